@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	LOGIN_HISTORY_DEFAULT_LIMIT = 50
+	LOGIN_HISTORY_MAX_LIMIT     = 500
+)
+
+type loginHistoryEntry struct {
+	Time    time.Time `json:"time"`
+	Ip      string    `json:"ip"`
+	Agent   string    `json:"agent"`
+	Success bool      `json:"success"`
+}
+
+type loginHistoryResponse struct {
+	Logins []loginHistoryEntry `json:"logins"`
+}
+
+// /account
+func (ah apiHandler) accountRoot(w http.ResponseWriter, r *http.Request) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	switch {
+	case head == "login_history" && r.URL.Path == "/" && r.Method == "GET":
+		return ah.accountLoginHistory(w, r)
+	case head == "change_password" && r.URL.Path == "/" && r.Method == "POST":
+		return ah.accountChangePassword(w, r)
+	case head == "export" && r.URL.Path == "/" && r.Method == "GET":
+		return ah.accountExport(w, r)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /account/login_history?limit=
+// The logins come from the audit log so they are kept for audit.retention_days. Failed logins include the attempts
+// with a wrong password made by anybody else with the id of the user
+func (ah apiHandler) accountLoginHistory(w http.ResponseWriter, r *http.Request) error {
+	limit, err := queryLimit(r, LOGIN_HISTORY_DEFAULT_LIMIT, LOGIN_HISTORY_MAX_LIMIT)
+	if err != nil {
+		return err
+	}
+	ctx := r.Context()
+	aes, err := models.FindAuditEntriesForActor(ctx, ctxGetUser(ctx).Id, []string{AUDIT_AUTH_LOGIN, AUDIT_AUTH_LOGIN_FAILED}, limit)
+	if err != nil {
+		return err
+	}
+	lhr := loginHistoryResponse{make([]loginHistoryEntry, len(aes))}
+	for i, ae := range aes {
+		lhr.Logins[i] = loginHistoryEntry{ae.CreatedAt, ae.Ip, ae.Agent, ae.Action == AUDIT_AUTH_LOGIN}
+	}
+	return jsonResponse(w, lhr)
+}
+
+type accountChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	Password    string `json:"password"`
+	KeyPack     []byte `json:"user_keys"`
+	Captcha     string `json:"captcha,omitempty"`
+}
+
+// POST /account/change_password
+// The key pack has the private keys of the user wrapped with the new password by the client. Every other session of
+// the user is closed. The old password goes through the login policy so a stolen session can't guess it
+func (ah apiHandler) accountChangePassword(w http.ResponseWriter, r *http.Request) error {
+	acpr := &accountChangePasswordRequest{}
+	if err := jsonDecode(w, r, 8192, acpr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	//The failed logins of the cached user can be stale
+	u, err := models.FindUser(ctx, ctxGetUser(ctx).Id)
+	if err != nil {
+		return err
+	}
+	if err := ah.checkLoginPassword(w, r, u, acpr.OldPassword, acpr.Captcha); util.CheckErr(err, models.ErrUnauthorized) {
+		errs := util.NewErrorFields().(*util.Error)
+		errs.SetFieldError("old_password", "invalid")
+		return errs.SetErrorOrCamo(models.ErrInvalidAttributes)
+	} else if err != nil {
+		return err
+	}
+	if err := u.ReplacePassword(ctx, acpr.OldPassword, acpr.Password, acpr.KeyPack); err != nil {
+		return err
+	}
+	ah.userChanged(u.Id)
+	ah.auditLog(r, AUDIT_USER_PASSWORD, auditObject("user", u.Id))
+	if err := ah.deleteOtherSessions(r); err != nil {
+		return err
+	}
+	if err := ah.mail.sendPasswordChangedMail(u, r.Header.Get("X-Locale")); err != nil {
+		return internalErr(err)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// deleteOtherSessions closes every session of the user but the one of the request
+func (ah apiHandler) deleteOtherSessions(r *http.Request) error {
+	sessions, err := ah.sm.GetAllSessions(ctxGetUser(r.Context()).Id)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		if s.Id == ctxGetSession(r.Context()).Id {
+			continue
+		}
+		if err := ah.sm.DeleteSession(s.Id); err != nil {
+			return err
+		}
+		ah.auditLog(r, AUDIT_SESSION_DELETE, auditObject("session", s.Id))
+	}
+	return nil
+}
+
+// ACCOUNT_EXPORT_FORMAT names the layout of the account export. It changes if fields are removed or change meaning
+const ACCOUNT_EXPORT_FORMAT = "keycat.account_export.v1"
+
+// accountExportSession is a session without its id, as the id is the token of the session
+type accountExportSession struct {
+	Agent        string    `json:"agent"`
+	RequiresCSRF bool      `json:"csrf_required"`
+	LastAccess   time.Time `json:"last_access"`
+	LastIp       string    `json:"last_ip"`
+	DeviceKey    []byte    `json:"device_key,omitempty"`
+	Current      bool      `json:"current"`
+}
+
+// accountExportResponse is the layout of the account export. The audit entries are streamed after everything else
+type accountExportResponse struct {
+	Format     string    `json:"format"`
+	ExportedAt time.Time `json:"exported_at"`
+	*models.UserExport
+	Sessions []accountExportSession `json:"sessions"`
+	Audit    []*models.AuditEntry   `json:"audit,omitempty"`
+}
+
+// GET /account/export
+// Everything the server keeps about the user for access requests. The audit entries are the ones done by the user or
+// about the user. Once the headers are sent errors can only be logged so a failed export is an invalid json document
+func (ah apiHandler) accountExport(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	ue, err := u.Export(ctx)
+	if err != nil {
+		return err
+	}
+	sessions, err := ah.sm.GetAllSessions(u.Id)
+	if err != nil {
+		return err
+	}
+	aer := accountExportResponse{Format: ACCOUNT_EXPORT_FORMAT, ExportedAt: time.Now().UTC(), UserExport: ue, Sessions: make([]accountExportSession, len(sessions))}
+	current := ctxGetSession(ctx)
+	for i, s := range sessions {
+		aer.Sessions[i] = accountExportSession{s.Agent, s.RequiresCSRF, s.LastAccess, s.LastIp, s.DeviceKey, s.Id == current.Id}
+	}
+	head, err := json.Marshal(aer)
+	if err != nil {
+		return util.NewErrorFrom(err)
+	}
+	ah.auditLog(r, AUDIT_USER_EXPORT, auditObject("user", u.Id))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"account-%s.json\"", u.Id))
+	w.WriteHeader(http.StatusOK)
+	//The audit entries are appended to the object instead of its closing brace
+	w.Write(head[:len(head)-1])
+	io.WriteString(w, `,"audit":[`)
+	sep := ""
+	err = models.ForEachAuditEntryAbout(ctx, u.Id, func(ae *models.AuditEntry) error {
+		b, err := json.Marshal(ae)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		sep = ","
+		_, err = w.Write(b)
+		return err
+	})
+	if err != nil {
+		requestLogf(r, "[ERROR] Could not export the audit entries of %s: %s", u.Id, err)
+		return nil
+	}
+	io.WriteString(w, "]}")
+	return nil
+}
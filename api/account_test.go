@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAccountLoginHistory(t *testing.T) {
+	u := getDummyUser()
+	activeSessionToken = ""
+	r, err := PostRequest("/auth/login", authRequest{Id: u.Id, Password: "wrong"})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/auth/login", authRequest{Id: u.Id, Password: u.Id})
+	CheckErrorAndResponse(t, r, err, 200)
+	alr := &authLoginResponse{}
+	if err := json.NewDecoder(r.Body).Decode(alr); err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = alr.Token
+	r, err = GetRequest("/account/login_history?limit=0")
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = GetRequest("/account/login_history")
+	CheckErrorAndResponse(t, r, err, 200)
+	lhr := &loginHistoryResponse{}
+	if err := json.NewDecoder(r.Body).Decode(lhr); err != nil {
+		t.Fatal(err)
+	}
+	if len(lhr.Logins) != 2 || !lhr.Logins[0].Success || lhr.Logins[1].Success || len(lhr.Logins[0].Ip) == 0 {
+		t.Fatalf("Unexpected login history %#v", lhr.Logins)
+	}
+	r, err = GetRequest("/account/login_history?limit=1")
+	CheckErrorAndResponse(t, r, err, 200)
+	lhr = &loginHistoryResponse{}
+	if err := json.NewDecoder(r.Body).Decode(lhr); err != nil {
+		t.Fatal(err)
+	}
+	if len(lhr.Logins) != 1 || !lhr.Logins[0].Success {
+		t.Fatalf("Unexpected login history %#v", lhr.Logins)
+	}
+}
+
+func TestAccountChangePassword(t *testing.T) {
+	u := getDummyUser()
+	other, err := apiH.sm.NewSession(u.Id, "1.1.1.1", "none", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := apiH.sm.NewSession(u.Id, "1.1.1.1", "none", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = current.Id
+	_, _, fullpack := generateNewKeys()
+	r, err := PostRequest("/account/change_password", accountChangePasswordRequest{OldPassword: "wrong", Password: "newpass", KeyPack: fullpack})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest("/account/change_password", accountChangePasswordRequest{OldPassword: u.Id, Password: "newpass", KeyPack: fullpack[1:]})
+	CheckErrorAndResponse(t, r, err, 400)
+	if _, err := apiH.sm.GetSession(other.Id); err != nil {
+		t.Fatalf("Failed changes shouldn't close the sessions: %s", err)
+	}
+	r, err = PostRequest("/account/change_password", accountChangePasswordRequest{OldPassword: u.Id, Password: "newpass", KeyPack: fullpack})
+	CheckErrorAndResponse(t, r, err, 200)
+	if _, err := apiH.sm.GetSession(other.Id); err == nil {
+		t.Fatal("The other sessions should have been closed")
+	}
+	if _, err := apiH.sm.GetSession(current.Id); err != nil {
+		t.Fatalf("The current session should be kept: %s", err)
+	}
+	activeSessionToken = ""
+	r, err = PostRequest("/auth/login", authRequest{Id: u.Id, Password: u.Id})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/auth/login", authRequest{Id: u.Id, Password: "newpass"})
+	CheckErrorAndResponse(t, r, err, 200)
+}
+
+func TestAccountExport(t *testing.T) {
+	u := getDummyUser()
+	activeSessionToken = ""
+	r, err := PostRequest("/auth/login", authRequest{Id: u.Id, Password: u.Id})
+	CheckErrorAndResponse(t, r, err, 200)
+	alr := &authLoginResponse{}
+	if err := json.NewDecoder(r.Body).Decode(alr); err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = alr.Token
+	r, err = GetRequest("/account/export")
+	CheckErrorAndResponse(t, r, err, 200)
+	aer := &accountExportResponse{}
+	if err := json.NewDecoder(r.Body).Decode(aer); err != nil {
+		t.Fatal(err)
+	}
+	if aer.Format != ACCOUNT_EXPORT_FORMAT || aer.Profile.Id != u.Id || len(aer.Keys) == 0 {
+		t.Fatalf("Unexpected export %#v", aer)
+	}
+	if len(aer.Teams) != 1 || !aer.Teams[0].Owner || !aer.Teams[0].Admin || len(aer.VaultKeys) == 0 {
+		t.Fatalf("Unexpected memberships %#v %#v", aer.Teams, aer.VaultKeys)
+	}
+	if len(aer.Sessions) != 1 || !aer.Sessions[0].Current {
+		t.Fatalf("Unexpected sessions %#v", aer.Sessions)
+	}
+	found := false
+	for _, ae := range aer.Audit {
+		found = found || (ae.Action == AUDIT_AUTH_LOGIN && ae.Actor == u.Id)
+	}
+	if !found {
+		t.Fatalf("The login is not in the exported audit entries %#v", aer.Audit)
+	}
+}
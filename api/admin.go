@@ -0,0 +1,306 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	adminDefaultPageSize = 50
+	adminMaxPageSize     = 500
+	adminStatsActiveDays = 30
+)
+
+// /admin
+func (ah apiHandler) adminRoot(w http.ResponseWriter, r *http.Request) error {
+	if !ctxGetUser(r.Context()).Admin {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	switch head {
+	case "user":
+		return ah.adminUserRoot(w, r)
+	case "maintenance":
+		return ah.adminMaintenanceRoot(w, r)
+	case "audit":
+		return ah.adminAuditRoot(w, r)
+	case "blocklist":
+		return ah.adminBlocklistRoot(w, r)
+	case "jobs":
+		return ah.adminJobsRoot(w, r)
+	case "features":
+		return ah.adminFeaturesRoot(w, r)
+	case "status":
+		if r.Method == "GET" {
+			return ah.adminStatus(w, r)
+		}
+	case "stats":
+		if r.Method == "GET" {
+			return ah.adminStats(w, r)
+		}
+	case "orphans":
+		if r.Method == "GET" {
+			return ah.adminOrphans(w, r)
+		}
+	case "retention":
+		if r.Method == "GET" {
+			return ah.adminRetention(w, r)
+		}
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+func queryBool(r *http.Request, key string) (*bool, error) {
+	val := r.URL.Query().Get(key)
+	if len(val) == 0 {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return nil, util.NewErrorf("Invalid value for %s", key)
+	}
+	return &b, nil
+}
+
+// queryPage reads the limit and offset of the listings that page in the db and report the total
+func queryPage(r *http.Request) (limit, offset int, err error) {
+	if limit, err = queryLimit(r, adminDefaultPageSize, adminMaxPageSize); err != nil {
+		return 0, 0, err
+	}
+	if val := r.URL.Query().Get("offset"); len(val) > 0 {
+		if offset, err = strconv.Atoi(val); err != nil || offset < 0 {
+			return 0, 0, util.NewErrorf("Invalid offset")
+		}
+	}
+	return limit, offset, nil
+}
+
+// /admin/user
+func (ah apiHandler) adminUserRoot(w http.ResponseWriter, r *http.Request) error {
+	var uid string
+	uid, r.URL.Path = shiftPath(r.URL.Path)
+	if len(uid) == 0 {
+		if r.Method == "GET" {
+			return ah.adminUserList(w, r)
+		}
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	u, err := models.FindUser(r.Context(), uid)
+	if err != nil {
+		return err
+	}
+	var action string
+	action, r.URL.Path = shiftPath(r.URL.Path)
+	switch {
+	case len(action) == 0 && r.Method == "GET":
+		return jsonResponse(w, u)
+	case len(action) == 0 && r.Method == "DELETE":
+		return ah.adminUserDelete(w, r, u)
+	case action == "session" && r.Method == "GET":
+		return ah.adminUserSessions(w, r, u)
+	case action == "disable" && r.Method == "POST":
+		return ah.adminUserSetDisabled(w, r, u, true)
+	case action == "enable" && r.Method == "POST":
+		return ah.adminUserSetDisabled(w, r, u, false)
+	case action == "reverify" && r.Method == "POST":
+		return ah.adminUserReverify(w, r, u)
+	case action == "force_password_reset" && r.Method == "POST":
+		return ah.adminUserForcePasswordReset(w, r, u)
+	case action == "login_state" && r.Method == "GET":
+		return jsonResponse(w, ah.opts().loginPolicy.evaluate(u, time.Now().UTC()))
+	case action == "login_state" && r.Method == "DELETE":
+		return ah.adminUserClearLoginState(w, r, u)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type adminUserListResponse struct {
+	Users  []*models.User `json:"users"`
+	Total  int            `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+}
+
+// GET /admin/user?q=&confirmed=&disabled=&admin=&limit=&offset=
+func (ah apiHandler) adminUserList(w http.ResponseWriter, r *http.Request) error {
+	var err error
+	uf := models.UserFilter{Query: r.URL.Query().Get("q")}
+	if uf.Confirmed, err = queryBool(r, "confirmed"); err != nil {
+		return err
+	}
+	if uf.Disabled, err = queryBool(r, "disabled"); err != nil {
+		return err
+	}
+	if uf.Admin, err = queryBool(r, "admin"); err != nil {
+		return err
+	}
+	limit, offset, err := queryPage(r)
+	if err != nil {
+		return err
+	}
+	users, total, err := models.FindUsers(r.Context(), uf, limit, offset)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, adminUserListResponse{users, total, limit, offset})
+}
+
+// GET /admin/user/:uid/session
+func (ah apiHandler) adminUserSessions(w http.ResponseWriter, r *http.Request, u *models.User) error {
+	return ah.sessionList(w, r, u.Id)
+}
+
+// POST /admin/user/:uid/disable and /admin/user/:uid/enable
+func (ah apiHandler) adminUserSetDisabled(w http.ResponseWriter, r *http.Request, u *models.User, disabled bool) error {
+	if disabled && u.Id == ctxGetUser(r.Context()).Id {
+		return util.NewErrorf("You cannot disable your own account")
+	}
+	if err := u.SetDisabled(r.Context(), disabled); err != nil {
+		return err
+	}
+	ah.userChanged(u.Id)
+	if disabled {
+		ah.auditLog(r, AUDIT_ADMIN_USER_DISABLE, auditObject("user", u.Id))
+	} else {
+		ah.auditLog(r, AUDIT_ADMIN_USER_ENABLE, auditObject("user", u.Id))
+	}
+	if disabled {
+		if err := ah.sm.DeleteAllSessions(u.Id); err != nil {
+			return err
+		}
+	}
+	return jsonResponse(w, u)
+}
+
+// POST /admin/user/:uid/reverify
+func (ah apiHandler) adminUserReverify(w http.ResponseWriter, r *http.Request, u *models.User) error {
+	t, err := u.ForceEmailVerification(r.Context())
+	if err != nil {
+		return err
+	}
+	ah.userChanged(u.Id)
+	ah.auditLog(r, AUDIT_ADMIN_USER_VERIFY, auditObject("user", u.Id))
+	if err := ah.mail.sendConfirmationMail(u, t, r.Header.Get("X-Locale")); err != nil {
+		return err
+	}
+	return jsonResponse(w, u)
+}
+
+// POST /admin/user/:uid/force_password_reset
+// For accounts that might have been compromised. Every session is closed and the user can't log in again until it
+// resets the password with POST /auth/reset_password
+func (ah apiHandler) adminUserForcePasswordReset(w http.ResponseWriter, r *http.Request, u *models.User) error {
+	if u.Id == ctxGetUser(r.Context()).Id {
+		return util.NewErrorf("You cannot force a password reset of your own account")
+	}
+	if err := u.ForcePasswordReset(r.Context()); err != nil {
+		return err
+	}
+	ah.userChanged(u.Id)
+	ah.auditLog(r, AUDIT_ADMIN_USER_RESET, auditObject("user", u.Id))
+	if err := ah.sm.DeleteAllSessions(u.Id); err != nil {
+		return err
+	}
+	return jsonResponse(w, u)
+}
+
+// DELETE /admin/user/:uid/login_state
+// GET /admin/user/:uid/login_state returns what the next login of the user faces
+func (ah apiHandler) adminUserClearLoginState(w http.ResponseWriter, r *http.Request, u *models.User) error {
+	if err := u.ClearFailedLogins(r.Context()); err != nil {
+		return err
+	}
+	ah.userChanged(u.Id)
+	ah.auditLog(r, AUDIT_ADMIN_LOGIN_CLEAR, auditObject("user", u.Id))
+	return jsonResponse(w, ah.opts().loginPolicy.evaluate(u, time.Now().UTC()))
+}
+
+// DELETE /admin/user/:uid
+func (ah apiHandler) adminUserDelete(w http.ResponseWriter, r *http.Request, u *models.User) error {
+	if u.Id == ctxGetUser(r.Context()).Id {
+		return util.NewErrorf("You cannot delete your own account")
+	}
+	dryRun, err := queryDryRun(r)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return ah.adminUserDeleteDryRun(w, r, u)
+	}
+	//The user is removed first so its sessions are kept if it can't be
+	if err := u.Delete(r.Context()); err != nil {
+		return err
+	}
+	if err := ah.sm.DeleteAllSessions(u.Id); err != nil {
+		return err
+	}
+	if err := ah.blobs.Delete(avatarBlobKey(u.Id)); err != nil && !util.CheckErr(err, models.ErrDoesntExist) {
+		requestLogf(r, "[ERROR] Could not remove the avatar of %s: %s", u.Id, err)
+	}
+	ah.userChanged(u.Id)
+	ah.auditLog(r, AUDIT_ADMIN_USER_DELETE, auditObject("user", u.Id))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// DELETE /admin/user/:uid?dry_run=true
+func (ah apiHandler) adminUserDeleteDryRun(w http.ResponseWriter, r *http.Request, u *models.User) error {
+	cr, err := u.DeleteWithReport(r.Context(), true)
+	if err != nil {
+		return err
+	}
+	sessions, err := ah.sm.GetAllSessions(u.Id)
+	if err != nil {
+		return err
+	}
+	//Session ids are the credentials of the sessions so they are only counted
+	cr.Add(models.AffectedRows{Kind: "session", Count: int64(len(sessions))})
+	return jsonResponse(w, cr)
+}
+
+type adminStatsUsers struct {
+	models.UserStats
+	Active int `json:"active"`
+}
+
+type adminStatsResponse struct {
+	Users       adminStatsUsers     `json:"users"`
+	Teams       int                 `json:"teams"`
+	Vaults      int                 `json:"vaults"`
+	Secrets     models.SecretStats  `json:"secrets"`
+	Storage     models.StorageStats `json:"storage"`
+	Sessions    int                 `json:"sessions"`
+	ActiveDays  int                 `json:"active_days"`
+	GeneratedAt time.Time           `json:"generated_at"`
+}
+
+// GET /admin/stats
+// Active users are the ones that have used a session in the last adminStatsActiveDays days
+func (ah apiHandler) adminStats(w http.ResponseWriter, r *http.Request) error {
+	now := time.Now().UTC()
+	is, err := models.GetInstanceStats(r.Context())
+	if err != nil {
+		return err
+	}
+	asr := adminStatsResponse{
+		Users:       adminStatsUsers{UserStats: is.Users},
+		Teams:       is.Teams,
+		Vaults:      is.Vaults,
+		Secrets:     is.Secrets,
+		Storage:     is.Storage,
+		ActiveDays:  adminStatsActiveDays,
+		GeneratedAt: now,
+	}
+	if asr.Users.Active, err = ah.sm.CountActiveUsers(now.AddDate(0, 0, -adminStatsActiveDays)); err != nil {
+		return err
+	}
+	if asr.Sessions, err = ah.sm.CountSessions(); err != nil {
+		return err
+	}
+	return jsonResponse(w, asr)
+}
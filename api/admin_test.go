@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestAdminUserManagement(t *testing.T) {
+	target := getDummyUser()
+	u := loginDummyUser()
+	r, err := GetRequest("/admin/user")
+	CheckErrorAndResponse(t, r, err, 401)
+	if err := u.SetAdmin(getCtx(), true); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest("/admin/user?limit=1&q=" + target.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	ul := &adminUserListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(ul); err != nil {
+		t.Fatal(err)
+	}
+	if ul.Total != 1 || len(ul.Users) != 1 || ul.Users[0].Id != target.Id {
+		t.Fatalf("Unexpected user list %#v", ul)
+	}
+	//Wildcards in the search only match themselves
+	r, err = GetRequest("/admin/user?q=%25")
+	CheckErrorAndResponse(t, r, err, 200)
+	ul = &adminUserListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(ul); err != nil {
+		t.Fatal(err)
+	}
+	if ul.Total != 0 {
+		t.Errorf("Expected %% to match no user and got %d", ul.Total)
+	}
+	if _, err := apiH.sm.NewSession(target.Id, "1.1.1.1", "none", false, nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err = PostRequest("/admin/user/"+target.Id+"/disable", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/admin/user/" + target.Id + "/session")
+	CheckErrorAndResponse(t, r, err, 200)
+	sr := &sessionListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(sr); err != nil {
+		t.Fatal(err)
+	}
+	if len(sr.Sessions) != 0 {
+		t.Errorf("Disabled users should not keep any session")
+	}
+	activeSessionToken = ""
+	r, err = PostRequest("/auth/login", authRequest{Id: target.Id, Password: target.Id})
+	CheckErrorAndResponse(t, r, err, 401)
+	loginDummyUser().SetAdmin(getCtx(), true)
+	r, err = PostRequest("/admin/user/"+target.Id+"/enable", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest("/admin/user/"+target.Id+"/reverify", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	ru := &models.User{}
+	if err := json.NewDecoder(r.Body).Decode(ru); err != nil {
+		t.Fatal(err)
+	}
+	if ru.ConfirmedAt.Valid {
+		t.Errorf("Expected the user to require email verification")
+	}
+	r, err = DeleteRequest("/admin/user/" + target.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/admin/user/" + target.Id)
+	CheckErrorAndResponse(t, r, err, 404)
+}
+
+func TestAdminForcePasswordReset(t *testing.T) {
+	target := getDummyUser()
+	u := loginDummyUser()
+	if err := u.SetAdmin(getCtx(), true); err != nil {
+		t.Fatal(err)
+	}
+	s, err := apiH.sm.NewSession(target.Id, "1.1.1.1", "none", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := PostRequest("/admin/user/"+u.Id+"/force_password_reset", nil)
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest("/admin/user/"+target.Id+"/force_password_reset", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	if _, err := apiH.sm.GetSession(s.Id); err == nil {
+		t.Fatal("The sessions of the user should have been closed")
+	}
+	activeSessionToken = ""
+	r, err = PostRequest("/auth/login", authRequest{Id: target.Id, Password: "wrong"})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/auth/login", authRequest{Id: target.Id, Password: target.Id})
+	CheckErrorAndResponse(t, r, err, 403)
+	_, _, fullpack := generateNewKeys()
+	r, err = PostRequest("/auth/reset_password", authResetPasswordRequest{Id: target.Id, OldPassword: "wrong", Password: "newpass", KeyPack: fullpack})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/auth/reset_password", authResetPasswordRequest{Id: target.Id, OldPassword: target.Id, Password: target.Id, KeyPack: fullpack})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest("/auth/reset_password", authResetPasswordRequest{Id: target.Id, OldPassword: target.Id, Password: "newpass", KeyPack: fullpack})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest("/auth/reset_password", authResetPasswordRequest{Id: target.Id, OldPassword: "newpass", Password: "another", KeyPack: fullpack})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/auth/login", authRequest{Id: target.Id, Password: "newpass"})
+	CheckErrorAndResponse(t, r, err, 200)
+}
+
+func TestAdminStats(t *testing.T) {
+	u := loginDummyUser()
+	r, err := GetRequest("/admin/stats")
+	CheckErrorAndResponse(t, r, err, 401)
+	if err := u.SetAdmin(getCtx(), true); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest("/admin/stats")
+	CheckErrorAndResponse(t, r, err, 200)
+	asr := &adminStatsResponse{}
+	if err := json.NewDecoder(r.Body).Decode(asr); err != nil {
+		t.Fatal(err)
+	}
+	if asr.Users.Total < 1 || asr.Users.Active < 1 || asr.Users.Active > asr.Users.Total || asr.Teams < 1 || asr.Sessions < 1 || asr.Storage.DBBytes <= 0 {
+		t.Errorf("Unexpected stats %#v", asr)
+	}
+	if asr.Secrets.Total > asr.Secrets.Versions {
+		t.Errorf("There can't be more secrets than secret versions: %#v", asr.Secrets)
+	}
+}
+
+func TestAdminUserDeleteKeepsSharedTeams(t *testing.T) {
+	ctx := getCtx()
+	owner := getDummyUser()
+	member := getDummyUser()
+	teams, err := owner.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := teams[0].AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	if err := loginDummyUser().SetAdmin(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	//Deleting the owner would delete the team of the member as well
+	r, err := DeleteRequest("/admin/user/" + owner.Id)
+	CheckErrorAndResponse(t, r, err, 409)
+	r, err = DeleteRequest("/admin/user/" + owner.Id + "?dry_run=true")
+	CheckErrorAndResponse(t, r, err, 409)
+	if _, err := models.FindUser(ctx, owner.Id); err != nil {
+		t.Fatalf("The owner has been deleted: %s", err)
+	}
+}
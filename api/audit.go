@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	AUDIT_AUTH_REGISTER         = "auth.register"
+	AUDIT_AUTH_CONFIRM_EMAIL    = "auth.confirm_email"
+	AUDIT_AUTH_LOGIN            = "auth.login"
+	AUDIT_AUTH_LOGIN_FAILED     = "auth.login_failed"
+	AUDIT_SESSION_DELETE        = "session.delete"
+	AUDIT_SESSION_PAIR          = "session.pair"
+	AUDIT_DEVICE_REVOKE         = "device.revoke"
+	AUDIT_USER_EMAIL_CHANGE     = "user.email_change"
+	AUDIT_USER_PASSWORD         = "user.password_change"
+	AUDIT_USER_PROFILE          = "user.profile_change"
+	AUDIT_USER_AVATAR           = "user.avatar_change"
+	AUDIT_USER_EXPORT           = "user.export"
+	AUDIT_TEAM_CREATE           = "team.create"
+	AUDIT_TEAM_INVITE           = "team.invite"
+	AUDIT_TEAM_USER_PROMOTE     = "team.user_promote"
+	AUDIT_TEAM_USER_DEMOTE      = "team.user_demote"
+	AUDIT_TEAM_SESSION_POLICY   = "team.session_policy"
+	AUDIT_TEAM_AUDITOR_ADD      = "team.auditor_add"
+	AUDIT_TEAM_AUDITOR_REMOVE   = "team.auditor_remove"
+	AUDIT_TEAM_PENDING_COMPLETE = "team.pending_complete"
+	AUDIT_TEAM_PENDING_DELETE   = "team.pending_delete"
+	AUDIT_VAULT_CREATE          = "vault.create"
+	AUDIT_VAULT_USER_ADD        = "vault.user_add"
+	AUDIT_VAULT_USER_REMOVE     = "vault.user_remove"
+	AUDIT_VAULT_APPROVAL_ON     = "vault.approval_on"
+	AUDIT_VAULT_APPROVAL_OFF    = "vault.approval_off"
+	AUDIT_VAULT_GRANT_REQUEST   = "vault.grant_request"
+	AUDIT_VAULT_GRANT_APPROVE   = "vault.grant_approve"
+	AUDIT_VAULT_GRANT_REJECT    = "vault.grant_reject"
+	AUDIT_VAULT_USER_EXPIRE     = "vault.user_expire"
+	AUDIT_VAULT_ROTATED         = "vault.rotated"
+	AUDIT_VAULT_TEMPLATE_CREATE = "vault.template_create"
+	AUDIT_VAULT_TEMPLATE_UPDATE = "vault.template_update"
+	AUDIT_VAULT_TEMPLATE_DELETE = "vault.template_delete"
+	AUDIT_VAULT_PINS            = "vault.pins"
+	AUDIT_MACHINE_TOKEN_CREATE  = "machine_token.create"
+	AUDIT_MACHINE_TOKEN_DELETE  = "machine_token.delete"
+	AUDIT_SECRET_CREATE         = "secret.create"
+	AUDIT_SECRET_UPDATE         = "secret.update"
+	AUDIT_SECRET_MOVE           = "secret.move"
+	AUDIT_SECRET_DELETE         = "secret.delete"
+	AUDIT_SECRET_LABELS         = "secret.labels"
+	AUDIT_SECRET_ARCHIVE        = "secret.archive"
+	AUDIT_SECRET_UNARCHIVE      = "secret.unarchive"
+	AUDIT_LABEL_CREATE          = "label.create"
+	AUDIT_LABEL_UPDATE          = "label.update"
+	AUDIT_LABEL_DELETE          = "label.delete"
+	AUDIT_WEBHOOK_CREATE        = "webhook.create"
+	AUDIT_WEBHOOK_DELETE        = "webhook.delete"
+	AUDIT_WEBHOOK_REDELIVER     = "webhook.redeliver"
+	AUDIT_MATRIX_SET            = "matrix.set"
+	AUDIT_MATRIX_DELETE         = "matrix.delete"
+	AUDIT_ADMIN_USER_DISABLE    = "admin.user_disable"
+	AUDIT_ADMIN_USER_ENABLE     = "admin.user_enable"
+	AUDIT_ADMIN_USER_VERIFY     = "admin.user_reverify"
+	AUDIT_ADMIN_USER_DELETE     = "admin.user_delete"
+	AUDIT_ADMIN_USER_RESET      = "admin.user_force_password_reset"
+	AUDIT_ADMIN_LOGIN_CLEAR     = "admin.login_state_clear"
+	AUDIT_ADMIN_AUDIT_EXPORT    = "admin.audit_export"
+	AUDIT_ADMIN_MAINTENANCE_ON  = "admin.maintenance_on"
+	AUDIT_ADMIN_MAINTENANCE_OFF = "admin.maintenance_off"
+	AUDIT_ADMIN_BLOCKLIST_ADD   = "admin.blocklist_add"
+	AUDIT_ADMIN_BLOCKLIST_DEL   = "admin.blocklist_delete"
+	AUDIT_BLOCKLIST_AUTO        = "blocklist.auto"
+	AUDIT_IDP_USER_DISABLE      = "idp.user_disable"
+	AUDIT_OIDC_AUTHORIZE        = "oidc.authorize"
+	AUDIT_ADMIN_JOB_RUN         = "admin.job_run"
+	AUDIT_ADMIN_FEATURE_SET     = "admin.feature_set"
+	AUDIT_ADMIN_FEATURE_DEL     = "admin.feature_delete"
+)
+
+func auditObject(parts ...string) string {
+	obj := ""
+	for i := 0; i+1 < len(parts); i += 2 {
+		if len(obj) > 0 {
+			obj += "/"
+		}
+		obj += parts[i] + ":" + parts[i+1]
+	}
+	return obj
+}
+
+// auditLog records the action done by the user in the request context
+func (ah apiHandler) auditLog(r *http.Request, action, object string) {
+	actor := ""
+	if u, ok := r.Context().Value(contextUserKey).(*models.User); ok {
+		actor = u.Id
+	}
+	ah.auditLogAs(r, actor, action, object)
+}
+
+// auditLogAs records an action for requests where the actor is not authenticated yet
+func (ah apiHandler) auditLogAs(r *http.Request, actor, action, object string) {
+	ae := &models.AuditEntry{
+		Actor:     actor,
+		Action:    action,
+		Object:    object,
+		Ip:        ah.clientIP(r),
+		Agent:     r.UserAgent(),
+		RequestId: ctxGetRequestId(r.Context()),
+	}
+	if err := ah.audit.Record(r.Context(), ae); err != nil {
+		requestLogf(r, "[ERROR] Could not record audit entry %s for %s: %s", action, object, err)
+	}
+}
+
+// /admin/audit
+func (ah apiHandler) adminAuditRoot(w http.ResponseWriter, r *http.Request) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if head == "export" && r.Method == "GET" {
+		return ah.adminAuditExport(w, r)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+func queryTime(r *http.Request, key string, def time.Time) (time.Time, error) {
+	val := r.URL.Query().Get(key)
+	if len(val) == 0 {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return t, util.NewErrorf("Invalid value for %s. It has to be RFC3339", key)
+	}
+	return t.UTC(), nil
+}
+
+var auditCSVHeader = []string{"id", "created_at", "actor", "action", "object", "ip", "agent", "request_id", "seq", "hash"}
+
+var auditListSpec = listSpec{
+	id:          func(i interface{}) string { return i.(*models.AuditEntry).Id },
+	defaultSort: "created_at",
+	sorts: map[string]listField{
+		"created_at": func(i interface{}) string { return listTime(i.(*models.AuditEntry).CreatedAt) },
+	},
+	filters: map[string]listField{
+		"actor":  func(i interface{}) string { return i.(*models.AuditEntry).Actor },
+		"action": func(i interface{}) string { return i.(*models.AuditEntry).Action },
+		"object": func(i interface{}) string { return i.(*models.AuditEntry).Object },
+		"ip":     func(i interface{}) string { return i.(*models.AuditEntry).Ip },
+	},
+}
+
+// GET /admin/audit/export?from=&to=&format=ndjson|csv&actor=&action=
+// Pages are at most listMaxLimit entries so they are collected to send the cursor of the next one in X-Next-Cursor
+func (ah apiHandler) adminAuditExport(w http.ResponseWriter, r *http.Request) error {
+	lq, err := parseListQuery(r, auditListSpec)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	from, err := queryTime(r, "from", now.Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+	to, err := queryTime(r, "to", now)
+	if err != nil {
+		return err
+	}
+	if !from.Before(to) {
+		return util.NewErrorf("from has to be before to")
+	}
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = "ndjson"
+	}
+	var write func(*models.AuditEntry) error
+	var flush func() error
+	switch format {
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		write = func(ae *models.AuditEntry) error { return enc.Encode(ae) }
+		flush = func() error { return nil }
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(auditCSVHeader)
+		write = func(ae *models.AuditEntry) error {
+			return cw.Write([]string{ae.Id, ae.CreatedAt.Format(time.RFC3339Nano), ae.Actor, ae.Action, ae.Object, ae.Ip, ae.Agent, ae.RequestId, strconv.FormatInt(ae.Seq, 10), ae.Hash})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return util.NewErrorf("Invalid format %s", format)
+	}
+	ah.auditLog(r, AUDIT_ADMIN_AUDIT_EXPORT, fmt.Sprintf("%s/%s", from.Format(time.RFC3339), to.Format(time.RFC3339)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"audit-%s.%s\"", from.Format("20060102T150405"), format))
+	if lq.paged {
+		lp := lq.newPage()
+		if err := models.ForEachAuditEntry(r.Context(), from, to, func(ae *models.AuditEntry) error {
+			lp.add(ae)
+			return nil
+		}); err != nil {
+			return err
+		}
+		items, next := lp.result()
+		if len(next) > 0 {
+			w.Header().Set("X-Next-Cursor", next)
+		}
+		w.WriteHeader(http.StatusOK)
+		for _, item := range items {
+			if err = write(item.(*models.AuditEntry)); err != nil {
+				break
+			}
+		}
+		if ferr := flush(); err == nil {
+			err = ferr
+		}
+		if err != nil {
+			requestLogf(r, "[ERROR] Could not export audit entries: %s", err)
+		}
+		return nil
+	}
+	w.WriteHeader(http.StatusOK)
+	//From here on the headers are sent so errors can only be logged
+	err = models.ForEachAuditEntry(r.Context(), from, to, func(ae *models.AuditEntry) error {
+		if !lq.matches(ae) {
+			return nil
+		}
+		return write(ae)
+	})
+	if ferr := flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		requestLogf(r, "[ERROR] Could not export audit entries: %s", err)
+	}
+	return nil
+}
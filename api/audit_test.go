@@ -0,0 +1,47 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestAuditExport(t *testing.T) {
+	from := time.Now().UTC().Add(-time.Minute).Format(time.RFC3339)
+	u := loginDummyUser()
+	if err := u.SetAdmin(getCtx(), true); err != nil {
+		t.Fatal(err)
+	}
+	r, err := GetRequest("/admin/audit/export?format=xml")
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = GetRequest("/admin/audit/export?from=" + url.QueryEscape(from))
+	CheckErrorAndResponse(t, r, err, 200)
+	found := false
+	sc := bufio.NewScanner(r.Body)
+	for sc.Scan() {
+		ae := &models.AuditEntry{}
+		if err := json.Unmarshal(sc.Bytes(), ae); err != nil {
+			t.Fatal(err)
+		}
+		if ae.Action == AUDIT_AUTH_LOGIN && ae.Actor == u.Id && len(ae.RequestId) > 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Could not find the login of %s in the audit log", u.Id)
+	}
+	r, err = GetRequest("/admin/audit/export?format=csv&from=" + url.QueryEscape(from))
+	CheckErrorAndResponse(t, r, err, 200)
+	records, err := csv.NewReader(r.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) < 2 || records[0][0] != "id" {
+		t.Errorf("Unexpected csv export %v", records)
+	}
+}
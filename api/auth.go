@@ -1,14 +1,14 @@
 package api
 
 import (
-	"fmt"
+	"crypto/ed25519"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/keydotcat/keycatd/managers"
 	"github.com/keydotcat/keycatd/models"
 	"github.com/keydotcat/keycatd/util"
-	"github.com/tomasen/realip"
 )
 
 func (ah apiHandler) getSessionFromHeader(r *http.Request) *managers.Session {
@@ -16,10 +16,22 @@ func (ah apiHandler) getSessionFromHeader(r *http.Request) *managers.Session {
 	if len(authHdr) < 2 || authHdr[0] != "Bearer" {
 		return nil
 	}
-	s, err := ah.sm.UpdateSession(authHdr[1], realip.FromRequest(r), r.UserAgent())
-	if err != nil {
+	ip, agent := ah.clientIP(r), r.UserAgent()
+	//The proof is checked before touching the session so a copied token can't even update its last access
+	s, err := ah.sm.GetSession(authHdr[1])
+	if err != nil || !ah.checkSessionProof(r, s) {
 		return nil
 	}
+	//Only store the last access when it's old enough or something changed so polling clients don't write on every request
+	if ah.sessionWrites > 0 && time.Since(s.LastAccess) < ah.sessionWrites && s.LastIp == ip && s.Agent == agent {
+		return s
+	}
+	if s, err = ah.sm.UpdateSession(authHdr[1], ip, agent); err != nil {
+		return nil
+	}
+	if err := models.TouchUserActivity(r.Context(), s.User, s.LastAccess); err != nil {
+		requestLogf(r, "[ERROR] Could not record the activity of %s: %s", s.User, err)
+	}
 	return s
 }
 
@@ -37,13 +49,22 @@ func (ah apiHandler) authorizeRequest(w http.ResponseWriter, r *http.Request) *h
 			r = r.WithContext(ctxAddCsrf(r.Context(), csrfToken))
 		}
 	}
-	u, err := models.FindUser(r.Context(), s.User)
-	if util.CheckErr(err, models.ErrDoesntExist) {
+	var u *models.User
+	var err error
+	if r.Method == "GET" {
+		u, err = ah.users.get(r.Context(), s.User)
+	} else {
+		//Requests that can change the user always load it and drop the cached one
+		ah.users.forget(s.User)
+		u, err = models.FindUser(r.Context(), s.User)
+	}
+	if util.CheckErr(err, models.ErrDoesntExist) || (err == nil && (u.IsDisabled() || u.MustResetPassword)) {
 		http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
 		//ah.sm.DeleteAllSessions(u.Id)
 		return nil
 	} else if err != nil {
-		panic(err)
+		httpErr(w, r, internalErr(err))
+		return nil
 	}
 	return r.WithContext(ctxAddUser(ctxAddSession(r.Context(), s), u))
 }
@@ -56,6 +77,18 @@ type authRegisterRequest struct {
 	KeyPack        []byte `json:"user_keys"`
 	VaultPublicKey []byte `json:"vault_public_keys"`
 	VaultKey       []byte `json:"vault_keys"`
+	Locale         string `json:"locale"`
+	Timezone       string `json:"timezone"`
+}
+
+// authRegisterInvite is an invite accepted by the registration with what the inviter proposed
+type authRegisterInvite struct {
+	Team string `json:"team"`
+	*models.Invite
+}
+
+type authRegisterResponse struct {
+	Invites []authRegisterInvite `json:"invites"`
 }
 
 func (ah apiHandler) authRoot(w http.ResponseWriter, r *http.Request) error {
@@ -72,6 +105,16 @@ func (ah apiHandler) authRoot(w http.ResponseWriter, r *http.Request) error {
 		return ah.authLogin(w, r)
 	case "session":
 		return ah.authGetSession(w, r)
+	case "pairing":
+		return ah.authPairing(w, r)
+	case "reset_password":
+		if r.URL.Path == "/" && r.Method == "POST" {
+			return ah.authResetPassword(w, r)
+		}
+	case "availability":
+		if r.URL.Path == "/" && r.Method == "GET" {
+			return ah.authAvailability(w, r)
+		}
 	}
 	return util.NewErrorFrom(ErrNotFound)
 }
@@ -83,15 +126,31 @@ func (ah apiHandler) authRegister(w http.ResponseWriter, r *http.Request) error
 		return err
 	}
 	ctx := r.Context()
-	if ah.options.onlyInvited {
-		invs, err := models.FindInvitesForEmail(ctx, apr.Email)
-		if err != nil {
-			return err
+	invs, err := models.FindInvitesForEmail(ctx, apr.Email)
+	if err != nil {
+		return err
+	}
+	accepted := []authRegisterInvite{}
+	for _, inv := range invs {
+		if !inv.Expired() {
+			accepted = append(accepted, authRegisterInvite{inv.Team, inv})
 		}
+	}
+	if ah.opts().onlyInvited {
 		if len(invs) == 0 {
 			return util.NewErrorFrom(models.ErrUnauthorized)
+		} else if len(accepted) == 0 {
+			return util.NewErrorFrom(models.ErrExpired)
 		}
 	}
+	//The locale of the request is kept unless the client picked another one
+	locale := apr.Locale
+	if len(locale) == 0 && models.ValidLocale(r.Header.Get("X-Locale")) {
+		locale = r.Header.Get("X-Locale")
+	}
+	if err := models.CheckLocale(locale, apr.Timezone); err != nil {
+		return err
+	}
 	u, t, err := models.NewUser(
 		ctx,
 		apr.Username,
@@ -107,11 +166,16 @@ func (ah apiHandler) authRegister(w http.ResponseWriter, r *http.Request) error
 	if err != nil {
 		return err
 	}
+	if len(locale) > 0 || len(apr.Timezone) > 0 {
+		if err := u.SetLocale(ctx, &locale, &apr.Timezone); err != nil {
+			return err
+		}
+	}
+	ah.auditLogAs(r, u.Id, AUDIT_AUTH_REGISTER, auditObject("user", u.Id))
 	if err := ah.mail.sendConfirmationMail(u, t, r.Header.Get("X-Locale")); err != nil {
-		panic(err)
+		return internalErr(err)
 	}
-	w.WriteHeader(http.StatusOK)
-	return nil
+	return jsonResponse(w, authRegisterResponse{accepted})
 }
 
 // /auth/confirm_email/:token
@@ -125,9 +189,12 @@ func (ah apiHandler) authConfirmEmail(w http.ResponseWriter, r *http.Request) er
 		return err
 	}
 	u, err := tok.ConfirmEmail(r.Context())
-	if err != nil {
+	if util.CheckErr(err, models.ErrAlreadyUsed) {
+		return err
+	} else if err != nil {
 		return util.NewErrorFrom(models.ErrDoesntExist)
 	}
+	ah.auditLogAs(r, u.Id, AUDIT_AUTH_CONFIRM_EMAIL, auditObject("user", u.Id))
 	return jsonResponse(w, u)
 }
 
@@ -136,6 +203,10 @@ type authRequest struct {
 	Password    string `json:"password"`
 	RequireCSRF bool   `json:"want_csrf"`
 	Email       string `json:"email"`
+	//DeviceKey binds the new session to the ed25519 key of the device
+	DeviceKey []byte `json:"device_key,omitempty"`
+	//Captcha is only needed once the login policy asks for it
+	Captcha string `json:"captcha,omitempty"`
 }
 
 // /auth/request_confirmation_token
@@ -156,9 +227,8 @@ func (ah apiHandler) authRequestConfirmationToken(w http.ResponseWriter, r *http
 	if err != nil {
 		return err
 	}
-	fmt.Println("Token is", t)
 	if err := ah.mail.sendConfirmationMail(u, t, r.Header.Get("X-Locale")); err != nil {
-		panic(err)
+		return internalErr(err)
 	}
 	w.WriteHeader(http.StatusOK)
 	return nil
@@ -175,26 +245,50 @@ type authLoginResponse struct {
 }
 
 // /auth/login
-func (ah apiHandler) authLogin(w http.ResponseWriter, r *http.Request) error {
+func (ah apiHandler) authLogin(w http.ResponseWriter, r *http.Request) (err error) {
 	aer := &authRequest{}
 	if err := jsonDecode(w, r, 1024, aer); err != nil {
 		return err
 	}
+	defer func() {
+		ah.metrics.observeLogin(err == nil)
+		if err == nil {
+			ah.auditLogAs(r, aer.Id, AUDIT_AUTH_LOGIN, auditObject("user", aer.Id))
+		} else {
+			ah.auditLogAs(r, aer.Id, AUDIT_AUTH_LOGIN_FAILED, auditObject("user", aer.Id))
+		}
+	}()
 	u, err := models.FindUser(r.Context(), aer.Id)
 	if util.CheckErr(err, models.ErrDoesntExist) {
 		return util.NewErrorFrom(models.ErrUnauthorized)
 	} else if err != nil {
 		return err
 	}
-	if !u.ConfirmedAt.Valid {
+	if !u.ConfirmedAt.Valid || u.IsDisabled() {
 		return util.NewErrorFrom(models.ErrUnauthorized)
 	}
-	if err := u.CheckPassword(aer.Password); err != nil {
-		return util.NewErrorFrom(models.ErrUnauthorized)
+	if err := ah.checkLoginPassword(w, r, u, aer.Password, aer.Captcha); err != nil {
+		return err
+	}
+	//Only told once the password is right so it doesn't give away which accounts have been flagged
+	if u.MustResetPassword {
+		return util.NewErrorFrom(models.ErrMustResetPassword)
 	}
-	s, err := ah.sm.NewSession(u.Id, realip.FromRequest(r), r.UserAgent(), aer.RequireCSRF)
+	if len(aer.DeviceKey) > 0 {
+		if len(aer.DeviceKey) != ed25519.PublicKeySize {
+			return util.NewErrorf("Invalid device key")
+		}
+		revoked, err := models.IsDeviceKeyRevoked(r.Context(), aer.DeviceKey)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return util.NewErrorFrom(models.ErrRevokedKey)
+		}
+	}
+	s, err := ah.sm.NewSession(u.Id, ah.clientIP(r), r.UserAgent(), aer.RequireCSRF, aer.DeviceKey)
 	if err != nil {
-		panic(err)
+		return internalErr(err)
 	}
 	return jsonResponse(w, authLoginResponse{
 		u.Id,
@@ -207,6 +301,49 @@ func (ah apiHandler) authLogin(w http.ResponseWriter, r *http.Request) error {
 	})
 }
 
+type authResetPasswordRequest struct {
+	Id          string `json:"id"`
+	OldPassword string `json:"old_password"`
+	Password    string `json:"password"`
+	KeyPack     []byte `json:"user_keys"`
+	Captcha     string `json:"captcha,omitempty"`
+}
+
+// /auth/reset_password
+// Only for the users an admin has forced to reset the password. They log in as usual afterwards
+func (ah apiHandler) authResetPassword(w http.ResponseWriter, r *http.Request) error {
+	arpr := &authResetPasswordRequest{}
+	if err := jsonDecode(w, r, 8192, arpr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u, err := models.FindUser(ctx, arpr.Id)
+	if util.CheckErr(err, models.ErrDoesntExist) {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	} else if err != nil {
+		return err
+	}
+	if u.IsDisabled() {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	if err := ah.checkLoginPassword(w, r, u, arpr.OldPassword, arpr.Captcha); err != nil {
+		return err
+	}
+	if err := u.ResetPassword(ctx, arpr.OldPassword, arpr.Password, arpr.KeyPack); err != nil {
+		return err
+	}
+	ah.userChanged(u.Id)
+	ah.auditLogAs(r, u.Id, AUDIT_USER_PASSWORD, auditObject("user", u.Id))
+	if err := ah.sm.DeleteAllSessions(u.Id); err != nil {
+		return err
+	}
+	if err := ah.mail.sendPasswordChangedMail(u, r.Header.Get("X-Locale")); err != nil {
+		return internalErr(err)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
 type authGetSessionResponse struct {
 	*managers.Session
 	Csrf       string `json:"csrf,omitempty"`
@@ -10,7 +10,7 @@ import (
 
 func loginDummyUser() *models.User {
 	u := getDummyUser()
-	s, err := apiH.sm.NewSession(u.Id, "1.1.1.1", "none", true)
+	s, err := apiH.sm.NewSession(u.Id, "1.1.1.1", "none", true, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -31,9 +31,14 @@ func TestRegister(t *testing.T) {
 		fullpack,
 		vkp.PublicKey,
 		vkp.Keys[uid],
+		"pt-BR",
+		"America/Sao_Paulo",
 	}
 	r, err := PostRequest("/auth/register", arp)
 	CheckErrorAndResponse(t, r, err, 200)
+	if u, err := models.FindUser(getCtx(), arp.Username); err != nil || u.Locale != arp.Locale || u.Timezone != arp.Timezone {
+		t.Fatalf("Expected the locale and the timezone to be stored: %v %s", u, err)
+	}
 	ar := authRequest{Id: arp.Username, Password: arp.Password, RequireCSRF: true}
 	r, err = PostRequest("/auth/login", ar)
 	CheckErrorAndResponse(t, r, err, 401)
@@ -42,6 +47,20 @@ func TestRegister(t *testing.T) {
 		t.Fatalf("Expected to find one token and found %d", len(tokens))
 	}
 	r, err = GetRequest("/auth/confirm_email/" + tokens[0].Id)
+	CheckErrorAndResponse(t, r, err, 404)
+	//Only the hash of the mailed token is stored so a new one has to be issued to get its secret
+	ru, err := models.FindUser(getCtx(), arp.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := ru.GetVerificationToken(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens = models.FindTokensForUser(getCtx(), arp.Username); len(tokens) != 1 || tokens[0].Id != tok.Id {
+		t.Fatalf("Expected the new token to replace the previous one and got %#v", tokens)
+	}
+	r, err = GetRequest("/auth/confirm_email/" + tok.Secret)
 	CheckErrorAndResponse(t, r, err, 200)
 	u := &models.User{}
 	if err := json.NewDecoder(r.Body).Decode(u); err != nil {
@@ -50,6 +69,8 @@ func TestRegister(t *testing.T) {
 	if u.Id != arp.Username {
 		t.Fatalf("Mismatch in the user id!: %s vs %s", arp.Username, u.Id)
 	}
+	r, err = GetRequest("/auth/confirm_email/" + tok.Secret)
+	CheckErrorAndResponse(t, r, err, 410)
 }
 
 func TestLogin(t *testing.T) {
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+// availabilityRateLimits always apply to the availability checks on top of the configured rules so they can't be used
+// to list the registered users
+var availabilityRateLimits = []ConfRateLimitRule{
+	{Route: "/api/auth/availability", Method: "GET", By: RATE_LIMIT_BY_IP, Limit: 30, Window: 60},
+}
+
+type authAvailabilityField struct {
+	Valid     bool `json:"valid"`
+	Available bool `json:"available"`
+}
+
+type authAvailabilityResponse struct {
+	Username      *authAvailabilityField `json:"username,omitempty"`
+	Email         *authAvailabilityField `json:"email,omitempty"`
+	UsernameRules models.UsernameRules   `json:"username_rules"`
+}
+
+// GET /auth/availability?username=&email=
+// Both are optional. The availability is only checked for valid values
+func (ah apiHandler) authAvailability(w http.ResponseWriter, r *http.Request) error {
+	if ah.rateLimitBlockRules(w, r, "/api/auth/availability", map[string]string{RATE_LIMIT_BY_IP: ah.clientIP(r)}, availabilityRateLimits) {
+		return nil
+	}
+	ctx := r.Context()
+	q := r.URL.Query()
+	aar := authAvailabilityResponse{UsernameRules: models.GetUsernameRules()}
+	if username := q.Get("username"); len(username) > 0 {
+		aar.Username = &authAvailabilityField{Valid: models.ValidNewUsername(username)}
+		if aar.Username.Valid {
+			available, err := models.UsernameAvailable(ctx, username)
+			if err != nil {
+				return err
+			}
+			aar.Username.Available = available
+		}
+	}
+	if email := q.Get("email"); len(email) > 0 {
+		aar.Email = &authAvailabilityField{Valid: models.ValidEmail(email)}
+		if aar.Email.Valid {
+			available, err := models.EmailAvailable(ctx, email)
+			if err != nil {
+				return err
+			}
+			aar.Email.Available = available
+		}
+	}
+	return jsonResponse(w, aar)
+}
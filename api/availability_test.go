@@ -0,0 +1,37 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAuthAvailability(t *testing.T) {
+	u := getDummyUser()
+	activeSessionToken = ""
+	check := func(query string) *authAvailabilityResponse {
+		r, err := GetRequest("/auth/availability?" + query)
+		CheckErrorAndResponse(t, r, err, 200)
+		aar := &authAvailabilityResponse{}
+		if err := json.NewDecoder(r.Body).Decode(aar); err != nil {
+			t.Fatal(err)
+		}
+		return aar
+	}
+	aar := check("username=" + u.Id + "&email=" + u.Email)
+	if !aar.Username.Valid || aar.Username.Available || !aar.Email.Valid || aar.Email.Available {
+		t.Fatalf("Registered user reported as available: %#v %#v", aar.Username, aar.Email)
+	}
+	aar = check("username=a!&email=nope")
+	if aar.Username.Valid || aar.Username.Available || aar.Email.Valid || aar.Email.Available {
+		t.Fatalf("Invalid values reported as valid: %#v %#v", aar.Username, aar.Email)
+	}
+	aar = check("username=free" + u.Id)
+	if !aar.Username.Valid || !aar.Username.Available || aar.Email != nil || aar.UsernameRules.MinLength != 3 {
+		t.Fatalf("Unexpected availability %#v", aar)
+	}
+	for i := 3; i < availabilityRateLimits[0].Limit; i++ {
+		check("username=" + u.Id)
+	}
+	r, err := GetRequest("/auth/availability?username=" + u.Id)
+	CheckErrorAndResponse(t, r, err, 429)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/keydotcat/keycatd/managers"
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const AVATAR_MAX_SIZE = 256 * 1024
+
+// avatarContentTypes are the images accepted as avatars. The type is sniffed from the data, never taken from the client
+var avatarContentTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true}
+
+func avatarBlobKey(uid string) string {
+	return "avatar/" + uid
+}
+
+type userProfileRequest struct {
+	Fullname *string `json:"fullname"`
+	Pronouns *string `json:"pronouns"`
+	Title    *string `json:"title"`
+	Locale   *string `json:"locale"`
+	Timezone *string `json:"timezone"`
+}
+
+// PUT /user/profile
+func (ah apiHandler) userUpdateProfile(w http.ResponseWriter, r *http.Request) error {
+	upr := &userProfileRequest{}
+	if err := jsonDecode(w, r, 1024, upr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	//Checked first so nothing is changed if they are invalid
+	if err := models.CheckLocale(stringOrEmpty(upr.Locale), stringOrEmpty(upr.Timezone)); err != nil {
+		return err
+	}
+	if err := u.UpdateProfile(ctx, upr.Fullname, upr.Pronouns, upr.Title); err != nil {
+		return err
+	}
+	if upr.Locale != nil || upr.Timezone != nil {
+		if err := u.SetLocale(ctx, upr.Locale, upr.Timezone); err != nil {
+			return err
+		}
+	}
+	ah.userChanged(u.Id)
+	ah.auditLog(r, AUDIT_USER_PROFILE, auditObject("user", u.Id))
+	return jsonResponse(w, u)
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// /user/avatar
+func (ah apiHandler) userAvatarRoot(w http.ResponseWriter, r *http.Request) error {
+	var uid string
+	uid, r.URL.Path = shiftPath(r.URL.Path)
+	if r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	switch {
+	case len(uid) == 0 && r.Method == "PUT":
+		return ah.userAvatarUpload(w, r)
+	case len(uid) == 0 && r.Method == "DELETE":
+		return ah.userAvatarDelete(w, r)
+	case len(uid) > 0 && r.Method == "GET":
+		return ah.userAvatarGet(w, r, uid)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// PUT /user/avatar
+// The body is the image itself
+func (ah apiHandler) userAvatarUpload(w http.ResponseWriter, r *http.Request) error {
+	max := int64(AVATAR_MAX_SIZE)
+	if limit, ok := ctxGetBodyLimit(r.Context()); ok {
+		max = limit
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, max))
+	if err != nil {
+		if err.Error() == bodyTooLargeMessage {
+			return util.NewErrorFrom(errBodyTooLarge{max})
+		}
+		return util.NewErrorFrom(err)
+	}
+	contentType := http.DetectContentType(data)
+	if !avatarContentTypes[contentType] {
+		return util.NewErrorf("The avatar has to be a png, jpeg, gif or webp image")
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	b := &managers.Blob{ContentType: contentType, Data: data}
+	if err := ah.blobs.Put(avatarBlobKey(u.Id), b); err != nil {
+		return err
+	}
+	if err := u.SetAvatarAt(ctx, b.UpdatedAt); err != nil {
+		return err
+	}
+	ah.userChanged(u.Id)
+	ah.auditLog(r, AUDIT_USER_AVATAR, auditObject("user", u.Id))
+	return jsonResponse(w, u)
+}
+
+// DELETE /user/avatar
+func (ah apiHandler) userAvatarDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if err := u.SetAvatarAt(ctx, time.Time{}); err != nil {
+		return err
+	}
+	if err := ah.blobs.Delete(avatarBlobKey(u.Id)); err != nil && !util.CheckErr(err, models.ErrDoesntExist) {
+		return err
+	}
+	ah.userChanged(u.Id)
+	ah.auditLog(r, AUDIT_USER_AVATAR, auditObject("user", u.Id))
+	return jsonResponse(w, u)
+}
+
+// GET /user/avatar/:uid
+// Only the members of the teams of the user can see its avatar
+func (ah apiHandler) userAvatarGet(w http.ResponseWriter, r *http.Request, uid string) error {
+	shared, err := ctxGetUser(r.Context()).SharesTeamWith(r.Context(), uid)
+	if err != nil {
+		return err
+	}
+	if !shared {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	b, err := ah.blobs.Get(avatarBlobKey(uid))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", b.ContentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	http.ServeContent(w, r, "", b.UpdatedAt, bytes.NewReader(b.Data))
+	return nil
+}
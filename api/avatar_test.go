@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestUserProfileAndAvatar(t *testing.T) {
+	u := loginDummyUser()
+	name, pronouns, title := "New name", "they/them", "Ops"
+	r, err := PutRequest("/user/profile", userProfileRequest{Fullname: &name, Pronouns: &pronouns, Title: &title})
+	CheckErrorAndResponse(t, r, err, 200)
+	nu := &models.User{}
+	if err := json.NewDecoder(r.Body).Decode(nu); err != nil {
+		t.Fatal(err)
+	}
+	if nu.FullName != name || nu.Pronouns != pronouns || nu.Title != title {
+		t.Fatalf("Unexpected profile %#v", nu)
+	}
+	long := strings.Repeat("a", models.USER_PRONOUNS_MAX_LENGTH+1)
+	r, err = PutRequest("/user/profile", userProfileRequest{Pronouns: &long})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PutRequestRaw("/user/avatar", []byte("<svg></svg>"))
+	CheckErrorAndResponse(t, r, err, 400)
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), bytes.Repeat([]byte{1}, 64)...)
+	r, err = PutRequestRaw("/user/avatar", png)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/user/avatar/" + u.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Get("Content-Type") != "image/png" || !bytes.Equal(data, png) {
+		t.Fatalf("Unexpected avatar %s %v", r.Header.Get("Content-Type"), data)
+	}
+	other := getDummyUser()
+	r, err = GetRequest("/user/avatar/" + other.Id)
+	CheckErrorAndResponse(t, r, err, 404)
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = PostRequest("/team/"+teams[0].Id+"/user", teamInviteUserRequest{Invite: other.Email})
+	CheckErrorAndResponse(t, r, err, 200)
+	tf := &models.TeamFull{}
+	if err := json.NewDecoder(r.Body).Decode(tf); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, tu := range tf.Users {
+		if tu.User == u.Id {
+			found = tu.FullName == name && tu.Pronouns == pronouns && tu.Title == title && tu.AvatarAt.Valid
+		}
+	}
+	if !found {
+		t.Fatalf("The profile is missing from the members of the team")
+	}
+	owner := activeSessionToken
+	s, err := apiH.sm.NewSession(other.Id, "1.1.1.1", "none", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = s.Id
+	r, err = GetRequest("/user/avatar/" + u.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	activeSessionToken = owner
+	r, err = DeleteRequest("/user/avatar")
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/user/avatar/" + u.Id)
+	CheckErrorAndResponse(t, r, err, 404)
+}
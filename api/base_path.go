@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// basePath is the path of the url the server is reached with, without the trailing slash. It's empty when the server
+// is in the root of its host
+func (c Conf) basePath() string {
+	u, err := url.Parse(c.Url)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(u.Path, "/")
+}
+
+// stripBasePath removes the base path from the request. It returns false if the request is outside of it
+func stripBasePath(r *http.Request, base string) bool {
+	if len(base) == 0 {
+		return true
+	}
+	p := r.URL.Path
+	if p != base && !strings.HasPrefix(p, base+"/") {
+		return false
+	}
+	r.URL.Path = "/" + strings.TrimPrefix(p[len(base):], "/")
+	r.URL.RawPath = ""
+	return true
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConfBasePath(t *testing.T) {
+	for url, base := range map[string]string{
+		"https://keycat.example.com":          "",
+		"https://keycat.example.com/":         "",
+		"https://intranet.example.com/keycat": "/keycat",
+		"https://intranet.example.com/a/b/":   "/a/b",
+	} {
+		if got := (Conf{Url: url}).basePath(); got != base {
+			t.Errorf("%s: expected base path %q and got %q", url, base, got)
+		}
+	}
+}
+
+func TestStripBasePath(t *testing.T) {
+	checks := []struct {
+		path   string
+		base   string
+		inBase bool
+		rest   string
+	}{
+		{"/api/v1/team", "", true, "/api/v1/team"},
+		{"/keycat/api/v1/team", "/keycat", true, "/api/v1/team"},
+		{"/keycat", "/keycat", true, "/"},
+		{"/keycat/", "/keycat", true, "/"},
+		{"/keycatx/api", "/keycat", false, "/keycatx/api"},
+		{"/api/v1/team", "/keycat", false, "/api/v1/team"},
+	}
+	for _, c := range checks {
+		r := httptest.NewRequest("GET", c.path, nil)
+		if inBase := stripBasePath(r, c.base); inBase != c.inBase || r.URL.Path != c.rest {
+			t.Errorf("%s in %s: expected %t %s and got %t %s", c.path, c.base, c.inBase, c.rest, inBase, r.URL.Path)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const batchMaxRequests = 20
+
+// Routes that can't run inside a batch. Streams never end, auth has its own rate limits and batches don't nest
+var batchForbiddenRoots = map[string]bool{
+	"auth":        true,
+	"ws":          true,
+	"eventsource": true,
+	"batch":       true,
+}
+
+var batchMethods = map[string]bool{"GET": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+type batchRequestItem struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type batchRequest struct {
+	Requests []batchRequestItem `json:"requests"`
+}
+
+type batchResponseItem struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	//Body is the json sent by the route or a string if it wasn't json
+	Body interface{} `json:"body,omitempty"`
+}
+
+type batchResponse struct {
+	Responses []batchResponseItem `json:"responses"`
+}
+
+// batchResponseWriter keeps the response of a request of the batch
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (bw *batchResponseWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *batchResponseWriter) WriteHeader(status int) {
+	if bw.status == 0 {
+		bw.status = status
+	}
+}
+
+func (bw *batchResponseWriter) Write(b []byte) (int, error) {
+	bw.WriteHeader(http.StatusOK)
+	return bw.body.Write(b)
+}
+
+// batchContext only keeps the cancellation of the batch so nothing that was set for the batch leaks into its requests
+type batchContext struct {
+	context.Context
+}
+
+func (batchContext) Value(key interface{}) interface{} {
+	return nil
+}
+
+// POST /batch
+func (ah apiHandler) batchRoot(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" || len(r.URL.Path) > 1 {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	br := &batchRequest{}
+	if err := jsonDecode(w, r, 1024*1024, br); err != nil {
+		return err
+	}
+	if len(br.Requests) == 0 || len(br.Requests) > batchMaxRequests {
+		return util.NewErrorf("A batch needs between 1 and %d requests", batchMaxRequests)
+	}
+	for i, item := range br.Requests {
+		if err := checkBatchRequestItem(item); err != nil {
+			return util.NewErrorf("Request %d: %s", i, err)
+		}
+	}
+	//Requests run one after the other so they can depend on the previous ones
+	resp := batchResponse{make([]batchResponseItem, len(br.Requests))}
+	for i, item := range br.Requests {
+		resp.Responses[i] = ah.batchServe(r, i, item)
+	}
+	return jsonResponse(w, resp)
+}
+
+func checkBatchRequestItem(item batchRequestItem) error {
+	if !batchMethods[item.Method] {
+		return fmt.Errorf("invalid method %s", item.Method)
+	}
+	u, err := url.Parse(item.Path)
+	if err != nil || u.IsAbs() || len(u.Host) > 0 || !strings.HasPrefix(u.Path, "/") {
+		return fmt.Errorf("invalid path %s", item.Path)
+	}
+	if head, _ := shiftPath(u.Path); batchForbiddenRoots[head] {
+		return fmt.Errorf("%s can't be batched", item.Path)
+	}
+	return nil
+}
+
+// batchServe runs a request of the batch through the whole handler as if the client had sent it with the same
+// credentials so authorization, rate limits and metrics apply to each one
+func (ah apiHandler) batchServe(r *http.Request, i int, item batchRequestItem) (bri batchResponseItem) {
+	sub, err := http.NewRequest(item.Method, ah.basePath+"/api/"+ctxGetApiVersion(r.Context())+item.Path, bytes.NewReader(item.Body))
+	if err != nil {
+		return batchErrorItem(r, http.StatusBadRequest, util.NewErrorf("Invalid request: %s", err))
+	}
+	//The requests of the batch can't be signed on their own since their uri is only known here. The proof of the batch covers them
+	sub = sub.WithContext(ctxAddBatchProof(batchContext{r.Context()}, ctxGetSession(r.Context()).Id))
+	sub.Header = r.Header.Clone()
+	sub.Header.Del("Content-Length")
+	//The batch gets compressed as a whole
+	sub.Header.Del("Accept-Encoding")
+	//The key of the batch covers the whole batch
+	sub.Header.Del(idempotencyHeader)
+	sub.Header.Set("Content-Type", "application/json")
+	sub.Header.Set(requestIdHeader, fmt.Sprintf("%s.%d", ctxGetRequestId(r.Context()), i))
+	sub.RemoteAddr = r.RemoteAddr
+	sub.Host = r.Host
+	bw := &batchResponseWriter{header: http.Header{}}
+	defer func() {
+		//Aborted listings leave a broken body behind
+		if rec := recover(); rec != nil {
+			requestLogf(r, "[ERROR] Request %d of the batch aborted: %v", i, rec)
+			bri = batchErrorItem(r, http.StatusInternalServerError, util.NewErrorFrom(ErrInternal))
+		}
+	}()
+	ah.ServeHTTP(bw, sub)
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	bri = batchResponseItem{Status: bw.status, Headers: map[string]string{}}
+	for k, v := range bw.header {
+		if k != "Content-Length" && len(v) > 0 {
+			bri.Headers[k] = v[0]
+		}
+	}
+	if body := bw.body.Bytes(); len(body) > 0 {
+		if strings.HasPrefix(bw.header.Get("Content-Type"), "application/json") && json.Valid(body) {
+			bri.Body = json.RawMessage(body)
+		} else {
+			bri.Body = string(body)
+		}
+	}
+	return bri
+}
+
+func batchErrorItem(r *http.Request, status int, err error) batchResponseItem {
+	return batchResponseItem{Status: status, Body: errWithRequestId(r, err)}
+}
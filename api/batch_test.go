@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBatchRequests(t *testing.T) {
+	u := loginDummyUser()
+	br := batchRequest{[]batchRequestItem{
+		{Method: "GET", Path: "/user"},
+		{Method: "GET", Path: "/team?limit=1"},
+		{Method: "GET", Path: "/team/nonexistent"},
+		{Method: "PUT", Path: "/user", Body: json.RawMessage(`{"full_name":`)},
+	}}
+	r, err := PostRequest("/batch", br)
+	CheckErrorAndResponse(t, r, err, 200)
+	resp := struct {
+		Responses []struct {
+			Status  int               `json:"status"`
+			Headers map[string]string `json:"headers"`
+			Body    json.RawMessage   `json:"body"`
+		} `json:"responses"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Responses) != len(br.Requests) {
+		t.Fatalf("Expected %d responses and got %d", len(br.Requests), len(resp.Responses))
+	}
+	for i, status := range []int{200, 200, 404, 400} {
+		if resp.Responses[i].Status != status {
+			t.Errorf("Expected status %d for request %d and got %d: %s", status, i, resp.Responses[i].Status, resp.Responses[i].Body)
+		}
+	}
+	user := struct {
+		Id string `json:"id"`
+	}{}
+	if err := json.Unmarshal(resp.Responses[0].Body, &user); err != nil || user.Id != u.Id {
+		t.Errorf("Expected the user of the batch and got %s", resp.Responses[0].Body)
+	}
+	if len(resp.Responses[0].Headers[requestIdHeader]) == 0 {
+		t.Errorf("Expected each request to have its own request id")
+	}
+}
+
+func TestBatchRejectedRequests(t *testing.T) {
+	loginDummyUser()
+	bad := [][]batchRequestItem{
+		{},
+		{{Method: "GET", Path: "/auth/login"}},
+		{{Method: "GET", Path: "/ws"}},
+		{{Method: "POST", Path: "/batch"}},
+		{{Method: "GET", Path: "/team/../eventsource"}},
+		{{Method: "GET", Path: "http://example.com/user"}},
+		{{Method: "GET", Path: "user"}},
+		{{Method: "TRACE", Path: "/user"}},
+		make([]batchRequestItem, batchMaxRequests+1),
+	}
+	for _, items := range bad {
+		r, err := PostRequest("/batch", batchRequest{items})
+		CheckErrorAndResponse(t, r, err, 400)
+	}
+	r, err := GetRequest("/batch")
+	CheckErrorAndResponse(t, r, err, 404)
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const JOB_BLOCKLIST_PURGE = "blocklist_purge"
+
+type ipBlockEntry struct {
+	id        string
+	network   *net.IPNet
+	expiresAt time.Time
+}
+
+// ipBlocklist keeps the active blocks in memory so they can be checked on every request without hitting the db
+type ipBlocklist struct {
+	lock    *sync.RWMutex
+	entries []ipBlockEntry
+}
+
+func newIpBlocklist() *ipBlocklist {
+	return &ipBlocklist{lock: &sync.RWMutex{}}
+}
+
+func (bl *ipBlocklist) set(ibs []*models.IpBlock) {
+	entries := make([]ipBlockEntry, 0, len(ibs))
+	for _, ib := range ibs {
+		network, err := models.ParseIpBlockCidr(ib.Cidr)
+		if err != nil {
+			continue
+		}
+		entry := ipBlockEntry{id: ib.Id, network: network}
+		if ib.ExpiresAt.Valid {
+			entry.expiresAt = ib.ExpiresAt.Time
+		}
+		entries = append(entries, entry)
+	}
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+	bl.entries = entries
+}
+
+// blocked returns the block that applies to the address if there's any
+func (bl *ipBlocklist) blocked(addr string, now time.Time) *ipBlockEntry {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	bl.lock.RLock()
+	defer bl.lock.RUnlock()
+	for i, entry := range bl.entries {
+		if !entry.expiresAt.IsZero() && !entry.expiresAt.After(now) {
+			continue
+		}
+		if entry.network.Contains(ip) {
+			return &bl.entries[i]
+		}
+	}
+	return nil
+}
+
+func (ah apiHandler) reloadBlocklist(ctx context.Context) error {
+	ibs, err := models.GetActiveIpBlocks(models.AddDBToContext(ctx, ah.db))
+	if err != nil {
+		return err
+	}
+	ah.blocklist.set(ibs)
+	return nil
+}
+
+func purgeExpiredIpBlocks(ctx context.Context) (string, error) {
+	purged, err := models.PurgeExpiredIpBlocks(ctx, time.Now().UTC(), false)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Purged %d expired ip blocks", purged.Count), nil
+}
+
+type blockedResponse struct {
+	Error     string     `json:"error"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RequestId string     `json:"request_id,omitempty"`
+}
+
+// blocklistBlock answers with a 403 if the address of the request is blocked. Returns true if the request has been answered
+func (ah apiHandler) blocklistBlock(w http.ResponseWriter, r *http.Request) bool {
+	entry := ah.blocklist.blocked(ah.clientIP(r), time.Now())
+	if entry == nil {
+		return false
+	}
+	br := blockedResponse{Error: "blocked", RequestId: ctxGetRequestId(r.Context())}
+	if !entry.expiresAt.IsZero() {
+		br.ExpiresAt = &entry.expiresAt
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(entry.expiresAt)/time.Second)+1))
+	}
+	b := util.BufPool.Get()
+	defer util.BufPool.Put(b)
+	json.NewEncoder(b).Encode(br)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.Bytes())))
+	w.WriteHeader(http.StatusForbidden)
+	b.WriteTo(w)
+	return true
+}
+
+// rateLimitTripped counts how many times an address has exceeded the rate limit of the guarded route
+// and blocks it for a while once it does so too often
+func (ah apiHandler) rateLimitTripped(r *http.Request, ip, path string) {
+	cb := ah.opts().blocklist
+	if cb.AutoTrips <= 0 || !routeMatches(cb.AutoRoute, "", r.Method, path) {
+		return
+	}
+	trips, _, err := ah.rateLimits.Hit("trips:"+ip, time.Duration(cb.AutoWindow)*time.Second)
+	if err != nil {
+		requestLogf(r, "[ERROR] Could not count rate limit trips for %s: %s", ip, err)
+		return
+	}
+	if trips < cb.AutoTrips {
+		return
+	}
+	reason := fmt.Sprintf("Exceeded the rate limit of %s %d times", cb.AutoRoute, trips)
+	ib, err := models.ExtendAutomaticIpBlock(r.Context(), ip, reason, time.Now().Add(time.Duration(cb.AutoDuration)*time.Second))
+	switch {
+	case util.CheckErr(err, models.ErrAlreadyExists):
+		//An admin has already blocked it
+		return
+	case err != nil:
+		requestLogf(r, "[ERROR] Could not block %s: %s", ip, err)
+		return
+	}
+	requestLogf(r, "Blocked %s until %s: %s", ib.Cidr, ib.ExpiresAt.Time.Format(time.RFC3339), reason)
+	ah.auditLogAs(r, "", AUDIT_BLOCKLIST_AUTO, auditObject("ip_block", ib.Id))
+	ah.bcast.Invalidate(INVALIDATE_BLOCKLIST, "")
+	if err := ah.reloadBlocklist(r.Context()); err != nil {
+		requestLogf(r, "[ERROR] Could not reload the blocklist: %s", err)
+	}
+}
+
+// /admin/blocklist
+func (ah apiHandler) adminBlocklistRoot(w http.ResponseWriter, r *http.Request) error {
+	var id string
+	id, r.URL.Path = shiftPath(r.URL.Path)
+	switch {
+	case len(id) == 0 && r.Method == "GET":
+		ibs, err := models.GetActiveIpBlocks(r.Context())
+		if err != nil {
+			return err
+		}
+		return jsonResponse(w, ibs)
+	case len(id) == 0 && r.Method == "POST":
+		return ah.adminBlocklistAdd(w, r)
+	case len(id) > 0 && r.Method == "DELETE":
+		return ah.adminBlocklistDelete(w, r, id)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type adminBlocklistAddRequest struct {
+	Cidr      string     `json:"cidr"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// POST /admin/blocklist
+func (ah apiHandler) adminBlocklistAdd(w http.ResponseWriter, r *http.Request) error {
+	abr := &adminBlocklistAddRequest{}
+	if err := jsonDecode(w, r, 4096, abr); err != nil {
+		return err
+	}
+	network, err := models.ParseIpBlockCidr(abr.Cidr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(ah.clientIP(r)); ip != nil && network.Contains(ip) {
+		return util.NewErrorf("Refusing to block %s because it contains your own address", network)
+	}
+	ib := &models.IpBlock{Cidr: abr.Cidr, Reason: abr.Reason, CreatedBy: ctxGetUser(r.Context()).Id}
+	if abr.ExpiresAt != nil {
+		ib.ExpiresAt = pq.NullTime{Time: abr.ExpiresAt.UTC(), Valid: true}
+	}
+	if err := models.AddIpBlock(r.Context(), ib); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_ADMIN_BLOCKLIST_ADD, auditObject("ip_block", ib.Id))
+	ah.bcast.Invalidate(INVALIDATE_BLOCKLIST, "")
+	if err := ah.reloadBlocklist(r.Context()); err != nil {
+		return err
+	}
+	return jsonResponse(w, ib)
+}
+
+// DELETE /admin/blocklist/:id
+func (ah apiHandler) adminBlocklistDelete(w http.ResponseWriter, r *http.Request, id string) error {
+	ib, err := models.FindIpBlock(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := ib.Delete(r.Context()); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_ADMIN_BLOCKLIST_DEL, auditObject("ip_block", ib.Id))
+	ah.bcast.Invalidate(INVALIDATE_BLOCKLIST, "")
+	if err := ah.reloadBlocklist(r.Context()); err != nil {
+		return err
+	}
+	return jsonResponse(w, ib)
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestIpBlocklistMatch(t *testing.T) {
+	bl := newIpBlocklist()
+	now := time.Now()
+	bl.set([]*models.IpBlock{
+		{Id: "net", Cidr: "10.1.0.0/16"},
+		{Id: "v6", Cidr: "2001:db8::1/128"},
+	})
+	for addr, expected := range map[string]string{"10.1.2.3": "net", "10.2.0.1": "", "2001:db8::1": "v6", "garbage": ""} {
+		entry := bl.blocked(addr, now)
+		switch {
+		case entry == nil && len(expected) > 0:
+			t.Errorf("Expected %s to be blocked by %s", addr, expected)
+		case entry != nil && entry.id != expected:
+			t.Errorf("Expected %s to be blocked by '%s' not %s", addr, expected, entry.id)
+		}
+	}
+	if _, err := models.ParseIpBlockCidr("10.1.2.3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.ParseIpBlockCidr("10.1.2.3/33"); err == nil {
+		t.Fatal("Expected an error with an invalid network")
+	}
+}
+
+func TestAdminBlocklist(t *testing.T) {
+	u := loginDummyUser()
+	r, err := PostRequest("/admin/blocklist", adminBlocklistAddRequest{Cidr: "192.0.2.0/24"})
+	CheckErrorAndResponse(t, r, err, 401)
+	if err := u.SetAdmin(getCtx(), true); err != nil {
+		t.Fatal(err)
+	}
+	r, err = PostRequest("/admin/blocklist", adminBlocklistAddRequest{Cidr: "127.0.0.0/8"})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest("/admin/blocklist", adminBlocklistAddRequest{Cidr: "192.0.2.0/24", Reason: "test"})
+	CheckErrorAndResponse(t, r, err, 200)
+	ibs, err := models.GetActiveIpBlocks(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ibs) != 1 || ibs[0].Cidr != "192.0.2.0/24" {
+		t.Fatalf("Unexpected blocks %#v", ibs)
+	}
+	req := httptest.NewRequest("GET", "/api/version", nil)
+	req.RemoteAddr = "192.0.2.10:4567"
+	w := httptest.NewRecorder()
+	apiH.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected a blocked request and got %d", w.Code)
+	}
+	r, err = DeleteRequest("/admin/blocklist/" + ibs[0].Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	w = httptest.NewRecorder()
+	apiH.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the block to be removed and got %d", w.Code)
+	}
+}
+
+func TestAutomaticBlock(t *testing.T) {
+	boot := apiH.live.boot
+	defer apiH.Reload(boot)
+	c := boot
+	c.RateLimit.Rules = []ConfRateLimitRule{{Route: "/api/version", By: RATE_LIMIT_BY_IP, Limit: 1, Window: 1}}
+	c.Blocklist = ConfBlocklist{AutoRoute: "/api/version", AutoTrips: 2, AutoWindow: 60, AutoDuration: 60}
+	if _, err := apiH.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/api/version", nil)
+	req.RemoteAddr = "198.51.100.7:4567"
+	codes := []int{}
+	for trip := 0; trip < 2; trip++ {
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			apiH.ServeHTTP(w, req)
+			codes = append(codes, w.Code)
+		}
+		time.Sleep(1100 * time.Millisecond)
+	}
+	w := httptest.NewRecorder()
+	apiH.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected the address to be blocked after tripping the limit twice and got %v %d", codes, w.Code)
+	}
+	ibs, err := models.GetActiveIpBlocks(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ib := range ibs {
+		if ib.Cidr == "198.51.100.7/32" && ib.Automatic {
+			ib.Delete(getCtx())
+		}
+	}
+	apiH.reloadBlocklist(getCtx())
+}
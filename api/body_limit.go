@@ -0,0 +1,50 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Returned by http.MaxBytesReader once the limit is reached
+const bodyTooLargeMessage = "http: request body too large"
+
+type errBodyTooLarge struct {
+	limit int64
+}
+
+func (e errBodyTooLarge) Error() string {
+	return fmt.Sprintf("Request body is larger than the %d bytes allowed", e.limit)
+}
+
+func getErrBodyTooLarge(err error) (errBodyTooLarge, bool) {
+	if uerr, ok := err.(*util.Error); ok && uerr.Inner() != nil {
+		err = uerr.Inner()
+	}
+	ebtl, ok := err.(errBodyTooLarge)
+	return ebtl, ok
+}
+
+type bodyTooLargeResponse struct {
+	Error     string `json:"error"`
+	Limit     int64  `json:"limit"`
+	RequestId string `json:"request_id,omitempty"`
+}
+
+// withBodyLimit stores the configured body limit for the most specific route matching the request
+func (ah apiHandler) withBodyLimit(r *http.Request, path string) *http.Request {
+	var best *ConfBodyLimit
+	for _, bl := range ah.opts().bodyLimits {
+		if !routeMatches(bl.Route, bl.Method, r.Method, path) {
+			continue
+		}
+		if bl := bl; best == nil || len(bl.Route) > len(best.Route) || (len(bl.Route) == len(best.Route) && len(bl.Method) > 0) {
+			best = &bl
+		}
+	}
+	if best == nil {
+		return r
+	}
+	return r.WithContext(ctxAddBodyLimit(r.Context(), best.Max))
+}
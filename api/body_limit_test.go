@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestConfiguredBodyLimit(t *testing.T) {
+	boot := apiH.live.boot
+	defer apiH.Reload(boot)
+	c := boot
+	c.BodyLimits = []ConfBodyLimit{
+		{Route: "/api/auth", Max: 1024 * 1024},
+		{Route: "/api/auth/register", Method: "POST", Max: 64},
+	}
+	if _, err := apiH.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+	arr := &authRegisterRequest{Username: "toolong", Email: "toolong@nowhere.net", Fullname: "A really long full name that does not fit in the limit"}
+	r, err := PostRequest("/auth/register", arr)
+	CheckErrorAndResponse(t, r, err, http.StatusRequestEntityTooLarge)
+	btlr := &bodyTooLargeResponse{}
+	if err := json.NewDecoder(r.Body).Decode(btlr); err != nil {
+		t.Fatal(err)
+	}
+	if btlr.Limit != 64 || len(btlr.RequestId) == 0 {
+		t.Errorf("Unexpected body too large response: %#v", btlr)
+	}
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/db"
+	"github.com/keydotcat/keycatd/managers"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const confCheckTimeout = 5 * time.Second
+
+const (
+	CONF_CHECK_OK = iota
+	CONF_CHECK_INVALID
+	CONF_CHECK_UNREACHABLE
+)
+
+type ConfCheck struct {
+	Name   string
+	Status int
+	Info   string
+	Error  error
+}
+
+func (cc ConfCheck) String() string {
+	switch {
+	case cc.Error != nil:
+		return fmt.Sprintf("FAIL %s: %s", cc.Name, cc.Error)
+	case len(cc.Info) > 0:
+		return fmt.Sprintf("OK   %s: %s", cc.Name, cc.Info)
+	}
+	return fmt.Sprintf("OK   %s", cc.Name)
+}
+
+// CheckConf validates the configuration and tries to reach the db, the mail server and the session store.
+// Unlike validate it doesn't stop at the first error so all problems can be fixed at once
+func CheckConf(c Conf) []ConfCheck {
+	checks := []ConfCheck{}
+	add := func(name string, status int, info string, err error) {
+		if err == nil {
+			status = CONF_CHECK_OK
+		}
+		checks = append(checks, ConfCheck{name, status, info, err})
+	}
+	add("config", CONF_CHECK_INVALID, "", c.validate())
+	add("url", CONF_CHECK_INVALID, c.Url, checkConfUrl(c.Url))
+	add("csrf", CONF_CHECK_INVALID, "", checkConfCsrf(c.Csrf))
+	info, err := checkConfDB(c)
+	add("db", CONF_CHECK_UNREACHABLE, info, err)
+	switch {
+	case c.MailSMTP != nil:
+		add("mail.smtp", CONF_CHECK_UNREACHABLE, c.MailSMTP.Server, checkConfSMTP(c.MailSMTP.Server))
+	case c.MailSparkpost != nil:
+		host := "api.sparkpost.com:443"
+		if c.MailSparkpost.EU {
+			host = "api.eu.sparkpost.com:443"
+		}
+		add("mail.sparkpost", CONF_CHECK_UNREACHABLE, host, checkConfDial(host))
+	default:
+		add("mail", CONF_CHECK_INVALID, "", util.NewErrorf("No mail sender configured. Define either mail.smtp or mail.sparkpost"))
+	}
+	switch {
+	case c.TLS != nil && c.TLS.ACME != nil:
+		add("tls.acme", CONF_CHECK_INVALID, strings.Join(c.TLS.ACME.Domains, ", "), checkConfCacheDir(c.TLS.ACME.CacheDir))
+	case c.TLS != nil:
+		add("tls", CONF_CHECK_INVALID, c.TLS.CertFile, checkConfCert(c.TLS.CertFile, c.TLS.KeyFile))
+	}
+	if c.Cluster != nil && c.Cluster.Redis != nil {
+		add("cluster.redis", CONF_CHECK_UNREACHABLE, c.Cluster.Redis.Server, checkConfDial(c.Cluster.Redis.Server))
+	}
+	if c.SessionRedis != nil {
+		add("session.redis", CONF_CHECK_UNREACHABLE, c.SessionRedis.Server, checkConfDial(c.SessionRedis.Server))
+	}
+	if len(c.Audit.CheckpointKeyFile) > 0 {
+		_, err := managers.LoadSigningKey(c.Audit.CheckpointKeyFile)
+		add("audit.checkpoint_key_file", CONF_CHECK_INVALID, c.Audit.CheckpointKeyFile, err)
+	}
+	if len(c.KeyLog.KeyFile) > 0 {
+		_, err := managers.LoadSigningKey(c.KeyLog.KeyFile)
+		add("keylog.key_file", CONF_CHECK_INVALID, c.KeyLog.KeyFile, err)
+	}
+	if c.KMS != nil {
+		add("kms", CONF_CHECK_UNREACHABLE, c.KMS.Provider, checkConfKMS(*c.KMS))
+	}
+	return checks
+}
+
+func checkConfUrl(raw string) error {
+	if len(raw) == 0 {
+		return util.NewErrorf("url is empty. Set it to the public address users reach the server with, like https://keycat.example.com")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return util.NewErrorf("url could not be parsed: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return util.NewErrorf("url has to start with http:// or https://")
+	}
+	if len(u.Host) == 0 {
+		return util.NewErrorf("url has no host")
+	}
+	if len(u.RawQuery) > 0 || len(u.Fragment) > 0 {
+		return util.NewErrorf("url can't have a query or a fragment")
+	}
+	return nil
+}
+
+func checkConfCsrf(cc ConfCsrf) error {
+	errs := []string{}
+	if l := len(cc.HashKey); l != 32 && l != 64 {
+		errs = append(errs, fmt.Sprintf("csrf.hash_key is %d characters long and has to be 32 or 64", l))
+	}
+	if l := len(cc.BlockKey); l != 0 && l != 16 && l != 24 && l != 32 {
+		errs = append(errs, fmt.Sprintf("csrf.block_key is %d characters long and has to be 16, 24 or 32 (or empty to disable encryption)", l))
+	}
+	for i, key := range cc.PreviousKeys {
+		if err := key.validate(fmt.Sprintf("csrf.previous_keys %d ", i)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return util.NewErrorf("%s", strings.Join(errs, ". "))
+	}
+	return nil
+}
+
+func checkConfDB(c Conf) (string, error) {
+	if len(c.DB) == 0 {
+		return "", util.NewErrorf("db is empty")
+	}
+	sqldb, err := sql.Open("postgres", c.DB)
+	if err != nil {
+		return "", util.NewErrorf("Invalid db connection string: %s", err)
+	}
+	defer sqldb.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), confCheckTimeout)
+	defer cancel()
+	if err := sqldb.PingContext(ctx); err != nil {
+		return "", util.NewErrorf("Could not connect to the db: %s", err)
+	}
+	m := db.NewMigrateMgr(sqldb, c.DBType)
+	if err := m.LoadMigrations(); err != nil {
+		return "", err
+	}
+	pending, err := m.CheckIfMigrationIsRequired()
+	if err != nil {
+		return "", util.NewErrorf("Could not check the migrations: %s", err)
+	}
+	if pending > 0 {
+		return fmt.Sprintf("%d migrations will be applied on start", pending), nil
+	}
+	return "connected", nil
+}
+
+func checkConfDial(address string) error {
+	conn, err := net.DialTimeout("tcp", address, confCheckTimeout)
+	if err != nil {
+		return util.NewErrorf("Could not connect to %s: %s", address, err)
+	}
+	return conn.Close()
+}
+
+// checkConfKMS wraps and unwraps a value to check that the kms can be used with the credentials
+func checkConfKMS(ck ConfKMS) error {
+	kms, err := ck.KeyWrapper()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), confCheckTimeout)
+	defer cancel()
+	wrapped, err := kms.Wrap(ctx, []byte("keycatd"))
+	if err != nil {
+		return err
+	}
+	_, err = kms.Unwrap(ctx, wrapped)
+	return err
+}
+
+func checkConfSMTP(server string) error {
+	conn, err := net.DialTimeout("tcp", server, confCheckTimeout)
+	if err != nil {
+		return util.NewErrorf("Could not connect to %s: %s", server, err)
+	}
+	conn.SetDeadline(time.Now().Add(confCheckTimeout))
+	host, _, _ := net.SplitHostPort(server)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return util.NewErrorf("%s did not answer as an smtp server: %s", server, err)
+	}
+	defer client.Close()
+	return client.Quit()
+}
+
+func checkConfCert(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return util.NewErrorf("Could not load the certificate: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return util.NewErrorf("Could not parse the certificate: %s", err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return util.NewErrorf("The certificate expired on %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func checkConfCacheDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return util.NewErrorf("Could not create tls.acme.cache_dir: %s", err)
+	}
+	f, err := ioutil.TempFile(dir, ".check-")
+	if err != nil {
+		return util.NewErrorf("tls.acme.cache_dir is not writable: %s", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
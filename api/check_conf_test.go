@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckConf(t *testing.T) {
+	c := apiH.live.boot
+	c.MailSMTP = &ConfMailSMTP{Server: "127.0.0.1:1"}
+	checks := CheckConf(c)
+	status := map[string]int{}
+	for _, check := range checks {
+		status[check.Name] = check.Status
+	}
+	for _, name := range []string{"config", "url", "csrf", "db"} {
+		if status[name] != CONF_CHECK_OK {
+			t.Errorf("Expected check %s to be ok: %v", name, checks)
+		}
+	}
+	if status["mail.smtp"] != CONF_CHECK_UNREACHABLE {
+		t.Errorf("Expected the smtp server to be unreachable: %v", checks)
+	}
+	c.Url = "keycat.example.com"
+	c.Csrf.HashKey = "short"
+	checks = CheckConf(c)
+	for _, check := range checks {
+		if (check.Name == "url" || check.Name == "csrf") && check.Status != CONF_CHECK_INVALID {
+			t.Errorf("Expected check %s to be invalid: %s", check.Name, check)
+		}
+	}
+}
+
+func TestCheckConfSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		buf := make([]byte, 512)
+		conn.Read(buf)
+		conn.Write([]byte("221 Bye\r\n"))
+	}()
+	if err := checkConfSMTP(ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckConfTLS(t *testing.T) {
+	if err := checkConfCacheDir(filepath.Join(t.TempDir(), "acme")); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkConfCert("/nonexistant/cert.pem", "/nonexistant/key.pem"); err == nil {
+		t.Fatal("Expected an error loading a missing certificate")
+	}
+	c := apiH.live.boot
+	c.TLS = &ConfTLS{CertFile: "cert.pem"}
+	if err := c.validate(); err == nil {
+		t.Fatal("Expected an error without a key file")
+	}
+	c.TLS = &ConfTLS{ACME: &ConfTLSACME{Domains: []string{"keycat.example.com"}, CacheDir: "acme", HTTPPort: 80}}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckConfCors(t *testing.T) {
+	c := apiH.live.boot
+	c.Cors = ConfCors{AllowedOrigins: []string{"keycat.example.com"}}
+	if err := c.validate(); err == nil {
+		t.Fatal("Expected an error with an origin without scheme")
+	}
+	c.Cors = ConfCors{AllowedOrigins: []string{"https://keycat.example.com", "https://*.example.com"}, AllowCredentials: true, MaxAge: 600}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
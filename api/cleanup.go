@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+const JOB_CLEANUP = "cleanup"
+
+func retentionCutoff(now time.Time, days int) time.Time {
+	return now.AddDate(0, 0, -days)
+}
+
+func setTokenLifetimes(c ConfTokenLifetimes) {
+	models.SetTokenLifetimes(time.Duration(c.VerificationHours)*time.Hour, time.Duration(c.InviteDays)*24*time.Hour)
+}
+
+// cleanupExpired removes the confirmation tokens and sessions older than the retention. A retention of 0 keeps them forever.
+// Idempotency keys are always removed once they expire
+func (ah apiHandler) cleanupExpired(ctx context.Context) (string, error) {
+	cr, err := ah.purgeExpired(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	var tokens, sessions, keys int64
+	for _, ar := range cr.Affected {
+		switch ar.Kind {
+		case "token":
+			tokens = ar.Count
+		case "session":
+			sessions = ar.Count
+		case "idempotency_key":
+			keys = ar.Count
+		}
+	}
+	return fmt.Sprintf("Purged %d tokens, %d sessions and %d idempotency keys", tokens, sessions, keys), nil
+}
+
+// purgeExpired does the work of the cleanup job. With dryRun it reports what the job would remove
+func (ah apiHandler) purgeExpired(ctx context.Context, dryRun bool) (*models.ChangeReport, error) {
+	cc := ah.opts().cleanup
+	now := time.Now().UTC()
+	cr := models.NewChangeReport(dryRun)
+	if cc.TokenRetentionDays > 0 {
+		tokens, err := models.PurgeExpiredTokens(ctx, retentionCutoff(now, cc.TokenRetentionDays), dryRun)
+		if err != nil {
+			return nil, err
+		}
+		if !dryRun {
+			atomic.AddUint64(&ah.metrics.purgedTokens, uint64(tokens.Count))
+		}
+		cr.Add(tokens)
+	}
+	if cc.SessionRetentionDays > 0 {
+		before := retentionCutoff(now, cc.SessionRetentionDays)
+		var sessions int
+		var err error
+		if dryRun {
+			sessions, err = ah.sm.CountSessionsBefore(before)
+		} else {
+			sessions, err = ah.sm.PurgeSessions(before)
+			atomic.AddUint64(&ah.metrics.purgedSessions, uint64(sessions))
+		}
+		if err != nil {
+			return nil, err
+		}
+		//Session ids are the credentials of the sessions so they are only counted
+		cr.Add(models.AffectedRows{Kind: "session", Count: int64(sessions)})
+	}
+	keys, err := models.PurgeIdempotencyKeys(ctx, now.Add(-models.IDEMPOTENCY_KEY_TTL), dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		atomic.AddUint64(&ah.metrics.purgedIdempotencyKeys, uint64(keys.Count))
+	}
+	cr.Add(keys)
+	return cr, nil
+}
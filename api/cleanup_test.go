@@ -0,0 +1,62 @@
+package api
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestCleanupExpired(t *testing.T) {
+	boot := apiH.live.boot
+	defer apiH.Reload(boot)
+	c := boot
+	c.Cleanup = ConfCleanup{TokenRetentionDays: 30, SessionRetentionDays: 90}
+	if _, err := apiH.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+	ctx := getCtx()
+	u := getDummyUser()
+	fresh, err := u.ChangeEmail(ctx, u.Id+"@fresh.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := getDummyUser()
+	old, err := stale.ChangeEmail(ctx, stale.Id+"@stale.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := apiH.db.Exec(`UPDATE "token" SET "updated_at" = $1 WHERE "id" = $2`, time.Now().AddDate(0, 0, -60), old.Id); err != nil {
+		t.Fatal(err)
+	}
+	s, err := apiH.sm.NewSession(stale.Id, "1.1.1.1", "none", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := atomic.LoadUint64(&apiH.metrics.purgedTokens)
+	if _, err := apiH.cleanupExpired(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.FindToken(ctx, fresh.Secret); err != nil {
+		t.Errorf("Fresh token has been purged: %s", err)
+	}
+	if _, err := models.FindToken(ctx, old.Secret); !util.CheckErr(err, models.ErrDoesntExist) {
+		t.Errorf("Expected the stale token to be purged and got %v", err)
+	}
+	if _, err := apiH.sm.GetSession(s.Id); err != nil {
+		t.Errorf("Session in use has been purged: %s", err)
+	}
+	if atomic.LoadUint64(&apiH.metrics.purgedTokens) <= before {
+		t.Errorf("Purged tokens have not been counted")
+	}
+	//Asking for the confirmation mail again has to create a new token
+	nt, err := stale.GetVerificationToken(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nt.Id == old.Id {
+		t.Errorf("Expected a new token")
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// trustedProxies decides when the forwarding headers are believed. Without proxy mode or trusted proxies the
+// address of the connection is the client, so nobody can pick the address they are blocked or rate limited by
+type trustedProxies struct {
+	//all trusts whatever connects to keycatd as a proxy, like when it only listens behind a load balancer
+	all      bool
+	networks []*net.IPNet
+}
+
+// parseTrustedProxies accepts both single addresses and cidrs
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		cidr := p
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, util.NewErrorf("Invalid trusted_proxies %s. It has to be an address or a cidr", p)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func newTrustedProxies(proxyMode bool, proxies []string) (*trustedProxies, error) {
+	networks, err := parseTrustedProxies(proxies)
+	if err != nil {
+		return nil, err
+	}
+	return &trustedProxies{proxyMode, networks}, nil
+}
+
+func (tp *trustedProxies) trusted(ip net.IP) bool {
+	for _, network := range tp.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address of the client of the request. X-Forwarded-For is read from the right and the first hop
+// that isn't a trusted proxy is the client, since the hops to its left can be made up by the client itself
+func (tp *trustedProxies) clientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	ip := net.ParseIP(remote)
+	if tp == nil || ip == nil || (!tp.all && !tp.trusted(ip)) {
+		return remote
+	}
+	if xff := r.Header.Get("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(xff, ",")
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			client = hop.String()
+			if !tp.trusted(hop) {
+				break
+			}
+		}
+		return client
+	}
+	if hop := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); hop != nil {
+		return hop.String()
+	}
+	return remote
+}
+
+// clientIP is the address used to block, rate limit and audit the request
+func (ah apiHandler) clientIP(r *http.Request) string {
+	return ah.proxies.clientIP(r)
+}
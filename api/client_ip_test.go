@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	direct, err := newTrustedProxies(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed, err := newTrustedProxies(false, []string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxied, err := newTrustedProxies(true, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTrustedProxies(false, []string{"nope"}); err == nil {
+		t.Errorf("Expected an invalid trusted proxy to be refused")
+	}
+	cases := []struct {
+		tp     *trustedProxies
+		remote string
+		xff    string
+		real   string
+		ip     string
+	}{
+		{direct, "198.51.100.7:1234", "203.0.113.1", "203.0.113.2", "198.51.100.7"},
+		{listed, "198.51.100.7:1234", "203.0.113.1", "", "198.51.100.7"},
+		{listed, "10.1.1.1:1234", "203.0.113.1", "", "203.0.113.1"},
+		{listed, "192.0.2.1:1234", "6.6.6.6, 203.0.113.1, 10.2.2.2", "", "203.0.113.1"},
+		{listed, "10.1.1.1:1234", "", "203.0.113.2", "203.0.113.2"},
+		{listed, "10.1.1.1:1234", "garbage", "", "10.1.1.1"},
+		{proxied, "198.51.100.7:1234", "6.6.6.6, 203.0.113.1", "", "203.0.113.1"},
+		{proxied, "198.51.100.7:1234", "", "", "198.51.100.7"},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.remote
+		if len(c.xff) > 0 {
+			req.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if len(c.real) > 0 {
+			req.Header.Set("X-Real-Ip", c.real)
+		}
+		if ip := c.tp.clientIP(req); ip != c.ip {
+			t.Errorf("Case %d: expected %s and got %s", i, c.ip, ip)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const clientHeader = "X-Keycat-Client"
+
+// clientVersions returns the minimum version of each type of client. The configured ones take precedence over the
+// ones built in the server
+func clientVersions(conf map[string]string) map[string]string {
+	versions := map[string]string{}
+	for client, version := range util.MIN_CLIENT_VERSIONS {
+		versions[client] = version
+	}
+	for client, version := range conf {
+		versions[strings.ToLower(client)] = version
+	}
+	return versions
+}
+
+// parseClientHeader splits a header like web/1.4.2 into the type of client and its version
+func parseClientHeader(val string) (client, version string, err error) {
+	parts := strings.SplitN(strings.TrimSpace(val), "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return "", "", util.NewErrorf("Invalid %s header. It has to be like web/1.4.2", clientHeader)
+	}
+	client, version = strings.ToLower(parts[0]), parts[1]
+	if _, err := util.CompareVersions(version, version); err != nil {
+		return "", "", util.NewErrorf("Invalid %s header: %s", clientHeader, err)
+	}
+	return client, version, nil
+}
+
+type upgradeRequiredResponse struct {
+	Error      string `json:"error"`
+	Client     string `json:"client"`
+	Version    string `json:"version"`
+	MinVersion string `json:"min_version"`
+	RequestId  string `json:"request_id,omitempty"`
+}
+
+// clientVersionBlock answers with a 426 if the client says it's older than the oldest version supported for its type.
+// Requests without the header and unknown types of clients are let through. Returns true if the request has been answered
+func (ah apiHandler) clientVersionBlock(w http.ResponseWriter, r *http.Request) bool {
+	val := r.Header.Get(clientHeader)
+	if len(val) == 0 {
+		return false
+	}
+	client, version, err := parseClientHeader(val)
+	if err != nil {
+		httpErr(w, r, err)
+		return true
+	}
+	min, ok := ah.opts().clientVersions[client]
+	if !ok {
+		return false
+	}
+	if cmp, err := util.CompareVersions(version, min); err != nil || cmp >= 0 {
+		return false
+	}
+	b := util.BufPool.Get()
+	defer util.BufPool.Put(b)
+	json.NewEncoder(b).Encode(upgradeRequiredResponse{"upgrade_required", client, version, min, ctxGetRequestId(r.Context())})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.Bytes())))
+	w.WriteHeader(http.StatusUpgradeRequired)
+	b.WriteTo(w)
+	return true
+}
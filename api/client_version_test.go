@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestParseClientHeader(t *testing.T) {
+	client, version, err := parseClientHeader("Web/1.4.2")
+	if err != nil || client != "web" || version != "1.4.2" {
+		t.Errorf("Unexpected client %s %s %v", client, version, err)
+	}
+	for _, bad := range []string{"web", "/1.0", "web/", "web/one"} {
+		if _, _, err := parseClientHeader(bad); err == nil {
+			t.Errorf("Expected %q to be invalid", bad)
+		}
+	}
+}
+
+func clientRequest(path, client string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", srv.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(clientHeader, client)
+	return httpDo(req)
+}
+
+func TestClientVersionBlock(t *testing.T) {
+	boot := apiH.live.boot
+	defer apiH.Reload(boot)
+	c := boot
+	c.ClientVersions = map[string]string{"web": "2.0.0"}
+	if _, err := apiH.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+	loginDummyUser()
+	r, err := clientRequest("/user", "web/1.9.9")
+	CheckErrorAndResponse(t, r, err, http.StatusUpgradeRequired)
+	urr := &upgradeRequiredResponse{}
+	if err := json.NewDecoder(r.Body).Decode(urr); err != nil {
+		t.Fatal(err)
+	}
+	if urr.Error != "upgrade_required" || urr.Client != "web" || urr.MinVersion != "2.0.0" {
+		t.Errorf("Unexpected upgrade response %#v", urr)
+	}
+	r, err = clientRequest("/user", "web/2.0.1")
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = clientRequest("/user", "unknown/0.0.1")
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = clientRequest("/user", "web")
+	CheckErrorAndResponse(t, r, err, 400)
+	//Outdated clients can still find out what to upgrade to
+	r, err = clientRequest("/version", "web/1.0.0")
+	CheckErrorAndResponse(t, r, err, 200)
+	vr := &versionSendFullResponse{}
+	if err := json.NewDecoder(r.Body).Decode(vr); err != nil {
+		t.Fatal(err)
+	}
+	if vr.ClientVersions["web"] != "2.0.0" || len(vr.ClientVersions["cli"]) == 0 {
+		t.Errorf("Unexpected client versions %v", vr.ClientVersions)
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/keydotcat/keycatd/db"
+	"github.com/keydotcat/keycatd/managers"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// clusterSyncInterval is how often the state that is cached in memory is reloaded from the db when running in a cluster.
+// Changes are picked up through the invalidations right away so this only catches the ones lost while reconnecting
+const clusterSyncInterval = 5 * time.Minute
+
+// What the instances invalidate in the rest of the cluster
+const (
+	INVALIDATE_USER        = "user"
+	INVALIDATE_BLOCKLIST   = "blocklist"
+	INVALIDATE_FEATURES    = "features"
+	INVALIDATE_MAINTENANCE = "maintenance"
+)
+
+func newInstanceId() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "keycatd"
+	}
+	return fmt.Sprintf("%s-%s", host, util.GenerateRandomToken(6))
+}
+
+// joinCluster sets up the broadcaster and the leader election. Without a cluster this instance is always the leader
+func (ah *apiHandler) joinCluster(c Conf) (err error) {
+	if c.Cluster == nil {
+		ah.bcast = managers.NewInternalBroadcasterMgr()
+		ah.leader = managers.NewLeaderMgrSingle()
+		return nil
+	}
+	switch c.Cluster.Broker {
+	case CLUSTER_BROKER_REDIS:
+		ah.bcast, err = managers.NewClusterBroadcasterMgrRedis(c.Cluster.Redis.Server, ah.db, ah.instance)
+	default:
+		ah.bcast, err = managers.NewClusterBroadcasterMgrPostgres(c.DB, ah.db, ah.instance)
+	}
+	if err != nil {
+		return err
+	}
+	if c.DBType == db.TYPE_COCKROACHDB {
+		ah.leader = managers.NewLeaderMgrLease(ah.db, ah.instance)
+	} else {
+		ah.leader = managers.NewLeaderMgrPostgres(ah.db)
+	}
+	go ah.clusterSyncLoop()
+	log.Printf("Joined the cluster as %s using %s for broadcasts", ah.instance, c.Cluster.Broker)
+	return nil
+}
+
+// clusterSyncLoop picks up the user, blocklist, feature flag and maintenance changes done through other instances
+func (ah apiHandler) clusterSyncLoop() {
+	for {
+		select {
+		case <-ah.shutdown.done:
+			return
+		case inv := <-ah.bcast.Invalidations():
+			ah.applyInvalidation(inv)
+		case <-time.After(clusterSyncInterval):
+			if err := ah.reloadBlocklist(context.Background()); err != nil {
+				log.Printf("[ERROR] Could not reload the blocklist: %s", err)
+			}
+			if err := ah.reloadFeatureFlags(context.Background()); err != nil {
+				log.Printf("[ERROR] Could not reload the feature flags: %s", err)
+			}
+			if err := ah.reloadMaintenance(context.Background()); err != nil {
+				log.Printf("[ERROR] Could not reload the maintenance mode: %s", err)
+			}
+		}
+	}
+}
+
+func (ah apiHandler) applyInvalidation(inv managers.Invalidation) {
+	var err error
+	switch inv.Kind {
+	case INVALIDATE_USER:
+		ah.users.forget(inv.Key)
+	case INVALIDATE_BLOCKLIST:
+		err = ah.reloadBlocklist(context.Background())
+	case INVALIDATE_FEATURES:
+		err = ah.reloadFeatureFlags(context.Background())
+	case INVALIDATE_MAINTENANCE:
+		err = ah.reloadMaintenance(context.Background())
+	}
+	if err != nil {
+		log.Printf("[ERROR] Could not apply the invalidation of the %s: %s", inv.Kind, err)
+	}
+}
+
+// userChanged drops the cached user in every instance
+func (ah apiHandler) userChanged(uid string) {
+	ah.users.forget(uid)
+	ah.bcast.Invalidate(INVALIDATE_USER, uid)
+}
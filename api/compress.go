@@ -0,0 +1,199 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinSize is the smallest body that gets compressed. Smaller ones grow or barely shrink once the gzip header is added
+const compressMinSize = 1024
+
+// incompressibleTypes are already compressed so gzipping them again only burns cpu
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/octet-stream",
+}
+
+var gzipPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// acceptsEncoding checks if the Accept-Encoding header of the request allows the encoding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		if name := strings.TrimSpace(fields[0]); name != encoding && name != "*" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q <= 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressWriter gzips the responses for the clients that accept it. The body is buffered until it reaches
+// compressMinSize so the headers can still be changed once it's known if it's worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	gzipOk   bool
+	status   int
+	buf      []byte
+	decided  bool
+	hijacked bool
+	gz       *gzip.Writer
+}
+
+func newCompressWriter(w http.ResponseWriter, r *http.Request) *compressWriter {
+	return &compressWriter{ResponseWriter: w, gzipOk: acceptsEncoding(r, "gzip")}
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) >= compressMinSize || !cw.compressible() {
+			if err := cw.decide(false); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if cw.gz != nil {
+		return cw.gz.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// compressible checks if the response can be compressed at all. Responses that are already encoded or
+// that only carry a range of the content are left alone
+func (cw *compressWriter) compressible() bool {
+	switch {
+	case cw.status != 0 && cw.status < http.StatusOK,
+		cw.status == http.StatusNoContent,
+		cw.status == http.StatusPartialContent,
+		cw.status == http.StatusNotModified:
+		return false
+	}
+	h := cw.Header()
+	if len(h.Get("Content-Encoding")) > 0 || len(h.Get("Content-Range")) > 0 {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	if len(ct) == 0 {
+		if len(cw.buf) == 0 {
+			return true
+		}
+		//net/http would sniff the type from the compressed body so it's detected before compressing
+		ct = http.DetectContentType(cw.buf)
+		h.Set("Content-Type", ct)
+	}
+	for _, it := range incompressibleTypes {
+		if strings.HasPrefix(ct, it) {
+			return false
+		}
+	}
+	return true
+}
+
+// decide writes the headers and the buffered body. Bodies are compressed once they reach compressMinSize
+// and streams always are
+func (cw *compressWriter) decide(stream bool) error {
+	cw.decided = true
+	if cw.compressible() {
+		h := cw.Header()
+		h.Add("Vary", "Accept-Encoding")
+		if cw.gzipOk && (stream || len(cw.buf) >= compressMinSize) {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			cw.gz = gzipPool.Get().(*gzip.Writer)
+			cw.gz.Reset(cw.ResponseWriter)
+		}
+	}
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.gz != nil {
+		_, err = cw.gz.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what has been written so far. Streams get compressed from the first flush on even if they are still small
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("Response writer does not support hijacking")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		cw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// close writes whatever is still buffered and ends the compressed stream
+func (cw *compressWriter) close() {
+	if cw.hijacked {
+		return
+	}
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.gz != nil {
+		cw.gz.Close()
+		gzipPool.Put(cw.gz)
+		cw.gz = nil
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func compressedResponse(acceptEncoding, contentType string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/api/team", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	rec := httptest.NewRecorder()
+	cw := newCompressWriter(rec, r)
+	cw.Header().Set("Content-Type", contentType)
+	cw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	cw.WriteHeader(http.StatusOK)
+	//Written in two parts so the buffering is exercised
+	cw.Write(body[:len(body)/2])
+	cw.Write(body[len(body)/2:])
+	cw.close()
+	return rec
+}
+
+func TestCompressResponses(t *testing.T) {
+	big := bytes.Repeat([]byte(`{"id":"secret","data":"abcdef"},`), 100)
+	rec := compressedResponse("br, gzip;q=0.8", "application/json", big)
+	if rec.Header().Get("Content-Encoding") != "gzip" || len(rec.Header().Get("Content-Length")) > 0 {
+		t.Fatalf("Expected a gzipped response without length and got headers %v", rec.Header())
+	}
+	if rec.Body.Len() >= len(big)/5 {
+		t.Errorf("Expected %d bytes to compress well and got %d", len(big), rec.Body.Len())
+	}
+	gr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, big) {
+		t.Errorf("Decompressed body does not match")
+	}
+	checks := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           []byte
+		vary           bool
+	}{
+		{"small", "gzip", "application/json", []byte(`{"id":"secret"}`), true},
+		{"not accepted", "identity", "application/json", big, true},
+		{"disabled gzip", "gzip;q=0", "application/json", big, true},
+		{"already compressed", "gzip", "image/png", big, false},
+		{"blob", "gzip", "application/octet-stream", big, false},
+	}
+	for _, c := range checks {
+		rec := compressedResponse(c.acceptEncoding, c.contentType, c.body)
+		if len(rec.Header().Get("Content-Encoding")) > 0 {
+			t.Errorf("%s: expected no compression", c.name)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), c.body) {
+			t.Errorf("%s: body has been changed", c.name)
+		}
+		if (rec.Header().Get("Vary") == "Accept-Encoding") != c.vary {
+			t.Errorf("%s: expected vary to be set to %t", c.name, c.vary)
+		}
+	}
+}
+
+func TestCompressStream(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/admin/audit", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	cw := newCompressWriter(rec, r)
+	cw.Header().Set("Content-Type", "text/csv")
+	cw.Write([]byte("a,b\n"))
+	cw.Flush()
+	if rec.Header().Get("Content-Encoding") != "gzip" || !rec.Flushed {
+		t.Fatalf("Expected the stream to be compressed from the first flush")
+	}
+	cw.Write([]byte("c,d\n"))
+	cw.close()
+	gr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := ioutil.ReadAll(gr)
+	if err != nil || string(plain) != "a,b\nc,d\n" {
+		t.Errorf("Unexpected stream %q: %v", plain, err)
+	}
+}
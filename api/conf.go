@@ -2,7 +2,12 @@ package api
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/keydotcat/keycatd/db"
+	"github.com/keydotcat/keycatd/managers"
 	"github.com/keydotcat/keycatd/util"
 )
 
@@ -22,24 +27,281 @@ type ConfSessionRedis struct {
 	DBId   int
 }
 
+type ConfCsrfKey struct {
+	HashKey  string `mapstructure:"hash_key"`
+	BlockKey string `mapstructure:"block_key"`
+}
+
+// ConfCsrf has the key that signs the csrf cookies and the previous ones that are still accepted while they are rotated
 type ConfCsrf struct {
-	HashKey  string
-	BlockKey string
+	HashKey      string
+	BlockKey     string
+	PreviousKeys []ConfCsrfKey
+}
+
+// keys returns the current key first and then the previous ones
+func (cc ConfCsrf) keys() []ConfCsrfKey {
+	return append([]ConfCsrfKey{{cc.HashKey, cc.BlockKey}}, cc.PreviousKeys...)
+}
+
+// validate checks the lengths of the keys. The prefix is where they are in the configuration for the errors
+func (ck ConfCsrfKey) validate(prefix string) error {
+	if len(ck.HashKey) != 32 && len(ck.HashKey) != 64 {
+		return util.NewErrorf("Invalid %shash_key. It has to be 32 or 64 characters long", prefix)
+	}
+	bl := len(ck.BlockKey)
+	if bl != 0 && bl != 16 && bl != 24 && bl != 32 {
+		return util.NewErrorf("Invalid %sblock_key. It has to be 16, 24 or 32 characters long, or 0 to disable encryption", prefix)
+	}
+	return nil
+}
+
+type ConfMetrics struct {
+	Port  int
+	Token string
+}
+
+// ConfIdpHooks protects the endpoints that receive the events of the identity providers
+type ConfIdpHooks struct {
+	Token string
+}
+
+type ConfOIDCClient struct {
+	Id           string
+	Name         string
+	Secret       string
+	RedirectURIs []string `mapstructure:"redirect_uris"`
+}
+
+// ConfOIDC enables the OIDC provider for the configured clients. Without a key file the tokens are signed with a key
+// generated on start
+type ConfOIDC struct {
+	KeyFile string
+	Clients []ConfOIDCClient
+}
+
+const (
+	KMS_PROVIDER_FILE  = managers.KMS_FILE
+	KMS_PROVIDER_VAULT = managers.KMS_VAULT
+)
+
+type ConfKMSVault struct {
+	Address string
+	Token   string
+	Mount   string
+	Key     string
+}
+
+// ConfKMS says where the key that wraps the data keys of the sealed columns is. Without it nothing is sealed
+type ConfKMS struct {
+	Provider string
+	KeyFile  string
+	Vault    ConfKMSVault
+}
+
+// KeyWrapper creates the manager that wraps the data keys with the configured provider
+func (ck ConfKMS) KeyWrapper() (managers.KeyWrapMgr, error) {
+	switch ck.Provider {
+	case KMS_PROVIDER_FILE:
+		return managers.NewKeyWrapMgrFile(ck.KeyFile)
+	case KMS_PROVIDER_VAULT:
+		return managers.NewKeyWrapMgrVault(ck.Vault.Address, ck.Vault.Token, ck.Vault.Mount, ck.Vault.Key), nil
+	}
+	return nil, util.NewErrorf("Invalid kms.provider %s. It has to be %s or %s", ck.Provider, KMS_PROVIDER_FILE, KMS_PROVIDER_VAULT)
+}
+
+func (ck ConfKMS) validate() error {
+	switch ck.Provider {
+	case KMS_PROVIDER_FILE:
+		if len(ck.KeyFile) == 0 {
+			return util.NewErrorf("Invalid kms.key_file. It is required for the file kms")
+		}
+	case KMS_PROVIDER_VAULT:
+		if len(ck.Vault.Address) == 0 || len(ck.Vault.Token) == 0 || len(ck.Vault.Mount) == 0 || len(ck.Vault.Key) == 0 {
+			return util.NewErrorf("Invalid kms.vault. The address, token, mount and key are required")
+		}
+	default:
+		return util.NewErrorf("Invalid kms.provider %s. It has to be %s or %s", ck.Provider, KMS_PROVIDER_FILE, KMS_PROVIDER_VAULT)
+	}
+	return nil
+}
+
+// ConfKeyLog has the key that signs the heads of the log of the public keys of the users
+type ConfKeyLog struct {
+	KeyFile string
+}
+
+type ConfSentry struct {
+	DSN          string
+	Environment  string
+	ReportErrors bool
+}
+
+type ConfAuditSyslog struct {
+	Network string
+	Address string
+	Format  string
+}
+
+type ConfRateLimitRule struct {
+	Route  string
+	Method string
+	By     string
+	Limit  int
+	Window int
+}
+
+type ConfRateLimit struct {
+	Rules []ConfRateLimitRule
+	Redis *ConfSessionRedis
+}
+
+type ConfBlocklist struct {
+	AutoRoute    string
+	AutoTrips    int
+	AutoWindow   int
+	AutoDuration int
+}
+
+type ConfTLSACME struct {
+	Domains  []string
+	Email    string
+	CacheDir string
+	HTTPPort int
+}
+
+type ConfTLS struct {
+	CertFile string
+	KeyFile  string
+	ACME     *ConfTLSACME
+}
+
+type ConfCors struct {
+	AllowedOrigins   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int
+}
+
+type ConfBodyLimit struct {
+	Route  string
+	Method string
+	Max    int64
+}
+
+const (
+	CLUSTER_BROKER_POSTGRES = "postgres"
+	CLUSTER_BROKER_REDIS    = "redis"
+)
+
+type ConfCluster struct {
+	Broker string
+	Redis  *ConfSessionRedis
+}
+
+type ConfAudit struct {
+	RetentionDays     int
+	Syslog            *ConfAuditSyslog
+	CheckpointKeyFile string
+}
+
+// ConfRetention says how many days the retention job keeps the logins in the audit log, the versions of a secret
+// after a newer one replaced them and the finished webhook deliveries. 0 keeps them forever
+type ConfRetention struct {
+	LoginAttemptDays    int
+	SecretVersionDays   int
+	WebhookDeliveryDays int
+}
+
+type ConfCleanup struct {
+	TokenRetentionDays   int
+	SessionRetentionDays int
+}
+
+// ConfTokenLifetimes says how long confirmation tokens and invites are valid. 0 keeps them valid until they are purged
+type ConfTokenLifetimes struct {
+	VerificationHours int
+	InviteDays        int
+}
+
+// ConfLoginPolicy escalates the failed logins in a row of a user. After DelayAfter failures the next attempt has to wait
+// DelaySeconds, after CaptchaAfter it needs a captcha and after LockoutAfter the account is locked for LockoutMinutes.
+// Failures are forgotten after ResetHours. 0 disables any of them
+type ConfLoginPolicy struct {
+	DelayAfter     int
+	DelaySeconds   int
+	CaptchaAfter   int
+	LockoutAfter   int
+	LockoutMinutes int
+	ResetHours     int
+	Captcha        ConfCaptcha
+}
+
+// ConfCaptcha is a siteverify endpoint like the ones of hCaptcha, reCAPTCHA or Turnstile
+type ConfCaptcha struct {
+	VerifyUrl string
+	Secret    string
+}
+
+type ConfCache struct {
+	UserTTL              int
+	SessionWriteInterval int
+}
+
+// ConfWeb says where the web client is served from. The assets embedded in the binary are used without a dir
+type ConfWeb struct {
+	Disabled bool
+	Dir      string
+	//CSP replaces the Content-Security-Policy sent with the web client
+	CSP string
 }
 
 type Conf struct {
-	Url           string
-	Port          int
-	DB            string
-	DBMaxConns    int
-	DBType        string
-	OnlyInvited   bool
-	ProxyMode     bool
-	MailSMTP      *ConfMailSMTP
-	MailSparkpost *ConfMailSparkpost
-	MailFrom      string
-	SessionRedis  *ConfSessionRedis
-	Csrf          ConfCsrf
+	Url                string
+	Port               int
+	DB                 string
+	DBMaxConns         int
+	DBMaxIdleConns     int
+	DBConnMaxLifetime  int
+	DBStatementTimeout int
+	DBReplica          string
+	DBType             string
+	DBTxRetries        int
+	DBQueryTimeout     int
+	DBSkipMigrations   bool
+	OnlyInvited        bool
+	RejectPlaintext    bool
+	ShutdownTimeout    int
+	LogLevel           string
+	ProxyMode          bool
+	TrustedProxies     []string
+	MailSMTP           *ConfMailSMTP
+	MailSparkpost      *ConfMailSparkpost
+	MailFrom           string
+	SessionRedis       *ConfSessionRedis
+	Csrf               ConfCsrf
+	Metrics            ConfMetrics
+	IdpHooks           ConfIdpHooks
+	OIDC               ConfOIDC
+	KMS                *ConfKMS
+	KeyLog             ConfKeyLog
+	Sentry             ConfSentry
+	Audit              ConfAudit
+	Cleanup            ConfCleanup
+	Retention          ConfRetention
+	TokenLifetimes     ConfTokenLifetimes
+	LoginPolicy        ConfLoginPolicy
+	Cache              ConfCache
+	RateLimit          ConfRateLimit
+	Blocklist          ConfBlocklist
+	BodyLimits         []ConfBodyLimit
+	TLS                *ConfTLS
+	Cors               ConfCors
+	Cluster            *ConfCluster
+	JobSchedules       map[string]string
+	Web                ConfWeb
+	ClientVersions     map[string]string
 }
 
 func (c Conf) validate() error {
@@ -52,18 +314,29 @@ func (c Conf) validate() error {
 	if len(c.DB) == 0 {
 		return util.NewErrorf("Invalid db configuration")
 	}
-	if c.DBType != "postgresql" && c.DBType != "cockroackdb" {
+	if c.DBType != db.TYPE_POSTGRESQL && c.DBType != db.TYPE_COCKROACHDB {
 		return util.NewErrorf("Invalid db type (%s)", c.DBType)
 	}
+	if c.DBTxRetries < 0 {
+		return util.NewErrorf("Invalid db.tx_retries")
+	}
+	if c.DBQueryTimeout < 0 {
+		return util.NewErrorf("Invalid db.query_timeout")
+	}
+	if c.DBMaxConns < 0 || c.DBMaxIdleConns < 0 || c.DBConnMaxLifetime < 0 || c.DBStatementTimeout < 0 {
+		return util.NewErrorf("Invalid db pool settings. db.maxconns, db.max_idle_conns, db.conn_max_lifetime and db.statement_timeout can't be negative")
+	}
 	if len(c.MailFrom) == 0 {
 		return util.NewErrorf("Invalid mail.from")
 	}
-	if len(c.Csrf.HashKey) != 32 && len(c.Csrf.HashKey) != 64 {
-		return util.NewErrorf("Invalid csrf.hash_key. It has to be 32 or 64 characters long")
-	}
-	bl := len(c.Csrf.BlockKey)
-	if bl != 0 && bl != 16 && bl != 24 && bl != 32 {
-		return util.NewErrorf("Invalid csrf.block_key. It has to be 16, 24 or 32 characters long, or 0 to disable encryption")
+	for i, key := range c.Csrf.keys() {
+		prefix := "csrf."
+		if i > 0 {
+			prefix = fmt.Sprintf("csrf.previous_keys %d ", i-1)
+		}
+		if err := key.validate(prefix); err != nil {
+			return err
+		}
 	}
 	if !TEST_MODE {
 		smtp := c.MailSMTP != nil
@@ -78,6 +351,137 @@ func (c Conf) validate() error {
 			return util.NewErrorf("Invalid mail.sparkpost.key")
 		}
 	}
+	if c.Metrics.Port < 0 || (c.Metrics.Port > 0 && c.Metrics.Port == c.Port) {
+		return util.NewErrorf("Invalid metrics.port. It has to be different from the main port")
+	}
+	if len(c.LogLevel) > 0 && !util.ValidLogLevel(c.LogLevel) {
+		return util.NewErrorf("Invalid log_level %s. It has to be info or error", c.LogLevel)
+	}
+	if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+		return err
+	}
+	if c.ShutdownTimeout < 0 {
+		return util.NewErrorf("Invalid shutdown_timeout")
+	}
+	if c.Audit.RetentionDays < 0 {
+		return util.NewErrorf("Invalid audit.retention_days")
+	}
+	if rc := c.Retention; rc.LoginAttemptDays < 0 || rc.SecretVersionDays < 0 || rc.WebhookDeliveryDays < 0 {
+		return util.NewErrorf("Invalid retention. The retention days can't be negative")
+	}
+	if c.Cleanup.TokenRetentionDays < 0 || c.Cleanup.SessionRetentionDays < 0 {
+		return util.NewErrorf("Invalid cleanup. The retention days can't be negative")
+	}
+	if c.TokenLifetimes.VerificationHours < 0 || c.TokenLifetimes.InviteDays < 0 {
+		return util.NewErrorf("Invalid token_lifetimes. Neither token_lifetimes.verification_hours nor token_lifetimes.invite_days can be negative")
+	}
+	lp := c.LoginPolicy
+	if lp.DelayAfter < 0 || lp.DelaySeconds < 0 || lp.CaptchaAfter < 0 || lp.LockoutAfter < 0 || lp.LockoutMinutes < 0 || lp.ResetHours < 0 {
+		return util.NewErrorf("Invalid login_policy. None of the settings can be negative")
+	}
+	if (lp.DelayAfter > 0 && lp.DelaySeconds == 0) || (lp.LockoutAfter > 0 && lp.LockoutMinutes == 0) {
+		return util.NewErrorf("Invalid login_policy. The delay and the lockout need delay_seconds and lockout_minutes")
+	}
+	if lp.CaptchaAfter > 0 && (!strings.HasPrefix(lp.Captcha.VerifyUrl, "https://") || len(lp.Captcha.Secret) == 0) {
+		return util.NewErrorf("Invalid login_policy. The captcha needs an https login_policy.captcha.verify_url and a secret")
+	}
+	if c.Cache.UserTTL < 0 || c.Cache.SessionWriteInterval < 0 {
+		return util.NewErrorf("Invalid cache. Neither cache.user_ttl nor cache.session_write_interval can be negative")
+	}
+	for i, rl := range c.RateLimit.Rules {
+		if !strings.HasPrefix(rl.Route, "/") {
+			return util.NewErrorf("Invalid ratelimit.rules %d. The route has to start with /", i)
+		}
+		if rl.By != RATE_LIMIT_BY_IP && rl.By != RATE_LIMIT_BY_USER && rl.By != RATE_LIMIT_BY_TOKEN {
+			return util.NewErrorf("Invalid ratelimit.rules %d. It can only limit by ip, user or token", i)
+		}
+		if rl.Limit < 1 || rl.Window < 1 {
+			return util.NewErrorf("Invalid ratelimit.rules %d. Both limit and window have to be positive", i)
+		}
+	}
+	if c.Blocklist.AutoTrips > 0 && (!strings.HasPrefix(c.Blocklist.AutoRoute, "/") || c.Blocklist.AutoWindow < 1 || c.Blocklist.AutoDuration < 1) {
+		return util.NewErrorf("Invalid blocklist. The auto_route has to start with / and both auto_window and auto_duration have to be positive")
+	}
+	if c.TLS != nil {
+		files := len(c.TLS.CertFile) > 0 || len(c.TLS.KeyFile) > 0
+		switch {
+		case files && c.TLS.ACME != nil:
+			return util.NewErrorf("Either configure tls.cert_file and tls.key_file or tls.acme but not both")
+		case files && (len(c.TLS.CertFile) == 0 || len(c.TLS.KeyFile) == 0):
+			return util.NewErrorf("Both tls.cert_file and tls.key_file are required")
+		case !files && c.TLS.ACME == nil:
+			return util.NewErrorf("Invalid tls. Configure either the certificate files or tls.acme")
+		case c.TLS.ACME != nil && len(c.TLS.ACME.Domains) == 0:
+			return util.NewErrorf("Invalid tls.acme.domains. At least one domain is required")
+		case c.TLS.ACME != nil && len(c.TLS.ACME.CacheDir) == 0:
+			return util.NewErrorf("Invalid tls.acme.cache_dir. Certificates have to be stored somewhere to survive restarts")
+		case c.TLS.ACME != nil && (c.TLS.ACME.HTTPPort < 0 || c.TLS.ACME.HTTPPort == c.Port):
+			return util.NewErrorf("Invalid tls.acme.http_port. It has to be different from the main port")
+		}
+	}
+	for _, origin := range c.Cors.AllowedOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return util.NewErrorf("Invalid cors.allowed_origins %s. Origins have to start with http:// or https://, or be *", origin)
+		}
+	}
+	if c.Cors.MaxAge < 0 {
+		return util.NewErrorf("Invalid cors.max_age")
+	}
+	for i, bl := range c.BodyLimits {
+		if !strings.HasPrefix(bl.Route, "/") || bl.Max < 1 {
+			return util.NewErrorf("Invalid body_limits %d. The route has to start with / and max has to be positive", i)
+		}
+	}
+	if c.RateLimit.Redis != nil && len(c.RateLimit.Redis.Server) == 0 {
+		return util.NewErrorf("Invalid ratelimit.redis.server")
+	}
+	if c.Cluster != nil {
+		switch {
+		case c.Cluster.Broker != CLUSTER_BROKER_POSTGRES && c.Cluster.Broker != CLUSTER_BROKER_REDIS:
+			return util.NewErrorf("Invalid cluster.broker %s. It has to be postgres or redis", c.Cluster.Broker)
+		case c.Cluster.Broker == CLUSTER_BROKER_REDIS && (c.Cluster.Redis == nil || len(c.Cluster.Redis.Server) == 0):
+			return util.NewErrorf("Invalid cluster.redis.server. It is required to use redis as the cluster broker")
+		case c.Cluster.Broker == CLUSTER_BROKER_POSTGRES && c.DBType == db.TYPE_COCKROACHDB:
+			return util.NewErrorf("Invalid cluster.broker. Cockroach has no LISTEN/NOTIFY so it requires redis as the cluster broker")
+		case len(c.RateLimit.Rules) > 0 && c.RateLimit.Redis == nil:
+			return util.NewErrorf("Rate limits require ratelimit.redis in a cluster so all instances share the counters")
+		}
+	}
+	for name, spec := range c.JobSchedules {
+		if _, err := managers.ParseSchedule(spec); err != nil {
+			return util.NewErrorf("Invalid jobs.schedules.%s: %s", name, err)
+		}
+	}
+	for client, version := range c.ClientVersions {
+		if _, err := util.CompareVersions(version, version); err != nil {
+			return util.NewErrorf("Invalid clients.min_versions.%s: %s", client, err)
+		}
+	}
+	if len(c.Web.Dir) > 0 {
+		if _, err := os.Stat(filepath.Join(c.Web.Dir, "index.html")); err != nil {
+			return util.NewErrorf("Invalid web.dir. It has to have an index.html: %s", err)
+		}
+	}
+	clients := map[string]bool{}
+	for i, oc := range c.OIDC.Clients {
+		if len(oc.Id) == 0 || len(oc.Secret) == 0 || clients[oc.Id] {
+			return util.NewErrorf("Invalid oidc.clients %d. Every client needs a unique id and a secret", i)
+		}
+		clients[oc.Id] = true
+		if len(oc.RedirectURIs) == 0 {
+			return util.NewErrorf("Invalid oidc.clients %d. At least one redirect_uris is required", i)
+		}
+		for _, uri := range oc.RedirectURIs {
+			if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+				return util.NewErrorf("Invalid oidc.clients %d redirect uri %s. It has to start with http:// or https://", i, uri)
+			}
+		}
+	}
+	if c.KMS != nil {
+		if err := c.KMS.validate(); err != nil {
+			return err
+		}
+	}
 	if c.SessionRedis != nil && len(c.SessionRedis.Server) == 0 {
 		return util.NewErrorf("Invalid session.redis.server")
 	}
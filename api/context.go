@@ -15,6 +15,10 @@ const (
 	contextVaultKey   = contextType(iota)
 	contextSessionKey = contextType(iota)
 	contextCsrfKey    = contextType(iota)
+	contextRequestKey = contextType(iota)
+	contextBodyLimit  = contextType(iota)
+	contextApiVersion = contextType(iota)
+	contextBatchProof = contextType(iota)
 )
 
 func ctxAddUser(ctx context.Context, u *models.User) context.Context {
@@ -76,3 +80,45 @@ func ctxGetCsrf(ctx context.Context) string {
 	}
 	return d
 }
+
+func ctxAddRequestId(ctx context.Context, rid string) context.Context {
+	return context.WithValue(ctx, contextRequestKey, rid)
+}
+
+// ctxGetRequestId returns an empty string if there's no request id in the context
+func ctxGetRequestId(ctx context.Context) string {
+	d, _ := ctx.Value(contextRequestKey).(string)
+	return d
+}
+
+func ctxAddBodyLimit(ctx context.Context, max int64) context.Context {
+	return context.WithValue(ctx, contextBodyLimit, max)
+}
+
+func ctxGetBodyLimit(ctx context.Context) (int64, bool) {
+	d, ok := ctx.Value(contextBodyLimit).(int64)
+	return d, ok
+}
+
+func ctxAddApiVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, contextApiVersion, version)
+}
+
+// ctxGetApiVersion returns the version of the api the request was sent to
+func ctxGetApiVersion(ctx context.Context) string {
+	d, ok := ctx.Value(contextApiVersion).(string)
+	if !ok {
+		return API_VERSION_LEGACY
+	}
+	return d
+}
+
+// ctxAddBatchProof marks the requests of a batch whose session proof was already checked for the whole batch
+func ctxAddBatchProof(ctx context.Context, session string) context.Context {
+	return context.WithValue(ctx, contextBatchProof, session)
+}
+
+func ctxGetBatchProof(ctx context.Context) string {
+	d, _ := ctx.Value(contextBatchProof).(string)
+	return d
+}
@@ -10,11 +10,22 @@ import (
 const CSRF_COOKIE_NAME = "kc4d018d7e07"
 
 type csrf struct {
-	sc *securecookie.SecureCookie
+	//codecs has the current key first. The previous keys after it are only used to read the cookies signed with them
+	codecs []securecookie.Codec
+	//path of the cookie. The base path of the server so it's not sent to other apps in the same host
+	path string
 }
 
-func newCsrf(hKey, bKey []byte) csrf {
-	return csrf{securecookie.New(hKey, bKey)}
+func newCsrf(cc ConfCsrf, path string) csrf {
+	pairs := [][]byte{}
+	for _, key := range cc.keys() {
+		var blockKey []byte
+		if len(key.BlockKey) > 0 {
+			blockKey = []byte(key.BlockKey)
+		}
+		pairs = append(pairs, []byte(key.HashKey), blockKey)
+	}
+	return csrf{securecookie.CodecsFromPairs(pairs...), path}
 }
 
 func (c csrf) checkToken(w http.ResponseWriter, r *http.Request) (string, bool) {
@@ -35,8 +46,14 @@ func (c csrf) checkToken(w http.ResponseWriter, r *http.Request) (string, bool)
 func (c csrf) getToken(w http.ResponseWriter, r *http.Request) (string, bool) {
 	if cookie, err := r.Cookie(CSRF_COOKIE_NAME); err == nil {
 		var csrfToken string
-		if err = c.sc.Decode(CSRF_COOKIE_NAME, cookie.Value, &csrfToken); err == nil {
-			return csrfToken, false
+		for i, codec := range c.codecs {
+			if err = codec.Decode(CSRF_COOKIE_NAME, cookie.Value, &csrfToken); err == nil {
+				if i > 0 {
+					//Sign it again with the current key so the previous one can be retired without losing the token
+					c.setCookie(w, csrfToken)
+				}
+				return csrfToken, false
+			}
 		}
 	}
 	return c.generateNewToken(w), true
@@ -44,16 +61,20 @@ func (c csrf) getToken(w http.ResponseWriter, r *http.Request) (string, bool) {
 
 func (c csrf) generateNewToken(w http.ResponseWriter) string {
 	csrfToken := util.GenerateRandomToken(8)
-	if encoded, err := c.sc.Encode(CSRF_COOKIE_NAME, csrfToken); err == nil {
+	c.setCookie(w, csrfToken)
+	return csrfToken
+}
+
+func (c csrf) setCookie(w http.ResponseWriter, csrfToken string) {
+	if encoded, err := c.codecs[0].Encode(CSRF_COOKIE_NAME, csrfToken); err == nil {
 		cookie := &http.Cookie{
 			Name:     CSRF_COOKIE_NAME,
 			Value:    encoded,
-			Path:     "/",
+			Path:     c.path,
 			HttpOnly: true,
 		}
 		http.SetCookie(w, cookie)
 	} else {
 		panic(err)
 	}
-	return csrfToken
 }
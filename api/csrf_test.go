@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCsrfKeyRotation(t *testing.T) {
+	oldKey := ConfCsrfKey{"4d018d7e070ca9d5da7e767001bdaf90", "4e3797182c94f05b384c81ed0246f6b4"}
+	old := newCsrf(ConfCsrf{HashKey: oldKey.HashKey, BlockKey: oldKey.BlockKey}, "/")
+	w := httptest.NewRecorder()
+	token := old.generateNewToken(w)
+	cookie := w.Result().Cookies()[0]
+	rotated := newCsrf(ConfCsrf{HashKey: "0123456789abcdef0123456789abcdef", PreviousKeys: []ConfCsrfKey{oldKey}}, "/")
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	got, generated := rotated.getToken(w, r)
+	if generated || got != token {
+		t.Fatalf("The token signed with the previous key was not accepted (%s vs %s)", got, token)
+	}
+	resigned := w.Result().Cookies()
+	if len(resigned) != 1 || resigned[0].Value == cookie.Value {
+		t.Fatalf("The cookie was not signed again with the current key")
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(resigned[0])
+	w = httptest.NewRecorder()
+	if got, generated = rotated.getToken(w, r); generated || got != token || len(w.Result().Cookies()) > 0 {
+		t.Fatalf("The cookie signed with the current key was not accepted as it is")
+	}
+	retired := newCsrf(ConfCsrf{HashKey: "0123456789abcdef0123456789abcdef"}, "/")
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	if got, generated = retired.getToken(httptest.NewRecorder(), r); !generated || got == token {
+		t.Fatalf("The cookie of a retired key was accepted")
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// dbConnString adds the statement timeout in milliseconds to the connection string. lib/pq sends the options it doesn't
+// know as run time parameters so the timeout applies to every connection of the pool
+func dbConnString(dsn string, statementTimeout int) (string, error) {
+	if statementTimeout == 0 {
+		return dsn, nil
+	}
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return fmt.Sprintf("%s statement_timeout=%d", dsn, statementTimeout), nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", util.NewErrorf("Could not parse the db url: %s", err)
+	}
+	q := u.Query()
+	q.Set("statement_timeout", fmt.Sprintf("%d", statementTimeout))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// openDB opens a pool to the db with the limits of the configuration. Without max idle connections the pool keeps the
+// database/sql default
+func openDB(c Conf, dsn string) (*sql.DB, error) {
+	dsn, err := dbConnString(dsn, c.DBStatementTimeout)
+	if err != nil {
+		return nil, err
+	}
+	d, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	d.SetMaxOpenConns(c.DBMaxConns)
+	if c.DBMaxIdleConns > 0 {
+		d.SetMaxIdleConns(c.DBMaxIdleConns)
+	}
+	d.SetConnMaxLifetime(time.Duration(c.DBConnMaxLifetime) * time.Second)
+	return d, nil
+}
@@ -0,0 +1,25 @@
+package api
+
+import "testing"
+
+func TestDBConnString(t *testing.T) {
+	checks := []struct {
+		dsn      string
+		timeout  int
+		expected string
+	}{
+		{"dbname=keycat sslmode=disable", 0, "dbname=keycat sslmode=disable"},
+		{"dbname=keycat sslmode=disable", 5000, "dbname=keycat sslmode=disable statement_timeout=5000"},
+		{"postgres://user@db:5432/keycat?sslmode=disable", 5000, "postgres://user@db:5432/keycat?sslmode=disable&statement_timeout=5000"},
+		{"postgresql://db/keycat", 100, "postgresql://db/keycat?statement_timeout=100"},
+	}
+	for _, c := range checks {
+		got, err := dbConnString(c.dsn, c.timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.expected {
+			t.Errorf("Expected %s for %s with a timeout of %d and got %s", c.expected, c.dsn, c.timeout, got)
+		}
+	}
+}
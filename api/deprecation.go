@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// deprecatedRoute is a route clients should stop using. Routes are written without the version like the rate limits
+type deprecatedRoute struct {
+	route  string
+	method string
+	//sunset is when the route will stop being served. Zero if it's not known yet
+	sunset time.Time
+	//successor is the route that replaces it inside the current version, if any
+	successor string
+}
+
+// deprecatedRoutes get the deprecation headers and their use is counted in keycatd_deprecated_requests_total.
+// Add a route here when its replacement is released and remove it after its sunset once nobody uses it anymore
+var deprecatedRoutes = []deprecatedRoute{}
+
+// Only the first requests of each client to each deprecated route are logged so the logs aren't flooded
+const deprecationLogMax = 1000
+
+var deprecationLogged = struct {
+	lock *sync.Mutex
+	seen map[string]bool
+}{&sync.Mutex{}, map[string]bool{}}
+
+// findDeprecatedRoute returns the most specific deprecated route for the request or nil if it's not deprecated
+func findDeprecatedRoute(routes []deprecatedRoute, method, path string) *deprecatedRoute {
+	var best *deprecatedRoute
+	for i, dr := range routes {
+		if routeMatches(dr.route, dr.method, method, path) && (best == nil || len(dr.route) > len(best.route)) {
+			best = &routes[i]
+		}
+	}
+	return best
+}
+
+func setRouteDeprecationHeaders(w http.ResponseWriter, dr deprecatedRoute, base string) {
+	w.Header().Set("Deprecation", "true")
+	if !dr.sunset.IsZero() {
+		w.Header().Set("Sunset", dr.sunset.UTC().Format(http.TimeFormat))
+	}
+	if len(dr.successor) > 0 {
+		w.Header().Set("Link", "<"+base+"/api/"+API_VERSION_CURRENT+dr.successor+">; rel=\"successor-version\"")
+	}
+}
+
+// deprecationClient is the type of client for the metrics. Unknown types are grouped so clients can't make up labels
+func (ah apiHandler) deprecationClient(r *http.Request) string {
+	val := r.Header.Get(clientHeader)
+	if len(val) == 0 {
+		return "none"
+	}
+	client, _, err := parseClientHeader(val)
+	if _, known := ah.opts().clientVersions[client]; err != nil || !known {
+		return "other"
+	}
+	return client
+}
+
+// deprecate sets the deprecation headers of the request and counts it if it uses a deprecated route, version or path
+func (ah apiHandler) deprecate(w http.ResponseWriter, r *http.Request, version string, av apiVersion, legacy bool, path string) {
+	setDeprecationHeaders(w, av, legacy, ah.basePath, path)
+	var route string
+	switch dr := findDeprecatedRoute(deprecatedRoutes, r.Method, "/api"+path); {
+	case dr != nil:
+		setRouteDeprecationHeaders(w, *dr, ah.basePath)
+		route = dr.route
+	case legacy:
+		route = "unversioned"
+	case av.deprecated:
+		route = "/api/" + version
+	default:
+		return
+	}
+	ah.metrics.observeDeprecated(route, ah.deprecationClient(r))
+	if firstDeprecatedUse(route + " " + r.Header.Get(clientHeader)) {
+		requestLogf(r, "Deprecated %s %s used by client %q from %s", r.Method, route, r.Header.Get(clientHeader), r.Header.Get("User-Agent"))
+	}
+}
+
+func firstDeprecatedUse(key string) bool {
+	deprecationLogged.lock.Lock()
+	defer deprecationLogged.lock.Unlock()
+	if deprecationLogged.seen[key] || len(deprecationLogged.seen) >= deprecationLogMax {
+		return false
+	}
+	deprecationLogged.seen[key] = true
+	return true
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindDeprecatedRoute(t *testing.T) {
+	routes := []deprecatedRoute{
+		{route: "/api/team"},
+		{route: "/api/team/t1/vault", method: "POST"},
+	}
+	checks := []struct {
+		method string
+		path   string
+		route  string
+	}{
+		{"GET", "/api/team/t1", "/api/team"},
+		{"POST", "/api/team/t1/vault", "/api/team/t1/vault"},
+		{"GET", "/api/team/t1/vault", "/api/team"},
+		{"GET", "/api/teams", ""},
+	}
+	for _, c := range checks {
+		dr := findDeprecatedRoute(routes, c.method, c.path)
+		if (dr == nil && len(c.route) > 0) || (dr != nil && dr.route != c.route) {
+			t.Errorf("%s %s: expected %q and got %v", c.method, c.path, c.route, dr)
+		}
+	}
+}
+
+func TestDeprecatedRouteHeaders(t *testing.T) {
+	defer func(routes []deprecatedRoute) { deprecatedRoutes = routes }(deprecatedRoutes)
+	sunset := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	deprecatedRoutes = []deprecatedRoute{{route: "/api/user", method: "GET", sunset: sunset, successor: "/session"}}
+	loginDummyUser()
+	req, err := http.NewRequest("GET", strings.TrimSuffix(srv.URL, "/api")+"/api/v1/user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(clientHeader, "web/100.0.0")
+	r, err := httpDo(req)
+	CheckErrorAndResponse(t, r, err, 200)
+	if r.Header.Get("Deprecation") != "true" || r.Header.Get("Sunset") != "Wed, 02 Jan 2030 03:04:05 GMT" || r.Header.Get("Link") != `</api/v1/session>; rel="successor-version"` {
+		t.Errorf("Unexpected deprecation headers %v", r.Header)
+	}
+	apiH.metrics.lock.Lock()
+	count := apiH.metrics.deprecated[metricsDeprecatedKey{"/api/user", "web"}]
+	apiH.metrics.lock.Unlock()
+	if count == 0 {
+		t.Errorf("Expected the use of the deprecated route to be counted")
+	}
+}
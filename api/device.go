@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/keydotcat/keycatd/managers"
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+type deviceRevokedResponse struct {
+	Revoked []*models.DeviceRevocation `json:"revoked"`
+}
+
+type deviceRevokeRequest struct {
+	Name      string `json:"name"`
+	PublicKey []byte `json:"public_key"`
+	Reason    string `json:"reason"`
+}
+
+// /device
+func (ah apiHandler) deviceRoot(w http.ResponseWriter, r *http.Request) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if head != "revoked" || r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	switch r.Method {
+	case "GET":
+		return ah.deviceRevokedList(w, r)
+	case "POST":
+		return ah.deviceRevoke(w, r)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /device/revoked
+func (ah apiHandler) deviceRevokedList(w http.ResponseWriter, r *http.Request) error {
+	drs, err := models.FindDeviceRevocationsForUser(r.Context(), ctxGetUser(r.Context()).Id)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, deviceRevokedResponse{drs})
+}
+
+// POST /device/revoked
+func (ah apiHandler) deviceRevoke(w http.ResponseWriter, r *http.Request) error {
+	drr := &deviceRevokeRequest{}
+	if err := jsonDecode(w, r, 1024*4, drr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	dr, err := models.RevokeDeviceKey(ctx, u.Id, drr.Name, drr.PublicKey, drr.Reason)
+	if err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_DEVICE_REVOKE, auditObject("device", dr.Id))
+	if err := ah.deleteDeviceSessions(r, u.Id, dr.PublicKey); err != nil {
+		return err
+	}
+	//Every team of the user is told so its members stop sealing anything for the key
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range teams {
+		ah.bcast.Send(t.Id, "", managers.BCAST_ACTION_DEVICE_REVOKE, nil)
+	}
+	return jsonResponse(w, dr)
+}
+
+// deleteDeviceSessions logs out the sessions of the user that are bound to the revoked key, like paired extensions
+func (ah apiHandler) deleteDeviceSessions(r *http.Request, uid string, publicKey []byte) error {
+	sessions, err := ah.sm.GetAllSessions(uid)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		if len(s.DeviceKey) == 0 || !bytes.Equal(s.DeviceKey, publicKey) {
+			continue
+		}
+		if err := ah.sm.DeleteSession(s.Id); err != nil {
+			return err
+		}
+		ah.auditLog(r, AUDIT_SESSION_DELETE, auditObject("session", s.Id))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestRevokeDevice(t *testing.T) {
+	u := loginDummyUser()
+	pub := []byte(util.GenerateRandomToken(32))
+	r, err := PostRequest("/device/revoked", deviceRevokeRequest{"Firefox extension", pub, "Laptop stolen"})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest("/device/revoked", deviceRevokeRequest{"Firefox extension", pub, "Laptop stolen"})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = GetRequest("/device/revoked")
+	CheckErrorAndResponse(t, r, err, 200)
+	drr := &deviceRevokedResponse{}
+	if err := json.NewDecoder(r.Body).Decode(drr); err != nil {
+		t.Fatal(err)
+	}
+	if len(drr.Revoked) != 1 || drr.Revoked[0].User != u.Id || string(drr.Revoked[0].PublicKey) != string(pub) {
+		t.Fatalf("Unexpected revocation list %#v", drr.Revoked)
+	}
+	other := getDummyUser()
+	r, err = PostRequest("/device/revoked", deviceRevokeRequest{"Not mine", other.PublicKey, ""})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/session/pairing", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	pr := &pairingResponse{}
+	if err := json.NewDecoder(r.Body).Decode(pr); err != nil {
+		t.Fatal(err)
+	}
+	r, err = PostRequest("/auth/pairing", authPairingClaimRequest{pr.Code, "Firefox extension", pub})
+	CheckErrorAndResponse(t, r, err, 400)
+}
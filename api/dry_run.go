@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// queryDryRun reads the dry_run parameter of the destructive operations. Dry runs report what would be removed and
+// roll everything back
+func queryDryRun(r *http.Request) (bool, error) {
+	dryRun, err := queryBool(r, "dry_run")
+	if err != nil {
+		return false, err
+	}
+	return dryRun != nil && *dryRun, nil
+}
+
+// jobDryRuns are the jobs that can report what they would remove without running them
+func (ah apiHandler) jobDryRuns() map[string]func(context.Context) (*models.ChangeReport, error) {
+	return map[string]func(context.Context) (*models.ChangeReport, error){
+		JOB_CLEANUP: func(ctx context.Context) (*models.ChangeReport, error) {
+			return ah.purgeExpired(ctx, true)
+		},
+		JOB_BLOCKLIST_PURGE: func(ctx context.Context) (*models.ChangeReport, error) {
+			purged, err := models.PurgeExpiredIpBlocks(ctx, time.Now().UTC(), true)
+			if err != nil {
+				return nil, err
+			}
+			cr := models.NewChangeReport(true)
+			cr.Add(purged)
+			return cr, nil
+		},
+		JOB_RETENTION: func(ctx context.Context) (*models.ChangeReport, error) {
+			return ah.applyRetention(ctx, true)
+		},
+		JOB_ORPHAN_GC: func(ctx context.Context) (*models.ChangeReport, error) {
+			or, err := models.CollectOrphans(ctx, true)
+			if err != nil {
+				return nil, err
+			}
+			return &models.ChangeReport{DryRun: true, Total: or.Total, Affected: or.Orphans}, nil
+		},
+	}
+}
+
+// POST /admin/jobs/:name/run?dry_run=true
+func (ah apiHandler) adminJobDryRun(w http.ResponseWriter, r *http.Request, name string) error {
+	if _, err := ah.findJob(r, name); err != nil {
+		return err
+	}
+	dryRun, ok := ah.jobDryRuns()[name]
+	if !ok {
+		return util.NewErrorf("Job %s can't be dry run", name)
+	}
+	cr, err := dryRun(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, cr)
+}
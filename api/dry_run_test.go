@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestAdminDryRuns(t *testing.T) {
+	ctx := getCtx()
+	target := loginDummyUser()
+	teams, err := target.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vfs, err := teams[0].GetVaultsFullForUser(ctx, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/team/%s/vault/%s/user/%s", teams[0].Id, vfs[0].Id, target.Id)
+	r, err := DeleteRequest(path + "?dry_run=maybe")
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = DeleteRequest(path + "?dry_run=true")
+	CheckErrorAndResponse(t, r, err, 200)
+	cr := &models.ChangeReport{}
+	if err := json.NewDecoder(r.Body).Decode(cr); err != nil {
+		t.Fatal(err)
+	}
+	if !cr.DryRun || cr.Total != 0 {
+		t.Fatalf("Team admins are never removed from the vaults but got %#v", cr)
+	}
+	admin := loginDummyUser()
+	if err := admin.SetAdmin(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	r, err = DeleteRequest("/admin/user/" + target.Id + "?dry_run=true")
+	CheckErrorAndResponse(t, r, err, 200)
+	cr = &models.ChangeReport{}
+	if err := json.NewDecoder(r.Body).Decode(cr); err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]models.AffectedRows{}
+	for _, ar := range cr.Affected {
+		kinds[ar.Kind] = ar
+	}
+	if !cr.DryRun || kinds["team"].Count != 1 || kinds["team"].Ids[0] != teams[0].Id || kinds["session"].Count < 1 || len(kinds["session"].Ids) > 0 {
+		t.Fatalf("Unexpected report for the deletion of the user %#v", cr)
+	}
+	if _, err := models.FindUser(ctx, target.Id); err != nil {
+		t.Fatalf("Dry run deleted the user: %s", err)
+	}
+	r, err = PostRequest("/admin/jobs/"+JOB_ORPHAN_GC+"/run?dry_run=true", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	cr = &models.ChangeReport{}
+	if err := json.NewDecoder(r.Body).Decode(cr); err != nil {
+		t.Fatal(err)
+	}
+	if !cr.DryRun || len(cr.Affected) == 0 {
+		t.Fatalf("Unexpected report for the orphan collection %#v", cr)
+	}
+	r, err = PostRequest("/admin/jobs/"+JOB_VAULT_ACCESS_EXPIRY+"/run?dry_run=true", nil)
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest("/admin/jobs/nonexistant/run?dry_run=true", nil)
+	CheckErrorAndResponse(t, r, err, 404)
+}
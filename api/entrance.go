@@ -1,10 +1,13 @@
 package api
 
 import (
+	"context"
+	"crypto/ed25519"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/keydotcat/keycatd/db"
 	"github.com/keydotcat/keycatd/managers"
@@ -14,18 +17,39 @@ import (
 
 var TEST_MODE = false
 
-type apiOptions struct {
-	onlyInvited bool
-}
-
 type apiHandler struct {
 	db            *sql.DB
+	readDB        managers.ReadDBMgr
 	sm            managers.SessionMgr
+	blobs         managers.BlobMgr
 	mail          *mailer
 	csrf          csrf
 	staticHandler *StaticHandler
-	options       apiOptions
+	live          *liveConf
 	bcast         managers.BroadcasterMgr
+	leader        managers.LeaderMgr
+	jobs          managers.JobMgr
+	instance      string
+	webhooks      managers.WebhookMgr
+	matrix        managers.MatrixMgr
+	metrics       *metrics
+	migrations    *db.MigrateMgr
+	errors        managers.ErrorReportMgr
+	audit         managers.AuditMgr
+	maintenance   *maintenance
+	rateLimits    managers.RateLimitMgr
+	blocklist     *ipBlocklist
+	features      *featureFlags
+	oidc          *oidcSigner
+	keyLogKey     ed25519.PrivateKey
+	users         *userCache
+	sessionWrites time.Duration
+	shutdown      *shutdownState
+	metricsServer *http.Server
+	startedAt     time.Time
+	proxies       *trustedProxies
+	//basePath is where the server is mounted in its host, like /keycat. Empty in the root
+	basePath string
 }
 
 func NewAPIHandler(c Conf) (http.Handler, error) {
@@ -33,90 +57,286 @@ func NewAPIHandler(c Conf) (http.Handler, error) {
 	if err != nil {
 		return nil, err
 	}
-	ah := apiHandler{}
-	ah.bcast = managers.NewInternalBroadcasterMgr()
-	ah.options.onlyInvited = c.OnlyInvited
-	ah.db, err = sql.Open("postgres", c.DB)
+	ah := apiHandler{startedAt: time.Now().UTC(), instance: newInstanceId(), basePath: c.basePath()}
+	ah.live = newLiveConf(c)
+	if ah.proxies, err = newTrustedProxies(c.ProxyMode, c.TrustedProxies); err != nil {
+		return nil, err
+	}
+	if len(c.LogLevel) > 0 {
+		util.SetLogLevel(c.LogLevel)
+	}
+	ah.metrics = newMetrics()
+	ah.maintenance = newMaintenance()
+	ah.shutdown = newShutdownState()
+	if len(c.Sentry.DSN) > 0 {
+		ah.errors, err = managers.NewErrorReportMgrSentry(c.Sentry.DSN, util.GetServerVersion(), c.Sentry.Environment)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ah.errors = managers.NewErrorReportMgrNULL()
+	}
+	ah.db, err = openDB(c, c.DB)
 	if err != nil {
 		return nil, util.NewErrorf("Could not connect to db '%s': %s", c.DB, err)
 	}
-	ah.db.SetMaxOpenConns(c.DBMaxConns)
+	models.SetTxRetries(c.DBTxRetries)
+	models.SetRejectPlaintext(c.RejectPlaintext)
+	setTokenLifetimes(c.TokenLifetimes)
+	models.SetQueryTimeout(time.Duration(c.DBQueryTimeout) * time.Second)
+	if len(c.DBReplica) > 0 {
+		replica, err := openDB(c, c.DBReplica)
+		if err != nil {
+			return nil, util.NewErrorf("Could not connect to db replica '%s': %s", c.DBReplica, err)
+		}
+		ah.readDB = managers.NewReadDBMgrReplica(ah.db, replica)
+	} else {
+		ah.readDB = managers.NewReadDBMgrPrimary(ah.db)
+	}
 	m := db.NewMigrateMgr(ah.db, c.DBType)
 	if err := m.LoadMigrations(); err != nil {
 		panic(err)
 	}
-	lid, ap, err := m.ApplyRequiredMigrations()
-	if err != nil {
-		fmt.Println(util.GetStack(err))
-		panic(err)
+	if c.DBSkipMigrations {
+		pending, err := m.CheckIfMigrationIsRequired()
+		if err != nil {
+			return nil, err
+		}
+		if pending > 0 {
+			return nil, util.NewErrorf("There are %d pending db migrations. Run keycatd migrate up or enable auto_migrate", pending)
+		}
+	} else {
+		lid, ap, err := m.ApplyRequiredMigrations()
+		if err != nil {
+			fmt.Println(util.GetStack(err))
+			panic(err)
+		}
+		log.Printf("Executed migrations until %d (%d applied)", lid, ap)
+	}
+	ah.migrations = m
+	if err := EnableSealing(ah.db, c); err != nil {
+		return nil, err
+	}
+	if n, err := models.BackfillKeyLog(models.AddDBToContext(context.Background(), ah.db)); err != nil {
+		return nil, err
+	} else if n > 0 {
+		log.Printf("Added the public keys of %d users to the key log", n)
+	}
+	ah.blocklist = newIpBlocklist()
+	if err := ah.reloadBlocklist(context.Background()); err != nil {
+		return nil, err
+	}
+	ah.users = newUserCache(time.Duration(c.Cache.UserTTL) * time.Second)
+	ah.sessionWrites = time.Duration(c.Cache.SessionWriteInterval) * time.Second
+	ah.features = newFeatureFlags()
+	if err := ah.reloadFeatureFlags(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := ah.reloadMaintenance(context.Background()); err != nil {
+		return nil, err
 	}
-	log.Printf("Executed migrations until %d (%d applied)", lid, ap)
 	switch {
 	case TEST_MODE:
 		ah.mail, err = newMailer(c.Url, TEST_MODE, managers.NewMailMgrNULL())
-	case c.MailSMTP != nil:
-		ah.mail, err = newMailer(c.Url, TEST_MODE, managers.NewMailMgrSMTP(c.MailSMTP.Server, c.MailSMTP.User, c.MailSMTP.Password, c.MailFrom))
-	case c.MailSparkpost != nil:
-		ah.mail, err = newMailer(c.Url, TEST_MODE, managers.NewMailMgrSparkpost(c.MailSparkpost.Key, c.MailFrom, c.MailSparkpost.EU))
 	default:
+		if mailMgr := newMailMgr(c); mailMgr != nil {
+			ah.mail, err = newMailer(c.Url, TEST_MODE, mailMgr)
+		}
 	}
 	if err != nil {
 		return nil, util.NewErrorf("Could not create mailer: %s", err)
 	}
-	if c.SessionRedis != nil {
-		ah.sm, err = managers.NewSessionMgrRedis(c.SessionRedis.Server, c.SessionRedis.DBId)
-		if err != nil {
-			return nil, util.NewErrorf("Could not connect to redis at %s: %s", c.SessionRedis.Server, err)
+	if ah.sm, err = NewSessionMgr(c, ah.db, ah.readDB); err != nil {
+		return nil, err
+	}
+	ah.blobs = managers.NewBlobMgrDB(ah.db)
+	ah.csrf = newCsrf(c.Csrf, ah.basePath+"/")
+	ah.staticHandler = NewStaticHandler(c.Web)
+	ah.staticHandler.BasePath = ah.basePath
+	if ah.oidc, err = newOIDCSigner(c.OIDC.KeyFile); err != nil {
+		return nil, err
+	}
+	if len(c.KeyLog.KeyFile) > 0 {
+		if ah.keyLogKey, err = managers.LoadSigningKey(c.KeyLog.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if c.RateLimit.Redis != nil {
+		if ah.rateLimits, err = managers.NewRateLimitMgrRedis(c.RateLimit.Redis.Server, c.RateLimit.Redis.DBId); err != nil {
+			return nil, util.NewErrorf("Could not connect to redis at %s: %s", c.RateLimit.Redis.Server, err)
 		}
 	} else {
-		ah.sm = managers.NewSessionMgrDB(ah.db)
+		ah.rateLimits = managers.NewRateLimitMgrMemory()
+	}
+	if err := ah.joinCluster(c); err != nil {
+		return nil, err
 	}
-	var blockKey []byte
-	if len(c.Csrf.BlockKey) > 0 {
-		blockKey = []byte(c.Csrf.BlockKey)
+	ah.jobs = managers.NewJobMgr(ah.db, ah.leader, ah.instance, c.JobSchedules, c.DBType != db.TYPE_COCKROACHDB)
+	if err := ah.jobs.Register(JOB_BLOCKLIST_PURGE, "@hourly", purgeExpiredIpBlocks); err != nil {
+		return nil, err
+	}
+	if err := ah.jobs.Register(JOB_CLEANUP, "@hourly", ah.cleanupExpired); err != nil {
+		return nil, err
+	}
+	if err := ah.jobs.Register(JOB_VAULT_ACCESS_EXPIRY, "@hourly", ah.expireVaultAccess); err != nil {
+		return nil, err
+	}
+	if err := ah.jobs.Register(JOB_ORPHAN_GC, "@daily", collectOrphans); err != nil {
+		return nil, err
+	}
+	if err := ah.jobs.Register(JOB_RETENTION, "@hourly", ah.enforceRetention); err != nil {
+		return nil, err
+	}
+	ah.webhooks = managers.NewWebhookMgr(ah.db, ah.bcast, ah.leader, ah.featureFilter(FEATURE_WEBHOOKS))
+	ah.matrix = managers.NewMatrixMgr(ah.db, ah.bcast, ah.featureFilter(FEATURE_MATRIX))
+	var auditSinks []managers.AuditSink
+	if c.Audit.Syslog != nil {
+		sink, err := managers.NewAuditSinkSyslog(c.Audit.Syslog.Network, c.Audit.Syslog.Address, c.Audit.Syslog.Format)
+		if err != nil {
+			return nil, err
+		}
+		auditSinks = append(auditSinks, sink)
+	}
+	var checkpointKey ed25519.PrivateKey
+	if len(c.Audit.CheckpointKeyFile) > 0 {
+		if checkpointKey, err = managers.LoadSigningKey(c.Audit.CheckpointKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if ah.audit, err = managers.NewAuditMgr(checkpointKey, ah.jobs, auditSinks...); err != nil {
+		return nil, err
+	}
+	if c.Metrics.Port > 0 {
+		ah.metricsServer = ah.newMetricsServer(c.Metrics.Port)
+		go serveMetrics(ah.metricsServer)
 	}
-	ah.csrf = newCsrf([]byte(c.Csrf.HashKey), blockKey)
-	ah.staticHandler = NewStaticHandler()
 	return ah, nil
 }
 
+// NewSessionMgr creates the session manager the configuration asks for. Sessions follow the session policies of the teams
+func NewSessionMgr(c Conf, db *sql.DB, read managers.ReadDBMgr) (managers.SessionMgr, error) {
+	policy := func(userId string) (models.SessionPolicy, error) {
+		return models.GetSessionPolicy(models.AddReadDBToContext(models.AddDBToContext(context.Background(), db), read.DB()), userId)
+	}
+	if c.SessionRedis == nil {
+		return managers.NewSessionMgrDB(db, read, policy), nil
+	}
+	sm, err := managers.NewSessionMgrRedis(c.SessionRedis.Server, c.SessionRedis.DBId, policy)
+	if err != nil {
+		return nil, util.NewErrorf("Could not connect to redis at %s: %s", c.SessionRedis.Server, err)
+	}
+	return sm, nil
+}
+
 func (ah apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = withRequestId(w, r)
 	r = r.WithContext(models.AddDBToContext(r.Context(), ah.db))
+	inBase := stripBasePath(r, ah.basePath)
 	head, subPath := shiftPath(r.URL.Path)
+	//Machine clients read secrets from /machine/v1/... like they do from other secret stores. It's /api/v1/machine/...
+	if head == "machine" {
+		version, rest := shiftPath(subPath)
+		r.URL.Path = "/api/" + version + "/machine" + rest
+		head, subPath = shiftPath(r.URL.Path)
+	}
+	switch {
+	case head == "healthz":
+		ah.healthzRoot(w, r)
+		return
+	case head == "readyz":
+		ah.readyzRoot(w, r)
+		return
+	//Probes come from the infrastructure so they are never blocked and they can skip the base path
+	case !inBase:
+		http.NotFound(w, r)
+		return
+	case ah.blocklistBlock(w, r):
+		return
+	//Metrics are only served in the main listener when they are protected by a token
+	case head == "metrics" && len(ah.opts().metricsToken) > 0:
+		ah.metricsRoot(w, r)
+		return
+	}
+	path := r.URL.Path
+	route := metricsRoute(path)
+	cw := newCompressWriter(w, r)
+	mw := &metricsResponseWriter{ResponseWriter: cw}
+	start := time.Now()
+	defer func() {
+		if rec := recover(); rec != nil {
+			ah.recoverPanic(mw, r, path, rec)
+		}
+		cw.close()
+		if mw.status == 0 {
+			mw.status = http.StatusOK
+		}
+		ah.metrics.observeRequest(route, r.Method, mw.status, time.Since(start), mw.hijacked)
+	}()
 	if head == "api" {
-		r.URL.Path = subPath
-		ah.apiRoot(w, r)
+		version, rest, legacy := splitApiVersion(subPath)
+		//Rules are matched without the version so they apply to every version of a route
+		apiPath := "/api" + rest
+		if ah.rateLimitBlock(mw, r, apiPath, map[string]string{RATE_LIMIT_BY_IP: ah.clientIP(r)}) {
+			return
+		}
+		//Outdated clients can still read the versions they have to upgrade to
+		if sub, _ := shiftPath(rest); sub != "version" && ah.clientVersionBlock(mw, r) {
+			return
+		}
+		r = ah.withBodyLimit(r, apiPath)
+		r.URL.Path = rest
+		ah.apiRoot(mw, r, version, legacy)
 	} else {
-		ah.staticHandler.ServeHTTP(w, r)
+		ah.staticHandler.ServeHTTP(mw, r)
 	}
 }
 
-func (ah apiHandler) apiRoot(w http.ResponseWriter, r *http.Request) {
+func (ah apiHandler) apiRoot(w http.ResponseWriter, r *http.Request, version string, legacy bool) {
 	r = r.WithContext(models.AddDBToContext(r.Context(), ah.db))
+	if r.Method == "GET" {
+		//Only listings read from the replica and only in requests that don't write so nothing reads its own stale writes
+		r = r.WithContext(models.AddReadDBToContext(r.Context(), ah.readDB.DB()))
+	}
+	r = r.WithContext(ctxAddApiVersion(r.Context(), version))
+	path := r.URL.Path
 	var err error
-	head := ""
-	head, r.URL.Path = shiftPath(r.URL.Path)
-	//This is the non authenticated root
-	switch head {
-	case "auth":
-		err = ah.authRoot(w, r)
-	case "version":
-		err = ah.versionRoot(w, r)
-	default:
-		err = ah.authenticatedRoot(w, r, head)
+	if av, ok := apiVersions[version]; ok {
+		ah.deprecate(w, r, version, av, legacy, path)
+		err = av.root(ah, w, r)
+	} else {
+		err = util.NewErrorFrom(ErrNotFound)
 	}
 	if err != nil {
-		httpErr(w, err)
+		requestLogf(r, "%s %s: %s", r.Method, path, err)
+		if ah.opts().reportErrors {
+			ah.reportError(r, "/api"+path, err)
+		}
+		httpErr(w, r, err)
 	}
 }
 
 func (ah apiHandler) authenticatedRoot(w http.ResponseWriter, r *http.Request, head string) error {
 	//From here on you need to be authenticated
-	err := util.NewErrorFrom(ErrNotFound)
 	r = ah.authorizeRequest(w, r)
 	if r == nil {
 		return nil
 	}
+	//Sessions are kept but only admins can use the api during maintenance
+	if !(head == "admin" && ctxGetUser(r.Context()).Admin) && ah.maintenanceBlock(w, r) {
+		return nil
+	}
+	s := ctxGetSession(r.Context())
+	route := "/api/" + head + r.URL.Path
+	if ah.rateLimitBlock(w, r, route, map[string]string{RATE_LIMIT_BY_USER: s.User, RATE_LIMIT_BY_TOKEN: s.Id}) {
+		return nil
+	}
+	return ah.withIdempotency(w, r, route, func(w http.ResponseWriter, r *http.Request) error {
+		return ah.authenticatedRoute(w, r, head)
+	})
+}
+
+func (ah apiHandler) authenticatedRoute(w http.ResponseWriter, r *http.Request, head string) error {
+	err := util.NewErrorFrom(ErrNotFound)
 	switch head {
 	case "session":
 		err = ah.sessionRoot(w, r)
@@ -128,6 +348,32 @@ func (ah apiHandler) authenticatedRoot(w http.ResponseWriter, r *http.Request, h
 		err = ah.wsRoot(w, r)
 	case "eventsource":
 		err = ah.eventSourceRoot(w, r)
+	case "admin":
+		err = ah.adminRoot(w, r)
+	case "batch":
+		err = ah.batchRoot(w, r)
+	case "keylog":
+		err = ah.keyLogRoot(w, r)
+	case "device":
+		err = ah.deviceRoot(w, r)
+	case "account":
+		err = ah.accountRoot(w, r)
 	}
 	return err
 }
+
+// EnableSealing unwraps the data keys with the configured kms so the sensitive columns are sealed from now on. The
+// commands that read or write users, vaults or tokens need it as well
+func EnableSealing(db *sql.DB, c Conf) error {
+	if c.KMS == nil {
+		return nil
+	}
+	kms, err := c.KMS.KeyWrapper()
+	if err != nil {
+		return err
+	}
+	if err := models.EnableSealing(models.AddDBToContext(context.Background(), db), kms); err != nil {
+		return util.NewErrorf("Could not enable the sealing of the sensitive columns: %s", err)
+	}
+	return nil
+}
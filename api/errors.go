@@ -2,4 +2,25 @@ package api
 
 import "errors"
 
-var ErrNotFound = errors.New("Not found")
+var (
+	ErrNotFound = errors.New("Not found")
+	ErrInternal = errors.New("Internal server error")
+	//ErrPreconditionFailed is returned when the If-Match of a change isn't the current ETag of the resource
+	ErrPreconditionFailed = errors.New("The resource has changed since it was read")
+	//ErrTooManyAttempts and ErrCaptchaRequired come from the login policy
+	ErrTooManyAttempts = errors.New("Too many failed logins. Try again later")
+	ErrCaptchaRequired = errors.New("A valid captcha is required")
+)
+
+// internalError marks failures that are not caused by the request. Clients get a 500 without the details
+type internalError struct {
+	err error
+}
+
+func (ie internalError) Error() string {
+	return ie.err.Error()
+}
+
+func internalErr(err error) error {
+	return internalError{err}
+}
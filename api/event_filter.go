@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/keydotcat/keycatd/managers"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// validEventClasses are the classes of the actions the broadcaster emits
+var validEventClasses = map[string]bool{"vault": true, "secret": true, "device": true}
+
+// eventFilter restricts the events sent through the ws and eventsource streams.
+// Empty sets mean no restriction.
+type eventFilter struct {
+	classes map[string]bool
+	teams   map[string]bool
+	vaults  map[string]map[string]bool
+}
+
+func splitQueryList(r *http.Request, key string) []string {
+	var vals []string
+	for _, v := range r.URL.Query()[key] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				vals = append(vals, item)
+			}
+		}
+	}
+	return vals
+}
+
+// parseEventFilter reads ?events=secret,vault&teams=tid&vaults=tid:vid
+func parseEventFilter(r *http.Request) (eventFilter, error) {
+	ef := eventFilter{map[string]bool{}, map[string]bool{}, map[string]map[string]bool{}}
+	for _, c := range splitQueryList(r, "events") {
+		if !validEventClasses[c] {
+			return ef, util.NewErrorf("Invalid event class %s", c)
+		}
+		ef.classes[c] = true
+	}
+	for _, t := range splitQueryList(r, "teams") {
+		ef.teams[t] = true
+	}
+	for _, tv := range splitQueryList(r, "vaults") {
+		parts := strings.SplitN(tv, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return ef, util.NewErrorf("Invalid vault %s. It has to be team:vault", tv)
+		}
+		if _, ok := ef.vaults[parts[0]]; !ok {
+			ef.vaults[parts[0]] = map[string]bool{}
+		}
+		ef.vaults[parts[0]][parts[1]] = true
+	}
+	return ef, nil
+}
+
+// matchesVault accepts the vault if there is no scope filter, if the whole team has been
+// subscribed or if the vault itself has been subscribed
+func (ef eventFilter) matchesVault(team, vault string) bool {
+	if len(ef.teams) == 0 && len(ef.vaults) == 0 {
+		return true
+	}
+	return ef.teams[team] || ef.vaults[team][vault]
+}
+
+// matches always accepts device revocations so no filter keeps a client using a revoked key
+func (ef eventFilter) matches(action managers.BroadcastAction, team, vault string) bool {
+	if action == managers.BCAST_ACTION_DEVICE_REVOKE {
+		return true
+	}
+	if len(ef.classes) > 0 && !ef.classes[action.Class()] {
+		return false
+	}
+	return ef.matchesVault(team, vault)
+}
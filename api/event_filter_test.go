@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/keydotcat/keycatd/managers"
+)
+
+func TestEventFilter(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ws?events=secret&vaults=t1:v1,t1:v2&teams=t2", nil)
+	ef, err := parseEventFilter(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		action managers.BroadcastAction
+		team   string
+		vault  string
+		match  bool
+	}{
+		{managers.BCAST_ACTION_SECRET_NEW, "t1", "v1", true},
+		{managers.BCAST_ACTION_SECRET_CHANGE, "t1", "v2", true},
+		{managers.BCAST_ACTION_SECRET_NEW, "t1", "v3", false},
+		{managers.BCAST_ACTION_SECRET_REMOVE, "t2", "any", true},
+		{managers.BCAST_ACTION_SECRET_NEW, "t3", "v1", false},
+		{managers.BCAST_ACTION_VAULT_VERSION, "t1", "v1", false},
+		{managers.BCAST_ACTION_DEVICE_REVOKE, "t3", "", true},
+	}
+	for _, c := range checks {
+		if ef.matches(c.action, c.team, c.vault) != c.match {
+			t.Errorf("Expected filter match for %s %s:%s to be %t", c.action, c.team, c.vault, c.match)
+		}
+	}
+	ef, err = parseEventFilter(httptest.NewRequest("GET", "/ws", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ef.matches(managers.BCAST_ACTION_SECRET_NEW, "t", "v") {
+		t.Errorf("Empty filter has to match everything")
+	}
+	for _, class := range []string{"unknown", "team", "session"} {
+		if _, err = parseEventFilter(httptest.NewRequest("GET", "/ws?events="+class, nil)); err == nil {
+			t.Errorf("Expected an error for the event class %s that is never emitted", class)
+		}
+	}
+	if _, err = parseEventFilter(httptest.NewRequest("GET", "/ws?vaults=novault", nil)); err == nil {
+		t.Errorf("Expected an error for an invalid vault")
+	}
+}
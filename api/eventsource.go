@@ -69,12 +69,22 @@ func (e eventSourceSender) sendMessage(msg []byte) error {
 	return e.sendPayload(string(msg))
 }
 
-// /eventsource
+func (e eventSourceSender) close() error {
+	return e.conn.Close()
+}
+
+// /eventsource?events=secret&vaults=tid:vid
 func (ah apiHandler) eventSourceSubscribe(w http.ResponseWriter, r *http.Request) error {
+	ef, err := parseEventFilter(r)
+	if err != nil {
+		return err
+	}
 	ess, err := ah.makeEventSourceSender(w)
 	if err != nil {
 		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
 		return nil
 	}
-	return ah.broadcastEventListenLoop(r, ess)
+	ah.streamOpened("eventsource")
+	defer ah.streamClosed("eventsource")
+	return ah.broadcastEventListenLoop(r, ef, ess)
 }
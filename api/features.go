@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/keydotcat/keycatd/managers"
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	FEATURE_WEBHOOKS = "webhooks"
+	FEATURE_MATRIX   = "matrix"
+)
+
+// knownFeatures maps every feature that can be toggled to whether it's enabled when no flag overrides it
+var knownFeatures = map[string]bool{
+	FEATURE_WEBHOOKS: true,
+	FEATURE_MATRIX:   true,
+}
+
+type featureFlagKey struct {
+	name string
+	team string
+}
+
+// featureFlags keeps the overrides in memory so handlers can check them without hitting the db
+type featureFlags struct {
+	lock      *sync.RWMutex
+	overrides map[featureFlagKey]bool
+}
+
+func newFeatureFlags() *featureFlags {
+	return &featureFlags{lock: &sync.RWMutex{}, overrides: map[featureFlagKey]bool{}}
+}
+
+func (fl *featureFlags) set(ffs []*models.FeatureFlag) {
+	overrides := make(map[featureFlagKey]bool, len(ffs))
+	for _, ff := range ffs {
+		overrides[featureFlagKey{ff.Name, ff.Team}] = ff.Enabled
+	}
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+	fl.overrides = overrides
+}
+
+// enabled checks the team override first, then the global one and falls back to the default of the feature
+func (fl *featureFlags) enabled(name, team string) bool {
+	fl.lock.RLock()
+	defer fl.lock.RUnlock()
+	if enabled, ok := fl.overrides[featureFlagKey{name, team}]; ok {
+		return enabled
+	}
+	if enabled, ok := fl.overrides[featureFlagKey{name, ""}]; ok {
+		return enabled
+	}
+	return knownFeatures[name]
+}
+
+func (ah apiHandler) featureEnabled(name, team string) bool {
+	return ah.features.enabled(name, team)
+}
+
+func (ah apiHandler) featureFilter(name string) managers.TeamFilter {
+	return func(team string) bool {
+		return ah.featureEnabled(name, team)
+	}
+}
+
+func (ah apiHandler) reloadFeatureFlags(ctx context.Context) error {
+	ffs, err := models.GetFeatureFlags(models.AddDBToContext(ctx, ah.db))
+	if err != nil {
+		return err
+	}
+	ah.features.set(ffs)
+	return nil
+}
+
+func sortedFeatureNames() []string {
+	names := make([]string, 0, len(knownFeatures))
+	for name := range knownFeatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GET /team/:tid/features
+func (ah apiHandler) teamFeatures(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	features := make(map[string]bool, len(knownFeatures))
+	for name := range knownFeatures {
+		features[name] = ah.featureEnabled(name, t.Id)
+	}
+	return jsonResponse(w, features)
+}
+
+// /admin/features
+func (ah apiHandler) adminFeaturesRoot(w http.ResponseWriter, r *http.Request) error {
+	var name string
+	name, r.URL.Path = shiftPath(r.URL.Path)
+	if len(name) == 0 {
+		if r.Method == "GET" {
+			return ah.adminFeaturesList(w, r)
+		}
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	if _, ok := knownFeatures[name]; !ok {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	switch r.Method {
+	case "PUT":
+		return ah.adminFeatureSet(w, r, name)
+	case "DELETE":
+		return ah.adminFeatureDelete(w, r, name)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type adminFeature struct {
+	Name      string                `json:"name"`
+	Default   bool                  `json:"default"`
+	Enabled   bool                  `json:"enabled"`
+	Overrides []*models.FeatureFlag `json:"overrides"`
+}
+
+// GET /admin/features
+func (ah apiHandler) adminFeaturesList(w http.ResponseWriter, r *http.Request) error {
+	ffs, err := models.GetFeatureFlags(r.Context())
+	if err != nil {
+		return err
+	}
+	features := []*adminFeature{}
+	byName := map[string]*adminFeature{}
+	for _, name := range sortedFeatureNames() {
+		af := &adminFeature{Name: name, Default: knownFeatures[name], Enabled: ah.featureEnabled(name, ""), Overrides: []*models.FeatureFlag{}}
+		features = append(features, af)
+		byName[name] = af
+	}
+	for _, ff := range ffs {
+		//Flags of features that have been removed are kept but ignored
+		if af, ok := byName[ff.Name]; ok {
+			af.Overrides = append(af.Overrides, ff)
+		}
+	}
+	return jsonResponse(w, features)
+}
+
+type adminFeatureSetRequest struct {
+	Team    string `json:"team"`
+	Enabled bool   `json:"enabled"`
+}
+
+// PUT /admin/features/:name
+func (ah apiHandler) adminFeatureSet(w http.ResponseWriter, r *http.Request, name string) error {
+	afr := &adminFeatureSetRequest{}
+	if err := jsonDecode(w, r, 1024, afr); err != nil {
+		return err
+	}
+	ff := &models.FeatureFlag{Name: name, Team: afr.Team, Enabled: afr.Enabled, UpdatedBy: ctxGetUser(r.Context()).Id}
+	if err := models.SetFeatureFlag(r.Context(), ff); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_ADMIN_FEATURE_SET, featureFlagObject(ff))
+	ah.bcast.Invalidate(INVALIDATE_FEATURES, "")
+	if err := ah.reloadFeatureFlags(r.Context()); err != nil {
+		return err
+	}
+	return jsonResponse(w, ff)
+}
+
+// DELETE /admin/features/:name?team=:tid removes the override so the feature goes back to the global setting or the default
+func (ah apiHandler) adminFeatureDelete(w http.ResponseWriter, r *http.Request, name string) error {
+	ff, err := models.FindFeatureFlag(r.Context(), name, r.URL.Query().Get("team"))
+	if err != nil {
+		return err
+	}
+	if err := ff.Delete(r.Context()); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_ADMIN_FEATURE_DEL, featureFlagObject(ff))
+	ah.bcast.Invalidate(INVALIDATE_FEATURES, "")
+	if err := ah.reloadFeatureFlags(r.Context()); err != nil {
+		return err
+	}
+	return jsonResponse(w, ff)
+}
+
+func featureFlagObject(ff *models.FeatureFlag) string {
+	if len(ff.Team) == 0 {
+		return auditObject("feature", ff.Name)
+	}
+	return auditObject("team", ff.Team, "feature", ff.Name)
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestFeatureFlagsResolution(t *testing.T) {
+	fl := newFeatureFlags()
+	fl.set([]*models.FeatureFlag{
+		{Name: FEATURE_WEBHOOKS, Enabled: false},
+		{Name: FEATURE_WEBHOOKS, Team: "beta", Enabled: true},
+		{Name: FEATURE_MATRIX, Team: "quiet", Enabled: false},
+	})
+	checks := []struct {
+		name     string
+		team     string
+		expected bool
+	}{
+		{FEATURE_WEBHOOKS, "", false},
+		{FEATURE_WEBHOOKS, "other", false},
+		{FEATURE_WEBHOOKS, "beta", true},
+		{FEATURE_MATRIX, "other", true},
+		{FEATURE_MATRIX, "quiet", false},
+		{"unknown", "", false},
+	}
+	for _, c := range checks {
+		if got := fl.enabled(c.name, c.team); got != c.expected {
+			t.Errorf("Expected %s for team '%s' to be %t and got %t", c.name, c.team, c.expected, got)
+		}
+	}
+}
+
+func TestAdminFeatures(t *testing.T) {
+	u := loginDummyUser()
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	r, err := PutRequest("/admin/features/"+FEATURE_WEBHOOKS, adminFeatureSetRequest{Team: team.Id})
+	CheckErrorAndResponse(t, r, err, 401)
+	if err := u.SetAdmin(getCtx(), true); err != nil {
+		t.Fatal(err)
+	}
+	r, err = PutRequest("/admin/features/nonexistant", adminFeatureSetRequest{})
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = PutRequest("/admin/features/"+FEATURE_WEBHOOKS, adminFeatureSetRequest{Team: "nonexistant"})
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = PutRequest("/admin/features/"+FEATURE_WEBHOOKS, adminFeatureSetRequest{Team: team.Id})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(fmt.Sprintf("/team/%s/webhook", team.Id))
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = GetRequest(fmt.Sprintf("/team/%s/features", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	features := map[string]bool{}
+	if err := json.NewDecoder(r.Body).Decode(&features); err != nil {
+		t.Fatal(err)
+	}
+	if features[FEATURE_WEBHOOKS] || !features[FEATURE_MATRIX] {
+		t.Errorf("Unexpected features for the team %#v", features)
+	}
+	r, err = GetRequest("/admin/features")
+	CheckErrorAndResponse(t, r, err, 200)
+	afs := []*adminFeature{}
+	if err := json.NewDecoder(r.Body).Decode(&afs); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, af := range afs {
+		found = found || (af.Name == FEATURE_WEBHOOKS && af.Enabled && len(af.Overrides) == 1 && af.Overrides[0].Team == team.Id)
+	}
+	if !found {
+		t.Errorf("Team override is not listed")
+	}
+	r, err = DeleteRequest(fmt.Sprintf("/admin/features/%s?team=%s", FEATURE_WEBHOOKS, team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(fmt.Sprintf("/team/%s/webhook", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+type healthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+func writeHealthResponse(w http.ResponseWriter, code int, hr healthResponse) {
+	b := util.BufPool.Get()
+	defer util.BufPool.Put(b)
+	json.NewEncoder(b).Encode(hr)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.Bytes())))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	b.WriteTo(w)
+}
+
+// GET /healthz
+func (ah apiHandler) healthzRoot(w http.ResponseWriter, r *http.Request) {
+	writeHealthResponse(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+func (ah apiHandler) checkDB(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	return ah.db.PingContext(ctx)
+}
+
+func (ah apiHandler) readinessChecks(ctx context.Context) (bool, map[string]healthCheck) {
+	ready := true
+	checks := map[string]healthCheck{}
+	fail := func(name string, err error) {
+		ready = false
+		checks[name] = healthCheck{Status: "fail", Error: err.Error()}
+	}
+	if err := ah.checkDB(ctx); err != nil {
+		fail("db", err)
+		fail("migrations", fmt.Errorf("Database is not reachable"))
+	} else {
+		checks["db"] = healthCheck{Status: "ok"}
+		if _, pending, err := ah.migrations.CheckInstalled(); err != nil {
+			fail("migrations", err)
+		} else if pending > 0 {
+			fail("migrations", fmt.Errorf("%d migrations pending", pending))
+		} else {
+			checks["migrations"] = healthCheck{Status: "ok"}
+		}
+	}
+	if ah.mail == nil {
+		fail("mail", fmt.Errorf("No mail was configured"))
+	} else {
+		checks["mail"] = healthCheck{Status: "ok"}
+	}
+	return ready, checks
+}
+
+// GET /readyz
+// The probe doesn't need a session, so the errors are only logged and the response just names the failing checks
+func (ah apiHandler) readyzRoot(w http.ResponseWriter, r *http.Request) {
+	ready, checks := ah.readinessChecks(r.Context())
+	for name, check := range checks {
+		if len(check.Error) > 0 {
+			requestLogf(r, "[ERROR] Readiness check %s failed: %s", name, check.Error)
+			checks[name] = healthCheck{Status: check.Status}
+		}
+	}
+	if !ready {
+		writeHealthResponse(w, http.StatusServiceUnavailable, healthResponse{"unavailable", checks})
+		return
+	}
+	writeHealthResponse(w, http.StatusOK, healthResponse{"ok", checks})
+}
+
+type adminStatusDB struct {
+	OpenConnections int `json:"open_connections"`
+	InUse           int `json:"in_use"`
+	Idle            int `json:"idle"`
+	MaxOpen         int `json:"max_open_connections"`
+	LastMigration   int `json:"last_migration"`
+}
+
+type adminStatusResponse struct {
+	Status         string                 `json:"status"`
+	Checks         map[string]healthCheck `json:"checks"`
+	Version        string                 `json:"version"`
+	WebVersion     string                 `json:"web_version"`
+	StartedAt      time.Time              `json:"started_at"`
+	UptimeSecs     int64                  `json:"uptime_secs"`
+	DB             adminStatusDB          `json:"db"`
+	Sessions       int                    `json:"sessions"`
+	EventStreams   map[string]int64       `json:"event_streams"`
+	MailQueueDepth int32                  `json:"mail_queue_depth"`
+	Instance       string                 `json:"instance"`
+	Leader         bool                   `json:"leader"`
+}
+
+// GET /admin/status
+func (ah apiHandler) adminStatus(w http.ResponseWriter, r *http.Request) error {
+	ready, checks := ah.readinessChecks(r.Context())
+	asr := adminStatusResponse{
+		Status:       "ok",
+		Checks:       checks,
+		Version:      util.GetServerVersion(),
+		WebVersion:   util.GetWebVersion(),
+		StartedAt:    ah.startedAt,
+		UptimeSecs:   int64(time.Since(ah.startedAt).Seconds()),
+		EventStreams: ah.metrics.streamCounts(),
+		Instance:     ah.instance,
+		Leader:       ah.leader.IsLeader(),
+	}
+	if !ready {
+		asr.Status = "unavailable"
+	}
+	stats := ah.db.Stats()
+	asr.DB = adminStatusDB{stats.OpenConnections, stats.InUse, stats.Idle, stats.MaxOpenConnections, 0}
+	if checks["migrations"].Status == "ok" {
+		asr.DB.LastMigration, _, _ = ah.migrations.CheckInstalled()
+	}
+	sessions, err := ah.sm.CountSessions()
+	if err != nil {
+		return err
+	}
+	asr.Sessions = sessions
+	if ah.mail != nil {
+		asr.MailQueueDepth = ah.mail.queueDepth()
+	}
+	return jsonResponse(w, asr)
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+type healthReportRequest struct {
+	Total  int `json:"total"`
+	Weak   int `json:"weak"`
+	Reused int `json:"reused"`
+	Old    int `json:"old"`
+}
+
+// /team/:tid/health
+func (ah apiHandler) teamHealthRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if len(head) > 0 {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	switch r.Method {
+	case "GET":
+		return ah.teamHealthGet(w, r, t)
+	case "PUT":
+		return ah.teamHealthReport(w, r, t)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /team/:tid/health
+func (ah apiHandler) teamHealthGet(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	ctx := r.Context()
+	th, err := t.GetHealth(ctx, ctxGetUser(ctx))
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, th)
+}
+
+// PUT /team/:tid/health
+// The client counts the weak, reused and old passwords in the secrets of the team it can open. Archived secrets are not
+// in the default listings so they don't count. Only the counts are sent
+func (ah apiHandler) teamHealthReport(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	hrr := &healthReportRequest{}
+	if err := jsonDecode(w, r, 1024, hrr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	hr := &models.HealthReport{Total: hrr.Total, Weak: hrr.Weak, Reused: hrr.Reused, Old: hrr.Old}
+	if err := t.SetHealthReport(ctx, ctxGetUser(ctx), hr); err != nil {
+		return err
+	}
+	return jsonResponse(w, hr)
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestTeamHealth(t *testing.T) {
+	admin := loginDummyUser()
+	teams, err := admin.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/team/" + teams[0].Id + "/health"
+	r, err := PutRequest(path, healthReportRequest{Total: 10, Weak: 11})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PutRequest(path, healthReportRequest{Total: 10, Weak: 2, Reused: 3, Old: 1})
+	CheckErrorAndResponse(t, r, err, 200)
+	getHealth := func() *models.TeamHealth {
+		r, err := GetRequest(path)
+		CheckErrorAndResponse(t, r, err, 200)
+		th := &models.TeamHealth{}
+		if err := json.NewDecoder(r.Body).Decode(th); err != nil {
+			t.Fatal(err)
+		}
+		return th
+	}
+	if th := getHealth(); th.Reporting != 1 || th.Total != 0 {
+		t.Fatalf("The counts of a single member should be hidden: %#v", th)
+	}
+	for i := 0; i < models.HEALTH_MIN_REPORTS-1; i++ {
+		member := getDummyUser()
+		r, err = PostRequest("/team/"+teams[0].Id+"/user", teamInviteUserRequest{Invite: member.Email})
+		CheckErrorAndResponse(t, r, err, 200)
+		if err := teams[0].SetHealthReport(getCtx(), member, &models.HealthReport{Total: 5, Weak: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	th := getHealth()
+	if th.Members != models.HEALTH_MIN_REPORTS || th.Reporting != models.HEALTH_MIN_REPORTS || th.Total != 20 || th.Weak != 4 || th.Reused != 3 || th.Old != 1 {
+		t.Fatalf("Unexpected team health %#v", th)
+	}
+	member := getDummyUser()
+	r, err = PostRequest("/team/"+teams[0].Id+"/user", teamInviteUserRequest{Invite: member.Email})
+	CheckErrorAndResponse(t, r, err, 200)
+	s, err := apiH.sm.NewSession(member.Id, "1.1.1.1", "none", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = s.Id
+	r, err = GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 401)
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHealthAndReadiness(t *testing.T) {
+	root := strings.TrimSuffix(srv.URL, "/api")
+	r, err := http.Get(root + "/healthz")
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = http.Get(root + "/readyz")
+	CheckErrorAndResponse(t, r, err, 200)
+	hr := &healthResponse{}
+	if err := json.NewDecoder(r.Body).Decode(hr); err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range []string{"db", "migrations", "mail"} {
+		if hr.Checks[check].Status != "ok" {
+			t.Errorf("Expected check %s to be ok: %#v", check, hr.Checks[check])
+		}
+	}
+}
+
+func TestAdminStatus(t *testing.T) {
+	u := loginDummyUser()
+	r, err := GetRequest("/admin/status")
+	CheckErrorAndResponse(t, r, err, 401)
+	if err := u.SetAdmin(getCtx(), true); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest("/admin/status")
+	CheckErrorAndResponse(t, r, err, 200)
+	asr := &adminStatusResponse{}
+	if err := json.NewDecoder(r.Body).Decode(asr); err != nil {
+		t.Fatal(err)
+	}
+	if asr.Status != "ok" || asr.DB.LastMigration == 0 || asr.Sessions < 1 {
+		t.Errorf("Unexpected status %#v", asr)
+	}
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"fmt"
+	"io"
 	"net/http"
 	"path"
 	"strconv"
@@ -25,19 +28,67 @@ func jsonErr(w http.ResponseWriter) {
 	http.Error(w, "Could not decode JSON data", http.StatusBadRequest)
 }
 
-func httpErr(w http.ResponseWriter, err error) bool {
+type internalErrorResponse struct {
+	Error     string `json:"error"`
+	RequestId string `json:"request_id,omitempty"`
+}
+
+func isTimeoutErr(err error) bool {
+	if ie, ok := err.(internalError); ok {
+		err = ie.err
+	}
+	return models.IsTimeoutErr(err)
+}
+
+func httpErr(w http.ResponseWriter, r *http.Request, err error) bool {
 	if err == nil {
 		return false
 	}
 	buf := util.BufPool.Get()
 	defer util.BufPool.Put(buf)
-	json.NewEncoder(buf).Encode(err)
+	if isTimeoutErr(err) {
+		requestLogf(r, "[ERROR] %s", err)
+		json.NewEncoder(buf).Encode(internalErrorResponse{models.ErrTimeout.Error(), ctxGetRequestId(r.Context())})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(buf.String())))
+		w.WriteHeader(http.StatusGatewayTimeout)
+		buf.WriteTo(w)
+		return true
+	}
+	if ie, ok := err.(internalError); ok {
+		requestLogf(r, "[ERROR] %s", ie.err)
+		json.NewEncoder(buf).Encode(internalErrorResponse{ErrInternal.Error(), ctxGetRequestId(r.Context())})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(buf.String())))
+		w.WriteHeader(http.StatusInternalServerError)
+		buf.WriteTo(w)
+		return true
+	}
+	if ebtl, ok := getErrBodyTooLarge(err); ok {
+		json.NewEncoder(buf).Encode(bodyTooLargeResponse{ebtl.Error(), ebtl.limit, ctxGetRequestId(r.Context())})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(buf.String())))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		buf.WriteTo(w)
+		return true
+	}
+	json.NewEncoder(buf).Encode(errWithRequestId(r, err))
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("Content-Length", strconv.Itoa(len(buf.String())))
 	if util.CheckErr(err, ErrNotFound) || util.CheckErr(err, models.ErrDoesntExist) {
 		w.WriteHeader(http.StatusNotFound)
 	} else if util.CheckErr(err, models.ErrUnauthorized) {
 		w.WriteHeader(http.StatusUnauthorized)
+	} else if util.CheckErr(err, models.ErrMustResetPassword) || util.CheckErr(err, ErrCaptchaRequired) {
+		w.WriteHeader(http.StatusForbidden)
+	} else if util.CheckErr(err, ErrTooManyAttempts) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	} else if util.CheckErr(err, models.ErrConflict) || util.CheckErr(err, models.ErrOwnsSharedTeams) {
+		w.WriteHeader(http.StatusConflict)
+	} else if util.CheckErr(err, models.ErrAlreadyUsed) || util.CheckErr(err, models.ErrExpired) {
+		w.WriteHeader(http.StatusGone)
+	} else if util.CheckErr(err, ErrPreconditionFailed) {
+		w.WriteHeader(http.StatusPreconditionFailed)
 	} else if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -46,21 +97,144 @@ func httpErr(w http.ResponseWriter, err error) bool {
 }
 
 func jsonResponse(w http.ResponseWriter, obj interface{}) error {
+	return jsonResponseWithStatus(w, http.StatusOK, obj)
+}
+
+func jsonResponseWithStatus(w http.ResponseWriter, status int, obj interface{}) error {
 	b := util.BufPool.Get()
 	defer util.BufPool.Put(b)
 	if err := json.NewEncoder(b).Encode(obj); err != nil {
-		panic(err)
+		return internalErr(err)
 	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("Content-Length", strconv.Itoa(len(b.Bytes())))
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	b.WriteTo(w)
 	return nil
 }
 
+// jsonListStream writes an object with a single list like {"secrets":[...]} encoding the items as they are
+// produced so big listings are never kept in memory. The response is sent chunked as it has no known length
+type jsonListStream struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	field   string
+	started bool
+	//next is the cursor of the next page. It's written after the list
+	next string
+}
+
+func newJSONListStream(w http.ResponseWriter, field string) *jsonListStream {
+	return &jsonListStream{w: w, enc: json.NewEncoder(w), field: field}
+}
+
+func (ls *jsonListStream) start() {
+	ls.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	ls.w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(ls.w, `{"%s":[`, ls.field)
+	ls.started = true
+}
+
+func (ls *jsonListStream) write(item interface{}) error {
+	if !ls.started {
+		ls.start()
+	} else if _, err := io.WriteString(ls.w, ","); err != nil {
+		return err
+	}
+	return ls.enc.Encode(item)
+}
+
+// close ends the list. Errors before the first item are returned as usual. Once the list has been started the
+// status can't be changed anymore so the response is cut to let the client know it's incomplete
+func (ls *jsonListStream) close(r *http.Request, err error) error {
+	if err != nil {
+		if !ls.started {
+			return err
+		}
+		requestLogf(r, "[ERROR] Aborting the %s listing: %s", ls.field, err)
+		panic(http.ErrAbortHandler)
+	}
+	if !ls.started {
+		ls.start()
+	}
+	if len(ls.next) > 0 {
+		next, _ := json.Marshal(ls.next)
+		_, err = fmt.Fprintf(ls.w, "],\"next_cursor\":%s}\n", next)
+		return err
+	}
+	_, err = io.WriteString(ls.w, "]}\n")
+	return err
+}
+
+// checkETag sets the ETag of the response and answers with a 304 if the client already has it.
+// Returns true if the request has been answered
+func checkETag(w http.ResponseWriter, r *http.Request, etag string) bool {
+	etag = `"` + etag + `"`
+	w.Header().Set("ETag", etag)
+	for _, val := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if val = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(val), "W/")); val == etag || val == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// resourceETag is the ETag of the json of a resource. The resource has to always be encoded in the same order
+func resourceETag(obj interface{}) (string, error) {
+	b := util.BufPool.Get()
+	defer util.BufPool.Put(b)
+	if err := json.NewEncoder(b).Encode(obj); err != nil {
+		return "", internalErr(err)
+	}
+	sum := sha256.Sum256(b.Bytes())
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// jsonResponseWithETag sends the resource with its ETag so clients can check if it changed and make conditional changes
+func jsonResponseWithETag(w http.ResponseWriter, r *http.Request, obj interface{}) error {
+	etag, err := resourceETag(obj)
+	if err != nil {
+		return err
+	}
+	if checkETag(w, r, etag) {
+		return nil
+	}
+	return jsonResponse(w, obj)
+}
+
+// checkIfMatch fails with ErrPreconditionFailed if the request has an If-Match that isn't the current ETag of the
+// resource. The resource is only loaded if the header is there
+func checkIfMatch(r *http.Request, load func() (interface{}, error)) error {
+	ifMatch := r.Header.Get("If-Match")
+	if len(ifMatch) == 0 {
+		return nil
+	}
+	obj, err := load()
+	if err != nil {
+		return err
+	}
+	etag, err := resourceETag(obj)
+	if err != nil {
+		return err
+	}
+	for _, val := range strings.Split(ifMatch, ",") {
+		if val = strings.TrimSpace(val); val == `"`+etag+`"` || val == "*" {
+			return nil
+		}
+	}
+	return util.NewErrorFrom(ErrPreconditionFailed)
+}
+
 func jsonDecode(w http.ResponseWriter, r *http.Request, max int64, obj interface{}) error {
+	if limit, ok := ctxGetBodyLimit(r.Context()); ok {
+		max = limit
+	}
 	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, max)).Decode(obj); err != nil {
-		log.Printf("[ERROR] Could not parse json: %s", err)
+		if err.Error() == bodyTooLargeMessage {
+			return util.NewErrorFrom(errBodyTooLarge{max})
+		}
+		requestLogf(r, "[ERROR] Could not parse json: %s", err)
 		return util.NewErrorf("Could not parse request. Probably malformed")
 	}
 	return nil
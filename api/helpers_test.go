@@ -103,6 +103,15 @@ func PostRequestRaw(path string, data []byte, header http.Header) (*http.Respons
 	return httpDo(req)
 }
 
+func PutRequestRaw(path string, data []byte) (*http.Response, error) {
+	req, err := http.NewRequest("PUT", srv.URL+path, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/octet-stream")
+	return httpDo(req)
+}
+
 func PostRequest(path string, obj interface{}) (*http.Response, error) {
 	body, err := json.Marshal(obj)
 	if err != nil {
@@ -131,6 +140,20 @@ func PatchRequest(path string, obj interface{}) (*http.Response, error) {
 	return httpDo(req)
 }
 
+func PutRequest(path string, obj interface{}) (*http.Response, error) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("PUT", srv.URL+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = http.Header{}
+	req.Header.Add("Content-Type", "application/json")
+	return httpDo(req)
+}
+
 func GetRequest(path string) (*http.Response, error) {
 	req, err := http.NewRequest("GET", srv.URL+path, nil)
 	if err != nil {
@@ -187,7 +210,7 @@ func getCookieJar() http.CookieJar {
 	if len(activeCsrfToken) == 0 {
 		activeCsrfToken = "dummy"
 	}
-	val, err := apiH.csrf.sc.Encode(CSRF_COOKIE_NAME, activeCsrfToken)
+	val, err := apiH.csrf.codecs[0].Encode(CSRF_COOKIE_NAME, activeCsrfToken)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	idempotencyHeader         = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	//Bodies larger than the largest create can't be retried with a key
+	idempotencyMaxRequest = 1024 * 1024
+	//Larger responses are sent but not kept, so retries run the request again
+	idempotencyMaxResponse = 64 * 1024
+)
+
+// idempotencyRecorder passes the response through and keeps a copy to replay it
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (ir *idempotencyRecorder) WriteHeader(status int) {
+	if ir.status == 0 {
+		ir.status = status
+	}
+	ir.ResponseWriter.WriteHeader(status)
+}
+
+func (ir *idempotencyRecorder) Write(b []byte) (int, error) {
+	if ir.status == 0 {
+		ir.status = http.StatusOK
+	}
+	if ir.body.Len() <= idempotencyMaxResponse {
+		ir.body.Write(b)
+	}
+	return ir.ResponseWriter.Write(b)
+}
+
+// idempotencyRequestHash identifies the request a key was used for so the key can't be reused for another one
+func idempotencyRequestHash(r *http.Request, route string, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+route+"?"+r.URL.RawQuery+"\x00")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// withIdempotency runs a POST with an Idempotency-Key only once per key of the user. Retries with the same key get the
+// response of the first request back. Only successful responses are kept so failed requests can be retried
+func (ah apiHandler) withIdempotency(w http.ResponseWriter, r *http.Request, route string, next func(http.ResponseWriter, *http.Request) error) error {
+	key := r.Header.Get(idempotencyHeader)
+	if r.Method != "POST" || len(key) == 0 {
+		return next(w, r)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, idempotencyMaxRequest+1))
+	if err != nil {
+		return util.NewErrorFrom(err)
+	}
+	if len(body) > idempotencyMaxRequest {
+		return util.NewErrorFrom(errBodyTooLarge{idempotencyMaxRequest})
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	ctx := r.Context()
+	ik, claimed, err := models.ClaimIdempotencyKey(ctx, ctxGetUser(ctx).Id, key, idempotencyRequestHash(r, route, body))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return replayIdempotent(w, r, ik, route, body)
+	}
+	ir := &idempotencyRecorder{ResponseWriter: w}
+	finished := false
+	defer func() {
+		//The handler panicked. The panic goes on but the key can't stay claimed until it expires or every retry conflicts
+		if !finished {
+			ah.releaseIdempotencyKey(r, ik)
+		}
+	}()
+	err = next(ir, r)
+	finished = true
+	if err != nil || ir.status < 200 || ir.status >= 300 || ir.body.Len() > idempotencyMaxResponse {
+		ah.releaseIdempotencyKey(r, ik)
+		return err
+	}
+	if err := ah.completeIdempotencyKey(ik, ir); err != nil {
+		//The response was sent already. Retries run the request again instead of conflicting until the key expires
+		requestLogf(r, "[ERROR] Could not store the response of the idempotency key: %s", err)
+		ah.releaseIdempotencyKey(r, ik)
+	}
+	return nil
+}
+
+// completeIdempotencyKey stores the response for the retries. Like the release it doesn't use the context of the
+// request, since the client can give up once the response has been written
+func (ah apiHandler) completeIdempotencyKey(ik *models.IdempotencyKey, ir *idempotencyRecorder) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = util.NewErrorf("Recovered from panic: %v", rec)
+		}
+	}()
+	return ik.Complete(models.AddDBToContext(context.Background(), ah.db), ir.status, ir.Header().Get("Content-Type"), ir.body.Bytes())
+}
+
+// releaseIdempotencyKey lets the key be claimed again. It doesn't use the context of the request since it's
+// cancelled when the client gives up, and that is also when the key has to be released
+func (ah apiHandler) releaseIdempotencyKey(r *http.Request, ik *models.IdempotencyKey) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			requestLogf(r, "[ERROR] Could not release idempotency key: %v", rec)
+		}
+	}()
+	if err := ik.Release(models.AddDBToContext(context.Background(), ah.db)); err != nil {
+		requestLogf(r, "[ERROR] Could not release idempotency key: %s", err)
+	}
+}
+
+func replayIdempotent(w http.ResponseWriter, r *http.Request, ik *models.IdempotencyKey, route string, body []byte) error {
+	if ik.RequestHash != idempotencyRequestHash(r, route, body) {
+		return util.NewErrorf("The %s has already been used for another request", idempotencyHeader)
+	}
+	if ik.Running() {
+		return util.NewErrorFrom(models.ErrConflict)
+	}
+	if len(ik.ContentType) > 0 {
+		w.Header().Set("Content-Type", ik.ContentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(ik.Body)))
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(ik.Status)
+	w.Write(ik.Body)
+	return nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+func postWithHeaders(path string, obj interface{}, headers map[string]string) (*http.Response, error) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", srv.URL+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return httpDo(req)
+}
+
+func TestIdempotentCreate(t *testing.T) {
+	u := loginDummyUser()
+	vkp := getDummyVaultKeyPair(getUserPrivateKeys(u.PublicKey, u.Key), u.Id)
+	tcr := teamCreateRequest{util.GenerateRandomToken(5), vkp}
+	key := map[string]string{idempotencyHeader: util.GenerateRandomToken(20)}
+	r, err := postWithHeaders("/team", tcr, key)
+	CheckErrorAndResponse(t, r, err, 200)
+	first := &models.TeamFull{}
+	if err := json.NewDecoder(r.Body).Decode(first); err != nil {
+		t.Fatal(err)
+	}
+	r, err = postWithHeaders("/team", tcr, key)
+	CheckErrorAndResponse(t, r, err, 200)
+	if r.Header.Get(idempotencyReplayedHeader) != "true" {
+		t.Errorf("The response was not replayed")
+	}
+	retry := &models.TeamFull{}
+	if err := json.NewDecoder(r.Body).Decode(retry); err != nil {
+		t.Fatal(err)
+	}
+	if retry.Id != first.Id {
+		t.Fatalf("The retry created team %s instead of returning %s", retry.Id, first.Id)
+	}
+	tcr.Name = util.GenerateRandomToken(5)
+	r, err = postWithHeaders("/team", tcr, key)
+	CheckErrorAndResponse(t, r, err, 400)
+}
+
+func TestIdempotencyKeyReleasedOnError(t *testing.T) {
+	loginDummyUser()
+	key := map[string]string{idempotencyHeader: util.GenerateRandomToken(20)}
+	r, err := postWithHeaders("/team", teamCreateRequest{}, key)
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = postWithHeaders("/team", teamCreateRequest{}, key)
+	CheckErrorAndResponse(t, r, err, 400)
+	if r.Header.Get(idempotencyReplayedHeader) != "" {
+		t.Errorf("A failed response was replayed")
+	}
+}
+
+func TestIdempotencyKeyReleasedOnPanic(t *testing.T) {
+	ctx := ctxAddUser(getCtx(), getDummyUser())
+	key := util.GenerateRandomToken(20)
+	newReq := func() *http.Request {
+		req := httptest.NewRequest("POST", "/team", bytes.NewBufferString("{}")).WithContext(ctx)
+		req.Header.Set(idempotencyHeader, key)
+		return req
+	}
+	func() {
+		defer func() {
+			if rec := recover(); rec == nil {
+				t.Errorf("Expected the panic of the handler to go on")
+			}
+		}()
+		apiH.withIdempotency(httptest.NewRecorder(), newReq(), "/team", func(w http.ResponseWriter, r *http.Request) error {
+			panic("boom")
+		})
+	}()
+	ran := false
+	err := apiH.withIdempotency(httptest.NewRecorder(), newReq(), "/team", func(w http.ResponseWriter, r *http.Request) error {
+		ran = true
+		return jsonResponse(w, map[string]string{})
+	})
+	if err != nil || !ran {
+		t.Errorf("Expected the key to be released after the panic (%v)", err)
+	}
+}
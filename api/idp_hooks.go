@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Okta events that mean the user can't use anything anymore
+var oktaDeactivationEvents = map[string]bool{
+	"user.lifecycle.deactivate":       true,
+	"user.lifecycle.suspend":          true,
+	"user.lifecycle.delete.initiated": true,
+}
+
+const IDP_EVENT_USER_DEACTIVATE = "user.deactivate"
+
+type idpHookResponse struct {
+	Disabled []string `json:"disabled"`
+	//Unknown counts the users of the events that don't have an account here
+	Unknown int `json:"unknown"`
+}
+
+// /idp
+func (ah apiHandler) idpRoot(w http.ResponseWriter, r *http.Request) error {
+	token := ah.opts().idpHooksToken
+	if len(token) == 0 {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	switch {
+	case head == "okta" && r.Method == "GET":
+		return ah.idpOktaVerify(w, r)
+	case head == "okta" && r.Method == "POST":
+		return ah.idpOktaEvents(w, r)
+	case head == "events" && r.Method == "POST":
+		return ah.idpEvents(w, r)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type idpOktaVerifyResponse struct {
+	Verification string `json:"verification"`
+}
+
+// GET /idp/okta
+func (ah apiHandler) idpOktaVerify(w http.ResponseWriter, r *http.Request) error {
+	//Okta checks that the hook is ours by asking to send the challenge back when it's created
+	challenge := r.Header.Get("X-Okta-Verification-Challenge")
+	if len(challenge) == 0 {
+		return util.NewErrorf("Missing X-Okta-Verification-Challenge header")
+	}
+	return jsonResponse(w, idpOktaVerifyResponse{challenge})
+}
+
+type idpOktaTarget struct {
+	Type        string `json:"type"`
+	AlternateId string `json:"alternateId"`
+}
+
+type idpOktaEvent struct {
+	EventType string          `json:"eventType"`
+	Target    []idpOktaTarget `json:"target"`
+}
+
+type idpOktaRequest struct {
+	Data struct {
+		Events []idpOktaEvent `json:"events"`
+	} `json:"data"`
+}
+
+// POST /idp/okta
+func (ah apiHandler) idpOktaEvents(w http.ResponseWriter, r *http.Request) error {
+	oer := &idpOktaRequest{}
+	if err := jsonDecode(w, r, 1024*1024, oer); err != nil {
+		return err
+	}
+	emails := []string{}
+	for _, ev := range oer.Data.Events {
+		if !oktaDeactivationEvents[ev.EventType] {
+			continue
+		}
+		for _, target := range ev.Target {
+			if target.Type == "User" && len(target.AlternateId) > 0 {
+				emails = append(emails, target.AlternateId)
+			}
+		}
+	}
+	resp, err := ah.idpDisableUsers(r, "okta", emails)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, resp)
+}
+
+type idpEvent struct {
+	Type  string `json:"type"`
+	Email string `json:"email"`
+}
+
+type idpEventsRequest struct {
+	Events []idpEvent `json:"events"`
+}
+
+// POST /idp/events
+func (ah apiHandler) idpEvents(w http.ResponseWriter, r *http.Request) error {
+	ier := &idpEventsRequest{}
+	if err := jsonDecode(w, r, 1024*1024, ier); err != nil {
+		return err
+	}
+	emails := []string{}
+	for i, ev := range ier.Events {
+		if ev.Type != IDP_EVENT_USER_DEACTIVATE {
+			return util.NewErrorf("Event %d has an invalid type %s. Only %s is supported", i, ev.Type, IDP_EVENT_USER_DEACTIVATE)
+		}
+		emails = append(emails, ev.Email)
+	}
+	resp, err := ah.idpDisableUsers(r, "events", emails)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, resp)
+}
+
+// idpDisableUsers disables the users with the emails and logs out all their sessions. Emails without a user are counted
+// as unknown since the identity provider has users that never used keycat
+func (ah apiHandler) idpDisableUsers(r *http.Request, provider string, emails []string) (idpHookResponse, error) {
+	resp := idpHookResponse{Disabled: []string{}}
+	for _, email := range emails {
+		u, err := models.FindUserByEmail(r.Context(), strings.TrimSpace(email))
+		if util.CheckErr(err, models.ErrDoesntExist) {
+			resp.Unknown++
+			continue
+		} else if err != nil {
+			return resp, err
+		}
+		if !u.IsDisabled() {
+			if err := u.SetDisabled(r.Context(), true); err != nil {
+				return resp, err
+			}
+			ah.userChanged(u.Id)
+			ah.auditLogAs(r, "idp:"+provider, AUDIT_IDP_USER_DISABLE, auditObject("user", u.Id))
+		}
+		//Sessions are removed even if the user was already disabled in case one was left behind
+		if err := ah.sm.DeleteAllSessions(u.Id); err != nil {
+			return resp, err
+		}
+		resp.Disabled = append(resp.Disabled, u.Id)
+	}
+	return resp, nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func idpRequest(method, path string, obj interface{}, token string) (*http.Response, error) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Okta-Verification-Challenge", "challenge")
+	return http.DefaultClient.Do(req)
+}
+
+func TestIdpOktaDeactivation(t *testing.T) {
+	u := getDummyUser()
+	s, err := apiH.sm.NewSession(u.Id, "1.1.1.1", "none", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := idpRequest("GET", "/idp/okta", nil, "idp-test-token")
+	CheckErrorAndResponse(t, r, err, 200)
+	ovr := &idpOktaVerifyResponse{}
+	if err := json.NewDecoder(r.Body).Decode(ovr); err != nil {
+		t.Fatal(err)
+	}
+	if ovr.Verification != "challenge" {
+		t.Errorf("Unexpected verification %s", ovr.Verification)
+	}
+	oer := &idpOktaRequest{}
+	oer.Data.Events = []idpOktaEvent{
+		{"user.session.start", []idpOktaTarget{{"User", u.Email}}},
+		{"user.lifecycle.deactivate", []idpOktaTarget{{"User", u.Email}, {"User", "nobody@nowhere.net"}}},
+	}
+	r, err = idpRequest("POST", "/idp/okta", oer, "wrong")
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = idpRequest("POST", "/idp/okta", oer, "idp-test-token")
+	CheckErrorAndResponse(t, r, err, 200)
+	resp := &idpHookResponse{}
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Disabled) != 1 || resp.Disabled[0] != u.Id || resp.Unknown != 1 {
+		t.Fatalf("Unexpected response %#v", resp)
+	}
+	du, err := models.FindUser(getCtx(), u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !du.IsDisabled() {
+		t.Errorf("The user was not disabled")
+	}
+	if _, err := apiH.sm.GetSession(s.Id); err == nil {
+		t.Errorf("The session of the user was not removed")
+	}
+}
+
+func TestIdpEvents(t *testing.T) {
+	r, err := idpRequest("POST", "/idp/events", idpEventsRequest{[]idpEvent{{"user.create", "nobody@nowhere.net"}}}, "idp-test-token")
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = idpRequest("POST", "/idp/events", idpEventsRequest{[]idpEvent{{IDP_EVENT_USER_DEACTIVATE, "nobody@nowhere.net"}}}, "idp-test-token")
+	CheckErrorAndResponse(t, r, err, 200)
+	resp := &idpHookResponse{}
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Disabled) != 0 || resp.Unknown != 1 {
+		t.Fatalf("Unexpected response %#v", resp)
+	}
+}
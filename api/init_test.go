@@ -45,6 +45,11 @@ func initSRV() {
 			HashKey:  "4d018d7e070ca9d5da7e767001bdaf90",
 			BlockKey: "4e3797182c94f05b384c81ed0246f6b4",
 		},
+		Metrics:  ConfMetrics{Token: "metrics-test-token"},
+		IdpHooks: ConfIdpHooks{Token: "idp-test-token"},
+		OIDC: ConfOIDC{Clients: []ConfOIDCClient{
+			{Id: "grafana", Secret: "grafana-secret", RedirectURIs: []string{"https://grafana.test/login"}},
+		}},
 	}
 	handler, err := NewAPIHandler(c)
 	if err != nil {
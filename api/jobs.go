@@ -0,0 +1,74 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/keydotcat/keycatd/managers"
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// /admin/jobs
+func (ah apiHandler) adminJobsRoot(w http.ResponseWriter, r *http.Request) error {
+	var name, action string
+	name, r.URL.Path = shiftPath(r.URL.Path)
+	action, r.URL.Path = shiftPath(r.URL.Path)
+	switch {
+	case len(name) == 0 && r.Method == "GET":
+		jobs, err := ah.jobs.Jobs(r.Context())
+		if err != nil {
+			return err
+		}
+		return jsonResponse(w, jobs)
+	case len(name) > 0 && len(action) == 0 && r.Method == "GET":
+		return ah.adminJobGet(w, r, name)
+	case len(name) > 0 && action == "run" && r.Method == "POST":
+		return ah.adminJobRun(w, r, name)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+func (ah apiHandler) findJob(r *http.Request, name string) (*models.Job, error) {
+	jobs, err := ah.jobs.Jobs(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		if j.Name == name {
+			return j, nil
+		}
+	}
+	return nil, util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /admin/jobs/:name
+func (ah apiHandler) adminJobGet(w http.ResponseWriter, r *http.Request, name string) error {
+	j, err := ah.findJob(r, name)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, j)
+}
+
+// POST /admin/jobs/:name/run
+func (ah apiHandler) adminJobRun(w http.ResponseWriter, r *http.Request, name string) error {
+	dryRun, err := queryDryRun(r)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return ah.adminJobDryRun(w, r, name)
+	}
+	if err := ah.jobs.Trigger(name); err != nil {
+		if util.CheckErr(err, managers.ErrJobUnknown) {
+			return util.NewErrorFrom(ErrNotFound)
+		}
+		return err
+	}
+	ah.auditLog(r, AUDIT_ADMIN_JOB_RUN, auditObject("job", name))
+	j, err := ah.findJob(r, name)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, j)
+}
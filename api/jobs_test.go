@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestAdminJobs(t *testing.T) {
+	u := loginDummyUser()
+	r, err := GetRequest("/admin/jobs")
+	CheckErrorAndResponse(t, r, err, 401)
+	if err := u.SetAdmin(getCtx(), true); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest("/admin/jobs")
+	CheckErrorAndResponse(t, r, err, 200)
+	jobs := []*models.Job{}
+	if err := json.NewDecoder(r.Body).Decode(&jobs); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, j := range jobs {
+		found = found || j.Name == JOB_BLOCKLIST_PURGE
+	}
+	if !found {
+		t.Fatalf("Job %s is not listed in %#v", JOB_BLOCKLIST_PURGE, jobs)
+	}
+	r, err = PostRequest("/admin/jobs/nonexistant/run", nil)
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = PostRequest("/admin/jobs/"+JOB_BLOCKLIST_PURGE+"/run", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	for i := 0; i < 50; i++ {
+		j, err := models.FindJob(getCtx(), JOB_BLOCKLIST_PURGE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Runs > 0 && !j.Running {
+			if j.LastStatus != models.JOB_STATUS_OK {
+				t.Errorf("Unexpected job status %#v", j)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("Job did not run")
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// keyLogHead is the signed tree head of the key log. Clients keep the last one they saw to ask for consistency proofs
+type keyLogHead struct {
+	Size      int64     `json:"size"`
+	RootHash  []byte    `json:"root_hash"`
+	Timestamp time.Time `json:"timestamp"`
+	//Signature is empty if there is no keylog.key_file
+	Signature []byte `json:"signature,omitempty"`
+	PublicKey []byte `json:"public_key,omitempty"`
+}
+
+func keyLogHeadMessage(size int64, root []byte, ts time.Time) []byte {
+	return []byte(fmt.Sprintf("keycatd.keylog.head\x00%d\x00%x\x00%d", size, root, ts.Unix()))
+}
+
+func (ah apiHandler) keyLogHead(klt *models.KeyLogTree) keyLogHead {
+	h := keyLogHead{Size: klt.Size(), RootHash: klt.Root(klt.Size()), Timestamp: time.Now().UTC().Truncate(time.Second)}
+	if ah.keyLogKey != nil {
+		h.Signature = ed25519.Sign(ah.keyLogKey, keyLogHeadMessage(h.Size, h.RootHash, h.Timestamp))
+		h.PublicKey = ah.keyLogKey.Public().(ed25519.PublicKey)
+	}
+	return h
+}
+
+type keyLogProof struct {
+	Entry *models.KeyLogEntry `json:"entry"`
+	Proof [][]byte            `json:"proof"`
+}
+
+type keyLogUserResponse struct {
+	Head    keyLogHead     `json:"head"`
+	Entries []*keyLogProof `json:"entries"`
+}
+
+type keyLogConsistencyResponse struct {
+	Head  keyLogHead `json:"head"`
+	From  int64      `json:"from"`
+	Proof [][]byte   `json:"proof"`
+}
+
+// /keylog
+func (ah apiHandler) keyLogRoot(w http.ResponseWriter, r *http.Request) error {
+	var head, uid string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	uid, r.URL.Path = shiftPath(r.URL.Path)
+	if r.Method != "GET" || r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	switch {
+	case head == "head" && len(uid) == 0:
+		return ah.keyLogGetHead(w, r)
+	case head == "consistency" && len(uid) == 0:
+		return ah.keyLogConsistency(w, r)
+	case head == "user" && len(uid) > 0:
+		return ah.keyLogUser(w, r, uid)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /keylog/head
+func (ah apiHandler) keyLogGetHead(w http.ResponseWriter, r *http.Request) error {
+	klt, err := models.LoadKeyLogTree(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, ah.keyLogHead(klt))
+}
+
+// GET /keylog/consistency?from=
+func (ah apiHandler) keyLogConsistency(w http.ResponseWriter, r *http.Request) error {
+	klt, err := models.LoadKeyLogTree(r.Context())
+	if err != nil {
+		return err
+	}
+	from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	if err != nil || from < 0 || from > klt.Size() {
+		return util.NewErrorf("Invalid from. It has to be the size of a previous head")
+	}
+	return jsonResponse(w, keyLogConsistencyResponse{ah.keyLogHead(klt), from, klt.ConsistencyProof(from)})
+}
+
+// GET /keylog/user/:uid
+// Only the keys of the user and of the members of the teams of the user can be checked
+func (ah apiHandler) keyLogUser(w http.ResponseWriter, r *http.Request, uid string) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if uid != u.Id {
+		share, err := models.UsersShareTeam(ctx, u.Id, uid)
+		if err != nil {
+			return err
+		}
+		if !share {
+			return util.NewErrorFrom(ErrNotFound)
+		}
+	}
+	klt, err := models.LoadKeyLogTree(ctx)
+	if err != nil {
+		return err
+	}
+	entries, err := models.FindKeyLogEntries(ctx, uid, klt.Size())
+	if err != nil {
+		return err
+	}
+	resp := keyLogUserResponse{Head: ah.keyLogHead(klt), Entries: []*keyLogProof{}}
+	for _, kle := range entries {
+		resp.Entries = append(resp.Entries, &keyLogProof{kle, klt.InclusionProof(kle.Seq)})
+	}
+	return jsonResponse(w, resp)
+}
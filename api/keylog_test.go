@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestKeyLogUserProof(t *testing.T) {
+	u := loginDummyUser()
+	r, err := GetRequest(fmt.Sprintf("/keylog/user/%s", u.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	klu := &keyLogUserResponse{}
+	if err := json.NewDecoder(r.Body).Decode(klu); err != nil {
+		t.Fatal(err)
+	}
+	if len(klu.Entries) != 1 {
+		t.Fatalf("Expected one entry for the user and got %d", len(klu.Entries))
+	}
+	e := klu.Entries[0]
+	if e.Entry.User != u.Id || !bytes.Equal(e.Entry.PublicKey, u.PublicKey) {
+		t.Fatalf("Unexpected entry %#v", e.Entry)
+	}
+	leaf := util.MerkleLeafHash(models.KeyLogLeaf(e.Entry.Seq, e.Entry.User, e.Entry.PublicKey, e.Entry.CreatedAt))
+	if !util.VerifyMerkleInclusion(int(e.Entry.Seq), int(klu.Head.Size), leaf, e.Proof, klu.Head.RootHash) {
+		t.Fatalf("The entry of the user is not in the log")
+	}
+	r, err = GetRequest(fmt.Sprintf("/keylog/consistency?from=%d", klu.Head.Size))
+	CheckErrorAndResponse(t, r, err, 200)
+	//Another user makes the log grow
+	loginDummyUser()
+	r, err = GetRequest(fmt.Sprintf("/keylog/consistency?from=%d", klu.Head.Size))
+	CheckErrorAndResponse(t, r, err, 200)
+	klc := &keyLogConsistencyResponse{}
+	if err := json.NewDecoder(r.Body).Decode(klc); err != nil {
+		t.Fatal(err)
+	}
+	if klc.Head.Size <= klu.Head.Size {
+		t.Fatalf("The log didn't grow")
+	}
+	if !util.VerifyMerkleConsistency(int(klu.Head.Size), int(klc.Head.Size), klu.Head.RootHash, klc.Head.RootHash, klc.Proof) {
+		t.Errorf("The log is not consistent with the previous head")
+	}
+	r, err = GetRequest(fmt.Sprintf("/keylog/user/%s", u.Id))
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = GetRequest(fmt.Sprintf("/keylog/consistency?from=%d", klc.Head.Size+1))
+	CheckErrorAndResponse(t, r, err, 400)
+}
+
+func TestKeyLogSignedHead(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	klt := &models.KeyLogTree{Leaves: [][]byte{util.MerkleLeafHash([]byte("a")), util.MerkleLeafHash([]byte("b"))}}
+	h := apiHandler{keyLogKey: key}.keyLogHead(klt)
+	if h.Size != 2 || !bytes.Equal(h.PublicKey, pub) {
+		t.Fatalf("Unexpected head %#v", h)
+	}
+	if !ed25519.Verify(pub, keyLogHeadMessage(h.Size, h.RootHash, h.Timestamp), h.Signature) {
+		t.Errorf("The signature of the head doesn't verify")
+	}
+	if h := (apiHandler{}).keyLogHead(klt); len(h.Signature) > 0 {
+		t.Errorf("Heads can't be signed without a key")
+	}
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// /team/:tid/label
+func (ah apiHandler) labelRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	var lid string
+	lid, r.URL.Path = shiftPath(r.URL.Path)
+	if r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	switch {
+	case len(lid) == 0 && r.Method == "GET":
+		return ah.labelList(w, r, t)
+	case len(lid) == 0 && r.Method == "POST":
+		return ah.labelCreate(w, r, t)
+	case len(lid) > 0 && r.Method == "PUT":
+		return ah.labelUpdate(w, r, t, lid)
+	case len(lid) > 0 && r.Method == "DELETE":
+		return ah.labelDelete(w, r, t, lid)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type labelListResponse struct {
+	Labels  []*models.Label       `json:"labels"`
+	Secrets []*models.SecretLabel `json:"secrets"`
+}
+
+// GET /team/:tid/label
+func (ah apiHandler) labelList(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	ctx := r.Context()
+	labels, err := t.GetLabels(ctx)
+	if err != nil {
+		return err
+	}
+	sls, err := t.GetSecretLabelsForUser(ctx, ctxGetUser(ctx))
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, labelListResponse{labels, sls})
+}
+
+type labelRequest struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// POST /team/:tid/label
+func (ah apiHandler) labelCreate(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	lr := &labelRequest{}
+	if err := jsonDecode(w, r, 1024, lr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	l, err := t.CreateLabel(ctx, ctxGetUser(ctx), lr.Name, lr.Color)
+	if err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_LABEL_CREATE, auditObject("team", t.Id, "label", l.Id))
+	return jsonResponse(w, l)
+}
+
+// PUT /team/:tid/label/:lid
+func (ah apiHandler) labelUpdate(w http.ResponseWriter, r *http.Request, t *models.Team, lid string) error {
+	lr := &labelRequest{}
+	if err := jsonDecode(w, r, 1024, lr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	l := &models.Label{Id: lid, Name: lr.Name, Color: lr.Color}
+	if err := t.UpdateLabel(ctx, ctxGetUser(ctx), l); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_LABEL_UPDATE, auditObject("team", t.Id, "label", l.Id))
+	return jsonResponse(w, l)
+}
+
+// DELETE /team/:tid/label/:lid
+func (ah apiHandler) labelDelete(w http.ResponseWriter, r *http.Request, t *models.Team, lid string) error {
+	ctx := r.Context()
+	if err := t.DeleteLabel(ctx, ctxGetUser(ctx), lid); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_LABEL_DELETE, auditObject("team", t.Id, "label", lid))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+type secretLabelsRequest struct {
+	Labels []string `json:"labels"`
+}
+
+// PUT /team/:tid/vault/:vid/secret/:sid/labels
+func (ah apiHandler) vaultSetSecretLabels(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, sid string) error {
+	slr := &secretLabelsRequest{}
+	if err := jsonDecode(w, r, 4096, slr); err != nil {
+		return err
+	}
+	lids, err := v.SetSecretLabels(r.Context(), sid, slr.Labels)
+	if err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_SECRET_LABELS, auditObject("team", t.Id, "vault", v.Id, "secret", sid))
+	return jsonResponse(w, secretLabelsRequest{lids})
+}
+
+// labelFilter returns which secrets to list for the label query parameter. Without it every secret is listed
+func labelFilter(r *http.Request, t *models.Team, load func(context.Context) ([]*models.SecretLabel, error)) (func(*models.Secret) bool, error) {
+	lid := r.URL.Query().Get("label")
+	if len(lid) == 0 {
+		return func(*models.Secret) bool { return true }, nil
+	}
+	ctx := r.Context()
+	if _, err := t.GetLabel(ctx, lid); err != nil {
+		return nil, err
+	}
+	sls, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	labeled := map[string]bool{}
+	for _, sl := range sls {
+		if sl.Label == lid {
+			labeled[sl.Vault+"/"+sl.Secret] = true
+		}
+	}
+	return func(s *models.Secret) bool { return labeled[s.Vault+"/"+s.Id] }, nil
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestLabelSecretsAndFilter(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	r, err := PostRequest(fmt.Sprintf("/team/%s/label", team.Id), labelRequest{"prod", "green"})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest(fmt.Sprintf("/team/%s/label", team.Id), labelRequest{"prod", "#00aa00"})
+	CheckErrorAndResponse(t, r, err, 200)
+	l := &models.Label{}
+	if err := json.NewDecoder(r.Body).Decode(l); err != nil {
+		t.Fatal(err)
+	}
+	vfs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vfs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	sids := make([]string, 2)
+	for i := range sids {
+		r, err = PostRequest(fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id), vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+		CheckErrorAndResponse(t, r, err, 200)
+		s := &models.Secret{}
+		if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+			t.Fatal(err)
+		}
+		sids[i] = s.Id
+	}
+	r, err = PutRequest(fmt.Sprintf("/team/%s/vault/%s/secret/%s/labels", team.Id, v.Id, sids[0]), secretLabelsRequest{[]string{l.Id}})
+	CheckErrorAndResponse(t, r, err, 200)
+	listed := func(path string) []*models.Secret {
+		r, err := GetRequest(path)
+		CheckErrorAndResponse(t, r, err, 200)
+		sl := &teamSecretListWrap{}
+		if err := json.NewDecoder(r.Body).Decode(sl); err != nil {
+			t.Fatal(err)
+		}
+		return sl.Secrets
+	}
+	for _, path := range []string{
+		fmt.Sprintf("/team/%s/secret?label=%s", team.Id, l.Id),
+		fmt.Sprintf("/team/%s/vault/%s/secret?label=%s&sort=id", team.Id, v.Id, l.Id),
+	} {
+		if secs := listed(path); len(secs) != 1 || secs[0].Id != sids[0] {
+			t.Errorf("Expected only the labeled secret in %s and got %#v", path, secs)
+		}
+	}
+	if secs := listed(fmt.Sprintf("/team/%s/secret", team.Id)); len(secs) != 2 {
+		t.Errorf("Expected all the secrets without a label filter and got %d", len(secs))
+	}
+	r, err = GetRequest(fmt.Sprintf("/team/%s/secret?label=nope", team.Id))
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = GetRequest(fmt.Sprintf("/team/%s/label", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	llr := &labelListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(llr); err != nil {
+		t.Fatal(err)
+	}
+	if len(llr.Labels) != 1 || len(llr.Secrets) != 1 || llr.Secrets[0].Secret != sids[0] {
+		t.Fatalf("Unexpected labels %#v", llr)
+	}
+	r, err = PutRequest(fmt.Sprintf("/team/%s/label/%s", team.Id, l.Id), labelRequest{"production", "#00aa00"})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/label/%s", team.Id, l.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(fmt.Sprintf("/team/%s/label", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	llr = &labelListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(llr); err != nil {
+		t.Fatal(err)
+	}
+	if len(llr.Labels) != 0 || len(llr.Secrets) != 0 {
+		t.Fatalf("Expected the label to be gone from the secrets %#v", llr)
+	}
+}
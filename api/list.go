@@ -0,0 +1,330 @@
+package api
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	listDefaultLimit = 100
+	listMaxLimit     = 1000
+	//listTimeLayout has a fixed width so the times sort like their strings
+	listTimeLayout = "2006-01-02T15:04:05.000000000Z"
+)
+
+// listField returns the value of a field of the items of a listing. Values have to sort like the field
+type listField func(item interface{}) string
+
+func listTime(t time.Time) string {
+	return t.UTC().Format(listTimeLayout)
+}
+
+// listSpec is what a listing can be sorted and filtered by
+type listSpec struct {
+	//id is unique for each item. It breaks the ties of the sort and goes in the cursors
+	id          listField
+	defaultSort string
+	sorts       map[string]listField
+	filters     map[string]listField
+	//fields are the json fields of the items a client can ask for. Listings without them always send whole items
+	fields []string
+}
+
+// listQuery is what the client asked for in the query string of a listing:
+//   - limit: how many items to return. Up to listMaxLimit
+//   - cursor: the next_cursor of the previous page
+//   - sort: a field to sort by, prefixed with - to sort in descending order
+//   - any of the filters of the listing, like owner=:uid. Only the items with that exact value are returned
+//   - fields: a comma separated list of the fields of the items to send, like fields=id,version
+//
+// Without limit, cursor or sort the whole listing is returned as it's read so the clients that don't page keep working.
+// Once any of them is used the listing is sorted and cut in pages of listDefaultLimit items by default
+type listQuery struct {
+	spec    listSpec
+	paged   bool
+	limit   int
+	sort    string
+	desc    bool
+	filters map[string]string
+	after   *listCursor
+	fields  []string
+}
+
+type listCursor struct {
+	key string
+	id  string
+}
+
+func queryLimit(r *http.Request, def, max int) (int, error) {
+	limit := def
+	if val := r.URL.Query().Get("limit"); len(val) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(val); err != nil || limit < 1 {
+			return 0, util.NewErrorf("Invalid limit")
+		}
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit, nil
+}
+
+func parseListQuery(r *http.Request, spec listSpec) (*listQuery, error) {
+	q := r.URL.Query()
+	lq := &listQuery{spec: spec, sort: spec.defaultSort, filters: map[string]string{}}
+	for name := range spec.filters {
+		if val, ok := q[name]; ok {
+			lq.filters[name] = val[0]
+		}
+	}
+	if val := q.Get("fields"); len(val) > 0 && len(spec.fields) > 0 {
+		for _, name := range strings.Split(val, ",") {
+			if !spec.hasField(name) {
+				return nil, util.NewErrorf("Invalid field %s", name)
+			}
+			lq.fields = append(lq.fields, name)
+		}
+	}
+	_, hasLimit := q["limit"]
+	_, hasSort := q["sort"]
+	lq.paged = hasLimit || hasSort || len(q.Get("cursor")) > 0
+	if !lq.paged {
+		return lq, nil
+	}
+	var err error
+	if lq.limit, err = queryLimit(r, listDefaultLimit, listMaxLimit); err != nil {
+		return nil, err
+	}
+	if val := q.Get("sort"); len(val) > 0 {
+		lq.desc = strings.HasPrefix(val, "-")
+		lq.sort = strings.TrimPrefix(val, "-")
+		if _, ok := spec.sorts[lq.sort]; !ok {
+			return nil, util.NewErrorf("Invalid sort %s", val)
+		}
+	}
+	if val := q.Get("cursor"); len(val) > 0 {
+		if lq.after, err = lq.decodeCursor(val); err != nil {
+			return nil, err
+		}
+	}
+	return lq, nil
+}
+
+func (ls listSpec) hasField(name string) bool {
+	for _, field := range ls.fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (lq *listQuery) sortName() string {
+	if lq.desc {
+		return "-" + lq.sort
+	}
+	return lq.sort
+}
+
+// Cursors carry the sort they were made for so they can't be mixed up with another order
+func (lq *listQuery) cursor(item interface{}) string {
+	raw := strings.Join([]string{lq.sortName(), lq.spec.sorts[lq.sort](item), lq.spec.id(item)}, "\x00")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func (lq *listQuery) decodeCursor(val string) (*listCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(val)
+	parts := strings.Split(string(raw), "\x00")
+	if err != nil || len(parts) != 3 {
+		return nil, util.NewErrorf("Invalid cursor")
+	}
+	if parts[0] != lq.sortName() {
+		return nil, util.NewErrorf("The cursor is for a listing sorted by %s", parts[0])
+	}
+	return &listCursor{parts[1], parts[2]}, nil
+}
+
+func (lq *listQuery) matches(item interface{}) bool {
+	for name, val := range lq.filters {
+		if lq.spec.filters[name](item) != val {
+			return false
+		}
+	}
+	return true
+}
+
+// cmp compares the position of two items in the requested order
+func (lq *listQuery) cmp(ka, ida, kb, idb string) int {
+	c := strings.Compare(ka, kb)
+	if c == 0 {
+		c = strings.Compare(ida, idb)
+	}
+	if lq.desc {
+		return -c
+	}
+	return c
+}
+
+func (lq *listQuery) less(a, b interface{}) bool {
+	key := lq.spec.sorts[lq.sort]
+	return lq.cmp(key(a), lq.spec.id(a), key(b), lq.spec.id(b)) < 0
+}
+
+// listFields is an item of a listing with only the fields the client asked for
+type listFields map[string]json.RawMessage
+
+// pick returns the item with only the requested fields or the whole item if none were requested
+func (lq *listQuery) pick(item interface{}) (interface{}, error) {
+	if len(lq.fields) == 0 {
+		return item, nil
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, internalErr(err)
+	}
+	all := listFields{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, internalErr(err)
+	}
+	picked := listFields{}
+	for _, name := range lq.fields {
+		picked[name] = all[name]
+	}
+	return picked, nil
+}
+
+// pickAll returns the items of a slice with only the requested fields. Fields have to have been requested
+func (lq *listQuery) pickAll(slice interface{}) ([]listFields, error) {
+	sv := reflect.ValueOf(slice)
+	picked := make([]listFields, sv.Len())
+	for i := range picked {
+		item, err := lq.pick(sv.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		picked[i] = item.(listFields)
+	}
+	return picked, nil
+}
+
+// listPage keeps the first items of the page among the ones it's given. The items don't have to be sorted and only
+// limit+1 of them are kept at any time so pages of big listings that are streamed don't need much memory
+type listPage struct {
+	lq    *listQuery
+	items []interface{}
+}
+
+func (lq *listQuery) newPage() *listPage {
+	return &listPage{lq: lq}
+}
+
+// The heap keeps the last item of the page on top so it can be dropped when a better one comes
+func (lp *listPage) Len() int              { return len(lp.items) }
+func (lp *listPage) Less(i, j int) bool    { return lp.lq.less(lp.items[j], lp.items[i]) }
+func (lp *listPage) Swap(i, j int)         { lp.items[i], lp.items[j] = lp.items[j], lp.items[i] }
+func (lp *listPage) Push(item interface{}) { lp.items = append(lp.items, item) }
+func (lp *listPage) Pop() (item interface{}) {
+	item, lp.items = lp.items[len(lp.items)-1], lp.items[:len(lp.items)-1]
+	return item
+}
+
+func (lp *listPage) add(item interface{}) {
+	lq := lp.lq
+	if !lq.matches(item) {
+		return
+	}
+	if lq.after != nil && lq.cmp(lq.spec.sorts[lq.sort](item), lq.spec.id(item), lq.after.key, lq.after.id) <= 0 {
+		return
+	}
+	heap.Push(lp, item)
+	//One more than the limit is kept to know if there's a next page
+	if lp.Len() > lq.limit+1 {
+		heap.Pop(lp)
+	}
+}
+
+// result returns the sorted items of the page and the cursor of the next one if there are more items
+func (lp *listPage) result() ([]interface{}, string) {
+	items := lp.items
+	sort.Slice(items, func(i, j int) bool { return lp.lq.less(items[i], items[j]) })
+	if len(items) <= lp.lq.limit {
+		return items, ""
+	}
+	items = items[:lp.lq.limit]
+	return items, lp.lq.cursor(items[len(items)-1])
+}
+
+// apply filters and pages a slice of items in place. It takes a pointer to the slice and returns the next cursor
+func (lq *listQuery) apply(slicePtr interface{}) string {
+	sv := reflect.ValueOf(slicePtr).Elem()
+	if !lq.paged {
+		if len(lq.filters) == 0 {
+			return ""
+		}
+		kept := reflect.MakeSlice(sv.Type(), 0, sv.Len())
+		for i := 0; i < sv.Len(); i++ {
+			if lq.matches(sv.Index(i).Interface()) {
+				kept = reflect.Append(kept, sv.Index(i))
+			}
+		}
+		sv.Set(kept)
+		return ""
+	}
+	lp := lq.newPage()
+	for i := 0; i < sv.Len(); i++ {
+		lp.add(sv.Index(i).Interface())
+	}
+	items, next := lp.result()
+	page := reflect.MakeSlice(sv.Type(), len(items), len(items))
+	for i, item := range items {
+		page.Index(i).Set(reflect.ValueOf(item))
+	}
+	sv.Set(page)
+	return next
+}
+
+// stream lists the items produced by each as a json list under field. Without paging the items are written as they
+// come. Pages are collected first as they have to be sorted
+func (lq *listQuery) stream(w http.ResponseWriter, r *http.Request, field string, each func(fn func(interface{}) error) error) error {
+	ls := newJSONListStream(w, field)
+	if !lq.paged {
+		return ls.close(r, each(func(item interface{}) error {
+			if !lq.matches(item) {
+				return nil
+			}
+			picked, err := lq.pick(item)
+			if err != nil {
+				return err
+			}
+			return ls.write(picked)
+		}))
+	}
+	lp := lq.newPage()
+	if err := each(func(item interface{}) error {
+		lp.add(item)
+		return nil
+	}); err != nil {
+		return err
+	}
+	items, next := lp.result()
+	for _, item := range items {
+		picked, err := lq.pick(item)
+		if err == nil {
+			err = ls.write(picked)
+		}
+		if err != nil {
+			return ls.close(r, err)
+		}
+	}
+	ls.next = next
+	return ls.close(r, nil)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type listTestItem struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+var listTestSpec = listSpec{
+	id:          func(i interface{}) string { return i.(*listTestItem).Id },
+	defaultSort: "name",
+	sorts: map[string]listField{
+		"name": func(i interface{}) string { return i.(*listTestItem).Name },
+	},
+	filters: map[string]listField{
+		"color": func(i interface{}) string { return i.(*listTestItem).Color },
+	},
+	fields: []string{"id", "name", "color"},
+}
+
+func listTestItems() []*listTestItem {
+	items := []*listTestItem{}
+	for i := 9; i >= 0; i-- {
+		color := "red"
+		if i%2 == 0 {
+			color = "blue"
+		}
+		//Two items share each name so the ties are broken by id
+		items = append(items, &listTestItem{fmt.Sprintf("i%d", i), fmt.Sprintf("n%d", i/2), color})
+	}
+	return items
+}
+
+func listTestQuery(t *testing.T, query string) *listQuery {
+	lq, err := parseListQuery(httptest.NewRequest("GET", "/team?"+query, nil), listTestSpec)
+	if err != nil {
+		t.Fatalf("%s: %s", query, err)
+	}
+	return lq
+}
+
+func listTestIds(items []*listTestItem) string {
+	ids := []string{}
+	for _, item := range items {
+		ids = append(ids, item.Id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestListQueryPages(t *testing.T) {
+	checks := []struct {
+		query string
+		pages []string
+	}{
+		{"", []string{"i9,i8,i7,i6,i5,i4,i3,i2,i1,i0"}},
+		{"color=blue", []string{"i8,i6,i4,i2,i0"}},
+		{"limit=4", []string{"i0,i1,i2,i3", "i4,i5,i6,i7", "i8,i9"}},
+		{"limit=3&sort=-name", []string{"i9,i8,i7", "i6,i5,i4", "i3,i2,i1", "i0"}},
+		{"limit=2&color=red", []string{"i1,i3", "i5,i7", "i9"}},
+		{"sort=name&color=blue", []string{"i0,i2,i4,i6,i8"}},
+	}
+	for _, c := range checks {
+		query := c.query
+		for i, expected := range c.pages {
+			items := listTestItems()
+			next := listTestQuery(t, query).apply(&items)
+			if got := listTestIds(items); got != expected {
+				t.Fatalf("%s: expected page %d to be %s and got %s", c.query, i, expected, got)
+			}
+			if (len(next) > 0) != (i < len(c.pages)-1) {
+				t.Fatalf("%s: unexpected next cursor %q for page %d", c.query, next, i)
+			}
+			q, _ := url.ParseQuery(query)
+			q.Set("cursor", next)
+			query = q.Encode()
+		}
+	}
+}
+
+func TestListQueryErrors(t *testing.T) {
+	items := listTestItems()
+	next := listTestQuery(t, "limit=2").apply(&items)
+	for _, query := range []string{"limit=0", "limit=a", "sort=color", "cursor=abc", "fields=id,size", "cursor=" + next + "&sort=-name"} {
+		if _, err := parseListQuery(httptest.NewRequest("GET", "/team?"+query, nil), listTestSpec); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+	lq := listTestQuery(t, fmt.Sprintf("limit=%d", listMaxLimit*2))
+	if lq.limit != listMaxLimit {
+		t.Errorf("Expected the limit to be capped to %d and got %d", listMaxLimit, lq.limit)
+	}
+}
+
+func TestListQueryStream(t *testing.T) {
+	for _, query := range []string{"color=red", "limit=3"} {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/team/t/secret?"+query, nil)
+		lq, err := parseListQuery(r, listTestSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := lq.stream(rec, r, "items", func(fn func(interface{}) error) error {
+			for _, item := range listTestItems() {
+				if err := fn(item); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		resp := struct {
+			Items      []*listTestItem `json:"items"`
+			NextCursor string          `json:"next_cursor"`
+		}{}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if lq.paged {
+			if listTestIds(resp.Items) != "i0,i1,i2" || len(resp.NextCursor) == 0 {
+				t.Errorf("%s: unexpected page %s with cursor %q", query, listTestIds(resp.Items), resp.NextCursor)
+			}
+		} else if listTestIds(resp.Items) != "i9,i7,i5,i3,i1" || len(resp.NextCursor) > 0 {
+			t.Errorf("%s: expected the items to be streamed in order and got %s", query, listTestIds(resp.Items))
+		}
+	}
+}
+
+func TestListQueryFields(t *testing.T) {
+	items := listTestItems()
+	lq := listTestQuery(t, "fields=id,color&limit=2")
+	lq.apply(&items)
+	picked, err := lq.pickAll(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(picked)
+	if string(data) != `[{"color":"blue","id":"i0"},{"color":"red","id":"i1"}]` {
+		t.Errorf("Unexpected fields %s", data)
+	}
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/team/t/secret?fields=name", nil)
+	lq, _ = parseListQuery(r, listTestSpec)
+	lq.stream(rec, r, "items", func(fn func(interface{}) error) error {
+		return fn(listTestItems()[0])
+	})
+	if rec.Body.String() != "{\"items\":[{\"name\":\"n4\"}\n]}\n" {
+		t.Errorf("Unexpected streamed fields %q", rec.Body.String())
+	}
+}
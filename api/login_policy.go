@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	LOGIN_STAGE_FREE    = "free"
+	LOGIN_STAGE_DELAY   = "delay"
+	LOGIN_STAGE_CAPTCHA = "captcha"
+	LOGIN_STAGE_LOCKOUT = "lockout"
+)
+
+var captchaClient = &http.Client{Timeout: 5 * time.Second}
+
+// loginState is what the next login of a user faces. RetryAt is only set for the delay and the lockout
+type loginState struct {
+	FailedAttempts int        `json:"failed_attempts"`
+	LastFailedAt   *time.Time `json:"last_failed_at,omitempty"`
+	Stage          string     `json:"stage"`
+	RetryAt        *time.Time `json:"retry_at,omitempty"`
+}
+
+// forgetBefore is when failures stop counting
+func (lp ConfLoginPolicy) forgetBefore(now time.Time) time.Time {
+	if lp.ResetHours == 0 {
+		return time.Time{}
+	}
+	return now.Add(-time.Duration(lp.ResetHours) * time.Hour)
+}
+
+// evaluate is the only place that decides the stage of the failed logins of a user. The lockout comes before the
+// captcha and the captcha before the delay
+func (lp ConfLoginPolicy) evaluate(u *models.User, now time.Time) loginState {
+	ls := loginState{Stage: LOGIN_STAGE_FREE}
+	if u.FailedAttempts == 0 || !u.FailedAt.Valid || u.FailedAt.Time.Before(lp.forgetBefore(now)) {
+		return ls
+	}
+	last := u.FailedAt.Time
+	ls.FailedAttempts, ls.LastFailedAt = u.FailedAttempts, &last
+	retry := func(wait time.Duration) bool {
+		until := last.Add(wait)
+		if !now.Before(until) {
+			return false
+		}
+		ls.RetryAt = &until
+		return true
+	}
+	switch {
+	case lp.LockoutAfter > 0 && u.FailedAttempts >= lp.LockoutAfter && retry(time.Duration(lp.LockoutMinutes)*time.Minute):
+		ls.Stage = LOGIN_STAGE_LOCKOUT
+	case lp.CaptchaAfter > 0 && u.FailedAttempts >= lp.CaptchaAfter:
+		ls.Stage = LOGIN_STAGE_CAPTCHA
+	case lp.DelayAfter > 0 && u.FailedAttempts >= lp.DelayAfter && retry(time.Duration(lp.DelaySeconds)*time.Second):
+		ls.Stage = LOGIN_STAGE_DELAY
+	}
+	return ls
+}
+
+type captchaVerifyResponse struct {
+	Success bool `json:"success"`
+}
+
+func (ah apiHandler) verifyCaptcha(r *http.Request, c ConfCaptcha, token string) error {
+	if len(token) == 0 {
+		return util.NewErrorFrom(ErrCaptchaRequired)
+	}
+	resp, err := captchaClient.PostForm(c.VerifyUrl, url.Values{"secret": {c.Secret}, "response": {token}, "remoteip": {ah.clientIP(r)}})
+	if err != nil {
+		return internalErr(err)
+	}
+	defer resp.Body.Close()
+	cvr := &captchaVerifyResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(cvr); err != nil {
+		return internalErr(err)
+	}
+	if !cvr.Success {
+		return util.NewErrorFrom(ErrCaptchaRequired)
+	}
+	return nil
+}
+
+// retryLater refuses the attempt while the login policy delays or locks out the user
+func retryLater(w http.ResponseWriter, ls loginState, now time.Time) error {
+	w.Header().Set("Retry-After", strconv.Itoa(int(ls.RetryAt.Sub(now)/time.Second)+1))
+	return util.NewErrorFrom(ErrTooManyAttempts)
+}
+
+// checkLoginPassword checks the password of the user through the login policy. The attempt is counted as a failure
+// before checking the password and evaluated again on the locked row, so concurrent attempts can't get past the
+// lockout. A success clears the failures
+func (ah apiHandler) checkLoginPassword(w http.ResponseWriter, r *http.Request, u *models.User, password, captcha string) error {
+	lp := ah.opts().loginPolicy
+	now := time.Now().UTC()
+	ls := lp.evaluate(u, now)
+	switch ls.Stage {
+	case LOGIN_STAGE_DELAY, LOGIN_STAGE_LOCKOUT:
+		return retryLater(w, ls, now)
+	case LOGIN_STAGE_CAPTCHA:
+		if err := ah.verifyCaptcha(r, lp.Captcha, captcha); err != nil {
+			return err
+		}
+	}
+	err := u.RecordLoginAttempt(r.Context(), now, lp.forgetBefore(now), func(cur *models.User) error {
+		switch cls := lp.evaluate(cur, now); cls.Stage {
+		case LOGIN_STAGE_DELAY, LOGIN_STAGE_LOCKOUT:
+			return retryLater(w, cls, now)
+		case LOGIN_STAGE_CAPTCHA:
+			if ls.Stage != LOGIN_STAGE_CAPTCHA {
+				return util.NewErrorFrom(ErrCaptchaRequired)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := u.CheckPassword(password); err != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	return u.ClearFailedLogins(r.Context())
+}
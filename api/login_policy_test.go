@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/lib/pq"
+)
+
+func TestLoginPolicyEvaluate(t *testing.T) {
+	lp := ConfLoginPolicy{DelayAfter: 2, DelaySeconds: 10, CaptchaAfter: 4, LockoutAfter: 6, LockoutMinutes: 5, ResetHours: 1}
+	now := time.Now().UTC()
+	for _, tc := range []struct {
+		failed int
+		ago    time.Duration
+		stage  string
+	}{
+		{0, 0, LOGIN_STAGE_FREE},
+		{1, time.Second, LOGIN_STAGE_FREE},
+		{2, time.Second, LOGIN_STAGE_DELAY},
+		{2, time.Minute, LOGIN_STAGE_FREE},
+		{4, time.Minute, LOGIN_STAGE_CAPTCHA},
+		{6, time.Minute, LOGIN_STAGE_LOCKOUT},
+		{6, 10 * time.Minute, LOGIN_STAGE_CAPTCHA},
+		{6, 2 * time.Hour, LOGIN_STAGE_FREE},
+	} {
+		u := &models.User{FailedAttempts: tc.failed, FailedAt: pq.NullTime{Time: now.Add(-tc.ago), Valid: tc.failed > 0}}
+		ls := lp.evaluate(u, now)
+		if ls.Stage != tc.stage {
+			t.Errorf("Expected %s after %d failures %s ago and got %s", tc.stage, tc.failed, tc.ago, ls.Stage)
+		}
+		if (ls.RetryAt != nil) != (ls.Stage == LOGIN_STAGE_DELAY || ls.Stage == LOGIN_STAGE_LOCKOUT) {
+			t.Errorf("Unexpected retry time %v for %s", ls.RetryAt, ls.Stage)
+		}
+	}
+}
+
+func TestLoginPolicyEscalation(t *testing.T) {
+	captcha := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(captchaVerifyResponse{r.PostFormValue("secret") == "secret" && r.PostFormValue("response") == "good"})
+	}))
+	defer captcha.Close()
+	prevClient := captchaClient
+	captchaClient = captcha.Client()
+	defer func() { captchaClient = prevClient }()
+	boot := apiH.live.boot
+	defer apiH.Reload(boot)
+	c := boot
+	c.LoginPolicy = ConfLoginPolicy{CaptchaAfter: 2, LockoutAfter: 3, LockoutMinutes: 5, Captcha: ConfCaptcha{captcha.URL, "secret"}}
+	if _, err := apiH.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+	u := getDummyUser()
+	activeSessionToken = ""
+	login := func(password, token string, code int) {
+		r, err := PostRequest("/auth/login", authRequest{Id: u.Id, Password: password, Captcha: token})
+		CheckErrorAndResponse(t, r, err, code)
+	}
+	login("wrong", "", 401)
+	login("wrong", "", 401)
+	login(u.Id, "", 403)
+	login(u.Id, "bad", 403)
+	login("wrong", "good", 401)
+	login(u.Id, "good", 429)
+	admin := loginDummyUser()
+	if err := admin.SetAdmin(getCtx(), true); err != nil {
+		t.Fatal(err)
+	}
+	r, err := GetRequest("/admin/user/" + u.Id + "/login_state")
+	CheckErrorAndResponse(t, r, err, 200)
+	ls := &loginState{}
+	if err := json.NewDecoder(r.Body).Decode(ls); err != nil {
+		t.Fatal(err)
+	}
+	if ls.Stage != LOGIN_STAGE_LOCKOUT || ls.FailedAttempts != 3 || ls.RetryAt == nil {
+		t.Fatalf("Unexpected login state %#v", ls)
+	}
+	r, err = DeleteRequest("/admin/user/" + u.Id + "/login_state")
+	CheckErrorAndResponse(t, r, err, 200)
+	activeSessionToken = ""
+	login(u.Id, "", 200)
+}
+
+func TestLoginPolicyChangePassword(t *testing.T) {
+	boot := apiH.live.boot
+	defer apiH.Reload(boot)
+	c := boot
+	c.LoginPolicy = ConfLoginPolicy{LockoutAfter: 2, LockoutMinutes: 5}
+	if _, err := apiH.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+	u := loginDummyUser()
+	_, _, fullpack := generateNewKeys()
+	change := func(password string, code int) {
+		r, err := PostRequest("/account/change_password", accountChangePasswordRequest{OldPassword: password, Password: "newpass", KeyPack: fullpack})
+		CheckErrorAndResponse(t, r, err, code)
+	}
+	change("wrong", 400)
+	change("wrong", 400)
+	change(u.Id, 429)
+	if err := u.ClearFailedLogins(getCtx()); err != nil {
+		t.Fatal(err)
+	}
+	change(u.Id, 200)
+}
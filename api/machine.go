@@ -0,0 +1,183 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// machineSecretResponse has everything a client needs to decrypt a secret without any other request. The data is
+// encrypted with the vault key and the vault key is encrypted with the key of the user that issued the token
+type machineSecretResponse struct {
+	Team           string    `json:"team"`
+	Vault          string    `json:"vault"`
+	Secret         string    `json:"secret"`
+	Version        uint32    `json:"version"`
+	VaultVersion   uint32    `json:"vault_version"`
+	Data           []byte    `json:"data"`
+	VaultKey       []byte    `json:"vault_key"`
+	VaultPublicKey []byte    `json:"vault_public_key"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// machineSecretVersion is the part of a secret needed to know if it changed
+type machineSecretVersion struct {
+	Secret       string    `json:"secret"`
+	Version      uint32    `json:"version"`
+	VaultVersion uint32    `json:"vault_version"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type machineSecretListResponse struct {
+	Team    string                  `json:"team"`
+	Vault   string                  `json:"vault"`
+	Secrets []*machineSecretVersion `json:"secrets"`
+}
+
+// authorizeMachineRequest authenticates the request with a machine token. Only machine tokens are accepted here and
+// they aren't sessions, so a machine never holds a token that can do anything but read its vault
+func (ah apiHandler) authorizeMachineRequest(w http.ResponseWriter, r *http.Request) (*models.MachineToken, *http.Request) {
+	authHdr := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(authHdr) < 2 || authHdr[0] != "Bearer" {
+		http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+		return nil, nil
+	}
+	ctx := r.Context()
+	mt, err := models.FindMachineToken(ctx, authHdr[1])
+	if util.CheckErr(err, models.ErrDoesntExist) {
+		http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+		return nil, nil
+	} else if err != nil {
+		httpErr(w, r, internalErr(err))
+		return nil, nil
+	}
+	u, err := ah.users.get(ctx, mt.User)
+	if util.CheckErr(err, models.ErrDoesntExist) || (err == nil && (u.IsDisabled() || u.MustResetPassword)) {
+		http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+		return nil, nil
+	} else if err != nil {
+		httpErr(w, r, internalErr(err))
+		return nil, nil
+	}
+	return mt, r.WithContext(ctxAddUser(ctx, u))
+}
+
+// /machine
+func (ah apiHandler) machineRoot(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	mt, r := ah.authorizeMachineRequest(w, r)
+	if r == nil {
+		return nil
+	}
+	if ah.maintenanceBlock(w, r) {
+		return nil
+	}
+	if ah.rateLimitBlock(w, r, "/api/machine"+r.URL.Path, map[string]string{RATE_LIMIT_BY_USER: mt.User, RATE_LIMIT_BY_TOKEN: mt.Id}) {
+		return nil
+	}
+	var tid, vid, sid string
+	tid, r.URL.Path = shiftPath(r.URL.Path)
+	vid, r.URL.Path = shiftPath(r.URL.Path)
+	sid, r.URL.Path = shiftPath(r.URL.Path)
+	//The token only reads the vault it was issued for
+	if tid != mt.Team || vid != mt.Vault || r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	t, err := u.GetTeam(ctx, tid)
+	if err != nil {
+		return err
+	}
+	if err := checkNotAuditor(r, t); err != nil {
+		return err
+	}
+	vf, err := (&models.Vault{Team: t.Id, Id: vid}).GetVaultFullForUser(ctx, u)
+	if err != nil {
+		return err
+	}
+	readable, err := mt.GetReadableSecrets(ctx)
+	if err != nil {
+		return err
+	}
+	if len(sid) == 0 {
+		return ah.machineListSecrets(w, r, vf, readable)
+	}
+	//Secrets the token can't read don't exist for it
+	if readable != nil && !readable[sid] {
+		return util.NewErrorFrom(models.ErrDoesntExist)
+	}
+	return ah.machineGetSecret(w, r, vf, sid)
+}
+
+// GET /machine/:tid/:vid?archived=
+func (ah apiHandler) machineListSecrets(w http.ResponseWriter, r *http.Request, vf *models.VaultFull, readable map[string]bool) error {
+	keep, err := archivedFilter(r)
+	if err != nil {
+		return err
+	}
+	resp := machineSecretListResponse{Team: vf.Team, Vault: vf.Id, Secrets: []*machineSecretVersion{}}
+	if err := vf.Vault.ForEachSecret(r.Context(), func(s *models.Secret) error {
+		if !keep(s) || (readable != nil && !readable[s.Id]) {
+			return nil
+		}
+		resp.Secrets = append(resp.Secrets, &machineSecretVersion{s.Id, s.Version, s.VaultVersion, s.CreatedAt})
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Slice(resp.Secrets, func(i, j int) bool { return resp.Secrets[i].Secret < resp.Secrets[j].Secret })
+	//The listing only changes when a secret or the vault keys do
+	h := sha256.New()
+	fmt.Fprintf(h, "%d", vf.Version)
+	for _, s := range resp.Secrets {
+		fmt.Fprintf(h, "\x00%s.%d.%d", s.Secret, s.Version, s.VaultVersion)
+	}
+	if checkETag(w, r, hex.EncodeToString(h.Sum(nil))[:32]) {
+		return nil
+	}
+	return jsonResponse(w, resp)
+}
+
+// GET /machine/:tid/:vid/:sid?version=
+func (ah apiHandler) machineGetSecret(w http.ResponseWriter, r *http.Request, vf *models.VaultFull, sid string) error {
+	var s *models.Secret
+	var err error
+	if val := r.URL.Query().Get("version"); len(val) > 0 {
+		version, perr := strconv.ParseUint(val, 10, 32)
+		if perr != nil {
+			return util.NewErrorf("Invalid version")
+		}
+		s, err = vf.Vault.GetSecretVersion(r.Context(), sid, uint32(version))
+	} else {
+		s, err = vf.Vault.GetSecret(r.Context(), sid)
+	}
+	if err != nil {
+		return err
+	}
+	//The vault key changes when the vault version does
+	if checkETag(w, r, fmt.Sprintf("%d.%d.%d", s.Version, s.VaultVersion, vf.Version)) {
+		return nil
+	}
+	return jsonResponse(w, machineSecretResponse{
+		Team:           vf.Team,
+		Vault:          vf.Id,
+		Secret:         s.Id,
+		Version:        s.Version,
+		VaultVersion:   s.VaultVersion,
+		Data:           s.Data,
+		VaultKey:       vf.Key,
+		VaultPublicKey: vf.PublicKey,
+		CreatedAt:      s.CreatedAt,
+	})
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+// issueMachineToken creates a machine token for the vault with the current session
+func issueMachineToken(t *testing.T, tid, vid string) *machineTokenCreateResponse {
+	r, err := PostRequest(fmt.Sprintf("/team/%s/vault/%s/machine_token", tid, vid), &machineTokenCreateRequest{Name: "ci"})
+	CheckErrorAndResponse(t, r, err, 200)
+	mtr := &machineTokenCreateResponse{}
+	if err := json.NewDecoder(r.Body).Decode(mtr); err != nil {
+		t.Fatal(err)
+	}
+	if len(mtr.Token) == 0 || mtr.Token == mtr.Id {
+		t.Fatalf("Unexpected machine token %#v", mtr)
+	}
+	return mtr
+}
+
+// machineGet sends the machine token instead of the session
+func machineGet(token, url string, header ...string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func TestMachineGetSecret(t *testing.T) {
+	u := loginDummyUser()
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vs := &vaultListResponse{}
+	r, err := GetRequest(fmt.Sprintf("/team/%s/vault", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	if err := json.NewDecoder(r.Body).Decode(vs); err != nil {
+		t.Fatal(err)
+	}
+	v := vs.Vaults[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	r, err = PostRequest(fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id), &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	mt := issueMachineToken(t, team.Id, v.Id)
+	base := strings.TrimSuffix(srv.URL, "/api")
+	for _, path := range []string{"/api/v1/machine", "/machine/v1"} {
+		r, err = machineGet(mt.Token, fmt.Sprintf("%s%s/%s/%s/%s", base, path, team.Id, v.Id, s.Id))
+		CheckErrorAndResponse(t, r, err, 200)
+		msr := &machineSecretResponse{}
+		if err := json.NewDecoder(r.Body).Decode(msr); err != nil {
+			t.Fatal(err)
+		}
+		if msr.Secret != s.Id || msr.Version != s.Version || !bytes.Equal(msr.Data, s.Data) || !bytes.Equal(msr.VaultKey, v.Key) {
+			t.Errorf("%s: unexpected envelope %#v", path, msr)
+		}
+	}
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s/%s?version=1", srv.URL, team.Id, v.Id, s.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	etag := r.Header.Get("ETag")
+	if len(etag) == 0 {
+		t.Fatal("Missing ETag")
+	}
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/v1/%s/%s/%s", base, team.Id, v.Id, s.Id), "If-None-Match", etag)
+	CheckErrorAndResponse(t, r, err, 304)
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s/%s?version=2", srv.URL, team.Id, v.Id, s.Id))
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s/%s?version=last", srv.URL, team.Id, v.Id, s.Id))
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s/nonexistent", srv.URL, team.Id, v.Id))
+	CheckErrorAndResponse(t, r, err, 404)
+	//The token only reads the vault it was issued for
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/nonexistent/%s", srv.URL, team.Id, s.Id))
+	CheckErrorAndResponse(t, r, err, 404)
+	//Sessions aren't accepted by the machine api and machine tokens aren't accepted anywhere else
+	r, err = GetRequest(fmt.Sprintf("/machine/%s/%s/%s", team.Id, v.Id, s.Id))
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/team/%s/vault/%s", srv.URL, team.Id, v.Id))
+	CheckErrorAndResponse(t, r, err, 401)
+}
+
+func TestMachineListSecrets(t *testing.T) {
+	u := loginDummyUser()
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vs := &vaultListResponse{}
+	r, err := GetRequest(fmt.Sprintf("/team/%s/vault", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	if err := json.NewDecoder(r.Body).Decode(vs); err != nil {
+		t.Fatal(err)
+	}
+	v := vs.Vaults[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	mt := issueMachineToken(t, team.Id, v.Id)
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s", srv.URL, team.Id, v.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	etag := r.Header.Get("ETag")
+	before := &machineSecretListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(before); err != nil {
+		t.Fatal(err)
+	}
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/v1/%s/%s", strings.TrimSuffix(srv.URL, "/api"), team.Id, v.Id), "If-None-Match", etag)
+	CheckErrorAndResponse(t, r, err, 304)
+	r, err = PostRequest(fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id), &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s", srv.URL, team.Id, v.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	if r.Header.Get("ETag") == etag {
+		t.Error("The ETag didn't change with a new secret")
+	}
+	after := &machineSecretListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(after); err != nil {
+		t.Fatal(err)
+	}
+	if len(after.Secrets) != len(before.Secrets)+1 {
+		t.Fatalf("Expected %d secrets and got %d", len(before.Secrets)+1, len(after.Secrets))
+	}
+	found := false
+	for _, ms := range after.Secrets {
+		found = found || (ms.Secret == s.Id && ms.Version == s.Version)
+	}
+	if !found {
+		t.Errorf("Secret %s not in the listing", s.Id)
+	}
+}
+
+func TestMachineTokenRevoke(t *testing.T) {
+	u := loginDummyUser()
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vs := &vaultListResponse{}
+	r, err := GetRequest(fmt.Sprintf("/team/%s/vault", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	if err := json.NewDecoder(r.Body).Decode(vs); err != nil {
+		t.Fatal(err)
+	}
+	v := vs.Vaults[0]
+	r, err = PostRequest(fmt.Sprintf("/team/%s/vault/%s/machine_token", team.Id, v.Id), &machineTokenCreateRequest{})
+	CheckErrorAndResponse(t, r, err, 400)
+	mt := issueMachineToken(t, team.Id, v.Id)
+	r, err = GetRequest(fmt.Sprintf("/team/%s/vault/%s/machine_token", team.Id, v.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	mtl := &machineTokenListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(mtl); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, listed := range mtl.Tokens {
+		found = found || listed.Id == mt.Id
+	}
+	if !found {
+		t.Fatalf("Machine token %s not in the listing", mt.Id)
+	}
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s", srv.URL, team.Id, v.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	//The listed id is the hash of the token so it can't be used as one
+	r, err = machineGet(mt.Id, fmt.Sprintf("%s/machine/%s/%s", srv.URL, team.Id, v.Id))
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/vault/%s/machine_token/%s", team.Id, v.Id, mt.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s", srv.URL, team.Id, v.Id))
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/vault/%s/machine_token/%s", team.Id, v.Id, mt.Id))
+	CheckErrorAndResponse(t, r, err, 404)
+}
+
+func TestMachineTokenLabels(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	r, err := PostRequest(fmt.Sprintf("/team/%s/label", team.Id), labelRequest{"machine", "#0000aa"})
+	CheckErrorAndResponse(t, r, err, 200)
+	l := &models.Label{}
+	if err := json.NewDecoder(r.Body).Decode(l); err != nil {
+		t.Fatal(err)
+	}
+	vfs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vfs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	sids := make([]string, 2)
+	for i := range sids {
+		r, err = PostRequest(fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id), vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+		CheckErrorAndResponse(t, r, err, 200)
+		s := &models.Secret{}
+		if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+			t.Fatal(err)
+		}
+		sids[i] = s.Id
+	}
+	r, err = PutRequest(fmt.Sprintf("/team/%s/vault/%s/secret/%s/labels", team.Id, v.Id, sids[0]), secretLabelsRequest{[]string{l.Id}})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest(fmt.Sprintf("/team/%s/vault/%s/machine_token", team.Id, v.Id), &machineTokenCreateRequest{Name: "ci", Labels: []string{"nope"}})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest(fmt.Sprintf("/team/%s/vault/%s/machine_token", team.Id, v.Id), &machineTokenCreateRequest{Name: "ci", Labels: []string{l.Id}})
+	CheckErrorAndResponse(t, r, err, 200)
+	mt := &machineTokenCreateResponse{}
+	if err := json.NewDecoder(r.Body).Decode(mt); err != nil {
+		t.Fatal(err)
+	}
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s", srv.URL, team.Id, v.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	msl := &machineSecretListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(msl); err != nil {
+		t.Fatal(err)
+	}
+	if len(msl.Secrets) != 1 || msl.Secrets[0].Secret != sids[0] {
+		t.Errorf("Expected only %s and got %#v", sids[0], msl.Secrets)
+	}
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s/%s", srv.URL, team.Id, v.Id, sids[0]))
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s/%s", srv.URL, team.Id, v.Id, sids[1]))
+	CheckErrorAndResponse(t, r, err, 404)
+	//Without its labels the token reads nothing
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/label/%s", team.Id, l.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = machineGet(mt.Token, fmt.Sprintf("%s/machine/%s/%s/%s", srv.URL, team.Id, v.Id, sids[0]))
+	CheckErrorAndResponse(t, r, err, 404)
+}
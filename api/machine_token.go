@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+type machineTokenCreateRequest struct {
+	Name   string   `json:"name"`
+	Labels []string `json:"labels"`
+}
+
+// machineTokenCreateResponse is the only time the token is sent. Only its hash is stored
+type machineTokenCreateResponse struct {
+	*models.MachineToken
+	Token string `json:"token"`
+}
+
+type machineTokenListResponse struct {
+	Tokens []*models.MachineToken `json:"tokens"`
+}
+
+// /team/:tid/vault/:vid/machine_token
+func (ah apiHandler) validVaultMachineTokenRoot(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	var mid string
+	mid, r.URL.Path = shiftPath(r.URL.Path)
+	if len(mid) == 0 {
+		switch r.Method {
+		case "GET":
+			return ah.vaultMachineTokenList(w, r, v)
+		case "POST":
+			return ah.vaultMachineTokenCreate(w, r, t, v)
+		}
+	} else if r.Method == "DELETE" && r.URL.Path == "/" {
+		return ah.vaultMachineTokenDelete(w, r, t, v, mid)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /team/:tid/vault/:vid/machine_token
+func (ah apiHandler) vaultMachineTokenList(w http.ResponseWriter, r *http.Request, v *models.Vault) error {
+	mts, err := v.GetMachineTokens(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, machineTokenListResponse{mts})
+}
+
+// POST /team/:tid/vault/:vid/machine_token
+func (ah apiHandler) vaultMachineTokenCreate(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	mcr := &machineTokenCreateRequest{}
+	if err := jsonDecode(w, r, 1024, mcr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	mt, err := v.CreateMachineToken(ctx, ctxGetUser(ctx), mcr.Name, mcr.Labels)
+	if err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_MACHINE_TOKEN_CREATE, auditObject("team", t.Id, "vault", v.Id, "machine_token", mt.Id))
+	return jsonResponse(w, machineTokenCreateResponse{mt.MachineToken, mt.Secret})
+}
+
+// DELETE /team/:tid/vault/:vid/machine_token/:mid
+func (ah apiHandler) vaultMachineTokenDelete(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, mid string) error {
+	ctx := r.Context()
+	mt, err := v.GetMachineToken(ctx, mid)
+	if err != nil {
+		return err
+	}
+	//Tokens are revoked by whoever issued them or by the team admins
+	if mt.User != ctxGetUser(ctx).Id {
+		if err := checkTeamAdmin(r, t); err != nil {
+			return err
+		}
+	}
+	if err := v.DeleteMachineToken(ctx, mid); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_MACHINE_TOKEN_DELETE, auditObject("team", t.Id, "vault", v.Id, "machine_token", mid))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
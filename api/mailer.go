@@ -5,7 +5,10 @@ import (
 	"html/template"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/keydotcat/keycatd/managers"
 	"github.com/keydotcat/keycatd/models"
@@ -14,6 +17,7 @@ import (
 )
 
 type mailer struct {
+	pending      int32
 	templatesDir string
 	rootUrl      string
 	lock         *sync.Mutex
@@ -25,7 +29,8 @@ type mailer struct {
 func newMailer(rootUrl string, testMode bool, mm managers.MailMgr) (*mailer, error) {
 	m := &mailer{}
 	m.templatesDir = "mail"
-	m.rootUrl = rootUrl
+	//The templates add the paths after the url
+	m.rootUrl = strings.TrimRight(rootUrl, "/")
 	m.lock = &sync.Mutex{}
 	m.mailMgr = mm
 	if err := m.compile(); err != nil {
@@ -55,21 +60,34 @@ func (mm *mailer) compile() error {
 }
 
 type mailUserTeamTokenData struct {
-	FullName string
-	HostUrl  string
-	Team     string
-	Token    string
-	Email    string
-	Username string
+	FullName  string
+	HostUrl   string
+	Team      string
+	Token     string
+	Email     string
+	Username  string
+	Vault     string
+	ExpiresAt string
+	Message   string
+}
+
+func (mm *mailer) queueDepth() int32 {
+	return atomic.LoadInt32(&mm.pending)
 }
 
 func (mm *mailer) send(muttd mailUserTeamTokenData, locale, templateName, subject string) error {
 	if mm.TestMode {
 		return nil
 	}
+	atomic.AddInt32(&mm.pending, 1)
+	defer atomic.AddInt32(&mm.pending, -1)
 	buf := util.BufPool.Get()
 	defer util.BufPool.Put(buf)
+	//pt-BR falls back to pt and then to en
 	tpl := mm.t.Lookup(fmt.Sprintf("%s/%s", locale, templateName))
+	if i := strings.Index(locale, "-"); tpl == nil && i > 0 {
+		tpl = mm.t.Lookup(fmt.Sprintf("%s/%s", locale[:i], templateName))
+	}
 	if tpl == nil {
 		tpl = mm.t.Lookup("en/" + templateName)
 	}
@@ -80,44 +98,96 @@ func (mm *mailer) send(muttd mailUserTeamTokenData, locale, templateName, subjec
 	if err != nil {
 		panic(err)
 	}
-	return mm.mailMgr.SendMail(muttd.Email, subject, buf.String())
+	return mm.getMailMgr().SendMail(muttd.Email, subject, buf.String())
+}
+
+func (mm *mailer) getMailMgr() managers.MailMgr {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	return mm.mailMgr
+}
+
+func (mm *mailer) setMailMgr(mailMgr managers.MailMgr) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	mm.mailMgr = mailMgr
 }
 
-func (mm *mailer) sendConfirmationMail(u *models.User, token *models.Token, locale string) error {
+// userLocale is the locale the user picked or the one of the request if it hasn't
+func userLocale(u *models.User, requested string) string {
+	if len(u.Locale) > 0 {
+		return u.Locale
+	}
+	return requested
+}
+
+func (mm *mailer) sendConfirmationMail(u *models.User, token *models.IssuedToken, locale string) error {
 	email := u.Email
 	if u.UnconfirmedEmail != "" {
 		email = u.UnconfirmedEmail
 	}
-	muttd := mailUserTeamTokenData{FullName: u.FullName, HostUrl: mm.rootUrl, Token: token.Id, Username: u.Id, Email: email}
-	return mm.send(muttd, locale, "confirm_account", "Confirm your email")
+	muttd := mailUserTeamTokenData{FullName: u.FullName, HostUrl: mm.rootUrl, Token: token.Secret, Username: u.Id, Email: email}
+	return mm.send(muttd, userLocale(u, locale), "confirm_account", "Confirm your email")
 }
 
 func (mm *mailer) sendInvitationMail(t *models.Team, u *models.User, i *models.Invite, locale string) error {
-	muttd := mailUserTeamTokenData{FullName: u.FullName, HostUrl: mm.rootUrl, Email: i.Email, Team: t.Name}
+	muttd := mailUserTeamTokenData{FullName: u.FullName, HostUrl: mm.rootUrl, Email: i.Email, Team: t.Name, Message: i.Message}
 	return mm.send(muttd, locale, "invite_user", fmt.Sprintf("%s has invited you to join key.cat", u.FullName))
 }
 
+func (mm *mailer) sendVaultAccessExpiringMail(u *models.User, va *models.VaultAccess, locale string) error {
+	muttd := mailUserTeamTokenData{FullName: u.FullName, HostUrl: mm.rootUrl, Email: u.Email, Team: va.Team, Username: va.User, Vault: va.Vault, ExpiresAt: va.ExpiresAt.In(u.Location()).Format(time.RFC1123)}
+	return mm.send(muttd, userLocale(u, locale), "vault_access_expiring", fmt.Sprintf("The access of %s to the vault %s is about to expire", va.User, va.Vault))
+}
+
+func (mm *mailer) sendPasswordChangedMail(u *models.User, locale string) error {
+	muttd := mailUserTeamTokenData{FullName: u.FullName, HostUrl: mm.rootUrl, Email: u.Email, Username: u.Id}
+	return mm.send(muttd, userLocale(u, locale), "password_changed", "Your password has been changed")
+}
+
 func (mm *mailer) sendTestEmail(to string) error {
 	muttd := mailUserTeamTokenData{Email: to}
 	return mm.send(muttd, "en", "test_email", "KeyCat test email")
 }
 
-func SendTestEmail(c Conf, to string) error {
-	err := c.validate()
-	if err != nil {
-		return err
-	}
-	var m *mailer
+func newMailMgr(c Conf) managers.MailMgr {
 	switch {
 	case c.MailSMTP != nil:
-		m, err = newMailer(c.Url, TEST_MODE, managers.NewMailMgrSMTP(c.MailSMTP.Server, c.MailSMTP.User, c.MailSMTP.Password, c.MailFrom))
+		return managers.NewMailMgrSMTP(c.MailSMTP.Server, c.MailSMTP.User, c.MailSMTP.Password, c.MailFrom)
 	case c.MailSparkpost != nil:
-		m, err = newMailer(c.Url, TEST_MODE, managers.NewMailMgrSparkpost(c.MailSparkpost.Key, c.MailFrom, c.MailSparkpost.EU))
-	default:
-		return util.NewErrorf("No mail was configured")
+		return managers.NewMailMgrSparkpost(c.MailSparkpost.Key, c.MailFrom, c.MailSparkpost.EU)
 	}
+	return nil
+}
+
+func newMailerFromConf(c Conf) (*mailer, error) {
+	err := c.validate()
 	if err != nil {
-		return util.NewErrorf("Could not create mailer: %s", err)
+		return nil, err
+	}
+	mailMgr := newMailMgr(c)
+	if mailMgr == nil {
+		return nil, util.NewErrorf("No mail was configured")
+	}
+	m, err := newMailer(c.Url, TEST_MODE, mailMgr)
+	if err != nil {
+		return nil, util.NewErrorf("Could not create mailer: %s", err)
+	}
+	return m, nil
+}
+
+func SendTestEmail(c Conf, to string) error {
+	m, err := newMailerFromConf(c)
+	if err != nil {
+		return err
 	}
 	return m.sendTestEmail(to)
 }
+
+func SendInvitationEmail(c Conf, t *models.Team, inviter *models.User, i *models.Invite) error {
+	m, err := newMailerFromConf(c)
+	if err != nil {
+		return err
+	}
+	return m.sendInvitationMail(t, inviter, i, "en")
+}
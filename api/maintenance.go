@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const maintenanceDefaultRetryAfter = 300
+
+type maintenanceStatus struct {
+	Enabled    bool      `json:"enabled"`
+	Message    string    `json:"message,omitempty"`
+	RetryAfter int       `json:"retry_after,omitempty"`
+	Since      time.Time `json:"since,omitempty"`
+	EnabledBy  string    `json:"enabled_by,omitempty"`
+}
+
+type maintenance struct {
+	lock   *sync.RWMutex
+	status maintenanceStatus
+}
+
+func newMaintenance() *maintenance {
+	return &maintenance{lock: &sync.RWMutex{}}
+}
+
+func (m *maintenance) get() maintenanceStatus {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.status
+}
+
+func (m *maintenance) set(ms maintenanceStatus) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.status = ms
+}
+
+// reloadMaintenance loads the maintenance mode stored in the db so every instance of a cluster blocks the same requests
+func (ah apiHandler) reloadMaintenance(ctx context.Context) error {
+	m, err := models.GetMaintenance(models.AddDBToContext(ctx, ah.db))
+	switch {
+	case util.CheckErr(err, models.ErrDoesntExist):
+		ah.maintenance.set(maintenanceStatus{})
+		return nil
+	case err != nil:
+		return err
+	}
+	ah.maintenance.set(maintenanceStatus{true, m.Message, m.RetryAfter, m.Since, m.EnabledBy})
+	return nil
+}
+
+type maintenanceResponse struct {
+	Error      string    `json:"error"`
+	Message    string    `json:"message,omitempty"`
+	RetryAfter int       `json:"retry_after"`
+	Since      time.Time `json:"since"`
+	RequestId  string    `json:"request_id,omitempty"`
+}
+
+// maintenanceBlock answers with a 503 if the server is in maintenance. Returns true if the request has been answered
+func (ah apiHandler) maintenanceBlock(w http.ResponseWriter, r *http.Request) bool {
+	ms := ah.maintenance.get()
+	if !ms.Enabled {
+		return false
+	}
+	b := util.BufPool.Get()
+	defer util.BufPool.Put(b)
+	json.NewEncoder(b).Encode(maintenanceResponse{"maintenance", ms.Message, ms.RetryAfter, ms.Since, ctxGetRequestId(r.Context())})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.Bytes())))
+	w.Header().Set("Retry-After", strconv.Itoa(ms.RetryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	b.WriteTo(w)
+	return true
+}
+
+// /admin/maintenance
+func (ah apiHandler) adminMaintenanceRoot(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return jsonResponse(w, ah.maintenance.get())
+	case "PUT":
+		return ah.adminMaintenanceEnable(w, r)
+	case "DELETE":
+		return ah.adminMaintenanceDisable(w, r)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type adminMaintenanceRequest struct {
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+// PUT /admin/maintenance
+func (ah apiHandler) adminMaintenanceEnable(w http.ResponseWriter, r *http.Request) error {
+	amr := &adminMaintenanceRequest{}
+	if err := jsonDecode(w, r, 4096, amr); err != nil {
+		return err
+	}
+	if amr.RetryAfter < 0 {
+		return util.NewErrorf("Invalid retry_after")
+	}
+	if amr.RetryAfter == 0 {
+		amr.RetryAfter = maintenanceDefaultRetryAfter
+	}
+	ctx := r.Context()
+	m := &models.Maintenance{Message: amr.Message, RetryAfter: amr.RetryAfter, Since: time.Now().UTC(), EnabledBy: ctxGetUser(ctx).Id}
+	if err := models.SetMaintenance(ctx, m); err != nil {
+		return err
+	}
+	ah.bcast.Invalidate(INVALIDATE_MAINTENANCE, "")
+	if err := ah.reloadMaintenance(ctx); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_ADMIN_MAINTENANCE_ON, "maintenance")
+	return jsonResponse(w, ah.maintenance.get())
+}
+
+// DELETE /admin/maintenance
+func (ah apiHandler) adminMaintenanceDisable(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if err := models.ClearMaintenance(ctx); err != nil {
+		return err
+	}
+	ah.bcast.Invalidate(INVALIDATE_MAINTENANCE, "")
+	if err := ah.reloadMaintenance(ctx); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_ADMIN_MAINTENANCE_OFF, "maintenance")
+	return jsonResponse(w, ah.maintenance.get())
+}
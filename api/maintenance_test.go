@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	defer func() {
+		models.ClearMaintenance(getCtx())
+		apiH.maintenance.set(maintenanceStatus{})
+	}()
+	u := loginDummyUser()
+	r, err := PutRequest("/admin/maintenance", adminMaintenanceRequest{"Upgrading", 0})
+	CheckErrorAndResponse(t, r, err, 401)
+	if err := u.SetAdmin(getCtx(), true); err != nil {
+		t.Fatal(err)
+	}
+	r, err = PutRequest("/admin/maintenance", adminMaintenanceRequest{"Upgrading", 60})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/user")
+	CheckErrorAndResponse(t, r, err, 503)
+	if r.Header.Get("Retry-After") != "60" {
+		t.Errorf("Unexpected Retry-After header %s", r.Header.Get("Retry-After"))
+	}
+	mr := &maintenanceResponse{}
+	if err := json.NewDecoder(r.Body).Decode(mr); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Error != "maintenance" || mr.Message != "Upgrading" {
+		t.Errorf("Unexpected maintenance response %#v", mr)
+	}
+	r, err = GetRequest("/admin/maintenance")
+	CheckErrorAndResponse(t, r, err, 200)
+	//Another instance or a restart loads it from the db
+	apiH.maintenance.set(maintenanceStatus{})
+	if err := apiH.reloadMaintenance(getCtx()); err != nil {
+		t.Fatal(err)
+	}
+	if ms := apiH.maintenance.get(); !ms.Enabled || ms.Message != "Upgrading" || ms.EnabledBy != u.Id {
+		t.Errorf("Maintenance mode was not stored: %#v", ms)
+	}
+	r, err = DeleteRequest("/admin/maintenance")
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/user")
+	CheckErrorAndResponse(t, r, err, 200)
+	if _, err := models.GetMaintenance(getCtx()); !util.CheckErr(err, models.ErrDoesntExist) {
+		t.Errorf("Expected the maintenance mode to be removed from the db and got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// /team/:tid/matrix
+func (ah apiHandler) matrixRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if len(head) > 0 {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	ctx := r.Context()
+	isAdmin, err := t.CheckAdmin(ctx, ctxGetUser(ctx))
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	switch r.Method {
+	case "GET":
+		return ah.matrixGet(w, r, t)
+	case "PUT":
+		return ah.matrixSet(w, r, t)
+	case "DELETE":
+		return ah.matrixDelete(w, r, t)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /team/:tid/matrix
+func (ah apiHandler) matrixGet(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	tm, err := t.GetMatrix(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, tm)
+}
+
+type matrixSetRequest struct {
+	Homeserver  string `json:"homeserver"`
+	AccessToken string `json:"access_token"`
+	RoomId      string `json:"room_id"`
+}
+
+// PUT /team/:tid/matrix
+func (ah apiHandler) matrixSet(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	msr := &matrixSetRequest{}
+	if err := jsonDecode(w, r, 4096, msr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	tm := &models.TeamMatrix{Homeserver: msr.Homeserver, AccessToken: msr.AccessToken, RoomId: msr.RoomId}
+	if err := t.SetMatrix(ctx, ctxGetUser(ctx), tm); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_MATRIX_SET, auditObject("team", t.Id, "room", tm.RoomId))
+	return jsonResponse(w, tm)
+}
+
+// DELETE /team/:tid/matrix
+func (ah apiHandler) matrixDelete(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	ctx := r.Context()
+	if err := t.DeleteMatrix(ctx, ctxGetUser(ctx)); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_MATRIX_DELETE, auditObject("team", t.Id))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestSetAndDeleteTeamMatrix(t *testing.T) {
+	u := loginDummyUser()
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/team/%s/matrix", teams[0].Id)
+	r, err := GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = PutRequest(path, matrixSetRequest{"https://matrix.org", "token", "invalid"})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PutRequest(path, matrixSetRequest{"http://169.254.169.254", "token", "!room:matrix.org"})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PutRequest(path, matrixSetRequest{"https://matrix.org/", "token", "!room:matrix.org"})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 200)
+	tm := &models.TeamMatrix{}
+	if err := json.NewDecoder(r.Body).Decode(tm); err != nil {
+		t.Fatal(err)
+	}
+	if tm.Homeserver != "https://matrix.org" || tm.RoomId != "!room:matrix.org" {
+		t.Errorf("Unexpected matrix settings %#v", tm)
+	}
+	if len(tm.AccessToken) > 0 {
+		t.Errorf("The access token must not be returned")
+	}
+	r, err = DeleteRequest(path)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 404)
+}
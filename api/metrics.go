@@ -0,0 +1,332 @@
+package api
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var metricsLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metricsRequestKey struct {
+	route  string
+	method string
+	status int
+}
+
+type metricsDeprecatedKey struct {
+	route  string
+	client string
+}
+
+type metricsLatency struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+type metrics struct {
+	//Atomically updated counters go first to keep them 64bit aligned
+	loginSuccess          uint64
+	loginFailure          uint64
+	purgedTokens          uint64
+	purgedSessions        uint64
+	purgedIdempotencyKeys uint64
+	lock                  *sync.Mutex
+	requests              map[metricsRequestKey]uint64
+	latency               map[string]*metricsLatency
+	streamsByKind         map[string]int64
+	deprecated            map[metricsDeprecatedKey]uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		lock:          &sync.Mutex{},
+		requests:      map[metricsRequestKey]uint64{},
+		latency:       map[string]*metricsLatency{},
+		streamsByKind: map[string]int64{},
+		deprecated:    map[metricsDeprecatedKey]uint64{},
+	}
+}
+
+func (m *metrics) observeRequest(route, method string, status int, elapsed time.Duration, hijacked bool) {
+	if !metricsMethods[method] {
+		method = "other"
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests[metricsRequestKey{route, method, status}]++
+	//Streams live as long as the client is connected so their latency means nothing
+	if hijacked {
+		return
+	}
+	l, ok := m.latency[route]
+	if !ok {
+		l = &metricsLatency{buckets: make([]uint64, len(metricsLatencyBuckets))}
+		m.latency[route] = l
+	}
+	secs := elapsed.Seconds()
+	for i, b := range metricsLatencyBuckets {
+		if secs <= b {
+			l.buckets[i]++
+		}
+	}
+	l.count++
+	l.sum += secs
+}
+
+func (m *metrics) observeLogin(success bool) {
+	if success {
+		atomic.AddUint64(&m.loginSuccess, 1)
+	} else {
+		atomic.AddUint64(&m.loginFailure, 1)
+	}
+}
+
+func (m *metrics) observeDeprecated(route, client string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.deprecated[metricsDeprecatedKey{route, client}]++
+}
+
+func (m *metrics) streamOpened(kind string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.streamsByKind[kind]++
+}
+
+func (m *metrics) streamClosed(kind string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.streamsByKind[kind]--
+}
+
+func (m *metrics) streamCounts() map[string]int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	counts := make(map[string]int64, len(m.streamsByKind))
+	for k, v := range m.streamsByKind {
+		counts[k] = v
+	}
+	return counts
+}
+
+// metricsRouteHeads are the roots of the api. The labels come from requests that may not even be authenticated so
+// anything else is counted as other instead of adding a series for every made up path
+var metricsRouteHeads = map[string]bool{
+	"auth": true, "version": true, "openapi.json": true, "idp": true, "oidc": true, "session": true, "user": true,
+	"team": true, "ws": true, "eventsource": true, "admin": true, "batch": true, "machine": true, "keylog": true,
+	"device": true, "account": true,
+}
+
+var metricsMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "OPTIONS": true,
+}
+
+func metricsRoute(path string) string {
+	head, tail := shiftPath(path)
+	if head != "api" {
+		return "static"
+	}
+	//Every version of a route is counted together
+	_, tail, _ = splitApiVersion(tail)
+	head, _ = shiftPath(tail)
+	switch {
+	case len(head) == 0:
+		return "/api"
+	case !metricsRouteHeads[head]:
+		return "/api/other"
+	}
+	return "/api/" + head
+}
+
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (ah apiHandler) writeMetrics(w io.Writer) {
+	m := ah.metrics
+	m.lock.Lock()
+	keys := make([]metricsRequestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	writeMetricHeader(w, "keycatd_http_requests_total", "counter", "HTTP requests by route, method and status")
+	for _, k := range keys {
+		fmt.Fprintf(w, "keycatd_http_requests_total{route=%q,method=%q,status=\"%d\"} %d\n", k.route, k.method, k.status, m.requests[k])
+	}
+	routes := make([]string, 0, len(m.latency))
+	for r := range m.latency {
+		routes = append(routes, r)
+	}
+	sort.Strings(routes)
+	writeMetricHeader(w, "keycatd_http_request_duration_seconds", "histogram", "HTTP request latency by route")
+	for _, r := range routes {
+		l := m.latency[r]
+		for i, b := range metricsLatencyBuckets {
+			fmt.Fprintf(w, "keycatd_http_request_duration_seconds_bucket{route=%q,le=%q} %d\n", r, strconv.FormatFloat(b, 'g', -1, 64), l.buckets[i])
+		}
+		fmt.Fprintf(w, "keycatd_http_request_duration_seconds_bucket{route=%q,le=\"+Inf\"} %d\n", r, l.count)
+		fmt.Fprintf(w, "keycatd_http_request_duration_seconds_sum{route=%q} %g\n", r, l.sum)
+		fmt.Fprintf(w, "keycatd_http_request_duration_seconds_count{route=%q} %d\n", r, l.count)
+	}
+	kinds := make([]string, 0, len(m.streamsByKind))
+	for k := range m.streamsByKind {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	writeMetricHeader(w, "keycatd_event_stream_connections", "gauge", "Open event stream connections")
+	for _, k := range kinds {
+		fmt.Fprintf(w, "keycatd_event_stream_connections{kind=%q} %d\n", k, m.streamsByKind[k])
+	}
+	dkeys := make([]metricsDeprecatedKey, 0, len(m.deprecated))
+	for k := range m.deprecated {
+		dkeys = append(dkeys, k)
+	}
+	sort.Slice(dkeys, func(i, j int) bool {
+		if dkeys[i].route != dkeys[j].route {
+			return dkeys[i].route < dkeys[j].route
+		}
+		return dkeys[i].client < dkeys[j].client
+	})
+	writeMetricHeader(w, "keycatd_deprecated_requests_total", "counter", "Requests to deprecated routes, versions and unversioned paths by type of client")
+	for _, k := range dkeys {
+		fmt.Fprintf(w, "keycatd_deprecated_requests_total{route=%q,client=%q} %d\n", k.route, k.client, m.deprecated[k])
+	}
+	m.lock.Unlock()
+	writeMetricHeader(w, "keycatd_logins_total", "counter", "Login attempts by result")
+	fmt.Fprintf(w, "keycatd_logins_total{result=\"success\"} %d\n", atomic.LoadUint64(&m.loginSuccess))
+	fmt.Fprintf(w, "keycatd_logins_total{result=\"failure\"} %d\n", atomic.LoadUint64(&m.loginFailure))
+	writeMetricHeader(w, "keycatd_cleanup_purged_total", "counter", "Rows removed by the cleanup job by kind")
+	fmt.Fprintf(w, "keycatd_cleanup_purged_total{kind=\"token\"} %d\n", atomic.LoadUint64(&m.purgedTokens))
+	fmt.Fprintf(w, "keycatd_cleanup_purged_total{kind=\"session\"} %d\n", atomic.LoadUint64(&m.purgedSessions))
+	fmt.Fprintf(w, "keycatd_cleanup_purged_total{kind=\"idempotency_key\"} %d\n", atomic.LoadUint64(&m.purgedIdempotencyKeys))
+	if count, err := ah.sm.CountSessions(); err != nil {
+		log.Printf("[ERROR] Could not count sessions: %s", err)
+	} else {
+		writeMetricHeader(w, "keycatd_sessions", "gauge", "Active sessions")
+		fmt.Fprintf(w, "keycatd_sessions %d\n", count)
+	}
+	if ah.mail != nil {
+		writeMetricHeader(w, "keycatd_mail_queue_depth", "gauge", "Mails waiting to be handed to the mail provider")
+		fmt.Fprintf(w, "keycatd_mail_queue_depth %d\n", ah.mail.queueDepth())
+	}
+	writeDBMetrics(w, ah.db.Stats())
+}
+
+func writeDBMetrics(w io.Writer, s sql.DBStats) {
+	writeMetricHeader(w, "keycatd_db_connections", "gauge", "DB pool connections by state")
+	fmt.Fprintf(w, "keycatd_db_connections{state=\"in_use\"} %d\n", s.InUse)
+	fmt.Fprintf(w, "keycatd_db_connections{state=\"idle\"} %d\n", s.Idle)
+	writeMetricHeader(w, "keycatd_db_max_open_connections", "gauge", "Maximum number of open DB connections")
+	fmt.Fprintf(w, "keycatd_db_max_open_connections %d\n", s.MaxOpenConnections)
+	writeMetricHeader(w, "keycatd_db_wait_count_total", "counter", "Times a DB connection had to be waited for")
+	fmt.Fprintf(w, "keycatd_db_wait_count_total %d\n", s.WaitCount)
+	writeMetricHeader(w, "keycatd_db_wait_duration_seconds_total", "counter", "Time spent waiting for DB connections")
+	fmt.Fprintf(w, "keycatd_db_wait_duration_seconds_total %g\n", s.WaitDuration.Seconds())
+	writeMetricHeader(w, "keycatd_db_pool_saturation", "gauge", "Fraction of the maximum open DB connections in use. 0 without a maximum")
+	saturation := 0.0
+	if s.MaxOpenConnections > 0 {
+		saturation = float64(s.InUse) / float64(s.MaxOpenConnections)
+	}
+	fmt.Fprintf(w, "keycatd_db_pool_saturation %g\n", saturation)
+	writeMetricHeader(w, "keycatd_db_closed_connections_total", "counter", "DB connections closed by the pool limits by reason")
+	fmt.Fprintf(w, "keycatd_db_closed_connections_total{reason=\"max_idle\"} %d\n", s.MaxIdleClosed)
+	fmt.Fprintf(w, "keycatd_db_closed_connections_total{reason=\"max_lifetime\"} %d\n", s.MaxLifetimeClosed)
+}
+
+// GET /metrics
+func (ah apiHandler) metricsRoot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := ah.opts().metricsToken; len(token) > 0 {
+		expected := []byte("Bearer " + token)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	ah.writeMetrics(w)
+}
+
+func (ah apiHandler) newMetricsServer(port int) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", ah.metricsRoot)
+	return &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+}
+
+func serveMetrics(s *http.Server) {
+	log.Printf("Serving metrics at %s", s.Addr)
+	if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("[ERROR] Metrics listener stopped: %s", err)
+	}
+}
+
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (mw *metricsResponseWriter) WriteHeader(status int) {
+	if mw.status == 0 {
+		mw.status = status
+	}
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	if mw.status == 0 {
+		mw.status = http.StatusOK
+	}
+	return mw.ResponseWriter.Write(b)
+}
+
+func (mw *metricsResponseWriter) Flush() {
+	if f, ok := mw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (mw *metricsResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := mw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("Response writer does not support hijacking")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		mw.hijacked = true
+		if mw.status == 0 {
+			mw.status = http.StatusSwitchingProtocols
+		}
+	}
+	return conn, rw, err
+}
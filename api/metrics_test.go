@@ -0,0 +1,56 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsRoute(t *testing.T) {
+	checks := map[string]string{
+		"/api/team/t1/vault": "/api/team",
+		"/api/auth/login":    "/api/auth",
+		"/api/v1/auth/login": "/api/auth",
+		"/api":               "/api",
+		"/api/v1/random1234": "/api/other",
+		"/index.html":        "static",
+	}
+	for p, route := range checks {
+		if got := metricsRoute(p); got != route {
+			t.Errorf("Expected route %s for %s and got %s", route, p, got)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	r, err := GetRequest("/version")
+	CheckErrorAndResponse(t, r, err, 200)
+	url := strings.TrimSuffix(srv.URL, "/api") + "/metrics"
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = http.DefaultClient.Do(req)
+	CheckErrorAndResponse(t, r, err, 401)
+	req.Header.Set("Authorization", "Bearer metrics-test-token")
+	r, err = http.DefaultClient.Do(req)
+	CheckErrorAndResponse(t, r, err, 200)
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metric := range []string{
+		`keycatd_http_requests_total{route="/api/version",method="GET",status="200"}`,
+		`keycatd_http_request_duration_seconds_count{route="/api/version"}`,
+		`keycatd_logins_total{result="success"}`,
+		`keycatd_sessions `,
+		`keycatd_db_connections{state="in_use"}`,
+		`keycatd_db_pool_saturation `,
+		`keycatd_db_closed_connections_total{reason="max_lifetime"}`,
+	} {
+		if !strings.Contains(string(data), metric) {
+			t.Errorf("Metric %s is missing from:\n%s", metric, data)
+		}
+	}
+}
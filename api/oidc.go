@@ -0,0 +1,379 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	OIDC_SCOPE_OPENID  = "openid"
+	OIDC_SCOPE_EMAIL   = "email"
+	OIDC_SCOPE_PROFILE = "profile"
+	//Tokens can't be revoked so they are short lived. Clients log in again through the web app
+	oidcTokenTTL = time.Hour
+)
+
+var oidcScopes = []string{OIDC_SCOPE_OPENID, OIDC_SCOPE_EMAIL, OIDC_SCOPE_PROFILE}
+
+func (ah apiHandler) oidcIssuer() string {
+	return strings.TrimRight(ah.live.boot.Url, "/") + "/api/" + API_VERSION_CURRENT + "/oidc"
+}
+
+func (ah apiHandler) oidcClient(id string) (ConfOIDCClient, bool) {
+	for _, oc := range ah.opts().oidcClients {
+		if oc.Id == id {
+			return oc, true
+		}
+	}
+	return ConfOIDCClient{}, false
+}
+
+// oidcCheckRedirect returns the client when the redirect uri is one of its own. Nothing is ever redirected anywhere else
+func (ah apiHandler) oidcCheckRedirect(clientId, redirectURI string) (ConfOIDCClient, error) {
+	oc, found := ah.oidcClient(clientId)
+	if !found {
+		return oc, util.NewErrorf("Unknown OIDC client %s", clientId)
+	}
+	for _, uri := range oc.RedirectURIs {
+		if uri == redirectURI {
+			return oc, nil
+		}
+	}
+	return oc, util.NewErrorf("Invalid redirect_uri for the OIDC client %s", clientId)
+}
+
+// oidcError answers with the errors in the format of the OAuth2 token endpoint
+func oidcError(w http.ResponseWriter, status int, code, description string) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+// /oidc
+func (ah apiHandler) oidcRoot(w http.ResponseWriter, r *http.Request) error {
+	if len(ah.opts().oidcClients) == 0 {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if head == ".well-known" {
+		head, r.URL.Path = shiftPath(r.URL.Path)
+		if head != "openid-configuration" {
+			return util.NewErrorFrom(ErrNotFound)
+		}
+	}
+	if r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	switch {
+	case head == "openid-configuration" && r.Method == "GET":
+		return ah.oidcDiscovery(w, r)
+	case head == "jwks" && r.Method == "GET":
+		return ah.oidcJwks(w, r)
+	case head == "authorize" && r.Method == "GET":
+		return ah.oidcAuthorizeRedirect(w, r)
+	case head == "authorize" && r.Method == "POST":
+		if r = ah.authorizeRequest(w, r); r == nil {
+			return nil
+		}
+		return ah.oidcAuthorize(w, r)
+	case head == "token" && r.Method == "POST":
+		return ah.oidcToken(w, r)
+	case head == "userinfo" && (r.Method == "GET" || r.Method == "POST"):
+		return ah.oidcUserinfo(w, r)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type oidcDiscoveryResponse struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JwksURI               string   `json:"jwks_uri"`
+	ResponseTypes         []string `json:"response_types_supported"`
+	GrantTypes            []string `json:"grant_types_supported"`
+	SubjectTypes          []string `json:"subject_types_supported"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+	Scopes                []string `json:"scopes_supported"`
+	TokenEndpointAuth     []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+	Claims                []string `json:"claims_supported"`
+}
+
+// GET /oidc/.well-known/openid-configuration
+func (ah apiHandler) oidcDiscovery(w http.ResponseWriter, r *http.Request) error {
+	iss := ah.oidcIssuer()
+	return jsonResponse(w, oidcDiscoveryResponse{
+		Issuer:                iss,
+		AuthorizationEndpoint: iss + "/authorize",
+		TokenEndpoint:         iss + "/token",
+		UserinfoEndpoint:      iss + "/userinfo",
+		JwksURI:               iss + "/jwks",
+		ResponseTypes:         []string{"code"},
+		GrantTypes:            []string{"authorization_code"},
+		SubjectTypes:          []string{"public"},
+		SigningAlgs:           []string{"RS256"},
+		Scopes:                oidcScopes,
+		TokenEndpointAuth:     []string{"client_secret_basic", "client_secret_post"},
+		CodeChallengeMethods:  []string{"S256"},
+		Claims:                []string{"sub", "email", "email_verified", "name", "preferred_username"},
+	})
+}
+
+// GET /oidc/jwks
+func (ah apiHandler) oidcJwks(w http.ResponseWriter, r *http.Request) error {
+	keys, err := ah.oidc.jwks()
+	if err != nil {
+		return internalErr(err)
+	}
+	return jsonResponse(w, keys)
+}
+
+// GET /oidc/authorize
+func (ah apiHandler) oidcAuthorizeRedirect(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	if _, err := ah.oidcCheckRedirect(q.Get("client_id"), q.Get("redirect_uri")); err != nil {
+		return err
+	}
+	//The session is only known by the web app so it's the one that asks the user and finishes the authorization
+	http.Redirect(w, r, strings.TrimRight(ah.live.boot.Url, "/")+"/#/oidc/authorize?"+r.URL.RawQuery, http.StatusFound)
+	return nil
+}
+
+type oidcAuthorizeRequest struct {
+	ClientId            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	ResponseType        string `json:"response_type"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+type oidcAuthorizeResponse struct {
+	//Redirect is where the web app has to send the browser, with either the code or the error for the client
+	Redirect string `json:"redirect"`
+}
+
+// POST /oidc/authorize
+func (ah apiHandler) oidcAuthorize(w http.ResponseWriter, r *http.Request) error {
+	oar := &oidcAuthorizeRequest{}
+	if err := jsonDecode(w, r, 1024*10, oar); err != nil {
+		return err
+	}
+	oc, err := ah.oidcCheckRedirect(oar.ClientId, oar.RedirectURI)
+	if err != nil {
+		return err
+	}
+	redirect, err := url.Parse(oar.RedirectURI)
+	if err != nil {
+		return util.NewErrorFrom(err)
+	}
+	q := redirect.Query()
+	if len(oar.State) > 0 {
+		q.Set("state", oar.State)
+	}
+	scopes := []string{}
+	for _, scope := range strings.Fields(oar.Scope) {
+		for _, known := range oidcScopes {
+			if scope == known {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	switch {
+	case oar.ResponseType != "code":
+		q.Set("error", "unsupported_response_type")
+	case !oidcHasScope(strings.Join(scopes, " "), OIDC_SCOPE_OPENID):
+		q.Set("error", "invalid_scope")
+	case oar.CodeChallengeMethod != "S256" && (len(oar.CodeChallenge) > 0 || len(oar.CodeChallengeMethod) > 0):
+		q.Set("error", "invalid_request")
+		q.Set("error_description", "Only S256 code challenges are supported")
+	default:
+		ctx := r.Context()
+		code := &models.OIDCCode{
+			User:          ctxGetUser(ctx).Id,
+			Client:        oc.Id,
+			RedirectURI:   oar.RedirectURI,
+			Scope:         strings.Join(scopes, " "),
+			Nonce:         oar.Nonce,
+			CodeChallenge: oar.CodeChallenge,
+		}
+		if err := models.NewOIDCCode(ctx, code); err != nil {
+			return err
+		}
+		ah.auditLog(r, AUDIT_OIDC_AUTHORIZE, auditObject("oidc_client", oc.Id))
+		q.Set("code", code.Code)
+	}
+	redirect.RawQuery = q.Encode()
+	return jsonResponse(w, oidcAuthorizeResponse{redirect.String()})
+}
+
+func oidcHasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+type oidcUserClaims struct {
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+func newOIDCUserClaims(u *models.User, scope string) oidcUserClaims {
+	uc := oidcUserClaims{}
+	if oidcHasScope(scope, OIDC_SCOPE_EMAIL) {
+		verified := u.ConfirmedAt.Valid
+		uc.Email, uc.EmailVerified = u.Email, &verified
+	}
+	if oidcHasScope(scope, OIDC_SCOPE_PROFILE) {
+		uc.Name, uc.PreferredUsername = u.FullName, u.Id
+	}
+	return uc
+}
+
+type oidcClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	Nonce     string `json:"nonce,omitempty"`
+	//ClientId and Scope are only in access tokens so id tokens can't be used as them
+	ClientId string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	oidcUserClaims
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IdToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+func oidcCheckPKCE(challenge, verifier string) bool {
+	h := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(h[:])), []byte(challenge)) == 1
+}
+
+// POST /oidc/token
+func (ah apiHandler) oidcToken(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return oidcError(w, http.StatusBadRequest, "invalid_request", err.Error())
+	}
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	oc, found := ah.oidcClient(id)
+	if !found || subtle.ConstantTimeCompare([]byte(oc.Secret), []byte(secret)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="keycat"`)
+		return oidcError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		return oidcError(w, http.StatusBadRequest, "unsupported_grant_type", "Only authorization_code is supported")
+	}
+	ctx := r.Context()
+	code, err := models.RedeemOIDCCode(ctx, r.PostForm.Get("code"))
+	if util.CheckErr(err, models.ErrDoesntExist) {
+		return oidcError(w, http.StatusBadRequest, "invalid_grant", "Invalid or expired code")
+	} else if util.CheckErr(err, models.ErrAlreadyUsed) {
+		return oidcError(w, http.StatusBadRequest, "invalid_grant", "The code has already been used")
+	} else if err != nil {
+		return err
+	}
+	if code.Client != oc.Id || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		return oidcError(w, http.StatusBadRequest, "invalid_grant", "The code was issued for another client or redirect_uri")
+	}
+	if len(code.CodeChallenge) > 0 && !oidcCheckPKCE(code.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		return oidcError(w, http.StatusBadRequest, "invalid_grant", "Invalid code_verifier")
+	}
+	u, err := models.FindUser(ctx, code.User)
+	if util.CheckErr(err, models.ErrDoesntExist) || (err == nil && (u.IsDisabled() || u.MustResetPassword)) {
+		return oidcError(w, http.StatusBadRequest, "invalid_grant", "The user can't log in")
+	} else if err != nil {
+		return err
+	}
+	now := time.Now()
+	base := oidcClaims{
+		Issuer:    ah.oidcIssuer(),
+		Subject:   u.Id,
+		Audience:  oc.Id,
+		ExpiresAt: now.Add(oidcTokenTTL).Unix(),
+		IssuedAt:  now.Unix(),
+	}
+	idClaims := base
+	idClaims.Nonce, idClaims.oidcUserClaims = code.Nonce, newOIDCUserClaims(u, code.Scope)
+	idToken, err := ah.oidc.sign(idClaims)
+	if err != nil {
+		return internalErr(err)
+	}
+	accessClaims := base
+	accessClaims.ClientId, accessClaims.Scope = oc.Id, code.Scope
+	accessToken, err := ah.oidc.sign(accessClaims)
+	if err != nil {
+		return internalErr(err)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	return jsonResponse(w, oidcTokenResponse{accessToken, "Bearer", int(oidcTokenTTL.Seconds()), idToken, code.Scope})
+}
+
+type oidcUserinfoResponse struct {
+	Subject string `json:"sub"`
+	oidcUserClaims
+}
+
+func (ah apiHandler) oidcCheckAccessToken(r *http.Request) (*oidcClaims, error) {
+	claims := &oidcClaims{}
+	if err := ah.oidc.verify(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), claims); err != nil {
+		return nil, err
+	}
+	if claims.Issuer != ah.oidcIssuer() || claims.ExpiresAt < time.Now().Unix() || len(claims.ClientId) == 0 {
+		return nil, util.NewErrorf("Invalid or expired access token")
+	}
+	if _, found := ah.oidcClient(claims.ClientId); !found {
+		return nil, util.NewErrorf("The client of the access token has been removed")
+	}
+	return claims, nil
+}
+
+// GET /oidc/userinfo
+func (ah apiHandler) oidcUserinfo(w http.ResponseWriter, r *http.Request) error {
+	claims, err := ah.oidcCheckAccessToken(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description=`+strconv.Quote(err.Error()))
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	u, err := models.FindUser(r.Context(), claims.Subject)
+	if util.CheckErr(err, models.ErrDoesntExist) || (err == nil && (u.IsDisabled() || u.MustResetPassword)) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	} else if err != nil {
+		return err
+	}
+	return jsonResponse(w, oidcUserinfoResponse{u.Id, newOIDCUserClaims(u, claims.Scope)})
+}
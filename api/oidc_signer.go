@@ -0,0 +1,172 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// oidcSigner signs the tokens of the OIDC provider with RS256. Without a key file a key is generated the first time
+// it's needed, so tokens stop verifying after a restart
+type oidcSigner struct {
+	once *sync.Once
+	key  *rsa.PrivateKey
+	kid  string
+	err  error
+}
+
+func newOIDCSigner(keyFile string) (*oidcSigner, error) {
+	s := &oidcSigner{once: &sync.Once{}}
+	if len(keyFile) == 0 {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, util.NewErrorf("Could not read oidc.key_file: %s", err)
+	}
+	key, err := parseRSAKey(data)
+	if err != nil {
+		return nil, util.NewErrorf("Invalid oidc.key_file: %s", err)
+	}
+	s.once.Do(func() { s.setKey(key) })
+	return s, nil
+}
+
+func parseRSAKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, util.NewErrorf("No PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, util.NewErrorf("Only RSA keys are supported")
+	}
+	return key, nil
+}
+
+func (s *oidcSigner) setKey(key *rsa.PrivateKey) {
+	h := sha256.Sum256(key.N.Bytes())
+	s.key, s.kid = key, base64.RawURLEncoding.EncodeToString(h[:12])
+}
+
+func (s *oidcSigner) load() error {
+	s.once.Do(func() {
+		log.Printf("[ERROR] No oidc.key_file configured. Signing the OIDC tokens with a temporary key")
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			s.err = util.NewErrorFrom(err)
+			return
+		}
+		s.setKey(key)
+	})
+	return s.err
+}
+
+func (s *oidcSigner) jwks() (jwkSet, error) {
+	if err := s.load(); err != nil {
+		return jwkSet{}, err
+	}
+	pub := s.key.PublicKey
+	return jwkSet{[]jwk{{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: s.kid,
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}}, nil
+}
+
+func jwtEncode(obj interface{}) (string, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return "", util.NewErrorFrom(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func (s *oidcSigner) sign(claims interface{}) (string, error) {
+	if err := s.load(); err != nil {
+		return "", err
+	}
+	header, err := jwtEncode(jwtHeader{"RS256", "JWT", s.kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := jwtEncode(claims)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(header + "." + payload))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", util.NewErrorFrom(err)
+	}
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// verify checks the signature of a token signed by this key and decodes its claims. The caller checks the claims
+func (s *oidcSigner) verify(token string, claims interface{}) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return util.NewErrorf("Invalid token")
+	}
+	hdr := jwtHeader{}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(data, &hdr) != nil || hdr.Alg != "RS256" || hdr.Kid != s.kid {
+		return util.NewErrorf("Invalid token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return util.NewErrorf("Invalid token signature")
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&s.key.PublicKey, crypto.SHA256, h[:], sig); err != nil {
+		return util.NewErrorf("Invalid token signature")
+	}
+	if data, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return util.NewErrorf("Invalid token payload")
+	}
+	return util.NewErrorFrom(json.Unmarshal(data, claims))
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func oidcTokenRequest(form url.Values, secret string) (*http.Response, error) {
+	req, err := http.NewRequest("POST", srv.URL+"/oidc/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("grafana", secret)
+	return http.DefaultClient.Do(req)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	u := loginDummyUser()
+	r, err := GetRequest("/oidc/.well-known/openid-configuration")
+	CheckErrorAndResponse(t, r, err, 200)
+	disc := &oidcDiscoveryResponse{}
+	if err := json.NewDecoder(r.Body).Decode(disc); err != nil {
+		t.Fatal(err)
+	}
+	if disc.Issuer != apiH.oidcIssuer() || disc.TokenEndpoint != disc.Issuer+"/token" {
+		t.Fatalf("Unexpected discovery %#v", disc)
+	}
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	r, err = noRedirect.Get(srv.URL + "/oidc/authorize?client_id=grafana&redirect_uri=https://evil.test/login")
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = noRedirect.Get(srv.URL + "/oidc/authorize?client_id=grafana&redirect_uri=https://grafana.test/login&state=s")
+	CheckErrorAndResponse(t, r, err, 302)
+	if loc := r.Header.Get("Location"); !strings.Contains(loc, "/#/oidc/authorize?client_id=grafana") {
+		t.Fatalf("Unexpected redirect to %s", loc)
+	}
+	verifier := "the verifier of the client that is long enough"
+	h := sha256.Sum256([]byte(verifier))
+	oar := oidcAuthorizeRequest{
+		ClientId:            "grafana",
+		RedirectURI:         "https://grafana.test/login",
+		ResponseType:        "code",
+		Scope:               "openid email profile groups",
+		State:               "s",
+		Nonce:               "n",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(h[:]),
+		CodeChallengeMethod: "S256",
+	}
+	r, err = PostRequest("/oidc/authorize", oar)
+	CheckErrorAndResponse(t, r, err, 200)
+	ar := &oidcAuthorizeResponse{}
+	if err := json.NewDecoder(r.Body).Decode(ar); err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := url.Parse(ar.Redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := redirect.Query().Get("code")
+	if redirect.Host != "grafana.test" || redirect.Query().Get("state") != "s" || len(code) == 0 {
+		t.Fatalf("Unexpected redirect %s", ar.Redirect)
+	}
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {oar.RedirectURI}, "code_verifier": {verifier}}
+	r, err = oidcTokenRequest(form, "wrong")
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = oidcTokenRequest(form, "grafana-secret")
+	CheckErrorAndResponse(t, r, err, 200)
+	tr := &oidcTokenResponse{}
+	if err := json.NewDecoder(r.Body).Decode(tr); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Scope != "openid email profile" {
+		t.Errorf("Unexpected scope %s", tr.Scope)
+	}
+	idc := &oidcClaims{}
+	if err := apiH.oidc.verify(tr.IdToken, idc); err != nil {
+		t.Fatal(err)
+	}
+	if idc.Subject != u.Id || idc.Audience != "grafana" || idc.Nonce != "n" || idc.Email != u.Email || len(idc.ClientId) > 0 {
+		t.Fatalf("Unexpected id token claims %#v", idc)
+	}
+	r, err = oidcTokenRequest(form, "grafana-secret")
+	CheckErrorAndResponse(t, r, err, 400)
+	for _, token := range []string{tr.IdToken, tr.AccessToken} {
+		req, err := http.NewRequest("GET", srv.URL+"/oidc/userinfo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		r, err = http.DefaultClient.Do(req)
+		if token == tr.IdToken {
+			CheckErrorAndResponse(t, r, err, 401)
+			continue
+		}
+		CheckErrorAndResponse(t, r, err, 200)
+		ui := &oidcUserinfoResponse{}
+		if err := json.NewDecoder(r.Body).Decode(ui); err != nil {
+			t.Fatal(err)
+		}
+		if ui.Subject != u.Id || ui.PreferredUsername != u.Id || ui.EmailVerified == nil || !*ui.EmailVerified {
+			t.Fatalf("Unexpected userinfo %#v", ui)
+		}
+	}
+}
+
+func TestOIDCAuthorizeErrors(t *testing.T) {
+	loginDummyUser()
+	oar := oidcAuthorizeRequest{ClientId: "grafana", RedirectURI: "https://grafana.test/login", ResponseType: "token", Scope: "openid"}
+	for _, expected := range []string{"unsupported_response_type", "invalid_scope"} {
+		r, err := PostRequest("/oidc/authorize", oar)
+		CheckErrorAndResponse(t, r, err, 200)
+		ar := &oidcAuthorizeResponse{}
+		if err := json.NewDecoder(r.Body).Decode(ar); err != nil {
+			t.Fatal(err)
+		}
+		redirect, err := url.Parse(ar.Redirect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if redirect.Query().Get("error") != expected || len(redirect.Query().Get("code")) > 0 {
+			t.Fatalf("Expected %s and got %s", expected, ar.Redirect)
+		}
+		oar.ResponseType, oar.Scope = "code", "email"
+	}
+	oar.RedirectURI = "https://evil.test/login"
+	r, err := PostRequest("/oidc/authorize", oar)
+	CheckErrorAndResponse(t, r, err, 400)
+}
@@ -0,0 +1,447 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// openapiRoute describes one of the routes of the api. Routing is done by hand in each handler so every new route
+// has to be added here as well. The request and response are values of the types that are decoded and encoded
+type openapiRoute struct {
+	id      string
+	method  string
+	path    string
+	summary string
+	public  bool
+	//machine routes take a machine token instead of a session
+	machine bool
+	query   []string
+	//list is set for the listings that follow the conventions of listQuery
+	list     *listSpec
+	request  interface{}
+	response interface{}
+	//produces overrides the content type of the response. Defaults to json
+	produces string
+}
+
+// apiErrorResponse is the body of the error answers. It is only used for the document
+type apiErrorResponse struct {
+	Error       string            `json:"error"`
+	ErrorFields map[string]string `json:"error_fields,omitempty"`
+	RequestId   string            `json:"request_id,omitempty"`
+}
+
+var openapiRoutes = []openapiRoute{
+	{id: "authRegister", method: "POST", path: "/auth/register", summary: "Register a new user. A confirmation mail is sent to the email. Returns the invites it accepted with the message, role and vaults the inviters proposed", public: true, request: authRegisterRequest{}, response: authRegisterResponse{}},
+	{id: "authAvailability", method: "GET", path: "/auth/availability", summary: "Check if a username and an email are valid and not registered yet. Limited to 30 requests a minute per address", public: true, response: authAvailabilityResponse{}},
+	{id: "authResetPassword", method: "POST", path: "/auth/reset_password", summary: "Change the password and the wrapped keys of a user an admin has forced to reset them", public: true, request: authResetPasswordRequest{}},
+	{id: "authConfirmEmail", method: "GET", path: "/auth/confirm_email/:token", summary: "Confirm the email of a user", public: true, response: models.User{}},
+	{id: "authRequestConfirmationToken", method: "POST", path: "/auth/request_confirmation_token", summary: "Send the confirmation mail again", public: true, request: authRequest{}},
+	{id: "authLogin", method: "POST", path: "/auth/login", summary: "Log in and create a new session", public: true, request: authRequest{}, response: authLoginResponse{}},
+	{id: "authGetSession", method: "GET", path: "/auth/session/:token", summary: "Get the session of the authorization header and a fresh csrf token", public: true, response: authGetSessionResponse{}},
+	{id: "authPairingClaim", method: "POST", path: "/auth/pairing", summary: "Claim a pairing code from a browser extension", public: true, request: authPairingClaimRequest{}, response: authPairingClaimResponse{}},
+	{id: "authPairingFinish", method: "POST", path: "/auth/pairing/:code", summary: "Get the session of an approved pairing. Answers 202 until it's approved", public: true, request: authPairingFinishRequest{}, response: authPairingFinishResponse{}},
+	{id: "versionSendFull", method: "GET", path: "/version", summary: "Get the versions of the server and the web", public: true, response: versionSendFullResponse{}},
+	{id: "openapiSend", method: "GET", path: "/openapi.json", summary: "Get this document", public: true},
+	{id: "idpOktaVerify", method: "GET", path: "/idp/okta", summary: "Answer the verification challenge of an Okta event hook. Needs the idp hooks token", public: true, response: idpOktaVerifyResponse{}},
+	{id: "idpOktaEvents", method: "POST", path: "/idp/okta", summary: "Disable the users deactivated in Okta. Needs the idp hooks token", public: true, request: idpOktaRequest{}, response: idpHookResponse{}},
+	{id: "idpEvents", method: "POST", path: "/idp/events", summary: "Disable the users deactivated in an identity provider. Needs the idp hooks token", public: true, request: idpEventsRequest{}, response: idpHookResponse{}},
+	{id: "oidcDiscovery", method: "GET", path: "/oidc/.well-known/openid-configuration", summary: "Get the OpenID configuration of the OIDC provider", public: true, response: oidcDiscoveryResponse{}},
+	{id: "oidcJwks", method: "GET", path: "/oidc/jwks", summary: "Get the keys that sign the OIDC tokens", public: true, response: jwkSet{}},
+	{id: "oidcAuthorizeRedirect", method: "GET", path: "/oidc/authorize", summary: "Start an OIDC login. Redirects to the web app to authorize it", public: true},
+	{id: "oidcAuthorize", method: "POST", path: "/oidc/authorize", summary: "Authorize an OIDC client for the current user. Returns where the browser has to be sent", request: oidcAuthorizeRequest{}, response: oidcAuthorizeResponse{}},
+	{id: "oidcToken", method: "POST", path: "/oidc/token", summary: "Exchange an OIDC code for the tokens. Takes a form with the client credentials", public: true, response: oidcTokenResponse{}},
+	{id: "oidcUserinfo", method: "GET", path: "/oidc/userinfo", summary: "Get the claims of the user of an OIDC access token", public: true, response: oidcUserinfoResponse{}},
+	{id: "sessionList", method: "GET", path: "/session", summary: "List the sessions of the current user", list: &sessionListSpec, response: sessionListResponse{}},
+	{id: "sessionGetToken", method: "GET", path: "/session/:token", summary: "Get a session of the current user", response: sessionGetTokenResponse{}},
+	{id: "sessionDeleteToken", method: "DELETE", path: "/session/:token", summary: "Log out a session of the current user"},
+	{id: "pairingCreate", method: "POST", path: "/session/pairing", summary: "Start pairing a browser extension", response: pairingResponse{}},
+	{id: "pairingGet", method: "GET", path: "/session/pairing/:code", summary: "Get a pairing and the extension that claimed it", response: pairingResponse{}},
+	{id: "pairingApprove", method: "PUT", path: "/session/pairing/:code", summary: "Approve a pairing with the keys of the user sealed for the extension", request: pairingApproveRequest{}, response: pairingResponse{}},
+	{id: "pairingDelete", method: "DELETE", path: "/session/pairing/:code", summary: "Cancel a pairing"},
+	{id: "userGetInfo", method: "GET", path: "/user", summary: "Get the current user", response: models.UserFull{}},
+	{id: "userUpdate", method: "PUT", path: "/user", summary: "Change the email or the password of the current user. PATCH is accepted too", request: userUpdateRequest{}},
+	{id: "userUpdateProfile", method: "PUT", path: "/user/profile", summary: "Change the name, pronouns or title shown to the other members of the teams and the locale and timezone of the mails", request: userProfileRequest{}, response: models.User{}},
+	{id: "userGetPreferences", method: "GET", path: "/user/preferences", summary: "Get the preferences the clients of the user store", response: userPreferencesResponse{}},
+	{id: "userSetPreferences", method: "PUT", path: "/user/preferences", summary: "Replace the preferences of the user. Send the ETag in If-Match to avoid overwriting other changes", request: userPreferencesRequest{}, response: userPreferencesResponse{}},
+	{id: "userAvatarUpload", method: "PUT", path: "/user/avatar", summary: "Upload a png, jpeg, gif or webp image as the body to use it as avatar", response: models.User{}},
+	{id: "userAvatarDelete", method: "DELETE", path: "/user/avatar", summary: "Remove the avatar of the current user", response: models.User{}},
+	{id: "userAvatarGet", method: "GET", path: "/user/avatar/:uid", summary: "Get the avatar of the user or of a member of its teams", produces: "image/*"},
+	{id: "teamGetAll", method: "GET", path: "/team", summary: "List the teams of the current user", list: &teamListSpec, response: teamGetAllResponse{}},
+	{id: "teamCreate", method: "POST", path: "/team", summary: "Create a team", request: teamCreateRequest{}, response: models.TeamFull{}},
+	{id: "teamGetInfo", method: "GET", path: "/team/:tid", summary: "Get a team with its ETag", response: models.TeamFull{}},
+	{id: "teamInviteUser", method: "POST", path: "/team/:tid/user", summary: "Add a user to the team or invite the email. The message, role and vaults are applied when the user joins", request: teamInviteUserRequest{}, response: models.TeamFull{}},
+	{id: "teamModifyUser", method: "PATCH", path: "/team/:tid/user/:uid", summary: "Promote or demote a user of the team", request: teamModifyUserRequest{}, response: teamModifyUserResponse{}},
+	{id: "teamPendingAccessList", method: "GET", path: "/team/:tid/pending", summary: "List the roles and vaults proposed in accepted invites that wait for the vault keys. Only for admins", response: teamPendingAccessListResponse{}},
+	{id: "teamPendingAccessComplete", method: "POST", path: "/team/:tid/pending/:uid", summary: "Apply the proposed role and vaults of a member with the vault keys wrapped for it. Only for admins", request: teamPendingAccessRequest{}, response: teamModifyUserResponse{}},
+	{id: "teamPendingAccessDelete", method: "DELETE", path: "/team/:tid/pending/:uid", summary: "Drop the proposed role and vaults of a member. Only for admins", response: teamPendingAccessListResponse{}},
+	{id: "teamSetAuditor", method: "PUT", path: "/team/:tid/user/:uid/auditor", summary: "Make a member of the team an auditor. Only for admins and never for admins or vault members", response: teamModifyUserResponse{}},
+	{id: "teamUnsetAuditor", method: "DELETE", path: "/team/:tid/user/:uid/auditor", summary: "Make an auditor of the team a regular member. Only for admins", response: teamModifyUserResponse{}},
+	{id: "teamAuditList", method: "GET", path: "/team/:tid/audit", summary: "List the audit entries about the team between from and to, the last 24 hours by default. Only for admins and auditors", query: []string{"from", "to"}, list: &auditListSpec, response: teamAuditListResponse{}},
+	{id: "teamAuditVaults", method: "GET", path: "/team/:tid/audit/vault", summary: "List the vaults of the team with their members and how many secrets they have. Only for admins and auditors", response: teamAuditVaultListResponse{}},
+	{id: "teamFeatures", method: "GET", path: "/team/:tid/features", summary: "Get which features are enabled for the team", response: map[string]bool{}},
+	{id: "teamSecretGetAll", method: "GET", path: "/team/:tid/secret", summary: "List the secrets of all the vaults of the team the user has access to. label only lists the secrets with that label. Archived secrets are only listed with archived=include or archived=only", list: &secretListSpec, query: []string{"label", "archived"}, response: teamSecretListWrap{}},
+	{id: "labelList", method: "GET", path: "/team/:tid/label", summary: "List the labels of the team and the labels of the secrets the user has access to", response: labelListResponse{}},
+	{id: "labelCreate", method: "POST", path: "/team/:tid/label", summary: "Create a label. Only team admins can", request: labelRequest{}, response: models.Label{}},
+	{id: "labelUpdate", method: "PUT", path: "/team/:tid/label/:lid", summary: "Rename or recolor a label. Only team admins can", request: labelRequest{}, response: models.Label{}},
+	{id: "labelDelete", method: "DELETE", path: "/team/:tid/label/:lid", summary: "Delete a label and remove it from all the secrets. Only team admins can"},
+	{id: "vaultList", method: "GET", path: "/team/:tid/vault", summary: "List the vaults of the team the user has access to", list: &vaultListSpec, response: vaultListResponse{}},
+	{id: "vaultCreate", method: "POST", path: "/team/:tid/vault", summary: "Create a vault", request: vaultCreateRequest{}, response: models.VaultFull{}},
+	{id: "vaultGet", method: "GET", path: "/team/:tid/vault/:vid", summary: "Get a vault with its ETag", response: models.VaultFull{}},
+	{id: "vaultAddUser", method: "POST", path: "/team/:tid/vault/:vid/user", summary: "Share the vault with users. The keys map each user to its vault key. Vaults that require approval answer 202 with the pending grants. The access lasts until expires_at if set", query: []string{"expires_at"}, request: map[string][]byte{}, response: models.VaultFull{}},
+	{id: "vaultSetApproval", method: "PUT", path: "/team/:tid/vault/:vid/approval", summary: "Require the approval of a second admin for the new members of the vault", request: vaultApprovalRequest{}, response: models.VaultFull{}},
+	{id: "vaultGrantList", method: "GET", path: "/team/:tid/vault/:vid/grant", summary: "List the members waiting for approval", response: vaultGrantListResponse{}},
+	{id: "vaultGrantApprove", method: "PUT", path: "/team/:tid/vault/:vid/grant/:uid", summary: "Approve a pending member. The admin that added it can't", response: models.VaultFull{}},
+	{id: "vaultGrantReject", method: "DELETE", path: "/team/:tid/vault/:vid/grant/:uid", summary: "Reject a pending member"},
+	{id: "vaultTemplateList", method: "GET", path: "/team/:tid/vault/:vid/template", summary: "List the templates of the new secrets of the vault", response: vaultTemplateListResponse{}},
+	{id: "vaultTemplateCreate", method: "POST", path: "/team/:tid/vault/:vid/template", summary: "Add a template to the vault. The data is opaque to the server. Only team admins can", request: vaultTemplateRequest{}, response: models.VaultTemplate{}},
+	{id: "vaultTemplateUpdate", method: "PUT", path: "/team/:tid/vault/:vid/template/:tmid", summary: "Replace the name and the data of a template. Only team admins can", request: vaultTemplateRequest{}, response: models.VaultTemplate{}},
+	{id: "vaultTemplateDelete", method: "DELETE", path: "/team/:tid/vault/:vid/template/:tmid", summary: "Delete a template. Only team admins can"},
+	{id: "vaultPinList", method: "GET", path: "/team/:tid/vault/:vid/pin", summary: "List the pinned secrets of the vault in the order clients list them first", response: vaultPinListResponse{}},
+	{id: "vaultPinSet", method: "PUT", path: "/team/:tid/vault/:vid/pin", summary: "Replace the pinned secrets of the vault with the ordered list of secret ids. Only team admins can", request: vaultPinRequest{}, response: vaultPinListResponse{}},
+	{id: "vaultMachineTokenList", method: "GET", path: "/team/:tid/vault/:vid/machine_token", summary: "List the machine tokens of the vault", response: machineTokenListResponse{}},
+	{id: "vaultMachineTokenCreate", method: "POST", path: "/team/:tid/vault/:vid/machine_token", summary: "Issue a read only token for the vault that reads with the vault key of the current user. With labels it only reads the secrets with any of them. The token is only sent in this answer", request: machineTokenCreateRequest{}, response: machineTokenCreateResponse{}},
+	{id: "vaultMachineTokenDelete", method: "DELETE", path: "/team/:tid/vault/:vid/machine_token/:mid", summary: "Revoke a machine token. Only the user that issued it and the team admins can"},
+	{id: "vaultAccessList", method: "GET", path: "/team/:tid/vault/:vid/access", summary: "List the members of the vault whose access expires", response: vaultAccessListResponse{}},
+	{id: "vaultMarkRotated", method: "DELETE", path: "/team/:tid/vault/:vid/rotation", summary: "Clear the rotation flag once the secrets of the vault have been moved to a new one", response: models.VaultFull{}},
+	{id: "vaultMembershipDiff", method: "GET", path: "/team/:tid/vault/:vid/membership", summary: "List who gained or lost the access to the vault between from and to, the last 30 days by default, and if the vault has been rotated since. Only for admins", query: []string{"from", "to"}, response: vaultMembershipDiffResponse{}},
+	{id: "vaultRemoveUser", method: "DELETE", path: "/team/:tid/vault/:vid/user/:uid", summary: "Remove a user from the vault. With dry_run=true it returns what would be removed without removing it", query: []string{"dry_run"}, response: models.VaultFull{}},
+	{id: "vaultGetSecrets", method: "GET", path: "/team/:tid/vault/:vid/secret", summary: "List the secrets of the vault. label only lists the secrets with that label. Archived secrets are only listed with archived=include or archived=only", list: &secretListSpec, query: []string{"label", "archived"}, response: teamSecretListWrap{}},
+	{id: "vaultCreateSecret", method: "POST", path: "/team/:tid/vault/:vid/secret", summary: "Create a secret", request: vaultCreateSecretRequest{}, response: models.Secret{}},
+	{id: "vaultUpdateSecret", method: "PUT", path: "/team/:tid/vault/:vid/secret/:sid", summary: "Update a secret or move it to another vault. PATCH is accepted too", request: vaultCreateSecretRequest{}, response: models.Secret{}},
+	{id: "vaultDeleteSecret", method: "DELETE", path: "/team/:tid/vault/:vid/secret/:sid", summary: "Delete a secret", response: models.Vault{}},
+	{id: "vaultArchiveSecret", method: "PUT", path: "/team/:tid/vault/:vid/secret/:sid/archive", summary: "Archive a secret. It's kept but left out of the default listings", response: models.Secret{}},
+	{id: "vaultUnarchiveSecret", method: "DELETE", path: "/team/:tid/vault/:vid/secret/:sid/archive", summary: "Restore an archived secret", response: models.Secret{}},
+	{id: "vaultSetSecretLabels", method: "PUT", path: "/team/:tid/vault/:vid/secret/:sid/labels", summary: "Replace the labels of a secret", request: secretLabelsRequest{}, response: secretLabelsRequest{}},
+	{id: "vaultCreateSecretList", method: "POST", path: "/team/:tid/vault/:vid/secrets", summary: "Create many secrets at once", request: teamSecretListWrap{}, response: teamSecretListWrap{}},
+	{id: "webhookList", method: "GET", path: "/team/:tid/webhook", summary: "List the webhooks of the team", response: webhookListResponse{}},
+	{id: "webhookCreate", method: "POST", path: "/team/:tid/webhook", summary: "Create a webhook. Its signing secret is only returned here", request: webhookCreateRequest{}, response: models.Webhook{}},
+	{id: "webhookGet", method: "GET", path: "/team/:tid/webhook/:wid", summary: "Get a webhook with its ETag", response: models.Webhook{}},
+	{id: "webhookDelete", method: "DELETE", path: "/team/:tid/webhook/:wid", summary: "Delete a webhook"},
+	{id: "webhookDeliveryList", method: "GET", path: "/team/:tid/webhook/:wid/delivery", summary: "List the deliveries of a webhook. Defaults to the dead ones", query: []string{"status"}, response: webhookDeliveryListResponse{}},
+	{id: "webhookRedeliver", method: "POST", path: "/team/:tid/webhook/:wid/delivery/:did/redeliver", summary: "Send a delivery again", response: models.WebhookDelivery{}},
+	{id: "teamHealthGet", method: "GET", path: "/team/:tid/health", summary: "Add up the password health reports of the members of the team. Only for admins", response: models.TeamHealth{}},
+	{id: "teamHealthReport", method: "PUT", path: "/team/:tid/health", summary: "Report the counts of weak, reused and old passwords the client found in the team", request: healthReportRequest{}, response: models.HealthReport{}},
+	{id: "teamSessionPolicyGet", method: "GET", path: "/team/:tid/session_policy", summary: "Get what the team requires of the sessions of its members", response: models.TeamSessionPolicy{}},
+	{id: "teamSessionPolicySet", method: "PUT", path: "/team/:tid/session_policy", summary: "Require csrf protected sessions or cap their lifetime for the members of the team. Only for admins", request: teamSessionPolicyRequest{}, response: models.TeamSessionPolicy{}},
+	{id: "matrixGet", method: "GET", path: "/team/:tid/matrix", summary: "Get the matrix room of the team", response: models.TeamMatrix{}},
+	{id: "matrixSet", method: "PUT", path: "/team/:tid/matrix", summary: "Set the matrix room of the team", request: matrixSetRequest{}, response: models.TeamMatrix{}},
+	{id: "matrixDelete", method: "DELETE", path: "/team/:tid/matrix", summary: "Stop sending events to the matrix room of the team"},
+	{id: "wsSubscribe", method: "GET", path: "/ws", summary: "Upgrade to a websocket that receives the changes of the vaults of the user"},
+	{id: "eventSourceSubscribe", method: "GET", path: "/eventsource", summary: "Receive the changes of the vaults of the user as server sent events", produces: "text/event-stream"},
+	{id: "adminUserList", method: "GET", path: "/admin/user", summary: "Search the users", query: []string{"q", "confirmed", "disabled", "admin", "limit", "offset"}, response: adminUserListResponse{}},
+	{id: "adminUserGet", method: "GET", path: "/admin/user/:uid", summary: "Get a user", response: models.User{}},
+	{id: "adminUserDelete", method: "DELETE", path: "/admin/user/:uid", summary: "Delete a user and its sessions. With dry_run=true it returns what would be removed without removing it", query: []string{"dry_run"}, response: models.ChangeReport{}},
+	{id: "adminUserSessions", method: "GET", path: "/admin/user/:uid/session", summary: "List the sessions of a user", list: &sessionListSpec, response: sessionListResponse{}},
+	{id: "adminUserDisable", method: "POST", path: "/admin/user/:uid/disable", summary: "Disable a user and log out its sessions", response: models.User{}},
+	{id: "adminUserEnable", method: "POST", path: "/admin/user/:uid/enable", summary: "Enable a user", response: models.User{}},
+	{id: "adminUserForcePasswordReset", method: "POST", path: "/admin/user/:uid/force_password_reset", summary: "Close every session of a user and keep it from logging in until it resets its password", response: models.User{}},
+	{id: "adminUserLoginState", method: "GET", path: "/admin/user/:uid/login_state", summary: "Show the failed logins of a user and what its next login faces: free, delay, captcha or lockout", response: loginState{}},
+	{id: "adminUserClearLoginState", method: "DELETE", path: "/admin/user/:uid/login_state", summary: "Forget the failed logins of a user", response: loginState{}},
+	{id: "adminUserReverify", method: "POST", path: "/admin/user/:uid/reverify", summary: "Force a user to confirm its email again", response: models.User{}},
+	{id: "adminMaintenanceGet", method: "GET", path: "/admin/maintenance", summary: "Get the maintenance mode", response: maintenanceStatus{}},
+	{id: "adminMaintenanceEnable", method: "PUT", path: "/admin/maintenance", summary: "Enable the maintenance mode", request: adminMaintenanceRequest{}, response: maintenanceStatus{}},
+	{id: "adminMaintenanceDisable", method: "DELETE", path: "/admin/maintenance", summary: "Disable the maintenance mode", response: maintenanceStatus{}},
+	{id: "adminAuditExport", method: "GET", path: "/admin/audit/export", summary: "Export the audit entries as ndjson or csv", query: []string{"from", "to", "format"}, list: &auditListSpec, response: models.AuditEntry{}, produces: "application/x-ndjson"},
+	{id: "adminBlocklistList", method: "GET", path: "/admin/blocklist", summary: "List the active ip blocks", response: []*models.IpBlock{}},
+	{id: "adminBlocklistAdd", method: "POST", path: "/admin/blocklist", summary: "Block a network", request: adminBlocklistAddRequest{}, response: models.IpBlock{}},
+	{id: "adminBlocklistDelete", method: "DELETE", path: "/admin/blocklist/:id", summary: "Remove an ip block", response: models.IpBlock{}},
+	{id: "adminJobList", method: "GET", path: "/admin/jobs", summary: "List the periodic jobs", response: []*models.Job{}},
+	{id: "adminJobGet", method: "GET", path: "/admin/jobs/:name", summary: "Get a periodic job", response: models.Job{}},
+	{id: "adminJobRun", method: "POST", path: "/admin/jobs/:name/run", summary: "Run a periodic job now. With dry_run=true the cleanup, blocklist_purge and orphan_gc jobs return what they would remove without running", query: []string{"dry_run"}, response: models.Job{}},
+	{id: "adminFeaturesList", method: "GET", path: "/admin/features", summary: "List the features and their overrides", response: []*adminFeature{}},
+	{id: "adminFeatureSet", method: "PUT", path: "/admin/features/:name", summary: "Enable or disable a feature globally or for a team", request: adminFeatureSetRequest{}, response: models.FeatureFlag{}},
+	{id: "adminFeatureDelete", method: "DELETE", path: "/admin/features/:name", summary: "Remove the override of a feature", query: []string{"team"}, response: models.FeatureFlag{}},
+	{id: "adminStatus", method: "GET", path: "/admin/status", summary: "Get the status of the instance", response: adminStatusResponse{}},
+	{id: "adminStats", method: "GET", path: "/admin/stats", summary: "Get the usage stats of the instance", response: adminStatsResponse{}},
+	{id: "adminOrphans", method: "GET", path: "/admin/orphans", summary: "Report the orphaned rows without removing them", response: models.OrphanReport{}},
+	{id: "adminRetention", method: "GET", path: "/admin/retention", summary: "Get the retention of every class of data and what its last run purged", response: retentionResponse{}},
+	{id: "machineListSecrets", method: "GET", path: "/machine/:tid/:vid", machine: true, summary: "List the versions of the secrets of a vault. Archived secrets are only listed with archived=include or archived=only. Also served at /machine/v1/:tid/:vid", query: []string{"archived"}, response: machineSecretListResponse{}},
+	{id: "machineGetSecret", method: "GET", path: "/machine/:tid/:vid/:sid", machine: true, summary: "Get a secret with the keys to decrypt it. Also served at /machine/v1/:tid/:vid/:sid", query: []string{"version"}, response: machineSecretResponse{}},
+	{id: "keyLogHead", method: "GET", path: "/keylog/head", summary: "Get the signed head of the log of the public keys of the users", response: keyLogHead{}},
+	{id: "keyLogConsistency", method: "GET", path: "/keylog/consistency", summary: "Prove that the key log only grew since a previous head", query: []string{"from"}, response: keyLogConsistencyResponse{}},
+	{id: "deviceRevokedList", method: "GET", path: "/device/revoked", summary: "List the revoked device keys of the user and the members of its teams", response: deviceRevokedResponse{}},
+	{id: "accountChangePassword", method: "POST", path: "/account/change_password", summary: "Change the password and the wrapped keys of the user and close its other sessions", request: accountChangePasswordRequest{}},
+	{id: "accountExport", method: "GET", path: "/account/export", summary: "Export everything the server keeps about the user. The audit entries are streamed last", response: accountExportResponse{}},
+	{id: "accountLoginHistory", method: "GET", path: "/account/login_history", summary: "List the latest successful and failed logins of the user", response: loginHistoryResponse{}},
+	{id: "deviceRevoke", method: "POST", path: "/device/revoked", summary: "Report a device as compromised and revoke its public key", request: deviceRevokeRequest{}, response: models.DeviceRevocation{}},
+	{id: "keyLogUser", method: "GET", path: "/keylog/user/:uid", summary: "Get the public keys a user had with the proofs that they are in the key log", response: keyLogUserResponse{}},
+	{id: "batch", method: "POST", path: "/batch", summary: "Run several requests in one round trip", request: batchRequest{}, response: batchResponse{}},
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// openapiSchemas keeps the schemas of the named types so each one is only described once
+type openapiSchemas map[string]interface{}
+
+func openapiSchemaName(t reflect.Type) string {
+	if t.PkgPath() == reflect.TypeOf(apiHandler{}).PkgPath() {
+		return t.Name()
+	}
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
+
+// schema returns the schema of the json encoding of the type
+func (sc openapiSchemas) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType):
+		//Custom encodings can't be described by looking at the fields
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": sc.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": sc.schema(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return sc.object(t)
+		}
+		name := openapiSchemaName(t)
+		if _, ok := sc[name]; !ok {
+			//Set before describing the fields so types that reference themselves don't recurse forever
+			sc[name] = nil
+			sc[name] = sc.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+func (sc openapiSchemas) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+	sc.fields(t, props, &required)
+	obj := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
+
+// fields adds the fields of the struct like encoding/json does. Embedded structs without a name are flattened
+func (sc openapiSchemas) fields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && len(name) == 0 && ft.Kind() == reflect.Struct {
+			sc.fields(ft, props, required)
+			continue
+		}
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		omit := false
+		fs := sc.schema(f.Type)
+		for _, opt := range opts[1:] {
+			switch opt {
+			case "omitempty":
+				omit = true
+			case "string":
+				fs = map[string]interface{}{"type": "string"}
+			}
+		}
+		props[name] = fs
+		if !omit {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func openapiPath(p string) (string, []interface{}) {
+	params := []interface{}{}
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+			params = append(params, map[string]interface{}{
+				"name":     part[1:],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+func openapiContent(contentType string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{contentType: map[string]interface{}{"schema": schema}}
+}
+
+func openapiListParams(spec *listSpec) []interface{} {
+	sorts := []string{}
+	for name := range spec.sorts {
+		sorts = append(sorts, name, "-"+name)
+	}
+	sort.Strings(sorts)
+	params := []interface{}{
+		map[string]interface{}{"name": "limit", "in": "query", "schema": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": listMaxLimit}},
+		map[string]interface{}{"name": "cursor", "in": "query", "schema": map[string]interface{}{"type": "string"}},
+		map[string]interface{}{"name": "sort", "in": "query", "schema": map[string]interface{}{"type": "string", "enum": sorts, "default": spec.defaultSort}},
+	}
+	filters := []string{}
+	for name := range spec.filters {
+		filters = append(filters, name)
+	}
+	sort.Strings(filters)
+	for _, name := range filters {
+		params = append(params, map[string]interface{}{"name": name, "in": "query", "schema": map[string]interface{}{"type": "string"}})
+	}
+	if len(spec.fields) > 0 {
+		params = append(params, map[string]interface{}{
+			"name":    "fields",
+			"in":      "query",
+			"style":   "form",
+			"explode": false,
+			"schema":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "enum": spec.fields}},
+		})
+	}
+	return params
+}
+
+func (sc openapiSchemas) operation(or openapiRoute, params []interface{}) map[string]interface{} {
+	if or.list != nil {
+		params = append(params, openapiListParams(or.list)...)
+	}
+	for _, q := range or.query {
+		params = append(params, map[string]interface{}{
+			"name":   q,
+			"in":     "query",
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	ok := map[string]interface{}{"description": "OK"}
+	if or.response != nil || len(or.produces) > 0 {
+		contentType := or.produces
+		if len(contentType) == 0 {
+			contentType = "application/json"
+		}
+		schema := map[string]interface{}{}
+		if or.response != nil {
+			schema = sc.schema(reflect.TypeOf(or.response))
+		}
+		ok["content"] = openapiContent(contentType, schema)
+	}
+	op := map[string]interface{}{
+		"operationId": or.id,
+		"summary":     or.summary,
+		"tags":        []string{strings.Split(or.path, "/")[1]},
+		"responses": map[string]interface{}{
+			"200": ok,
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content":     openapiContent("application/json", sc.schema(reflect.TypeOf(apiErrorResponse{}))),
+			},
+		},
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if or.request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  openapiContent("application/json", sc.schema(reflect.TypeOf(or.request))),
+		}
+	}
+	if or.public {
+		op["security"] = []interface{}{}
+	} else if or.machine {
+		op["security"] = []interface{}{map[string]interface{}{"machine": []string{}}}
+	}
+	return op
+}
+
+// buildOpenapiDocument describes the routes as an OpenAPI 3 document
+func buildOpenapiDocument(routes []openapiRoute, base string) map[string]interface{} {
+	sc := openapiSchemas{}
+	paths := map[string]map[string]interface{}{}
+	for _, or := range routes {
+		p, params := openapiPath(or.path)
+		if _, ok := paths[p]; !ok {
+			paths[p] = map[string]interface{}{}
+		}
+		paths[p][strings.ToLower(or.method)] = sc.operation(or, params)
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "KeyCat",
+			"version": util.GetServerVersion(),
+		},
+		"servers": []interface{}{map[string]interface{}{"url": base + "/api/" + API_VERSION_CURRENT}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": sc,
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"machine": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"session": []string{}}},
+	}
+}
+
+var openapiDocument = struct {
+	once *sync.Once
+	data []byte
+	err  error
+}{once: &sync.Once{}}
+
+// /openapi.json
+func (ah apiHandler) openapiRoot(w http.ResponseWriter, r *http.Request) error {
+	if head, _ := shiftPath(r.URL.Path); len(head) > 0 || r.Method != "GET" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	openapiDocument.once.Do(func() {
+		openapiDocument.data, openapiDocument.err = json.Marshal(buildOpenapiDocument(openapiRoutes, ah.basePath))
+	})
+	if openapiDocument.err != nil {
+		return internalErr(openapiDocument.err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(openapiDocument.data)
+	return nil
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOpenapiDocument(t *testing.T) {
+	r, err := http.Get(srv.URL + "/openapi.json")
+	CheckErrorAndResponse(t, r, err, 200)
+	doc := struct {
+		Openapi string                                       `json:"openapi"`
+		Paths   map[string]map[string]map[string]interface{} `json:"paths"`
+		Comps   struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+				Required   []string                          `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(doc.Openapi, "3.") {
+		t.Errorf("Unexpected openapi version %s", doc.Openapi)
+	}
+	login, ok := doc.Paths["/auth/login"]["post"]
+	if !ok {
+		t.Fatalf("Missing the login operation")
+	}
+	if sec, ok := login["security"].([]interface{}); !ok || len(sec) > 0 {
+		t.Errorf("Login should not require a session: %v", login["security"])
+	}
+	if _, ok := doc.Paths["/team/{tid}/vault/{vid}/secret/{sid}"]["put"]; !ok {
+		t.Errorf("Missing the path parameters of the secret update")
+	}
+	alr := doc.Comps.Schemas["authLoginResponse"]
+	if alr.Properties["session_token"]["type"] != "string" || alr.Properties["public_key"]["format"] != "byte" {
+		t.Errorf("Unexpected login response schema %v", alr.Properties)
+	}
+	if _, ok := alr.Properties["csrf"]; !ok {
+		t.Errorf("Missing csrf in the login response schema")
+	}
+	for _, name := range alr.Required {
+		if name == "csrf" {
+			t.Errorf("Fields with omitempty should not be required")
+		}
+	}
+	//Embedded sessions are flattened and the fields hidden from json are skipped
+	sgr := doc.Comps.Schemas["sessionGetTokenResponse"]
+	if _, ok := sgr.Properties["last_access"]; !ok {
+		t.Errorf("Expected the fields of the session in %v", sgr.Properties)
+	}
+	if _, ok := sgr.Properties["StoreToken"]; ok {
+		t.Errorf("Fields hidden from json should not be in %v", sgr.Properties)
+	}
+	if doc.Comps.Schemas["models.User"].Properties["created_at"]["format"] != "date-time" {
+		t.Errorf("Expected times to be described as date-time")
+	}
+}
+
+var routeComment = regexp.MustCompile(`(?m)^// (GET|POST|PUT|PATCH|DELETE) (/[^ ?\n]+)`)
+
+// Every handler documented with its route has to be in the document
+func TestOpenapiCoversRoutes(t *testing.T) {
+	documented := map[string]bool{}
+	ids := map[string]bool{}
+	for _, or := range openapiRoutes {
+		documented[or.method+" "+or.path] = true
+		if ids[or.id] {
+			t.Errorf("Duplicated operation id %s", or.id)
+		}
+		ids[or.id] = true
+	}
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range routeComment.FindAllStringSubmatch(string(data), -1) {
+			switch m[2] {
+			//Served outside of the api
+			case "/healthz", "/readyz", "/metrics":
+				continue
+			}
+			found++
+			if !documented[m[1]+" "+m[2]] {
+				t.Errorf("%s: %s %s is not in the openapi document", file, m[1], m[2])
+			}
+		}
+	}
+	if found == 0 {
+		t.Fatalf("No route comments found")
+	}
+}
+
+func TestOpenapiSchemaRecursion(t *testing.T) {
+	type node struct {
+		Name     string  `json:"name"`
+		Children []*node `json:"children,omitempty"`
+	}
+	sc := openapiSchemas{}
+	ref := sc.schema(reflect.TypeOf(&node{}))
+	if ref["$ref"] != "#/components/schemas/node" {
+		t.Fatalf("Unexpected reference %v", ref)
+	}
+	obj := sc["node"].(map[string]interface{})
+	items := obj["properties"].(map[string]interface{})["children"].(map[string]interface{})["items"]
+	if !reflect.DeepEqual(items, ref) {
+		t.Errorf("Expected the children to reference the node and got %v", items)
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+const JOB_ORPHAN_GC = "orphan_gc"
+
+func collectOrphans(ctx context.Context) (string, error) {
+	or, err := models.CollectOrphans(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	found := []string{}
+	for _, oc := range or.Orphans {
+		if oc.Count > 0 {
+			found = append(found, fmt.Sprintf("%d %s", oc.Count, oc.Kind))
+		}
+	}
+	if len(found) == 0 {
+		return "No orphaned rows found", nil
+	}
+	return "Removed " + strings.Join(found, ", "), nil
+}
+
+// GET /admin/orphans reports what the orphan collection would remove without removing anything
+func (ah apiHandler) adminOrphans(w http.ResponseWriter, r *http.Request) error {
+	or, err := models.CollectOrphans(r.Context(), true)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, or)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestAdminOrphans(t *testing.T) {
+	ctx := getCtx()
+	u := loginDummyUser()
+	r, err := GetRequest("/admin/orphans")
+	CheckErrorAndResponse(t, r, err, 401)
+	if err := u.SetAdmin(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//The user is already in the team so the invite will never be used
+	if _, err := teams[0].InviteByEmail(ctx, u.Email); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest("/admin/orphans")
+	CheckErrorAndResponse(t, r, err, 200)
+	or := &models.OrphanReport{}
+	if err := json.NewDecoder(r.Body).Decode(or); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, oc := range or.Orphans {
+		if oc.Kind == "invite_for_member" {
+			//Only the first ids are listed
+			found = oc.Count > models.AFFECTED_IDS_MAX
+			for _, id := range oc.Ids {
+				found = found || id == teams[0].Id+"/"+u.Email
+			}
+		}
+	}
+	if !or.DryRun || !found {
+		t.Fatalf("Stale invite is not reported in %#v", or)
+	}
+	invs, err := models.FindInvitesForEmail(ctx, u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invs) != 1 {
+		t.Fatalf("Dry run removed the invite")
+	}
+	if _, err := collectOrphans(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if invs, err = models.FindInvitesForEmail(ctx, u.Email); err != nil || len(invs) != 0 {
+		t.Fatalf("Stale invite has not been removed: %d left (%v)", len(invs), err)
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+type pairingResponse struct {
+	Code      string    `json:"code"`
+	Name      string    `json:"name,omitempty"`
+	PublicKey []byte    `json:"public_key,omitempty"`
+	Claimed   bool      `json:"claimed"`
+	Approved  bool      `json:"approved"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func newPairingResponse(p *models.Pairing) pairingResponse {
+	return pairingResponse{p.Code, p.Name, p.PublicKey, p.Claimed(), p.Approved(), p.ExpiresAt()}
+}
+
+// /session/pairing
+func (ah apiHandler) pairingRoot(w http.ResponseWriter, r *http.Request) error {
+	var code string
+	code, r.URL.Path = shiftPath(r.URL.Path)
+	if len(code) == 0 {
+		if r.Method == "POST" {
+			return ah.pairingCreate(w, r)
+		}
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	p, err := models.FindPairing(r.Context(), code)
+	if err != nil {
+		return err
+	}
+	if p.User != ctxGetUser(r.Context()).Id {
+		return util.NewErrorFrom(models.ErrDoesntExist)
+	}
+	switch r.Method {
+	case "GET":
+		return jsonResponse(w, newPairingResponse(p))
+	case "PUT":
+		return ah.pairingApprove(w, r, p)
+	case "DELETE":
+		if err := p.Delete(r.Context()); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// POST /session/pairing
+func (ah apiHandler) pairingCreate(w http.ResponseWriter, r *http.Request) error {
+	p, err := models.NewPairing(r.Context(), ctxGetUser(r.Context()).Id)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, newPairingResponse(p))
+}
+
+type pairingApproveRequest struct {
+	Keys []byte `json:"keys"`
+}
+
+// PUT /session/pairing/:code
+func (ah apiHandler) pairingApprove(w http.ResponseWriter, r *http.Request, p *models.Pairing) error {
+	par := &pairingApproveRequest{}
+	if err := jsonDecode(w, r, 1024*10, par); err != nil {
+		return err
+	}
+	if err := p.Approve(r.Context(), par.Keys); err != nil {
+		return err
+	}
+	return jsonResponse(w, newPairingResponse(p))
+}
+
+// /auth/pairing
+func (ah apiHandler) authPairing(w http.ResponseWriter, r *http.Request) error {
+	var code string
+	code, r.URL.Path = shiftPath(r.URL.Path)
+	if r.Method != "POST" || r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	if len(code) == 0 {
+		return ah.authPairingClaim(w, r)
+	}
+	return ah.authPairingFinish(w, r, code)
+}
+
+type authPairingClaimRequest struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	PublicKey []byte `json:"public_key"`
+}
+
+type authPairingClaimResponse struct {
+	Code      string    `json:"code"`
+	Secret    string    `json:"secret"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// POST /auth/pairing
+func (ah apiHandler) authPairingClaim(w http.ResponseWriter, r *http.Request) error {
+	apr := &authPairingClaimRequest{}
+	if err := jsonDecode(w, r, 1024*4, apr); err != nil {
+		return err
+	}
+	//The key of the extension is the device key of its session
+	if len(apr.PublicKey) != ed25519.PublicKeySize {
+		return util.NewErrorf("Invalid public key")
+	}
+	p, err := models.FindPairing(r.Context(), apr.Code)
+	if err != nil {
+		return err
+	}
+	secret, err := p.Claim(r.Context(), apr.Name, apr.PublicKey)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, authPairingClaimResponse{p.Code, secret, p.ExpiresAt()})
+}
+
+type authPairingFinishRequest struct {
+	Secret string `json:"secret"`
+}
+
+type authPairingFinishResponse struct {
+	Username   string `json:"user_id"`
+	Token      string `json:"session_token"`
+	StoreToken string `json:"store_token"`
+	PublicKeys []byte `json:"public_key"`
+	//Keys are the keys of the user sealed by the web app for the extension
+	Keys []byte `json:"keys"`
+}
+
+// POST /auth/pairing/:code
+func (ah apiHandler) authPairingFinish(w http.ResponseWriter, r *http.Request, code string) error {
+	afr := &authPairingFinishRequest{}
+	if err := jsonDecode(w, r, 1024, afr); err != nil {
+		return err
+	}
+	p, err := models.FindPairing(r.Context(), code)
+	if err != nil {
+		return err
+	}
+	if !p.CheckSecret(afr.Secret) {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	if !p.Approved() {
+		//The extension polls until the user approves it in the web app
+		w.WriteHeader(http.StatusAccepted)
+		return nil
+	}
+	u, err := models.FindUser(r.Context(), p.User)
+	if err != nil {
+		return err
+	}
+	if u.IsDisabled() || u.MustResetPassword {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	if revoked, err := models.IsDeviceKeyRevoked(r.Context(), p.PublicKey); err != nil {
+		return err
+	} else if revoked {
+		return util.NewErrorFrom(models.ErrRevokedKey)
+	}
+	//Removing the pairing first makes sure only one session comes out of it
+	if err := p.Delete(r.Context()); err != nil {
+		return err
+	}
+	//The session is bound to the key of the extension so the requests have to be signed with it and revoking the
+	//key logs the extension out
+	s, err := ah.sm.NewSession(u.Id, ah.clientIP(r), r.UserAgent(), false, p.PublicKey)
+	if err != nil {
+		return internalErr(err)
+	}
+	ah.auditLogAs(r, u.Id, AUDIT_SESSION_PAIR, auditObject("session", s.Id, "device", models.DeviceKeyFingerprint(p.PublicKey)))
+	return jsonResponse(w, authPairingFinishResponse{u.Id, s.Id, s.StoreToken, u.PublicKey, p.Keys})
+}
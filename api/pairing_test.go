@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPairExtension(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := loginDummyUser()
+	webToken := activeSessionToken
+	defer func() { activeSessionToken = webToken }()
+	r, err := PostRequest("/session/pairing", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	pr := &pairingResponse{}
+	if err := json.NewDecoder(r.Body).Decode(pr); err != nil {
+		t.Fatal(err)
+	}
+	if pr.Claimed || pr.Approved || len(pr.Code) == 0 {
+		t.Fatalf("Unexpected new pairing %#v", pr)
+	}
+	//The extension doesn't have a session
+	activeSessionToken = ""
+	typed := strings.ToLower(pr.Code[:4] + "-" + pr.Code[4:])
+	r, err = PostRequest("/auth/pairing", authPairingClaimRequest{typed, "Firefox extension", []byte("not a key")})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest("/auth/pairing", authPairingClaimRequest{typed, "Firefox extension", pub})
+	CheckErrorAndResponse(t, r, err, 200)
+	claim := &authPairingClaimResponse{}
+	if err := json.NewDecoder(r.Body).Decode(claim); err != nil {
+		t.Fatal(err)
+	}
+	r, err = PostRequest("/auth/pairing", authPairingClaimRequest{pr.Code, "Another extension", pub})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest("/auth/pairing/"+pr.Code, authPairingFinishRequest{"wrong"})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/auth/pairing/"+pr.Code, authPairingFinishRequest{claim.Secret})
+	CheckErrorAndResponse(t, r, err, 202)
+	activeSessionToken = webToken
+	r, err = GetRequest("/session/pairing/" + pr.Code)
+	CheckErrorAndResponse(t, r, err, 200)
+	if err := json.NewDecoder(r.Body).Decode(pr); err != nil {
+		t.Fatal(err)
+	}
+	if !pr.Claimed || pr.Approved || pr.Name != "Firefox extension" {
+		t.Fatalf("Unexpected claimed pairing %#v", pr)
+	}
+	r, err = PutRequest("/session/pairing/"+pr.Code, pairingApproveRequest{[]byte("sealed keys")})
+	CheckErrorAndResponse(t, r, err, 200)
+	activeSessionToken = ""
+	r, err = PostRequest("/auth/pairing/"+pr.Code, authPairingFinishRequest{claim.Secret})
+	CheckErrorAndResponse(t, r, err, 200)
+	fin := &authPairingFinishResponse{}
+	if err := json.NewDecoder(r.Body).Decode(fin); err != nil {
+		t.Fatal(err)
+	}
+	if fin.Username != u.Id || string(fin.Keys) != "sealed keys" || len(fin.Token) == 0 {
+		t.Fatalf("Unexpected pairing session %#v", fin)
+	}
+	r, err = PostRequest("/auth/pairing/"+pr.Code, authPairingFinishRequest{claim.Secret})
+	CheckErrorAndResponse(t, r, err, 404)
+	//The session of the extension is bound to its key
+	activeSessionToken = fin.Token
+	r, err = GetRequest("/session/" + fin.Token)
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = signedGetRequest("/session/"+fin.Token, priv, time.Now())
+	CheckErrorAndResponse(t, r, err, 200)
+	//Revoking the key of the extension logs it out
+	activeSessionToken = webToken
+	r, err = PostRequest("/device/revoked", deviceRevokeRequest{"Firefox extension", pub, "lost"})
+	CheckErrorAndResponse(t, r, err, 200)
+	if _, err := apiH.sm.GetSession(fin.Token); err == nil {
+		t.Fatal("Expected the session of the revoked extension to be removed")
+	}
+	if _, err := apiH.sm.GetSession(webToken); err != nil {
+		t.Fatalf("The sessions without the key should be kept: %s", err)
+	}
+}
+
+func TestPairingOfAnotherUser(t *testing.T) {
+	loginDummyUser()
+	r, err := PostRequest("/session/pairing", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	pr := &pairingResponse{}
+	if err := json.NewDecoder(r.Body).Decode(pr); err != nil {
+		t.Fatal(err)
+	}
+	r, err = DeleteRequest("/session/pairing/" + pr.Code)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/session/pairing/" + pr.Code)
+	CheckErrorAndResponse(t, r, err, 404)
+}
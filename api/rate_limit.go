@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	RATE_LIMIT_BY_IP    = "ip"
+	RATE_LIMIT_BY_USER  = "user"
+	RATE_LIMIT_BY_TOKEN = "token"
+)
+
+// routeMatches checks if the path is the route or below it and the method matches if one is defined
+func routeMatches(route, routeMethod, method, path string) bool {
+	if len(routeMethod) > 0 && !strings.EqualFold(routeMethod, method) {
+		return false
+	}
+	return path == route || strings.HasPrefix(path, strings.TrimSuffix(route, "/")+"/")
+}
+
+func (rl ConfRateLimitRule) matches(method, path string) bool {
+	return routeMatches(rl.Route, rl.Method, method, path)
+}
+
+type rateLimitResponse struct {
+	Error      string `json:"error"`
+	Limit      int    `json:"limit"`
+	Window     int    `json:"window"`
+	RetryAfter int    `json:"retry_after"`
+	RequestId  string `json:"request_id,omitempty"`
+}
+
+// rateLimitBlock counts the request against every rule for the path that limits by one of the principals.
+// It sets the X-RateLimit headers for the most restrictive rule and answers with a 429 if any limit is exceeded.
+// Returns true if the request has been answered
+func (ah apiHandler) rateLimitBlock(w http.ResponseWriter, r *http.Request, path string, principals map[string]string) bool {
+	return ah.rateLimitBlockRules(w, r, path, principals, ah.opts().rateLimits)
+}
+
+// rateLimitBlockRules is rateLimitBlock with rules that are built in instead of configured
+func (ah apiHandler) rateLimitBlockRules(w http.ResponseWriter, r *http.Request, path string, principals map[string]string, rules []ConfRateLimitRule) bool {
+	var tightest *ConfRateLimitRule
+	var exceeded *ConfRateLimitRule
+	remaining := 0
+	var reset time.Time
+	for _, rule := range rules {
+		principal, ok := principals[rule.By]
+		if !ok || len(principal) == 0 || !rule.matches(r.Method, path) {
+			continue
+		}
+		rule := rule
+		key := fmt.Sprintf("%s:%s:%s%s", rule.By, principal, strings.ToUpper(rule.Method), rule.Route)
+		count, ruleReset, err := ah.rateLimits.Hit(key, time.Duration(rule.Window)*time.Second)
+		if err != nil {
+			//Better to let requests through than to stop serving when the counters are not reachable
+			requestLogf(r, "[ERROR] Could not check rate limit for %s: %s", key, err)
+			continue
+		}
+		if count == rule.Limit+1 && rule.By == RATE_LIMIT_BY_IP {
+			ah.rateLimitTripped(r, principal, path)
+		}
+		left := rule.Limit - count
+		if left < 0 {
+			left = 0
+		}
+		if tightest == nil || left < remaining {
+			tightest, remaining, reset = &rule, left, ruleReset
+		}
+		if count > rule.Limit && (exceeded == nil || ruleReset.After(reset)) {
+			exceeded, reset = &rule, ruleReset
+			tightest, remaining = &rule, 0
+		}
+	}
+	if tightest == nil {
+		return false
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(tightest.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if exceeded == nil {
+		return false
+	}
+	retryAfter := int(time.Until(reset)/time.Second) + 1
+	b := util.BufPool.Get()
+	defer util.BufPool.Put(b)
+	json.NewEncoder(b).Encode(rateLimitResponse{"rate_limited", exceeded.Limit, exceeded.Window, retryAfter, ctxGetRequestId(r.Context())})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.Bytes())))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+	b.WriteTo(w)
+	return true
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRateLimitByIp(t *testing.T) {
+	boot := apiH.live.boot
+	defer apiH.Reload(boot)
+	c := boot
+	c.RateLimit.Rules = []ConfRateLimitRule{
+		{Route: "/api/version", Method: "GET", By: RATE_LIMIT_BY_IP, Limit: 2, Window: 60},
+		{Route: "/api/version", By: RATE_LIMIT_BY_IP, Limit: 5, Window: 60},
+	}
+	if _, err := apiH.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+	for i, remaining := range []string{"1", "0"} {
+		r, err := http.Get(srv.URL + "/version")
+		CheckErrorAndResponse(t, r, err, 200)
+		if r.Header.Get("X-RateLimit-Limit") != "2" || r.Header.Get("X-RateLimit-Remaining") != remaining {
+			t.Errorf("Unexpected rate limit headers in request %d: %v", i, r.Header)
+		}
+	}
+	r, err := http.Get(srv.URL + "/version")
+	CheckErrorAndResponse(t, r, err, http.StatusTooManyRequests)
+	if len(r.Header.Get("Retry-After")) == 0 || len(r.Header.Get("X-RateLimit-Reset")) == 0 {
+		t.Errorf("Expected retry headers in the 429: %v", r.Header)
+	}
+}
+
+func TestRateLimitByUser(t *testing.T) {
+	boot := apiH.live.boot
+	defer apiH.Reload(boot)
+	loginDummyUser()
+	c := boot
+	c.RateLimit.Rules = []ConfRateLimitRule{{Route: "/api/user", Method: "GET", By: RATE_LIMIT_BY_USER, Limit: 1, Window: 60}}
+	if _, err := apiH.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+	r, err := GetRequest("/user")
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/user")
+	CheckErrorAndResponse(t, r, err, http.StatusTooManyRequests)
+}
+
+func TestRateLimitRuleMatches(t *testing.T) {
+	rl := ConfRateLimitRule{Route: "/api/team", Method: "post"}
+	for path, expected := range map[string]bool{"/api/team": true, "/api/team/": true, "/api/team/t1/vault": true, "/api/teams": false} {
+		if rl.matches("POST", path) != expected {
+			t.Errorf("Expected match for %s to be %t", path, expected)
+		}
+	}
+	if rl.matches("GET", "/api/team") {
+		t.Errorf("Expected the method to be checked")
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/facebookgo/stack"
+	"github.com/keydotcat/keycatd/managers"
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+var errorReportHiddenHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"X-Csrf-Token":  true,
+	"Referer":       true,
+}
+
+// newErrorReport only sends the route of the request like the metrics do. Paths carry confirmation tokens, pairing
+// codes and session ids that can't end up in the error reports
+func newErrorReport(r *http.Request, path, kind, msg string, st stack.Stack) managers.ErrorReport {
+	headers := map[string]string{}
+	for k, v := range r.Header {
+		if !errorReportHiddenHeaders[k] && len(v) > 0 {
+			headers[k] = v[0]
+		}
+	}
+	return managers.ErrorReport{
+		Type:      kind,
+		Message:   msg,
+		Stack:     st,
+		RequestId: ctxGetRequestId(r.Context()),
+		Method:    r.Method,
+		Url:       metricsRoute(path),
+		Headers:   headers,
+	}
+}
+
+func (ah apiHandler) recoverPanic(w *metricsResponseWriter, r *http.Request, path string, rec interface{}) {
+	//Let net/http deal with aborted handlers
+	if rec == http.ErrAbortHandler {
+		panic(rec)
+	}
+	msg := fmt.Sprint(rec)
+	if err, ok := rec.(error); ok {
+		msg = err.Error()
+	}
+	st := stack.Callers(3)
+	requestLogf(r, "[ERROR] Panic serving %s %s: %s\n%s", r.Method, path, msg, st)
+	ah.errors.Report(newErrorReport(r, path, "panic", msg, st))
+	if w.hijacked || w.status != 0 {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err, ok := rec.(error); ok && models.IsTimeoutErr(err) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		json.NewEncoder(w).Encode(internalErrorResponse{models.ErrTimeout.Error(), ctxGetRequestId(r.Context())})
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(internalErrorResponse{ErrInternal.Error(), ctxGetRequestId(r.Context())})
+}
+
+func (ah apiHandler) reportError(r *http.Request, path string, err error) {
+	if ie, ok := err.(internalError); ok {
+		err = ie.err
+	}
+	if util.CheckErr(err, ErrNotFound) || util.CheckErr(err, models.ErrDoesntExist) || util.CheckErr(err, models.ErrUnauthorized) {
+		return
+	}
+	var st stack.Stack
+	if me, ok := err.(*util.Error); ok && len(me.MultiStack().Stacks()) > 0 {
+		st = me.MultiStack().Stacks()[0]
+	}
+	ah.errors.Report(newErrorReport(r, path, fmt.Sprintf("%T", err), err.Error(), st))
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	rec := httptest.NewRecorder()
+	r := withRequestId(rec, httptest.NewRequest("GET", "/api/boom", nil))
+	apiH.recoverPanic(&metricsResponseWriter{ResponseWriter: rec}, r, "/api/boom", "boom")
+	if rec.Code != 500 {
+		t.Fatalf("Expected a 500 and got %d", rec.Code)
+	}
+	body := map[string]string{}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body["request_id"]) == 0 || body["request_id"] != rec.Header().Get(requestIdHeader) {
+		t.Errorf("Expected the request id in the response: %#v", body)
+	}
+}
+
+func TestInternalErrorResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	r := withRequestId(rec, httptest.NewRequest("GET", "/api/boom", nil))
+	httpErr(rec, r, internalErr(errors.New("smtp is down")))
+	if rec.Code != 500 {
+		t.Fatalf("Expected a 500 and got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "smtp") {
+		t.Errorf("Internal details leaked to the client: %s", rec.Body.String())
+	}
+	body := map[string]string{}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != ErrInternal.Error() || body["request_id"] != rec.Header().Get(requestIdHeader) {
+		t.Errorf("Unexpected response %#v", body)
+	}
+}
+
+func TestErrorReportHidesTokens(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/v1/auth/confirm_email/secrettoken", nil)
+	r.Header.Set("Authorization", "Bearer secrettoken")
+	r.Header.Set("Referer", "https://keycat.test/#/confirm/secrettoken")
+	r.Header.Set("User-Agent", "test")
+	er := newErrorReport(r, r.URL.Path, "panic", "boom", nil)
+	if er.Url != "/api/auth" || er.Headers["User-Agent"] != "test" {
+		t.Errorf("Unexpected error report %#v", er)
+	}
+	for k, v := range er.Headers {
+		if strings.Contains(v, "secrettoken") {
+			t.Errorf("Header %s leaked the token to the error report", k)
+		}
+	}
+}
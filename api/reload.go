@@ -0,0 +1,118 @@
+package api
+
+import (
+	"log"
+	"reflect"
+	"sync"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+type apiOptions struct {
+	onlyInvited        bool
+	metricsToken       string
+	reportErrors       bool
+	rateLimits         []ConfRateLimitRule
+	bodyLimits         []ConfBodyLimit
+	blocklist          ConfBlocklist
+	cleanup            ConfCleanup
+	clientVersions     map[string]string
+	idpHooksToken      string
+	oidcClients        []ConfOIDCClient
+	loginPolicy        ConfLoginPolicy
+	auditRetentionDays int
+	retention          ConfRetention
+}
+
+func newAPIOptions(c Conf) apiOptions {
+	return apiOptions{c.OnlyInvited, c.Metrics.Token, c.Sentry.ReportErrors, c.RateLimit.Rules, c.BodyLimits, c.Blocklist, c.Cleanup, clientVersions(c.ClientVersions), c.IdpHooks.Token, c.OIDC.Clients, c.LoginPolicy, c.Audit.RetentionDays, c.Retention}
+}
+
+// liveConf keeps the settings that can be changed with a reload and the configuration the server started with
+type liveConf struct {
+	lock    *sync.RWMutex
+	boot    Conf
+	options apiOptions
+}
+
+func newLiveConf(c Conf) *liveConf {
+	return &liveConf{&sync.RWMutex{}, c, newAPIOptions(c)}
+}
+
+func (ah apiHandler) opts() apiOptions {
+	ah.live.lock.RLock()
+	defer ah.live.lock.RUnlock()
+	return ah.live.options
+}
+
+// restartRequired returns the settings that differ from the ones the server started with and can't be hot reloaded
+func (c Conf) restartRequired(boot Conf) []string {
+	changed := []string{}
+	check := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+	check("url", c.Url, boot.Url)
+	check("port", c.Port, boot.Port)
+	check("db", c.DB, boot.DB)
+	check("proxy_mode", c.ProxyMode, boot.ProxyMode)
+	check("trusted_proxies", c.TrustedProxies, boot.TrustedProxies)
+	check("db.replica", c.DBReplica, boot.DBReplica)
+	check("db.type", c.DBType, boot.DBType)
+	check("db.maxconns", c.DBMaxConns, boot.DBMaxConns)
+	check("db.max_idle_conns", c.DBMaxIdleConns, boot.DBMaxIdleConns)
+	check("db.conn_max_lifetime", c.DBConnMaxLifetime, boot.DBConnMaxLifetime)
+	check("db.statement_timeout", c.DBStatementTimeout, boot.DBStatementTimeout)
+	check("db.tx_retries", c.DBTxRetries, boot.DBTxRetries)
+	check("db.query_timeout", c.DBQueryTimeout, boot.DBQueryTimeout)
+	check("session.redis", c.SessionRedis, boot.SessionRedis)
+	check("csrf", c.Csrf, boot.Csrf)
+	check("metrics.port", c.Metrics.Port, boot.Metrics.Port)
+	check("sentry.dsn", c.Sentry.DSN, boot.Sentry.DSN)
+	check("sentry.environment", c.Sentry.Environment, boot.Sentry.Environment)
+	check("audit.syslog", c.Audit.Syslog, boot.Audit.Syslog)
+	check("audit.checkpoint_key_file", c.Audit.CheckpointKeyFile, boot.Audit.CheckpointKeyFile)
+	check("cache", c.Cache, boot.Cache)
+	check("tls", c.TLS, boot.TLS)
+	check("cors", c.Cors, boot.Cors)
+	check("cluster", c.Cluster, boot.Cluster)
+	check("jobs.schedules", c.JobSchedules, boot.JobSchedules)
+	check("ratelimit.redis", c.RateLimit.Redis, boot.RateLimit.Redis)
+	check("web", c.Web, boot.Web)
+	check("oidc.key_file", c.OIDC.KeyFile, boot.OIDC.KeyFile)
+	check("kms", c.KMS, boot.KMS)
+	check("keylog", c.KeyLog, boot.KeyLog)
+	return changed
+}
+
+// Reload applies the mail settings, registration mode, plaintext check, token lifetimes, login policy, rate limit rules, body limits, automatic blocking, cleanup and data retention,
+// minimum client versions, metrics and identity provider tokens, OIDC clients, error reporting and log level without restarting. It returns the settings that changed but will only be applied after a restart
+func (ah apiHandler) Reload(c Conf) ([]string, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	ah.live.lock.Lock()
+	defer ah.live.lock.Unlock()
+	level := c.LogLevel
+	if len(level) == 0 {
+		level = util.LOG_LEVEL_INFO
+	}
+	if err := util.SetLogLevel(level); err != nil {
+		return nil, err
+	}
+	if !TEST_MODE && ah.mail != nil {
+		if mailMgr := newMailMgr(c); mailMgr != nil {
+			ah.mail.setMailMgr(mailMgr)
+		}
+	}
+	ah.live.options = newAPIOptions(c)
+	models.SetRejectPlaintext(c.RejectPlaintext)
+	setTokenLifetimes(c.TokenLifetimes)
+	pending := c.restartRequired(ah.live.boot)
+	for _, name := range pending {
+		log.Printf("[ERROR] Setting %s has changed but can't be reloaded. Restart the server to apply it", name)
+	}
+	return pending, nil
+}
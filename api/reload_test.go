@@ -0,0 +1,35 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestReloadConf(t *testing.T) {
+	boot := apiH.live.boot
+	defer apiH.Reload(boot)
+	c := boot
+	c.OnlyInvited = true
+	c.Sentry.ReportErrors = true
+	pending, err := apiH.Reload(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("Expected no pending settings and got %v", pending)
+	}
+	if o := apiH.opts(); !o.onlyInvited || !o.reportErrors {
+		t.Errorf("Options were not reloaded: %#v", o)
+	}
+	c.Port = boot.Port + 1
+	c.DB = boot.DB + " connect_timeout=10"
+	if pending, err = apiH.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0] != "port" || pending[1] != "db" {
+		t.Errorf("Expected port and db to require a restart and got %v", pending)
+	}
+	c.LogLevel = "verbose"
+	if _, err = apiH.Reload(c); err == nil {
+		t.Errorf("Expected an error for an invalid log level")
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+var reValidRequestId = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,64}$`)
+
+// withRequestId reuses the request id sent by the client or proxy if it looks sane or generates a new one
+func withRequestId(w http.ResponseWriter, r *http.Request) *http.Request {
+	rid := r.Header.Get(requestIdHeader)
+	if !reValidRequestId.MatchString(rid) {
+		rid = util.GenerateRandomToken(20)
+	}
+	w.Header().Set(requestIdHeader, rid)
+	return r.WithContext(ctxAddRequestId(r.Context(), rid))
+}
+
+func requestLogf(r *http.Request, format string, v ...interface{}) {
+	rid := ctxGetRequestId(r.Context())
+	if len(rid) == 0 {
+		log.Printf(format, v...)
+		return
+	}
+	log.Printf("[%s] %s", rid, fmt.Sprintf(format, v...))
+}
+
+func errWithRequestId(r *http.Request, err error) interface{} {
+	rid := ctxGetRequestId(r.Context())
+	if len(rid) == 0 {
+		return err
+	}
+	body := map[string]interface{}{}
+	if data, merr := json.Marshal(err); merr == nil {
+		json.Unmarshal(data, &body)
+	}
+	body["request_id"] = rid
+	return body
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRequestId(t *testing.T) {
+	r, err := GetRequest("/version")
+	CheckErrorAndResponse(t, r, err, 200)
+	if len(r.Header.Get(requestIdHeader)) == 0 {
+		t.Fatalf("No request id was generated")
+	}
+	req, err := http.NewRequest("GET", srv.URL+"/auth/nonexistant", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(requestIdHeader, "support-1234")
+	r, err = httpDo(req)
+	CheckErrorAndResponse(t, r, err, 404)
+	if rid := r.Header.Get(requestIdHeader); rid != "support-1234" {
+		t.Errorf("Expected the client request id to be kept and got %s", rid)
+	}
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["request_id"] != "support-1234" {
+		t.Errorf("Expected the request id in the error response: %#v", body)
+	}
+	req.Header.Set(requestIdHeader, "not a valid\tid")
+	r, err = httpDo(req)
+	CheckErrorAndResponse(t, r, err, 404)
+	if rid := r.Header.Get(requestIdHeader); !reValidRequestId.MatchString(rid) {
+		t.Errorf("Expected a new request id and got %s", rid)
+	}
+}
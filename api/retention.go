@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+const (
+	JOB_RETENTION = "retention"
+
+	RETENTION_AUDIT_ENTRIES      = "audit_entries"
+	RETENTION_LOGIN_ATTEMPTS     = "login_attempts"
+	RETENTION_SECRET_VERSIONS    = "secret_versions"
+	RETENTION_WEBHOOK_DELIVERIES = "webhook_deliveries"
+)
+
+// retentionClass is a kind of data the retention job purges once it's older than the days configured for it
+type retentionClass struct {
+	name  string
+	days  func(apiOptions) int
+	purge func(ctx context.Context, before time.Time, dryRun bool) (models.AffectedRows, error)
+}
+
+var retentionClasses = []retentionClass{
+	{RETENTION_AUDIT_ENTRIES, func(o apiOptions) int { return o.auditRetentionDays }, models.PurgeAuditEntries},
+	{RETENTION_LOGIN_ATTEMPTS, func(o apiOptions) int { return o.retention.LoginAttemptDays }, purgeLoginAttempts},
+	{RETENTION_SECRET_VERSIONS, func(o apiOptions) int { return o.retention.SecretVersionDays }, models.PurgeSecretVersions},
+	{RETENTION_WEBHOOK_DELIVERIES, func(o apiOptions) int { return o.retention.WebhookDeliveryDays }, models.PurgeWebhookDeliveries},
+}
+
+func purgeLoginAttempts(ctx context.Context, before time.Time, dryRun bool) (models.AffectedRows, error) {
+	return models.PurgeAuditEntriesWithActions(ctx, []string{AUDIT_AUTH_LOGIN, AUDIT_AUTH_LOGIN_FAILED}, before, dryRun)
+}
+
+// enforceRetention purges every class of data older than its retention. A retention of 0 keeps the class forever
+func (ah apiHandler) enforceRetention(ctx context.Context) (string, error) {
+	cr, err := ah.applyRetention(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	if len(cr.Affected) == 0 {
+		return "No retention is configured", nil
+	}
+	purged := []string{}
+	for _, ar := range cr.Affected {
+		purged = append(purged, fmt.Sprintf("%d %s", ar.Count, ar.Kind))
+	}
+	return "Purged " + strings.Join(purged, ", "), nil
+}
+
+// applyRetention does the work of the retention job and records the run of every class. With dryRun it only reports
+// what the job would remove
+func (ah apiHandler) applyRetention(ctx context.Context, dryRun bool) (*models.ChangeReport, error) {
+	opts := ah.opts()
+	now := time.Now().UTC()
+	cr := models.NewChangeReport(dryRun)
+	for _, rc := range retentionClasses {
+		days := rc.days(opts)
+		if days == 0 {
+			continue
+		}
+		cutoff := retentionCutoff(now, days)
+		ar, err := rc.purge(ctx, cutoff, dryRun)
+		if err != nil {
+			return nil, err
+		}
+		ar.Kind = rc.name
+		cr.Add(ar)
+		if dryRun {
+			continue
+		}
+		if err := models.RecordRetentionRun(ctx, &models.RetentionRun{Class: rc.name, Days: days, Cutoff: cutoff, Purged: ar.Count}); err != nil {
+			return nil, err
+		}
+	}
+	return cr, nil
+}
+
+type retentionClassStatus struct {
+	Class   string               `json:"class"`
+	Days    int                  `json:"days"`
+	LastRun *models.RetentionRun `json:"last_run"`
+}
+
+type retentionResponse struct {
+	Classes []retentionClassStatus `json:"classes"`
+}
+
+// GET /admin/retention reports the retention of every class and what its last run purged
+func (ah apiHandler) adminRetention(w http.ResponseWriter, r *http.Request) error {
+	rrs, err := models.GetRetentionRuns(r.Context())
+	if err != nil {
+		return err
+	}
+	runs := map[string]*models.RetentionRun{}
+	for _, rr := range rrs {
+		runs[rr.Class] = rr
+	}
+	opts := ah.opts()
+	resp := retentionResponse{Classes: []retentionClassStatus{}}
+	for _, rc := range retentionClasses {
+		resp.Classes = append(resp.Classes, retentionClassStatus{rc.name, rc.days(opts), runs[rc.name]})
+	}
+	return jsonResponse(w, resp)
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestRetention(t *testing.T) {
+	boot := apiH.live.boot
+	defer apiH.Reload(boot)
+	c := boot
+	c.Retention = ConfRetention{LoginAttemptDays: 30, WebhookDeliveryDays: 7}
+	if _, err := apiH.Reload(c); err != nil {
+		t.Fatal(err)
+	}
+	ctx := getCtx()
+	u := loginDummyUser()
+	if err := u.SetAdmin(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	r, err := PostRequest("/admin/jobs/"+JOB_RETENTION+"/run?dry_run=true", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	cr := &models.ChangeReport{}
+	if err := json.NewDecoder(r.Body).Decode(cr); err != nil {
+		t.Fatal(err)
+	}
+	if !cr.DryRun || len(cr.Affected) != 2 || cr.Affected[0].Kind != RETENTION_LOGIN_ATTEMPTS || cr.Affected[1].Kind != RETENTION_WEBHOOK_DELIVERIES {
+		t.Fatalf("Expected a dry run of the configured classes and got %#v", cr)
+	}
+	if _, err := apiH.enforceRetention(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest("/admin/retention")
+	CheckErrorAndResponse(t, r, err, 200)
+	rr := &retentionResponse{}
+	if err := json.NewDecoder(r.Body).Decode(rr); err != nil {
+		t.Fatal(err)
+	}
+	if len(rr.Classes) != len(retentionClasses) {
+		t.Fatalf("Expected every class in %#v", rr)
+	}
+	for _, rc := range rr.Classes {
+		switch rc.Class {
+		case RETENTION_LOGIN_ATTEMPTS, RETENTION_WEBHOOK_DELIVERIES:
+			if rc.Days == 0 || rc.LastRun == nil || rc.LastRun.Days != rc.Days {
+				t.Errorf("Expected the last run of %s and got %#v", rc.Class, rc)
+			}
+		case RETENTION_SECRET_VERSIONS:
+			if rc.Days != 0 || rc.LastRun != nil {
+				t.Errorf("Secret versions are kept forever but got %#v", rc)
+			}
+		}
+	}
+	c.Retention.SecretVersionDays = -1
+	if _, err := apiH.Reload(c); err == nil {
+		t.Errorf("Negative retention days were accepted")
+	}
+}
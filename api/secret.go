@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/keydotcat/keycatd/managers"
@@ -22,18 +23,71 @@ func (ah apiHandler) teamSecretRoot(w http.ResponseWriter, r *http.Request, t *m
 }
 
 type teamSecretListWrap struct {
-	Secrets []*models.Secret `json:"secrets"`
+	Secrets    []*models.Secret `json:"secrets"`
+	NextCursor string           `json:"next_cursor,omitempty"`
 }
 
-// GET /team/:tid/secret
+// The vault is part of the id as secret ids are only unique inside their vault
+var secretListSpec = listSpec{
+	id:          func(i interface{}) string { return i.(*models.Secret).Vault + "/" + i.(*models.Secret).Id },
+	defaultSort: "id",
+	sorts: map[string]listField{
+		"id":         func(i interface{}) string { return i.(*models.Secret).Vault + "/" + i.(*models.Secret).Id },
+		"created_at": func(i interface{}) string { return listTime(i.(*models.Secret).CreatedAt) },
+	},
+	filters: map[string]listField{
+		"vault": func(i interface{}) string { return i.(*models.Secret).Vault },
+	},
+	fields: []string{"vault", "id", "version", "data", "vault_version", "created_at", "archived_at"},
+}
+
+// archivedFilter returns which secrets to list for the archived query parameter. Archived secrets are left out by
+// default, sent with the rest with include and alone with only
+func archivedFilter(r *http.Request) (func(*models.Secret) bool, error) {
+	switch r.URL.Query().Get("archived") {
+	case "":
+		return func(s *models.Secret) bool { return !s.ArchivedAt.Valid }, nil
+	case "include":
+		return func(*models.Secret) bool { return true }, nil
+	case "only":
+		return func(s *models.Secret) bool { return s.ArchivedAt.Valid }, nil
+	}
+	return nil, util.NewErrorf("Invalid archived filter")
+}
+
+// secretFilter returns which secrets to list for the archived and the label query parameters
+func secretFilter(r *http.Request, t *models.Team, load func(context.Context) ([]*models.SecretLabel, error)) (func(*models.Secret) bool, error) {
+	archived, err := archivedFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	labeled, err := labelFilter(r, t, load)
+	if err != nil {
+		return nil, err
+	}
+	return func(s *models.Secret) bool { return archived(s) && labeled(s) }, nil
+}
+
+// GET /team/:tid/secret?sort=-created_at&vault=&label=&archived=&fields=
 func (ah apiHandler) teamSecretGetAll(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	lq, err := parseListQuery(r, secretListSpec)
+	if err != nil {
+		return err
+	}
 	ctx := r.Context()
 	u := ctxGetUser(ctx)
-	s, err := t.GetSecretsForUser(ctx, u)
+	keep, err := secretFilter(r, t, func(ctx context.Context) ([]*models.SecretLabel, error) { return t.GetSecretLabelsForUser(ctx, u) })
 	if err != nil {
 		return err
 	}
-	return jsonResponse(w, teamSecretListWrap{s})
+	return lq.stream(w, r, "secrets", func(fn func(interface{}) error) error {
+		return t.ForEachSecretForUser(ctx, u, func(s *models.Secret) error {
+			if !keep(s) {
+				return nil
+			}
+			return fn(s)
+		})
+	})
 }
 
 // /team/:tid/vault/:vid/secret
@@ -47,6 +101,15 @@ func (ah apiHandler) validVaultSecretRoot(w http.ResponseWriter, r *http.Request
 		case "POST":
 			return ah.vaultCreateSecret(w, r, t, v)
 		}
+	} else if sub, _ := shiftPath(r.URL.Path); len(sub) > 0 {
+		switch {
+		case sub == "labels" && r.Method == "PUT":
+			return ah.vaultSetSecretLabels(w, r, t, v, head)
+		case sub == "archive" && r.Method == "PUT":
+			return ah.vaultArchiveSecret(w, r, t, v, head, true)
+		case sub == "archive" && r.Method == "DELETE":
+			return ah.vaultArchiveSecret(w, r, t, v, head, false)
+		}
 	} else {
 		switch r.Method {
 		case "DELETE":
@@ -58,14 +121,25 @@ func (ah apiHandler) validVaultSecretRoot(w http.ResponseWriter, r *http.Request
 	return util.NewErrorFrom(ErrNotFound)
 }
 
+// GET /team/:tid/vault/:vid/secret?sort=-created_at&label=&archived=&fields=
 func (ah apiHandler) vaultGetSecrets(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	lq, err := parseListQuery(r, secretListSpec)
+	if err != nil {
+		return err
+	}
 	ctx := r.Context()
-	secrets, err := v.GetSecrets(ctx)
+	keep, err := secretFilter(r, t, v.GetSecretLabels)
 	if err != nil {
 		return err
 	}
-	return jsonResponse(w, teamSecretListWrap{secrets})
-
+	return lq.stream(w, r, "secrets", func(fn func(interface{}) error) error {
+		return v.ForEachSecret(ctx, func(s *models.Secret) error {
+			if !keep(s) {
+				return nil
+			}
+			return fn(s)
+		})
+	})
 }
 
 type vaultCreateSecretRequest struct {
@@ -85,6 +159,7 @@ func (ah apiHandler) vaultCreateSecret(w http.ResponseWriter, r *http.Request, t
 		return err
 	}
 	ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_NEW, s)
+	ah.auditLog(r, AUDIT_SECRET_CREATE, auditObject("team", v.Team, "vault", v.Id, "secret", s.Id))
 	return jsonResponse(w, s)
 }
 
@@ -94,9 +169,26 @@ func (ah apiHandler) vaultDeleteSecret(w http.ResponseWriter, r *http.Request, t
 		return err
 	}
 	ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_REMOVE, &models.Secret{Id: sid})
+	ah.auditLog(r, AUDIT_SECRET_DELETE, auditObject("team", v.Team, "vault", v.Id, "secret", sid))
 	return jsonResponse(w, v)
 }
 
+// PUT /team/:tid/vault/:vid/secret/:sid/archive
+// DELETE /team/:tid/vault/:vid/secret/:sid/archive
+func (ah apiHandler) vaultArchiveSecret(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, sid string, archived bool) error {
+	s, err := v.ArchiveSecret(r.Context(), sid, archived)
+	if err != nil {
+		return err
+	}
+	action := AUDIT_SECRET_UNARCHIVE
+	if archived {
+		action = AUDIT_SECRET_ARCHIVE
+	}
+	ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_CHANGE, s)
+	ah.auditLog(r, action, auditObject("team", v.Team, "vault", v.Id, "secret", sid))
+	return jsonResponse(w, s)
+}
+
 func (ah apiHandler) vaultUpdateSecret(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, sid string) error {
 	ctx := r.Context()
 	vscr := &vaultCreateSecretRequest{}
@@ -111,6 +203,7 @@ func (ah apiHandler) vaultUpdateSecret(w http.ResponseWriter, r *http.Request, t
 				return err
 			}
 			ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_CHANGE, s)
+			ah.auditLog(r, AUDIT_SECRET_UPDATE, auditObject("team", v.Team, "vault", v.Id, "secret", sid))
 		}
 		return jsonResponse(w, s)
 	} else {
@@ -133,6 +226,7 @@ func (ah apiHandler) vaultUpdateSecret(w http.ResponseWriter, r *http.Request, t
 		}
 		ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_REMOVE, &models.Secret{Id: sid})
 		ah.bcast.Send(targetTeam.Id, targetVault.Id, managers.BCAST_ACTION_SECRET_NEW, s)
+		ah.auditLog(r, AUDIT_SECRET_MOVE, auditObject("team", targetTeam.Id, "vault", targetVault.Id, "secret", s.Id))
 		return jsonResponse(w, s)
 	}
 }
@@ -165,6 +259,7 @@ func (ah apiHandler) vaultCreateSecretList(w http.ResponseWriter, r *http.Reques
 	}
 	for _, s := range sl {
 		ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_NEW, s)
+		ah.auditLog(r, AUDIT_SECRET_CREATE, auditObject("team", v.Team, "vault", v.Id, "secret", s.Id))
 	}
-	return jsonResponse(w, teamSecretListWrap{sl})
+	return jsonResponse(w, teamSecretListWrap{Secrets: sl})
 }
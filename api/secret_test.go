@@ -3,6 +3,9 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/keydotcat/keycatd/models"
@@ -54,6 +57,51 @@ func TestGetAllSecrets(t *testing.T) {
 	}
 }
 
+func TestArchiveSecret(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vfs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vfs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	r, err := PostRequest(fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id), vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	r, err = PutRequest(fmt.Sprintf("/team/%s/vault/%s/secret/%s/archive", team.Id, v.Id, s.Id), nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	count := func(query string) int {
+		r, err := GetRequest(fmt.Sprintf("/team/%s/secret%s", team.Id, query))
+		CheckErrorAndResponse(t, r, err, 200)
+		sl := &teamSecretListWrap{}
+		if err := json.NewDecoder(r.Body).Decode(sl); err != nil {
+			t.Fatal(err)
+		}
+		return len(sl.Secrets)
+	}
+	for query, expected := range map[string]int{"": 0, "?archived=include": 1, "?archived=only&sort=id": 1} {
+		if got := count(query); got != expected {
+			t.Errorf("Expected %d secrets for '%s' and got %d", expected, query, got)
+		}
+	}
+	r, err = GetRequest(fmt.Sprintf("/team/%s/secret?archived=maybe", team.Id))
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/vault/%s/secret/%s/archive", team.Id, v.Id, s.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	if got := count(""); got != 1 {
+		t.Errorf("Expected the restored secret to be listed and got %d", got)
+	}
+}
+
 func TestAddSecretList(t *testing.T) {
 	u := loginDummyUser()
 	ctx := getCtx()
@@ -97,3 +145,42 @@ func TestAddSecretList(t *testing.T) {
 	}
 
 }
+
+func TestJSONListStream(t *testing.T) {
+	r := httptest.NewRequest("GET", "/team/t/secret", nil)
+	rec := httptest.NewRecorder()
+	ls := newJSONListStream(rec, "secrets")
+	if err := ls.close(r, nil); err != nil || strings.TrimSpace(rec.Body.String()) != `{"secrets":[]}` {
+		t.Fatalf("Unexpected empty listing %q: %v", rec.Body.String(), err)
+	}
+	rec = httptest.NewRecorder()
+	ls = newJSONListStream(rec, "secrets")
+	for _, id := range []string{"s1", "s2"} {
+		if err := ls.write(&models.Secret{Id: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ls.close(r, nil); err != nil {
+		t.Fatal(err)
+	}
+	sl := &teamSecretListWrap{}
+	if err := json.Unmarshal(rec.Body.Bytes(), sl); err != nil || len(sl.Secrets) != 2 || sl.Secrets[1].Id != "s2" {
+		t.Fatalf("Unexpected listing %q: %v", rec.Body.String(), err)
+	}
+	if rec.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("Unexpected content type %s", rec.Header().Get("Content-Type"))
+	}
+	//Errors before the first item can still be answered normally
+	ls = newJSONListStream(httptest.NewRecorder(), "secrets")
+	if err := ls.close(r, models.ErrTimeout); err != models.ErrTimeout {
+		t.Errorf("Expected the error to be returned and got %v", err)
+	}
+	ls = newJSONListStream(httptest.NewRecorder(), "secrets")
+	ls.write(&models.Secret{Id: "s1"})
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Errorf("Expected the response to be aborted and got %v", rec)
+		}
+	}()
+	ls.close(r, models.ErrTimeout)
+}
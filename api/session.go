@@ -11,8 +11,13 @@ import (
 func (ah apiHandler) sessionRoot(w http.ResponseWriter, r *http.Request) error {
 	var head string
 	head, r.URL.Path = shiftPath(r.URL.Path)
+	if head == "pairing" {
+		return ah.pairingRoot(w, r)
+	}
 	if len(head) == 0 {
-		//TODO: list all sessions
+		if r.Method == "GET" {
+			return ah.sessionList(w, r, ctxGetUser(r.Context()).Id)
+		}
 		return util.NewErrorFrom(ErrNotFound)
 	} else {
 		switch r.Method {
@@ -25,6 +30,36 @@ func (ah apiHandler) sessionRoot(w http.ResponseWriter, r *http.Request) error {
 	return util.NewErrorFrom(ErrNotFound)
 }
 
+type sessionListResponse struct {
+	Sessions   []*managers.Session `json:"sessions"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+var sessionListSpec = listSpec{
+	id:          func(i interface{}) string { return i.(*managers.Session).Id },
+	defaultSort: "last_access",
+	sorts: map[string]listField{
+		"last_access": func(i interface{}) string { return listTime(i.(*managers.Session).LastAccess) },
+	},
+	filters: map[string]listField{
+		"last_ip": func(i interface{}) string { return i.(*managers.Session).LastIp },
+	},
+}
+
+// GET /session?sort=-last_access&last_ip=
+func (ah apiHandler) sessionList(w http.ResponseWriter, r *http.Request, uid string) error {
+	lq, err := parseListQuery(r, sessionListSpec)
+	if err != nil {
+		return err
+	}
+	sessions, err := ah.sm.GetAllSessions(uid)
+	if err != nil {
+		return err
+	}
+	next := lq.apply(&sessions)
+	return jsonResponse(w, sessionListResponse{sessions, next})
+}
+
 type sessionGetTokenResponse struct {
 	*managers.Session
 	StoreToken string `json:"store_token,omitempty"`
@@ -56,6 +91,7 @@ func (ah apiHandler) sessionDeleteToken(w http.ResponseWriter, r *http.Request,
 	if err := ah.sm.DeleteSession(tid); err != nil {
 		return err
 	}
+	ah.auditLog(r, AUDIT_SESSION_DELETE, auditObject("session", tid))
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
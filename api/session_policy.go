@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// /team/:tid/session_policy
+func (ah apiHandler) teamSessionPolicyRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if len(head) > 0 {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	switch r.Method {
+	case "GET":
+		return ah.teamSessionPolicyGet(w, r, t)
+	case "PUT":
+		return ah.teamSessionPolicySet(w, r, t)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /team/:tid/session_policy
+func (ah apiHandler) teamSessionPolicyGet(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	tsp, err := t.GetSessionPolicy(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, tsp)
+}
+
+type teamSessionPolicyRequest struct {
+	RequireCSRF     bool `json:"require_csrf"`
+	MaxSessionHours int  `json:"max_session_hours"`
+}
+
+// PUT /team/:tid/session_policy
+// The sessions of the members that don't meet the new policy are closed the next time they are used
+func (ah apiHandler) teamSessionPolicySet(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	tspr := &teamSessionPolicyRequest{}
+	if err := jsonDecode(w, r, 1024, tspr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	tsp := &models.TeamSessionPolicy{RequireCSRF: tspr.RequireCSRF, MaxSessionHours: tspr.MaxSessionHours}
+	if err := t.SetSessionPolicy(ctx, ctxGetUser(ctx), tsp); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_TEAM_SESSION_POLICY, auditObject("team", t.Id))
+	return jsonResponse(w, tsp)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestTeamSessionPolicy(t *testing.T) {
+	ctx := getCtx()
+	admin := loginDummyUser()
+	adminToken := activeSessionToken
+	teams, err := admin.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	member := getDummyUser()
+	if _, err := teams[0].AddOrInviteUserByEmail(ctx, admin, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	loose, err := apiH.sm.NewSession(member.Id, "1.1.1.1", "none", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/team/" + teams[0].Id + "/session_policy"
+	r, err := PutRequest(path, teamSessionPolicyRequest{MaxSessionHours: models.MAX_SESSION_HOURS + 1})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PutRequest(path, teamSessionPolicyRequest{RequireCSRF: true, MaxSessionHours: 8})
+	CheckErrorAndResponse(t, r, err, 200)
+	activeSessionToken = loose.Id
+	r, err = GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 401)
+	strict, err := apiH.sm.NewSession(member.Id, "1.1.1.1", "none", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strict.RequiresCSRF {
+		t.Fatalf("The team policy didn't require csrf for a new session of a member")
+	}
+	activeSessionToken = strict.Id
+	r, err = GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 200)
+	tsp := &models.TeamSessionPolicy{}
+	if err := json.NewDecoder(r.Body).Decode(tsp); err != nil {
+		t.Fatal(err)
+	}
+	if !tsp.RequireCSRF || tsp.MaxSessionHours != 8 || tsp.UpdatedBy != admin.Id {
+		t.Fatalf("Unexpected policy %#v", tsp)
+	}
+	r, err = PutRequest(path, teamSessionPolicyRequest{})
+	CheckErrorAndResponse(t, r, err, 401)
+	activeSessionToken = adminToken
+	r, err = PutRequest(path, teamSessionPolicyRequest{})
+	CheckErrorAndResponse(t, r, err, 200)
+}
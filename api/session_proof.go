@@ -0,0 +1,92 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/keydotcat/keycatd/managers"
+)
+
+const (
+	SESSION_PROOF_HEADER     = "X-Session-Proof"
+	SESSION_TIMESTAMP_HEADER = "X-Session-Timestamp"
+	SESSION_NONCE_HEADER     = "X-Session-Nonce"
+	//SESSION_PROOF_MAX_SKEW is how far the timestamp of a signed request can be from the time of the server
+	SESSION_PROOF_MAX_SKEW = 5 * time.Minute
+)
+
+var reValidSessionNonce = regexp.MustCompile(`^[a-zA-Z0-9_-]{16,64}$`)
+
+// sessionProofMessage is what the device signs for each request. The body is signed by its hex sha256
+func sessionProofMessage(timestamp, method, requestUri, token, nonce, bodyHash string) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s", timestamp, method, requestUri, token, nonce, bodyHash))
+}
+
+// readBodyHash returns the hex sha256 of the body and puts it back so the handlers can still read it. The body is
+// already bounded by the body limit of the route
+func readBodyHash(r *http.Request) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return "", err
+		}
+		r.Body.Close()
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// checkSessionProof makes sure the requests of sessions bound to a device key are signed by it, so a copied token
+// is useless without the device. Each proof has a nonce that is only accepted once while its timestamp is valid so a
+// captured request can't be sent again. The requests of a batch are covered by the proof of the batch
+func (ah apiHandler) checkSessionProof(r *http.Request, s *managers.Session) bool {
+	if len(s.DeviceKey) == 0 || ctxGetBatchProof(r.Context()) == s.Id {
+		return true
+	}
+	if len(s.DeviceKey) != ed25519.PublicKeySize {
+		return false
+	}
+	ts := r.Header.Get(SESSION_TIMESTAMP_HEADER)
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := time.Since(time.Unix(secs, 0))
+	if skew > SESSION_PROOF_MAX_SKEW || skew < -SESSION_PROOF_MAX_SKEW {
+		return false
+	}
+	nonce := r.Header.Get(SESSION_NONCE_HEADER)
+	if !reValidSessionNonce.MatchString(nonce) {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Header.Get(SESSION_PROOF_HEADER))
+	if err != nil {
+		return false
+	}
+	bodyHash, err := readBodyHash(r)
+	if err != nil {
+		return false
+	}
+	if !ed25519.Verify(ed25519.PublicKey(s.DeviceKey), sessionProofMessage(ts, r.Method, r.RequestURI, s.Id, nonce, bodyHash), sig) {
+		return false
+	}
+	//A timestamp is accepted for twice the skew so the nonce is remembered that long. The rate limit manager is shared
+	//by the whole cluster when it's in redis
+	seen, _, err := ah.rateLimits.Hit("session_nonce:"+s.Id+":"+nonce, 2*SESSION_PROOF_MAX_SKEW)
+	if err != nil {
+		requestLogf(r, "[ERROR] Could not check the nonce of the session proof: %s", err)
+		return false
+	}
+	return seen == 1
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// signRequest sets the proof headers of the request for the body with the nonce
+func signRequest(req *http.Request, priv ed25519.PrivateKey, ts time.Time, nonce string, body []byte) {
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	h := sha256.Sum256(body)
+	sig := ed25519.Sign(priv, sessionProofMessage(stamp, req.Method, req.URL.RequestURI(), activeSessionToken, nonce, hex.EncodeToString(h[:])))
+	req.Header.Set(SESSION_TIMESTAMP_HEADER, stamp)
+	req.Header.Set(SESSION_NONCE_HEADER, nonce)
+	req.Header.Set(SESSION_PROOF_HEADER, base64.StdEncoding.EncodeToString(sig))
+}
+
+func signedGetRequest(path string, priv ed25519.PrivateKey, ts time.Time) (*http.Response, error) {
+	req, err := http.NewRequest("GET", srv.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = http.Header{}
+	signRequest(req, priv, ts, util.GenerateRandomToken(22), nil)
+	return httpDo(req)
+}
+
+func TestSessionBoundToDeviceKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := getDummyUser()
+	s, err := apiH.sm.NewSession(u.Id, "1.1.1.1", "none", true, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = s.Id
+	r, err := GetRequest("/auth/session")
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = signedGetRequest("/auth/session", priv, time.Now())
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = signedGetRequest("/auth/session", priv, time.Now().Add(-2*SESSION_PROOF_MAX_SKEW))
+	CheckErrorAndResponse(t, r, err, 401)
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = signedGetRequest("/auth/session", other, time.Now())
+	CheckErrorAndResponse(t, r, err, 401)
+	//The proof covers the path so it can't be replayed against another route
+	req, err := http.NewRequest("GET", srv.URL+"/user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := util.GenerateRandomToken(22)
+	h := sha256.Sum256(nil)
+	req.Header = http.Header{}
+	req.Header.Set(SESSION_TIMESTAMP_HEADER, stamp)
+	req.Header.Set(SESSION_NONCE_HEADER, nonce)
+	req.Header.Set(SESSION_PROOF_HEADER, base64.StdEncoding.EncodeToString(ed25519.Sign(priv, sessionProofMessage(stamp, "GET", "/auth/session", s.Id, nonce, hex.EncodeToString(h[:])))))
+	r, err = httpDo(req)
+	CheckErrorAndResponse(t, r, err, 401)
+	//A nonce is only accepted once
+	for i, code := range []int{200, 401} {
+		req, err = http.NewRequest("GET", srv.URL+"/auth/session", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = http.Header{}
+		signRequest(req, priv, time.Now(), nonce, nil)
+		r, err = httpDo(req)
+		if err != nil || r.StatusCode != code {
+			t.Fatalf("Request %d with the same nonce: expected %d and got %v %v", i, code, r, err)
+		}
+	}
+	//The proof covers the body
+	req, err = http.NewRequest("PUT", srv.URL+"/user", bytes.NewReader([]byte(`{"fullname":"changed"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = http.Header{}
+	signRequest(req, priv, time.Now(), util.GenerateRandomToken(22), []byte(`{"fullname":"signed"}`))
+	r, err = httpDo(req)
+	CheckErrorAndResponse(t, r, err, 401)
+}
+
+func TestLoginRejectsInvalidDeviceKey(t *testing.T) {
+	activeSessionToken = ""
+	u := getDummyUser()
+	r, err := PostRequest("/auth/login", authRequest{Id: u.Id, Password: u.Id, DeviceKey: []byte("short")})
+	CheckErrorAndResponse(t, r, err, 400)
+}
+
+func TestBatchWithSessionBoundToDeviceKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := apiH.sm.NewSession(getDummyUser().Id, "1.1.1.1", "none", true, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = s.Id
+	body, err := json.Marshal(batchRequest{[]batchRequestItem{{Method: "GET", Path: "/auth/session"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", srv.URL+"/batch", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = http.Header{}
+	req.Header.Set("Content-Type", "application/json")
+	signRequest(req, priv, time.Now(), util.GenerateRandomToken(22), body)
+	r, err := httpDo(req)
+	CheckErrorAndResponse(t, r, err, 200)
+	br := &batchResponse{}
+	if err := json.NewDecoder(r.Body).Decode(br); err != nil {
+		t.Fatal(err)
+	}
+	if len(br.Responses) != 1 || br.Responses[0].Status != 200 {
+		t.Errorf("Expected the proof of the batch to cover its requests and got %#v", br.Responses)
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const shutdownPollInterval = 100 * time.Millisecond
+
+type shutdownState struct {
+	done    chan struct{}
+	once    *sync.Once
+	streams *sync.WaitGroup
+}
+
+func newShutdownState() *shutdownState {
+	return &shutdownState{make(chan struct{}), &sync.Once{}, &sync.WaitGroup{}}
+}
+
+func (ah apiHandler) streamOpened(kind string) {
+	ah.shutdown.streams.Add(1)
+	ah.metrics.streamOpened(kind)
+}
+
+func (ah apiHandler) streamClosed(kind string) {
+	ah.metrics.streamClosed(kind)
+	ah.shutdown.streams.Done()
+}
+
+func waitOrDeadline(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown closes the event streams, flushes the mail and webhook queues and closes the db pool.
+// It has to be called once the http server has stopped accepting requests
+func (ah apiHandler) Shutdown(ctx context.Context) error {
+	ah.shutdown.once.Do(func() { close(ah.shutdown.done) })
+	if err := waitOrDeadline(ctx, ah.shutdown.streams.Wait); err != nil {
+		log.Printf("[ERROR] Event streams did not close in time: %s", err)
+	}
+	if ah.mail != nil {
+		for ah.mail.queueDepth() > 0 && ctx.Err() == nil {
+			time.Sleep(shutdownPollInterval)
+		}
+		if pending := ah.mail.queueDepth(); pending > 0 {
+			log.Printf("[ERROR] Shutting down with %d mails still being sent", pending)
+		}
+	}
+	if ah.metricsServer != nil {
+		ah.metricsServer.Shutdown(ctx)
+	}
+	if err := waitOrDeadline(ctx, func() {
+		ah.jobs.Stop()
+		ah.webhooks.Stop()
+		ah.matrix.Stop()
+		ah.audit.Stop()
+		ah.errors.Stop()
+		ah.bcast.Stop()
+		ah.leader.Stop()
+	}); err != nil {
+		log.Printf("[ERROR] Background workers did not stop in time: %s", err)
+	}
+	return ah.db.Close()
+}
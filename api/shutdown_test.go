@@ -0,0 +1,20 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitOrDeadline(t *testing.T) {
+	if err := waitOrDeadline(context.Background(), func() {}); err != nil {
+		t.Fatalf("Expected no error and got %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	block := make(chan struct{})
+	defer close(block)
+	if err := waitOrDeadline(ctx, func() { <-block }); err != context.DeadlineExceeded {
+		t.Fatalf("Expected a deadline error and got %v", err)
+	}
+}
@@ -2,10 +2,17 @@ package api
 
 import (
 	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/keydotcat/keycatd/static"
@@ -13,22 +20,132 @@ import (
 
 //HACK: html/template removes the html comments and since we only require the csrf.. :P
 
+// Bundlers add a hash of the contents to the asset names so those never change and can be cached forever
+var reFingerprintedAsset = regexp.MustCompile(`[.-][0-9a-fA-F]{8,}\.[a-z0-9]+$`)
+
+// DEFAULT_CSP only lets the web client load code and data from the server
+const DEFAULT_CSP = "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self' data:; connect-src 'self'; font-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+var (
+	reIndexAssetTag = regexp.MustCompile(`<(?:script|link)\b[^>]*>`)
+	reAssetUrl      = regexp.MustCompile(`\b(?:src|href)="([^"]+)"`)
+	reAssetRel      = regexp.MustCompile(`\brel="(?:stylesheet|modulepreload)"`)
+)
+
 type StaticHandler struct {
 	Dir         string
 	IndexFile   string
+	BasePath    string
 	cacheStatic bool
+	disabled    bool
+	//webDir serves the web client from disk instead of the embedded assets
+	webDir string
+	csp    string
+	//sri keeps the integrity hash of the assets referenced by the index
+	sri     map[string]sriHash
+	sriLock *sync.Mutex
+}
+
+type sriHash struct {
+	modTime time.Time
+	hash    string
 }
 
-func NewStaticHandler() *StaticHandler {
+func NewStaticHandler(c ConfWeb) *StaticHandler {
+	csp := c.CSP
+	if len(csp) == 0 {
+		csp = DEFAULT_CSP
+	}
 	return &StaticHandler{
-		Dir:         "web",
-		IndexFile:   "index.html",
-		cacheStatic: true,
+		Dir:       "web",
+		IndexFile: "index.html",
+		//Embedded assets only change with the binary. The ones in a directory can be replaced at any time
+		cacheStatic: len(c.Dir) == 0,
+		disabled:    c.Disabled,
+		webDir:      c.Dir,
+		csp:         csp,
+		sri:         map[string]sriHash{},
+		sriLock:     &sync.Mutex{},
+	}
+}
+
+func (s *StaticHandler) asset(file string) ([]byte, os.FileInfo, error) {
+	if len(s.webDir) == 0 {
+		filePath := fmt.Sprintf("%s/%s", s.Dir, file)
+		finfo, err := static.AssetInfo(filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := static.Asset(filePath)
+		return data, finfo, err
+	}
+	filePath := filepath.Join(s.webDir, filepath.FromSlash(path.Clean("/"+file)))
+	finfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, nil, err
 	}
+	if finfo.IsDir() {
+		return nil, nil, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(filePath)
+	return data, finfo, err
+}
+
+// integrity returns the subresource integrity hash of an asset. Hashes are kept until the asset changes
+func (s *StaticHandler) integrity(file string) (string, bool) {
+	data, finfo, err := s.asset(file)
+	if err != nil {
+		return "", false
+	}
+	s.sriLock.Lock()
+	defer s.sriLock.Unlock()
+	if h, ok := s.sri[file]; ok && h.modTime.Equal(finfo.ModTime()) {
+		return h.hash, true
+	}
+	sum := sha512.Sum384(data)
+	h := sriHash{finfo.ModTime(), "sha384-" + base64.StdEncoding.EncodeToString(sum[:])}
+	s.sri[file] = h
+	return h.hash, true
+}
+
+// addIntegrity adds the integrity attribute to the scripts and stylesheets of the index that are served by keycatd, so
+// the browser refuses them if something between the server and the browser changes them
+func (s *StaticHandler) addIntegrity(index []byte) []byte {
+	return reIndexAssetTag.ReplaceAllFunc(index, func(tag []byte) []byte {
+		if bytes.Contains(tag, []byte("integrity=")) || (bytes.HasPrefix(tag, []byte("<link")) && !reAssetRel.Match(tag)) {
+			return tag
+		}
+		m := reAssetUrl.FindSubmatch(tag)
+		if m == nil {
+			return tag
+		}
+		ref := string(m[1])
+		if strings.Contains(ref, "//") || strings.HasPrefix(ref, "data:") {
+			return tag
+		}
+		if i := strings.IndexAny(ref, "?#"); i > -1 {
+			ref = ref[:i]
+		}
+		if strings.HasPrefix(ref, "/") {
+			ref = strings.TrimPrefix(ref, s.BasePath)
+		}
+		hash, ok := s.integrity(strings.TrimLeft(path.Clean("/"+ref), "/"))
+		if !ok {
+			return tag
+		}
+		end := len(tag) - 1
+		if bytes.HasSuffix(tag, []byte("/>")) {
+			end--
+		}
+		out := make([]byte, 0, len(tag)+len(hash)+13)
+		out = append(out, bytes.TrimRight(tag[:end], " ")...)
+		out = append(out, fmt.Sprintf(` integrity="%s"`, hash)...)
+		return append(out, tag[end:]...)
+	})
 }
 
 func (s *StaticHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" && r.Method != "HEAD" {
+	if s.disabled || (r.Method != "GET" && r.Method != "HEAD") {
 		http.NotFound(rw, r)
 		return
 	}
@@ -38,25 +155,45 @@ func (s *StaticHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 	file = strings.TrimLeft(file, "/")
-	cacheResource := s.cacheStatic
-	if len(file) == 0 || file == s.IndexFile || len(filepath.Ext(file)) == 0 {
+	//Paths without an extension are routes of the web client that it resolves itself with the history api
+	isIndex := len(file) == 0 || file == s.IndexFile || len(filepath.Ext(file)) == 0
+	if isIndex {
 		file = s.IndexFile
-		cacheResource = false
 	}
-	filePath := fmt.Sprintf("%s/%s", s.Dir, file)
-	finfo, err := static.AssetInfo(filePath)
+	data, finfo, err := s.asset(file)
 	if err != nil {
 		http.NotFound(rw, r)
 		return
 	}
-	data, err := static.Asset(filePath)
-	if err != nil {
-		http.NotFound(rw, r)
-		return
+	if isIndex {
+		data = s.addIntegrity(data)
+		rw.Header().Set("Content-Security-Policy", s.csp)
+	} else if acceptsEncoding(r, "br") {
+		//Assets can be shipped already compressed with brotli next to the original one
+		if br, _, err := s.asset(file + ".br"); err == nil {
+			rw.Header().Set("Content-Encoding", "br")
+			rw.Header().Add("Vary", "Accept-Encoding")
+			data = br
+		}
 	}
-	if cacheResource {
+	switch {
+	case isIndex:
+		//The index points to the current assets so it's always revalidated
+		rw.Header().Set("Cache-Control", "no-cache")
+	case reFingerprintedAsset.MatchString(file):
+		rw.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	case s.cacheStatic:
 		rw.Header().Add("Cache-Control", "public, max-age=31536000")
 		rw.Header().Add("Expires", time.Now().Add(30*24*time.Hour).Format(time.RFC1123))
+	default:
+		rw.Header().Set("Cache-Control", "no-cache")
+	}
+	modTime := finfo.ModTime()
+	if isIndex {
+		//The index changes with the hashes of the assets even if the file doesn't
+		sum := sha512.Sum384(data)
+		rw.Header().Set("ETag", fmt.Sprintf(`"%s"`, base64.RawURLEncoding.EncodeToString(sum[:16])))
+		modTime = time.Time{}
 	}
-	http.ServeContent(rw, r, file, finfo.ModTime(), bytes.NewReader(data))
+	http.ServeContent(rw, r, file, modTime, bytes.NewReader(data))
 }
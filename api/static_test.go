@@ -0,0 +1,129 @@
+package api
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticHandlerDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":              "<html></html>",
+		"logo.png":                "png",
+		"js/app.3f2a9c1b7d.js":    "app",
+		"js/app.3f2a9c1b7d.js.br": "br",
+	}
+	for name, data := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sh := NewStaticHandler(ConfWeb{Dir: dir})
+	checks := []struct {
+		path  string
+		code  int
+		body  string
+		cache string
+	}{
+		{"/", 200, "<html></html>", "no-cache"},
+		{"/team/t1/vault", 200, "<html></html>", "no-cache"},
+		{"/logo.png", 200, "png", "no-cache"},
+		{"/js/app.3f2a9c1b7d.js", 200, "app", "public, max-age=31536000, immutable"},
+		{"/missing.js", 404, "", ""},
+		{"/js", 200, "<html></html>", "no-cache"},
+		{"/../conf.go", 404, "", ""},
+	}
+	for _, c := range checks {
+		rec := httptest.NewRecorder()
+		sh.ServeHTTP(rec, httptest.NewRequest("GET", c.path, nil))
+		if rec.Code != c.code {
+			t.Errorf("%s: expected %d and got %d", c.path, c.code, rec.Code)
+			continue
+		}
+		if c.code != 200 {
+			continue
+		}
+		if rec.Body.String() != c.body || rec.Header().Get("Cache-Control") != c.cache {
+			t.Errorf("%s: unexpected response %q with cache %q", c.path, rec.Body.String(), rec.Header().Get("Cache-Control"))
+		}
+	}
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/js/app.3f2a9c1b7d.js", nil)
+	r.Header.Set("Accept-Encoding", "gzip, br")
+	sh.ServeHTTP(rec, r)
+	if rec.Body.String() != "br" || rec.Header().Get("Content-Encoding") != "br" {
+		t.Errorf("Expected the brotli asset and got %q", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	NewStaticHandler(ConfWeb{Dir: dir, Disabled: true}).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != 404 {
+		t.Errorf("Expected a disabled web client to not be served and got %d", rec.Code)
+	}
+}
+
+func TestStaticHandlerIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	index := `<html><head><link rel="stylesheet" href="/keycat/css/app.css"><link rel="icon" href="/favicon.ico">` +
+		`<script src="https://cdn.example.com/lib.js"></script><script src="js/app.js?v=1"></script>` +
+		`<script src="js/pinned.js" integrity="sha384-pinned"></script></head></html>`
+	files := map[string]string{
+		"index.html":   index,
+		"favicon.ico":  "ico",
+		"css/app.css":  "body{}",
+		"js/app.js":    "app",
+		"js/pinned.js": "pinned",
+	}
+	for name, data := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sri := func(data string) string {
+		sum := sha512.Sum384([]byte(data))
+		return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+	sh := NewStaticHandler(ConfWeb{Dir: dir})
+	sh.BasePath = "/keycat"
+	rec := httptest.NewRecorder()
+	sh.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	body := rec.Body.String()
+	expected := []string{
+		`<link rel="stylesheet" href="/keycat/css/app.css" integrity="` + sri("body{}") + `">`,
+		`<link rel="icon" href="/favicon.ico">`,
+		`<script src="https://cdn.example.com/lib.js"></script>`,
+		`<script src="js/app.js?v=1" integrity="` + sri("app") + `"></script>`,
+		`<script src="js/pinned.js" integrity="sha384-pinned"></script>`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("Expected %s in the index %s", e, body)
+		}
+	}
+	if csp := rec.Header().Get("Content-Security-Policy"); csp != DEFAULT_CSP {
+		t.Errorf("Unexpected csp %q", csp)
+	}
+	//Changing an asset changes the hash in the index
+	if err := ioutil.WriteFile(filepath.Join(dir, "js/app.js"), []byte("new app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filepath.Join(dir, "js/app.js"), time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	rec = httptest.NewRecorder()
+	sh.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rec.Body.String(), sri("new app")) {
+		t.Errorf("The index still has the hash of the old asset %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	NewStaticHandler(ConfWeb{Dir: dir, CSP: "default-src 'none'"}).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if csp := rec.Header().Get("Content-Security-Policy"); csp != "default-src 'none'" {
+		t.Errorf("The csp has not been overridden: %q", csp)
+	}
+}
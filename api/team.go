@@ -2,6 +2,8 @@ package api
 
 import (
 	"net/http"
+	"sort"
+	"strconv"
 
 	"github.com/keydotcat/keycatd/models"
 	"github.com/keydotcat/keycatd/util"
@@ -30,18 +32,37 @@ func (ah apiHandler) teamRoot(w http.ResponseWriter, r *http.Request) error {
 }
 
 type teamGetAllResponse struct {
-	Teams []*models.Team `json:"teams"`
+	Teams      []*models.Team `json:"teams"`
+	NextCursor string         `json:"next_cursor,omitempty"`
 }
 
-// GET /team
+var teamListSpec = listSpec{
+	id:          func(i interface{}) string { return i.(*models.Team).Id },
+	defaultSort: "name",
+	sorts: map[string]listField{
+		"name":       func(i interface{}) string { return i.(*models.Team).Name },
+		"created_at": func(i interface{}) string { return listTime(i.(*models.Team).CreatedAt) },
+	},
+	filters: map[string]listField{
+		"owner":   func(i interface{}) string { return i.(*models.Team).Owner },
+		"primary": func(i interface{}) string { return strconv.FormatBool(i.(*models.Team).Primary) },
+	},
+}
+
+// GET /team?sort=name&owner=&primary=
 func (ah apiHandler) teamGetAll(w http.ResponseWriter, r *http.Request) error {
+	lq, err := parseListQuery(r, teamListSpec)
+	if err != nil {
+		return err
+	}
 	ctx := r.Context()
 	currentUser := ctxGetUser(ctx)
 	teams, err := currentUser.GetTeams(ctx)
 	if err != nil {
 		return err
 	}
-	return jsonResponse(w, teamGetAllResponse{teams})
+	next := lq.apply(&teams)
+	return jsonResponse(w, teamGetAllResponse{teams, next})
 }
 
 type teamCreateRequest struct {
@@ -61,6 +82,7 @@ func (ah apiHandler) teamCreate(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	ah.auditLog(r, AUDIT_TEAM_CREATE, auditObject("team", team.Id))
 	tf, err := team.GetTeamFull(ctx, currentUser)
 	if err != nil {
 		return err
@@ -79,27 +101,73 @@ func (ah apiHandler) validTeamRoot(w http.ResponseWriter, r *http.Request, t *mo
 			return util.NewErrorFrom(ErrNotFound)
 		}
 	} else {
+		//Auditors only read the team and its audit log
+		if head == "vault" || head == "secret" || r.Method != "GET" {
+			if err := checkNotAuditor(r, t); err != nil {
+				return err
+			}
+		}
 		switch head {
+		case "audit":
+			return ah.teamAuditRoot(w, r, t)
 		case "user":
 			return ah.validTeamUserRoot(w, r, t)
 		case "vault":
 			return ah.vaultRoot(w, r, t)
 		case "secret":
 			return ah.teamSecretRoot(w, r, t)
+		case "label":
+			return ah.labelRoot(w, r, t)
+		case "webhook":
+			if ah.featureEnabled(FEATURE_WEBHOOKS, t.Id) {
+				return ah.webhookRoot(w, r, t)
+			}
+		case "matrix":
+			if ah.featureEnabled(FEATURE_MATRIX, t.Id) {
+				return ah.matrixRoot(w, r, t)
+			}
+		case "health":
+			return ah.teamHealthRoot(w, r, t)
+		case "session_policy":
+			return ah.teamSessionPolicyRoot(w, r, t)
+		case "pending":
+			return ah.teamPendingAccessRoot(w, r, t)
+		case "features":
+			if r.Method == "GET" {
+				return ah.teamFeatures(w, r, t)
+			}
 		}
 	}
 	return util.NewErrorFrom(ErrNotFound)
 }
 
+// teamFull returns the team as the user sees it in a stable order so its ETag only changes when the team does
+func teamFull(r *http.Request, t *models.Team) (*models.TeamFull, error) {
+	tf, err := t.GetTeamFull(r.Context(), ctxGetUser(r.Context()))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tf.Vaults, func(i, j int) bool { return tf.Vaults[i].Id < tf.Vaults[j].Id })
+	for _, vf := range tf.Vaults {
+		sort.Strings(vf.Users)
+	}
+	sort.Slice(tf.Users, func(i, j int) bool { return tf.Users[i].User < tf.Users[j].User })
+	sort.Slice(tf.Invites, func(i, j int) bool { return tf.Invites[i].Email < tf.Invites[j].Email })
+	return tf, nil
+}
+
+// checkTeamIfMatch checks the If-Match of a change to the team against the team the user sees
+func checkTeamIfMatch(r *http.Request, t *models.Team) error {
+	return checkIfMatch(r, func() (interface{}, error) { return teamFull(r, t) })
+}
+
 // GET /team/:tid
 func (ah apiHandler) teamGetInfo(w http.ResponseWriter, r *http.Request, t *models.Team) error {
-	ctx := r.Context()
-	currentUser := ctxGetUser(ctx)
-	tf, err := t.GetTeamFull(ctx, currentUser)
+	tf, err := teamFull(r, t)
 	if err != nil {
 		return err
 	}
-	return jsonResponse(w, tf)
+	return jsonResponseWithETag(w, r, tf)
 }
 
 func (ah apiHandler) validTeamUserRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
@@ -110,6 +178,13 @@ func (ah apiHandler) validTeamUserRoot(w http.ResponseWriter, r *http.Request, t
 		case "POST":
 			return ah.teamInviteUser(w, r, t)
 		}
+	} else if sub, _ := shiftPath(r.URL.Path); sub == "auditor" {
+		switch r.Method {
+		case "PUT":
+			return ah.teamSetAuditor(w, r, t, head, true)
+		case "DELETE":
+			return ah.teamSetAuditor(w, r, t, head, false)
+		}
 	} else {
 		switch r.Method {
 		case "PATCH":
@@ -121,6 +196,7 @@ func (ah apiHandler) validTeamUserRoot(w http.ResponseWriter, r *http.Request, t
 
 type teamInviteUserRequest struct {
 	Invite string `json:"invite"`
+	models.InviteAssignment
 }
 
 // POST /team/:tid/user
@@ -128,16 +204,20 @@ func (ah apiHandler) teamInviteUser(w http.ResponseWriter, r *http.Request, t *m
 	ctx := r.Context()
 	u := ctxGetUser(ctx)
 	tcr := &teamInviteUserRequest{}
-	if err := jsonDecode(w, r, 1024, tcr); err != nil {
+	if err := jsonDecode(w, r, 8192, tcr); err != nil {
+		return err
+	}
+	if err := checkTeamIfMatch(r, t); err != nil {
 		return err
 	}
-	invite, err := t.AddOrInviteUserByEmail(ctx, u, tcr.Invite)
+	invite, err := t.AddOrInviteUserWithAssignment(ctx, u, tcr.Invite, tcr.InviteAssignment)
 	if err != nil && !util.CheckErr(err, models.ErrAlreadyInvited) {
 		return err
 	}
+	ah.auditLog(r, AUDIT_TEAM_INVITE, auditObject("team", t.Id, "email", tcr.Invite))
 	if invite != nil {
 		if err := ah.mail.sendInvitationMail(t, u, invite, r.Header.Get("X-Locale")); err != nil {
-			panic(err)
+			return internalErr(err)
 		}
 	}
 	tf, err := t.GetTeamFull(ctx, u)
@@ -163,20 +243,26 @@ func (ah apiHandler) teamModifyUser(w http.ResponseWriter, r *http.Request, t *m
 	if err := jsonDecode(w, r, 2048, tiur); err != nil {
 		return err
 	}
+	if err := checkTeamIfMatch(r, t); err != nil {
+		return err
+	}
 	ctx := r.Context()
 	admin := ctxGetUser(ctx)
 	u, err := models.FindUser(ctx, uid)
 	if err != nil {
 		return err
 	}
+	action := AUDIT_TEAM_USER_PROMOTE
 	if tiur.Admin {
 		err = t.PromoteUser(ctx, admin, u, models.VaultKeyPair{Keys: tiur.Keys})
 	} else {
+		action = AUDIT_TEAM_USER_DEMOTE
 		err = t.DemoteUser(ctx, admin, u)
 	}
 	if err != nil {
 		return err
 	}
+	ah.auditLog(r, action, auditObject("team", t.Id, "user", u.Id))
 	tuf, err := t.GetUsersAfiliationFull(ctx)
 	if err != nil {
 		return err
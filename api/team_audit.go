@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+type teamAuditListResponse struct {
+	Entries    []*models.AuditEntry `json:"entries"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+type teamAuditVaultListResponse struct {
+	Vaults []*models.VaultMetadata `json:"vaults"`
+}
+
+// checkNotAuditor keeps the auditors of the team away from the vaults, the secrets and any change. They can't get a
+// vault key anyway but this way a bug in a vault endpoint can't hand one out
+func checkNotAuditor(r *http.Request, t *models.Team) error {
+	auditor, err := t.CheckAuditor(r.Context(), ctxGetUser(r.Context()))
+	if err != nil {
+		return err
+	}
+	if auditor {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	return nil
+}
+
+// checkTeamAuditor only lets the admins and the auditors of the team through
+func checkTeamAuditor(r *http.Request, t *models.Team) error {
+	u := ctxGetUser(r.Context())
+	if isAdmin, err := t.CheckAdmin(r.Context(), u); err != nil || isAdmin {
+		return err
+	}
+	auditor, err := t.CheckAuditor(r.Context(), u)
+	if err != nil {
+		return err
+	}
+	if !auditor {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	return nil
+}
+
+// /team/:tid/audit
+func (ah apiHandler) teamAuditRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if r.Method != "GET" || r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	if err := checkTeamAuditor(r, t); err != nil {
+		return err
+	}
+	switch head {
+	case "":
+		return ah.teamAuditList(w, r, t)
+	case "vault":
+		return ah.teamAuditVaults(w, r, t)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /team/:tid/audit?from=&to=&actor=&action=&object=
+func (ah apiHandler) teamAuditList(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	lq, err := parseListQuery(r, auditListSpec)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	from, err := queryTime(r, "from", now.Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+	to, err := queryTime(r, "to", now)
+	if err != nil {
+		return err
+	}
+	if !from.Before(to) {
+		return util.NewErrorf("from has to be before to")
+	}
+	return lq.stream(w, r, "entries", func(fn func(interface{}) error) error {
+		return models.ForEachAuditEntryUnder(r.Context(), auditObject("team", t.Id), from, to, func(ae *models.AuditEntry) error {
+			return fn(ae)
+		})
+	})
+}
+
+// GET /team/:tid/audit/vault
+func (ah apiHandler) teamAuditVaults(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	vms, err := t.GetVaultsMetadata(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, teamAuditVaultListResponse{vms})
+}
+
+// PUT /team/:tid/user/:uid/auditor
+// DELETE /team/:tid/user/:uid/auditor
+func (ah apiHandler) teamSetAuditor(w http.ResponseWriter, r *http.Request, t *models.Team, uid string, auditor bool) error {
+	if err := checkTeamIfMatch(r, t); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	if err := t.SetAuditor(ctx, ctxGetUser(ctx), uid, auditor); err != nil {
+		return err
+	}
+	action := AUDIT_TEAM_AUDITOR_ADD
+	if !auditor {
+		action = AUDIT_TEAM_AUDITOR_REMOVE
+	}
+	ah.auditLog(r, action, auditObject("team", t.Id, "user", uid))
+	tuf, err := t.GetUsersAfiliationFull(ctx)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, teamModifyUserResponse{t.Id, tuf})
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestTeamAuditor(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	member := getDummyUser()
+	r, err := PostRequest(fmt.Sprintf("/team/%s/user", team.Id), teamInviteUserRequest{Invite: member.Email})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PutRequest(fmt.Sprintf("/team/%s/user/%s/auditor", team.Id, u.Id), nil)
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PutRequest(fmt.Sprintf("/team/%s/user/%s/auditor", team.Id, member.Id), nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	tmr := &teamModifyUserResponse{}
+	if err := json.NewDecoder(r.Body).Decode(tmr); err != nil {
+		t.Fatal(err)
+	}
+	for _, tuf := range tmr.Users {
+		if tuf.User == member.Id && !tuf.Auditor {
+			t.Errorf("Expected %s to be an auditor in %#v", member.Id, tuf)
+		}
+	}
+	ms, err := apiH.sm.NewSession(member.Id, "1.1.1.1", "none", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = ms.Id
+	r, err = GetRequest(fmt.Sprintf("/team/%s/audit", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	tal := &teamAuditListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(tal); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, ae := range tal.Entries {
+		found = found || (ae.Action == AUDIT_TEAM_AUDITOR_ADD && ae.Actor == u.Id)
+	}
+	if !found {
+		t.Errorf("Could not find the new auditor in the audit log of the team %#v", tal.Entries)
+	}
+	r, err = GetRequest(fmt.Sprintf("/team/%s/audit/vault", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	tavl := &teamAuditVaultListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(tavl); err != nil {
+		t.Fatal(err)
+	}
+	if len(tavl.Vaults) == 0 || len(tavl.Vaults[0].Users) == 0 {
+		t.Errorf("Expected the vaults of the team with their members and got %#v", tavl.Vaults)
+	}
+	vid := tavl.Vaults[0].Id
+	for _, path := range []string{"/vault", "/vault/" + vid, "/vault/" + vid + "/secret", "/secret"} {
+		r, err = GetRequest(fmt.Sprintf("/team/%s%s", team.Id, path))
+		CheckErrorAndResponse(t, r, err, 401)
+	}
+	r, err = PostRequest(fmt.Sprintf("/team/%s/user", team.Id), teamInviteUserRequest{Invite: getDummyUser().Email})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = GetRequest(fmt.Sprintf("/team/%s", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	us, err := apiH.sm.NewSession(u.Id, "1.1.1.1", "none", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = us.Id
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/user/%s/auditor", team.Id, member.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	activeSessionToken = ms.Id
+	r, err = GetRequest(fmt.Sprintf("/team/%s/audit", team.Id))
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = GetRequest(fmt.Sprintf("/team/%s/vault", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+type teamPendingAccessListResponse struct {
+	Pending []*models.PendingAccess `json:"pending"`
+}
+
+type teamPendingAccessRequest struct {
+	Keys map[string][]byte `json:"keys"`
+}
+
+// /team/:tid/pending
+func (ah apiHandler) teamPendingAccessRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	var uid string
+	uid, r.URL.Path = shiftPath(r.URL.Path)
+	if r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	if err := checkTeamAdmin(r, t); err != nil {
+		return err
+	}
+	switch {
+	case len(uid) == 0 && r.Method == "GET":
+		return ah.teamPendingAccessList(w, r, t)
+	case len(uid) > 0 && r.Method == "POST":
+		return ah.teamPendingAccessComplete(w, r, t, uid)
+	case len(uid) > 0 && r.Method == "DELETE":
+		return ah.teamPendingAccessDelete(w, r, t, uid)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /team/:tid/pending
+func (ah apiHandler) teamPendingAccessList(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	pas, err := t.GetPendingAccesses(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, teamPendingAccessListResponse{pas})
+}
+
+// POST /team/:tid/pending/:uid
+func (ah apiHandler) teamPendingAccessComplete(w http.ResponseWriter, r *http.Request, t *models.Team, uid string) error {
+	tpar := &teamPendingAccessRequest{}
+	if err := jsonDecode(w, r, 81920, tpar); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	added, requested, err := t.CompletePendingAccess(ctx, ctxGetUser(ctx), uid, models.VaultKeyPair{Keys: tpar.Keys})
+	if err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_TEAM_PENDING_COMPLETE, auditObject("team", t.Id, "user", uid))
+	for _, vid := range added {
+		ah.auditLog(r, AUDIT_VAULT_USER_ADD, auditObject("team", t.Id, "vault", vid, "user", uid))
+	}
+	for _, vid := range requested {
+		ah.auditLog(r, AUDIT_VAULT_GRANT_REQUEST, auditObject("team", t.Id, "vault", vid, "user", uid))
+	}
+	tuf, err := t.GetUsersAfiliationFull(ctx)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, teamModifyUserResponse{t.Id, tuf})
+}
+
+// DELETE /team/:tid/pending/:uid
+func (ah apiHandler) teamPendingAccessDelete(w http.ResponseWriter, r *http.Request, t *models.Team, uid string) error {
+	ctx := r.Context()
+	if err := t.DeletePendingAccess(ctx, ctxGetUser(ctx), uid); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_TEAM_PENDING_DELETE, auditObject("team", t.Id, "user", uid))
+	return ah.teamPendingAccessList(w, r, t)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestInviteWithPendingAccess(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vfs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vfs[0]
+	uid := util.GenerateRandomToken(5)
+	email := uid + "@nowhere.net"
+	tiur := teamInviteUserRequest{Invite: email, InviteAssignment: models.InviteAssignment{Message: "Welcome", Role: "boss"}}
+	r, err := PostRequest(fmt.Sprintf("/team/%s/user", team.Id), tiur)
+	CheckErrorAndResponse(t, r, err, 400)
+	tiur.Role, tiur.Vaults = models.TEAM_ROLE_MEMBER, []string{v.Id}
+	r, err = PostRequest(fmt.Sprintf("/team/%s/user", team.Id), tiur)
+	CheckErrorAndResponse(t, r, err, 200)
+	session := activeSessionToken
+	activeSessionToken = ""
+	_, priv, fullpack := generateNewKeys()
+	vkp := getDummyVaultKeyPair(priv, uid)
+	r, err = PostRequest("/auth/register", authRegisterRequest{uid, email, "Invited", "pass", fullpack, vkp.PublicKey, vkp.Keys[uid], "", ""})
+	CheckErrorAndResponse(t, r, err, 200)
+	arr := &authRegisterResponse{}
+	if err := json.NewDecoder(r.Body).Decode(arr); err != nil {
+		t.Fatal(err)
+	}
+	if len(arr.Invites) != 1 || arr.Invites[0].Team != team.Id || arr.Invites[0].Message != "Welcome" || arr.Invites[0].InvitedBy != u.Id || len(arr.Invites[0].Vaults) != 1 {
+		t.Fatalf("Expected the invitee to see the invite and got %#v", arr.Invites)
+	}
+	activeSessionToken = session
+	path := fmt.Sprintf("/team/%s/pending", team.Id)
+	r, err = GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 200)
+	tpal := &teamPendingAccessListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(tpal); err != nil {
+		t.Fatal(err)
+	}
+	if len(tpal.Pending) != 1 || tpal.Pending[0].User != uid || tpal.Pending[0].Vaults[0] != v.Id {
+		t.Fatalf("Unexpected pending accesses %#v", tpal.Pending)
+	}
+	r, err = PostRequest(path+"/"+uid, teamPendingAccessRequest{map[string][]byte{v.Id: v.Key}})
+	CheckErrorAndResponse(t, r, err, 200)
+	invitee, err := models.FindUser(ctx, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := team.GetVaultForUser(ctx, v.Id, invitee); err != nil {
+		t.Errorf("The invitee has no access to the vault: %v", err)
+	}
+	r, err = DeleteRequest(path + "/" + uid)
+	CheckErrorAndResponse(t, r, err, 404)
+	ms, err := apiH.sm.NewSession(uid, "1.1.1.1", "none", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = ms.Id
+	r, err = GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 401)
+}
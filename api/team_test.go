@@ -1,7 +1,9 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"net/http"
 	"testing"
 
 	"github.com/keydotcat/keycatd/models"
@@ -43,3 +45,47 @@ func TestGetAllTeams(t *testing.T) {
 		t.Fatalf("Unexpected number of teams: %d vs %d", len(teams)+1, len(sga.Teams))
 	}
 }
+
+func TestTeamETag(t *testing.T) {
+	u := loginDummyUser()
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := GetRequest("/team/" + teams[0].Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	etag := r.Header.Get("ETag")
+	if len(etag) == 0 {
+		t.Fatal("Missing ETag")
+	}
+	req, err := http.NewRequest("GET", srv.URL+"/team/"+teams[0].Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", etag)
+	r, err = httpDo(req)
+	CheckErrorAndResponse(t, r, err, 304)
+	body, err := json.Marshal(teamInviteUserRequest{Invite: util.GenerateRandomToken(5) + "@nowhere.net"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest("POST", srv.URL+"/team/"+teams[0].Id+"/user", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", `"stale"`)
+	r, err = httpDo(req)
+	CheckErrorAndResponse(t, r, err, 412)
+	req, err = http.NewRequest("POST", srv.URL+"/team/"+teams[0].Id+"/user", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", etag)
+	r, err = httpDo(req)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/team/" + teams[0].Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	if r.Header.Get("ETag") == etag {
+		t.Errorf("The ETag didn't change with a new invite")
+	}
+}
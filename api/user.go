@@ -9,13 +9,19 @@ import (
 func (ah apiHandler) userRoot(w http.ResponseWriter, r *http.Request) error {
 	var head string
 	head, r.URL.Path = shiftPath(r.URL.Path)
-	if len(head) == 0 {
-		switch r.Method {
-		case "GET":
-			return ah.userGetInfo(w, r)
-		case "PUT", "PATCH":
-			return ah.userUpdate(w, r)
-		}
+	switch {
+	case len(head) == 0 && r.Method == "GET":
+		return ah.userGetInfo(w, r)
+	case len(head) == 0 && (r.Method == "PUT" || r.Method == "PATCH"):
+		return ah.userUpdate(w, r)
+	case head == "profile" && r.URL.Path == "/" && r.Method == "PUT":
+		return ah.userUpdateProfile(w, r)
+	case head == "preferences" && r.URL.Path == "/" && r.Method == "GET":
+		return ah.userGetPreferences(w, r)
+	case head == "preferences" && r.URL.Path == "/" && r.Method == "PUT":
+		return ah.userSetPreferences(w, r)
+	case head == "avatar":
+		return ah.userAvatarRoot(w, r)
 	}
 	return util.NewErrorFrom(ErrNotFound)
 }
@@ -49,8 +55,10 @@ func (ah apiHandler) userUpdate(w http.ResponseWriter, r *http.Request) error {
 		if err != nil {
 			return err
 		}
+		ah.userChanged(u.Id)
+		ah.auditLog(r, AUDIT_USER_EMAIL_CHANGE, auditObject("user", u.Id))
 		if err := ah.mail.sendConfirmationMail(u, t, r.Header.Get("X-Locale")); err != nil {
-			panic(err)
+			return internalErr(err)
 		}
 		w.WriteHeader(http.StatusOK)
 		return nil
@@ -60,6 +68,8 @@ func (ah apiHandler) userUpdate(w http.ResponseWriter, r *http.Request) error {
 		if err != nil {
 			return err
 		}
+		ah.userChanged(u.Id)
+		ah.auditLog(r, AUDIT_USER_PASSWORD, auditObject("user", u.Id))
 		w.WriteHeader(http.StatusOK)
 		return nil
 	}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// userCacheSweepInterval is how often the expired users are dropped from the cache
+const userCacheSweepInterval = time.Minute
+
+type userCacheEntry struct {
+	u       *models.User
+	expires time.Time
+}
+
+// userCacheLoad is a load of a user from the db in progress. Concurrent requests for the same user wait for it
+type userCacheLoad struct {
+	done chan struct{}
+	u    *models.User
+	err  error
+}
+
+// userCache keeps the users of the authorized GET requests for a few seconds so polling clients don't load them on every request.
+// Each instance has its own so changes done through another instance show up once the entry expires
+type userCache struct {
+	ttl       time.Duration
+	lock      *sync.Mutex
+	entries   map[string]userCacheEntry
+	loads     map[string]*userCacheLoad
+	lastSweep time.Time
+}
+
+func newUserCache(ttl time.Duration) *userCache {
+	return &userCache{ttl, &sync.Mutex{}, map[string]userCacheEntry{}, map[string]*userCacheLoad{}, time.Now()}
+}
+
+// get returns a copy of the cached user so handlers can modify it. Without a ttl it always loads it from the db
+func (uc *userCache) get(ctx context.Context, id string) (*models.User, error) {
+	if uc.ttl == 0 {
+		return models.FindUser(ctx, id)
+	}
+	uc.lock.Lock()
+	if e, ok := uc.entries[id]; ok && time.Now().Before(e.expires) {
+		uc.lock.Unlock()
+		return copyUser(e.u), nil
+	}
+	l, loading := uc.loads[id]
+	if loading {
+		uc.lock.Unlock()
+		<-l.done
+	} else {
+		l = &userCacheLoad{done: make(chan struct{})}
+		uc.loads[id] = l
+		uc.lock.Unlock()
+		uc.load(ctx, id, l)
+	}
+	if l.err != nil {
+		return nil, l.err
+	}
+	return copyUser(l.u), nil
+}
+
+// load loads the user and wakes up the waiters even if the load panics. They get the panic as an error
+// while the request that loaded it panics again so it's handled like any other panic
+func (uc *userCache) load(ctx context.Context, id string, l *userCacheLoad) {
+	defer func() {
+		rec := recover()
+		if rec != nil {
+			l.u, l.err = nil, util.NewErrorf("Could not load user %s: %v", id, rec)
+		}
+		uc.store(id, l)
+		close(l.done)
+		if rec != nil {
+			panic(rec)
+		}
+	}()
+	l.u, l.err = models.FindUser(ctx, id)
+}
+
+// store keeps the loaded user unless it has been forgotten while it was being loaded
+func (uc *userCache) store(id string, l *userCacheLoad) {
+	uc.lock.Lock()
+	defer uc.lock.Unlock()
+	if uc.loads[id] != l {
+		return
+	}
+	delete(uc.loads, id)
+	now := time.Now()
+	if l.err == nil {
+		uc.entries[id] = userCacheEntry{l.u, now.Add(uc.ttl)}
+	}
+	if now.Sub(uc.lastSweep) > userCacheSweepInterval {
+		for eid, e := range uc.entries {
+			if now.After(e.expires) {
+				delete(uc.entries, eid)
+			}
+		}
+		uc.lastSweep = now
+	}
+}
+
+// forget drops the user so the next request loads it again
+func (uc *userCache) forget(id string) {
+	uc.lock.Lock()
+	defer uc.lock.Unlock()
+	delete(uc.entries, id)
+	delete(uc.loads, id)
+}
+
+func copyUser(u *models.User) *models.User {
+	cp := *u
+	return &cp
+}
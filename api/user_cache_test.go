@@ -0,0 +1,49 @@
+package api
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserCache(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	uc := newUserCache(time.Minute)
+	cached, err := uc.get(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.FullName = "changed by a handler"
+	if err := u.SetAdmin(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cu, err := uc.get(ctx, u.Id)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if cu.Admin || cu.FullName != u.FullName {
+				t.Errorf("Expected the cached user without the changes")
+			}
+		}()
+	}
+	wg.Wait()
+	uc.forget(u.Id)
+	if cu, err := uc.get(ctx, u.Id); err != nil || !cu.Admin {
+		t.Fatalf("Expected the user to be loaded again after forgetting it (%v)", err)
+	}
+	//Without ttl every request loads the user
+	nc := newUserCache(0)
+	if err := u.SetAdmin(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if cu, err := nc.get(ctx, u.Id); err != nil || cu.Admin {
+		t.Fatalf("Expected the user to be loaded without a ttl (%v)", err)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+type userPreferencesRequest struct {
+	Preferences json.RawMessage `json:"preferences"`
+}
+
+type userPreferencesResponse struct {
+	Preferences json.RawMessage `json:"preferences"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+func newUserPreferencesResponse(up *models.UserPreferences) userPreferencesResponse {
+	return userPreferencesResponse{json.RawMessage(up.Data), up.UpdatedAt}
+}
+
+// GET /user/preferences
+func (ah apiHandler) userGetPreferences(w http.ResponseWriter, r *http.Request) error {
+	up, err := models.FindUserPreferences(r.Context(), ctxGetUser(r.Context()).Id)
+	if err != nil {
+		return err
+	}
+	return jsonResponseWithETag(w, r, newUserPreferencesResponse(up))
+}
+
+// PUT /user/preferences
+// Clients send the ETag they got in If-Match so they don't overwrite the changes made by another device
+func (ah apiHandler) userSetPreferences(w http.ResponseWriter, r *http.Request) error {
+	upr := &userPreferencesRequest{}
+	if err := jsonDecode(w, r, models.USER_PREFERENCES_MAX_SIZE+1024, upr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	up, err := models.UpdateUserPreferences(ctx, ctxGetUser(ctx).Id, upr.Preferences, func(current *models.UserPreferences) error {
+		return checkIfMatch(r, func() (interface{}, error) { return newUserPreferencesResponse(current), nil })
+	})
+	if err != nil {
+		return err
+	}
+	return jsonResponseWithETag(w, r, newUserPreferencesResponse(up))
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func putPreferences(prefs string, ifMatch string) (*http.Response, error) {
+	body, err := json.Marshal(userPreferencesRequest{json.RawMessage(prefs)})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("PUT", srv.URL+"/user/preferences", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(ifMatch) > 0 {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	return httpDo(req)
+}
+
+func TestUserPreferences(t *testing.T) {
+	loginDummyUser()
+	r, err := GetRequest("/user/preferences")
+	CheckErrorAndResponse(t, r, err, 200)
+	upr := &userPreferencesResponse{}
+	if err := json.NewDecoder(r.Body).Decode(upr); err != nil {
+		t.Fatal(err)
+	}
+	if string(upr.Preferences) != "{}" {
+		t.Fatalf("Expected empty preferences and got %s", upr.Preferences)
+	}
+	etag := r.Header.Get("ETag")
+	r, err = putPreferences(`{"theme": "dark", "density": "compact"}`, etag)
+	CheckErrorAndResponse(t, r, err, 200)
+	newEtag := r.Header.Get("ETag")
+	if len(newEtag) == 0 || newEtag == etag {
+		t.Fatalf("The ETag didn't change")
+	}
+	r, err = putPreferences(`{"theme": "light"}`, etag)
+	CheckErrorAndResponse(t, r, err, 412)
+	r, err = putPreferences(`["dark"]`, newEtag)
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = GetRequest("/user/preferences")
+	CheckErrorAndResponse(t, r, err, 200)
+	upr = &userPreferencesResponse{}
+	if err := json.NewDecoder(r.Body).Decode(upr); err != nil {
+		t.Fatal(err)
+	}
+	if string(upr.Preferences) != `{"theme":"dark","density":"compact"}` || r.Header.Get("ETag") != newEtag {
+		t.Fatalf("Unexpected preferences %s", upr.Preferences)
+	}
+	r, err = putPreferences(`{"theme": "light"}`, "")
+	CheckErrorAndResponse(t, r, err, 200)
+}
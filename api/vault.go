@@ -2,6 +2,8 @@ package api
 
 import (
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/keydotcat/keycatd/models"
 	"github.com/keydotcat/keycatd/util"
@@ -30,17 +32,48 @@ func (ah apiHandler) vaultRoot(w http.ResponseWriter, r *http.Request, t *models
 }
 
 type vaultListResponse struct {
-	Vaults []*models.VaultFull `json:"vaults"`
+	Vaults     []*models.VaultFull `json:"vaults"`
+	NextCursor string              `json:"next_cursor,omitempty"`
 }
 
+var vaultListSpec = listSpec{
+	id:          func(i interface{}) string { return i.(*models.VaultFull).Id },
+	defaultSort: "id",
+	sorts: map[string]listField{
+		"id":         func(i interface{}) string { return i.(*models.VaultFull).Id },
+		"created_at": func(i interface{}) string { return listTime(i.(*models.VaultFull).CreatedAt) },
+		"updated_at": func(i interface{}) string { return listTime(i.(*models.VaultFull).UpdatedAt) },
+	},
+	fields: []string{"id", "version", "public_key", "created_at", "updated_at", "approval_required", "rotation_required", "key", "users"},
+}
+
+// vaultFieldsResponse is the listing of the vaults when only some of their fields are requested
+type vaultFieldsResponse struct {
+	Vaults     []listFields `json:"vaults"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// GET /team/:tid/vault?sort=-updated_at&fields=
 func (ah apiHandler) vaultList(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	lq, err := parseListQuery(r, vaultListSpec)
+	if err != nil {
+		return err
+	}
 	ctx := r.Context()
 	u := ctxGetUser(ctx)
 	vs, err := t.GetVaultsFullForUser(ctx, u)
 	if err != nil {
 		return err
 	}
-	return jsonResponse(w, vaultListResponse{vs})
+	next := lq.apply(&vs)
+	if len(lq.fields) > 0 {
+		picked, err := lq.pickAll(vs)
+		if err != nil {
+			return err
+		}
+		return jsonResponse(w, vaultFieldsResponse{picked, next})
+	}
+	return jsonResponse(w, vaultListResponse{vs, next})
 }
 
 type vaultCreateRequest struct {
@@ -59,6 +92,7 @@ func (ah apiHandler) vaultCreate(w http.ResponseWriter, r *http.Request, t *mode
 	if err != nil {
 		return err
 	}
+	ah.auditLog(r, AUDIT_VAULT_CREATE, auditObject("team", t.Id, "vault", v.Id))
 	vf, err := v.GetVaultFullForUser(ctx, u)
 	if err != nil {
 		return err
@@ -71,7 +105,9 @@ func (ah apiHandler) validVaultRoot(w http.ResponseWriter, r *http.Request, t *m
 	var head string
 	head, r.URL.Path = shiftPath(r.URL.Path)
 	if len(head) == 0 {
-		//Not yet here
+		if r.Method == "GET" {
+			return ah.vaultGet(w, r, v)
+		}
 	} else {
 		switch head {
 		case "user":
@@ -80,11 +116,54 @@ func (ah apiHandler) validVaultRoot(w http.ResponseWriter, r *http.Request, t *m
 			return ah.validVaultSecretRoot(w, r, t, v)
 		case "secrets":
 			return ah.validVaultSecretsRoot(w, r, t, v)
+		case "approval":
+			if r.Method == "PUT" && r.URL.Path == "/" {
+				return ah.vaultSetApproval(w, r, t, v)
+			}
+		case "grant":
+			return ah.validVaultGrantRoot(w, r, t, v)
+		case "template":
+			return ah.validVaultTemplateRoot(w, r, t, v)
+		case "pin":
+			return ah.validVaultPinRoot(w, r, t, v)
+		case "machine_token":
+			return ah.validVaultMachineTokenRoot(w, r, t, v)
+		case "access":
+			if r.Method == "GET" && r.URL.Path == "/" {
+				return ah.vaultAccessList(w, r, t, v)
+			}
+		case "rotation":
+			if r.Method == "DELETE" && r.URL.Path == "/" {
+				return ah.vaultMarkRotated(w, r, t, v)
+			}
+		case "membership":
+			if r.Method == "GET" && r.URL.Path == "/" {
+				return ah.vaultMembershipDiff(w, r, t, v)
+			}
 		}
 	}
 	return util.NewErrorFrom(ErrNotFound)
 }
 
+// vaultFull returns the vault as the user sees it in a stable order so its ETag only changes when the vault does
+func vaultFull(r *http.Request, v *models.Vault) (*models.VaultFull, error) {
+	vf, err := v.GetVaultFullForUser(r.Context(), ctxGetUser(r.Context()))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(vf.Users)
+	return vf, nil
+}
+
+// GET /team/:tid/vault/:vid
+func (ah apiHandler) vaultGet(w http.ResponseWriter, r *http.Request, v *models.Vault) error {
+	vf, err := vaultFull(r, v)
+	if err != nil {
+		return err
+	}
+	return jsonResponseWithETag(w, r, vf)
+}
+
 // /team/:tid/vault/:vid/user
 func (ah apiHandler) validVaultUserRoot(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
 	var uid string
@@ -118,9 +197,22 @@ func (ah apiHandler) vaultAddUser(w http.ResponseWriter, r *http.Request, t *mod
 	if !isAdmin {
 		return util.NewErrorFrom(models.ErrUnauthorized)
 	}
-	if err := v.AddUsers(ctx, keys); err != nil {
+	if err := checkIfMatch(r, func() (interface{}, error) { return vaultFull(r, v) }); err != nil {
+		return err
+	}
+	expiresAt, err := queryTime(r, "expires_at", time.Time{})
+	if err != nil {
 		return err
 	}
+	if v.ApprovalRequired {
+		return ah.vaultRequestGrants(w, r, t, v, keys, expiresAt)
+	}
+	if err := v.AddUsersUntil(ctx, keys, expiresAt); err != nil {
+		return err
+	}
+	for uid := range keys {
+		ah.auditLog(r, AUDIT_VAULT_USER_ADD, auditObject("team", t.Id, "vault", v.Id, "user", uid))
+	}
 	vf, err := v.GetVaultFullForUser(ctx, u)
 	if err != nil {
 		return err
@@ -139,9 +231,24 @@ func (ah apiHandler) vaultRemoveUser(w http.ResponseWriter, r *http.Request, t *
 	if !isAdmin {
 		return util.NewErrorFrom(models.ErrUnauthorized)
 	}
+	if err := checkIfMatch(r, func() (interface{}, error) { return vaultFull(r, v) }); err != nil {
+		return err
+	}
+	dryRun, err := queryDryRun(r)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		cr, err := v.RemoveUserWithReport(ctx, uid, true)
+		if err != nil {
+			return err
+		}
+		return jsonResponse(w, cr)
+	}
 	if err := v.RemoveUser(ctx, uid); err != nil {
 		return err
 	}
+	ah.auditLog(r, AUDIT_VAULT_USER_REMOVE, auditObject("team", t.Id, "vault", v.Id, "user", uid))
 	vf, err := v.GetVaultFullForUser(ctx, u)
 	if err != nil {
 		return err
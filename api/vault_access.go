@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const JOB_VAULT_ACCESS_EXPIRY = "vault_access_expiry"
+
+// VAULT_ACCESS_EXPIRY_NOTICE is how long before an access expires the admins of the team are told about it
+const VAULT_ACCESS_EXPIRY_NOTICE = 72 * time.Hour
+
+type vaultAccessListResponse struct {
+	Access []*models.VaultAccess `json:"access"`
+}
+
+// expireVaultAccess removes the members whose access to a vault expired and mails the admins of the teams with
+// accesses about to expire
+func (ah apiHandler) expireVaultAccess(ctx context.Context) (string, error) {
+	now := time.Now().UTC()
+	expired, err := models.ExpireVaultUsers(ctx, now)
+	if err != nil {
+		return "", err
+	}
+	for _, va := range expired {
+		ae := &models.AuditEntry{Actor: "job:" + JOB_VAULT_ACCESS_EXPIRY, Action: AUDIT_VAULT_USER_EXPIRE, Object: auditObject("team", va.Team, "vault", va.Vault, "user", va.User)}
+		if err := ah.audit.Record(ctx, ae); err != nil {
+			return "", err
+		}
+	}
+	expiring, err := models.ClaimExpiringVaultUsers(ctx, now.Add(VAULT_ACCESS_EXPIRY_NOTICE))
+	if err != nil {
+		return "", err
+	}
+	admins := map[string][]*models.User{}
+	for _, va := range expiring {
+		us, ok := admins[va.Team]
+		if !ok {
+			t, err := models.FindTeam(ctx, va.Team)
+			if err != nil {
+				return "", err
+			}
+			if us, err = t.GetAdminUsers(ctx); err != nil {
+				return "", err
+			}
+			admins[va.Team] = us
+		}
+		for _, u := range us {
+			if err := ah.mail.sendVaultAccessExpiringMail(u, va, "en"); err != nil {
+				return "", err
+			}
+		}
+	}
+	return fmt.Sprintf("Expired %d vault accesses and notified %d about to expire", len(expired), len(expiring)), nil
+}
+
+// GET /team/:tid/vault/:vid/access
+func (ah apiHandler) vaultAccessList(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	if err := checkTeamAdmin(r, t); err != nil {
+		return err
+	}
+	vas, err := v.GetExpiringUsers(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, vaultAccessListResponse{vas})
+}
+
+// DELETE /team/:tid/vault/:vid/rotation
+func (ah apiHandler) vaultMarkRotated(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	if err := checkTeamAdmin(r, t); err != nil {
+		return err
+	}
+	if !v.RotationRequired {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	if err := v.MarkRotated(r.Context()); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_VAULT_ROTATED, auditObject("team", t.Id, "vault", v.Id))
+	vf, err := vaultFull(r, v)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, vf)
+}
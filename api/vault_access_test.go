@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestVaultAccessExpires(t *testing.T) {
+	u := loginDummyUser()
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tid := teams[0].Id
+	vkp := getDummyVaultKeyPair(getUserPrivateKeys(u.PublicKey, u.Key), u.Id)
+	r, err := PostRequest(fmt.Sprintf("/team/%s/vault", tid), vaultCreateRequest{util.GenerateRandomToken(5), vkp})
+	CheckErrorAndResponse(t, r, err, 200)
+	vf := &models.VaultFull{}
+	if err := json.NewDecoder(r.Body).Decode(vf); err != nil {
+		t.Fatal(err)
+	}
+	vpath := fmt.Sprintf("/team/%s/vault/%s", tid, vf.Id)
+	invitee := getDummyUser()
+	r, err = PostRequest(fmt.Sprintf("/team/%s/user", tid), teamInviteUserRequest{Invite: invitee.Email})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest(vpath+"/user?expires_at=yesterday", map[string][]byte{invitee.Id: vkp.Keys[u.Id]})
+	CheckErrorAndResponse(t, r, err, 400)
+	expiresAt := time.Now().UTC().Add(time.Hour).Format(time.RFC3339)
+	r, err = PostRequest(vpath+"/user?expires_at="+expiresAt, map[string][]byte{invitee.Id: vkp.Keys[u.Id]})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(vpath + "/access")
+	CheckErrorAndResponse(t, r, err, 200)
+	val := &vaultAccessListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(val); err != nil {
+		t.Fatal(err)
+	}
+	if len(val.Access) != 1 || val.Access[0].User != invitee.Id {
+		t.Fatalf("Unexpected expiring members %#v", val.Access)
+	}
+	r, err = DeleteRequest(vpath + "/rotation")
+	CheckErrorAndResponse(t, r, err, 404)
+	if _, err := apiH.db.Exec(`UPDATE "vault_user" SET "expires_at" = $1 WHERE "team" = $2 AND "vault" = $3 AND "user" = $4`, time.Now().Add(-time.Minute), tid, vf.Id, invitee.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := apiH.expireVaultAccess(getCtx()); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest(vpath)
+	CheckErrorAndResponse(t, r, err, 200)
+	if err := json.NewDecoder(r.Body).Decode(vf); err != nil {
+		t.Fatal(err)
+	}
+	if !vf.RotationRequired || len(vf.Users) != 1 {
+		t.Fatalf("Expected the vault to need rotation without the expired member: %v %v", vf.RotationRequired, vf.Users)
+	}
+	r, err = DeleteRequest(vpath + "/rotation")
+	CheckErrorAndResponse(t, r, err, 200)
+	if err := json.NewDecoder(r.Body).Decode(vf); err != nil {
+		t.Fatal(err)
+	}
+	if vf.RotationRequired {
+		t.Fatal("The rotation flag has not been cleared")
+	}
+}
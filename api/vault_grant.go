@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+type vaultApprovalRequest struct {
+	Required bool `json:"required"`
+}
+
+type vaultGrantListResponse struct {
+	Grants []*models.VaultGrant `json:"grants"`
+}
+
+func checkTeamAdmin(r *http.Request, t *models.Team) error {
+	isAdmin, err := t.CheckAdmin(r.Context(), ctxGetUser(r.Context()))
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	return nil
+}
+
+// PUT /team/:tid/vault/:vid/approval
+func (ah apiHandler) vaultSetApproval(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	aqr := &vaultApprovalRequest{}
+	if err := jsonDecode(w, r, 1024, aqr); err != nil {
+		return err
+	}
+	if err := checkTeamAdmin(r, t); err != nil {
+		return err
+	}
+	if err := v.SetApprovalRequired(r.Context(), aqr.Required); err != nil {
+		return err
+	}
+	action := AUDIT_VAULT_APPROVAL_OFF
+	if aqr.Required {
+		action = AUDIT_VAULT_APPROVAL_ON
+	}
+	ah.auditLog(r, action, auditObject("team", t.Id, "vault", v.Id))
+	vf, err := vaultFull(r, v)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, vf)
+}
+
+// vaultRequestGrants keeps the keys of the new members of a vault that requires approval. The answer is a 202 with the
+// pending grants
+func (ah apiHandler) vaultRequestGrants(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, keys map[string][]byte, expiresAt time.Time) error {
+	vgs, err := v.RequestGrants(r.Context(), ctxGetUser(r.Context()).Id, keys, expiresAt)
+	if err != nil {
+		return err
+	}
+	for _, vg := range vgs {
+		ah.auditLog(r, AUDIT_VAULT_GRANT_REQUEST, auditObject("team", t.Id, "vault", v.Id, "user", vg.User))
+	}
+	return jsonResponseWithStatus(w, http.StatusAccepted, vaultGrantListResponse{vgs})
+}
+
+// /team/:tid/vault/:vid/grant
+func (ah apiHandler) validVaultGrantRoot(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	var uid string
+	uid, r.URL.Path = shiftPath(r.URL.Path)
+	if r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	if err := checkTeamAdmin(r, t); err != nil {
+		return err
+	}
+	switch {
+	case len(uid) == 0 && r.Method == "GET":
+		return ah.vaultGrantList(w, r, v)
+	case len(uid) > 0 && r.Method == "PUT":
+		return ah.vaultGrantApprove(w, r, t, v, uid)
+	case len(uid) > 0 && r.Method == "DELETE":
+		return ah.vaultGrantReject(w, r, t, v, uid)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /team/:tid/vault/:vid/grant
+func (ah apiHandler) vaultGrantList(w http.ResponseWriter, r *http.Request, v *models.Vault) error {
+	vgs, err := v.GetGrants(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, vaultGrantListResponse{vgs})
+}
+
+// PUT /team/:tid/vault/:vid/grant/:uid
+func (ah apiHandler) vaultGrantApprove(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, uid string) error {
+	if err := checkIfMatch(r, func() (interface{}, error) { return vaultFull(r, v) }); err != nil {
+		return err
+	}
+	if _, err := v.ApproveGrant(r.Context(), ctxGetUser(r.Context()).Id, uid); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_VAULT_GRANT_APPROVE, auditObject("team", t.Id, "vault", v.Id, "user", uid))
+	ah.auditLog(r, AUDIT_VAULT_USER_ADD, auditObject("team", t.Id, "vault", v.Id, "user", uid))
+	vf, err := vaultFull(r, v)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, vf)
+}
+
+// DELETE /team/:tid/vault/:vid/grant/:uid
+func (ah apiHandler) vaultGrantReject(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, uid string) error {
+	if err := v.RejectGrant(r.Context(), uid); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_VAULT_GRANT_REJECT, auditObject("team", t.Id, "vault", v.Id, "user", uid))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestVaultGrantNeedsSecondAdmin(t *testing.T) {
+	u := loginDummyUser()
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tid := teams[0].Id
+	vkp := getDummyVaultKeyPair(getUserPrivateKeys(u.PublicKey, u.Key), u.Id)
+	r, err := PostRequest(fmt.Sprintf("/team/%s/vault", tid), vaultCreateRequest{util.GenerateRandomToken(5), vkp})
+	CheckErrorAndResponse(t, r, err, 200)
+	vf := &models.VaultFull{}
+	if err := json.NewDecoder(r.Body).Decode(vf); err != nil {
+		t.Fatal(err)
+	}
+	vpath := fmt.Sprintf("/team/%s/vault/%s", tid, vf.Id)
+	r, err = PutRequest(vpath+"/approval", vaultApprovalRequest{true})
+	CheckErrorAndResponse(t, r, err, 200)
+	if err := json.NewDecoder(r.Body).Decode(vf); err != nil {
+		t.Fatal(err)
+	}
+	if !vf.ApprovalRequired {
+		t.Fatal("The vault doesn't require approval")
+	}
+	invitee := getDummyUser()
+	r, err = PostRequest(fmt.Sprintf("/team/%s/user", tid), teamInviteUserRequest{Invite: invitee.Email})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest(vpath+"/user", map[string][]byte{invitee.Id: vkp.Keys[u.Id]})
+	CheckErrorAndResponse(t, r, err, http.StatusAccepted)
+	r, err = GetRequest(vpath + "/grant")
+	CheckErrorAndResponse(t, r, err, 200)
+	vgl := &vaultGrantListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(vgl); err != nil {
+		t.Fatal(err)
+	}
+	if len(vgl.Grants) != 1 || vgl.Grants[0].User != invitee.Id || vgl.Grants[0].RequestedBy != u.Id {
+		t.Fatalf("Unexpected grants %#v", vgl.Grants)
+	}
+	r, err = GetRequest(vpath)
+	CheckErrorAndResponse(t, r, err, 200)
+	if err := json.NewDecoder(r.Body).Decode(vf); err != nil {
+		t.Fatal(err)
+	}
+	if len(vf.Users) != 1 {
+		t.Fatalf("The pending member is already in the vault: %v", vf.Users)
+	}
+	r, err = PutRequest(vpath+"/grant/"+invitee.Id, nil)
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = DeleteRequest(vpath + "/grant/" + invitee.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = DeleteRequest(vpath + "/grant/" + invitee.Id)
+	CheckErrorAndResponse(t, r, err, 404)
+}
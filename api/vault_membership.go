@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// VAULT_MEMBERSHIP_WINDOW is how far back the membership changes of a vault are listed by default
+const VAULT_MEMBERSHIP_WINDOW = 30 * 24 * time.Hour
+
+type vaultMembershipChange struct {
+	User   string    `json:"user"`
+	Action string    `json:"action"`
+	Actor  string    `json:"actor"`
+	At     time.Time `json:"at"`
+}
+
+type vaultMembershipDiffResponse struct {
+	From   time.Time                `json:"from"`
+	To     time.Time                `json:"to"`
+	Gained []*vaultMembershipChange `json:"gained"`
+	Lost   []*vaultMembershipChange `json:"lost"`
+	//LastRotation is the last time the vault was rotated after from, even if it was after to
+	LastRotation     *time.Time `json:"last_rotation,omitempty"`
+	RotatedSinceLoss bool       `json:"rotated_since_loss"`
+	RotationRequired bool       `json:"rotation_required"`
+	//RotationOverdue is set when someone lost the access and the vault has not been rotated since
+	RotationOverdue bool `json:"rotation_overdue"`
+}
+
+// GET /team/:tid/vault/:vid/membership?from=&to=
+func (ah apiHandler) vaultMembershipDiff(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	if err := checkTeamAdmin(r, t); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	from, err := queryTime(r, "from", now.Add(-VAULT_MEMBERSHIP_WINDOW))
+	if err != nil {
+		return err
+	}
+	to, err := queryTime(r, "to", now)
+	if err != nil {
+		return err
+	}
+	if !from.Before(to) {
+		return util.NewErrorf("from has to be before to")
+	}
+	//The rotations are looked up until now since the point is to know if the changes are covered by one
+	end := now
+	if to.After(end) {
+		end = to
+	}
+	vobj := auditObject("team", t.Id, "vault", v.Id)
+	last := map[string]*vaultMembershipChange{}
+	var lastRotation *time.Time
+	err = models.ForEachAuditEntryUnder(r.Context(), vobj, from, end, func(ae *models.AuditEntry) error {
+		if ae.Action == AUDIT_VAULT_ROTATED && ae.Object == vobj {
+			at := ae.CreatedAt
+			lastRotation = &at
+			return nil
+		}
+		if !ae.CreatedAt.Before(to) || !strings.HasPrefix(ae.Object, vobj+"/user:") {
+			return nil
+		}
+		switch ae.Action {
+		case AUDIT_VAULT_USER_ADD, AUDIT_VAULT_USER_REMOVE, AUDIT_VAULT_USER_EXPIRE:
+			uid := strings.TrimPrefix(ae.Object, vobj+"/user:")
+			last[uid] = &vaultMembershipChange{uid, ae.Action, ae.Actor, ae.CreatedAt}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	vmd := vaultMembershipDiffResponse{From: from, To: to, Gained: []*vaultMembershipChange{}, Lost: []*vaultMembershipChange{}, LastRotation: lastRotation, RotationRequired: v.RotationRequired}
+	//Only the last change of each member counts, so someone removed and added back keeps the access
+	var lastLoss time.Time
+	for _, vmc := range last {
+		if vmc.Action == AUDIT_VAULT_USER_ADD {
+			vmd.Gained = append(vmd.Gained, vmc)
+			continue
+		}
+		vmd.Lost = append(vmd.Lost, vmc)
+		if vmc.At.After(lastLoss) {
+			lastLoss = vmc.At
+		}
+	}
+	for _, vmcs := range [][]*vaultMembershipChange{vmd.Gained, vmd.Lost} {
+		sort.Slice(vmcs, func(i, j int) bool { return vmcs[i].At.Before(vmcs[j].At) })
+	}
+	if len(vmd.Lost) > 0 {
+		vmd.RotatedSinceLoss = lastRotation != nil && lastRotation.After(lastLoss)
+		vmd.RotationOverdue = !vmd.RotatedSinceLoss
+	}
+	return jsonResponse(w, vmd)
+}
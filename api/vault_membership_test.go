@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestVaultMembershipDiff(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vfs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vfs[0]
+	vpath := fmt.Sprintf("/team/%s/vault/%s", team.Id, v.Id)
+	gone, kept := getDummyUser(), getDummyUser()
+	for _, member := range []*models.User{gone, kept} {
+		r, err := PostRequest(fmt.Sprintf("/team/%s/user", team.Id), teamInviteUserRequest{Invite: member.Email})
+		CheckErrorAndResponse(t, r, err, 200)
+		r, err = PostRequest(vpath+"/user", map[string][]byte{member.Id: v.Key})
+		CheckErrorAndResponse(t, r, err, 200)
+	}
+	r, err := DeleteRequest(vpath + "/user/" + gone.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	vmd := &vaultMembershipDiffResponse{}
+	r, err = GetRequest(vpath + "/membership")
+	CheckErrorAndResponse(t, r, err, 200)
+	if err := json.NewDecoder(r.Body).Decode(vmd); err != nil {
+		t.Fatal(err)
+	}
+	if len(vmd.Gained) != 1 || vmd.Gained[0].User != kept.Id || len(vmd.Lost) != 1 || vmd.Lost[0].User != gone.Id || vmd.Lost[0].Actor != u.Id {
+		t.Fatalf("Unexpected membership changes %#v", vmd)
+	}
+	if !vmd.RotationOverdue || vmd.RotatedSinceLoss || vmd.LastRotation != nil {
+		t.Errorf("Expected the rotation to be overdue after a loss and got %#v", vmd)
+	}
+	if err := models.RecordAuditEntry(ctx, &models.AuditEntry{Actor: u.Id, Action: AUDIT_VAULT_ROTATED, Object: auditObject("team", team.Id, "vault", v.Id)}); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest(vpath + "/membership")
+	CheckErrorAndResponse(t, r, err, 200)
+	vmd = &vaultMembershipDiffResponse{}
+	if err := json.NewDecoder(r.Body).Decode(vmd); err != nil {
+		t.Fatal(err)
+	}
+	if vmd.RotationOverdue || !vmd.RotatedSinceLoss || vmd.LastRotation == nil {
+		t.Errorf("Expected the rotation to cover the loss and got %#v", vmd)
+	}
+	r, err = GetRequest(vpath + "/membership?to=" + url.QueryEscape(time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)))
+	CheckErrorAndResponse(t, r, err, 200)
+	vmd = &vaultMembershipDiffResponse{}
+	if err := json.NewDecoder(r.Body).Decode(vmd); err != nil {
+		t.Fatal(err)
+	}
+	if len(vmd.Gained) != 0 || len(vmd.Lost) != 0 || vmd.RotationOverdue {
+		t.Errorf("Expected no changes before the window and got %#v", vmd)
+	}
+	ms, err := apiH.sm.NewSession(kept.Id, "1.1.1.1", "none", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = ms.Id
+	r, err = GetRequest(vpath + "/membership")
+	CheckErrorAndResponse(t, r, err, 401)
+}
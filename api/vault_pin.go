@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+type vaultPinRequest struct {
+	Secrets []string `json:"secrets"`
+}
+
+type vaultPinListResponse struct {
+	Pins []*models.SecretPin `json:"pins"`
+}
+
+// /team/:tid/vault/:vid/pin
+func (ah apiHandler) validVaultPinRoot(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	if r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	switch r.Method {
+	case "GET":
+		return ah.vaultPinList(w, r, v)
+	case "PUT":
+		//Every member sees the pins but only the admins choose them
+		if err := checkTeamAdmin(r, t); err != nil {
+			return err
+		}
+		return ah.vaultPinSet(w, r, t, v)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /team/:tid/vault/:vid/pin
+func (ah apiHandler) vaultPinList(w http.ResponseWriter, r *http.Request, v *models.Vault) error {
+	sps, err := v.GetPins(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, vaultPinListResponse{sps})
+}
+
+// PUT /team/:tid/vault/:vid/pin
+func (ah apiHandler) vaultPinSet(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	vpr := &vaultPinRequest{}
+	if err := jsonDecode(w, r, 4096, vpr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	sps, err := v.SetPins(ctx, ctxGetUser(ctx).Id, vpr.Secrets)
+	if err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_VAULT_PINS, auditObject("team", t.Id, "vault", v.Id))
+	return jsonResponse(w, vaultPinListResponse{sps})
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestVaultPins(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vfs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vfs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	r, err := PostRequest(fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id), vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/team/%s/vault/%s/pin", team.Id, v.Id)
+	r, err = PutRequest(path, vaultPinRequest{[]string{"nope"}})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PutRequest(path, vaultPinRequest{[]string{s.Id}})
+	CheckErrorAndResponse(t, r, err, 200)
+	member := getDummyUser()
+	r, err = PostRequest(fmt.Sprintf("/team/%s/user", team.Id), teamInviteUserRequest{Invite: member.Email})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest(fmt.Sprintf("/team/%s/vault/%s/user", team.Id, v.Id), map[string][]byte{member.Id: v.Key})
+	CheckErrorAndResponse(t, r, err, 200)
+	ms, err := apiH.sm.NewSession(member.Id, "1.1.1.1", "none", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = ms.Id
+	r, err = GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 200)
+	vpl := &vaultPinListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(vpl); err != nil {
+		t.Fatal(err)
+	}
+	if len(vpl.Pins) != 1 || vpl.Pins[0].Secret != s.Id || vpl.Pins[0].PinnedBy != u.Id {
+		t.Fatalf("Unexpected pins %#v", vpl.Pins)
+	}
+	r, err = PutRequest(path, vaultPinRequest{nil})
+	CheckErrorAndResponse(t, r, err, 401)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+type vaultTemplateRequest struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+type vaultTemplateListResponse struct {
+	Templates []*models.VaultTemplate `json:"templates"`
+}
+
+// /team/:tid/vault/:vid/template
+func (ah apiHandler) validVaultTemplateRoot(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	var tmid string
+	tmid, r.URL.Path = shiftPath(r.URL.Path)
+	if r.URL.Path != "/" {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	if len(tmid) == 0 && r.Method == "GET" {
+		return ah.vaultTemplateList(w, r, v)
+	}
+	//Every member can use the templates but only the admins manage them
+	if err := checkTeamAdmin(r, t); err != nil {
+		return err
+	}
+	switch {
+	case len(tmid) == 0 && r.Method == "POST":
+		return ah.vaultTemplateCreate(w, r, t, v)
+	case len(tmid) > 0 && r.Method == "PUT":
+		return ah.vaultTemplateUpdate(w, r, t, v, tmid)
+	case len(tmid) > 0 && r.Method == "DELETE":
+		return ah.vaultTemplateDelete(w, r, t, v, tmid)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /team/:tid/vault/:vid/template
+func (ah apiHandler) vaultTemplateList(w http.ResponseWriter, r *http.Request, v *models.Vault) error {
+	vts, err := v.GetTemplates(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, vaultTemplateListResponse{vts})
+}
+
+// POST /team/:tid/vault/:vid/template
+func (ah apiHandler) vaultTemplateCreate(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	vtr := &vaultTemplateRequest{}
+	if err := jsonDecode(w, r, 2*models.VAULT_TEMPLATE_MAX_SIZE, vtr); err != nil {
+		return err
+	}
+	vt := &models.VaultTemplate{Name: vtr.Name, Data: vtr.Data}
+	if err := v.AddTemplate(r.Context(), ctxGetUser(r.Context()).Id, vt); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_VAULT_TEMPLATE_CREATE, auditObject("team", t.Id, "vault", v.Id, "template", vt.Id))
+	return jsonResponse(w, vt)
+}
+
+// PUT /team/:tid/vault/:vid/template/:tmid
+func (ah apiHandler) vaultTemplateUpdate(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, tmid string) error {
+	vtr := &vaultTemplateRequest{}
+	if err := jsonDecode(w, r, 2*models.VAULT_TEMPLATE_MAX_SIZE, vtr); err != nil {
+		return err
+	}
+	vt := &models.VaultTemplate{Id: tmid, Name: vtr.Name, Data: vtr.Data}
+	if err := v.UpdateTemplate(r.Context(), vt); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_VAULT_TEMPLATE_UPDATE, auditObject("team", t.Id, "vault", v.Id, "template", tmid))
+	return jsonResponse(w, vt)
+}
+
+// DELETE /team/:tid/vault/:vid/template/:tmid
+func (ah apiHandler) vaultTemplateDelete(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, tmid string) error {
+	if err := v.DeleteTemplate(r.Context(), tmid); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_VAULT_TEMPLATE_DELETE, auditObject("team", t.Id, "vault", v.Id, "template", tmid))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestVaultTemplates(t *testing.T) {
+	u := loginDummyUser()
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tid := teams[0].Id
+	vkp := getDummyVaultKeyPair(getUserPrivateKeys(u.PublicKey, u.Key), u.Id)
+	r, err := PostRequest(fmt.Sprintf("/team/%s/vault", tid), vaultCreateRequest{util.GenerateRandomToken(5), vkp})
+	CheckErrorAndResponse(t, r, err, 200)
+	vf := &models.VaultFull{}
+	if err := json.NewDecoder(r.Body).Decode(vf); err != nil {
+		t.Fatal(err)
+	}
+	tpath := fmt.Sprintf("/team/%s/vault/%s/template", tid, vf.Id)
+	r, err = PostRequest(tpath, vaultTemplateRequest{"Login", nil})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest(tpath, vaultTemplateRequest{"Login", []byte(`{"fields":["user","password"]}`)})
+	CheckErrorAndResponse(t, r, err, 200)
+	vt := &models.VaultTemplate{}
+	if err := json.NewDecoder(r.Body).Decode(vt); err != nil {
+		t.Fatal(err)
+	}
+	r, err = PostRequest(tpath, vaultTemplateRequest{"login", []byte("{}")})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PutRequest(tpath+"/"+vt.Id, vaultTemplateRequest{"Web login", []byte(`{"fields":["url","user","password"]}`)})
+	CheckErrorAndResponse(t, r, err, 200)
+	member := getDummyUser()
+	r, err = PostRequest(fmt.Sprintf("/team/%s/user", tid), teamInviteUserRequest{Invite: member.Email})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest(fmt.Sprintf("/team/%s/vault/%s/user", tid, vf.Id), map[string][]byte{member.Id: vkp.Keys[u.Id]})
+	CheckErrorAndResponse(t, r, err, 200)
+	s, err := apiH.sm.NewSession(member.Id, "1.1.1.1", "none", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = s.Id
+	r, err = GetRequest(tpath)
+	CheckErrorAndResponse(t, r, err, 200)
+	vtl := &vaultTemplateListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(vtl); err != nil {
+		t.Fatal(err)
+	}
+	if len(vtl.Templates) != 1 || vtl.Templates[0].Name != "Web login" || vtl.Templates[0].CreatedBy != u.Id {
+		t.Fatalf("Unexpected templates %#v", vtl.Templates)
+	}
+	r, err = DeleteRequest(tpath + "/" + vt.Id)
+	CheckErrorAndResponse(t, r, err, 401)
+}
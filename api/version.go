@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/keydotcat/keycatd/util"
 )
@@ -16,12 +17,27 @@ func (ah apiHandler) versionRoot(w http.ResponseWriter, r *http.Request) error {
 }
 
 type versionSendFullResponse struct {
-	Name   string `json:"name"`
-	Server string `json:"server"`
-	Web    string `json:"web"`
+	Name             string    `json:"name"`
+	Server           string    `json:"server"`
+	Web              string    `json:"web"`
+	Commit           string    `json:"commit"`
+	BuildDate        time.Time `json:"build_date"`
+	MinClientVersion string    `json:"min_client_version"`
+	ApiVersion       string    `json:"api_version"`
+	//ClientVersions is the oldest version supported for each type of client
+	ClientVersions map[string]string `json:"client_versions"`
 }
 
 // /version
 func (ah apiHandler) versionSendFull(w http.ResponseWriter, r *http.Request) error {
-	return jsonResponse(w, versionSendFullResponse{Name: "KeyCat", Server: util.GetServerVersion(), Web: util.GetWebVersion()})
+	return jsonResponse(w, versionSendFullResponse{
+		Name:             "KeyCat",
+		Server:           util.GetServerVersion(),
+		Web:              util.GetWebVersion(),
+		Commit:           util.GetVersion(),
+		BuildDate:        util.GetBuildDate(),
+		MinClientVersion: util.MIN_CLIENT_VERSION,
+		ApiVersion:       API_VERSION_CURRENT,
+		ClientVersions:   ah.opts().clientVersions,
+	})
 }
@@ -21,6 +21,12 @@ func TestGetFullVersion(t *testing.T) {
 	if sga.Web != util.GetWebVersion() {
 		t.Errorf("Mismatch in the web version: %s vs %s", util.GetWebVersion(), sga.Web)
 	}
+	if sga.Commit != util.GetVersion() || sga.MinClientVersion != util.MIN_CLIENT_VERSION || sga.ApiVersion != API_VERSION_CURRENT {
+		t.Errorf("Mismatch in the build info: %#v", sga)
+	}
+	if sga.BuildDate.IsZero() {
+		t.Errorf("Missing build date")
+	}
 	if sga.Name != "KeyCat" {
 		t.Errorf("Mismatch in the name : 'KeyCat' vs %s", sga.Name)
 	}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// API_VERSION_CURRENT is the version new clients should use
+const API_VERSION_CURRENT = "v1"
+
+// API_VERSION_LEGACY is the version served in the paths without a version. Browser extensions that were released
+// before the api was versioned use them so they are kept as an alias of v1 forever
+const API_VERSION_LEGACY = "v1"
+
+// apiVersion is the router of one version of the api. Versions with breaking changes get their own router and
+// the previous ones are kept marked as deprecated until their clients are gone
+type apiVersion struct {
+	root       func(ah apiHandler, w http.ResponseWriter, r *http.Request) error
+	deprecated bool
+	//sunset is when a deprecated version will stop being served. Zero if it's not known yet
+	sunset time.Time
+}
+
+var apiVersions map[string]apiVersion
+
+// The versions are set in init because batches make the routers call back into apiRoot
+func init() {
+	apiVersions = map[string]apiVersion{
+		"v1": {root: apiHandler.v1Root},
+	}
+}
+
+var apiVersionRe = regexp.MustCompile(`^v[0-9]+$`)
+
+// splitApiVersion returns the version of the api a path under /api asks for and the path inside that version.
+// Paths that don't start with a version are served by the legacy one
+func splitApiVersion(p string) (version, rest string, legacy bool) {
+	head, tail := shiftPath(p)
+	if apiVersionRe.MatchString(head) {
+		return head, tail, false
+	}
+	return API_VERSION_LEGACY, p, true
+}
+
+// setDeprecationHeaders tells the clients of deprecated paths which ones to use instead
+func setDeprecationHeaders(w http.ResponseWriter, av apiVersion, legacy bool, base, path string) {
+	switch {
+	case legacy:
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+base+"/api/"+API_VERSION_CURRENT+path+">; rel=\"successor-version\"")
+	case av.deprecated:
+		w.Header().Set("Deprecation", "true")
+		if !av.sunset.IsZero() {
+			w.Header().Set("Sunset", av.sunset.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Set("Link", "<"+base+"/api/"+API_VERSION_CURRENT+">; rel=\"successor-version\"")
+	}
+}
+
+// v1Root is the router of the first version of the api
+func (ah apiHandler) v1Root(w http.ResponseWriter, r *http.Request) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	//This is the non authenticated root
+	switch head {
+	case "auth":
+		//Admins still need to be able to login during maintenance
+		if sub, _ := shiftPath(r.URL.Path); sub != "login" && ah.maintenanceBlock(w, r) {
+			return nil
+		}
+		return ah.authRoot(w, r)
+	case "version":
+		return ah.versionRoot(w, r)
+	case "openapi.json":
+		return ah.openapiRoot(w, r)
+	case "idp":
+		return ah.idpRoot(w, r)
+	case "oidc":
+		return ah.oidcRoot(w, r)
+	case "machine":
+		//Machines authenticate with their own tokens instead of sessions
+		return ah.machineRoot(w, r)
+	}
+	return ah.authenticatedRoot(w, r, head)
+}
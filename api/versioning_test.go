@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitApiVersion(t *testing.T) {
+	checks := []struct {
+		path    string
+		version string
+		rest    string
+		legacy  bool
+	}{
+		{"/v1/team/t1", "v1", "/team/t1", false},
+		{"/v7/auth/login", "v7", "/auth/login", false},
+		{"/team/t1", API_VERSION_LEGACY, "/team/t1", true},
+		{"/version", API_VERSION_LEGACY, "/version", true},
+		{"/", API_VERSION_LEGACY, "/", true},
+	}
+	for _, c := range checks {
+		version, rest, legacy := splitApiVersion(c.path)
+		if version != c.version || rest != c.rest || legacy != c.legacy {
+			t.Errorf("%s: expected %s %s %t and got %s %s %t", c.path, c.version, c.rest, c.legacy, version, rest, legacy)
+		}
+	}
+}
+
+func TestApiVersionRoutes(t *testing.T) {
+	base := strings.TrimSuffix(srv.URL, "/api")
+	r, err := http.Get(base + "/api/v1/version")
+	CheckErrorAndResponse(t, r, err, 200)
+	if len(r.Header.Get("Deprecation")) > 0 {
+		t.Errorf("The current version should not be deprecated")
+	}
+	r, err = http.Get(base + "/api/version")
+	CheckErrorAndResponse(t, r, err, 200)
+	if r.Header.Get("Deprecation") != "true" || r.Header.Get("Link") != `</api/v1/version>; rel="successor-version"` {
+		t.Errorf("Expected the legacy path to be deprecated and got %v", r.Header)
+	}
+	r, err = http.Get(base + "/api/v99/version")
+	CheckErrorAndResponse(t, r, err, 404)
+}
+
+func TestDeprecatedApiVersionHeaders(t *testing.T) {
+	sunset := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	rec := httptest.NewRecorder()
+	setDeprecationHeaders(rec, apiVersion{deprecated: true, sunset: sunset}, false, "", "/team")
+	if rec.Header().Get("Deprecation") != "true" || rec.Header().Get("Sunset") != "Wed, 02 Jan 2030 03:04:05 GMT" {
+		t.Errorf("Unexpected deprecation headers %v", rec.Header())
+	}
+	rec = httptest.NewRecorder()
+	setDeprecationHeaders(rec, apiVersions[API_VERSION_CURRENT], false, "", "/team")
+	if len(rec.Header()) > 0 {
+		t.Errorf("Expected no headers for the current version and got %v", rec.Header())
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// /team/:tid/webhook
+func (ah apiHandler) webhookRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	ctx := r.Context()
+	isAdmin, err := t.CheckAdmin(ctx, ctxGetUser(ctx))
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	var wid string
+	wid, r.URL.Path = shiftPath(r.URL.Path)
+	if len(wid) == 0 {
+		switch r.Method {
+		case "GET":
+			return ah.webhookList(w, r, t)
+		case "POST":
+			return ah.webhookCreate(w, r, t)
+		}
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	wh, err := t.GetWebhook(ctx, wid)
+	if err != nil {
+		return err
+	}
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	switch {
+	case len(head) == 0 && r.Method == "GET":
+		return ah.webhookGet(w, r, wh)
+	case len(head) == 0 && r.Method == "DELETE":
+		return ah.webhookDelete(w, r, t, wh)
+	case head == "delivery":
+		return ah.webhookDeliveryRoot(w, r, wh)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type webhookListResponse struct {
+	Webhooks []*models.Webhook `json:"webhooks"`
+}
+
+// GET /team/:tid/webhook
+func (ah apiHandler) webhookList(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	hooks, err := t.GetWebhooks(r.Context())
+	if err != nil {
+		return err
+	}
+	for i, hook := range hooks {
+		hooks[i] = withoutWebhookSecret(hook)
+	}
+	return jsonResponse(w, webhookListResponse{hooks})
+}
+
+// withoutWebhookSecret hides the signing secret. It's only shown once when the webhook is created
+func withoutWebhookSecret(wh *models.Webhook) *models.Webhook {
+	cp := *wh
+	cp.Secret = ""
+	return &cp
+}
+
+type webhookCreateRequest struct {
+	Url string `json:"url"`
+}
+
+// POST /team/:tid/webhook
+func (ah apiHandler) webhookCreate(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	wcr := &webhookCreateRequest{}
+	if err := jsonDecode(w, r, 4096, wcr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	wh, err := t.CreateWebhook(ctx, ctxGetUser(ctx), wcr.Url)
+	if err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_WEBHOOK_CREATE, auditObject("team", t.Id, "webhook", wh.Id))
+	return jsonResponse(w, wh)
+}
+
+// GET /team/:tid/webhook/:wid
+func (ah apiHandler) webhookGet(w http.ResponseWriter, r *http.Request, wh *models.Webhook) error {
+	return jsonResponseWithETag(w, r, withoutWebhookSecret(wh))
+}
+
+// DELETE /team/:tid/webhook/:wid
+func (ah apiHandler) webhookDelete(w http.ResponseWriter, r *http.Request, t *models.Team, wh *models.Webhook) error {
+	ctx := r.Context()
+	if err := checkIfMatch(r, func() (interface{}, error) { return withoutWebhookSecret(wh), nil }); err != nil {
+		return err
+	}
+	if err := t.DeleteWebhook(ctx, ctxGetUser(ctx), wh.Id); err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_WEBHOOK_DELETE, auditObject("team", t.Id, "webhook", wh.Id))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// /team/:tid/webhook/:wid/delivery
+func (ah apiHandler) webhookDeliveryRoot(w http.ResponseWriter, r *http.Request, wh *models.Webhook) error {
+	var did string
+	did, r.URL.Path = shiftPath(r.URL.Path)
+	if len(did) == 0 {
+		if r.Method == "GET" {
+			return ah.webhookDeliveryList(w, r, wh)
+		}
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	action, _ := shiftPath(r.URL.Path)
+	if action == "redeliver" && r.Method == "POST" {
+		return ah.webhookRedeliver(w, r, wh, did)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type webhookDeliveryListResponse struct {
+	Deliveries []*models.WebhookDelivery `json:"deliveries"`
+}
+
+var webhookDeliveryStatus = map[string]int{
+	"pending":   models.WEBHOOK_DELIVERY_PENDING,
+	"delivered": models.WEBHOOK_DELIVERY_DELIVERED,
+	"dead":      models.WEBHOOK_DELIVERY_DEAD,
+}
+
+// GET /team/:tid/webhook/:wid/delivery?status=dead
+func (ah apiHandler) webhookDeliveryList(w http.ResponseWriter, r *http.Request, wh *models.Webhook) error {
+	name := r.URL.Query().Get("status")
+	if len(name) == 0 {
+		name = "dead"
+	}
+	status, ok := webhookDeliveryStatus[name]
+	if !ok {
+		return util.NewErrorf("Invalid delivery status %s", name)
+	}
+	ds, err := wh.GetDeliveries(r.Context(), status)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, webhookDeliveryListResponse{ds})
+}
+
+// POST /team/:tid/webhook/:wid/delivery/:did/redeliver
+func (ah apiHandler) webhookRedeliver(w http.ResponseWriter, r *http.Request, wh *models.Webhook, did string) error {
+	d, err := wh.Redeliver(r.Context(), did)
+	if err != nil {
+		return err
+	}
+	ah.auditLog(r, AUDIT_WEBHOOK_REDELIVER, auditObject("team", wh.Team, "webhook", wh.Id, "delivery", d.Id))
+	return jsonResponse(w, d)
+}
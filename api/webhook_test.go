@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestWebhookCreateListAndRedeliver(t *testing.T) {
+	u := loginDummyUser()
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	r, err := PostRequest(fmt.Sprintf("/team/%s/webhook", team.Id), webhookCreateRequest{"not an url"})
+	CheckErrorAndResponse(t, r, err, 400)
+	for _, internal := range []string{"http://127.0.0.1:1/hook", "http://localhost/hook", "http://169.254.169.254/latest", "http://10.0.0.1/hook", "http://[::1]/hook"} {
+		r, err = PostRequest(fmt.Sprintf("/team/%s/webhook", team.Id), webhookCreateRequest{internal})
+		CheckErrorAndResponse(t, r, err, 400)
+	}
+	r, err = PostRequest(fmt.Sprintf("/team/%s/webhook", team.Id), webhookCreateRequest{"https://hooks.example.com/hook"})
+	CheckErrorAndResponse(t, r, err, 200)
+	wh := &models.Webhook{}
+	if err := json.NewDecoder(r.Body).Decode(wh); err != nil {
+		t.Fatal(err)
+	}
+	if len(wh.Secret) == 0 {
+		t.Errorf("Expected to get the webhook signing secret")
+	}
+	r, err = GetRequest(fmt.Sprintf("/team/%s/webhook", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	wl := &webhookListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(wl); err != nil {
+		t.Fatal(err)
+	}
+	if len(wl.Webhooks) != 1 || wl.Webhooks[0].Id != wh.Id {
+		t.Fatalf("Unexpected webhook list %#v", wl.Webhooks)
+	}
+	if len(wl.Webhooks[0].Secret) > 0 {
+		t.Errorf("The webhook list leaks the signing secret")
+	}
+	if err := models.EnqueueWebhookDeliveries(getCtx(), team.Id, []byte(`{"action":"test"}`)); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest(fmt.Sprintf("/team/%s/webhook/%s/delivery?status=pending", team.Id, wh.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	dl := &webhookDeliveryListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(dl); err != nil {
+		t.Fatal(err)
+	}
+	if len(dl.Deliveries) != 1 {
+		t.Fatalf("Expected 1 pending delivery and got %d", len(dl.Deliveries))
+	}
+	d := dl.Deliveries[0]
+	if err := d.MarkFailed(getCtx(), "gone", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest(fmt.Sprintf("/team/%s/webhook/%s/delivery?status=dead", team.Id, wh.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	dl = &webhookDeliveryListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(dl); err != nil {
+		t.Fatal(err)
+	}
+	if len(dl.Deliveries) != 1 || dl.Deliveries[0].LastError != "gone" {
+		t.Fatalf("Expected the delivery to be dead: %#v", dl.Deliveries)
+	}
+	r, err = PostRequest(fmt.Sprintf("/team/%s/webhook/%s/delivery/%s/redeliver", team.Id, wh.Id, d.Id), nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	rd := &models.WebhookDelivery{}
+	if err := json.NewDecoder(r.Body).Decode(rd); err != nil {
+		t.Fatal(err)
+	}
+	if rd.Status != models.WEBHOOK_DELIVERY_PENDING || rd.Attempts != 0 {
+		t.Errorf("Delivery was not queued again: %#v", rd)
+	}
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/webhook/%s", team.Id, wh.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(fmt.Sprintf("/team/%s/webhook/%s/delivery", team.Id, wh.Id))
+	CheckErrorAndResponse(t, r, err, 404)
+}
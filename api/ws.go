@@ -56,9 +56,10 @@ func receiveWsPongs(ws *websocket.Conn) {
 type eventSender interface {
 	sendMessage([]byte) error
 	sendPing() error
+	close() error
 }
 
-func (ah apiHandler) broadcastEventListenLoop(r *http.Request, eb eventSender) error {
+func (ah apiHandler) broadcastEventListenLoop(r *http.Request, ef eventFilter, eb eventSender) error {
 	ctx := r.Context()
 	currentUser := ctxGetUser(ctx)
 	tv, err := getTeamVaultMapForUser(ctx, currentUser)
@@ -73,7 +74,9 @@ func (ah apiHandler) broadcastEventListenLoop(r *http.Request, eb eventSender) e
 	for tid, vaults := range tv {
 		verMsg.VaultVersion[tid] = map[string]uint32{}
 		for _, vault := range vaults {
-			verMsg.VaultVersion[tid][vault.Id] = vault.Version
+			if ef.matchesVault(tid, vault.Id) {
+				verMsg.VaultVersion[tid][vault.Id] = vault.Version
+			}
 		}
 	}
 	if err := json.NewEncoder(buf).Encode(verMsg); err != nil {
@@ -85,19 +88,30 @@ func (ah apiHandler) broadcastEventListenLoop(r *http.Request, eb eventSender) e
 	}
 	bChan := ah.bcast.Subscribe(r.RemoteAddr)
 	defer ah.bcast.Unsubscribe(r.RemoteAddr)
+	defer eb.close()
 	alive := true
 	for alive {
 		select {
+		case <-ah.shutdown.done:
+			alive = false
 		case <-time.After(time.Second * 30):
 			if err := eb.sendPing(); err != nil {
 				alive = false
 			}
-		case b := <-bChan:
+		case b, ok := <-bChan:
+			if !ok {
+				alive = false
+				continue
+			}
+			if !ef.matches(b.Action, b.Team, b.Vault) {
+				continue
+			}
 			vs, ok := tv[b.Team]
 			if !ok {
 				continue
 			}
-			found := false
+			//Broadcasts without a vault are for every member of the team
+			found := len(b.Vault) == 0
 			for _, v := range vs {
 				if v.Id == b.Vault {
 					found = true
@@ -127,13 +141,24 @@ func (ss webSocketSender) sendMessage(msg []byte) error {
 	return ss.ws.WriteMessage(websocket.TextMessage, msg)
 }
 
-// /ws
+func (ss webSocketSender) close() error {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+	return ss.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
+
+// /ws?events=secret&vaults=tid:vid
 func (ah apiHandler) wsSubscribe(w http.ResponseWriter, r *http.Request) error {
+	ef, err := parseEventFilter(r)
+	if err != nil {
+		return err
+	}
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return util.NewErrorFrom(err)
 	}
 	defer ws.Close()
 	go receiveWsPongs(ws)
-	return ah.broadcastEventListenLoop(r, webSocketSender{ws})
+	ah.streamOpened("ws")
+	defer ah.streamClosed("ws")
+	return ah.broadcastEventListenLoop(r, ef, webSocketSender{ws})
 }
@@ -0,0 +1,183 @@
+package cmds
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/keydotcat/keycatd/api"
+	"github.com/keydotcat/keycatd/managers"
+	"github.com/keydotcat/keycatd/models"
+	"github.com/spf13/cobra"
+)
+
+// dbContext connects to the db without the kms, for the commands that don't read the sealed columns
+func dbContext(cmd *cobra.Command) (api.Conf, *sql.DB, context.Context) {
+	cfgFile, err := cmd.Flags().GetString("config")
+	if err != nil {
+		log.Fatalf("Could not get config file: %s", err)
+	}
+	c := processConf(cfgFile)
+	db, err := sql.Open("postgres", c.DB)
+	if err != nil {
+		log.Fatalf("Could not connect to db '%s': %s", c.DB, err)
+	}
+	models.SetTxRetries(c.DBTxRetries)
+	return c, db, models.AddDBToContext(context.Background(), db)
+}
+
+func adminContext(cmd *cobra.Command) (api.Conf, *sql.DB, context.Context) {
+	c, db, ctx := dbContext(cmd)
+	if err := api.EnableSealing(db, c); err != nil {
+		log.Fatalf("%s", err)
+	}
+	return c, db, ctx
+}
+
+func adminPage(cmd *cobra.Command) (limit, offset int) {
+	flags := cmd.Flags()
+	limit, err := flags.GetInt("limit")
+	if err != nil {
+		log.Fatalf("Could not get limit: %s", err)
+	}
+	offset, err = flags.GetInt("offset")
+	if err != nil {
+		log.Fatalf("Could not get offset: %s", err)
+	}
+	return limit, offset
+}
+
+func AdminUserListCmd(cmd *cobra.Command, args []string) {
+	_, _, ctx := adminContext(cmd)
+	query, err := cmd.Flags().GetString("query")
+	if err != nil {
+		log.Fatalf("Could not get query: %s", err)
+	}
+	limit, offset := adminPage(cmd)
+	users, total, err := models.FindUsers(ctx, models.UserFilter{Query: query}, limit, offset)
+	if err != nil {
+		log.Fatalf("Could not list users: %s", err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tNAME\tCONFIRMED\tDISABLED\tADMIN")
+	for _, u := range users {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%t\t%t\n", u.Id, u.Email, u.FullName, u.ConfirmedAt.Valid, u.IsDisabled(), u.Admin)
+	}
+	tw.Flush()
+	fmt.Printf("Showing %d of %d users\n", len(users), total)
+}
+
+func adminFindUser(ctx context.Context, args []string) *models.User {
+	if len(args) != 1 {
+		log.Fatalf("Which user?")
+	}
+	u, err := models.FindUser(ctx, args[0])
+	if err != nil {
+		log.Fatalf("Could not find user %s: %s", args[0], err)
+	}
+	return u
+}
+
+func adminUserSetDisabled(cmd *cobra.Command, args []string, disabled bool) {
+	c, db, ctx := adminContext(cmd)
+	u := adminFindUser(ctx, args)
+	if err := u.SetDisabled(ctx, disabled); err != nil {
+		log.Fatalf("Could not update user %s: %s", u.Id, err)
+	}
+	if disabled {
+		sm, err := api.NewSessionMgr(c, db, managers.NewReadDBMgrPrimary(db))
+		if err != nil {
+			log.Fatalf("Could not create session manager: %s", err)
+		}
+		if err := sm.DeleteAllSessions(u.Id); err != nil {
+			log.Fatalf("Could not delete sessions for user %s: %s", u.Id, err)
+		}
+	}
+	log.Printf("User %s disabled: %t", u.Id, disabled)
+}
+
+func AdminUserDisableCmd(cmd *cobra.Command, args []string) {
+	adminUserSetDisabled(cmd, args, true)
+}
+
+func AdminUserEnableCmd(cmd *cobra.Command, args []string) {
+	adminUserSetDisabled(cmd, args, false)
+}
+
+func adminUserSetAdmin(cmd *cobra.Command, args []string, admin bool) {
+	_, _, ctx := adminContext(cmd)
+	u := adminFindUser(ctx, args)
+	if err := u.SetAdmin(ctx, admin); err != nil {
+		log.Fatalf("Could not update user %s: %s", u.Id, err)
+	}
+	log.Printf("User %s admin: %t", u.Id, admin)
+}
+
+func AdminUserPromoteCmd(cmd *cobra.Command, args []string) {
+	adminUserSetAdmin(cmd, args, true)
+}
+
+func AdminUserDemoteCmd(cmd *cobra.Command, args []string) {
+	adminUserSetAdmin(cmd, args, false)
+}
+
+func AdminTeamListCmd(cmd *cobra.Command, args []string) {
+	_, _, ctx := adminContext(cmd)
+	limit, offset := adminPage(cmd)
+	teams, err := models.FindTeams(ctx, limit, offset)
+	if err != nil {
+		log.Fatalf("Could not list teams: %s", err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tOWNER\tPRIMARY")
+	for _, t := range teams {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n", t.Id, t.Name, t.Owner, t.Primary)
+	}
+	tw.Flush()
+}
+
+func AdminInviteCreateCmd(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	tid, err := flags.GetString("team")
+	switch {
+	case err != nil:
+		log.Fatalf("Could not get team: %s", err)
+	case len(tid) == 0:
+		log.Fatalf("Which team to invite to?")
+	}
+	email, err := flags.GetString("email")
+	switch {
+	case err != nil:
+		log.Fatalf("Could not get email: %s", err)
+	case len(email) == 0:
+		log.Fatalf("Who to invite?")
+	}
+	sendMail, err := flags.GetBool("mail")
+	if err != nil {
+		log.Fatalf("Could not get mail flag: %s", err)
+	}
+	c, _, ctx := adminContext(cmd)
+	t, err := models.FindTeam(ctx, tid)
+	if err != nil {
+		log.Fatalf("Could not find team %s: %s", tid, err)
+	}
+	i, err := t.InviteByEmail(ctx, email)
+	if err != nil {
+		log.Fatalf("Could not invite %s: %s", email, err)
+	}
+	log.Printf("Invited %s to team %s", i.Email, t.Name)
+	if !sendMail {
+		return
+	}
+	owner, err := models.FindUser(ctx, t.Owner)
+	if err != nil {
+		log.Fatalf("Could not find team owner %s: %s", t.Owner, err)
+	}
+	if err := api.SendInvitationEmail(c, t, owner, i); err != nil {
+		log.Fatalf("Could not send invitation email: %s", err)
+	}
+	log.Println("Mail sent")
+}
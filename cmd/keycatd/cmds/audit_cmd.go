@@ -0,0 +1,52 @@
+package cmds
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/keydotcat/keycatd/managers"
+	"github.com/keydotcat/keycatd/models"
+	"github.com/spf13/cobra"
+)
+
+func AuditVerifyCmd(cmd *cobra.Command, args []string) {
+	pubFile, err := cmd.Flags().GetString("public-key")
+	if err != nil {
+		log.Fatalf("Could not get public key: %s", err)
+	}
+	c, _, ctx := dbContext(cmd)
+	if len(pubFile) == 0 {
+		pubFile = c.Audit.CheckpointKeyFile
+	}
+	var pub ed25519.PublicKey
+	if len(pubFile) > 0 {
+		if pub, err = managers.LoadSigningPublicKey(pubFile); err != nil {
+			log.Fatalf("%s", err)
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "No --public-key or audit.checkpoint_key_file. Only the hashes of the chain can be checked")
+	}
+	av, err := models.VerifyAuditChain(ctx, pub)
+	if err != nil {
+		log.Fatalf("Could not verify the audit chain: %s", err)
+	}
+	fmt.Printf("Checked %d chained entries (%d to %d) and %d signed checkpoints\n", av.Entries, av.First, av.Last, av.Checkpoints)
+	if av.Unchained > 0 {
+		fmt.Printf("%d entries are from before the chain and can't be verified\n", av.Unchained)
+	}
+	if av.Purged > 0 {
+		fmt.Printf("%d entries were purged by the retention policy and only their hashes are left\n", av.Purged)
+	}
+	if av.Uncovered > 0 {
+		fmt.Printf("%d entries are after the last checkpoint and are only chained\n", av.Uncovered)
+	}
+	for _, p := range av.Problems {
+		fmt.Printf("FAIL %s\n", p)
+	}
+	if !av.Valid() {
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
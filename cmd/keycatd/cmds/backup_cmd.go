@@ -0,0 +1,137 @@
+package cmds
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/keydotcat/keycatd/db"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/openpgp"
+)
+
+const backupPassphraseEnv = "KEYCATD_BACKUP_PASSPHRASE"
+
+// backupPassphrase reads the passphrase from the file in --passphrase-file or from the environment.
+// An empty passphrase means the backup is not encrypted
+func backupPassphrase(cmd *cobra.Command) []byte {
+	file, err := cmd.Flags().GetString("passphrase-file")
+	if err != nil {
+		log.Fatalf("Could not get passphrase file: %s", err)
+	}
+	if len(file) == 0 {
+		return []byte(os.Getenv(backupPassphraseEnv))
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Fatalf("Could not read passphrase file: %s", err)
+	}
+	pass := strings.TrimRight(string(data), "\r\n")
+	if len(pass) == 0 {
+		log.Fatalf("Passphrase file %s is empty", file)
+	}
+	return []byte(pass)
+}
+
+func BackupCmd(cmd *cobra.Command, args []string) {
+	out, err := cmd.Flags().GetString("out")
+	switch {
+	case err != nil:
+		log.Fatalf("Could not get output file: %s", err)
+	case len(out) == 0:
+		log.Fatalf("Where to write the backup to? Use --out")
+	}
+	pass := backupPassphrase(cmd)
+	c, sqldb, _ := dbContext(cmd)
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("Could not create backup file: %s", err)
+	}
+	var w io.WriteCloser = f
+	if len(pass) > 0 {
+		//Symmetric OpenPGP so it can also be decrypted with gpg --decrypt
+		if w, err = openpgp.SymmetricallyEncrypt(f, pass, &openpgp.FileHints{FileName: "keycatd-backup.json.gz"}, nil); err != nil {
+			os.Remove(out)
+			log.Fatalf("Could not encrypt backup: %s", err)
+		}
+	}
+	gz := gzip.NewWriter(w)
+	bh, err := db.Backup(context.Background(), sqldb, c.DBType, gz)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil && w != f {
+		err = w.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+		log.Fatalf("Could not write backup: %s", err)
+	}
+	fmt.Printf("Wrote backup at migration %d to %s (encrypted: %t)\n", bh.Migration, out, len(pass) > 0)
+}
+
+func RestoreCmd(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	in, err := flags.GetString("in")
+	switch {
+	case err != nil:
+		log.Fatalf("Could not get input file: %s", err)
+	case len(in) == 0:
+		log.Fatalf("Which backup to restore? Use --in")
+	}
+	overwrite, err := flags.GetBool("overwrite")
+	if err != nil {
+		log.Fatalf("Could not get overwrite: %s", err)
+	}
+	pass := backupPassphrase(cmd)
+	c, sqldb, _ := dbContext(cmd)
+	f, err := os.Open(in)
+	if err != nil {
+		log.Fatalf("Could not open backup: %s", err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if len(pass) > 0 {
+		tries := 0
+		md, err := openpgp.ReadMessage(f, nil, func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+			//The prompt is called again when the passphrase is wrong
+			if tries++; tries > 1 {
+				return nil, fmt.Errorf("Invalid passphrase")
+			}
+			return pass, nil
+		}, nil)
+		if err != nil {
+			log.Fatalf("Could not decrypt backup: %s", err)
+		}
+		r = md.UnverifiedBody
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		log.Fatalf("Could not read backup. Is it encrypted? %s", err)
+	}
+	m := db.NewMigrateMgr(sqldb, c.DBType)
+	if err := m.LoadMigrations(); err != nil {
+		log.Fatalf("Could not load migrations: %s", err)
+	}
+	//A brand new db gets the schema before loading the data
+	if lid, err := m.GetLastMigrationInstalled(); err != nil {
+		log.Fatalf("Could not check migrations: %s", err)
+	} else if lid == 0 {
+		if _, _, err := m.ApplyRequiredMigrations(); err != nil {
+			log.Fatalf("Could not create the schema: %s", err)
+		}
+	}
+	bh, err := db.Restore(context.Background(), sqldb, c.DBType, gz, overwrite)
+	if err != nil {
+		log.Fatalf("Could not restore backup: %s", err)
+	}
+	fmt.Printf("Restored backup taken at %s by keycatd %s\n", bh.CreatedAt.Format("2006-01-02 15:04:05 MST"), bh.ServerVersion)
+}
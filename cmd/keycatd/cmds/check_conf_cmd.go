@@ -0,0 +1,39 @@
+package cmds
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/keydotcat/keycatd/api"
+	"github.com/spf13/cobra"
+)
+
+const (
+	checkConfExitLoad        = 1
+	checkConfExitInvalid     = 2
+	checkConfExitUnreachable = 3
+)
+
+func CheckConfCmd(cmd *cobra.Command, args []string) {
+	cfgFile, err := cmd.Flags().GetString("config")
+	if err != nil {
+		log.Fatalf("Could not get config file: %s", err)
+	}
+	c, err := loadConf(cfgFile)
+	if err != nil {
+		fmt.Printf("FAIL load: %s\n", err)
+		os.Exit(checkConfExitLoad)
+	}
+	exit := 0
+	for _, check := range api.CheckConf(c) {
+		fmt.Println(check)
+		switch {
+		case check.Status == api.CONF_CHECK_INVALID:
+			exit = checkConfExitInvalid
+		case check.Status == api.CONF_CHECK_UNREACHABLE && exit == 0:
+			exit = checkConfExitUnreachable
+		}
+	}
+	os.Exit(exit)
+}
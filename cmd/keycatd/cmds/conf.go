@@ -2,12 +2,29 @@ package cmds
 
 import (
 	"log"
+	"strings"
 
 	"github.com/keydotcat/keycatd/api"
 	"github.com/spf13/viper"
 )
 
+// Shorter names for the most common options in container deployments
+var confEnvAliases = map[string]string{
+	"db":                 "KEYCATD_DB_URL",
+	"mail.smtp.server":   "KEYCATD_SMTP_HOST",
+	"mail.smtp.user":     "KEYCATD_SMTP_USER",
+	"mail.smtp.password": "KEYCATD_SMTP_PASSWORD",
+}
+
 func processConf(cfgFile string) api.Conf {
+	c, err := loadConf(cfgFile)
+	if err != nil {
+		log.Fatalf("Fatal error while loading config file: %s \n", err)
+	}
+	return c
+}
+
+func loadConf(cfgFile string) (api.Conf, error) {
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
 	} else {
@@ -18,9 +35,21 @@ func processConf(cfgFile string) api.Conf {
 	viper.SetDefault("port", 27623)
 	viper.SetDefault("url", "http://localhost:27623")
 	viper.SetDefault("db", "keycat")
-	viper.SetDefault("db.maxconns", 0)
+	viper.SetDefault("db.maxconns", 25)
+	viper.SetDefault("db.max_idle_conns", 10)
+	viper.SetDefault("db.conn_max_lifetime", 1800)
+	viper.SetDefault("db.statement_timeout", 0)
+	viper.SetDefault("db.replica", "")
 	viper.SetDefault("db.type", "postgresql")
+	viper.SetDefault("db.tx_retries", 0)
+	viper.SetDefault("db.query_timeout", 30)
+	viper.SetDefault("auto_migrate", true)
 	viper.SetDefault("only_invited", false)
+	viper.SetDefault("reject_plaintext", false)
+	viper.SetDefault("shutdown_timeout", 30)
+	viper.SetDefault("proxy_mode", false)
+	viper.SetDefault("trusted_proxies", []string{})
+	viper.SetDefault("log_level", "info")
 	viper.SetDefault("csrf.hash_key", "")
 	viper.SetDefault("csrf.block_key", "")
 	viper.SetDefault("session.redis.server", "")
@@ -31,25 +60,199 @@ func processConf(cfgFile string) api.Conf {
 	viper.SetDefault("mail.smtp.password", "")
 	viper.SetDefault("mail.sparkpost.key", "")
 	viper.SetDefault("mail.sparkpost.eu", false)
+	viper.SetDefault("metrics.port", 0)
+	viper.SetDefault("metrics.token", "")
+	viper.SetDefault("idp_hooks.token", "")
+	viper.SetDefault("oidc.key_file", "")
+	viper.SetDefault("keylog.key_file", "")
+	viper.SetDefault("kms.provider", "")
+	viper.SetDefault("kms.key_file", "")
+	viper.SetDefault("kms.vault.address", "")
+	viper.SetDefault("kms.vault.token", "")
+	viper.SetDefault("kms.vault.mount", "transit")
+	viper.SetDefault("kms.vault.key", "")
+	viper.SetDefault("sentry.dsn", "")
+	viper.SetDefault("sentry.environment", "")
+	viper.SetDefault("sentry.report_errors", false)
+	viper.SetDefault("audit.retention_days", 0)
+	viper.SetDefault("audit.syslog.address", "")
+	viper.SetDefault("audit.syslog.network", "tcp")
+	viper.SetDefault("audit.syslog.format", "json")
+	viper.SetDefault("audit.checkpoint_key_file", "")
+	viper.SetDefault("retention.login_attempt_days", 0)
+	viper.SetDefault("retention.secret_version_days", 0)
+	viper.SetDefault("retention.webhook_delivery_days", 0)
+	viper.SetDefault("cleanup.token_retention_days", 30)
+	viper.SetDefault("cleanup.session_retention_days", 90)
+	viper.SetDefault("token_lifetimes.verification_hours", 72)
+	viper.SetDefault("token_lifetimes.invite_days", 30)
+	viper.SetDefault("login_policy.delay_after", 3)
+	viper.SetDefault("login_policy.delay_seconds", 5)
+	viper.SetDefault("login_policy.captcha_after", 0)
+	viper.SetDefault("login_policy.lockout_after", 10)
+	viper.SetDefault("login_policy.lockout_minutes", 15)
+	viper.SetDefault("login_policy.reset_hours", 24)
+	viper.SetDefault("login_policy.captcha.verify_url", "")
+	viper.SetDefault("login_policy.captcha.secret", "")
+	viper.SetDefault("cache.user_ttl", 5)
+	viper.SetDefault("cache.session_write_interval", 60)
+	viper.SetDefault("cors.allowed_origins", []string{"*"})
+	viper.SetDefault("cors.allowed_headers", []string{"*"})
+	viper.SetDefault("cors.exposed_headers", []string{"X-Request-Id", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "Deprecation", "Sunset", "Link", "X-Next-Cursor"})
+	viper.SetDefault("cors.allow_credentials", true)
+	viper.SetDefault("cors.max_age", 0)
+	viper.SetDefault("tls.cert_file", "")
+	viper.SetDefault("tls.key_file", "")
+	viper.SetDefault("tls.acme.domains", []string{})
+	viper.SetDefault("tls.acme.email", "")
+	viper.SetDefault("tls.acme.cache_dir", "")
+	viper.SetDefault("tls.acme.http_port", 80)
+	viper.SetDefault("blocklist.auto_route", "/api/auth/login")
+	viper.SetDefault("blocklist.auto_trips", 5)
+	viper.SetDefault("blocklist.auto_window", 3600)
+	viper.SetDefault("blocklist.auto_duration", 3600)
+	viper.SetDefault("cluster.enabled", false)
+	viper.SetDefault("cluster.broker", api.CLUSTER_BROKER_POSTGRES)
+	viper.SetDefault("cluster.redis.server", "")
+	viper.SetDefault("ratelimit.redis.server", "")
+	viper.SetDefault("ratelimit.redis.db_id", 0)
+	viper.SetDefault("web.enabled", true)
+	viper.SetDefault("web.dir", "")
+	viper.SetDefault("web.csp", "")
+	//Every option can be set with KEYCATD_ and the option name in upper case with dots replaced by underscores.
+	//Environment variables take precedence over the config file that takes precedence over the defaults
 	viper.SetEnvPrefix("KEYCATD")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
-	err := viper.ReadInConfig()
-	if err != nil {
-		log.Fatalf("Fatal error while loading config file: %s \n", err)
+	for key, env := range confEnvAliases {
+		viper.BindEnv(key, env)
 	}
 	c := api.Conf{}
+	if err := viper.ReadInConfig(); err != nil {
+		//Without an explicit config file everything can come from the environment
+		if _, notFound := err.(viper.ConfigFileNotFoundError); !notFound || cfgFile != "" {
+			return c, err
+		}
+		log.Printf("No config file found. Using only the environment and defaults")
+	}
 	c.Url = viper.GetString("url")
 	c.Port = viper.GetInt("port")
 	c.DB = viper.GetString("db")
+	c.DBReplica = viper.GetString("db.replica")
 	c.DBType = viper.GetString("db.type")
 	if len(c.DBType) == 0 {
 		c.DBType = "postgresql"
 	}
 	c.DBMaxConns = viper.GetInt("db.maxconns")
+	c.DBMaxIdleConns = viper.GetInt("db.max_idle_conns")
+	c.DBConnMaxLifetime = viper.GetInt("db.conn_max_lifetime")
+	c.DBStatementTimeout = viper.GetInt("db.statement_timeout")
+	c.DBTxRetries = viper.GetInt("db.tx_retries")
+	c.DBQueryTimeout = viper.GetInt("db.query_timeout")
+	c.DBSkipMigrations = !viper.GetBool("auto_migrate")
 	c.OnlyInvited = viper.GetBool("only_invited")
+	c.RejectPlaintext = viper.GetBool("reject_plaintext")
+	c.ShutdownTimeout = viper.GetInt("shutdown_timeout")
+	c.ProxyMode = viper.GetBool("proxy_mode")
+	c.TrustedProxies = viper.GetStringSlice("trusted_proxies")
+	c.LogLevel = viper.GetString("log_level")
 	c.MailFrom = viper.GetString("mail.from")
 	c.Csrf.HashKey = viper.GetString("csrf.hash_key")
 	c.Csrf.BlockKey = viper.GetString("csrf.block_key")
+	if err := viper.UnmarshalKey("csrf.previous_keys", &c.Csrf.PreviousKeys); err != nil {
+		return c, err
+	}
+	c.Metrics.Port = viper.GetInt("metrics.port")
+	c.Metrics.Token = viper.GetString("metrics.token")
+	c.IdpHooks.Token = viper.GetString("idp_hooks.token")
+	c.OIDC.KeyFile = viper.GetString("oidc.key_file")
+	if err := viper.UnmarshalKey("oidc.clients", &c.OIDC.Clients); err != nil {
+		return c, err
+	}
+	c.KMS = readKMSConf(viper.GetViper())
+	c.KeyLog.KeyFile = viper.GetString("keylog.key_file")
+	c.Sentry.DSN = viper.GetString("sentry.dsn")
+	c.Sentry.Environment = viper.GetString("sentry.environment")
+	c.Sentry.ReportErrors = viper.GetBool("sentry.report_errors")
+	c.Audit.RetentionDays = viper.GetInt("audit.retention_days")
+	c.Audit.CheckpointKeyFile = viper.GetString("audit.checkpoint_key_file")
+	if addr := viper.GetString("audit.syslog.address"); len(addr) > 0 {
+		c.Audit.Syslog = &api.ConfAuditSyslog{
+			Network: viper.GetString("audit.syslog.network"),
+			Address: addr,
+			Format:  viper.GetString("audit.syslog.format"),
+		}
+	}
+	c.Retention.LoginAttemptDays = viper.GetInt("retention.login_attempt_days")
+	c.Retention.SecretVersionDays = viper.GetInt("retention.secret_version_days")
+	c.Retention.WebhookDeliveryDays = viper.GetInt("retention.webhook_delivery_days")
+	c.Cleanup.TokenRetentionDays = viper.GetInt("cleanup.token_retention_days")
+	c.Cleanup.SessionRetentionDays = viper.GetInt("cleanup.session_retention_days")
+	c.TokenLifetimes.VerificationHours = viper.GetInt("token_lifetimes.verification_hours")
+	c.TokenLifetimes.InviteDays = viper.GetInt("token_lifetimes.invite_days")
+	c.LoginPolicy = api.ConfLoginPolicy{
+		DelayAfter:     viper.GetInt("login_policy.delay_after"),
+		DelaySeconds:   viper.GetInt("login_policy.delay_seconds"),
+		CaptchaAfter:   viper.GetInt("login_policy.captcha_after"),
+		LockoutAfter:   viper.GetInt("login_policy.lockout_after"),
+		LockoutMinutes: viper.GetInt("login_policy.lockout_minutes"),
+		ResetHours:     viper.GetInt("login_policy.reset_hours"),
+		Captcha: api.ConfCaptcha{
+			VerifyUrl: viper.GetString("login_policy.captcha.verify_url"),
+			Secret:    viper.GetString("login_policy.captcha.secret"),
+		},
+	}
+	c.Cache.UserTTL = viper.GetInt("cache.user_ttl")
+	c.Cache.SessionWriteInterval = viper.GetInt("cache.session_write_interval")
+	if err := viper.UnmarshalKey("ratelimit.rules", &c.RateLimit.Rules); err != nil {
+		return c, err
+	}
+	c.Blocklist = api.ConfBlocklist{
+		AutoRoute:    viper.GetString("blocklist.auto_route"),
+		AutoTrips:    viper.GetInt("blocklist.auto_trips"),
+		AutoWindow:   viper.GetInt("blocklist.auto_window"),
+		AutoDuration: viper.GetInt("blocklist.auto_duration"),
+	}
+	c.Cors = api.ConfCors{
+		AllowedOrigins:   viper.GetStringSlice("cors.allowed_origins"),
+		AllowedHeaders:   viper.GetStringSlice("cors.allowed_headers"),
+		ExposedHeaders:   viper.GetStringSlice("cors.exposed_headers"),
+		AllowCredentials: viper.GetBool("cors.allow_credentials"),
+		MaxAge:           viper.GetInt("cors.max_age"),
+	}
+	if domains := viper.GetStringSlice("tls.acme.domains"); len(domains) > 0 {
+		c.TLS = &api.ConfTLS{ACME: &api.ConfTLSACME{
+			Domains:  domains,
+			Email:    viper.GetString("tls.acme.email"),
+			CacheDir: viper.GetString("tls.acme.cache_dir"),
+			HTTPPort: viper.GetInt("tls.acme.http_port"),
+		}}
+	}
+	if cert, key := viper.GetString("tls.cert_file"), viper.GetString("tls.key_file"); len(cert) > 0 || len(key) > 0 {
+		if c.TLS == nil {
+			c.TLS = &api.ConfTLS{}
+		}
+		c.TLS.CertFile, c.TLS.KeyFile = cert, key
+	}
+	c.JobSchedules = viper.GetStringMapString("jobs.schedules")
+	c.ClientVersions = viper.GetStringMapString("clients.min_versions")
+	if err := viper.UnmarshalKey("body_limits", &c.BodyLimits); err != nil {
+		return c, err
+	}
+	c.Web = api.ConfWeb{
+		Disabled: !viper.GetBool("web.enabled"),
+		Dir:      viper.GetString("web.dir"),
+		CSP:      viper.GetString("web.csp"),
+	}
+	if viper.GetBool("cluster.enabled") {
+		c.Cluster = &api.ConfCluster{Broker: viper.GetString("cluster.broker")}
+		if srv := viper.GetString("cluster.redis.server"); len(srv) > 0 {
+			c.Cluster.Redis = &api.ConfSessionRedis{Server: srv}
+		}
+	}
+	if srv := viper.GetString("ratelimit.redis.server"); len(srv) > 0 {
+		c.RateLimit.Redis = &api.ConfSessionRedis{Server: srv, DBId: viper.GetInt("ratelimit.redis.db_id")}
+	}
 	if len(viper.GetString("mail.smtp.server")) > 0 {
 		c.MailSMTP = &api.ConfMailSMTP{
 			Server:   viper.GetString("mail.smtp.server"),
@@ -66,5 +269,27 @@ func processConf(cfgFile string) api.Conf {
 	if srv := viper.GetString("session.redis.server"); len(srv) > 0 {
 		c.SessionRedis = &api.ConfSessionRedis{srv, viper.GetInt("session.redis.db_id")}
 	}
-	return c
+	return c, nil
+}
+
+// readKMSConf reads the kms section. It's separate so keycatd kms rewrap can read the one of the previous config
+func readKMSConf(v *viper.Viper) *api.ConfKMS {
+	provider := v.GetString("kms.provider")
+	if len(provider) == 0 {
+		return nil
+	}
+	ck := &api.ConfKMS{
+		Provider: provider,
+		KeyFile:  v.GetString("kms.key_file"),
+		Vault: api.ConfKMSVault{
+			Address: v.GetString("kms.vault.address"),
+			Token:   v.GetString("kms.vault.token"),
+			Mount:   v.GetString("kms.vault.mount"),
+			Key:     v.GetString("kms.vault.key"),
+		},
+	}
+	if len(ck.Vault.Mount) == 0 {
+		ck.Vault.Mount = "transit"
+	}
+	return ck
 }
@@ -0,0 +1,30 @@
+package cmds
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/spf13/cobra"
+)
+
+func KeyLogVerifyCmd(cmd *cobra.Command, args []string) {
+	_, _, ctx := dbContext(cmd)
+	klt, err := models.LoadKeyLogTree(ctx)
+	if err != nil {
+		log.Fatalf("Could not load the key log: %s", err)
+	}
+	fmt.Printf("The key log has %d entries with root %x\n", klt.Size(), klt.Root(klt.Size()))
+	users, err := models.FindKeyLogMismatches(ctx)
+	if err != nil {
+		log.Fatalf("Could not compare the key log with the users: %s", err)
+	}
+	for _, uid := range users {
+		fmt.Printf("FAIL The public key of %s is not the last one in the key log\n", uid)
+	}
+	if len(users) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
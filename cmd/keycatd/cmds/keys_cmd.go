@@ -0,0 +1,57 @@
+package cmds
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/keydotcat/keycatd/api"
+	"github.com/keydotcat/keycatd/util"
+	"github.com/spf13/cobra"
+)
+
+// printCsrfConf writes the csrf section so it can replace the one in the config file
+func printCsrfConf(cc api.ConfCsrf) {
+	fmt.Printf("[csrf]\n\thash_key = %q\n\tblock_key = %q\n", cc.HashKey, cc.BlockKey)
+	for _, key := range cc.PreviousKeys {
+		fmt.Printf("\t[[csrf.previous_keys]]\n\t\thash_key = %q\n\t\tblock_key = %q\n", key.HashKey, key.BlockKey)
+	}
+}
+
+func KeysRotateCmd(cmd *cobra.Command, args []string) {
+	cfgFile, err := cmd.Flags().GetString("config")
+	if err != nil {
+		log.Fatalf("Could not get config file: %s", err)
+	}
+	c := processConf(cfgFile)
+	cc := api.ConfCsrf{
+		HashKey:      util.GenerateRandomToken(32),
+		BlockKey:     util.GenerateRandomToken(32),
+		PreviousKeys: append([]api.ConfCsrfKey{{HashKey: c.Csrf.HashKey, BlockKey: c.Csrf.BlockKey}}, c.Csrf.PreviousKeys...),
+	}
+	printCsrfConf(cc)
+	fmt.Fprintln(os.Stderr, "Replace the csrf section of the config with this and restart the instances. Sessions are kept and the csrf cookies")
+	fmt.Fprintln(os.Stderr, "of the previous keys are signed again with the new one when they are used. Retire the previous keys afterwards")
+	fmt.Fprintln(os.Stderr, "with keycatd keys retire. Add the new key to csrf.previous_keys of every instance first to rotate a cluster")
+}
+
+func KeysRetireCmd(cmd *cobra.Command, args []string) {
+	cfgFile, err := cmd.Flags().GetString("config")
+	if err != nil {
+		log.Fatalf("Could not get config file: %s", err)
+	}
+	keep, err := cmd.Flags().GetInt("keep")
+	if err != nil {
+		log.Fatalf("Could not get keep: %s", err)
+	}
+	c := processConf(cfgFile)
+	if keep < 0 {
+		log.Fatalf("Invalid keep %d", keep)
+	}
+	if keep < len(c.Csrf.PreviousKeys) {
+		c.Csrf.PreviousKeys = c.Csrf.PreviousKeys[:keep]
+	}
+	printCsrfConf(c.Csrf)
+	fmt.Fprintln(os.Stderr, "Replace the csrf section of the config with this and restart the instances. Cookies signed with the retired keys")
+	fmt.Fprintln(os.Stderr, "only get a new csrf token")
+}
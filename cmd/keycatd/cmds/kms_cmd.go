@@ -0,0 +1,82 @@
+package cmds
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/keydotcat/keycatd/api"
+	"github.com/keydotcat/keycatd/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func kmsContext(cmd *cobra.Command) (api.Conf, context.Context) {
+	c, _, ctx := adminContext(cmd)
+	if c.KMS == nil {
+		log.Fatalf("There is no kms configured. Set kms.provider first")
+	}
+	return c, ctx
+}
+
+func KMSNewDataKeyCmd(cmd *cobra.Command, args []string) {
+	_, ctx := kmsContext(cmd)
+	dk, err := models.NewDataKey(ctx)
+	if err != nil {
+		log.Fatalf("Could not create data key: %s", err)
+	}
+	fmt.Printf("Created data key %s. Restart the instances to seal with it and run keycatd kms reseal to move the existing values\n", dk.Id)
+}
+
+func KMSRewrapCmd(cmd *cobra.Command, args []string) {
+	fromFile, err := cmd.Flags().GetString("from-config")
+	if err != nil {
+		log.Fatalf("Could not get from-config: %s", err)
+	}
+	if len(fromFile) == 0 {
+		log.Fatalf("Which config has the previous kms? Use --from-config")
+	}
+	v := viper.New()
+	v.SetConfigFile(fromFile)
+	if err := v.ReadInConfig(); err != nil {
+		log.Fatalf("Could not read %s: %s", fromFile, err)
+	}
+	fc := readKMSConf(v)
+	if fc == nil {
+		log.Fatalf("There is no kms.provider in %s", fromFile)
+	}
+	from, err := fc.KeyWrapper()
+	if err != nil {
+		log.Fatalf("Invalid kms in %s: %s", fromFile, err)
+	}
+	//The current data keys are still wrapped with the previous kms so they can't be unwrapped on start
+	c, _, ctx := dbContext(cmd)
+	if c.KMS == nil {
+		log.Fatalf("There is no kms configured. Set kms.provider first")
+	}
+	to, err := c.KMS.KeyWrapper()
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+	n, err := models.RewrapDataKeys(ctx, from, to)
+	if err != nil {
+		log.Fatalf("Rewrapped %d data keys before failing: %s", n, err)
+	}
+	fmt.Printf("Rewrapped %d data keys with the %s kms\n", n, to.Name())
+}
+
+func KMSResealCmd(cmd *cobra.Command, args []string) {
+	retire, err := cmd.Flags().GetBool("retire")
+	if err != nil {
+		log.Fatalf("Could not get retire: %s", err)
+	}
+	_, ctx := kmsContext(cmd)
+	n, err := models.ResealColumns(ctx, retire)
+	if err != nil {
+		log.Fatalf("Resealed %d values before failing: %s", n, err)
+	}
+	fmt.Printf("Resealed %d values with the current data key\n", n)
+	if retire {
+		fmt.Println("Removed the previous data keys")
+	}
+}
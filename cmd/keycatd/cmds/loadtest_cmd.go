@@ -0,0 +1,63 @@
+package cmds
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/keydotcat/keycatd/loadtest"
+	"github.com/keydotcat/keycatd/models"
+	"github.com/spf13/cobra"
+)
+
+func LoadTestCmd(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	lc := loadtest.Config{}
+	var err error
+	get := func(name string, dest interface{}) {
+		switch d := dest.(type) {
+		case *string:
+			*d, err = flags.GetString(name)
+		case *int:
+			*d, err = flags.GetInt(name)
+		case *float64:
+			*d, err = flags.GetFloat64(name)
+		}
+		if err != nil {
+			log.Fatalf("Could not get %s: %s", name, err)
+		}
+	}
+	get("url", &lc.Url)
+	get("users", &lc.Users)
+	get("teams", &lc.TeamsPerUser)
+	get("vaults", &lc.VaultsPerTeam)
+	get("secrets", &lc.SecretsPerVault)
+	get("workers", &lc.Workers)
+	get("read-ratio", &lc.ReadRatio)
+	if lc.Duration, err = flags.GetDuration("duration"); err != nil {
+		log.Fatalf("Could not get duration: %s", err)
+	}
+	//The confirmation mails can't be read so the users are confirmed directly in the db of the instance
+	_, _, ctx := adminContext(cmd)
+	lc.Confirm = func(uid string) error {
+		u, err := models.FindUser(ctx, uid)
+		if err != nil {
+			return err
+		}
+		t, err := u.GetVerificationToken(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = t.ConfirmEmail(ctx)
+		return err
+	}
+	log.Printf("Setting up %d users with %d teams of %d vaults with %d secrets each", lc.Users, lc.TeamsPerUser, lc.VaultsPerTeam, lc.SecretsPerVault)
+	res, err := loadtest.Run(lc)
+	if err != nil {
+		log.Fatalf("Load test failed: %s", err)
+	}
+	fmt.Printf("Setup took %s\n", res.Setup.Elapsed)
+	res.Setup.Print(os.Stdout)
+	fmt.Printf("\nMix of %.0f%% reads with %d workers for %s\n", lc.ReadRatio*100, lc.Workers, res.Mix.Elapsed)
+	res.Mix.Print(os.Stdout)
+}
@@ -0,0 +1,76 @@
+package cmds
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/keydotcat/keycatd/db"
+	"github.com/spf13/cobra"
+)
+
+func migrateMgr(cmd *cobra.Command) *db.MigrateMgr {
+	c, sqldb, _ := dbContext(cmd)
+	m := db.NewMigrateMgr(sqldb, c.DBType)
+	if err := m.LoadMigrations(); err != nil {
+		log.Fatalf("Could not load migrations: %s", err)
+	}
+	return m
+}
+
+func MigrateUpCmd(cmd *cobra.Command, args []string) {
+	lid, ap, err := migrateMgr(cmd).ApplyRequiredMigrations()
+	if err != nil {
+		log.Fatalf("Could not apply migrations: %s", err)
+	}
+	fmt.Printf("Applied %d migrations. Last installed is %d\n", ap, lid)
+}
+
+func MigrateDownCmd(cmd *cobra.Command, args []string) {
+	steps, err := cmd.Flags().GetInt("steps")
+	if err != nil {
+		log.Fatalf("Could not get steps: %s", err)
+	}
+	m := migrateMgr(cmd)
+	for i := 0; i < steps; i++ {
+		lid, err := m.RevertLastMigration()
+		if err != nil {
+			log.Fatalf("Could not revert migration: %s", err)
+		}
+		fmt.Printf("Reverted migration. Last installed is %d\n", lid)
+	}
+}
+
+func MigrateStatusCmd(cmd *cobra.Command, args []string) {
+	status, err := migrateMgr(cmd).Status()
+	if err != nil {
+		log.Fatalf("Could not get migration status: %s", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tAPPLIED\tAPPLIED AT\tREVERTIBLE")
+	for _, s := range status {
+		at := ""
+		if s.Applied {
+			at = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%t\t%s\t%t\n", s.Id, s.Applied, at, s.Revertible)
+	}
+	w.Flush()
+}
+
+func MigrateForceCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		log.Fatalf("Which migration id to force?")
+	}
+	mid, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Fatalf("Invalid migration id %s", args[0])
+	}
+	if err := migrateMgr(cmd).ForceMigration(mid); err != nil {
+		log.Fatalf("Could not force migration: %s", err)
+	}
+	fmt.Printf("Migrations table now marks %d as the last installed migration\n", mid)
+}
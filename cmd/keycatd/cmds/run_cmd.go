@@ -1,9 +1,14 @@
 package cmds
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/keydotcat/keycatd/api"
@@ -11,17 +16,61 @@ import (
 	"github.com/spf13/cobra"
 )
 
-func runServer(c api.Conf) {
-	apiHandler, err := api.NewAPIHandler(c)
+type reloadableHandler interface {
+	http.Handler
+	Reload(api.Conf) ([]string, error)
+	Shutdown(context.Context) error
+}
+
+func reloadServer(cfgFile string, current api.Conf, ah reloadableHandler) api.Conf {
+	c, err := loadConf(cfgFile)
 	if err != nil {
-		log.Fatalf("Could not parse configuration: %s", err)
+		log.Printf("[ERROR] Could not reload the configuration: %s", err)
+		return current
+	}
+	pending, err := ah.Reload(c)
+	if err != nil {
+		log.Printf("[ERROR] Could not reload the configuration: %s", err)
+		return current
 	}
-	handler := cors.New(cors.Options{
-		AllowOriginFunc:  func(origin string) bool { return true },
+	if len(pending) > 0 {
+		log.Printf("Reloaded the configuration. Restart is required to apply: %s", strings.Join(pending, ", "))
+	} else {
+		log.Printf("Reloaded the configuration")
+	}
+	return c
+}
+
+func newCorsHandler(cc api.ConfCors, h http.Handler) http.Handler {
+	opts := cors.Options{
+		AllowedOrigins:   cc.AllowedOrigins,
 		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodDelete, http.MethodPatch, http.MethodHead, http.MethodPut},
-		AllowedHeaders:   []string{"*"},
-		AllowCredentials: true,
-	}).Handler(apiHandler)
+		AllowedHeaders:   cc.AllowedHeaders,
+		ExposedHeaders:   cc.ExposedHeaders,
+		AllowCredentials: cc.AllowCredentials,
+		MaxAge:           cc.MaxAge,
+	}
+	if cc.AllowCredentials {
+		for _, origin := range cc.AllowedOrigins {
+			if origin == "*" {
+				//Browsers reject a wildcard with credentials so the origin is echoed back instead
+				opts.AllowedOrigins = nil
+				opts.AllowOriginFunc = func(string) bool { return true }
+				log.Printf("CORS allows any origin to make requests with credentials. Set cors.allowed_origins to restrict it")
+				break
+			}
+		}
+	}
+	return cors.New(opts).Handler(h)
+}
+
+func runServer(cfgFile string, c api.Conf) {
+	h, err := api.NewAPIHandler(c)
+	if err != nil {
+		log.Fatalf("Could not parse configuration: %s", err)
+	}
+	apiHandler := h.(reloadableHandler)
+	handler := newCorsHandler(c.Cors, apiHandler)
 	s := &http.Server{
 		Addr:           fmt.Sprintf(":%d", c.Port),
 		Handler:        handler,
@@ -29,8 +78,48 @@ func runServer(c api.Conf) {
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
-	log.Printf("Listening at %s", s.Addr)
-	log.Fatal(s.ListenAndServe())
+	var challenges *http.Server
+	if c.TLS != nil {
+		if s.TLSConfig, challenges, err = newTLSConfig(c); err != nil {
+			log.Fatalf("Could not configure tls: %s", err)
+		}
+		if challenges != nil {
+			go serveACMEChallenges(challenges)
+		}
+	}
+	go func() {
+		log.Printf("Listening at %s (tls: %t)", s.Addr, s.TLSConfig != nil)
+		var err error
+		if s.TLSConfig != nil {
+			//The certificates come from the tls config
+			err = s.ListenAndServeTLS("", "")
+		} else {
+			err = s.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	sig := <-sigs
+	for ; sig == syscall.SIGHUP; sig = <-sigs {
+		c = reloadServer(cfgFile, c, apiHandler)
+	}
+	log.Printf("Received %s. Shutting down with a deadline of %d seconds", sig, c.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.ShutdownTimeout)*time.Second)
+	defer cancel()
+	//Stop accepting connections and wait for the in-flight requests
+	if err := s.Shutdown(ctx); err != nil {
+		log.Printf("[ERROR] Not all connections were drained: %s", err)
+	}
+	if challenges != nil {
+		challenges.Shutdown(ctx)
+	}
+	if err := apiHandler.Shutdown(ctx); err != nil {
+		log.Printf("[ERROR] Could not shut down cleanly: %s", err)
+	}
+	log.Printf("Shut down")
 }
 
 func RunCmd(cmd *cobra.Command, args []string) {
@@ -41,5 +130,5 @@ func RunCmd(cmd *cobra.Command, args []string) {
 		return
 	}
 	c := processConf(cfgFile)
-	runServer(c)
+	runServer(cfgFile, c)
 }
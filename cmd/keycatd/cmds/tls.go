@@ -0,0 +1,53 @@
+package cmds
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/keydotcat/keycatd/api"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newTLSConfig returns the tls configuration for the main listener and, when ACME needs to answer
+// HTTP-01 challenges, the server that has to listen on the http port
+func newTLSConfig(c api.Conf) (*tls.Config, *http.Server, error) {
+	if c.TLS.ACME == nil {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not load the tls certificate: %s", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil, nil
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(c.TLS.ACME.CacheDir),
+		HostPolicy: autocert.HostWhitelist(c.TLS.ACME.Domains...),
+		Email:      c.TLS.ACME.Email,
+	}
+	//The manager config already answers TLS-ALPN-01 challenges in the main listener
+	tc := m.TLSConfig()
+	tc.MinVersion = tls.VersionTLS12
+	tc.NextProtos = append([]string{"h2", "http/1.1"}, acme.ALPNProto)
+	if c.TLS.ACME.HTTPPort == 0 {
+		return tc, nil, nil
+	}
+	//Anything besides the HTTP-01 challenges is redirected to https
+	hs := &http.Server{
+		Addr:         fmt.Sprintf(":%d", c.TLS.ACME.HTTPPort),
+		Handler:      m.HTTPHandler(nil),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	return tc, hs, nil
+}
+
+func serveACMEChallenges(hs *http.Server) {
+	log.Printf("Answering ACME challenges at %s", hs.Addr)
+	if err := hs.ListenAndServe(); err != http.ErrServerClosed {
+		log.Printf("[ERROR] ACME challenge listener stopped: %s", err)
+	}
+}
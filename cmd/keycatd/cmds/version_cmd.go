@@ -2,6 +2,7 @@ package cmds
 
 import (
 	"log"
+	"time"
 
 	"github.com/keydotcat/keycatd/util"
 	"github.com/spf13/cobra"
@@ -9,4 +10,5 @@ import (
 
 func VersionCmd(cmd *cobra.Command, args []string) {
 	log.Printf("Keycat server version is %s (web %s)", util.GetServerVersion(), util.GetWebVersion())
+	log.Printf("Built from %s on %s. Supports clients from %s", util.GetVersion(), util.GetBuildDate().Format(time.RFC3339), util.MIN_CLIENT_VERSION)
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/keydotcat/keycatd/cmd/keycatd/cmds"
 	"github.com/spf13/cobra"
 )
@@ -21,11 +23,226 @@ func main() {
 	testMailCmd.Flags().String("to", "", "Who to send the test mail to")
 	rootCmd.AddCommand(testMailCmd)
 
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "check-config",
+		Short: "Validate the configuration and check that the db and mail server are reachable",
+		Long: `Validate the configuration and check that the db, mail server and session store are reachable.
+Exits with 0 if everything is fine, 1 if the configuration could not be loaded,
+2 if there are invalid settings and 3 if a service could not be reached`,
+		Run: cmds.CheckConfCmd,
+	})
+
+	var migrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage the db schema migrations embedded in the binary",
+	}
+	migrateCmd.AddCommand(&cobra.Command{
+		Use:   "up",
+		Short: "Apply all pending migrations",
+		Run:   cmds.MigrateUpCmd,
+	})
+	var migrateDownCmd = &cobra.Command{
+		Use:   "down",
+		Short: "Revert the last installed migrations",
+		Run:   cmds.MigrateDownCmd,
+	}
+	migrateDownCmd.Flags().Int("steps", 1, "How many migrations to revert")
+	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "List the migrations and whether they are installed",
+		Run:   cmds.MigrateStatusCmd,
+	})
+	migrateCmd.AddCommand(&cobra.Command{
+		Use:   "force <id>",
+		Short: "Mark migrations up to id as installed without running them",
+		Run:   cmds.MigrateForceCmd,
+	})
+	rootCmd.AddCommand(migrateCmd)
+
+	var backupCmd = &cobra.Command{
+		Use:   "backup",
+		Short: "Write a consistent snapshot of the db to a file",
+		Long: `Write a consistent snapshot of every table to a gzipped file.
+If a passphrase is given with --passphrase-file or KEYCATD_BACKUP_PASSPHRASE the file
+is encrypted with OpenPGP and can also be decrypted with gpg --decrypt`,
+		Run: cmds.BackupCmd,
+	}
+	backupCmd.Flags().String("out", "", "File to write the backup to. It must not exist")
+	backupCmd.Flags().String("passphrase-file", "", "File with the passphrase to encrypt the backup with")
+	rootCmd.AddCommand(backupCmd)
+	var restoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Load a backup into an empty db",
+		Run:   cmds.RestoreCmd,
+	}
+	restoreCmd.Flags().String("in", "", "Backup file to restore")
+	restoreCmd.Flags().String("passphrase-file", "", "File with the passphrase the backup was encrypted with")
+	restoreCmd.Flags().Bool("overwrite", false, "Delete all existing data before restoring")
+	rootCmd.AddCommand(restoreCmd)
+
 	var versionCmd = &cobra.Command{
 		Use:   "version",
 		Short: "Print the keycatd version",
 		Run:   cmds.VersionCmd,
 	}
 	rootCmd.AddCommand(versionCmd)
+
+	var loadTestCmd = &cobra.Command{
+		Use:   "loadtest",
+		Short: "Register users, fill their vaults and replay a mix of reads and writes against a running instance",
+		Long: `Register users, fill their teams and vaults and replay a mix of reads and writes against
+a running instance, then print the latency percentiles of every operation.
+The users are confirmed directly in the db of the --config file so it has to be the one of the instance.
+Never run it against production: the users and secrets it creates are not removed`,
+		Run: cmds.LoadTestCmd,
+	}
+	loadTestCmd.Flags().String("url", "http://localhost:23764/api/v1", "Url of the api of the instance")
+	loadTestCmd.Flags().Int("users", 10, "Users to register")
+	loadTestCmd.Flags().Int("teams", 1, "Teams of each user, including the primary one")
+	loadTestCmd.Flags().Int("vaults", 2, "Vaults in each team")
+	loadTestCmd.Flags().Int("secrets", 50, "Secrets in each vault")
+	loadTestCmd.Flags().Int("workers", 4, "Requests sent concurrently")
+	loadTestCmd.Flags().Duration("duration", 30*time.Second, "How long to replay the mix")
+	loadTestCmd.Flags().Float64("read-ratio", 0.9, "Part of the requests of the mix that only read")
+	rootCmd.AddCommand(loadTestCmd)
+
+	var keysCmd = &cobra.Command{
+		Use:   "keys",
+		Short: "Rotate the keys that sign the csrf cookies",
+		Long: `Rotate the keys that sign the csrf cookies without invalidating the sessions.
+The commands print the csrf section of the config with the changes to replace the one in the --config file`,
+	}
+	keysCmd.AddCommand(&cobra.Command{
+		Use:   "rotate",
+		Short: "Generate new csrf keys and keep the current ones as previous keys",
+		Run:   cmds.KeysRotateCmd,
+	})
+	var keysRetireCmd = &cobra.Command{
+		Use:   "retire",
+		Short: "Remove the previous csrf keys",
+		Run:   cmds.KeysRetireCmd,
+	}
+	keysRetireCmd.Flags().Int("keep", 0, "Previous keys to keep, the most recent first")
+	keysCmd.AddCommand(keysRetireCmd)
+	rootCmd.AddCommand(keysCmd)
+
+	var auditCmd = &cobra.Command{
+		Use:   "audit",
+		Short: "Check the audit log",
+	}
+	var auditVerifyCmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the hash chain of the audit log and its signed checkpoints",
+		Run:   cmds.AuditVerifyCmd,
+	}
+	auditVerifyCmd.Flags().String("public-key", "", "Public key of the checkpoints. The key in audit.checkpoint_key_file by default")
+	auditCmd.AddCommand(auditVerifyCmd)
+	rootCmd.AddCommand(auditCmd)
+
+	var keyLogCmd = &cobra.Command{
+		Use:   "keylog",
+		Short: "Check the log of the public keys of the users",
+	}
+	keyLogCmd.AddCommand(&cobra.Command{
+		Use:   "verify",
+		Short: "Check that the public key of every user is the last one in the key log",
+		Run:   cmds.KeyLogVerifyCmd,
+	})
+	rootCmd.AddCommand(keyLogCmd)
+
+	var kmsCmd = &cobra.Command{
+		Use:   "kms",
+		Short: "Manage the data keys that seal the sensitive columns",
+		Long: `Manage the data keys that seal the key packs, vault keys and tokens in the db.
+The data keys are stored wrapped by the kms configured in the --config file`,
+	}
+	kmsCmd.AddCommand(&cobra.Command{
+		Use:   "new-data-key",
+		Short: "Create a new data key to seal with",
+		Run:   cmds.KMSNewDataKeyCmd,
+	})
+	var kmsRewrapCmd = &cobra.Command{
+		Use:   "rewrap",
+		Short: "Wrap the data keys again with the configured kms",
+		Run:   cmds.KMSRewrapCmd,
+	}
+	kmsRewrapCmd.Flags().String("from-config", "", "Config file with the kms the data keys are wrapped with now")
+	kmsCmd.AddCommand(kmsRewrapCmd)
+	var kmsResealCmd = &cobra.Command{
+		Use:   "reseal",
+		Short: "Seal every value with the current data key",
+		Run:   cmds.KMSResealCmd,
+	}
+	kmsResealCmd.Flags().Bool("retire", false, "Remove the data keys that are no longer used afterwards")
+	kmsCmd.AddCommand(kmsResealCmd)
+	rootCmd.AddCommand(kmsCmd)
+
+	var adminCmd = &cobra.Command{
+		Use:   "admin",
+		Short: "Manage users, teams and invites directly in the database",
+	}
+	var adminUserCmd = &cobra.Command{
+		Use:   "user",
+		Short: "Manage users",
+	}
+	var adminUserListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List users",
+		Run:   cmds.AdminUserListCmd,
+	}
+	adminUserListCmd.Flags().String("query", "", "Only show users whose id, name or email contain this")
+	adminUserListCmd.Flags().Int("limit", 50, "Maximum number of users to show")
+	adminUserListCmd.Flags().Int("offset", 0, "Number of users to skip")
+	adminUserCmd.AddCommand(adminUserListCmd)
+	adminUserCmd.AddCommand(&cobra.Command{
+		Use:   "disable <user>",
+		Short: "Disable a user and close all its sessions",
+		Run:   cmds.AdminUserDisableCmd,
+	})
+	adminUserCmd.AddCommand(&cobra.Command{
+		Use:   "enable <user>",
+		Short: "Enable a previously disabled user",
+		Run:   cmds.AdminUserEnableCmd,
+	})
+	adminUserCmd.AddCommand(&cobra.Command{
+		Use:   "promote <user>",
+		Short: "Grant server admin rights to a user",
+		Run:   cmds.AdminUserPromoteCmd,
+	})
+	adminUserCmd.AddCommand(&cobra.Command{
+		Use:   "demote <user>",
+		Short: "Revoke server admin rights from a user",
+		Run:   cmds.AdminUserDemoteCmd,
+	})
+	adminCmd.AddCommand(adminUserCmd)
+	var adminTeamCmd = &cobra.Command{
+		Use:   "team",
+		Short: "Manage teams",
+	}
+	var adminTeamListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List teams",
+		Run:   cmds.AdminTeamListCmd,
+	}
+	adminTeamListCmd.Flags().Int("limit", 50, "Maximum number of teams to show")
+	adminTeamListCmd.Flags().Int("offset", 0, "Number of teams to skip")
+	adminTeamCmd.AddCommand(adminTeamListCmd)
+	adminCmd.AddCommand(adminTeamCmd)
+	var adminInviteCmd = &cobra.Command{
+		Use:   "invite",
+		Short: "Manage invites",
+	}
+	var adminInviteCreateCmd = &cobra.Command{
+		Use:   "create",
+		Short: "Invite an email to a team",
+		Run:   cmds.AdminInviteCreateCmd,
+	}
+	adminInviteCreateCmd.Flags().String("team", "", "Team to invite to")
+	adminInviteCreateCmd.Flags().String("email", "", "Email to invite")
+	adminInviteCreateCmd.Flags().Bool("mail", false, "Send the invitation email on behalf of the team owner")
+	adminInviteCmd.AddCommand(adminInviteCreateCmd)
+	adminCmd.AddCommand(adminInviteCmd)
+	rootCmd.AddCommand(adminCmd)
 	rootCmd.Execute()
 }
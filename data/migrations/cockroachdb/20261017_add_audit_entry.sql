@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS "audit_entry" CASCADE;
+CREATE TABLE "audit_entry" (
+	"id" TEXT NOT NULL,
+	"actor" TEXT NOT NULL,
+	"action" TEXT NOT NULL,
+	"object" TEXT NOT NULL,
+	"ip" TEXT NOT NULL,
+	"agent" TEXT NOT NULL,
+	"request_id" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_audit_entry" PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_audit_entry_created_at" ON "audit_entry" ("created_at", "id");
+-- Cockroach has no rules. Entries are never updated by keycatd but nothing stops other clients from doing it
+
+-- migrate:down
+DROP TABLE IF EXISTS "audit_entry" CASCADE;
@@ -0,0 +1,24 @@
+-- Append only log of the public keys of the users. Entries outlive the users
+DROP TABLE IF EXISTS "key_log" CASCADE;
+CREATE TABLE "key_log" (
+	"seq" BIGINT NOT NULL,
+	"user" TEXT NOT NULL,
+	"public_key" BYTEA NOT NULL,
+	"leaf_hash" BYTEA NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_key_log" PRIMARY KEY ("seq")
+);
+CREATE INDEX "idx_key_log_user" ON "key_log" ("user", "seq");
+-- Cockroach has no rules. Entries are never updated by keycatd and the clients notice a rewritten log by its root
+-- Size of the log. Locking its only row serializes the appends
+DROP TABLE IF EXISTS "key_log_head" CASCADE;
+CREATE TABLE "key_log_head" (
+	"id" INT NOT NULL,
+	"size" BIGINT NOT NULL,
+	CONSTRAINT "pk_key_log_head" PRIMARY KEY ("id")
+);
+INSERT INTO "key_log_head" ("id", "size") VALUES (1, 0);
+
+-- migrate:down
+DROP TABLE IF EXISTS "key_log_head" CASCADE;
+DROP TABLE IF EXISTS "key_log" CASCADE;
@@ -0,0 +1,6 @@
+-- Tokens are stored by the sha256 of their secret. Cockroach hashes strings directly and returns the hex digest
+UPDATE "token" SET "id" = sha256("id");
+
+-- migrate:down
+-- The secrets can't be recovered from the hashes so the tokens are dropped and have to be sent again
+DELETE FROM "token";
@@ -116,3 +116,6 @@ CREATE TABLE "session" (
 	CONSTRAINT "fk_session_user" FOREIGN KEY ("user") REFERENCES "user" ON DELETE CASCADE
 );
 CREATE INDEX "idx_session_user" ON "session" ("user");
+
+-- migrate:down
+DROP TABLE IF EXISTS "session", "secret", "vault_user", "vault", "token", "team_user", "invite", "team", "user" CASCADE;
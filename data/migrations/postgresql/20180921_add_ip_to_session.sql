@@ -1 +1,4 @@
 ALTER TABLE "session" ADD COLUMN "last_ip" TEXT NOT NULL DEFAULT '1.1.1.1';
+
+-- migrate:down
+ALTER TABLE "session" DROP COLUMN "last_ip";
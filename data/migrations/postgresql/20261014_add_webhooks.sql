@@ -0,0 +1,32 @@
+DROP TABLE IF EXISTS "webhook" CASCADE;
+CREATE TABLE "webhook" (
+	"team" TEXT NOT NULL,
+	"id" TEXT NOT NULL,
+	"url" TEXT NOT NULL,
+	"secret" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_webhook" PRIMARY KEY ("team", "id"),
+	CONSTRAINT "fk_webhook_team" FOREIGN KEY ("team") REFERENCES "team" ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS "webhook_delivery" CASCADE;
+CREATE TABLE "webhook_delivery" (
+	"id" TEXT NOT NULL,
+	"team" TEXT NOT NULL,
+	"webhook" TEXT NOT NULL,
+	"payload" BYTEA NOT NULL,
+	"status" INT NOT NULL,
+	"attempts" INT NOT NULL,
+	"next_attempt" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"last_error" TEXT NOT NULL DEFAULT '',
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_webhook_delivery" PRIMARY KEY ("id"),
+	CONSTRAINT "fk_webhook_delivery_webhook" FOREIGN KEY ("team", "webhook") REFERENCES "webhook" ON DELETE CASCADE
+);
+CREATE INDEX "idx_webhook_delivery_status_next" ON "webhook_delivery" ("status", "next_attempt");
+CREATE INDEX "idx_webhook_delivery_webhook" ON "webhook_delivery" ("team", "webhook");
+
+-- migrate:down
+DROP TABLE IF EXISTS "webhook_delivery", "webhook" CASCADE;
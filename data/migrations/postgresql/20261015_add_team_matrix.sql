@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS "team_matrix" CASCADE;
+CREATE TABLE "team_matrix" (
+	"team" TEXT NOT NULL,
+	"homeserver" TEXT NOT NULL,
+	"access_token" TEXT NOT NULL,
+	"room_id" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_team_matrix" PRIMARY KEY ("team"),
+	CONSTRAINT "fk_team_matrix_team" FOREIGN KEY ("team") REFERENCES "team" ON DELETE CASCADE
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "team_matrix" CASCADE;
@@ -0,0 +1,4 @@
+ALTER TABLE "user" ADD COLUMN "admin" BOOL NOT NULL DEFAULT false;
+
+-- migrate:down
+ALTER TABLE "user" DROP COLUMN "admin";
@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS "ip_block" CASCADE;
+CREATE TABLE "ip_block" (
+	"id" TEXT NOT NULL,
+	"cidr" TEXT NOT NULL,
+	"reason" TEXT NOT NULL,
+	"automatic" BOOLEAN NOT NULL,
+	"created_by" TEXT NOT NULL,
+	"expires_at" TIMESTAMP WITH TIME ZONE NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_ip_block" PRIMARY KEY ("id"),
+	CONSTRAINT "uq_ip_block_cidr" UNIQUE ("cidr")
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "ip_block" CASCADE;
@@ -0,0 +1,19 @@
+DROP TABLE IF EXISTS "job" CASCADE;
+CREATE TABLE "job" (
+	"name" TEXT NOT NULL,
+	"schedule" TEXT NOT NULL,
+	"running" BOOLEAN NOT NULL,
+	"last_instance" TEXT NOT NULL,
+	"last_status" TEXT NOT NULL,
+	"last_message" TEXT NOT NULL,
+	"last_started_at" TIMESTAMP WITH TIME ZONE NULL,
+	"last_duration_ms" BIGINT NOT NULL,
+	"next_run_at" TIMESTAMP WITH TIME ZONE NULL,
+	"runs" BIGINT NOT NULL,
+	"failures" BIGINT NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_job" PRIMARY KEY ("name")
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "job" CASCADE;
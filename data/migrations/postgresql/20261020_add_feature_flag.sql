@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS "feature_flag" CASCADE;
+CREATE TABLE "feature_flag" (
+	"name" TEXT NOT NULL,
+	"team" TEXT NOT NULL,
+	"enabled" BOOLEAN NOT NULL,
+	"updated_by" TEXT NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_feature_flag" PRIMARY KEY ("name", "team")
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "feature_flag" CASCADE;
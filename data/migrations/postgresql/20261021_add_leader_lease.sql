@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS "leader_lease" CASCADE;
+CREATE TABLE "leader_lease" (
+	"name" TEXT NOT NULL,
+	"instance" TEXT NOT NULL,
+	"expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_leader_lease" PRIMARY KEY ("name")
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "leader_lease" CASCADE;
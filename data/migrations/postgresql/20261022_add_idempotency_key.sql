@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS "idempotency_key" CASCADE;
+CREATE TABLE "idempotency_key" (
+	"user" TEXT NOT NULL,
+	"key" TEXT NOT NULL,
+	"request_hash" TEXT NOT NULL,
+	"status" INTEGER NOT NULL,
+	"content_type" TEXT NOT NULL,
+	"body" BYTEA NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_idempotency_key" PRIMARY KEY ("user", "key"),
+	CONSTRAINT "fk_idempotency_key_user" FOREIGN KEY ("user") REFERENCES "user" ON DELETE CASCADE
+);
+CREATE INDEX "idx_idempotency_key_created_at" ON "idempotency_key" ("created_at");
+
+-- migrate:down
+DROP TABLE IF EXISTS "idempotency_key" CASCADE;
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS "data_key" CASCADE;
+CREATE TABLE "data_key" (
+	"id" TEXT NOT NULL,
+	"kms" TEXT NOT NULL,
+	"wrapped" BYTEA NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_data_key" PRIMARY KEY ("id")
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "data_key" CASCADE;
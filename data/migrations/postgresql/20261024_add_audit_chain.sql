@@ -0,0 +1,28 @@
+-- Entries recorded before the chain existed keep seq 0 and are not chained
+ALTER TABLE "audit_entry" ADD COLUMN "seq" BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE "audit_entry" ADD COLUMN "hash" TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX "idx_audit_entry_seq" ON "audit_entry" ("seq") WHERE "seq" > 0;
+-- Head of the chain. Locking its only row serializes the entries
+DROP TABLE IF EXISTS "audit_chain" CASCADE;
+CREATE TABLE "audit_chain" (
+	"id" INT NOT NULL,
+	"seq" BIGINT NOT NULL,
+	"hash" TEXT NOT NULL,
+	CONSTRAINT "pk_audit_chain" PRIMARY KEY ("id")
+);
+INSERT INTO "audit_chain" ("id", "seq", "hash") VALUES (1, 0, '');
+DROP TABLE IF EXISTS "audit_checkpoint" CASCADE;
+CREATE TABLE "audit_checkpoint" (
+	"seq" BIGINT NOT NULL,
+	"hash" TEXT NOT NULL,
+	"signature" BYTEA NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_audit_checkpoint" PRIMARY KEY ("seq")
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "audit_checkpoint" CASCADE;
+DROP TABLE IF EXISTS "audit_chain" CASCADE;
+DROP INDEX IF EXISTS "idx_audit_entry_seq";
+ALTER TABLE "audit_entry" DROP COLUMN "hash";
+ALTER TABLE "audit_entry" DROP COLUMN "seq";
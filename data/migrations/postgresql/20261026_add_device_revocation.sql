@@ -0,0 +1,15 @@
+-- Public keys of the devices reported as compromised. The id is the sha256 of the key
+DROP TABLE IF EXISTS "device_revocation" CASCADE;
+CREATE TABLE "device_revocation" (
+	"id" TEXT NOT NULL,
+	"user" TEXT NOT NULL,
+	"name" TEXT NOT NULL,
+	"public_key" BYTEA NOT NULL,
+	"reason" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_device_revocation" PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_device_revocation_user" ON "device_revocation" ("user");
+
+-- migrate:down
+DROP TABLE IF EXISTS "device_revocation" CASCADE;
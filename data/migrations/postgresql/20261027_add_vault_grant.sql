@@ -0,0 +1,19 @@
+-- Vaults that need a second admin to approve every new member
+ALTER TABLE "vault" ADD COLUMN "approval_required" BOOLEAN NOT NULL DEFAULT false;
+-- Keys of the members waiting for the approval. They are moved to vault_user once approved
+DROP TABLE IF EXISTS "vault_grant" CASCADE;
+CREATE TABLE "vault_grant" (
+	"team" TEXT NOT NULL,
+	"vault" TEXT NOT NULL,
+	"user" TEXT NOT NULL,
+	"key" BYTEA NOT NULL,
+	"requested_by" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_vault_grant" PRIMARY KEY ("team", "vault", "user"),
+	CONSTRAINT "fk_vault_grant_vault" FOREIGN KEY ("team", "vault") REFERENCES "vault" ON DELETE CASCADE,
+	CONSTRAINT "fk_vault_grant_team_user" FOREIGN KEY ("team", "user") REFERENCES "team_user" ON DELETE CASCADE
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "vault_grant" CASCADE;
+ALTER TABLE "vault" DROP COLUMN "approval_required";
@@ -0,0 +1,14 @@
+-- Members can be given access to a vault until a date. The server stops serving their key once it passes
+ALTER TABLE "vault_user" ADD COLUMN "expires_at" TIMESTAMP WITH TIME ZONE;
+ALTER TABLE "vault_user" ADD COLUMN "expiry_notified" BOOLEAN NOT NULL DEFAULT false;
+CREATE INDEX "idx_vault_user_expires_at" ON "vault_user" ("expires_at") WHERE "expires_at" IS NOT NULL;
+ALTER TABLE "vault_grant" ADD COLUMN "expires_at" TIMESTAMP WITH TIME ZONE;
+-- Members that could read the vault key lost their access so the secrets should be moved to a new vault
+ALTER TABLE "vault" ADD COLUMN "rotation_required" BOOLEAN NOT NULL DEFAULT false;
+
+-- migrate:down
+ALTER TABLE "vault" DROP COLUMN "rotation_required";
+ALTER TABLE "vault_grant" DROP COLUMN "expires_at";
+DROP INDEX IF EXISTS "idx_vault_user_expires_at";
+ALTER TABLE "vault_user" DROP COLUMN "expiry_notified";
+ALTER TABLE "vault_user" DROP COLUMN "expires_at";
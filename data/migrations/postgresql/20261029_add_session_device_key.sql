@@ -0,0 +1,5 @@
+-- Sessions can be bound to the ed25519 key of a device that signs every request
+ALTER TABLE "session" ADD COLUMN "device_key" BYTEA;
+
+-- migrate:down
+ALTER TABLE "session" DROP COLUMN "device_key";
@@ -0,0 +1,7 @@
+-- Used tokens are kept with the time they were used instead of being removed so a second use can be told apart from
+-- an unknown token. The cleanup job removes them with the rest
+ALTER TABLE "token" ADD COLUMN "consumed_at" TIMESTAMP WITH TIME ZONE;
+
+-- migrate:down
+DELETE FROM "token" WHERE "consumed_at" IS NOT NULL;
+ALTER TABLE "token" DROP COLUMN "consumed_at";
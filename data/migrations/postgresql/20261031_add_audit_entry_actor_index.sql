@@ -0,0 +1,5 @@
+-- The login history of a user is read by actor
+CREATE INDEX "idx_audit_entry_actor" ON "audit_entry" ("actor", "created_at");
+
+-- migrate:down
+DROP INDEX IF EXISTS "idx_audit_entry_actor";
@@ -0,0 +1,18 @@
+ALTER TABLE "user" ADD COLUMN "pronouns" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "user" ADD COLUMN "title" TEXT NOT NULL DEFAULT '';
+-- When the avatar was last uploaded. The image itself is in the blob store
+ALTER TABLE "user" ADD COLUMN "avatar_at" TIMESTAMP WITH TIME ZONE;
+DROP TABLE IF EXISTS "blob" CASCADE;
+CREATE TABLE "blob" (
+	"key" TEXT NOT NULL,
+	"content_type" TEXT NOT NULL,
+	"data" BYTEA NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_blob" PRIMARY KEY ("key")
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "blob" CASCADE;
+ALTER TABLE "user" DROP COLUMN "avatar_at";
+ALTER TABLE "user" DROP COLUMN "title";
+ALTER TABLE "user" DROP COLUMN "pronouns";
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS "user_preferences" CASCADE;
+CREATE TABLE "user_preferences" (
+	"user" TEXT NOT NULL,
+	"data" BYTEA NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_user_preferences" PRIMARY KEY ("user"),
+	CONSTRAINT "fk_user_preferences_user" FOREIGN KEY ("user") REFERENCES "user" ON DELETE CASCADE
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "user_preferences" CASCADE;
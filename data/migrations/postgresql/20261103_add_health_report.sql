@@ -0,0 +1,17 @@
+-- Counts computed by the clients of each member over the secrets of the team. The secrets themselves are never sent
+DROP TABLE IF EXISTS "health_report" CASCADE;
+CREATE TABLE "health_report" (
+	"team" TEXT NOT NULL,
+	"user" TEXT NOT NULL,
+	"total" INTEGER NOT NULL,
+	"weak" INTEGER NOT NULL,
+	"reused" INTEGER NOT NULL,
+	"old" INTEGER NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_health_report" PRIMARY KEY ("team", "user"),
+	CONSTRAINT "fk_health_report_team" FOREIGN KEY ("team") REFERENCES "team" ON DELETE CASCADE,
+	CONSTRAINT "fk_health_report_user" FOREIGN KEY ("user") REFERENCES "user" ON DELETE CASCADE
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "health_report" CASCADE;
@@ -0,0 +1,5 @@
+-- Rounded down to the hour. It's only meant to spot dormant accounts
+ALTER TABLE "user" ADD COLUMN "last_active_at" TIMESTAMP WITH TIME ZONE;
+
+-- migrate:down
+ALTER TABLE "user" DROP COLUMN "last_active_at";
@@ -0,0 +1,7 @@
+-- Empty until the user picks them. The mails fall back to the locale of the request and UTC
+ALTER TABLE "user" ADD COLUMN "locale" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "user" ADD COLUMN "timezone" TEXT NOT NULL DEFAULT '';
+
+-- migrate:down
+ALTER TABLE "user" DROP COLUMN "timezone";
+ALTER TABLE "user" DROP COLUMN "locale";
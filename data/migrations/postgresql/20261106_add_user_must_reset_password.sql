@@ -0,0 +1,5 @@
+-- Set by the admins after a suspected compromise. The user can't log in until it resets the password
+ALTER TABLE "user" ADD COLUMN "must_reset_password" BOOLEAN NOT NULL DEFAULT false;
+
+-- migrate:down
+ALTER TABLE "user" DROP COLUMN "must_reset_password";
@@ -0,0 +1,5 @@
+-- When the last of the failed_attempts in a row happened. The login policy forgets failures older than its reset_hours
+ALTER TABLE "user" ADD COLUMN "failed_at" TIMESTAMP WITH TIME ZONE;
+
+-- migrate:down
+ALTER TABLE "user" DROP COLUMN "failed_at";
@@ -0,0 +1,28 @@
+-- Labels are managed by the team admins. Secrets only point to their ids since the data of the secrets is sealed
+DROP TABLE IF EXISTS "label" CASCADE;
+CREATE TABLE "label" (
+	"team" TEXT NOT NULL,
+	"id" TEXT NOT NULL,
+	"name" TEXT NOT NULL,
+	"color" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_label" PRIMARY KEY ("team", "id"),
+	CONSTRAINT "fk_label_team" FOREIGN KEY ("team") REFERENCES "team" ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_label_team_name" ON "label" ("team", LOWER("name"));
+
+DROP TABLE IF EXISTS "secret_label" CASCADE;
+CREATE TABLE "secret_label" (
+	"team" TEXT NOT NULL,
+	"vault" TEXT NOT NULL,
+	"secret" TEXT NOT NULL,
+	"label" TEXT NOT NULL,
+	CONSTRAINT "pk_secret_label" PRIMARY KEY ("team", "vault", "secret", "label"),
+	CONSTRAINT "fk_secret_label_label" FOREIGN KEY ("team", "label") REFERENCES "label" ON DELETE CASCADE,
+	CONSTRAINT "fk_secret_label_vault" FOREIGN KEY ("team", "vault") REFERENCES "vault" ON DELETE CASCADE
+);
+CREATE INDEX "idx_secret_label_label" ON "secret_label" ("team", "label");
+
+-- migrate:down
+DROP TABLE IF EXISTS "secret_label", "label" CASCADE;
@@ -0,0 +1,5 @@
+-- Archived secrets are kept but left out of the default listings. Every version of a secret has the same value
+ALTER TABLE "secret" ADD COLUMN "archived_at" TIMESTAMP WITH TIME ZONE;
+
+-- migrate:down
+ALTER TABLE "secret" DROP COLUMN "archived_at";
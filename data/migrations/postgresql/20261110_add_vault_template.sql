@@ -0,0 +1,18 @@
+-- Layouts of the fields of new secrets. The server doesn't look into the data
+DROP TABLE IF EXISTS "vault_template" CASCADE;
+CREATE TABLE "vault_template" (
+	"team" TEXT NOT NULL,
+	"vault" TEXT NOT NULL,
+	"id" TEXT NOT NULL,
+	"name" TEXT NOT NULL,
+	"data" BYTEA NOT NULL,
+	"created_by" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_vault_template" PRIMARY KEY ("team", "vault", "id"),
+	CONSTRAINT "fk_vault_template_vault" FOREIGN KEY ("team", "vault") REFERENCES "vault" ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_vault_template_name" ON "vault_template" ("team", "vault", LOWER("name"));
+
+-- migrate:down
+DROP TABLE IF EXISTS "vault_template" CASCADE;
@@ -0,0 +1,28 @@
+-- Hashes of the chained audit entries the retention policy purged from the middle of the chain, like the logins
+DROP TABLE IF EXISTS "audit_tombstone" CASCADE;
+CREATE TABLE "audit_tombstone" (
+	"seq" BIGINT NOT NULL,
+	"hash" TEXT NOT NULL,
+	"action" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_audit_tombstone" PRIMARY KEY ("seq")
+);
+CREATE INDEX "idx_audit_tombstone_created_at" ON "audit_tombstone" ("created_at");
+CREATE INDEX "idx_audit_entry_action_created_at" ON "audit_entry" ("action", "created_at");
+CREATE INDEX "idx_webhook_delivery_updated_at" ON "webhook_delivery" ("updated_at");
+
+-- Last purge of each class of data
+DROP TABLE IF EXISTS "retention_run" CASCADE;
+CREATE TABLE "retention_run" (
+	"class" TEXT NOT NULL,
+	"days" INT NOT NULL,
+	"cutoff" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"purged" BIGINT NOT NULL,
+	"total_purged" BIGINT NOT NULL,
+	"ran_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_retention_run" PRIMARY KEY ("class")
+);
+-- migrate:down
+DROP INDEX IF EXISTS "idx_webhook_delivery_updated_at";
+DROP INDEX IF EXISTS "idx_audit_entry_action_created_at";
+DROP TABLE IF EXISTS "retention_run", "audit_tombstone" CASCADE;
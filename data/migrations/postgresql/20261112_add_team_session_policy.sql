@@ -0,0 +1,17 @@
+-- Teams can require csrf protected sessions and cap how long the sessions of their members last
+DROP TABLE IF EXISTS "team_session_policy" CASCADE;
+CREATE TABLE "team_session_policy" (
+	"team" TEXT NOT NULL,
+	"require_csrf" BOOLEAN NOT NULL,
+	"max_session_hours" INT NOT NULL,
+	"updated_by" TEXT NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_team_session_policy" PRIMARY KEY ("team"),
+	CONSTRAINT "fk_team_session_policy_team" FOREIGN KEY ("team") REFERENCES "team" ON DELETE CASCADE
+);
+-- The lifetime of the sessions from before starts counting now
+ALTER TABLE "session" ADD COLUMN "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+-- migrate:down
+ALTER TABLE "session" DROP COLUMN "created_at";
+DROP TABLE IF EXISTS "team_session_policy" CASCADE;
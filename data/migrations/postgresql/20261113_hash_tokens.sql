@@ -0,0 +1,6 @@
+-- Tokens are stored by the sha256 of their secret. The ids were the secrets so the tokens already sent keep working
+UPDATE "token" SET "id" = encode(sha256(convert_to("id", 'UTF8')), 'hex');
+
+-- migrate:down
+-- The secrets can't be recovered from the hashes so the tokens are dropped and have to be sent again
+DELETE FROM "token";
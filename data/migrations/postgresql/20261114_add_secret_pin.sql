@@ -0,0 +1,15 @@
+-- Secrets the clients list first in a vault, in the order set by the team admins
+DROP TABLE IF EXISTS "secret_pin" CASCADE;
+CREATE TABLE "secret_pin" (
+	"team" TEXT NOT NULL,
+	"vault" TEXT NOT NULL,
+	"secret" TEXT NOT NULL,
+	"position" INT NOT NULL,
+	"pinned_by" TEXT NOT NULL,
+	"pinned_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_secret_pin" PRIMARY KEY ("team", "vault", "secret"),
+	CONSTRAINT "fk_secret_pin_vault" FOREIGN KEY ("team", "vault") REFERENCES "vault" ON DELETE CASCADE
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "secret_pin" CASCADE;
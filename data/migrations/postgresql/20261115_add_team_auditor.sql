@@ -0,0 +1,5 @@
+-- Auditors see the audit log, the members and the vaults of the team but never get the keys of a vault
+ALTER TABLE "team_user" ADD COLUMN "auditor" BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- migrate:down
+ALTER TABLE "team_user" DROP COLUMN "auditor";
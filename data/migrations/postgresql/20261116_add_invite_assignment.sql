@@ -0,0 +1,24 @@
+-- Invites carry a message and the role and vaults proposed for the invitee. Once accepted the grants wait for an admin
+-- to wrap the vault keys for the new member
+ALTER TABLE "invite" ADD COLUMN "message" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "invite" ADD COLUMN "role" TEXT NOT NULL DEFAULT 'member';
+ALTER TABLE "invite" ADD COLUMN "vaults" TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE "invite" ADD COLUMN "invited_by" TEXT NOT NULL DEFAULT '';
+DROP TABLE IF EXISTS "pending_access" CASCADE;
+CREATE TABLE "pending_access" (
+	"team" TEXT NOT NULL,
+	"user" TEXT NOT NULL,
+	"role" TEXT NOT NULL,
+	"vaults" TEXT[] NOT NULL,
+	"invited_by" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_pending_access" PRIMARY KEY ("team", "user"),
+	CONSTRAINT "fk_pending_access_team_user" FOREIGN KEY ("team", "user") REFERENCES "team_user" ON DELETE CASCADE
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "pending_access" CASCADE;
+ALTER TABLE "invite" DROP COLUMN "invited_by";
+ALTER TABLE "invite" DROP COLUMN "vaults";
+ALTER TABLE "invite" DROP COLUMN "role";
+ALTER TABLE "invite" DROP COLUMN "message";
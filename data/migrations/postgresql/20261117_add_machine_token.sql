@@ -0,0 +1,17 @@
+-- Read only tokens that let a machine read the secrets of one vault with the vault key of the user that issued them.
+-- Only the sha256 of the secret is kept, as in the token table
+DROP TABLE IF EXISTS "machine_token" CASCADE;
+CREATE TABLE "machine_token" (
+	"id" TEXT NOT NULL,
+	"team" TEXT NOT NULL,
+	"vault" TEXT NOT NULL,
+	"user" TEXT NOT NULL,
+	"name" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_machine_token" PRIMARY KEY ("id"),
+	CONSTRAINT "fk_machine_token_vault_user" FOREIGN KEY ("team", "vault", "user") REFERENCES "vault_user" ON DELETE CASCADE
+);
+CREATE INDEX "idx_machine_token_vault" ON "machine_token" ("team", "vault");
+
+-- migrate:down
+DROP TABLE IF EXISTS "machine_token" CASCADE;
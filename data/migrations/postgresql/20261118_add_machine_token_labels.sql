@@ -0,0 +1,5 @@
+-- Machine tokens can be limited to the secrets with some labels
+ALTER TABLE "machine_token" ADD COLUMN "labels" TEXT[] NOT NULL DEFAULT '{}';
+
+-- migrate:down
+ALTER TABLE "machine_token" DROP COLUMN "labels";
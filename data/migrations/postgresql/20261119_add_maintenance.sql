@@ -0,0 +1,14 @@
+-- The maintenance mode is stored so it survives restarts and every instance of a cluster loads it. It has one row at most
+DROP TABLE IF EXISTS "maintenance" CASCADE;
+CREATE TABLE "maintenance" (
+	"id" INT NOT NULL,
+	"message" TEXT NOT NULL,
+	"retry_after" INT NOT NULL,
+	"since" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"enabled_by" TEXT NOT NULL,
+	CONSTRAINT "pk_maintenance" PRIMARY KEY ("id"),
+	CONSTRAINT "ck_maintenance_single_row" CHECK ("id" = 1)
+);
+
+-- migrate:down
+DROP TABLE IF EXISTS "maintenance" CASCADE;
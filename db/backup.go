@@ -0,0 +1,246 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const (
+	backupFormat  = "keycatd-backup"
+	backupVersion = 1
+	//Rows are json objects so the longest line is bounded by the biggest secret
+	backupMaxLineSize = 64 * 1024 * 1024
+)
+
+type BackupHeader struct {
+	Format        string    `json:"format"`
+	Version       int       `json:"version"`
+	ServerVersion string    `json:"server_version"`
+	Migration     int       `json:"migration"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type backupRow struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+// listTables returns the tables sorted so that every table comes after the ones it references
+func listTables(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT tablename FROM pg_catalog.pg_tables WHERE schemaname = current_schema() AND tablename != 'db_migrations'`)
+	if err != nil {
+		return nil, util.NewErrorf("Could not retrieve tables: %s", err)
+	}
+	deps := map[string]map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, util.NewErrorFrom(err)
+		}
+		deps[name] = map[string]bool{}
+	}
+	rows.Close()
+	rows, err = tx.QueryContext(ctx, `SELECT src.relname, dst.relname FROM pg_catalog.pg_constraint c
+		JOIN pg_catalog.pg_class src ON src.oid = c.conrelid
+		JOIN pg_catalog.pg_class dst ON dst.oid = c.confrelid
+		WHERE c.contype = 'f' AND c.conrelid != c.confrelid`)
+	if err != nil {
+		return nil, util.NewErrorf("Could not retrieve foreign keys: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var src, dst string
+		if err := rows.Scan(&src, &dst); err != nil {
+			return nil, util.NewErrorFrom(err)
+		}
+		if _, ok := deps[src]; ok {
+			deps[src][dst] = true
+		}
+	}
+	sorted := make([]string, 0, len(deps))
+	done := map[string]bool{}
+	for len(sorted) < len(deps) {
+		ready := []string{}
+		for name, refs := range deps {
+			if done[name] {
+				continue
+			}
+			ok := true
+			for ref := range refs {
+				if _, known := deps[ref]; known && !done[ref] {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				ready = append(ready, name)
+			}
+		}
+		if len(ready) == 0 {
+			return nil, util.NewErrorf("Tables have circular foreign keys")
+		}
+		sort.Strings(ready)
+		for _, name := range ready {
+			done[name] = true
+		}
+		sorted = append(sorted, ready...)
+	}
+	return sorted, nil
+}
+
+// Backup writes a consistent snapshot of every table as newline delimited json.
+// All tables are read in the same read only transaction
+func Backup(ctx context.Context, db *sql.DB, dbType string, w io.Writer) (BackupHeader, error) {
+	bh := BackupHeader{Format: backupFormat, Version: backupVersion, ServerVersion: util.GetServerVersion(), CreatedAt: time.Now().UTC()}
+	if dbType != "postgresql" {
+		return bh, util.NewErrorf("Backups are only supported for postgresql")
+	}
+	lid, err := NewMigrateMgr(db, dbType).GetLastMigrationInstalled()
+	if err != nil {
+		return bh, err
+	}
+	bh.Migration = lid
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return bh, util.NewErrorFrom(err)
+	}
+	defer tx.Rollback()
+	tables, err := listTables(ctx, tx)
+	if err != nil {
+		return bh, err
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(bh); err != nil {
+		return bh, util.NewErrorFrom(err)
+	}
+	for _, table := range tables {
+		if err := backupTable(ctx, tx, table, enc); err != nil {
+			return bh, err
+		}
+	}
+	return bh, nil
+}
+
+func backupTable(ctx context.Context, tx *sql.Tx, table string, enc *json.Encoder) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT row_to_json(t) FROM %s t`, pq.QuoteIdentifier(table)))
+	if err != nil {
+		return util.NewErrorf("Could not read table %s: %s", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		br := backupRow{Table: table}
+		if err := rows.Scan(&br.Row); err != nil {
+			return util.NewErrorFrom(err)
+		}
+		if err := enc.Encode(br); err != nil {
+			return util.NewErrorFrom(err)
+		}
+	}
+	return util.NewErrorFrom(rows.Err())
+}
+
+// Restore loads a backup into a db that has to be at the same migration the backup was taken at.
+// It refuses to load into tables that already have data unless overwrite is set, in which case they are emptied first
+func Restore(ctx context.Context, db *sql.DB, dbType string, r io.Reader, overwrite bool) (BackupHeader, error) {
+	bh := BackupHeader{}
+	if dbType != "postgresql" {
+		return bh, util.NewErrorf("Backups are only supported for postgresql")
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), backupMaxLineSize)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return bh, util.NewErrorf("Could not read backup: %s", err)
+		}
+		return bh, util.NewErrorf("Backup is empty")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &bh); err != nil || bh.Format != backupFormat {
+		return bh, util.NewErrorf("This is not a keycatd backup. Is it encrypted?")
+	}
+	if bh.Version != backupVersion {
+		return bh, util.NewErrorf("Unsupported backup version %d", bh.Version)
+	}
+	lid, err := NewMigrateMgr(db, dbType).GetLastMigrationInstalled()
+	if err != nil {
+		return bh, err
+	}
+	if lid != bh.Migration {
+		return bh, util.NewErrorf("Backup was taken at migration %d and the db is at migration %d. Restore it with a keycatd version at the same migration", bh.Migration, lid)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return bh, util.NewErrorFrom(err)
+	}
+	defer tx.Rollback()
+	tables, err := listTables(ctx, tx)
+	if err != nil {
+		return bh, err
+	}
+	if err := prepareRestore(ctx, tx, tables, overwrite); err != nil {
+		return bh, err
+	}
+	known := map[string]bool{}
+	for _, table := range tables {
+		known[table] = true
+	}
+	inserts := map[string]*sql.Stmt{}
+	for scanner.Scan() {
+		br := backupRow{}
+		if err := json.Unmarshal(scanner.Bytes(), &br); err != nil {
+			return bh, util.NewErrorf("Backup is corrupted: %s", err)
+		}
+		if !known[br.Table] {
+			return bh, util.NewErrorf("Backup contains unknown table %s", br.Table)
+		}
+		stmt, ok := inserts[br.Table]
+		if !ok {
+			qt := pq.QuoteIdentifier(br.Table)
+			if stmt, err = tx.PrepareContext(ctx, fmt.Sprintf(`INSERT INTO %s SELECT * FROM json_populate_record(NULL::%s, $1)`, qt, qt)); err != nil {
+				return bh, util.NewErrorFrom(err)
+			}
+			inserts[br.Table] = stmt
+		}
+		if _, err := stmt.ExecContext(ctx, string(br.Row)); err != nil {
+			return bh, util.NewErrorf("Could not restore row in %s: %s", br.Table, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return bh, util.NewErrorf("Could not read backup: %s", err)
+	}
+	return bh, util.NewErrorFrom(tx.Commit())
+}
+
+func prepareRestore(ctx context.Context, tx *sql.Tx, tables []string, overwrite bool) error {
+	quoted := make([]string, len(tables))
+	for i, table := range tables {
+		quoted[i] = pq.QuoteIdentifier(table)
+		if overwrite {
+			continue
+		}
+		var full bool
+		if err := tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s)`, quoted[i])).Scan(&full); err != nil {
+			return util.NewErrorFrom(err)
+		}
+		if full {
+			return util.NewErrorf("Table %s is not empty. Refusing to restore over existing data", table)
+		}
+	}
+	if !overwrite || len(quoted) == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "TRUNCATE "+strings.Join(quoted, ", ")+" CASCADE"); err != nil {
+		return util.NewErrorf("Could not empty the tables: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/keydotcat/keycatd/thelpers"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	defer thelpers.DropAllTables(db)
+	ctx := context.Background()
+	for _, q := range []string{
+		`CREATE TABLE "zparent" ("id" TEXT PRIMARY KEY, "data" BYTEA NOT NULL)`,
+		`CREATE TABLE "achild" ("id" TEXT PRIMARY KEY, "parent" TEXT NOT NULL REFERENCES "zparent", "at" TIMESTAMP WITH TIME ZONE NOT NULL)`,
+		`INSERT INTO "zparent" VALUES ('p1', '\x0102ff'), ('p2', '\x')`,
+		`INSERT INTO "achild" VALUES ('c1', 'p1', now()), ('c2', 'p2', now())`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if _, err := Backup(ctx, db, "postgresql", buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if _, err := Restore(ctx, db, "postgresql", bytes.NewReader(data), false); err == nil {
+		t.Fatal("Expected restore over existing data to fail")
+	}
+	if _, err := Restore(ctx, db, "postgresql", bytes.NewReader(data), true); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM "achild" c JOIN "zparent" p ON p.id = c.parent WHERE p.id != 'p1' OR p.data = '\x0102ff'`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("Expected 2 restored rows and got %d", count)
+	}
+	if _, err := Restore(ctx, db, "postgresql", bytes.NewReader([]byte("garbage\n")), true); err == nil {
+		t.Fatal("Expected an error restoring an invalid backup")
+	}
+}
@@ -13,18 +13,52 @@ import (
 	"github.com/keydotcat/keycatd/util"
 )
 
+// Everything after this line in a migration file is run to revert it
+const downMarker = "\n-- migrate:down\n"
+
+const (
+	TYPE_POSTGRESQL  = "postgresql"
+	TYPE_COCKROACHDB = "cockroachdb"
+)
+
+// Oldest cockroach with everything the migrations and the models use, like expression indexes and SKIP LOCKED
+const (
+	COCKROACHDB_MIN_MAJOR = 22
+	COCKROACHDB_MIN_MINOR = 2
+)
+
 type MigrateMgr struct {
 	db         *sql.DB
 	dbType     string
 	migrations map[int]string
+	downs      map[int]string
+}
+
+type MigrationStatus struct {
+	Id         int
+	Applied    bool
+	AppliedAt  time.Time
+	Revertible bool
 }
 
 func NewMigrateMgr(db *sql.DB, dbType string) *MigrateMgr {
-	return &MigrateMgr{db, dbType, make(map[int]string)}
+	return &MigrateMgr{db, dbType, make(map[int]string), make(map[int]string)}
 }
 
+// LoadMigrations reads the migrations of the db type. Cockroach runs the postgresql migrations except the ones
+// that are replaced by a file with the same id in its own directory
 func (m *MigrateMgr) LoadMigrations() error {
-	return static.Walk("migrations/"+m.dbType, func(path string, fi os.FileInfo, err error) error {
+	if m.dbType != TYPE_COCKROACHDB {
+		return m.loadMigrationsFrom("migrations/" + m.dbType)
+	}
+	if err := m.loadMigrationsFrom("migrations/" + TYPE_POSTGRESQL); err != nil {
+		return err
+	}
+	return m.loadMigrationsFrom("migrations/" + TYPE_COCKROACHDB)
+}
+
+func (m *MigrateMgr) loadMigrationsFrom(dir string) error {
+	return static.Walk(dir, func(path string, fi os.FileInfo, err error) error {
 		log.Println("Found migration", path)
 		if !strings.HasSuffix(path, ".sql") {
 			return nil
@@ -42,7 +76,13 @@ func (m *MigrateMgr) LoadMigrations() error {
 		if err != nil {
 			return util.NewErrorf("Could not parse number for db migration %s: %s", path, err)
 		}
-		m.migrations[idx] = string(data)
+		up := string(data)
+		delete(m.downs, idx)
+		if pos := strings.Index(up, downMarker); pos > -1 {
+			m.downs[idx] = up[pos+len(downMarker):]
+			up = up[:pos]
+		}
+		m.migrations[idx] = up
 		return nil
 	})
 }
@@ -56,6 +96,10 @@ func (m *MigrateMgr) GetLastMigrationInstalled() (int, error) {
 			return 0, err
 		}
 	}
+	return m.queryLastMigration()
+}
+
+func (m *MigrateMgr) queryLastMigration() (int, error) {
 	var mid int
 	err := m.db.QueryRow("SELECT \"Id\" FROM \"db_migrations\" ORDER BY \"Id\" DESC LIMIT 1").Scan(&mid)
 	if err == sql.ErrNoRows {
@@ -71,25 +115,88 @@ func (m *MigrateMgr) CheckIfMigrationIsRequired() (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	return m.countRequired(mid), nil
+}
+
+// CheckInstalled returns the last migration installed and how many are pending. Unlike GetLastMigrationInstalled it
+// only reads, so the probes never create the migrations table
+func (m *MigrateMgr) CheckInstalled() (last int, pending int, err error) {
+	if last, err = m.queryLastMigration(); err != nil {
+		return 0, 0, err
+	}
+	return last, m.countRequired(last), nil
+}
+
+func (m *MigrateMgr) countRequired(mid int) int {
 	required := 0
 	for kid := range m.migrations {
 		if kid > mid {
 			required += 1
 		}
 	}
-	return required, nil
+	return required
 }
 
-func (m *MigrateMgr) ApplyRequiredMigrations() (int, int, error) {
-	lid, err := m.GetLastMigrationInstalled()
-	if err != nil {
-		return 0, 0, err
-	}
+func (m *MigrateMgr) sortedIds() []int {
 	ids := make([]int, 0, len(m.migrations))
 	for kid := range m.migrations {
 		ids = append(ids, kid)
 	}
 	sort.Ints(ids)
+	return ids
+}
+
+// checkServerVersion refuses to migrate a cockroach older than the minimum version. Postgres is not checked
+func (m *MigrateMgr) checkServerVersion() error {
+	if m.dbType != TYPE_COCKROACHDB {
+		return nil
+	}
+	var version string
+	if err := m.db.QueryRow(`SELECT version()`).Scan(&version); err != nil {
+		return util.NewErrorf("Could not retrieve the db version: %s", err)
+	}
+	major, minor, ok := parseCockroachVersion(version)
+	if !ok {
+		log.Printf("Could not parse the cockroach version %s. It has to be at least v%d.%d", version, COCKROACHDB_MIN_MAJOR, COCKROACHDB_MIN_MINOR)
+		return nil
+	}
+	if major < COCKROACHDB_MIN_MAJOR || (major == COCKROACHDB_MIN_MAJOR && minor < COCKROACHDB_MIN_MINOR) {
+		return util.NewErrorf("Cockroach v%d.%d is not supported. It has to be at least v%d.%d", major, minor, COCKROACHDB_MIN_MAJOR, COCKROACHDB_MIN_MINOR)
+	}
+	return nil
+}
+
+// parseCockroachVersion reads the version out of something like CockroachDB CCL v22.2.3 (x86_64-pc-linux-gnu, ...)
+func parseCockroachVersion(version string) (major, minor int, ok bool) {
+	for _, field := range strings.Fields(version) {
+		if !strings.HasPrefix(field, "v") {
+			continue
+		}
+		parts := strings.SplitN(field[1:], ".", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		var err error
+		if major, err = strconv.Atoi(parts[0]); err != nil {
+			continue
+		}
+		if minor, err = strconv.Atoi(parts[1]); err != nil {
+			continue
+		}
+		return major, minor, true
+	}
+	return 0, 0, false
+}
+
+func (m *MigrateMgr) ApplyRequiredMigrations() (int, int, error) {
+	if err := m.checkServerVersion(); err != nil {
+		return 0, 0, err
+	}
+	lid, err := m.GetLastMigrationInstalled()
+	if err != nil {
+		return 0, 0, err
+	}
+	ids := m.sortedIds()
 	applied := 0
 	for _, mid := range ids {
 		if mid <= lid {
@@ -124,12 +231,106 @@ func (m *MigrateMgr) applyMigration(mid int) error {
 	return util.NewErrorFrom(tx.Commit())
 }
 
+// RevertLastMigration runs the down section of the last installed migration and returns the new last installed id
+func (m *MigrateMgr) RevertLastMigration() (int, error) {
+	lid, err := m.GetLastMigrationInstalled()
+	if err != nil {
+		return 0, err
+	}
+	if lid == 0 {
+		return 0, util.NewErrorf("There are no migrations installed")
+	}
+	down, ok := m.downs[lid]
+	if !ok {
+		return 0, util.NewErrorf("Migration %d can't be reverted. It has no down section", lid)
+	}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return 0, util.NewErrorFrom(err)
+	}
+	if _, err = tx.Exec(down); err != nil {
+		tx.Rollback()
+		return 0, util.NewErrorf("Could not revert migration %d: %s", lid, err)
+	}
+	if _, err = tx.Exec(`DELETE FROM "db_migrations" WHERE "Id" = $1`, lid); err != nil {
+		tx.Rollback()
+		return 0, util.NewErrorf("Could not remove reverted migration from table: %s", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, util.NewErrorFrom(err)
+	}
+	return m.GetLastMigrationInstalled()
+}
+
+// ForceMigration marks every known migration up to mid as installed and the rest as not installed without running them.
+// It is meant to fix the migrations table after a migration was applied or reverted by hand
+func (m *MigrateMgr) ForceMigration(mid int) error {
+	if _, ok := m.migrations[mid]; !ok && mid != 0 {
+		return util.NewErrorf("Unknown migration %d", mid)
+	}
+	if _, err := m.GetLastMigrationInstalled(); err != nil {
+		return err
+	}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return util.NewErrorFrom(err)
+	}
+	if _, err = tx.Exec(`DELETE FROM "db_migrations" WHERE "Id" > $1`, mid); err != nil {
+		tx.Rollback()
+		return util.NewErrorf("Could not remove migrations from table: %s", err)
+	}
+	now := time.Now().UTC()
+	for kid := range m.migrations {
+		if kid > mid {
+			continue
+		}
+		if _, err = tx.Exec(`INSERT INTO "db_migrations" ("Id","CreatedAt") SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM "db_migrations" WHERE "Id" = $1)`, kid, now); err != nil {
+			tx.Rollback()
+			return util.NewErrorf("Could not write forced migration to table: %s", err)
+		}
+	}
+	return util.NewErrorFrom(tx.Commit())
+}
+
+// Status returns every known migration sorted by id and whether it has been applied
+func (m *MigrateMgr) Status() ([]MigrationStatus, error) {
+	if _, err := m.GetLastMigrationInstalled(); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.Query(`SELECT "Id", "CreatedAt" FROM "db_migrations"`)
+	if err != nil {
+		return nil, util.NewErrorf("Could not retrieve installed migrations: %s", err)
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var mid int
+		var at time.Time
+		if err := rows.Scan(&mid, &at); err != nil {
+			return nil, util.NewErrorFrom(err)
+		}
+		applied[mid] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	ids := m.sortedIds()
+	status := make([]MigrationStatus, len(ids))
+	for i, mid := range ids {
+		at, ok := applied[mid]
+		_, revertible := m.downs[mid]
+		status[i] = MigrationStatus{mid, ok, at, revertible}
+	}
+	return status, nil
+}
+
 func (m *MigrateMgr) checkIfMigrationsTableExists() (bool, error) {
 	var query string
 	switch m.dbType {
-	case "cockroach":
-		query = "SHOW TABLES"
-	case "postgresql":
+	case TYPE_COCKROACHDB:
+		//SHOW TABLES returns more than the name in newer versions
+		query = `SELECT table_name FROM information_schema.tables WHERE table_schema = 'public'`
+	case TYPE_POSTGRESQL:
 		query = `SELECT tablename FROM pg_catalog.pg_tables WHERE schemaname != 'pg_catalog' AND schemaname != 'information_schema'`
 	default:
 		return false, util.NewErrorf("Unknown database type: %s", m.dbType)
@@ -154,9 +355,9 @@ func (m *MigrateMgr) checkIfMigrationsTableExists() (bool, error) {
 func (m *MigrateMgr) createMigrationsTable() error {
 	var query string
 	switch m.dbType {
-	case "cockroach":
+	case TYPE_COCKROACHDB:
 		query = `CREATE TABLE "db_migrations" ("Id" INT NOT NULL, "CreatedAt" TIMESTAMP WITH TIME ZONE NOT NULL, CONSTRAINT "primary" PRIMARY KEY ("Id" DESC), FAMILY "primary" ("Id", "CreatedAt") )`
-	case "postgresql":
+	case TYPE_POSTGRESQL:
 		query = `CREATE TABLE "db_migrations" ("Id" INT NOT NULL, "CreatedAt" TIMESTAMP WITH TIME ZONE NOT NULL, CONSTRAINT "primary" PRIMARY KEY ("Id") )`
 	default:
 		return util.NewErrorf("Unknown database type: %s", m.dbType)
@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/keydotcat/keycatd/thelpers"
@@ -79,3 +80,73 @@ func TestMigrations(t *testing.T) {
 		t.Fatalf("Expected to run 2 migrations and got %d", ap)
 	}
 }
+
+func TestMigrationsRevertAndForce(t *testing.T) {
+	defer thelpers.DropAllTables(db)
+	m := NewMigrateMgr(db, "postgresql")
+	m.migrations = map[int]string{}
+	addMigration(m)
+	addMigration(m)
+	m.downs[2] = `DROP TABLE "a2"; DROP TABLE "b2";`
+	if _, _, err := m.ApplyRequiredMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	st, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st) != 2 || !st[0].Applied || !st[1].Applied || st[0].Revertible || !st[1].Revertible {
+		t.Fatalf("Unexpected migration status %#v", st)
+	}
+	lid, err := m.RevertLastMigration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lid != 1 {
+		t.Fatalf("Expected 1 as last migration after reverting and got %d", lid)
+	}
+	if _, err = m.RevertLastMigration(); err == nil {
+		t.Fatalf("Expected an error reverting a migration without down section")
+	}
+	if err = m.ForceMigration(2); err != nil {
+		t.Fatal(err)
+	}
+	if req, err := m.CheckIfMigrationIsRequired(); err != nil || req != 0 {
+		t.Fatalf("Expected no migrations required after forcing and got %d (%v)", req, err)
+	}
+	if err = m.ForceMigration(0); err != nil {
+		t.Fatal(err)
+	}
+	if lid, err = m.GetLastMigrationInstalled(); err != nil || lid != 0 {
+		t.Fatalf("Expected no migrations installed after forcing to 0 and got %d (%v)", lid, err)
+	}
+}
+
+func TestLoadMigrationsSplitsDown(t *testing.T) {
+	m := NewMigrateMgr(db, "postgresql")
+	if err := m.LoadMigrations(); err != nil {
+		t.Fatal(err)
+	}
+	for mid, up := range m.migrations {
+		if strings.Contains(up, "migrate:down") {
+			t.Errorf("Migration %d still contains the down section", mid)
+		}
+		if _, ok := m.downs[mid]; !ok {
+			t.Errorf("Migration %d has no down section", mid)
+		}
+	}
+}
+
+func TestParseCockroachVersion(t *testing.T) {
+	cases := map[string][3]int{
+		"CockroachDB CCL v22.2.3 (x86_64-pc-linux-gnu, built 2023/01/23 19:40:07, go1.19.1)": {22, 2, 1},
+		"CockroachDB OSS v21.1.0-beta.1 (x86_64-unknown-linux-gnu)":                          {21, 1, 1},
+		"PostgreSQL 13.4 on x86_64-pc-linux-gnu":                                             {0, 0, 0},
+	}
+	for version, expected := range cases {
+		major, minor, ok := parseCockroachVersion(version)
+		if major != expected[0] || minor != expected[1] || ok != (expected[2] == 1) {
+			t.Errorf("Unexpected version %d.%d (%t) for %s", major, minor, ok, version)
+		}
+	}
+}
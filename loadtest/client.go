@@ -0,0 +1,180 @@
+package loadtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// client talks to the api of a running instance as one user
+type client struct {
+	http    *http.Client
+	api     string
+	user    string
+	keys    userKeys
+	session string
+	teams   []string
+	//vaults maps each team to its vaults and each vault to the secrets created during the setup
+	vaults map[string]map[string][]string
+}
+
+func newClient(hc *http.Client, api, user string) *client {
+	return &client{http: hc, api: strings.TrimSuffix(api, "/"), user: user, vaults: map[string]map[string][]string{}}
+}
+
+type apiError struct {
+	status int
+	body   string
+}
+
+func (e apiError) Error() string {
+	return fmt.Sprintf("Got %d: %s", e.status, strings.TrimSpace(e.body))
+}
+
+func (c *client) do(method, path string, body, resp interface{}) error {
+	var rb io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rb = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.api+path, rb)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(c.session) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.session)
+	}
+	r, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
+		return apiError{r.StatusCode, string(data)}
+	}
+	if resp == nil {
+		//The body is read so the connection can be reused
+		_, err = io.Copy(ioutil.Discard, r.Body)
+		return err
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+func (c *client) password() string {
+	return "loadtest-" + c.user
+}
+
+func (c *client) register(email string) error {
+	c.keys = generateKeys()
+	vkp := c.keys.vaultKeys(c.user)
+	return c.do("POST", "/auth/register", map[string]interface{}{
+		"id":                c.user,
+		"email":             email,
+		"fullname":          "Load test " + c.user,
+		"password":          c.password(),
+		"user_keys":         c.keys.pack,
+		"vault_public_keys": vkp.PublicKey,
+		"vault_keys":        vkp.Keys[c.user],
+	}, nil)
+}
+
+func (c *client) login() error {
+	resp := struct {
+		Token string `json:"session_token"`
+	}{}
+	if err := c.do("POST", "/auth/login", map[string]interface{}{"id": c.user, "password": c.password()}, &resp); err != nil {
+		return err
+	}
+	c.session = resp.Token
+	return nil
+}
+
+func (c *client) createTeam(name string) (string, error) {
+	resp := struct {
+		Id string `json:"id"`
+	}{}
+	if err := c.do("POST", "/team", map[string]interface{}{"name": name, "vault_keys": c.keys.vaultKeys(c.user)}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Id, nil
+}
+
+// loadTeams fetches the teams and vaults of the user
+func (c *client) loadTeams() error {
+	resp := struct {
+		Teams []*models.Team `json:"teams"`
+	}{}
+	if err := c.do("GET", "/team", nil, &resp); err != nil {
+		return err
+	}
+	c.teams = c.teams[:0]
+	for _, t := range resp.Teams {
+		vl := struct {
+			Vaults []*models.VaultFull `json:"vaults"`
+		}{}
+		if err := c.do("GET", "/team/"+t.Id+"/vault", nil, &vl); err != nil {
+			return err
+		}
+		c.teams = append(c.teams, t.Id)
+		if _, ok := c.vaults[t.Id]; !ok {
+			c.vaults[t.Id] = map[string][]string{}
+		}
+		for _, v := range vl.Vaults {
+			if _, ok := c.vaults[t.Id][v.Id]; !ok {
+				c.vaults[t.Id][v.Id] = []string{}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *client) createVault(team, name string) error {
+	if err := c.do("POST", "/team/"+team+"/vault", map[string]interface{}{"name": name, "vault_keys": c.keys.vaultKeys(c.user)}, nil); err != nil {
+		return err
+	}
+	c.vaults[team][name] = []string{}
+	return nil
+}
+
+// secretData is opaque for the server so random bytes of the size of a typical encrypted credential are enough
+func secretData() []byte {
+	return util.GenerateRandomByteArray(256)
+}
+
+func (c *client) createSecret(team, vault string) (string, error) {
+	resp := struct {
+		Id string `json:"id"`
+	}{}
+	if err := c.do("POST", "/team/"+team+"/vault/"+vault+"/secret", map[string]interface{}{"data": secretData()}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Id, nil
+}
+
+func (c *client) updateSecret(team, vault, sid string) error {
+	return c.do("PUT", "/team/"+team+"/vault/"+vault+"/secret/"+sid, map[string]interface{}{"data": secretData()}, nil)
+}
+
+func (c *client) listTeamSecrets(team string) error {
+	return c.do("GET", "/team/"+team+"/secret", nil, nil)
+}
+
+func (c *client) listVaultSecrets(team, vault string) error {
+	return c.do("GET", "/team/"+team+"/vault/"+vault+"/secret", nil, nil)
+}
+
+func (c *client) listTeams() error {
+	return c.do("GET", "/team", nil, nil)
+}
@@ -0,0 +1,119 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// histogramBuckets are the upper bounds of the latency buckets. Each one is about 1.5 times the previous one so the
+// percentiles have the same relative precision from sub millisecond requests to the slow ones
+var histogramBuckets = func() []time.Duration {
+	buckets := []time.Duration{}
+	for b := 100 * time.Microsecond; b < time.Minute; b = b * 3 / 2 {
+		buckets = append(buckets, b)
+	}
+	return buckets
+}()
+
+// histogram counts the latencies of an operation
+type histogram struct {
+	counts []uint64
+	total  uint64
+	errors uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(histogramBuckets)+1)}
+}
+
+func (h *histogram) observe(d time.Duration, failed bool) {
+	h.counts[sort.Search(len(histogramBuckets), func(i int) bool { return histogramBuckets[i] >= d })]++
+	h.total++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+	if failed {
+		h.errors++
+	}
+}
+
+// percentile returns the upper bound of the bucket the percentile falls in
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(p*float64(h.total) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if i == len(histogramBuckets) || histogramBuckets[i] > h.max {
+				return h.max
+			}
+			return histogramBuckets[i]
+		}
+	}
+	return h.max
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// Report keeps a histogram for each operation. It can be fed from many workers at the same time
+type Report struct {
+	lock    *sync.Mutex
+	ops     map[string]*histogram
+	Elapsed time.Duration
+}
+
+func newReport() *Report {
+	return &Report{lock: &sync.Mutex{}, ops: map[string]*histogram{}}
+}
+
+func (r *Report) observe(op string, d time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	h, ok := r.ops[op]
+	if !ok {
+		h = newHistogram()
+		r.ops[op] = h
+	}
+	h.observe(d, err != nil)
+}
+
+// Print writes a table with the throughput and latencies of every operation
+func (r *Report) Print(w io.Writer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	names := make([]string, 0, len(r.ops))
+	for name := range r.ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OPERATION\tREQUESTS\tERRORS\tREQ/S\tMEAN\tP50\tP90\tP99\tMAX\t")
+	for _, name := range names {
+		h := r.ops[name]
+		rate := 0.0
+		if r.Elapsed > 0 {
+			rate = float64(h.total) / r.Elapsed.Seconds()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\t\n", name, h.total, h.errors, rate,
+			h.mean().Round(time.Microsecond), h.percentile(0.5), h.percentile(0.9), h.percentile(0.99), h.max.Round(time.Microsecond))
+	}
+	tw.Flush()
+}
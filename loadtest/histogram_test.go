@@ -0,0 +1,49 @@
+package loadtest
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentiles(t *testing.T) {
+	h := newHistogram()
+	for i := 1; i <= 100; i++ {
+		h.observe(time.Duration(i)*time.Millisecond, i%10 == 0)
+	}
+	if h.total != 100 || h.errors != 10 || h.max != 100*time.Millisecond {
+		t.Fatalf("Unexpected totals %d/%d and max %s", h.total, h.errors, h.max)
+	}
+	//The percentiles are bucket bounds so they can be up to 1.5 times the real value
+	checks := map[float64]time.Duration{0.5: 50 * time.Millisecond, 0.9: 90 * time.Millisecond, 0.99: 99 * time.Millisecond}
+	for p, real := range checks {
+		if got := h.percentile(p); got < real || got > real*3/2 {
+			t.Errorf("Expected p%.0f to be close to %s and got %s", p*100, real, got)
+		}
+	}
+	if got := h.percentile(1); got != h.max {
+		t.Errorf("Expected p100 to be the max and got %s", got)
+	}
+	if newHistogram().percentile(0.5) != 0 {
+		t.Errorf("Empty histograms have no percentiles")
+	}
+}
+
+func TestReportPrint(t *testing.T) {
+	r := newReport()
+	r.observe("list_teams", time.Millisecond, nil)
+	r.observe("list_teams", 2*time.Millisecond, errors.New("boom"))
+	r.observe("create_secret", 5*time.Millisecond, nil)
+	r.Elapsed = time.Second
+	b := &bytes.Buffer{}
+	r.Print(b)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "create_secret") || !strings.Contains(lines[2], "list_teams") {
+		t.Fatalf("Unexpected report:\n%s", b)
+	}
+	if fields := strings.Fields(lines[2]); fields[1] != "2" || fields[2] != "1" || fields[3] != "2.0" {
+		t.Errorf("Unexpected counts in %q", lines[2])
+	}
+}
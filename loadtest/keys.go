@@ -0,0 +1,70 @@
+package loadtest
+
+import (
+	"crypto/rand"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const boxNonceSize = 24
+
+// userKeys are the keys a client generates on registration. The server only checks the signatures so the
+// private keys are sealed with a key derived from the public one instead of the password
+type userKeys struct {
+	pub  []byte
+	priv []byte
+	pack []byte
+}
+
+func generateKeys() userKeys {
+	epub, epriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	spub, spriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	pubPack := append([]byte(spub), signAndPack(spriv, (*epub)[:])...)
+	priv := append([]byte(spriv), (*epriv)[:]...)
+	snonce := util.GenerateRandomByteArray(boxNonceSize)
+	var nonce [24]byte
+	copy(nonce[:], snonce)
+	var spass [32]byte
+	copy(spass[:], spub)
+	privPack := signAndPack(spriv, secretbox.Seal(snonce, priv, &nonce, &spass))
+	return userKeys{pubPack, priv, append(pubPack, privPack...)}
+}
+
+func signAndPack(signer []byte, msg []byte) []byte {
+	key := signer[:ed25519.PrivateKeySize]
+	sig := ed25519.Sign(ed25519.PrivateKey(key), msg)
+	response := make([]byte, ed25519.SignatureSize+len(msg))
+	copy(response[:ed25519.SignatureSize], sig)
+	copy(response[ed25519.SignatureSize:], msg)
+	return response
+}
+
+// vaultKeys generates the keys of a new vault signed by the user and shared with the given users
+func (uk userKeys) vaultKeys(ids ...string) models.VaultKeyPair {
+	vault := generateKeys()
+	vkp := models.VaultKeyPair{
+		PublicKey: signAndPack(uk.priv, vault.pub),
+		Keys:      map[string][]byte{},
+	}
+	snonce := util.GenerateRandomByteArray(boxNonceSize)
+	var nonce [24]byte
+	copy(nonce[:], snonce)
+	var sharedK [32]byte
+	copy(sharedK[:], vault.pub)
+	signedSealed := signAndPack(vault.priv, box.SealAfterPrecomputation(snonce, vault.priv, &nonce, &sharedK))
+	for _, id := range ids {
+		vkp.Keys[id] = signedSealed
+	}
+	return vkp
+}
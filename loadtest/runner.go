@@ -0,0 +1,207 @@
+package loadtest
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+type Config struct {
+	//Url of the api of the instance to test, like http://localhost:23764/api/v1
+	Url             string
+	Users           int
+	TeamsPerUser    int
+	VaultsPerTeam   int
+	SecretsPerVault int
+	//Workers send requests concurrently. Each user is only used by one of them
+	Workers  int
+	Duration time.Duration
+	//ReadRatio is the part of the requests that only read. Clients mostly poll so most requests are reads
+	ReadRatio float64
+	//Confirm confirms the email of a registered user. The instance sends the token by mail so it has to be done out of band
+	Confirm func(uid string) error
+}
+
+func (c Config) validate() error {
+	switch {
+	case len(c.Url) == 0:
+		return util.NewErrorf("Missing url of the instance")
+	case c.Users < 1 || c.Workers < 1:
+		return util.NewErrorf("At least one user and one worker are needed")
+	case c.TeamsPerUser < 0 || c.VaultsPerTeam < 0 || c.SecretsPerVault < 0:
+		return util.NewErrorf("Invalid amount of teams, vaults or secrets")
+	case c.ReadRatio < 0 || c.ReadRatio > 1:
+		return util.NewErrorf("The read ratio has to be between 0 and 1")
+	case c.Confirm == nil:
+		return util.NewErrorf("Missing a way to confirm the users")
+	}
+	return nil
+}
+
+// Result has the latencies of the requests needed to create the data and the ones of the mix of reads and writes
+type Result struct {
+	Setup *Report
+	Mix   *Report
+}
+
+// Run registers the users, fills their teams and vaults and replays the mix of reads and writes until the duration is over
+func Run(c Config) (*Result, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if c.Workers > c.Users {
+		c.Workers = c.Users
+	}
+	hc := &http.Client{
+		Timeout:   time.Minute,
+		Transport: &http.Transport{MaxIdleConnsPerHost: c.Workers},
+	}
+	//Runs are told apart so the users of a previous one don't clash with the new ones
+	run := util.GenerateRandomToken(4)
+	clients := make([]*client, c.Users)
+	for i := range clients {
+		clients[i] = newClient(hc, c.Url, fmt.Sprintf("lt%s%d", run, i))
+	}
+	res := &Result{newReport(), newReport()}
+	start := time.Now()
+	if err := c.eachWorker(clients, func(rnd *rand.Rand, cls []*client) error {
+		for _, cl := range cls {
+			if err := c.setup(res.Setup, cl); err != nil {
+				return util.NewErrorf("Could not set up user %s: %s", cl.user, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	res.Setup.Elapsed = time.Since(start)
+	start = time.Now()
+	deadline := start.Add(c.Duration)
+	c.eachWorker(clients, func(rnd *rand.Rand, cls []*client) error {
+		for time.Now().Before(deadline) {
+			c.step(res.Mix, rnd, cls[rnd.Intn(len(cls))])
+		}
+		return nil
+	})
+	res.Mix.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// eachWorker splits the clients between the workers and waits for all of them. It returns the first error
+func (c Config) eachWorker(clients []*client, fn func(*rand.Rand, []*client) error) error {
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, c.Workers)
+	for w := 0; w < c.Workers; w++ {
+		cls := []*client{}
+		for i := w; i < len(clients); i += c.Workers {
+			cls = append(cls, clients[i])
+		}
+		wg.Add(1)
+		go func(seed int64, cls []*client) {
+			defer wg.Done()
+			if err := fn(rand.New(rand.NewSource(seed)), cls); err != nil {
+				errs <- err
+			}
+		}(time.Now().UnixNano()+int64(w), cls)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func timed(r *Report, op string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	r.observe(op, time.Since(start), err)
+	return err
+}
+
+func (c Config) setup(r *Report, cl *client) error {
+	if err := timed(r, "register", func() error { return cl.register(cl.user + "@loadtest.invalid") }); err != nil {
+		return err
+	}
+	if err := c.Confirm(cl.user); err != nil {
+		return err
+	}
+	if err := timed(r, "login", cl.login); err != nil {
+		return err
+	}
+	//Registering creates the primary team with its vault
+	for i := 1; i < c.TeamsPerUser; i++ {
+		if err := timed(r, "create_team", func() error {
+			_, err := cl.createTeam(fmt.Sprintf("team%d", i))
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	if err := timed(r, "list_teams", cl.loadTeams); err != nil {
+		return err
+	}
+	for _, team := range cl.teams {
+		for i := len(cl.vaults[team]); i < c.VaultsPerTeam; i++ {
+			if err := timed(r, "create_vault", func() error { return cl.createVault(team, fmt.Sprintf("vault%d", i)) }); err != nil {
+				return err
+			}
+		}
+		for vault := range cl.vaults[team] {
+			for i := 0; i < c.SecretsPerVault; i++ {
+				var sid string
+				if err := timed(r, "create_secret", func() (err error) {
+					sid, err = cl.createSecret(team, vault)
+					return err
+				}); err != nil {
+					return err
+				}
+				cl.vaults[team][vault] = append(cl.vaults[team][vault], sid)
+			}
+		}
+	}
+	return nil
+}
+
+// step runs one request of the mix. Reads are spread like the polls of the clients: mostly full team listings,
+// then single vaults and the team list. Writes mostly update existing secrets
+func (c Config) step(r *Report, rnd *rand.Rand, cl *client) {
+	team := cl.teams[rnd.Intn(len(cl.teams))]
+	vaults := make([]string, 0, len(cl.vaults[team]))
+	for vault := range cl.vaults[team] {
+		vaults = append(vaults, vault)
+	}
+	vault := ""
+	if len(vaults) > 0 {
+		vault = vaults[rnd.Intn(len(vaults))]
+	}
+	dice := rnd.Float64()
+	if rnd.Float64() < c.ReadRatio {
+		switch {
+		case dice < 0.5 || len(vault) == 0:
+			timed(r, "list_team_secrets", func() error { return cl.listTeamSecrets(team) })
+		case dice < 0.8:
+			timed(r, "list_vault_secrets", func() error { return cl.listVaultSecrets(team, vault) })
+		default:
+			timed(r, "list_teams", cl.listTeams)
+		}
+		return
+	}
+	if len(vault) == 0 {
+		return
+	}
+	secrets := cl.vaults[team][vault]
+	if dice < 0.7 && len(secrets) > 0 {
+		sid := secrets[rnd.Intn(len(secrets))]
+		timed(r, "update_secret", func() error { return cl.updateSecret(team, vault, sid) })
+		return
+	}
+	var sid string
+	if timed(r, "create_secret", func() (err error) {
+		sid, err = cl.createSecret(team, vault)
+		return err
+	}) == nil {
+		cl.vaults[team][vault] = append(secrets, sid)
+	}
+}
@@ -0,0 +1,108 @@
+package loadtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// fakeInstance answers like the api of an instance with a single team and vault per user
+func fakeInstance(t *testing.T) (*httptest.Server, *sync.Map) {
+	seen := &sync.Map{}
+	var secrets int64
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+		if strings.HasSuffix(r.URL.Path, "/secret") || strings.Contains(r.URL.Path, "/secret/") {
+			key = r.Method + " secret"
+		}
+		v, _ := seen.LoadOrStore(key, new(int64))
+		atomic.AddInt64(v.(*int64), 1)
+		if r.URL.Path == "/api/auth/register" {
+			req := map[string][]byte{}
+			json.NewDecoder(r.Body).Decode(&req)
+			spub := req["user_keys"][:ed25519.PublicKeySize]
+			if !ed25519.Verify(spub, req["vault_public_keys"][ed25519.SignatureSize:], req["vault_public_keys"][:ed25519.SignatureSize]) {
+				t.Errorf("The vault keys are not signed by the user")
+			}
+		}
+		var resp interface{} = map[string]string{}
+		switch {
+		case r.URL.Path == "/api/auth/login":
+			resp = map[string]string{"session_token": "token"}
+		case r.Method == "GET" && r.URL.Path == "/api/team":
+			resp = map[string]interface{}{"teams": []map[string]string{{"id": "t1"}}}
+		case r.Method == "GET" && r.URL.Path == "/api/team/t1/vault":
+			resp = map[string]interface{}{"vaults": []map[string]string{{"id": "v1"}}}
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/secret"):
+			resp = map[string]string{"id": fmt.Sprintf("s%d", atomic.AddInt64(&secrets, 1))}
+		}
+		if r.URL.Path != "/api/auth/register" && r.URL.Path != "/api/auth/login" && r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	})), seen
+}
+
+func TestRun(t *testing.T) {
+	srv, seen := fakeInstance(t)
+	defer srv.Close()
+	var confirmed int64
+	res, err := Run(Config{
+		Url:             srv.URL + "/api/",
+		Users:           3,
+		TeamsPerUser:    1,
+		VaultsPerTeam:   2,
+		SecretsPerVault: 4,
+		Workers:         5,
+		Duration:        200 * time.Millisecond,
+		ReadRatio:       0.8,
+		Confirm: func(uid string) error {
+			atomic.AddInt64(&confirmed, 1)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirmed != 3 {
+		t.Errorf("Expected 3 users to be confirmed and got %d", confirmed)
+	}
+	setup := map[string]uint64{"register": 3, "login": 3, "list_teams": 3, "create_vault": 3, "create_secret": 24}
+	for op, count := range setup {
+		if h := res.Setup.ops[op]; h == nil || h.total != count || h.errors > 0 {
+			t.Errorf("Expected %d %s without errors in the setup and got %+v", count, op, h)
+		}
+	}
+	if _, ok := res.Setup.ops["create_team"]; ok {
+		t.Errorf("The primary team is enough with one team per user")
+	}
+	var reads, writes uint64
+	for op, h := range res.Mix.ops {
+		if h.errors > 0 {
+			t.Errorf("Got %d errors in %s", h.errors, op)
+		}
+		if strings.HasPrefix(op, "list_") {
+			reads += h.total
+		} else {
+			writes += h.total
+		}
+	}
+	if reads == 0 || writes == 0 || reads < writes {
+		t.Errorf("Expected mostly reads and got %d reads and %d writes", reads, writes)
+	}
+	if v, ok := seen.Load("PUT secret"); !ok || atomic.LoadInt64(v.(*int64)) == 0 {
+		t.Errorf("Secrets have not been updated")
+	}
+	if _, err := Run(Config{Url: srv.URL, Users: 1, Workers: 1, ReadRatio: 2}); err == nil {
+		t.Errorf("Expected invalid configs to be rejected")
+	}
+}
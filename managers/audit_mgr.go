@@ -0,0 +1,60 @@
+package managers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+const AUDIT_CHECKPOINT_JOB = "audit_checkpoint"
+
+type AuditMgr interface {
+	Record(ctx context.Context, ae *models.AuditEntry) error
+	Stop()
+}
+
+type auditMgr struct {
+	checkpointKey ed25519.PrivateKey
+	sinks         []AuditSink
+}
+
+// NewAuditMgr stores the audit entries and forwards them to the sinks. With a checkpoint key the head of the audit chain
+// is signed every hour. Old entries are purged by the retention job of the api
+func NewAuditMgr(checkpointKey ed25519.PrivateKey, jobs JobMgr, sinks ...AuditSink) (AuditMgr, error) {
+	am := &auditMgr{checkpointKey, sinks}
+	if checkpointKey != nil {
+		if err := jobs.Register(AUDIT_CHECKPOINT_JOB, "@hourly", am.checkpoint); err != nil {
+			return nil, err
+		}
+	}
+	return am, nil
+}
+
+func (am *auditMgr) Record(ctx context.Context, ae *models.AuditEntry) error {
+	if err := models.RecordAuditEntry(ctx, ae); err != nil {
+		return err
+	}
+	for _, s := range am.sinks {
+		s.Send(ae)
+	}
+	return nil
+}
+
+func (am *auditMgr) checkpoint(ctx context.Context) (string, error) {
+	ac, err := models.NewAuditCheckpoint(ctx, am.checkpointKey)
+	if err != nil {
+		return "", err
+	}
+	if ac == nil {
+		return "No audit entries since the last checkpoint", nil
+	}
+	return fmt.Sprintf("Signed the audit chain at entry %d", ac.Seq), nil
+}
+
+func (am *auditMgr) Stop() {
+	for _, s := range am.sinks {
+		s.Stop()
+	}
+}
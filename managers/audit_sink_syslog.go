@@ -0,0 +1,202 @@
+package managers
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	AUDIT_FORMAT_JSON = "json"
+	AUDIT_FORMAT_CEF  = "cef"
+
+	auditSyslogDialTimeout  = 5 * time.Second
+	auditSyslogWriteTimeout = 5 * time.Second
+	auditSyslogMaxBackoff   = time.Minute
+	//facility local0 (16) and severity informational (6)
+	auditSyslogPriority = 16*8 + 6
+)
+
+type AuditSink interface {
+	Send(ae *models.AuditEntry)
+	Stop()
+}
+
+type auditSinkSyslog struct {
+	network  string
+	address  string
+	format   string
+	hostname string
+	entries  chan *models.AuditEntry
+	stopChan chan bool
+	wg       *sync.WaitGroup
+	conn     net.Conn
+}
+
+// NewAuditSinkSyslog forwards the audit entries as RFC5424 syslog messages over tcp, tls or udp.
+// Messages are newline delimited so they can be fed directly into most SIEM tcp inputs
+func NewAuditSinkSyslog(network, address, format string) (AuditSink, error) {
+	switch network {
+	case "tcp", "udp", "tls":
+	default:
+		return nil, util.NewErrorf("Invalid audit syslog network %s", network)
+	}
+	switch format {
+	case AUDIT_FORMAT_JSON, AUDIT_FORMAT_CEF:
+	default:
+		return nil, util.NewErrorf("Invalid audit syslog format %s", format)
+	}
+	if len(address) == 0 {
+		return nil, util.NewErrorf("Invalid audit syslog address")
+	}
+	hostname, err := os.Hostname()
+	if err != nil || len(hostname) == 0 {
+		hostname = "-"
+	}
+	as := &auditSinkSyslog{
+		network:  network,
+		address:  address,
+		format:   format,
+		hostname: hostname,
+		entries:  make(chan *models.AuditEntry, 1000),
+		stopChan: make(chan bool),
+		wg:       &sync.WaitGroup{},
+	}
+	as.wg.Add(1)
+	go as.sendLoop()
+	return as, nil
+}
+
+func (as *auditSinkSyslog) Send(ae *models.AuditEntry) {
+	select {
+	case as.entries <- ae:
+	default:
+		log.Printf("[ERROR] Audit syslog queue is full. Dropping entry %s", ae.Id)
+	}
+}
+
+func (as *auditSinkSyslog) Stop() {
+	close(as.stopChan)
+	as.wg.Wait()
+}
+
+func (as *auditSinkSyslog) sendLoop() {
+	defer as.wg.Done()
+	defer as.disconnect()
+	for {
+		select {
+		case <-as.stopChan:
+			as.drain()
+			return
+		case ae := <-as.entries:
+			as.deliver(ae)
+		}
+	}
+}
+
+// drain tries once to send whatever is still queued when stopping
+func (as *auditSinkSyslog) drain() {
+	for {
+		select {
+		case ae := <-as.entries:
+			if err := as.write(as.message(ae)); err != nil {
+				log.Printf("[ERROR] Could not forward audit entry %s while stopping: %s", ae.Id, err)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// deliver keeps retrying with backoff until the entry is sent or the sink is stopped
+func (as *auditSinkSyslog) deliver(ae *models.AuditEntry) {
+	msg := as.message(ae)
+	backoff := time.Second
+	for {
+		err := as.write(msg)
+		if err == nil {
+			return
+		}
+		log.Printf("[ERROR] Could not forward audit entry %s to %s: %s. Retrying in %s", ae.Id, as.address, err, backoff)
+		select {
+		case <-as.stopChan:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > auditSyslogMaxBackoff {
+			backoff = auditSyslogMaxBackoff
+		}
+	}
+}
+
+func (as *auditSinkSyslog) connect() error {
+	var err error
+	switch as.network {
+	case "tls":
+		as.conn, err = tls.DialWithDialer(&net.Dialer{Timeout: auditSyslogDialTimeout}, "tcp", as.address, nil)
+	default:
+		as.conn, err = net.DialTimeout(as.network, as.address, auditSyslogDialTimeout)
+	}
+	return err
+}
+
+func (as *auditSinkSyslog) disconnect() {
+	if as.conn != nil {
+		as.conn.Close()
+		as.conn = nil
+	}
+}
+
+func (as *auditSinkSyslog) write(msg []byte) error {
+	if as.conn == nil {
+		if err := as.connect(); err != nil {
+			return err
+		}
+	}
+	as.conn.SetWriteDeadline(time.Now().Add(auditSyslogWriteTimeout))
+	if _, err := as.conn.Write(msg); err != nil {
+		as.disconnect()
+		return err
+	}
+	return nil
+}
+
+func (as *auditSinkSyslog) message(ae *models.AuditEntry) []byte {
+	var body string
+	if as.format == AUDIT_FORMAT_CEF {
+		body = auditCEF(ae)
+	} else {
+		data, _ := json.Marshal(ae)
+		body = string(data)
+	}
+	return []byte(fmt.Sprintf("<%d>1 %s %s keycatd - audit - %s\n", auditSyslogPriority, ae.CreatedAt.UTC().Format(time.RFC3339Nano), as.hostname, body))
+}
+
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+func auditCEF(ae *models.AuditEntry) string {
+	ext := []string{
+		"rt=" + fmt.Sprint(ae.CreatedAt.UnixNano()/int64(time.Millisecond)),
+		"externalId=" + cefValueEscaper.Replace(ae.Id),
+		"suser=" + cefValueEscaper.Replace(ae.Actor),
+		"src=" + cefValueEscaper.Replace(ae.Ip),
+		"requestClientApplication=" + cefValueEscaper.Replace(ae.Agent),
+		"cs1Label=object",
+		"cs1=" + cefValueEscaper.Replace(ae.Object),
+		"cs2Label=requestId",
+		"cs2=" + cefValueEscaper.Replace(ae.RequestId),
+	}
+	action := cefHeaderEscaper.Replace(ae.Action)
+	return fmt.Sprintf("CEF:0|Keycat|keycatd|%s|%s|%s|3|%s", cefHeaderEscaper.Replace(util.GetServerVersion()), action, action, strings.Join(ext, " "))
+}
@@ -0,0 +1,53 @@
+package managers
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestAuditSinkSyslog(t *testing.T) {
+	if _, err := NewAuditSinkSyslog("tcp", "localhost:1", "xml"); err == nil {
+		t.Errorf("Expected an error for an invalid format")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+	as, err := NewAuditSinkSyslog("tcp", ln.Addr().String(), AUDIT_FORMAT_CEF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer as.Stop()
+	as.Send(&models.AuditEntry{Id: "a1", Actor: "u1", Action: "secret.create", Object: "team:t|1", Ip: "1.2.3.4", RequestId: "rid", CreatedAt: time.Now()})
+	select {
+	case line := <-lines:
+		if !strings.HasPrefix(line, "<134>1 ") {
+			t.Errorf("Unexpected syslog header: %s", line)
+		}
+		for _, part := range []string{"CEF:0|Keycat|keycatd|", "|secret.create|secret.create|3|", "suser=u1", "cs1=team:t|1", "cs2=rid"} {
+			if !strings.Contains(line, part) {
+				t.Errorf("Expected %s in %s", part, line)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Audit entry was not forwarded")
+	}
+}
@@ -0,0 +1,65 @@
+package managers
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Blob is an opaque file like an avatar
+type Blob struct {
+	ContentType string
+	Data        []byte
+	UpdatedAt   time.Time
+}
+
+// BlobMgr keeps the files that don't belong in a row of their own. Get and Delete return models.ErrDoesntExist for
+// unknown keys
+type BlobMgr interface {
+	Put(key string, b *Blob) error
+	Get(key string) (*Blob, error)
+	Delete(key string) error
+}
+
+type blobMgrDB struct {
+	dbp *sql.DB
+}
+
+// NewBlobMgrDB stores the blobs in the db so every instance of a cluster serves them
+func NewBlobMgrDB(dbp *sql.DB) BlobMgr {
+	return blobMgrDB{dbp}
+}
+
+func (bm blobMgrDB) Put(key string, b *Blob) error {
+	b.UpdatedAt = time.Now().UTC()
+	_, err := bm.dbp.Exec(`INSERT INTO "blob" ("key", "content_type", "data", "updated_at") VALUES ($1, $2, $3, $4)
+		ON CONFLICT ("key") DO UPDATE SET "content_type" = EXCLUDED."content_type", "data" = EXCLUDED."data", "updated_at" = EXCLUDED."updated_at"`, key, b.ContentType, b.Data, b.UpdatedAt)
+	return util.NewErrorFrom(err)
+}
+
+func (bm blobMgrDB) Get(key string) (*Blob, error) {
+	b := &Blob{}
+	err := bm.dbp.QueryRow(`SELECT "content_type", "data", "updated_at" FROM "blob" WHERE "key" = $1`, key).Scan(&b.ContentType, &b.Data, &b.UpdatedAt)
+	if util.CheckErr(err, sql.ErrNoRows) {
+		return nil, util.NewErrorFrom(models.ErrDoesntExist)
+	}
+	if err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	return b, nil
+}
+
+func (bm blobMgrDB) Delete(key string) error {
+	res, err := bm.dbp.Exec(`DELETE FROM "blob" WHERE "key" = $1`, key)
+	if err != nil {
+		return util.NewErrorFrom(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return util.NewErrorFrom(err)
+	} else if n == 0 {
+		return util.NewErrorFrom(models.ErrDoesntExist)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package managers
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestBlobMgrDB(t *testing.T) {
+	bm := NewBlobMgrDB(mdb)
+	key := "test/" + util.GenerateRandomToken(10)
+	if _, err := bm.Get(key); !util.CheckErr(err, models.ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", models.ErrDoesntExist, err)
+	}
+	if err := bm.Put(key, &Blob{ContentType: "text/plain", Data: []byte("first")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.Put(key, &Blob{ContentType: "image/png", Data: []byte("second")}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := bm.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.ContentType != "image/png" || !bytes.Equal(b.Data, []byte("second")) || b.UpdatedAt.IsZero() {
+		t.Fatalf("Unexpected blob %#v", b)
+	}
+	if err := bm.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.Delete(key); !util.CheckErr(err, models.ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", models.ErrDoesntExist, err)
+	}
+}
@@ -2,6 +2,7 @@ package managers
 
 import (
 	"encoding/json"
+	"strings"
 
 	"github.com/keydotcat/keycatd/models"
 )
@@ -13,18 +14,38 @@ const (
 	BCAST_ACTION_SECRET_CHANGE = BroadcastAction("secret:change")
 	BCAST_ACTION_SECRET_REMOVE = BroadcastAction("secret:remove")
 	BCAST_ACTION_VAULT_VERSION = BroadcastAction("vault:version")
+	//Device revocations are sent to the whole team without a vault
+	BCAST_ACTION_DEVICE_REVOKE = BroadcastAction("device:revoke")
 )
 
+// Class returns the event class of the action (secret, vault or device)
+func (a BroadcastAction) Class() string {
+	return strings.SplitN(string(a), ":", 2)[0]
+}
+
 type Broadcast struct {
 	Team    string
 	Vault   string
+	Action  BroadcastAction
 	Message []byte
+	//Remote broadcasts come from another instance of the cluster
+	Remote bool
+}
+
+// Invalidation tells that another instance changed something that may be kept in memory
+type Invalidation struct {
+	Kind string
+	Key  string
 }
 
 type BroadcasterMgr interface {
 	Subscribe(address string) <-chan *Broadcast
 	Unsubscribe(address string)
 	Send(team, vault string, action BroadcastAction, secret *models.Secret)
+	//Invalidate asks the rest of the instances to drop what they keep in memory of the key
+	Invalidate(kind, key string)
+	//Invalidations receives what the rest of the instances have invalidated. Nothing is received outside a cluster
+	Invalidations() <-chan Invalidation
 	Stop()
 }
 
@@ -41,5 +62,5 @@ func createBroadcast(team, vault string, action BroadcastAction, secret *models.
 	if err != nil {
 		panic(err)
 	}
-	return &Broadcast{team, vault, msg}
+	return &Broadcast{team, vault, action, msg, false}
 }
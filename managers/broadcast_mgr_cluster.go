@@ -0,0 +1,169 @@
+package managers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+const (
+	clusterBroadcastChannel = "keycatd_broadcast"
+	//clusterInvalidationQueue is how many invalidations can be waiting to be applied before new ones are dropped
+	clusterInvalidationQueue = 64
+)
+
+// clusterTransport carries the broadcasts between the instances of a cluster
+type clusterTransport interface {
+	publish(payload []byte) error
+	//receive returns the payloads published by all instances. It gets closed by close
+	receive() <-chan []byte
+	//maxPayload is the biggest payload the transport can carry or 0 if there's no limit
+	maxPayload() int
+	close() error
+}
+
+type clusterEnvelope struct {
+	Origin    string          `json:"origin"`
+	Team      string          `json:"team"`
+	Vault     string          `json:"vault"`
+	Action    BroadcastAction `json:"action"`
+	Message   json.RawMessage `json:"message"`
+	Truncated bool            `json:"truncated,omitempty"`
+	//Invalidate is set for the envelopes that carry an invalidation instead of a broadcast
+	Invalidate string `json:"invalidate,omitempty"`
+	Key        string `json:"key,omitempty"`
+}
+
+// clusterBroadcasterMgr delivers the broadcasts to the local subscribers and publishes them so
+// the rest of the instances can deliver them to their own subscribers
+type clusterBroadcasterMgr struct {
+	*InternalBroadcasterMgr
+	ctx           context.Context
+	origin        string
+	transport     clusterTransport
+	invalidations chan Invalidation
+	wg            *sync.WaitGroup
+}
+
+func newClusterBroadcasterMgr(db *sql.DB, origin string, t clusterTransport) *clusterBroadcasterMgr {
+	cbm := &clusterBroadcasterMgr{
+		NewInternalBroadcasterMgr().(*InternalBroadcasterMgr),
+		models.AddDBToContext(context.Background(), db),
+		origin,
+		t,
+		make(chan Invalidation, clusterInvalidationQueue),
+		&sync.WaitGroup{},
+	}
+	cbm.wg.Add(1)
+	go cbm.receiveLoop()
+	return cbm
+}
+
+func (cbm *clusterBroadcasterMgr) Send(team, vault string, action BroadcastAction, secret *models.Secret) {
+	b := createBroadcast(team, vault, action, secret)
+	cbm.sendBroadcast(b)
+	if err := cbm.publish(b); err != nil {
+		log.Printf("[ERROR] Could not publish broadcast for team %s to the cluster: %s", team, err)
+	}
+}
+
+func (cbm *clusterBroadcasterMgr) Invalidate(kind, key string) {
+	payload, err := json.Marshal(clusterEnvelope{Origin: cbm.origin, Invalidate: kind, Key: key})
+	if err == nil {
+		err = cbm.transport.publish(payload)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Could not publish the invalidation of %s %s to the cluster: %s", kind, key, err)
+	}
+}
+
+func (cbm *clusterBroadcasterMgr) Invalidations() <-chan Invalidation {
+	return cbm.invalidations
+}
+
+func (cbm *clusterBroadcasterMgr) publish(b *Broadcast) error {
+	env := clusterEnvelope{cbm.origin, b.Team, b.Vault, b.Action, b.Message, false, "", ""}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if max := cbm.transport.maxPayload(); max > 0 && len(payload) > max {
+		//Secrets that don't fit are sent without data and the receivers load them from the db
+		p := &BroadcastPayload{}
+		if err := json.Unmarshal(b.Message, p); err != nil {
+			return err
+		}
+		if p.Secret != nil {
+			p.Secret = &models.Secret{Vault: p.Secret.Vault, Id: p.Secret.Id, Version: p.Secret.Version, VaultVersion: p.Secret.VaultVersion}
+		}
+		if env.Message, err = json.Marshal(p); err != nil {
+			return err
+		}
+		env.Truncated = true
+		if payload, err = json.Marshal(env); err != nil {
+			return err
+		}
+	}
+	return cbm.transport.publish(payload)
+}
+
+func (cbm *clusterBroadcasterMgr) receiveLoop() {
+	defer cbm.wg.Done()
+	for payload := range cbm.transport.receive() {
+		b, err := cbm.decode(payload)
+		if err != nil {
+			log.Printf("[ERROR] Could not process broadcast from the cluster: %s", err)
+			continue
+		}
+		if b != nil {
+			cbm.sendBroadcast(b)
+		}
+	}
+}
+
+// decode returns nil for the broadcasts this instance published
+func (cbm *clusterBroadcasterMgr) decode(payload []byte) (b *Broadcast, err error) {
+	defer recoverAsErr(&err)
+	env := clusterEnvelope{}
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return nil, err
+	}
+	if env.Origin == cbm.origin {
+		return nil, nil
+	}
+	if len(env.Invalidate) > 0 {
+		select {
+		case cbm.invalidations <- Invalidation{env.Invalidate, env.Key}:
+		default:
+			log.Printf("[ERROR] Dropped the invalidation of %s %s because too many are queued", env.Invalidate, env.Key)
+		}
+		return nil, nil
+	}
+	if env.Truncated {
+		p := &BroadcastPayload{}
+		if err := json.Unmarshal(env.Message, p); err != nil {
+			return nil, err
+		}
+		//Removed secrets are gone from the db and the clients only need their ids
+		if p.Secret != nil && env.Action != BCAST_ACTION_SECRET_REMOVE {
+			s, err := models.Vault{Team: env.Team, Id: env.Vault}.GetSecret(cbm.ctx, p.Secret.Id)
+			if err != nil {
+				return nil, err
+			}
+			env.Message = createBroadcast(env.Team, env.Vault, env.Action, s).Message
+		}
+	}
+	return &Broadcast{env.Team, env.Vault, env.Action, env.Message, true}, nil
+}
+
+// Stop disconnects from the cluster. Local subscribers keep their channels until they unsubscribe
+func (cbm *clusterBroadcasterMgr) Stop() {
+	if err := cbm.transport.close(); err != nil {
+		log.Printf("[ERROR] Could not disconnect from the cluster broadcasts: %s", err)
+	}
+	cbm.wg.Wait()
+}
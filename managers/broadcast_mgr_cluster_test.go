@@ -0,0 +1,141 @@
+package managers
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+// memTransport connects the cluster broadcasters of the tests without any server in between
+type memTransport struct {
+	lock  *sync.Mutex
+	peers *[]*memTransport
+	msgs  chan []byte
+	max   int
+}
+
+func newMemCluster(size, max int) []*memTransport {
+	peers := &[]*memTransport{}
+	lock := &sync.Mutex{}
+	for i := 0; i < size; i++ {
+		*peers = append(*peers, &memTransport{lock, peers, make(chan []byte, 2000), max})
+	}
+	return *peers
+}
+
+func (t *memTransport) publish(payload []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, p := range *t.peers {
+		p.msgs <- payload
+	}
+	return nil
+}
+
+func (t *memTransport) receive() <-chan []byte { return t.msgs }
+func (t *memTransport) maxPayload() int        { return t.max }
+func (t *memTransport) close() error {
+	close(t.msgs)
+	return nil
+}
+
+func TestClusterBroadcasterMgr(t *testing.T) {
+	peers := newMemCluster(1, 0)
+	cbm := newClusterBroadcasterMgr(nil, "solo", peers[0])
+	defer cbm.Stop()
+	testBroadcastMgr("cluster", cbm, t)
+}
+
+func TestClusterBroadcasterFanOut(t *testing.T) {
+	peers := newMemCluster(2, 0)
+	a := newClusterBroadcasterMgr(nil, "a", peers[0])
+	b := newClusterBroadcasterMgr(nil, "b", peers[1])
+	defer a.Stop()
+	defer b.Stop()
+	ac := a.Subscribe("client-a")
+	bc := b.Subscribe("client-b")
+	a.Send("team", "vault", BCAST_ACTION_SECRET_NEW, &models.Secret{Id: "s1", Data: []byte("data")})
+	for name, c := range map[string]<-chan *Broadcast{"a": ac, "b": bc} {
+		select {
+		case m := <-c:
+			if m.Team != "team" || m.Vault != "vault" || m.Action != BCAST_ACTION_SECRET_NEW {
+				t.Errorf("Unexpected broadcast in %s: %#v", name, m)
+			}
+			if m.Remote != (name == "b") {
+				t.Errorf("Broadcast in %s has remote set to %t", name, m.Remote)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Instance %s did not get the broadcast", name)
+		}
+	}
+	//Instances ignore the broadcasts they have published themselves
+	select {
+	case m := <-ac:
+		t.Errorf("Got a duplicated broadcast %#v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+	a.Unsubscribe("client-a")
+	b.Unsubscribe("client-b")
+}
+
+func TestClusterBroadcasterTruncate(t *testing.T) {
+	peers := newMemCluster(1, 300)
+	cbm := newClusterBroadcasterMgr(nil, "a", peers[0])
+	defer cbm.Stop()
+	if err := cbm.publish(createBroadcast("team", "vault", BCAST_ACTION_SECRET_REMOVE, &models.Secret{Id: "s1", Data: make([]byte, 1000)})); err != nil {
+		t.Fatal(err)
+	}
+	payload := <-peers[0].msgs
+	if len(payload) > 300 {
+		t.Errorf("Payload is %d bytes long and the transport only accepts 300", len(payload))
+	}
+	//The removal is forwarded with the ids without loading the secret that doesn't exist anymore
+	other := newClusterBroadcasterMgr(nil, "b", newMemCluster(1, 0)[0])
+	defer other.Stop()
+	b, err := other.decode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &BroadcastPayload{}
+	if err := json.Unmarshal(b.Message, p); err != nil {
+		t.Fatal(err)
+	}
+	if b.Action != BCAST_ACTION_SECRET_REMOVE || p.Secret == nil || p.Secret.Id != "s1" || len(p.Secret.Data) > 0 {
+		t.Errorf("Unexpected truncated removal %#v", p)
+	}
+}
+
+func TestClusterBroadcasterInvalidations(t *testing.T) {
+	peers := newMemCluster(2, 0)
+	a := newClusterBroadcasterMgr(nil, "a", peers[0])
+	b := newClusterBroadcasterMgr(nil, "b", peers[1])
+	defer a.Stop()
+	defer b.Stop()
+	bc := b.Subscribe("client-b")
+	defer b.Unsubscribe("client-b")
+	a.Invalidate("user", "u1")
+	select {
+	case inv := <-b.Invalidations():
+		if inv.Kind != "user" || inv.Key != "u1" {
+			t.Errorf("Unexpected invalidation %#v", inv)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Instance b did not get the invalidation")
+	}
+	//Invalidations are neither applied by the instance that sent them nor delivered to the subscribers
+	select {
+	case inv := <-a.Invalidations():
+		t.Errorf("Instance a got its own invalidation %#v", inv)
+	case m := <-bc:
+		t.Errorf("Invalidation delivered as a broadcast %#v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+	ibm := NewInternalBroadcasterMgr()
+	defer ibm.Stop()
+	if ibm.Invalidations() != nil {
+		t.Errorf("Instances outside a cluster can't get invalidations")
+	}
+}
@@ -85,7 +85,18 @@ func (ibm *InternalBroadcasterMgr) Unsubscribe(sid string) {
 }
 
 func (ibm *InternalBroadcasterMgr) Send(team, vault string, action BroadcastAction, secret *models.Secret) {
-	ibm.sourceChan <- createBroadcast(team, vault, action, secret)
+	ibm.sendBroadcast(createBroadcast(team, vault, action, secret))
+}
+
+// Invalidate does nothing because there are no other instances
+func (ibm *InternalBroadcasterMgr) Invalidate(kind, key string) {}
+
+func (ibm *InternalBroadcasterMgr) Invalidations() <-chan Invalidation {
+	return nil
+}
+
+func (ibm *InternalBroadcasterMgr) sendBroadcast(b *Broadcast) {
+	ibm.sourceChan <- b
 }
 
 func (ibm *InternalBroadcasterMgr) Stop() {
@@ -0,0 +1,73 @@
+package managers
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const (
+	//Postgres refuses notifications with payloads of 8000 bytes or more
+	pgNotifyMaxPayload = 7999
+	pgListenerPing     = 90 * time.Second
+)
+
+type pgTransport struct {
+	db       *sql.DB
+	listener *pq.Listener
+	msgs     chan []byte
+}
+
+// NewClusterBroadcasterMgrPostgres shares the broadcasts with the rest of the instances using LISTEN/NOTIFY
+func NewClusterBroadcasterMgrPostgres(dbUrl string, db *sql.DB, origin string) (BroadcasterMgr, error) {
+	l := pq.NewListener(dbUrl, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("[ERROR] Cluster broadcast listener: %s", err)
+		}
+	})
+	if err := l.Listen(clusterBroadcastChannel); err != nil {
+		l.Close()
+		return nil, util.NewErrorf("Could not listen for cluster broadcasts: %s", err)
+	}
+	t := &pgTransport{db, l, make(chan []byte, 16)}
+	go t.run()
+	return newClusterBroadcasterMgr(db, origin, t), nil
+}
+
+func (t *pgTransport) run() {
+	defer close(t.msgs)
+	for {
+		select {
+		case n, ok := <-t.listener.Notify:
+			if !ok {
+				return
+			}
+			//A nil notification means the connection was reestablished and some broadcasts may be lost
+			if n != nil {
+				t.msgs <- []byte(n.Extra)
+			}
+		case <-time.After(pgListenerPing):
+			go t.listener.Ping()
+		}
+	}
+}
+
+func (t *pgTransport) publish(payload []byte) error {
+	_, err := t.db.Exec(`SELECT pg_notify($1, $2)`, clusterBroadcastChannel, string(payload))
+	return util.NewErrorFrom(err)
+}
+
+func (t *pgTransport) receive() <-chan []byte {
+	return t.msgs
+}
+
+func (t *pgTransport) maxPayload() int {
+	return pgNotifyMaxPayload
+}
+
+func (t *pgTransport) close() error {
+	return t.listener.Close()
+}
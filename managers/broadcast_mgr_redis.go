@@ -0,0 +1,64 @@
+package managers
+
+import (
+	"database/sql"
+
+	"github.com/keydotcat/keycatd/util"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+type redisTransport struct {
+	pool *radix.Pool
+	ps   radix.PubSubConn
+	in   chan radix.PubSubMessage
+	msgs chan []byte
+	done chan struct{}
+}
+
+// NewClusterBroadcasterMgrRedis shares the broadcasts with the rest of the instances using redis pub/sub
+func NewClusterBroadcasterMgrRedis(server string, db *sql.DB, origin string) (BroadcasterMgr, error) {
+	pool, err := radix.NewPool("tcp", server, 4, nil)
+	if err != nil {
+		return nil, err
+	}
+	t := &redisTransport{pool, radix.PersistentPubSub("tcp", server, nil), make(chan radix.PubSubMessage, 16), make(chan []byte, 16), make(chan struct{})}
+	if err := t.ps.Subscribe(t.in, clusterBroadcastChannel); err != nil {
+		t.ps.Close()
+		pool.Close()
+		return nil, util.NewErrorf("Could not subscribe to cluster broadcasts: %s", err)
+	}
+	go t.run()
+	return newClusterBroadcasterMgr(db, origin, t), nil
+}
+
+func (t *redisTransport) run() {
+	defer close(t.msgs)
+	for {
+		select {
+		case m := <-t.in:
+			t.msgs <- m.Message
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *redisTransport) publish(payload []byte) error {
+	return util.NewErrorFrom(t.pool.Do(radix.Cmd(nil, "PUBLISH", clusterBroadcastChannel, string(payload))))
+}
+
+func (t *redisTransport) receive() <-chan []byte {
+	return t.msgs
+}
+
+func (t *redisTransport) maxPayload() int {
+	return 0
+}
+
+func (t *redisTransport) close() error {
+	err := t.ps.Unsubscribe(t.in, clusterBroadcastChannel)
+	t.ps.Close()
+	close(t.done)
+	t.pool.Close()
+	return err
+}
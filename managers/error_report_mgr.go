@@ -0,0 +1,197 @@
+package managers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/facebookgo/stack"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const errorReportTimeout = 10 * time.Second
+
+type ErrorReport struct {
+	Type      string
+	Message   string
+	Stack     stack.Stack
+	RequestId string
+	Method    string
+	Url       string
+	Headers   map[string]string
+}
+
+type ErrorReportMgr interface {
+	Report(er ErrorReport)
+	Stop()
+}
+
+type errorReportMgrNULL struct{}
+
+// NewErrorReportMgrNULL only logs the reports
+func NewErrorReportMgrNULL() ErrorReportMgr {
+	return errorReportMgrNULL{}
+}
+
+func (e errorReportMgrNULL) Report(er ErrorReport) {
+	log.Printf("[ERROR] [%s] %s %s: %s\n%s", er.RequestId, er.Method, er.Url, er.Message, er.Stack)
+}
+
+func (e errorReportMgrNULL) Stop() {}
+
+type errorReportMgrSentry struct {
+	endpoint    string
+	key         string
+	release     string
+	environment string
+	serverName  string
+	client      *http.Client
+	reports     chan ErrorReport
+	wg          *sync.WaitGroup
+}
+
+// NewErrorReportMgrSentry ships the reports to any service that understands the Sentry store API
+func NewErrorReportMgrSentry(dsn, release, environment string) (ErrorReportMgr, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, util.NewErrorf("Invalid sentry dsn: %s", err)
+	}
+	if u.User == nil || len(u.User.Username()) == 0 {
+		return nil, util.NewErrorf("Invalid sentry dsn: missing public key")
+	}
+	idx := strings.LastIndex(u.Path, "/")
+	if idx == -1 || idx == len(u.Path)-1 {
+		return nil, util.NewErrorf("Invalid sentry dsn: missing project id")
+	}
+	serverName, _ := os.Hostname()
+	em := &errorReportMgrSentry{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:idx], u.Path[idx+1:]),
+		key:         u.User.Username(),
+		release:     release,
+		environment: environment,
+		serverName:  serverName,
+		client:      &http.Client{Timeout: errorReportTimeout},
+		reports:     make(chan ErrorReport, 100),
+		wg:          &sync.WaitGroup{},
+	}
+	em.wg.Add(1)
+	go em.sendLoop()
+	return em, nil
+}
+
+func (em *errorReportMgrSentry) Report(er ErrorReport) {
+	errorReportMgrNULL{}.Report(er)
+	select {
+	case em.reports <- er:
+	default:
+		log.Printf("[ERROR] Error report queue is full. Dropping report %s", er.RequestId)
+	}
+}
+
+func (em *errorReportMgrSentry) Stop() {
+	close(em.reports)
+	em.wg.Wait()
+}
+
+func (em *errorReportMgrSentry) sendLoop() {
+	defer em.wg.Done()
+	for er := range em.reports {
+		if err := em.send(er); err != nil {
+			log.Printf("[ERROR] Could not send error report %s: %s", er.RequestId, err)
+		}
+	}
+}
+
+type sentryFrame struct {
+	Filename string `json:"filename"`
+	Function string `json:"function"`
+	Lineno   int    `json:"lineno"`
+}
+
+type sentryException struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Stacktrace struct {
+		Frames []sentryFrame `json:"frames"`
+	} `json:"stacktrace"`
+}
+
+type sentryRequest struct {
+	Url     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+}
+
+type sentryEvent struct {
+	EventId     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message"`
+	Tags        map[string]string `json:"tags"`
+	Request     sentryRequest     `json:"request"`
+	Exception   struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+}
+
+func (em *errorReportMgrSentry) buildEvent(er ErrorReport) sentryEvent {
+	ev := sentryEvent{
+		EventId:     hex.EncodeToString(util.GenerateRandomByteArray(16)),
+		Timestamp:   time.Now().UTC().Format("2006-01-02T15:04:05"),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "keycatd",
+		ServerName:  em.serverName,
+		Release:     em.release,
+		Environment: em.environment,
+		Message:     er.Message,
+		Tags:        map[string]string{"request_id": er.RequestId},
+		Request:     sentryRequest{er.Url, er.Method, er.Headers},
+	}
+	ex := sentryException{Type: er.Type, Value: er.Message}
+	//Sentry wants the outermost frame first
+	ex.Stacktrace.Frames = make([]sentryFrame, len(er.Stack))
+	for i, f := range er.Stack {
+		ex.Stacktrace.Frames[len(er.Stack)-1-i] = sentryFrame{f.File, f.Name, f.Line}
+	}
+	ev.Exception.Values = []sentryException{ex}
+	return ev
+}
+
+func (em *errorReportMgrSentry) send(er ErrorReport) error {
+	body, err := json.Marshal(em.buildEvent(er))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", em.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=keycatd/%s, sentry_timestamp=%d, sentry_key=%s", em.release, time.Now().Unix(), em.key))
+	resp, err := em.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Unexpected response code %d: %s", resp.StatusCode, data)
+	}
+	return nil
+}
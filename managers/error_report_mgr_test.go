@@ -0,0 +1,53 @@
+package managers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/stack"
+)
+
+func TestSentryErrorReport(t *testing.T) {
+	if _, err := NewErrorReportMgrSentry("http://localhost/42", "test", ""); err == nil {
+		t.Errorf("Expected an error for a dsn without key")
+	}
+	if _, err := NewErrorReportMgrSentry("http://key@localhost/", "test", ""); err == nil {
+		t.Errorf("Expected an error for a dsn without project")
+	}
+	events := make(chan sentryEvent, 1)
+	var path, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("X-Sentry-Auth")
+		ev := sentryEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events <- ev
+	}))
+	defer srv.Close()
+	dsn := strings.Replace(srv.URL, "://", "://pubkey@", 1) + "/prefix/42"
+	em, err := NewErrorReportMgrSentry(dsn, "1.0", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	em.Report(ErrorReport{Type: "panic", Message: "boom", Stack: stack.Callers(0), RequestId: "rid"})
+	em.Stop()
+	ev := <-events
+	if path != "/prefix/api/42/store/" {
+		t.Errorf("Unexpected store path %s", path)
+	}
+	if !strings.Contains(auth, "sentry_key=pubkey") {
+		t.Errorf("Missing key in auth header: %s", auth)
+	}
+	if ev.Message != "boom" || ev.Tags["request_id"] != "rid" || ev.Environment != "test" {
+		t.Errorf("Unexpected event %#v", ev)
+	}
+	frames := ev.Exception.Values[0].Stacktrace.Frames
+	if len(frames) == 0 || !strings.HasSuffix(frames[len(frames)-1].Function, "TestSentryErrorReport") {
+		t.Errorf("Expected the reporting function to be the innermost frame: %#v", frames)
+	}
+}
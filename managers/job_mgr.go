@@ -0,0 +1,247 @@
+package managers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	jobTickInterval = 5 * time.Second
+	//jobLockClass namespaces the advisory locks that make sure a job only runs once at a time in the whole cluster
+	jobLockClass = 0x6a6f62
+	//jobFinishTimeout bounds storing the result of a run. It doesn't use the context of the manager since that one
+	//is already cancelled when the manager stops and waits for the running jobs
+	jobFinishTimeout = 10 * time.Second
+)
+
+var (
+	ErrJobUnknown = errors.New("Unknown job")
+	ErrJobRunning = errors.New("Job is already running")
+)
+
+// JobFunc does the work of a job and returns a short summary of what it did
+type JobFunc func(ctx context.Context) (string, error)
+
+type JobMgr interface {
+	// Register adds a job that runs on the schedule. The schedule can be overridden in the configuration
+	Register(name, schedule string, fn JobFunc) error
+	// RunOnce adds a job that runs a single time at the given time
+	RunOnce(name string, at time.Time, fn JobFunc) error
+	// Trigger runs the job right away in the background in this instance
+	Trigger(name string) error
+	Jobs(ctx context.Context) ([]*models.Job, error)
+	Stop()
+}
+
+type jobEntry struct {
+	name     string
+	spec     string
+	schedule Schedule
+	fn       JobFunc
+	next     time.Time
+	running  bool
+}
+
+type jobMgr struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	db        *sql.DB
+	leader    LeaderMgr
+	instance  string
+	overrides map[string]string
+	advisory  bool
+	lock      *sync.Mutex
+	jobs      map[string]*jobEntry
+	stopChan  chan bool
+	wg        *sync.WaitGroup
+}
+
+// NewJobMgr runs the registered jobs when they are due. Only the leader runs scheduled jobs but triggered jobs
+// run in any instance. The overrides replace the schedule of a job by name. With advisory a run holds a postgres advisory
+// lock so no other instance runs the same job at the same time. Cockroach has no advisory locks so there a job triggered
+// in another instance can overlap with the run of the leader
+func NewJobMgr(db *sql.DB, leader LeaderMgr, instance string, overrides map[string]string, advisory bool) JobMgr {
+	ctx, cancel := context.WithCancel(models.AddDBToContext(context.Background(), db))
+	jm := &jobMgr{ctx, cancel, db, leader, instance, overrides, advisory, &sync.Mutex{}, map[string]*jobEntry{}, make(chan bool), &sync.WaitGroup{}}
+	jm.wg.Add(1)
+	go jm.loop()
+	return jm
+}
+
+func (jm *jobMgr) Register(name, spec string, fn JobFunc) error {
+	if override, ok := jm.overrides[name]; ok {
+		spec = override
+	}
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	return jm.add(&jobEntry{name: name, spec: spec, schedule: schedule, fn: fn, next: schedule.Next(time.Now())})
+}
+
+func (jm *jobMgr) RunOnce(name string, at time.Time, fn JobFunc) error {
+	return jm.add(&jobEntry{name: name, spec: "once", fn: fn, next: at})
+}
+
+func (jm *jobMgr) add(je *jobEntry) error {
+	jm.lock.Lock()
+	defer jm.lock.Unlock()
+	if _, ok := jm.jobs[je.name]; ok {
+		return util.NewErrorf("Job %s is already registered", je.name)
+	}
+	if err := jm.register(je); err != nil {
+		return err
+	}
+	jm.jobs[je.name] = je
+	return nil
+}
+
+func (jm *jobMgr) register(je *jobEntry) (err error) {
+	defer recoverAsErr(&err)
+	return models.RegisterJob(jm.ctx, je.name, je.spec, je.next)
+}
+
+func (jm *jobMgr) loop() {
+	defer jm.wg.Done()
+	for {
+		select {
+		case <-jm.stopChan:
+			return
+		case now := <-time.After(jobTickInterval):
+			if jm.leader.IsLeader() {
+				jm.runDue(now)
+			}
+		}
+	}
+}
+
+func (jm *jobMgr) runDue(now time.Time) {
+	jm.lock.Lock()
+	defer jm.lock.Unlock()
+	for _, je := range jm.jobs {
+		if !je.running && !je.next.IsZero() && !je.next.After(now) {
+			jm.start(je)
+		}
+	}
+}
+
+// start has to be called with the lock held
+func (jm *jobMgr) start(je *jobEntry) {
+	je.running = true
+	jm.wg.Add(1)
+	go func() {
+		defer jm.wg.Done()
+		jm.run(je)
+	}()
+}
+
+func (jm *jobMgr) run(je *jobEntry) {
+	start := time.Now()
+	summary, err := jm.exec(je, start)
+	jm.lock.Lock()
+	je.running = false
+	if je.schedule != nil {
+		je.next = je.schedule.Next(time.Now())
+	} else if !util.CheckErr(err, ErrJobRunning) {
+		//Run once jobs are done even if they failed. They can be triggered again by hand
+		je.next = time.Time{}
+	}
+	next := je.next
+	jm.lock.Unlock()
+	if util.CheckErr(err, ErrJobRunning) {
+		return
+	}
+	if ferr := jm.finish(je, err, summary, time.Since(start), next); ferr != nil {
+		log.Printf("[ERROR] Could not store the result of job %s: %s", je.name, ferr)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Job %s failed after %s: %s", je.name, time.Since(start), err)
+	} else if len(summary) > 0 {
+		log.Printf("Job %s: %s", je.name, summary)
+	}
+}
+
+// finish stores the result of the run with its own context so it's stored even when the manager is stopping
+func (jm *jobMgr) finish(je *jobEntry, runErr error, summary string, took time.Duration, next time.Time) (err error) {
+	defer recoverAsErr(&err)
+	ctx, cancel := context.WithTimeout(models.AddDBToContext(context.Background(), jm.db), jobFinishTimeout)
+	defer cancel()
+	return models.FinishJobRun(ctx, je.name, runErr, summary, took, next)
+}
+
+// exec runs the job while holding the advisory lock for it so no other instance runs it at the same time.
+// Panics of the job or of storing its start are returned as errors so they don't take down the server
+func (jm *jobMgr) exec(je *jobEntry, start time.Time) (summary string, err error) {
+	defer recoverAsErr(&err)
+	if jm.advisory {
+		conn, err := jm.db.Conn(jm.ctx)
+		if err != nil {
+			return "", util.NewErrorFrom(err)
+		}
+		defer conn.Close()
+		var locked bool
+		if err := conn.QueryRowContext(jm.ctx, `SELECT pg_try_advisory_lock($1, hashtext($2))`, jobLockClass, je.name).Scan(&locked); err != nil {
+			return "", util.NewErrorFrom(err)
+		}
+		if !locked {
+			return "", util.NewErrorFrom(ErrJobRunning)
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, hashtext($2))`, jobLockClass, je.name)
+	}
+	if err := models.StartJobRun(jm.ctx, je.name, jm.instance, start); err != nil {
+		return "", err
+	}
+	return je.fn(jm.ctx)
+}
+
+// recoverAsErr stores a panic in err. It has to be deferred directly since recover only works there
+func recoverAsErr(err *error) {
+	if rec := recover(); rec != nil {
+		*err = util.NewErrorf("Recovered from panic: %v", rec)
+	}
+}
+
+func (jm *jobMgr) Trigger(name string) error {
+	jm.lock.Lock()
+	defer jm.lock.Unlock()
+	je, ok := jm.jobs[name]
+	switch {
+	case !ok:
+		return util.NewErrorFrom(ErrJobUnknown)
+	case je.running:
+		return util.NewErrorFrom(ErrJobRunning)
+	}
+	jm.start(je)
+	return nil
+}
+
+// Jobs returns the status of the registered jobs. Jobs that are stored but not registered anymore are left out
+func (jm *jobMgr) Jobs(ctx context.Context) ([]*models.Job, error) {
+	stored, err := models.GetJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jm.lock.Lock()
+	defer jm.lock.Unlock()
+	jobs := make([]*models.Job, 0, len(jm.jobs))
+	for _, j := range stored {
+		if _, ok := jm.jobs[j.Name]; ok {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs, nil
+}
+
+// Stop waits for the running jobs. Their context is cancelled so long jobs should check it
+func (jm *jobMgr) Stop() {
+	close(jm.stopChan)
+	jm.cancel()
+	jm.wg.Wait()
+}
@@ -0,0 +1,164 @@
+package managers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// JOB_SCHEDULE_OFF disables a scheduled job. It can still be triggered by hand
+const JOB_SCHEDULE_OFF = "off"
+
+// Schedule returns the next time a job has to run after the given one. A zero time means never
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+type everySchedule struct {
+	every time.Duration
+}
+
+func (es everySchedule) Next(after time.Time) time.Time {
+	return after.Add(es.every)
+}
+
+type offSchedule struct{}
+
+func (offSchedule) Next(after time.Time) time.Time {
+	return time.Time{}
+}
+
+// cronSchedule keeps a bit per valid value of each field
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseSchedule accepts the usual five cron fields (minute hour day-of-month month day-of-week) with
+// lists, ranges and steps, the @hourly, @daily, @weekly and @monthly aliases, "@every <duration>" and off.
+// Cron schedules are evaluated in UTC
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == JOB_SCHEDULE_OFF {
+		return offSchedule{}, nil
+	}
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || d < time.Second {
+			return nil, util.NewErrorf("Invalid schedule %s. The duration has to be at least 1s", spec)
+		}
+		return everySchedule{d}, nil
+	}
+	if alias, ok := cronAliases[spec]; ok {
+		spec = alias
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, util.NewErrorf("Invalid schedule %s. It needs five fields: minute hour day-of-month month day-of-week", spec)
+	}
+	cs := cronSchedule{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	for _, f := range []struct {
+		dst      *uint64
+		val      string
+		min, max int
+	}{
+		{&cs.minute, fields[0], 0, 59},
+		{&cs.hour, fields[1], 0, 23},
+		{&cs.dom, fields[2], 1, 31},
+		{&cs.month, fields[3], 1, 12},
+		{&cs.dow, fields[4], 0, 7},
+	} {
+		if *f.dst, err = parseCronField(f.val, f.min, f.max); err != nil {
+			return nil, util.NewErrorf("Invalid schedule %s: %s", spec, err)
+		}
+	}
+	//Sunday can be either 0 or 7
+	if cs.dow&(1<<7) > 0 {
+		cs.dow |= 1
+	}
+	return cs, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return 0, util.NewErrorf("invalid step in %s", part)
+			}
+			step, part = s, part[:i]
+		}
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, util.NewErrorf("invalid range %s", part)
+			}
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, util.NewErrorf("invalid value %s", part)
+			}
+			lo, hi = v, v
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, util.NewErrorf("%s is out of the %d-%d range", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (cs cronSchedule) dayMatches(t time.Time) bool {
+	dom := cs.dom&(1<<uint(t.Day())) > 0
+	dow := cs.dow&(1<<uint(t.Weekday())) > 0
+	//As in cron if both days are restricted matching any of them is enough
+	switch {
+	case cs.domStar || cs.dowStar:
+		return dom && dow
+	default:
+		return dom || dow
+	}
+}
+
+func (cs cronSchedule) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case cs.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !cs.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case cs.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case cs.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,36 @@
+package managers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2026, 10, 14, 10, 30, 15, 0, time.UTC)
+	for spec, expected := range map[string]time.Time{
+		"* * * * *":        time.Date(2026, 10, 14, 10, 31, 0, 0, time.UTC),
+		"@hourly":          time.Date(2026, 10, 14, 11, 0, 0, 0, time.UTC),
+		"@daily":           time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC),
+		"*/15 * * * *":     time.Date(2026, 10, 14, 10, 45, 0, 0, time.UTC),
+		"5 3 * * *":        time.Date(2026, 10, 15, 3, 5, 0, 0, time.UTC),
+		"0 0 1 * *":        time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+		"0 12 * * 7":       time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		"0 9-17/4 * * 1-5": time.Date(2026, 10, 14, 13, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":       time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		"@every 90m":       time.Date(2026, 10, 14, 12, 0, 15, 0, time.UTC),
+		"off":              {},
+	} {
+		s, err := ParseSchedule(spec)
+		if err != nil {
+			t.Fatalf("Could not parse %s: %s", spec, err)
+		}
+		if next := s.Next(base); !next.Equal(expected) {
+			t.Errorf("Expected %s to run next at %s and got %s", spec, expected, next)
+		}
+	}
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@every 1ms", "@yearly"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("Expected an error parsing '%s'", spec)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package managers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	KMS_FILE  = "file"
+	KMS_VAULT = "vault"
+)
+
+// KeyWrapMgr wraps the data keys that seal the sensitive columns with a key that is kept outside of the db
+type KeyWrapMgr interface {
+	Name() string
+	Wrap(ctx context.Context, plain []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+type keyWrapMgrFile struct {
+	aead cipher.AEAD
+}
+
+// NewKeyWrapMgrFile wraps the data keys with the 32 byte master key in the file. It can be raw, hex or base64
+func NewKeyWrapMgrFile(path string) (KeyWrapMgr, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.NewErrorf("Could not read the kms master key: %s", err)
+	}
+	key := data
+	if len(key) != 32 {
+		text := strings.TrimSpace(string(data))
+		if key, err = hex.DecodeString(text); err != nil {
+			key, err = base64.StdEncoding.DecodeString(text)
+		}
+		if err != nil || len(key) != 32 {
+			return nil, util.NewErrorf("Invalid kms master key. It has to be 32 bytes, raw or encoded in hex or base64")
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	return keyWrapMgrFile{aead}, nil
+}
+
+func (kw keyWrapMgrFile) Name() string {
+	return KMS_FILE
+}
+
+func (kw keyWrapMgrFile) Wrap(ctx context.Context, plain []byte) ([]byte, error) {
+	nonce := make([]byte, kw.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	return kw.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (kw keyWrapMgrFile) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	ns := kw.aead.NonceSize()
+	if len(wrapped) < ns {
+		return nil, util.NewErrorf("Wrapped key too short")
+	}
+	plain, err := kw.aead.Open(nil, wrapped[:ns], wrapped[ns:], nil)
+	if err != nil {
+		return nil, util.NewErrorf("Could not unwrap the key. Is it the right master key? %s", err)
+	}
+	return plain, nil
+}
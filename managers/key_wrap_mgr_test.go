@@ -0,0 +1,103 @@
+package managers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyWrapFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	master := bytes.Repeat([]byte{7}, 32)
+	ctx := context.Background()
+	var wrapped []byte
+	for i, content := range [][]byte{master, []byte(hex.EncodeToString(master) + "\n"), []byte(base64.StdEncoding.EncodeToString(master))} {
+		path := filepath.Join(dir, "key")
+		if err := ioutil.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		kw, err := NewKeyWrapMgrFile(path)
+		if err != nil {
+			t.Fatalf("Encoding %d: %s", i, err)
+		}
+		if wrapped == nil {
+			if wrapped, err = kw.Wrap(ctx, []byte("data key")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		//Every encoding of the same master key has to open the key wrapped by the first one
+		plain, err := kw.Unwrap(ctx, wrapped)
+		if err != nil {
+			t.Fatalf("Encoding %d: %s", i, err)
+		}
+		if string(plain) != "data key" {
+			t.Errorf("Mismatch in the unwrapped key: %s", plain)
+		}
+	}
+	path := filepath.Join(dir, "other")
+	ioutil.WriteFile(path, bytes.Repeat([]byte{8}, 32), 0600)
+	kw, err := NewKeyWrapMgrFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kw.Unwrap(ctx, wrapped); err == nil {
+		t.Errorf("Expected an error unwrapping with another master key")
+	}
+	ioutil.WriteFile(path, []byte("short"), 0600)
+	if _, err := NewKeyWrapMgrFile(path); err == nil {
+		t.Errorf("Expected an error for a short master key")
+	}
+}
+
+func TestKeyWrapVault(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.Header.Get("X-Vault-Token") != "vtoken" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(vaultTransitResponse{Errors: []string{"permission denied"}})
+			return
+		}
+		in := vaultTransitData{}
+		json.NewDecoder(r.Body).Decode(&in)
+		out := vaultTransitResponse{}
+		if in.Plaintext != "" {
+			out.Data.Ciphertext = "vault:v1:" + in.Plaintext
+		} else {
+			out.Data.Plaintext = in.Ciphertext[len("vault:v1:"):]
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	kw := NewKeyWrapMgrVault(srv.URL+"/", "vtoken", "/transit/", "keycat")
+	wrapped, err := kw.Wrap(ctx, []byte("data key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := kw.Unwrap(ctx, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != "data key" {
+		t.Errorf("Mismatch in the unwrapped key: %s", plain)
+	}
+	if len(paths) != 2 || paths[0] != "/v1/transit/encrypt/keycat" || paths[1] != "/v1/transit/decrypt/keycat" {
+		t.Errorf("Unexpected vault paths: %v", paths)
+	}
+	if _, err := NewKeyWrapMgrVault(srv.URL, "bad", "transit", "keycat").Wrap(ctx, []byte("x")); err == nil {
+		t.Errorf("Expected an error with an invalid vault token")
+	}
+}
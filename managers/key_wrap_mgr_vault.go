@@ -0,0 +1,99 @@
+package managers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const keyWrapVaultTimeout = 10 * time.Second
+
+type keyWrapMgrVault struct {
+	address string
+	mount   string
+	key     string
+	token   string
+	client  *http.Client
+}
+
+// NewKeyWrapMgrVault wraps the data keys with a key of the transit secrets engine of Hashicorp Vault
+func NewKeyWrapMgrVault(address, token, mount, key string) KeyWrapMgr {
+	return keyWrapMgrVault{
+		address: strings.TrimRight(address, "/"),
+		mount:   strings.Trim(mount, "/"),
+		key:     key,
+		token:   token,
+		client:  &http.Client{Timeout: keyWrapVaultTimeout},
+	}
+}
+
+func (kw keyWrapMgrVault) Name() string {
+	return KMS_VAULT
+}
+
+type vaultTransitData struct {
+	Plaintext  string `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+type vaultTransitResponse struct {
+	Data   vaultTransitData `json:"data"`
+	Errors []string         `json:"errors"`
+}
+
+func (kw keyWrapMgrVault) call(ctx context.Context, op string, in vaultTransitData) (vaultTransitData, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return vaultTransitData{}, util.NewErrorFrom(err)
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/%s/%s/%s", kw.address, kw.mount, op, kw.key), bytes.NewReader(body))
+	if err != nil {
+		return vaultTransitData{}, util.NewErrorFrom(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", kw.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := kw.client.Do(req)
+	if err != nil {
+		return vaultTransitData{}, util.NewErrorf("Could not reach vault: %s", err)
+	}
+	defer resp.Body.Close()
+	vr := vaultTransitResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&vr); err != nil && resp.StatusCode == http.StatusOK {
+		return vaultTransitData{}, util.NewErrorf("Invalid vault response: %s", err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return vaultTransitData{}, util.NewErrorf("Vault answered %d to %s: %s", resp.StatusCode, op, strings.Join(vr.Errors, ", "))
+	}
+	return vr.Data, nil
+}
+
+func (kw keyWrapMgrVault) Wrap(ctx context.Context, plain []byte) ([]byte, error) {
+	out, err := kw.call(ctx, "encrypt", vaultTransitData{Plaintext: base64.StdEncoding.EncodeToString(plain)})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out.Ciphertext), nil
+}
+
+func (kw keyWrapMgrVault) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	out, err := kw.call(ctx, "decrypt", vaultTransitData{Ciphertext: string(wrapped)})
+	if err != nil {
+		return nil, err
+	}
+	plain, err := base64.StdEncoding.DecodeString(out.Plaintext)
+	if err != nil {
+		return nil, util.NewErrorf("Invalid plaintext from vault: %s", err)
+	}
+	return plain, nil
+}
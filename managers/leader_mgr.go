@@ -0,0 +1,189 @@
+package managers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	leaderCheckInterval = 10 * time.Second
+	//leaderLockId is the advisory lock held by the instance that runs the background jobs
+	leaderLockId = 0x6b657963
+	//leaderLeaseTTL is how long a lease lasts if the leader stops renewing it
+	leaderLeaseTTL  = 3 * leaderCheckInterval
+	leaderLeaseName = "jobs"
+)
+
+// LeaderMgr decides which instance of a cluster runs the background jobs
+type LeaderMgr interface {
+	IsLeader() bool
+	Stop()
+}
+
+type leaderMgrSingle struct{}
+
+// NewLeaderMgrSingle is used when there's only one instance so it's always the leader
+func NewLeaderMgrSingle() LeaderMgr {
+	return leaderMgrSingle{}
+}
+
+func (leaderMgrSingle) IsLeader() bool {
+	return true
+}
+
+func (leaderMgrSingle) Stop() {}
+
+type leaderMgrPostgres struct {
+	db       *sql.DB
+	leader   *int32
+	conn     *sql.Conn
+	stopChan chan bool
+	wg       *sync.WaitGroup
+}
+
+// NewLeaderMgrPostgres makes the instance that holds a postgres advisory lock the leader.
+// The lock lives as long as the connection that took it so if the leader dies another instance takes over
+func NewLeaderMgrPostgres(db *sql.DB) LeaderMgr {
+	lm := &leaderMgrPostgres{db, new(int32), nil, make(chan bool), &sync.WaitGroup{}}
+	lm.check()
+	lm.wg.Add(1)
+	go lm.loop()
+	return lm
+}
+
+func (lm *leaderMgrPostgres) IsLeader() bool {
+	return atomic.LoadInt32(lm.leader) == 1
+}
+
+func (lm *leaderMgrPostgres) loop() {
+	defer lm.wg.Done()
+	for {
+		select {
+		case <-lm.stopChan:
+			return
+		case <-time.After(leaderCheckInterval):
+			lm.check()
+		}
+	}
+}
+
+func (lm *leaderMgrPostgres) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), leaderCheckInterval)
+	defer cancel()
+	if lm.conn != nil {
+		if err := lm.conn.PingContext(ctx); err == nil {
+			return
+		}
+		log.Printf("[ERROR] Lost the connection holding the leader lock")
+		atomic.StoreInt32(lm.leader, 0)
+		lm.conn.Close()
+		lm.conn = nil
+	}
+	conn, err := lm.db.Conn(ctx)
+	if err != nil {
+		log.Printf("[ERROR] Could not get a connection to check the leader lock: %s", err)
+		return
+	}
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, leaderLockId).Scan(&locked); err != nil || !locked {
+		if err != nil {
+			log.Printf("[ERROR] Could not check the leader lock: %s", err)
+		}
+		conn.Close()
+		return
+	}
+	lm.conn = conn
+	atomic.StoreInt32(lm.leader, 1)
+	log.Printf("This instance is now the leader and runs the background jobs")
+}
+
+// Stop releases the lock so another instance can take over right away
+func (lm *leaderMgrPostgres) Stop() {
+	close(lm.stopChan)
+	lm.wg.Wait()
+	if lm.conn == nil {
+		return
+	}
+	atomic.StoreInt32(lm.leader, 0)
+	if _, err := lm.conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, leaderLockId); err != nil {
+		log.Printf("[ERROR] Could not release the leader lock: %s", err)
+	}
+	lm.conn.Close()
+}
+
+type leaderMgrLease struct {
+	db       *sql.DB
+	instance string
+	leader   *int32
+	stopChan chan bool
+	wg       *sync.WaitGroup
+}
+
+// NewLeaderMgrLease makes the instance that holds the lease row the leader. The leader renews the lease on every check
+// and if it stops doing so another instance takes it once it expires. It's used with cockroach that has no advisory locks
+func NewLeaderMgrLease(db *sql.DB, instance string) LeaderMgr {
+	lm := &leaderMgrLease{db, instance, new(int32), make(chan bool), &sync.WaitGroup{}}
+	lm.check()
+	lm.wg.Add(1)
+	go lm.loop()
+	return lm
+}
+
+func (lm *leaderMgrLease) IsLeader() bool {
+	return atomic.LoadInt32(lm.leader) == 1
+}
+
+func (lm *leaderMgrLease) loop() {
+	defer lm.wg.Done()
+	for {
+		select {
+		case <-lm.stopChan:
+			return
+		case <-time.After(leaderCheckInterval):
+			lm.check()
+		}
+	}
+}
+
+// check takes the lease if it's free or expired and renews it if this instance already holds it.
+// The expiration is compared with the db clock so the clocks of the instances don't matter
+func (lm *leaderMgrLease) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), leaderCheckInterval)
+	defer cancel()
+	var holder string
+	err := lm.db.QueryRowContext(ctx, `INSERT INTO "leader_lease" ("name", "instance", "expires_at") VALUES ($1, $2, now() + $3::INT * INTERVAL '1 second')
+		ON CONFLICT ("name") DO UPDATE SET "instance" = EXCLUDED."instance", "expires_at" = EXCLUDED."expires_at"
+		WHERE "leader_lease"."instance" = EXCLUDED."instance" OR "leader_lease"."expires_at" < now() RETURNING "instance"`,
+		leaderLeaseName, lm.instance, int(leaderLeaseTTL/time.Second)).Scan(&holder)
+	wasLeader := lm.IsLeader()
+	switch {
+	case err == nil:
+		atomic.StoreInt32(lm.leader, 1)
+		if !wasLeader {
+			log.Printf("This instance is now the leader and runs the background jobs")
+		}
+	case err == sql.ErrNoRows:
+		atomic.StoreInt32(lm.leader, 0)
+	default:
+		//The lease can't be renewed so it's safer to stop before another instance takes it over
+		atomic.StoreInt32(lm.leader, 0)
+		log.Printf("[ERROR] Could not check the leader lease: %s", err)
+	}
+}
+
+// Stop releases the lease so another instance can take over without waiting for it to expire
+func (lm *leaderMgrLease) Stop() {
+	close(lm.stopChan)
+	lm.wg.Wait()
+	if !lm.IsLeader() {
+		return
+	}
+	atomic.StoreInt32(lm.leader, 0)
+	if _, err := lm.db.ExecContext(context.Background(), `DELETE FROM "leader_lease" WHERE "name" = $1 AND "instance" = $2`, leaderLeaseName, lm.instance); err != nil {
+		log.Printf("[ERROR] Could not release the leader lease: %s", err)
+	}
+}
@@ -0,0 +1,36 @@
+package managers
+
+import (
+	"testing"
+)
+
+func TestLeaderMgrLease(t *testing.T) {
+	if _, err := mdb.Exec(`DELETE FROM "leader_lease"`); err != nil {
+		t.Fatal(err)
+	}
+	first := NewLeaderMgrLease(mdb, "first")
+	second := NewLeaderMgrLease(mdb, "second").(*leaderMgrLease)
+	defer second.Stop()
+	if !first.IsLeader() || second.IsLeader() {
+		t.Fatalf("Expected only the first instance to be the leader (%t, %t)", first.IsLeader(), second.IsLeader())
+	}
+	second.check()
+	if second.IsLeader() {
+		t.Fatalf("The lease has been taken while it was held")
+	}
+	first.Stop()
+	second.check()
+	if !second.IsLeader() {
+		t.Fatalf("The lease has not been taken after the leader released it")
+	}
+	//An expired lease can be taken by anyone
+	if _, err := mdb.Exec(`UPDATE "leader_lease" SET "expires_at" = now() - INTERVAL '1 minute'`); err != nil {
+		t.Fatal(err)
+	}
+	third := NewLeaderMgrLease(mdb, "third").(*leaderMgrLease)
+	defer third.Stop()
+	second.check()
+	if !third.IsLeader() || second.IsLeader() {
+		t.Fatalf("Expected the expired lease to move to the third instance (%t, %t)", third.IsLeader(), second.IsLeader())
+	}
+}
@@ -0,0 +1,138 @@
+package managers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const matrixRequestTimeout = 10 * time.Second
+
+type MatrixMgr interface {
+	Stop()
+}
+
+type matrixNotice struct {
+	room *models.TeamMatrix
+	text string
+}
+
+type matrixMgr struct {
+	ctx     context.Context
+	bcast   BroadcasterMgr
+	enabled TeamFilter
+	client  *http.Client
+	notices chan matrixNotice
+	wg      *sync.WaitGroup
+}
+
+// NewMatrixMgr posts the activity of the teams that have a Matrix room configured and are accepted by enabled
+func NewMatrixMgr(db *sql.DB, bcast BroadcasterMgr, enabled TeamFilter) MatrixMgr {
+	mm := &matrixMgr{
+		models.AddDBToContext(context.Background(), db),
+		bcast,
+		enabled,
+		newExternalClient(matrixRequestTimeout),
+		make(chan matrixNotice, 100),
+		&sync.WaitGroup{},
+	}
+	mm.wg.Add(2)
+	go mm.listenLoop(bcast.Subscribe("matrix-mgr"))
+	go mm.sendLoop()
+	return mm
+}
+
+var matrixActionText = map[BroadcastAction]string{
+	BCAST_ACTION_SECRET_NEW:    "A secret has been created",
+	BCAST_ACTION_SECRET_CHANGE: "A secret has been modified",
+	BCAST_ACTION_SECRET_REMOVE: "A secret has been removed",
+	BCAST_ACTION_DEVICE_REVOKE: "A device of a member has been reported as compromised",
+}
+
+func (mm *matrixMgr) listenLoop(bChan <-chan *Broadcast) {
+	defer mm.wg.Done()
+	defer close(mm.notices)
+	for b := range bChan {
+		text, ok := matrixActionText[b.Action]
+		//The instance that got the change posts it
+		if !ok || b.Remote || !mm.enabled(b.Team) {
+			continue
+		}
+		room, err := mm.findRoom(b.Team)
+		if util.CheckErr(err, models.ErrDoesntExist) {
+			continue
+		} else if err != nil {
+			log.Printf("[ERROR] Could not retrieve matrix room for team %s: %s", b.Team, err)
+			continue
+		}
+		if len(b.Vault) > 0 {
+			text = fmt.Sprintf("%s in vault %s", text, b.Vault)
+		}
+		select {
+		case mm.notices <- matrixNotice{room, text}:
+		default:
+			log.Printf("[ERROR] Matrix notice queue is full. Dropping notice for team %s", b.Team)
+		}
+	}
+}
+
+// findRoom returns the room the team has configured
+func (mm *matrixMgr) findRoom(team string) (room *models.TeamMatrix, err error) {
+	defer recoverAsErr(&err)
+	return models.FindTeamMatrix(mm.ctx, team)
+}
+
+func (mm *matrixMgr) sendLoop() {
+	defer mm.wg.Done()
+	for n := range mm.notices {
+		if err := mm.send(n); err != nil {
+			log.Printf("[ERROR] Could not post matrix notice for team %s: %s", n.room.Team, err)
+		}
+	}
+}
+
+type matrixMessage struct {
+	MsgType string `json:"msgtype"`
+	Body    string `json:"body"`
+}
+
+func (mm *matrixMgr) send(n matrixNotice) error {
+	body, err := json.Marshal(matrixMessage{"m.notice", n.text})
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/_matrix/client/r0/rooms/%s/send/m.room.message/%s", n.room.Homeserver, url.PathEscape(n.room.RoomId), util.GenerateRandomToken(16))
+	req, err := http.NewRequest("PUT", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+n.room.AccessToken)
+	resp, err := mm.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Unexpected response code %d: %s", resp.StatusCode, data)
+	}
+	return nil
+}
+
+func (mm *matrixMgr) Stop() {
+	mm.bcast.Unsubscribe("matrix-mgr")
+	mm.wg.Wait()
+}
@@ -0,0 +1,50 @@
+package managers
+
+import (
+	"sync"
+	"time"
+)
+
+const rateLimitSweepInterval = time.Minute
+
+type RateLimitMgr interface {
+	// Hit counts a request for the key in a fixed window and returns the requests counted so far and when the window resets
+	Hit(key string, window time.Duration) (int, time.Time, error)
+}
+
+type rateCounter struct {
+	count int
+	reset time.Time
+}
+
+type rateLimitMgrMemory struct {
+	lock      *sync.Mutex
+	counters  map[string]*rateCounter
+	lastSweep time.Time
+}
+
+// NewRateLimitMgrMemory keeps the counters in memory so they are only valid for a single server
+func NewRateLimitMgrMemory() RateLimitMgr {
+	return &rateLimitMgrMemory{&sync.Mutex{}, map[string]*rateCounter{}, time.Now()}
+}
+
+func (rm *rateLimitMgrMemory) Hit(key string, window time.Duration) (int, time.Time, error) {
+	now := time.Now()
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+	if now.Sub(rm.lastSweep) > rateLimitSweepInterval {
+		for k, c := range rm.counters {
+			if !now.Before(c.reset) {
+				delete(rm.counters, k)
+			}
+		}
+		rm.lastSweep = now
+	}
+	c, ok := rm.counters[key]
+	if !ok || !now.Before(c.reset) {
+		c = &rateCounter{0, now.Add(window)}
+		rm.counters[key] = c
+	}
+	c.count++
+	return c.count, c.reset, nil
+}
@@ -0,0 +1,54 @@
+package managers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// rateLimitHitScript counts the hit and starts the window in a single step. Separate commands could leave a counter
+// without expiration if the key expired between them, and that client would stay limited forever
+var rateLimitHitScript = radix.NewEvalScript(1, `
+local count = redis.call("INCR", KEYS[1])
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+type rateLimitMgrRedis struct {
+	prefix string
+	dbId   string
+	pool   *radix.Pool
+}
+
+// NewRateLimitMgrRedis shares the counters between all the servers using the same redis
+func NewRateLimitMgrRedis(connUrl string, dbId int) (RateLimitMgr, error) {
+	pool, err := radix.NewPool("tcp", connUrl, 10, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rateLimitMgrRedis{"kc-rl:", strconv.Itoa(dbId), pool}, nil
+}
+
+func (r rateLimitMgrRedis) Hit(key string, window time.Duration) (int, time.Time, error) {
+	var res []int64
+	k := r.prefix + key
+	err := r.pool.Do(radix.WithConn(k, func(c radix.Conn) error {
+		if err := c.Do(radix.Cmd(nil, "SELECT", r.dbId)); err != nil {
+			return err
+		}
+		return c.Do(rateLimitHitScript.Cmd(&res, k, strconv.FormatInt(int64(window/time.Millisecond), 10)))
+	}))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if len(res) != 2 {
+		return 0, time.Time{}, util.NewErrorf("Unexpected rate limit reply from redis: %v", res)
+	}
+	return int(res[0]), time.Now().Add(time.Duration(res[1]) * time.Millisecond), nil
+}
@@ -0,0 +1,29 @@
+package managers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitMgrMemory(t *testing.T) {
+	rm := NewRateLimitMgrMemory()
+	for i := 1; i <= 3; i++ {
+		count, reset, err := rm.Hit("ip:1.2.3.4", 50*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != i {
+			t.Fatalf("Expected a count of %d and got %d", i, count)
+		}
+		if !reset.After(time.Now()) {
+			t.Fatalf("Expected the reset to be in the future")
+		}
+	}
+	if count, _, _ := rm.Hit("ip:4.3.2.1", 50*time.Millisecond); count != 1 {
+		t.Fatalf("Expected keys to be counted independently and got %d", count)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if count, _, _ := rm.Hit("ip:1.2.3.4", 50*time.Millisecond); count != 1 {
+		t.Fatalf("Expected the window to be reset and got %d", count)
+	}
+}
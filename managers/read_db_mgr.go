@@ -0,0 +1,90 @@
+package managers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const replicaCheckInterval = 10 * time.Second
+
+// ReadDBMgr picks the db that runs the read only queries
+type ReadDBMgr interface {
+	DB() *sql.DB
+	Stop()
+}
+
+type readDBMgrPrimary struct {
+	db *sql.DB
+}
+
+// NewReadDBMgrPrimary is used when there's no replica so everything is read from the primary
+func NewReadDBMgrPrimary(db *sql.DB) ReadDBMgr {
+	return readDBMgrPrimary{db}
+}
+
+func (rm readDBMgrPrimary) DB() *sql.DB {
+	return rm.db
+}
+
+func (readDBMgrPrimary) Stop() {}
+
+type readDBMgrReplica struct {
+	primary  *sql.DB
+	replica  *sql.DB
+	healthy  *int32
+	stopChan chan bool
+	wg       *sync.WaitGroup
+}
+
+// NewReadDBMgrReplica sends the read only queries to the replica while it answers the periodic pings.
+// If it stops answering the queries go to the primary until the replica is back
+func NewReadDBMgrReplica(primary, replica *sql.DB) ReadDBMgr {
+	rm := &readDBMgrReplica{primary, replica, new(int32), make(chan bool), &sync.WaitGroup{}}
+	rm.check()
+	rm.wg.Add(1)
+	go rm.loop()
+	return rm
+}
+
+func (rm *readDBMgrReplica) DB() *sql.DB {
+	if atomic.LoadInt32(rm.healthy) == 1 {
+		return rm.replica
+	}
+	return rm.primary
+}
+
+func (rm *readDBMgrReplica) loop() {
+	defer rm.wg.Done()
+	for {
+		select {
+		case <-rm.stopChan:
+			return
+		case <-time.After(replicaCheckInterval):
+			rm.check()
+		}
+	}
+}
+
+func (rm *readDBMgrReplica) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckInterval)
+	defer cancel()
+	err := rm.replica.PingContext(ctx)
+	switch {
+	case err == nil && atomic.SwapInt32(rm.healthy, 1) == 0:
+		log.Printf("Sending read only queries to the db replica")
+	case err != nil && atomic.SwapInt32(rm.healthy, 0) == 1:
+		log.Printf("[ERROR] The db replica is not reachable. Falling back to the primary: %s", err)
+	}
+}
+
+// Stop closes the connections to the replica. The primary is left alone
+func (rm *readDBMgrReplica) Stop() {
+	close(rm.stopChan)
+	rm.wg.Wait()
+	atomic.StoreInt32(rm.healthy, 0)
+	rm.replica.Close()
+}
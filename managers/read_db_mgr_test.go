@@ -0,0 +1,35 @@
+package managers
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/keydotcat/keycatd/thelpers"
+)
+
+func TestReadDBMgrReplica(t *testing.T) {
+	replica, err := sql.Open("postgres", thelpers.GetDBConnString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm := NewReadDBMgrReplica(mdb, replica)
+	if rm.DB() != replica {
+		t.Fatalf("Expected the reachable replica to be used")
+	}
+	rm.Stop()
+	if rm.DB() != mdb {
+		t.Fatalf("Expected the primary to be used once the replica is stopped")
+	}
+	down, err := sql.Open("postgres", thelpers.GetDBConnString()+" host=/nonexistent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm = NewReadDBMgrReplica(mdb, down)
+	defer rm.Stop()
+	if rm.DB() != mdb {
+		t.Fatalf("Expected to fall back to the primary when the replica is down")
+	}
+	if NewReadDBMgrPrimary(mdb).DB() != mdb {
+		t.Fatalf("Expected the primary without a replica")
+	}
+}
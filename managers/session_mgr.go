@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/gob"
+	"errors"
 	"time"
 
 	"github.com/golang/snappy"
+	"github.com/keydotcat/keycatd/models"
 	"github.com/keydotcat/keycatd/util"
 )
 
+var ErrSessionPolicy = errors.New("Session is not allowed by the session policy of the teams of the user")
+
 type Session struct {
 	Id           string    `json:"id" scaneo:"pk"`
 	User         string    `json:"user"`
@@ -18,6 +22,9 @@ type Session struct {
 	LastAccess   time.Time `json:"last_access"`
 	StoreToken   string    `json:"-"`
 	LastIp       string    `json:"last_ip"`
+	//DeviceKey is the ed25519 public key that has to sign the requests of the session if it's bound to a device
+	DeviceKey []byte    `json:"device_key,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 func encodeSession(buf *bytes.Buffer, s *Session) error {
@@ -38,11 +45,52 @@ func decodeSession(buf *bytes.Buffer, s *Session) error {
 	return util.NewErrorFrom(gob.NewDecoder(snappySource).Decode(s))
 }
 
+// SessionPolicyFunc returns the session policy merged from all the teams of a user
+type SessionPolicyFunc func(userId string) (models.SessionPolicy, error)
+
+// requireCSRF says if a new session of the user needs csrf protection because the client asked for it or a team does
+func (spf SessionPolicyFunc) requireCSRF(userId string, csrf bool) (bool, error) {
+	if spf == nil || csrf {
+		return csrf, nil
+	}
+	sp, err := spf(userId)
+	if err != nil {
+		return false, err
+	}
+	return sp.RequireCSRF, nil
+}
+
+// check returns ErrSessionPolicy if the session doesn't meet the current policy of the teams of its user. Policies
+// can change after the session was created so it's checked every time the session is used
+func (spf SessionPolicyFunc) check(s *Session, now time.Time) error {
+	if spf == nil {
+		return nil
+	}
+	sp, err := spf(s.User)
+	if err != nil {
+		return err
+	}
+	if sp.RequireCSRF && !s.RequiresCSRF {
+		return util.NewErrorFrom(ErrSessionPolicy)
+	}
+	if sp.MaxSessionHours > 0 && now.Sub(s.CreatedAt) > time.Duration(sp.MaxSessionHours)*time.Hour {
+		return util.NewErrorFrom(ErrSessionPolicy)
+	}
+	return nil
+}
+
 type SessionMgr interface {
-	NewSession(userId string, ip string, agent string, csrf bool) (*Session, error)
+	//NewSession requires csrf protection if the client or any team of the user asks for it
+	NewSession(userId string, ip string, agent string, csrf bool, deviceKey []byte) (*Session, error)
+	//UpdateSession removes the session and returns ErrSessionPolicy if it doesn't meet the policy of the teams of the user anymore
 	UpdateSession(id, ip, agent string) (*Session, error)
 	GetSession(id string) (*Session, error)
 	DeleteSession(id string) error
 	GetAllSessions(userId string) ([]*Session, error)
 	DeleteAllSessions(userId string) error
+	CountSessions() (int, error)
+	CountActiveUsers(since time.Time) (int, error)
+	PurgeSessions(before time.Time) (int, error)
+	//CountSessionsBefore returns how many sessions PurgeSessions would remove
+	CountSessionsBefore(before time.Time) (int, error)
 }
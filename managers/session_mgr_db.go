@@ -9,17 +9,21 @@ import (
 )
 
 type sessionMgrDB struct {
-	dbp *sql.DB
+	dbp    *sql.DB
+	read   ReadDBMgr
+	policy SessionPolicyFunc
 }
 
-func NewSessionMgrDB(dbp *sql.DB) SessionMgr {
-	return sessionMgrDB{dbp}
+// NewSessionMgrDB stores the sessions in the db. Sessions are read through the read db manager so the polling of the clients
+// can be served by a replica. Without a policy only the csrf flag of the clients is honored
+func NewSessionMgrDB(dbp *sql.DB, read ReadDBMgr, policy SessionPolicyFunc) SessionMgr {
+	return sessionMgrDB{dbp, read, policy}
 }
 
 func (r sessionMgrDB) doTx(ftor func(*sql.Tx) error) error {
 	tx, err := r.dbp.Begin()
 	if err != nil {
-		panic(err)
+		return util.NewErrorFrom(err)
 	}
 	if err = ftor(tx); err != nil {
 		if util.CheckErr(err, sql.ErrTxDone) || util.CheckErr(err, sql.ErrConnDone) {
@@ -36,30 +40,41 @@ func (r sessionMgrDB) doTx(ftor func(*sql.Tx) error) error {
 	return nil
 }
 
-func (r sessionMgrDB) NewSession(userId, ip, agent string, csrf bool) (*Session, error) {
-	o := Session{util.GenerateRandomToken(15), userId, agent, csrf, time.Now().UTC(), util.GenerateRandomToken(15), ip}
-	err := r.doTx(func(tx *sql.Tx) error {
-		_, err := r.dbp.Exec("INSERT INTO \"session\" "+insertSessionFields+" VALUES "+insertSessionBinds, o.Id, o.User, o.Agent, o.RequiresCSRF, o.LastAccess, o.StoreToken, o.LastIp)
+func (r sessionMgrDB) NewSession(userId, ip, agent string, csrf bool, deviceKey []byte) (*Session, error) {
+	csrf, err := r.policy.requireCSRF(userId, csrf)
+	if err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	now := time.Now().UTC()
+	o := Session{util.GenerateRandomToken(15), userId, agent, csrf, now, util.GenerateRandomToken(15), ip, deviceKey, now}
+	err = r.doTx(func(tx *sql.Tx) error {
+		_, err := r.dbp.Exec("INSERT INTO \"session\" "+insertSessionFields+" VALUES "+insertSessionBinds, o.Id, o.User, o.Agent, o.RequiresCSRF, o.LastAccess, o.StoreToken, o.LastIp, o.DeviceKey, o.CreatedAt)
 		return err
 	})
 	if err == nil {
 		return &o, nil
 	}
 	if models.IsDuplicateErr(err) {
-		return r.NewSession(userId, ip, agent, csrf)
+		return r.NewSession(userId, ip, agent, csrf, deviceKey)
 	}
-	panic(err)
+	return nil, util.NewErrorFrom(err)
 }
 
 func (r sessionMgrDB) GetSession(id string) (*Session, error) {
 	o := &Session{}
-	row := r.dbp.QueryRow("SELECT "+selectSessionFields+" FROM \"session\" WHERE "+findSessionCondition, id)
-	return o, o.dbScanRow(row)
+	rdb := r.read.DB()
+	err := o.dbScanRow(rdb.QueryRow("SELECT "+selectSessionFields+" FROM \"session\" WHERE "+findSessionCondition, id))
+	if rdb != r.dbp && util.CheckErr(err, sql.ErrNoRows) {
+		//The replica may not have the session yet if it was just created
+		err = o.dbScanRow(r.dbp.QueryRow("SELECT "+selectSessionFields+" FROM \"session\" WHERE "+findSessionCondition, id))
+	}
+	return o, err
 }
 
 func (r sessionMgrDB) UpdateSession(id, ip, agent string) (*Session, error) {
 	o := &Session{Id: id}
-	return o, r.doTx(func(tx *sql.Tx) error {
+	var violation error
+	err := r.doTx(func(tx *sql.Tx) error {
 		if err := o.dbFind(tx); err != nil {
 			if util.CheckErr(err, sql.ErrNoRows) {
 				return util.NewErrorFrom(models.ErrDoesntExist)
@@ -69,35 +84,76 @@ func (r sessionMgrDB) UpdateSession(id, ip, agent string) (*Session, error) {
 		o.Agent = agent
 		o.LastAccess = time.Now().UTC()
 		o.LastIp = ip
+		if violation = r.policy.check(o, o.LastAccess); util.CheckErr(violation, ErrSessionPolicy) {
+			_, err := o.dbDelete(tx)
+			return err
+		} else if violation != nil {
+			return violation
+		}
 		_, err := o.dbUpdate(tx)
 		return err
 	})
+	if err != nil {
+		return nil, err
+	}
+	if violation != nil {
+		return nil, violation
+	}
+	return o, nil
 }
 
 func (r sessionMgrDB) DeleteSession(id string) error {
 	_, err := r.dbp.Exec("DELETE FROM \"session\" WHERE "+findSessionCondition, id)
-	if err != nil {
-		panic(err)
-	}
-	return nil
+	return util.NewErrorFrom(err)
 }
 
 func (r sessionMgrDB) DeleteAllSessions(userId string) error {
 	_, err := r.dbp.Exec("DELETE FROM \"session\" WHERE \"user\"=$1", userId)
-	if err != nil {
-		panic(err)
-	}
-	return nil
+	return util.NewErrorFrom(err)
 }
 
 func (r sessionMgrDB) GetAllSessions(userId string) ([]*Session, error) {
-	rows, err := r.dbp.Query("SELECT "+selectSessionFields+" FROM \"session\" WHERE \"user\"=$1", userId)
+	rows, err := r.read.DB().Query("SELECT "+selectSessionFields+" FROM \"session\" WHERE \"user\"=$1", userId)
 	if err != nil {
-		panic(err)
+		return nil, util.NewErrorFrom(err)
 	}
 	return scanSessions(rows)
 }
 
+func (r sessionMgrDB) CountSessions() (int, error) {
+	var count int
+	if err := r.dbp.QueryRow("SELECT COUNT(*) FROM \"session\"").Scan(&count); err != nil {
+		return 0, util.NewErrorFrom(err)
+	}
+	return count, nil
+}
+
+func (r sessionMgrDB) CountActiveUsers(since time.Time) (int, error) {
+	var count int
+	if err := r.dbp.QueryRow("SELECT COUNT(DISTINCT \"user\") FROM \"session\" WHERE \"last_access\" >= $1", since).Scan(&count); err != nil {
+		return 0, util.NewErrorFrom(err)
+	}
+	return count, nil
+}
+
+func (r sessionMgrDB) CountSessionsBefore(before time.Time) (int, error) {
+	var count int
+	if err := r.dbp.QueryRow("SELECT COUNT(*) FROM \"session\" WHERE \"last_access\" < $1", before).Scan(&count); err != nil {
+		return 0, util.NewErrorFrom(err)
+	}
+	return count, nil
+}
+
+// PurgeSessions removes the sessions that haven't been used since before. Sessions being updated are left for the next run
+func (r sessionMgrDB) PurgeSessions(before time.Time) (int, error) {
+	res, err := r.dbp.Exec("DELETE FROM \"session\" WHERE \"id\" IN (SELECT \"id\" FROM \"session\" WHERE \"last_access\" < $1 FOR UPDATE SKIP LOCKED)", before)
+	if err != nil {
+		return 0, util.NewErrorFrom(err)
+	}
+	purged, err := res.RowsAffected()
+	return int(purged), util.NewErrorFrom(err)
+}
+
 func (r sessionMgrDB) purgeAllData() {
 	_, err := r.dbp.Exec("DELETE FROM \"session\"")
 	if err != nil {
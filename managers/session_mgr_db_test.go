@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/nacl/box"
@@ -135,7 +136,7 @@ func getVaultPrivateKeys(pubPack, privPack []byte) []byte {
 
 func addSessionForUser(rs SessionMgr, uid, agent string) error {
 	ip := "1.1.1.1"
-	s, err := rs.NewSession(uid, ip, agent, false)
+	s, err := rs.NewSession(uid, ip, agent, false, nil)
 	if err != nil {
 		return err
 	}
@@ -165,6 +166,20 @@ func testSessionManager(rs SessionMgr, t *testing.T, smName string) {
 	if err != nil {
 		t.Fatalf("%s failed test: %s", smName, err)
 	}
+	count, err := rs.CountSessions()
+	if err != nil {
+		t.Fatalf("%s failed test: %s", smName, err)
+	}
+	if count < 3 {
+		t.Errorf("%s expected at least 3 sessions and got %d", smName, count)
+	}
+	active, err := rs.CountActiveUsers(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("%s failed test: %s", smName, err)
+	}
+	if active < 1 || active > count {
+		t.Errorf("%s expected between 1 and %d active users and got %d", smName, count, active)
+	}
 	sids := map[string]bool{uid1 + ":s1": false, uid1 + ":s2": false}
 	for _, ses := range sess {
 		sids[ses.Agent] = true
@@ -195,13 +210,72 @@ func testSessionManager(rs SessionMgr, t *testing.T, smName string) {
 	if !util.CheckErr(err, models.ErrDoesntExist) {
 		t.Fatalf("%s unexpected error: %s vs %s", smName, models.ErrDoesntExist, err)
 	}
+	if _, err = rs.PurgeSessions(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("%s failed test: %s", smName, err)
+	}
+	if sess, err = rs.GetAllSessions(uid2); err != nil || len(sess) != 1 {
+		t.Fatalf("%s purged a session in use: %d sessions left (%v)", smName, len(sess), err)
+	}
+	stale, err := rs.CountSessionsBefore(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("%s failed test: %s", smName, err)
+	}
+	purged, err := rs.PurgeSessions(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("%s failed test: %s", smName, err)
+	}
+	if purged < 2 {
+		t.Errorf("%s expected at least 2 purged sessions and got %d", smName, purged)
+	}
+	if stale < 2 {
+		t.Errorf("%s expected at least 2 sessions to purge and got %d", smName, stale)
+	}
+	if sess, err = rs.GetAllSessions(uid2); err != nil || len(sess) != 0 {
+		t.Fatalf("%s did not purge the stale sessions: %d sessions left (%v)", smName, len(sess), err)
+	}
 	for _, uid := range []string{uid1, uid2} {
 		if err = rs.DeleteAllSessions(uid1); err != nil {
 			t.Fatalf("%s could not delete all sessions for user %s: %s", smName, uid, err)
 		}
 	}
 }
+func testSessionPolicy(t *testing.T, smName string, newMgr func(SessionPolicyFunc) SessionMgr) {
+	sp := models.SessionPolicy{}
+	rs := newMgr(func(string) (models.SessionPolicy, error) { return sp, nil })
+	uid := getDummyUser().Id
+	loose, err := rs.NewSession(uid, "1.1.1.3", "policy", false, nil)
+	if err != nil {
+		t.Fatalf("%s failed test: %s", smName, err)
+	}
+	sp.RequireCSRF = true
+	strict, err := rs.NewSession(uid, "1.1.1.3", "policy", false, nil)
+	if err != nil {
+		t.Fatalf("%s failed test: %s", smName, err)
+	}
+	if loose.RequiresCSRF || !strict.RequiresCSRF {
+		t.Errorf("%s expected only the session created with the policy to require csrf", smName)
+	}
+	if _, err := rs.UpdateSession(loose.Id, "1.1.1.3", "policy"); !util.CheckErr(err, ErrSessionPolicy) {
+		t.Errorf("%s expected the session without csrf to be rejected and got %v", smName, err)
+	}
+	if _, err := rs.GetSession(loose.Id); err == nil {
+		t.Errorf("%s did not remove the session rejected by the policy", smName)
+	}
+	sp.MaxSessionHours = 1
+	if _, err := rs.UpdateSession(strict.Id, "1.1.1.3", "policy"); err != nil {
+		t.Errorf("%s rejected a session within the policy: %s", smName, err)
+	}
+	strict.CreatedAt = time.Now().Add(-2 * time.Hour)
+	if err := SessionPolicyFunc(func(string) (models.SessionPolicy, error) { return sp, nil }).check(strict, time.Now()); !util.CheckErr(err, ErrSessionPolicy) {
+		t.Errorf("%s expected a session older than the cap to be rejected and got %v", smName, err)
+	}
+	if err := rs.DeleteAllSessions(uid); err != nil {
+		t.Fatalf("%s failed test: %s", smName, err)
+	}
+}
+
 func TestDBSessionManager(t *testing.T) {
-	rs := NewSessionMgrDB(mdb)
+	rs := NewSessionMgrDB(mdb, NewReadDBMgrPrimary(mdb), nil)
 	testSessionManager(rs, t, "db")
+	testSessionPolicy(t, "db", func(spf SessionPolicyFunc) SessionMgr { return NewSessionMgrDB(mdb, NewReadDBMgrPrimary(mdb), spf) })
 }
@@ -14,14 +14,16 @@ type sessionMgrRedis struct {
 	prefix string
 	dbId   string
 	pool   *radix.Pool
+	policy SessionPolicyFunc
 }
 
-func NewSessionMgrRedis(connUrl string, dbId int) (SessionMgr, error) {
+// NewSessionMgrRedis stores the sessions in redis. Without a policy only the csrf flag of the clients is honored
+func NewSessionMgrRedis(connUrl string, dbId int, policy SessionPolicyFunc) (SessionMgr, error) {
 	pool, err := radix.NewPool("tcp", connUrl, 10, nil)
 	if err != nil {
 		return nil, err
 	}
-	return sessionMgrRedis{"kc-", strconv.Itoa(dbId), pool}, nil
+	return sessionMgrRedis{"kc-", strconv.Itoa(dbId), pool, policy}, nil
 }
 
 func (r sessionMgrRedis) skey(i string) string {
@@ -32,8 +34,13 @@ func (r sessionMgrRedis) ukey(i string) string {
 	return fmt.Sprintf("%su:%s", r.prefix, i)
 }
 
-func (r sessionMgrRedis) NewSession(userId, ip, agent string, csrf bool) (*Session, error) {
-	s := &Session{util.GenerateRandomToken(15), userId, agent, csrf, time.Now().UTC(), util.GenerateRandomToken(15), ip}
+func (r sessionMgrRedis) NewSession(userId, ip, agent string, csrf bool, deviceKey []byte) (*Session, error) {
+	csrf, err := r.policy.requireCSRF(userId, csrf)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	s := &Session{util.GenerateRandomToken(15), userId, agent, csrf, now, util.GenerateRandomToken(15), ip, deviceKey, now}
 	b := util.BufPool.Get()
 	defer util.BufPool.Put(b)
 	if err := encodeSession(b, s); err != nil {
@@ -65,6 +72,81 @@ func (r sessionMgrRedis) purgeAllData() error {
 	return s.Close()
 }
 
+func (r sessionMgrRedis) CountSessions() (int, error) {
+	s := radix.NewScanner(r.pool, radix.ScanOpts{Command: "SCAN", Pattern: r.skey("*")})
+	var key string
+	count := 0
+	for s.Next(&key) {
+		count++
+	}
+	return count, s.Close()
+}
+
+func (r sessionMgrRedis) CountActiveUsers(since time.Time) (int, error) {
+	s := radix.NewScanner(r.pool, radix.ScanOpts{Command: "SCAN", Pattern: r.skey("*")})
+	var key string
+	users := map[string]bool{}
+	for s.Next(&key) {
+		ses, err := r.getSession(key[len(r.skey("")):])
+		if util.CheckErr(err, models.ErrDoesntExist) {
+			continue
+		}
+		if err != nil {
+			s.Close()
+			return 0, err
+		}
+		if !ses.LastAccess.Before(since) {
+			users[ses.User] = true
+		}
+	}
+	return len(users), s.Close()
+}
+
+func (r sessionMgrRedis) CountSessionsBefore(before time.Time) (int, error) {
+	s := radix.NewScanner(r.pool, radix.ScanOpts{Command: "SCAN", Pattern: r.skey("*")})
+	var key string
+	count := 0
+	for s.Next(&key) {
+		ses, err := r.getSession(key[len(r.skey("")):])
+		if util.CheckErr(err, models.ErrDoesntExist) {
+			continue
+		}
+		if err != nil {
+			s.Close()
+			return 0, err
+		}
+		if ses.LastAccess.Before(before) {
+			count++
+		}
+	}
+	return count, s.Close()
+}
+
+func (r sessionMgrRedis) PurgeSessions(before time.Time) (int, error) {
+	s := radix.NewScanner(r.pool, radix.ScanOpts{Command: "SCAN", Pattern: r.skey("*")})
+	var key string
+	purged := 0
+	for s.Next(&key) {
+		ses, err := r.getSession(key[len(r.skey("")):])
+		if util.CheckErr(err, models.ErrDoesntExist) {
+			continue
+		}
+		if err != nil {
+			s.Close()
+			return purged, err
+		}
+		if !ses.LastAccess.Before(before) {
+			continue
+		}
+		if err := r.delete(ses); err != nil {
+			s.Close()
+			return purged, err
+		}
+		purged++
+	}
+	return purged, s.Close()
+}
+
 func (r sessionMgrRedis) getSession(id string) (*Session, error) {
 	b := util.BufPool.Get()
 	defer util.BufPool.Put(b)
@@ -119,6 +201,18 @@ func (r sessionMgrRedis) UpdateSession(id, ip, agent string) (*Session, error) {
 	s.Agent = agent
 	s.LastAccess = time.Now().UTC()
 	s.LastIp = ip
+	if s.CreatedAt.IsZero() {
+		//Sessions stored before they had a creation time start counting now
+		s.CreatedAt = s.LastAccess
+	}
+	if err := r.policy.check(s, s.LastAccess); util.CheckErr(err, ErrSessionPolicy) {
+		if derr := r.delete(s); derr != nil {
+			return nil, derr
+		}
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
 	return s, r.storeSession(s)
 }
 
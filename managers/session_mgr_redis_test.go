@@ -3,7 +3,7 @@ package managers
 import "testing"
 
 func init() {
-	rs, err := NewSessionMgrRedis("localhost:6379", 10)
+	rs, err := NewSessionMgrRedis("localhost:6379", 10, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -13,9 +13,16 @@ func init() {
 }
 
 func TestRedisSessionManager(t *testing.T) {
-	rs, err := NewSessionMgrRedis("localhost:6379", 10)
+	rs, err := NewSessionMgrRedis("localhost:6379", 10, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	testSessionManager(rs, t, "redis")
+	testSessionPolicy(t, "redis", func(spf SessionPolicyFunc) SessionMgr {
+		rs, err := NewSessionMgrRedis("localhost:6379", 10, spf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	})
 }
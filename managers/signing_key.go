@@ -0,0 +1,64 @@
+package managers
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func readSigningKeyBlock(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.NewErrorf("Could not read the signing key %s: %s", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, util.NewErrorf("No PEM data found in %s", path)
+	}
+	return block, nil
+}
+
+// LoadSigningKey reads a PKCS8 ed25519 key (openssl genpkey -algorithm ed25519) like the ones that sign the audit
+// checkpoints and the heads of the key log
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readSigningKeyBlock(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, util.NewErrorf("Invalid signing key %s: %s", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, util.NewErrorf("The signing key has to be an ed25519 key")
+	}
+	return key, nil
+}
+
+// LoadSigningPublicKey reads the public key to verify the signatures. The private key is accepted as well
+func LoadSigningPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readSigningKeyBlock(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != "PUBLIC KEY" {
+		key, err := LoadSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, util.NewErrorf("Invalid public key %s: %s", path, err)
+	}
+	pub, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, util.NewErrorf("The signing key has to be an ed25519 key")
+	}
+	return pub, nil
+}
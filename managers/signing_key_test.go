@@ -0,0 +1,49 @@
+package managers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSigningKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDer, _ := x509.MarshalPKCS8PrivateKey(key)
+	pubDer, _ := x509.MarshalPKIXPublicKey(pub)
+	privFile, pubFile := filepath.Join(dir, "key.pem"), filepath.Join(dir, "pub.pem")
+	ioutil.WriteFile(privFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDer}), 0600)
+	ioutil.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer}), 0600)
+	loaded, err := LoadSigningKey(privFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, key) {
+		t.Errorf("Mismatch in the loaded key")
+	}
+	for _, file := range []string{privFile, pubFile} {
+		lpub, err := LoadSigningPublicKey(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(lpub, pub) {
+			t.Errorf("Mismatch in the public key loaded from %s", file)
+		}
+	}
+	if _, err := LoadSigningKey(pubFile); err == nil {
+		t.Errorf("Expected an error loading a public key as the signing key")
+	}
+}
@@ -0,0 +1,224 @@
+package managers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+const (
+	WEBHOOK_MAX_ATTEMPTS  = 8
+	WEBHOOK_BASE_BACKOFF  = 30 * time.Second
+	WEBHOOK_MAX_BACKOFF   = 6 * time.Hour
+	webhookPollInterval   = 5 * time.Second
+	webhookRequestTimeout = 10 * time.Second
+	webhookBatchSize      = 50
+)
+
+type WebhookMgr interface {
+	Stop()
+}
+
+// TeamFilter tells if a team wants the notifications of a manager
+type TeamFilter func(team string) bool
+
+type webhookMgr struct {
+	ctx         context.Context
+	bcast       BroadcasterMgr
+	leader      LeaderMgr
+	enabled     TeamFilter
+	client      *http.Client
+	enqueueDone chan bool
+	stopChan    chan bool
+	wg          *sync.WaitGroup
+}
+
+// NewWebhookMgr subscribes to the broadcaster to queue a delivery for every team webhook
+// and starts the worker that sends the queued deliveries retrying the failed ones.
+// Deliveries are queued by the instance that got the change and only sent by the leader. Teams rejected by enabled get no deliveries
+func NewWebhookMgr(db *sql.DB, bcast BroadcasterMgr, leader LeaderMgr, enabled TeamFilter) WebhookMgr {
+	wm := &webhookMgr{
+		models.AddDBToContext(context.Background(), db),
+		bcast,
+		leader,
+		enabled,
+		newExternalClient(webhookRequestTimeout),
+		make(chan bool),
+		make(chan bool),
+		&sync.WaitGroup{},
+	}
+	wm.wg.Add(2)
+	go wm.enqueueLoop(bcast.Subscribe("webhook-mgr"))
+	go wm.deliverLoop()
+	return wm
+}
+
+// newExternalClient is for the urls set by the team admins, like webhooks and homeservers. It refuses to connect to
+// internal addresses. The check is done on the resolved address so a host that resolves to one is refused too
+func newExternalClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || models.IsInternalIP(ip) {
+				return fmt.Errorf("Refusing to connect to internal address %s", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{Timeout: timeout, Transport: &http.Transport{DialContext: dialer.DialContext}}
+}
+
+func (wm *webhookMgr) enqueueLoop(bChan <-chan *Broadcast) {
+	defer wm.wg.Done()
+	defer close(wm.enqueueDone)
+	for b := range bChan {
+		if b.Remote || !wm.enabled(b.Team) {
+			continue
+		}
+		if err := wm.enqueue(b); err != nil {
+			log.Printf("[ERROR] Could not queue webhook deliveries for team %s: %s", b.Team, err)
+		}
+	}
+}
+
+// enqueue queues a delivery of the broadcast for each webhook of the team
+func (wm *webhookMgr) enqueue(b *Broadcast) (err error) {
+	defer recoverAsErr(&err)
+	payload, err := webhookPayload(b)
+	if err != nil {
+		return fmt.Errorf("Could not prepare webhook payload: %s", err)
+	}
+	return models.EnqueueWebhookDeliveries(wm.ctx, b.Team, payload)
+}
+
+// webhookPayload strips the secret contents from the broadcast. Only the metadata leaves the server
+func webhookPayload(b *Broadcast) ([]byte, error) {
+	p := &BroadcastPayload{}
+	if err := json.Unmarshal(b.Message, p); err != nil {
+		return nil, err
+	}
+	if p.Secret != nil {
+		p.Secret = &models.Secret{Vault: p.Secret.Vault, Id: p.Secret.Id, Version: p.Secret.Version, VaultVersion: p.Secret.VaultVersion}
+	}
+	return json.Marshal(p)
+}
+
+func (wm *webhookMgr) deliverLoop() {
+	defer wm.wg.Done()
+	for {
+		select {
+		case <-wm.stopChan:
+			//Flush whatever is due before leaving. The rest stays queued for the next start
+			wm.logDeliverDue()
+			return
+		case <-time.After(webhookPollInterval):
+			wm.logDeliverDue()
+		}
+	}
+}
+
+func (wm *webhookMgr) logDeliverDue() {
+	if err := wm.deliverDue(); err != nil {
+		log.Printf("[ERROR] Could not deliver pending webhooks: %s", err)
+	}
+}
+
+// deliverDue sends the deliveries that are due. Only the leader sends them
+func (wm *webhookMgr) deliverDue() (err error) {
+	defer recoverAsErr(&err)
+	if !wm.leader.IsLeader() {
+		return nil
+	}
+	ds, err := models.FindDueWebhookDeliveries(wm.ctx, webhookBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, d := range ds {
+		wm.deliver(d)
+	}
+	return nil
+}
+
+func (wm *webhookMgr) deliver(d *models.WebhookDelivery) {
+	w, err := models.FindWebhook(wm.ctx, d.Team, d.Webhook)
+	if err != nil {
+		log.Printf("[ERROR] Could not find webhook %s for delivery %s: %s", d.Webhook, d.Id, err)
+		return
+	}
+	retry, err := wm.post(w, d)
+	switch {
+	case err == nil:
+		err = d.MarkDelivered(wm.ctx)
+	case retry && d.Attempts+1 < WEBHOOK_MAX_ATTEMPTS:
+		err = d.MarkFailed(wm.ctx, err.Error(), time.Now().Add(webhookBackoff(d.Attempts+1)))
+	default:
+		err = d.MarkFailed(wm.ctx, err.Error(), time.Time{})
+	}
+	if err != nil {
+		log.Printf("[ERROR] Could not update webhook delivery %s: %s", d.Id, err)
+	}
+}
+
+// post sends the delivery and returns whether a failure deserves a retry
+func (wm *webhookMgr) post(w *models.Webhook, d *models.WebhookDelivery) (bool, error) {
+	req, err := http.NewRequest("POST", w.Url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return false, err
+	}
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(d.Payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Keycat-Delivery", d.Id)
+	req.Header.Set("X-Keycat-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := wm.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("Unexpected response code %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("Unexpected response code %d", resp.StatusCode)
+	}
+}
+
+func webhookBackoff(attempt int) time.Duration {
+	d := WEBHOOK_BASE_BACKOFF
+	for i := 1; i < attempt && d < WEBHOOK_MAX_BACKOFF; i++ {
+		d *= 2
+	}
+	if d > WEBHOOK_MAX_BACKOFF {
+		d = WEBHOOK_MAX_BACKOFF
+	}
+	return d
+}
+
+func (wm *webhookMgr) Stop() {
+	wm.bcast.Unsubscribe("webhook-mgr")
+	<-wm.enqueueDone
+	close(wm.stopChan)
+	wm.wg.Wait()
+}
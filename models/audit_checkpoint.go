@@ -0,0 +1,229 @@
+package models
+
+import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// AuditCheckpoint is the signed hash of the audit chain at seq. Without the signing key nobody can rewrite the
+// entries before it, even with write access to the db
+type AuditCheckpoint struct {
+	Seq       int64     `scaneo:"pk" json:"seq"`
+	Hash      string    `json:"hash"`
+	Signature []byte    `json:"signature"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func auditCheckpointMessage(seq int64, hash string) []byte {
+	return []byte(fmt.Sprintf("keycatd.audit.checkpoint\x00%d\x00%s", seq, hash))
+}
+
+func (ac *AuditCheckpoint) verify(pub ed25519.PublicKey) bool {
+	return ed25519.Verify(pub, auditCheckpointMessage(ac.Seq, ac.Hash), ac.Signature)
+}
+
+// NewAuditCheckpoint signs the current head of the audit chain. It returns nil if there are no entries since the last checkpoint
+func NewAuditCheckpoint(ctx context.Context, key ed25519.PrivateKey) (*AuditCheckpoint, error) {
+	ac := &AuditCheckpoint{}
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT "seq", "hash" FROM "audit_chain" WHERE "id" = 1`).Scan(&ac.Seq, &ac.Hash)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		var last int64
+		err = tx.QueryRow(`SELECT COALESCE(MAX("seq"), 0) FROM "audit_checkpoint"`).Scan(&last)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if ac.Seq == 0 || ac.Seq == last {
+			ac = nil
+			return nil
+		}
+		ac.Signature = ed25519.Sign(key, auditCheckpointMessage(ac.Seq, ac.Hash))
+		ac.CreatedAt = time.Now().UTC()
+		_, err = ac.dbInsert(tx)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+	return ac, err
+}
+
+// AuditVerification is the result of checking the audit chain. It's valid if there are no problems
+type AuditVerification struct {
+	Entries        int64    `json:"entries"`
+	First          int64    `json:"first"`
+	Last           int64    `json:"last"`
+	Unchained      int64    `json:"unchained"`
+	Checkpoints    int      `json:"checkpoints"`
+	Uncovered      int64    `json:"uncovered"`
+	Purged         int64    `json:"purged"`
+	Problems       []string `json:"problems"`
+	checkpoints    []*AuditCheckpoint
+	lastCheckpoint int64
+	prevHash       string
+	prevSeq        int64
+}
+
+func (av *AuditVerification) Valid() bool {
+	return len(av.Problems) == 0
+}
+
+func (av *AuditVerification) problem(format string, args ...interface{}) {
+	av.Problems = append(av.Problems, fmt.Sprintf(format, args...))
+}
+
+// startAuditVerification checks the signatures of the checkpoints, oldest first. Without a key only the chain is checked
+func startAuditVerification(pub ed25519.PublicKey, cps []*AuditCheckpoint) *AuditVerification {
+	av := &AuditVerification{Problems: []string{}}
+	if len(pub) != ed25519.PublicKeySize {
+		return av
+	}
+	for _, cp := range cps {
+		if !cp.verify(pub) {
+			av.problem("Checkpoint %d has an invalid signature", cp.Seq)
+			continue
+		}
+		av.checkpoints = append(av.checkpoints, cp)
+	}
+	return av
+}
+
+// entry checks that the entry follows the previous one in the chain and matches the checkpoint at its seq
+func (av *AuditVerification) entry(ae *AuditEntry) {
+	av.Entries++
+	av.link(ae.Seq, ae.Hash, func(prev string) bool { return ae.chainHash(prev) == ae.Hash }, ae.Id)
+}
+
+// tombstone follows the chain over an entry the retention policy purged. Its contents are gone so only its place in
+// the chain and the checkpoints can be checked
+func (av *AuditVerification) tombstone(at *auditTombstone) {
+	av.Purged++
+	av.link(at.Seq, at.Hash, nil, "purged")
+}
+
+func (av *AuditVerification) link(seq int64, hash string, valid func(prev string) bool, id string) {
+	switch {
+	case av.prevSeq == 0:
+		//Unless it's the first one ever, the entries before were purged so it can only be checked against a checkpoint
+		av.First = seq
+		if seq == 1 && valid != nil && !valid("") {
+			av.problem("Entry %d (%s) has been modified", seq, id)
+		}
+	case seq != av.prevSeq+1:
+		av.problem("Entries %d to %d are missing", av.prevSeq+1, seq-1)
+	case valid != nil && !valid(av.prevHash):
+		av.problem("Entry %d (%s) has been modified", seq, id)
+	}
+	for len(av.checkpoints) > 0 && av.checkpoints[0].Seq <= seq {
+		cp := av.checkpoints[0]
+		av.checkpoints = av.checkpoints[1:]
+		if cp.Seq < av.First {
+			continue
+		}
+		av.Checkpoints++
+		av.lastCheckpoint = cp.Seq
+		if cp.Seq == seq && cp.Hash != hash {
+			av.problem("Entry %d doesn't match the signed checkpoint", seq)
+		} else if cp.Seq != seq {
+			av.problem("Entry %d of a signed checkpoint is missing", cp.Seq)
+		}
+	}
+	av.prevSeq, av.prevHash, av.Last = seq, hash, seq
+}
+
+func (av *AuditVerification) finish(head int64) {
+	for _, cp := range av.checkpoints {
+		av.problem("Entry %d of a signed checkpoint is missing", cp.Seq)
+	}
+	if av.Last < head {
+		av.problem("Entries %d to %d are missing", av.Last+1, head)
+	}
+	av.Uncovered = av.Entries + av.Purged
+	if av.Checkpoints > 0 {
+		av.Uncovered = av.Last - av.lastCheckpoint
+	}
+}
+
+// VerifyAuditChain checks the hashes of every chained entry and that they match the checkpoints signed by the key of
+// pub. Entries recorded while it runs are checked the next time
+func VerifyAuditChain(ctx context.Context, pub ed25519.PublicKey) (*AuditVerification, error) {
+	var head int64
+	var cps []*AuditCheckpoint
+	var unchained int64
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		if err := tx.QueryRow(`SELECT "seq" FROM "audit_chain" WHERE "id" = 1`).Scan(&head); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM "audit_entry" WHERE "seq" = 0`).Scan(&unchained); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		rows, err := tx.Query(`SELECT `+selectAuditCheckpointFields+` FROM "audit_checkpoint" WHERE "seq" <= $1 ORDER BY "seq"`, head)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		cps, err = scanAuditCheckpoints(rows)
+		return util.NewErrorFrom(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	av := startAuditVerification(pub, cps)
+	av.Unchained = unchained
+	var last int64
+	for last < head {
+		entries, err := auditChainBatch(ctx, last, head)
+		if err != nil {
+			return nil, err
+		}
+		upper := head
+		if len(entries) == auditExportBatch {
+			upper = entries[len(entries)-1].Seq
+		}
+		tombstones, err := auditTombstonesBetween(ctx, last, upper)
+		if err != nil {
+			return nil, err
+		}
+		for _, ae := range entries {
+			for len(tombstones) > 0 && tombstones[0].Seq < ae.Seq {
+				av.tombstone(tombstones[0])
+				tombstones = tombstones[1:]
+			}
+			av.entry(ae)
+		}
+		for _, at := range tombstones {
+			av.tombstone(at)
+		}
+		last = upper
+	}
+	av.finish(head)
+	return av, nil
+}
+
+func auditChainBatch(ctx context.Context, after, head int64) ([]*AuditEntry, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := GetDB(ctx).QueryContext(ctx, `SELECT `+selectAuditEntryFields+` FROM "audit_entry" WHERE "seq" > $1 AND "seq" <= $2 ORDER BY "seq" LIMIT $3`, after, head, auditExportBatch)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	entries, err := scanAuditEntrys(rows)
+	isErrOrPanic(err)
+	return entries, util.NewErrorFrom(err)
+}
+
+func auditTombstonesBetween(ctx context.Context, after, upto int64) ([]*auditTombstone, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := GetDB(ctx).QueryContext(ctx, `SELECT `+selectAuditTombstoneFields+` FROM "audit_tombstone" WHERE "seq" > $1 AND "seq" <= $2 ORDER BY "seq"`, after, upto)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	tombstones, err := scanAuditTombstones(rows)
+	isErrOrPanic(err)
+	return tombstones, util.NewErrorFrom(err)
+}
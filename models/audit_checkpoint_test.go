@@ -0,0 +1,110 @@
+package models
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func getTestAuditChain(n int) []*AuditEntry {
+	entries := []*AuditEntry{}
+	prev := ""
+	for i := 1; i <= n; i++ {
+		ae := &AuditEntry{Id: "ae" + string(rune('a'+i)), Action: "test", Seq: int64(i), CreatedAt: time.Now().UTC().Truncate(time.Microsecond)}
+		ae.Hash = ae.chainHash(prev)
+		prev = ae.Hash
+		entries = append(entries, ae)
+	}
+	return entries
+}
+
+func verifyTestAuditChain(pub ed25519.PublicKey, cps []*AuditCheckpoint, entries []*AuditEntry, head int64) *AuditVerification {
+	av := startAuditVerification(pub, cps)
+	for _, ae := range entries {
+		av.entry(ae)
+	}
+	av.finish(head)
+	return av
+}
+
+func TestAuditChainVerification(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := getTestAuditChain(5)
+	cp := &AuditCheckpoint{Seq: 3, Hash: entries[2].Hash}
+	cp.Signature = ed25519.Sign(key, auditCheckpointMessage(cp.Seq, cp.Hash))
+	av := verifyTestAuditChain(pub, []*AuditCheckpoint{cp}, entries, 5)
+	if !av.Valid() || av.Entries != 5 || av.Checkpoints != 1 || av.Uncovered != 2 {
+		t.Fatalf("Unexpected verification of a valid chain %#v", av)
+	}
+	//Purged entries are fine as long as the rest is chained
+	if av := verifyTestAuditChain(pub, []*AuditCheckpoint{cp}, entries[2:], 5); !av.Valid() || av.First != 3 {
+		t.Errorf("Unexpected verification of a purged chain %#v", av)
+	}
+	entries[1].Actor = "mallory"
+	if av := verifyTestAuditChain(pub, nil, entries, 5); av.Valid() {
+		t.Errorf("A modified entry was not detected")
+	}
+	//Rewriting every hash after the change is caught by the checkpoint
+	entries[1].Hash = entries[1].chainHash(entries[0].Hash)
+	entries[2].Hash = entries[2].chainHash(entries[1].Hash)
+	if av := verifyTestAuditChain(pub, []*AuditCheckpoint{cp}, entries[:3], 3); av.Valid() {
+		t.Errorf("A rewritten chain was not detected by the checkpoint")
+	}
+	entries = getTestAuditChain(5)
+	//Entries purged by the retention policy leave their hash so the checkpoint at them still matches
+	av = startAuditVerification(pub, []*AuditCheckpoint{cp})
+	for _, ae := range entries {
+		if ae.Seq == 3 {
+			av.tombstone(&auditTombstone{Seq: ae.Seq, Hash: ae.Hash, Action: ae.Action})
+		} else {
+			av.entry(ae)
+		}
+	}
+	if av.finish(5); !av.Valid() || av.Entries != 4 || av.Purged != 1 || av.Checkpoints != 1 {
+		t.Errorf("Unexpected verification of a chain with a tombstone %#v", av)
+	}
+	if av := verifyTestAuditChain(pub, nil, append(entries[:2:2], entries[3:]...), 5); av.Valid() {
+		t.Errorf("A removed entry was not detected")
+	}
+	if av := verifyTestAuditChain(pub, nil, entries[:4], 5); av.Valid() {
+		t.Errorf("A removed last entry was not detected")
+	}
+	forged := &AuditCheckpoint{Seq: 3, Hash: entries[2].Hash, Signature: make([]byte, ed25519.SignatureSize)}
+	if av := verifyTestAuditChain(pub, []*AuditCheckpoint{forged}, entries, 5); av.Valid() {
+		t.Errorf("A forged checkpoint was not detected")
+	}
+}
+
+func TestAuditChainRecord(t *testing.T) {
+	ctx := getCtx()
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := RecordAuditEntry(ctx, &AuditEntry{Actor: "chain", Action: "test.chain"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ac, err := NewAuditCheckpoint(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac == nil {
+		t.Fatal("Expected a checkpoint after recording entries")
+	}
+	if again, err := NewAuditCheckpoint(ctx, key); err != nil || again != nil {
+		t.Errorf("Expected no checkpoint without new entries and got %v %s", again, err)
+	}
+	av, err := VerifyAuditChain(ctx, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !av.Valid() || av.Last < ac.Seq || av.Checkpoints == 0 {
+		t.Errorf("Unexpected verification %#v", av)
+	}
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const auditExportBatch = 1000
+
+// AuditEntry records who did what to which object. Entries are never modified once stored, only purged.
+// Each entry is chained to the previous one with its hash. Entries from before the chain have seq 0
+type AuditEntry struct {
+	Id        string    `scaneo:"pk" json:"id"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Object    string    `json:"object"`
+	Ip        string    `json:"ip"`
+	Agent     string    `json:"agent"`
+	RequestId string    `json:"request_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Seq       int64     `json:"seq"`
+	Hash      string    `json:"hash"`
+}
+
+func (ae *AuditEntry) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(ae.Action) == 0 {
+		errs.SetFieldError("action", "invalid")
+	}
+	return errs.Camo()
+}
+
+func (ae *AuditEntry) insert(tx *sql.Tx) error {
+	if err := ae.validate(); err != nil {
+		return err
+	}
+	ae.Id = util.GenerateRandomToken(20)
+	//The db keeps microseconds and the hash has to match what is read back
+	ae.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	var prev string
+	err := tx.QueryRow(`UPDATE "audit_chain" SET "seq" = "seq" + 1 WHERE "id" = 1 RETURNING "seq", "hash"`).Scan(&ae.Seq, &prev)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	ae.Hash = ae.chainHash(prev)
+	if _, err := tx.Exec(`UPDATE "audit_chain" SET "hash" = $1 WHERE "id" = 1`, ae.Hash); isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	_, err = ae.dbInsert(tx)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+// chainHash is the hash of the entry chained to the hash of the previous one
+func (ae *AuditEntry) chainHash(prev string) string {
+	h := sha256.New()
+	io.WriteString(h, prev+"\n")
+	io.WriteString(h, strings.Join([]string{
+		strconv.FormatInt(ae.Seq, 10), ae.Id, ae.Actor, ae.Action, ae.Object, ae.Ip, ae.Agent, ae.RequestId,
+		ae.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}, "\x00"))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func RecordAuditEntry(ctx context.Context, ae *AuditEntry) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		return ae.insert(tx)
+	})
+}
+
+// ForEachAuditEntry calls fn for every entry created in [from, to) in chronological order.
+// Entries are retrieved in batches so big ranges can be streamed
+func ForEachAuditEntry(ctx context.Context, from, to time.Time, fn func(*AuditEntry) error) error {
+	return forEachAuditEntry(ctx, from, `"created_at" < $4`, []interface{}{to}, fn)
+}
+
+// ForEachAuditEntryAbout calls fn for every entry with the user as the actor or as part of the object in
+// chronological order
+func ForEachAuditEntryAbout(ctx context.Context, user string, fn func(*AuditEntry) error) error {
+	return forEachAuditEntry(ctx, time.Time{}, `("actor" = $4 OR POSITION('/user:' || $4 || '/' IN '/' || "object" || '/') > 0)`, []interface{}{user}, fn)
+}
+
+// ForEachAuditEntryUnder calls fn for every entry created in [from, to) about the object or anything in it, like
+// team:tid for the whole team or team:tid/vault:vid for a vault, in chronological order
+func ForEachAuditEntryUnder(ctx context.Context, object string, from, to time.Time, fn func(*AuditEntry) error) error {
+	return forEachAuditEntry(ctx, from, `"created_at" < $4 AND ("object" = $5 OR LEFT("object", LENGTH($5) + 1) = $5 || '/')`, []interface{}{to, object}, fn)
+}
+
+// forEachAuditEntry pages through the entries after from that match the condition. Its arguments start at $4
+func forEachAuditEntry(ctx context.Context, from time.Time, cond string, args []interface{}, fn func(*AuditEntry) error) error {
+	lastTime := from
+	lastId := ""
+	for {
+		//Each batch gets its own timeout so long exports are not cut
+		qctx, cancel := queryCtx(ctx)
+		rows, err := getReadDB(ctx).QueryContext(qctx, `SELECT `+selectAuditEntryFields+` FROM "audit_entry" WHERE ("created_at", "id") > ($1, $2) AND `+cond+` ORDER BY "created_at", "id" LIMIT $3`, append([]interface{}{lastTime, lastId, auditExportBatch}, args...)...)
+		if err != nil {
+			cancel()
+		}
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		entries, err := scanAuditEntrys(rows)
+		cancel()
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		for _, ae := range entries {
+			if err := fn(ae); err != nil {
+				return err
+			}
+		}
+		if len(entries) < auditExportBatch {
+			return nil
+		}
+		lastTime = entries[len(entries)-1].CreatedAt
+		lastId = entries[len(entries)-1].Id
+	}
+}
+
+// FindAuditEntriesForActor returns the latest entries of the actor with any of the actions, newest first
+func FindAuditEntriesForActor(ctx context.Context, actor string, actions []string, limit int) ([]*AuditEntry, error) {
+	binds := make([]string, len(actions))
+	values := []interface{}{actor, limit}
+	for i, action := range actions {
+		binds[i] = fmt.Sprintf("$%d", i+3)
+		values = append(values, action)
+	}
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := getReadDB(ctx).QueryContext(ctx, `SELECT `+selectAuditEntryFields+` FROM "audit_entry" WHERE "actor" = $1 AND "action" IN (`+strings.Join(binds, ",")+`) ORDER BY "created_at" DESC, "id" DESC LIMIT $2`, values...)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	entries, err := scanAuditEntrys(rows)
+	isErrOrPanic(err)
+	return entries, util.NewErrorFrom(err)
+}
+
+// PurgeAuditEntries removes the entries older than the given time with the hashes left by the purged logins. With
+// dryRun nothing is removed
+func PurgeAuditEntries(ctx context.Context, before time.Time, dryRun bool) (purged AffectedRows, err error) {
+	return purged, doDryRunTx(ctx, dryRun, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM "audit_tombstone" WHERE "created_at" < $1`, before); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		purged, err = countAffected(tx, "audit_entry", `WITH "purged" AS (DELETE FROM "audit_entry" WHERE "created_at" < $1 RETURNING 1) SELECT COUNT(*) FROM "purged"`, before)
+		return err
+	})
+}
+
+// auditTombstone is what is left of a chained entry purged from the middle of the chain. The hash is enough to
+// verify the entries after it
+type auditTombstone struct {
+	Seq       int64 `scaneo:"pk"`
+	Hash      string
+	Action    string
+	CreatedAt time.Time
+}
+
+// PurgeAuditEntriesWithActions removes the entries with any of the actions older than the given time. The chained
+// ones leave a tombstone so the chain can still be verified. With dryRun nothing is removed
+func PurgeAuditEntriesWithActions(ctx context.Context, actions []string, before time.Time, dryRun bool) (purged AffectedRows, err error) {
+	return purged, doDryRunTx(ctx, dryRun, func(tx *sql.Tx) error {
+		purged, err = countAffected(tx, "audit_entry", `WITH "purged" AS (
+				DELETE FROM "audit_entry" WHERE "action" = ANY($1) AND "created_at" < $2 RETURNING "seq", "hash", "action", "created_at"
+			), "tombstones" AS (
+				INSERT INTO "audit_tombstone" ("seq", "hash", "action", "created_at") SELECT * FROM "purged" WHERE "seq" > 0
+			) SELECT COUNT(*) FROM "purged"`, pq.Array(actions), before)
+		return err
+	})
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"database/sql"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// AFFECTED_IDS_MAX bounds how many ids of each kind are listed in the reports. The count always covers every row
+const AFFECTED_IDS_MAX = 100
+
+// AffectedRows is how many rows of a kind an operation removes or would remove. Ids of rows with composite
+// keys are joined with a slash and ids that are credentials, like sessions or tokens, are never listed
+type AffectedRows struct {
+	Kind  string   `json:"kind"`
+	Count int64    `json:"count"`
+	Ids   []string `json:"ids,omitempty"`
+}
+
+// ChangeReport lists what a destructive operation changed. With DryRun nothing has been committed
+type ChangeReport struct {
+	DryRun   bool           `json:"dry_run"`
+	Total    int64          `json:"total"`
+	Affected []AffectedRows `json:"affected"`
+}
+
+// NewChangeReport returns a report without any affected rows
+func NewChangeReport(dryRun bool) *ChangeReport {
+	return &ChangeReport{DryRun: dryRun, Affected: []AffectedRows{}}
+}
+
+func (cr *ChangeReport) Add(ar AffectedRows) {
+	cr.Affected = append(cr.Affected, ar)
+	cr.Total += ar.Count
+}
+
+// queryAffected runs a query that returns the id of every affected row
+func queryAffected(tx *sql.Tx, kind, query string, args ...interface{}) (AffectedRows, error) {
+	ar := AffectedRows{Kind: kind}
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return ar, util.NewErrorf("Could not check %s: %s", kind, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return ar, util.NewErrorFrom(err)
+		}
+		if ar.Count < AFFECTED_IDS_MAX {
+			ar.Ids = append(ar.Ids, id)
+		}
+		ar.Count++
+	}
+	return ar, util.NewErrorFrom(rows.Err())
+}
+
+// countAffected runs a query that returns how many rows are affected. It's used for the rows whose ids can't be listed
+func countAffected(tx *sql.Tx, kind, query string, args ...interface{}) (AffectedRows, error) {
+	ar := AffectedRows{Kind: kind}
+	if err := tx.QueryRow(query, args...).Scan(&ar.Count); err != nil {
+		return ar, util.NewErrorf("Could not count %s: %s", kind, err)
+	}
+	return ar, nil
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func affectedRows(cr *ChangeReport, kind string) AffectedRows {
+	for _, ar := range cr.Affected {
+		if ar.Kind == kind {
+			return ar
+		}
+	}
+	return AffectedRows{Kind: kind}
+}
+
+func TestDryRunReports(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := getFirstVault(owner, team)
+	s := &Secret{Data: signAndPack(vm.priv, a32b)}
+	if err := vm.v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	member := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.AddUsers(ctx, map[string][]byte{member.Id: sealVaultKey(vm.v, vm.priv)}); err != nil {
+		t.Fatal(err)
+	}
+	cr, err := vm.v.RemoveUserWithReport(ctx, member.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vu := affectedRows(cr, "vault_user")
+	if !cr.DryRun || vu.Count != 1 || vu.Ids[0] != team.Id+"/"+vm.v.Id+"/"+member.Id || affectedRows(cr, "vault_without_users").Count != 0 {
+		t.Fatalf("Unexpected report for the removal of a member %#v", cr)
+	}
+	if uids, err := vm.v.GetUserIds(ctx); err != nil || len(uids) != 2 {
+		t.Fatalf("Dry run removed the member: %v (%v)", uids, err)
+	}
+	if _, err := owner.DeleteWithReport(ctx, true); !util.CheckErr(err, ErrOwnsSharedTeams) {
+		t.Fatalf("Expected the owner of a team with members to be kept and got %v", err)
+	}
+	if err := member.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cr, err = owner.DeleteWithReport(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teams, secrets := affectedRows(cr, "team"), affectedRows(cr, "secret")
+	if teams.Count != 1 || teams.Ids[0] != team.Id || secrets.Count != 1 || secrets.Ids[0] != team.Id+"/"+vm.v.Id+"/"+s.Id {
+		t.Fatalf("Unexpected report for the deletion of the owner %#v", cr)
+	}
+	if affectedRows(cr, "vault_user").Count != 1 || cr.Total < 5 {
+		t.Fatalf("Expected the vault users to be reported in %#v", cr)
+	}
+	if _, err := FindUser(ctx, owner.Id); err != nil {
+		t.Fatalf("Dry run deleted the owner: %s", err)
+	}
+	if cr, err = owner.DeleteWithReport(ctx, false); err != nil || cr.DryRun {
+		t.Fatalf("Could not delete the owner: %#v (%v)", cr, err)
+	}
+	if _, err := FindUser(ctx, owner.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected the owner to be deleted and got %v", err)
+	}
+}
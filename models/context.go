@@ -3,6 +3,10 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
 
 	"github.com/keydotcat/keycatd/util"
 )
@@ -11,6 +15,8 @@ type contextType int
 
 const (
 	contextDBKey = contextType(0)
+	//contextReadDBKey is the db for read only queries that can lag behind the primary
+	contextReadDBKey = contextType(1)
 )
 
 func GetDB(ctx context.Context) *sql.DB {
@@ -21,11 +27,130 @@ func GetDB(ctx context.Context) *sql.DB {
 	return d
 }
 
+// getReadDB returns the db for read only queries or the primary if there's none
+func getReadDB(ctx context.Context) *sql.DB {
+	if d, ok := ctx.Value(contextReadDBKey).(*sql.DB); ok {
+		return d
+	}
+	return GetDB(ctx)
+}
+
+var queryTimeout int64
+
+// SetQueryTimeout bounds how long a query or a whole transaction can take. Once it expires the transaction is rolled
+// back and ErrTimeout is returned. 0 disables it
+func SetQueryTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&queryTimeout, int64(timeout))
+}
+
+// queryCtx derives the context for the queries of a call. The caller has to cancel it once the rows have been read
+func queryCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := time.Duration(atomic.LoadInt64(&queryTimeout)); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+const (
+	txRetryDelay = 10 * time.Millisecond
+	//conflictTxRetries is the minimum amount of retries for the transactions that are run with doConflictTx
+	conflictTxRetries = 3
+)
+
+var txRetries int32
+
+// SetTxRetries sets how many times a transaction that conflicted with another one is run again before giving up.
+// Cockroach runs every transaction as serializable so it needs retries once there are concurrent writes
+func SetTxRetries(retries int) {
+	atomic.StoreInt32(&txRetries, int32(retries))
+}
+
 func doTx(ctx context.Context, ftor func(*sql.Tx) error) error {
-	tx, err := GetDB(ctx).BeginTx(ctx, nil)
+	return retryTx(ctx, ftor, int(atomic.LoadInt32(&txRetries)))
+}
+
+// doConflictTx is doTx for the transactions that write many related rows, like creating teams, accepting invites or
+// storing new keys. Those deadlock or fail to serialize under concurrent writes even in postgres so they are always retried
+func doConflictTx(ctx context.Context, ftor func(*sql.Tx) error) error {
+	retries := int(atomic.LoadInt32(&txRetries))
+	if retries < conflictTxRetries {
+		retries = conflictTxRetries
+	}
+	return retryTx(ctx, ftor, retries)
+}
+
+// errDryRun makes doDryRunTx roll back the transaction once everything has been run
+var errDryRun = errors.New("Dry run")
+
+// doDryRunTx is doTx that rolls back the transaction when dryRun is set so the caller can report what would
+// have been changed without changing anything
+func doDryRunTx(ctx context.Context, dryRun bool, ftor func(*sql.Tx) error) error {
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		if err := ftor(tx); err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		return nil
+	}
+	return err
+}
+
+// retryTx runs the transaction again up to retries times while it conflicts with other ones. Once it gives up
+// ErrConflict is returned instead of the raw db error
+func retryTx(ctx context.Context, ftor func(*sql.Tx) error, retries int) error {
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, GetDB(ctx), nil, ftor, retries > 0)
+		if !IsRetryableErr(err) {
+			return err
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			return util.NewErrorFrom(ErrConflict)
+		}
+		time.Sleep(txRetryBackoff(attempt))
+	}
+}
+
+// txRetryBackoff doubles the wait on each attempt. The jitter keeps the transactions that conflicted from running
+// again at the same time and conflicting once more
+func txRetryBackoff(attempt int) time.Duration {
+	delay := txRetryDelay << uint(attempt)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay)))
+}
+
+// runTx runs the function in a transaction. With retryable the statements that fail with a retryable error
+// return it instead of panicking. Otherwise panics are left alone so they keep the stack of the failed statement
+func runTx(ctx context.Context, d *sql.DB, opts *sql.TxOptions, ftor func(*sql.Tx) error, retryable bool) (err error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	tx, err := d.BeginTx(ctx, opts)
 	if err != nil {
 		panic(err)
 	}
+	//The transaction is rolled back when the context expires so whatever failed afterwards was caused by the timeout
+	defer func() {
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = util.NewErrorFrom(ErrTimeout)
+		}
+	}()
+	if retryable {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			sp, ok := rec.(sqlPanic)
+			if !ok || !IsRetryableErr(sp.err) {
+				panic(rec)
+			}
+			tx.Rollback()
+			err = util.NewErrorFrom(sp.err)
+		}()
+	}
 	if err = ftor(tx); err != nil {
 		if util.CheckErr(err, sql.ErrTxDone) || util.CheckErr(err, sql.ErrConnDone) {
 			return err
@@ -36,11 +161,25 @@ func doTx(ctx context.Context, ftor func(*sql.Tx) error) error {
 		return err
 	}
 	if err = tx.Commit(); err != nil {
+		if IsRetryableErr(err) {
+			return util.NewErrorFrom(err)
+		}
 		return util.NewErrorf("Could not commit transaction: %s", err)
 	}
 	return nil
 }
 
+// doReadTx runs a read only transaction in the read db. Nothing is written so it never has to be retried
+func doReadTx(ctx context.Context, ftor func(*sql.Tx) error) error {
+	return runTx(ctx, getReadDB(ctx), &sql.TxOptions{ReadOnly: true}, ftor, false)
+}
+
 func AddDBToContext(ctx context.Context, d *sql.DB) context.Context {
 	return context.WithValue(ctx, contextDBKey, d)
 }
+
+// AddReadDBToContext sets the db that runs the listings. They can be served by a replica because
+// clients poll them and are fine with data that is a bit behind
+func AddReadDBToContext(ctx context.Context, d *sql.DB) context.Context {
+	return context.WithValue(ctx, contextReadDBKey, d)
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+func TestDoTxRetries(t *testing.T) {
+	defer SetTxRetries(0)
+	conflict := func(runs *int, fails int) func(*sql.Tx) error {
+		return func(tx *sql.Tx) error {
+			*runs++
+			if *runs <= fails {
+				return util.NewErrorFrom(&pq.Error{Code: "40001", Message: "restart transaction"})
+			}
+			return nil
+		}
+	}
+	runs := 0
+	if err := doTx(getCtx(), conflict(&runs, 1)); !IsRetryableErr(err) || runs != 1 {
+		t.Fatalf("Expected a retryable error without retries and got %v after %d runs", err, runs)
+	}
+	SetTxRetries(2)
+	runs = 0
+	if err := doTx(getCtx(), conflict(&runs, 2)); err != nil || runs != 3 {
+		t.Fatalf("Expected the transaction to succeed on the third run and got %v after %d runs", err, runs)
+	}
+	runs = 0
+	if err := doTx(getCtx(), conflict(&runs, 3)); !IsRetryableErr(err) || runs != 3 {
+		t.Fatalf("Expected to give up after 2 retries and got %v after %d runs", err, runs)
+	}
+	//Statements that fail with a retryable error panic but are retried too
+	runs = 0
+	err := doTx(getCtx(), func(tx *sql.Tx) error {
+		runs++
+		if runs == 1 {
+			isErrOrPanic(&pq.Error{Code: "40001", Message: "restart transaction"})
+		}
+		return nil
+	})
+	if err != nil || runs != 2 {
+		t.Fatalf("Expected the panicking transaction to be retried and got %v after %d runs", err, runs)
+	}
+	if IsRetryableErr(util.NewErrorFrom(&pq.Error{Code: "23505"})) {
+		t.Errorf("Duplicates are not retryable")
+	}
+}
+
+func TestDoTxQueryTimeout(t *testing.T) {
+	defer SetQueryTimeout(0)
+	SetQueryTimeout(50 * time.Millisecond)
+	err := doTx(getCtx(), func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`SELECT pg_sleep(0.2)`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`SELECT 1`)
+		return err
+	})
+	if !util.CheckErr(err, ErrTimeout) || !IsTimeoutErr(err) {
+		t.Fatalf("Expected a timeout and got %v", err)
+	}
+	SetQueryTimeout(0)
+	if err := doTx(getCtx(), func(tx *sql.Tx) error {
+		_, err := tx.Exec(`SELECT pg_sleep(0.1)`)
+		return err
+	}); err != nil {
+		t.Fatalf("Expected no timeout and got %s", err)
+	}
+	if !IsTimeoutErr(sqlPanic{&pq.Error{Code: "57014", Message: "canceling statement due to statement timeout"}}) {
+		t.Errorf("Statement timeouts have to be detected")
+	}
+	if IsTimeoutErr(util.NewErrorFrom(&pq.Error{Code: "23505"})) {
+		t.Errorf("Duplicates are not timeouts")
+	}
+}
+
+func TestDoConflictTxRetries(t *testing.T) {
+	runs := 0
+	deadlock := func(tx *sql.Tx) error {
+		runs++
+		return util.NewErrorFrom(&pq.Error{Code: "40P01", Message: "deadlock detected"})
+	}
+	if err := doConflictTx(getCtx(), deadlock); !util.CheckErr(err, ErrConflict) || runs != conflictTxRetries+1 {
+		t.Fatalf("Expected a conflict after %d runs and got %v after %d runs", conflictTxRetries+1, err, runs)
+	}
+	runs = 0
+	if err := doTx(getCtx(), deadlock); !util.CheckErr(err, ErrConflict) || runs != 1 {
+		t.Fatalf("Expected the raw deadlock to be hidden and got %v after %d runs", err, runs)
+	}
+	for attempt := 0; attempt < 4; attempt++ {
+		delay := txRetryDelay << uint(attempt)
+		if d := txRetryBackoff(attempt); d < delay/2 || d >= delay*3/2 {
+			t.Errorf("Backoff of attempt %d out of bounds: %s", attempt, d)
+		}
+	}
+}
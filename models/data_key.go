@@ -0,0 +1,252 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// DataKey encrypts the sealed columns. It's stored wrapped by the kms so a dump of the db can't be opened without it
+type DataKey struct {
+	Id        string    `scaneo:"pk" json:"id"`
+	Kms       string    `json:"kms"`
+	Wrapped   []byte    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func findDataKeys(ctx context.Context) (dks []*DataKey, err error) {
+	return dks, doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT ` + selectDataKeyFields + ` FROM "data_key" ORDER BY "created_at", "id"`)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		dks, err = scanDataKeys(rows)
+		return util.NewErrorFrom(err)
+	})
+}
+
+// NewDataKey creates a data key and seals everything written from now on in this instance with it. Other instances
+// keep using the one they have until they restart but can read what was sealed with the new one
+func NewDataKey(ctx context.Context) (*DataKey, error) {
+	if sealing == nil {
+		return nil, util.NewErrorf("Sealing is not enabled")
+	}
+	wrapped, err := sealing.kms.Wrap(ctx, util.GenerateRandomByteArray(32))
+	if err != nil {
+		return nil, util.NewErrorf("Could not wrap a new data key with the %s kms: %s", sealing.kms.Name(), err)
+	}
+	dk := &DataKey{Id: util.GenerateRandomToken(16), Kms: sealing.kms.Name(), Wrapped: wrapped, CreatedAt: time.Now().UTC()}
+	err = doTx(ctx, func(tx *sql.Tx) error {
+		_, err := dk.dbInsert(tx)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := sealing.add(ctx, dk); err != nil {
+		return nil, err
+	}
+	sealing.lock.Lock()
+	sealing.current = dk.Id
+	sealing.lock.Unlock()
+	return dk, nil
+}
+
+// RewrapDataKeys unwraps every data key with from and wraps it again with to, after the key of the kms has been
+// rotated or to move to another kms. The data keys don't change so nothing has to be sealed again
+func RewrapDataKeys(ctx context.Context, from, to KeyWrapper) (rewrapped int, err error) {
+	dks, err := findDataKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, dk := range dks {
+		plain, err := from.Unwrap(ctx, dk.Wrapped)
+		if err != nil {
+			return rewrapped, util.NewErrorf("Could not unwrap data key %s with the %s kms: %s", dk.Id, from.Name(), err)
+		}
+		if dk.Wrapped, err = to.Wrap(ctx, plain); err != nil {
+			return rewrapped, util.NewErrorf("Could not wrap data key %s with the %s kms: %s", dk.Id, to.Name(), err)
+		}
+		dk.Kms = to.Name()
+		err = doTx(ctx, func(tx *sql.Tx) error {
+			return treatUpdateErr(dk.dbUpdate(tx))
+		})
+		if err != nil {
+			return rewrapped, err
+		}
+		rewrapped++
+	}
+	return rewrapped, nil
+}
+
+type sealedColumn struct {
+	table  string
+	column string
+	pk     []string
+	text   bool
+}
+
+var sealedColumns = []sealedColumn{
+	{"user", "key", []string{"id"}, false},
+	{"vault_user", "key", []string{"team", "vault", "user"}, false},
+	{"vault_grant", "key", []string{"team", "vault", "user"}, false},
+	{"token", "extra", []string{"id"}, true},
+}
+
+const resealBatch = 500
+
+// ResealColumns seals every value of the sealed columns that isn't sealed with the current data key yet, like the ones
+// stored before sealing was enabled. If retire is set the data keys that are no longer used are removed afterwards
+func ResealColumns(ctx context.Context, retire bool) (resealed int64, err error) {
+	if sealing == nil {
+		return 0, util.NewErrorf("Sealing is not enabled")
+	}
+	for _, sc := range sealedColumns {
+		n, err := sc.reseal(ctx)
+		resealed += n
+		if err != nil {
+			return resealed, err
+		}
+	}
+	if retire {
+		err = doTx(ctx, func(tx *sql.Tx) error {
+			_, err := tx.Exec(`DELETE FROM "data_key" WHERE "id" <> $1`, sealing.current)
+			return util.NewErrorFrom(err)
+		})
+	}
+	return resealed, err
+}
+
+func (sc sealedColumn) quoted(names []string) string {
+	q := make([]string, len(names))
+	for i, name := range names {
+		q[i] = `"` + name + `"`
+	}
+	return strings.Join(q, ",")
+}
+
+func (sc sealedColumn) currentKey(raw []byte) bool {
+	if sc.text {
+		if !strings.HasPrefix(string(raw), sealTextPrefix) {
+			return false
+		}
+		data, err := base64.StdEncoding.DecodeString(string(raw[len(sealTextPrefix):]))
+		if err != nil {
+			return false
+		}
+		raw = data
+	}
+	id, _, bound, ok := sealedKeyId(raw)
+	return ok && bound && id == sealing.current
+}
+
+// resealValue opens the stored value and seals it again with the current data key for its row
+func resealValue(v interface {
+	sealedValue
+	sql.Scanner
+}, raw, ad []byte) error {
+	if err := v.Scan(raw); err != nil {
+		return err
+	}
+	if err := v.open(ad); err != nil {
+		return err
+	}
+	_, err := v.seal(ad)
+	return err
+}
+
+// reseal walks the table in batches by primary key, locking each batch while it's sealed again
+func (sc sealedColumn) reseal(ctx context.Context) (resealed int64, err error) {
+	pks := sc.quoted(sc.pk)
+	binds := make([]string, len(sc.pk))
+	for i := range sc.pk {
+		binds[i] = fmt.Sprintf("$%d", i+1)
+	}
+	after := make([]interface{}, len(sc.pk))
+	for i := range after {
+		after[i] = ""
+	}
+	for {
+		done := false
+		var next []interface{}
+		var n int64
+		err = doTx(ctx, func(tx *sql.Tx) error {
+			next, n = after, 0
+			rows, err := tx.Query(fmt.Sprintf(`SELECT %s, "%s" FROM "%s" WHERE (%s) > (%s) ORDER BY %s LIMIT %d FOR UPDATE`,
+				pks, sc.column, sc.table, pks, strings.Join(binds, ","), pks, resealBatch), after...)
+			if err != nil {
+				return util.NewErrorFrom(err)
+			}
+			type row struct {
+				pk   []interface{}
+				keys []string
+				raw  []byte
+			}
+			batch := []row{}
+			for rows.Next() {
+				r := row{pk: make([]interface{}, len(sc.pk)), keys: make([]string, len(sc.pk))}
+				dest := make([]interface{}, len(sc.pk)+1)
+				for i := range r.keys {
+					dest[i] = &r.keys[i]
+				}
+				dest[len(sc.pk)] = &r.raw
+				if err := rows.Scan(dest...); err != nil {
+					rows.Close()
+					return util.NewErrorFrom(err)
+				}
+				for i, k := range r.keys {
+					r.pk[i] = k
+				}
+				batch = append(batch, r)
+			}
+			if err := rows.Err(); err != nil {
+				return util.NewErrorFrom(err)
+			}
+			done = len(batch) < resealBatch
+			for _, r := range batch {
+				next = r.pk
+				if r.raw == nil || sc.currentKey(r.raw) {
+					continue
+				}
+				//Values sealed before they were bound to their row are opened without it and bound when sealed again
+				ad := sealedRow(sc.table, sc.column, r.keys...)
+				var value interface{}
+				if sc.text {
+					var s SealedString
+					if err := resealValue(&s, r.raw, ad); err != nil {
+						return err
+					}
+					value = s
+				} else {
+					var s Sealed
+					if err := resealValue(&s, r.raw, ad); err != nil {
+						return err
+					}
+					value = s
+				}
+				conds := make([]string, len(sc.pk))
+				for i, name := range sc.pk {
+					conds[i] = fmt.Sprintf(`"%s" = $%d`, name, i+2)
+				}
+				args := append([]interface{}{value}, r.pk...)
+				if _, err := tx.Exec(fmt.Sprintf(`UPDATE "%s" SET "%s" = $1 WHERE %s`, sc.table, sc.column, strings.Join(conds, " AND ")), args...); err != nil {
+					return util.NewErrorFrom(err)
+				}
+				n++
+			}
+			return nil
+		})
+		if err == nil {
+			after, resealed = next, resealed+n
+		}
+		if err != nil || done {
+			return resealed, err
+		}
+	}
+}
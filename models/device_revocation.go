@@ -0,0 +1,145 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// DeviceRevocation is the public key of a device of a user that has been reported as compromised. Nothing is sealed
+// for a revoked key anymore
+type DeviceRevocation struct {
+	Id        string    `scaneo:"pk" json:"id"`
+	User      string    `json:"user"`
+	Name      string    `json:"name"`
+	PublicKey []byte    `json:"public_key"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeviceKeyFingerprint identifies a public key in the revocation list
+func DeviceKeyFingerprint(publicKey []byte) string {
+	h := sha256.Sum256(publicKey)
+	return hex.EncodeToString(h[:])
+}
+
+func (dr *DeviceRevocation) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(dr.PublicKey) == 0 || len(dr.PublicKey) > 1024 {
+		errs.SetFieldError("public_key", "invalid")
+	}
+	if len(dr.Name) > 100 {
+		errs.SetFieldError("name", "too long")
+	}
+	if len(dr.Reason) > 1024 {
+		errs.SetFieldError("reason", "too long")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+// RevokeDeviceKey adds the public key of a device of the user to the revocation list
+func RevokeDeviceKey(ctx context.Context, user, name string, publicKey []byte, reason string) (*DeviceRevocation, error) {
+	dr := &DeviceRevocation{
+		Id:        DeviceKeyFingerprint(publicKey),
+		User:      user,
+		Name:      strings.TrimSpace(name),
+		PublicKey: publicKey,
+		Reason:    strings.TrimSpace(reason),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := dr.validate(); err != nil {
+		return nil, err
+	}
+	return dr, doTx(ctx, func(tx *sql.Tx) error {
+		//Revoking the key of somebody else would keep it out of every vault
+		other := false
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM "user" WHERE "public_key" = $1 AND "id" <> $2)`, dr.PublicKey, dr.User).Scan(&other)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if other {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		_, err = dr.dbInsert(tx)
+		if IsDuplicateErr(err) {
+			return util.NewErrorFrom(ErrAlreadyExists)
+		}
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+// FindDeviceRevocationsForUser returns the revoked keys of the user and of the members of its teams. Those are the only
+// keys its clients seal anything for
+func FindDeviceRevocationsForUser(ctx context.Context, user string) (drs []*DeviceRevocation, err error) {
+	return drs, doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+selectDeviceRevocationFullFields+` FROM "device_revocation" WHERE "device_revocation"."user" = $1
+			OR "device_revocation"."user" IN (SELECT b."user" FROM "team_user" a JOIN "team_user" b ON a."team" = b."team" WHERE a."user" = $1)
+			ORDER BY "device_revocation"."created_at"`, user)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		drs, err = scanDeviceRevocations(rows)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+func IsDeviceKeyRevoked(ctx context.Context, publicKey []byte) (revoked bool, err error) {
+	return revoked, doTx(ctx, func(tx *sql.Tx) error {
+		revoked, err = isDeviceKeyRevoked(tx, publicKey)
+		return err
+	})
+}
+
+func isDeviceKeyRevoked(tx *sql.Tx, publicKey []byte) (bool, error) {
+	revoked := false
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM "device_revocation" WHERE "id" = $1)`, DeviceKeyFingerprint(publicKey)).Scan(&revoked)
+	isErrOrPanic(err)
+	return revoked, util.NewErrorFrom(err)
+}
+
+// checkUserKeysNotRevoked fails if the current public key of any of the users has been revoked
+func checkUserKeysNotRevoked(tx *sql.Tx, uids []string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	binds := make([]string, len(uids))
+	values := make([]interface{}, len(uids))
+	for i, uid := range uids {
+		binds[i] = fmt.Sprintf("$%d", i+1)
+		values[i] = uid
+	}
+	rows, err := tx.Query(`SELECT "public_key" FROM "user" WHERE "id" IN (`+strings.Join(binds, ",")+`)`, values...)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	keys := [][]byte{}
+	for rows.Next() {
+		var pub []byte
+		if err := rows.Scan(&pub); err != nil {
+			rows.Close()
+			return util.NewErrorFrom(err)
+		}
+		keys = append(keys, pub)
+	}
+	if err := rows.Err(); isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	for _, pub := range keys {
+		revoked, err := isDeviceKeyRevoked(tx, pub)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return util.NewErrorFrom(ErrRevokedKey)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestRevokeDeviceKey(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	pub := []byte("device public key " + util.GenerateRandomToken(8))
+	p, err := NewPairing(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Claim(ctx, "extension", pub); err != nil {
+		t.Fatal(err)
+	}
+	dr, err := RevokeDeviceKey(ctx, u.Id, " laptop ", pub, "stolen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dr.Id != DeviceKeyFingerprint(pub) || dr.Name != "laptop" {
+		t.Fatalf("Unexpected revocation %#v", dr)
+	}
+	if _, err := RevokeDeviceKey(ctx, u.Id, "laptop", pub, "stolen"); !util.CheckErr(err, ErrAlreadyExists) {
+		t.Fatalf("Expected %s and got %s", ErrAlreadyExists, err)
+	}
+	if err := p.Approve(ctx, []byte("keys")); !util.CheckErr(err, ErrRevokedKey) {
+		t.Fatalf("Expected %s and got %s", ErrRevokedKey, err)
+	}
+	p, err = NewPairing(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Claim(ctx, "extension", pub); !util.CheckErr(err, ErrRevokedKey) {
+		t.Fatalf("Expected %s and got %s", ErrRevokedKey, err)
+	}
+	drs, err := FindDeviceRevocationsForUser(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drs) != 1 || drs[0].Id != dr.Id {
+		t.Fatalf("Unexpected revocation list %#v", drs)
+	}
+	other := getDummyUser()
+	if _, err := RevokeDeviceKey(ctx, u.Id, "not mine", other.PublicKey, ""); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("Expected %s and got %s", ErrUnauthorized, err)
+	}
+	if drs, err = FindDeviceRevocationsForUser(ctx, other.Id); err != nil || len(drs) != 0 {
+		t.Fatalf("Users without a team in common see the revocations: %v %#v", err, drs)
+	}
+}
+
+func TestRevokedUserKeyGetsNoVaultKeys(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	if _, err := RevokeDeviceKey(ctx, u.Id, "account", u.PublicKey, "leaked"); err != nil {
+		t.Fatal(err)
+	}
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		return checkUserKeysNotRevoked(tx, []string{u.Id})
+	})
+	if !util.CheckErr(err, ErrRevokedKey) {
+		t.Fatalf("Expected %s and got %s", ErrRevokedKey, err)
+	}
+}
@@ -14,4 +14,14 @@ var (
 	ErrInvalidSignature  = errors.New("Invalid signature")
 	ErrInvalidPublicKey  = errors.New("Invalid public key length")
 	ErrInvalidAttributes = errors.New("Invalid attributes")
+	ErrTimeout           = errors.New("The database took too long to answer")
+	ErrConflict          = errors.New("Conflicted with a concurrent change. Try again")
+	ErrRevokedKey        = errors.New("The public key has been revoked")
+	ErrApprovalRequired  = errors.New("New members of the vault need the approval of a second admin")
+	ErrAlreadyUsed       = errors.New("Already used")
+	ErrPlaintext         = errors.New("The secret doesn't look encrypted")
+	ErrExpired           = errors.New("Expired")
+	ErrMustResetPassword = errors.New("The password has to be reset")
+	ErrAuditor           = errors.New("Auditors can't have access to any vault")
+	ErrOwnsSharedTeams   = errors.New("The user owns teams with other members")
 )
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+var reValidFeatureFlagName = regexp.MustCompile(`^[a-z][a-z0-9_]{1,63}$`)
+
+// FeatureFlag overrides whether a feature is enabled. Flags without a team apply to every team that has no override of its own
+type FeatureFlag struct {
+	Name      string    `scaneo:"pk" json:"name"`
+	Team      string    `scaneo:"pk" json:"team,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ff *FeatureFlag) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if !reValidFeatureFlagName.MatchString(ff.Name) {
+		errs.SetFieldError("feature_flag_name", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+// SetFeatureFlag creates or replaces the override for the feature in the team or globally if the team is empty
+func SetFeatureFlag(ctx context.Context, ff *FeatureFlag) error {
+	if err := ff.validate(); err != nil {
+		return err
+	}
+	ff.UpdatedAt = time.Now().UTC()
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if len(ff.Team) > 0 {
+			t := &Team{Id: ff.Team}
+			if err := t.dbFind(tx); isNotExistsErr(err) {
+				return util.NewErrorFrom(ErrDoesntExist)
+			} else if isErrOrPanic(err) {
+				return util.NewErrorFrom(err)
+			}
+		}
+		_, err := tx.Exec(`INSERT INTO "feature_flag" (`+selectFeatureFlagFields+`) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT ("name", "team") DO UPDATE SET "enabled" = EXCLUDED."enabled", "updated_by" = EXCLUDED."updated_by", "updated_at" = EXCLUDED."updated_at"`,
+			ff.Name, ff.Team, ff.Enabled, ff.UpdatedBy, ff.UpdatedAt)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		return nil
+	})
+}
+
+func FindFeatureFlag(ctx context.Context, name, team string) (*FeatureFlag, error) {
+	ff := &FeatureFlag{Name: name, Team: team}
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		return ff.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return ff, nil
+}
+
+// GetFeatureFlags returns all the overrides sorted by name with the global ones first
+func GetFeatureFlags(ctx context.Context) ([]*FeatureFlag, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := GetDB(ctx).QueryContext(ctx, `SELECT `+selectFeatureFlagFields+` FROM "feature_flag" ORDER BY "name", "team"`)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	ffs, err := scanFeatureFlags(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return ffs, nil
+}
+
+func (ff *FeatureFlag) Delete(ctx context.Context) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		return treatUpdateErr(ff.dbDelete(tx))
+	})
+}
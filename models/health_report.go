@@ -0,0 +1,102 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const (
+	//HEALTH_REPORT_MAX_AGE is how long a report counts for the team. Members that stop reporting drop out of it
+	HEALTH_REPORT_MAX_AGE = 30 * 24 * time.Hour
+	//HEALTH_MIN_REPORTS is how many members have to report before the counts are shown so they can't be pinned on one
+	HEALTH_MIN_REPORTS = 3
+	healthMaxSecrets   = 1000000
+)
+
+// HealthReport are the counts of weak, reused and old passwords a member found in the secrets of a team
+type HealthReport struct {
+	Team      string    `scaneo:"pk" json:"-"`
+	User      string    `scaneo:"pk" json:"-"`
+	Total     int       `json:"total"`
+	Weak      int       `json:"weak"`
+	Reused    int       `json:"reused"`
+	Old       int       `json:"old"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TeamHealth adds up the recent reports of the members of a team. The counts are zero until HEALTH_MIN_REPORTS
+// members have reported
+type TeamHealth struct {
+	Members      int         `json:"members"`
+	Reporting    int         `json:"reporting"`
+	Total        int         `json:"total"`
+	Weak         int         `json:"weak"`
+	Reused       int         `json:"reused"`
+	Old          int         `json:"old"`
+	OldestReport pq.NullTime `json:"oldest_report,omitempty"`
+}
+
+func (hr *HealthReport) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if hr.Total < 0 || hr.Total > healthMaxSecrets {
+		errs.SetFieldError("total", "invalid")
+	}
+	for field, val := range map[string]int{"weak": hr.Weak, "reused": hr.Reused, "old": hr.Old} {
+		if val < 0 || val > hr.Total {
+			errs.SetFieldError(field, "invalid")
+		}
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+// SetHealthReport replaces the last report of the member
+func (t *Team) SetHealthReport(ctx context.Context, u *User, hr *HealthReport) error {
+	hr.Team, hr.User, hr.UpdatedAt = t.Id, u.Id, time.Now().UTC()
+	if err := hr.validate(); err != nil {
+		return err
+	}
+	return doTx(ctx, func(tx *sql.Tx) error {
+		tu, err := t.getUserAffiliation(tx, u.Id)
+		if err != nil {
+			return err
+		}
+		if tu == nil {
+			return util.NewErrorFrom(ErrNotInTeam)
+		}
+		_, err = tx.Exec(`INSERT INTO "health_report" `+insertHealthReportFields+` VALUES `+insertHealthReportBinds+`
+			ON CONFLICT ("team", "user") DO UPDATE SET "total" = EXCLUDED."total", "weak" = EXCLUDED."weak", "reused" = EXCLUDED."reused",
+			"old" = EXCLUDED."old", "updated_at" = EXCLUDED."updated_at"`,
+			hr.Team, hr.User, hr.Total, hr.Weak, hr.Reused, hr.Old, hr.UpdatedAt)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+// GetHealth adds up the reports of the current members from the last HEALTH_REPORT_MAX_AGE. Only admins can see it
+func (t *Team) GetHealth(ctx context.Context, admin *User) (th *TeamHealth, err error) {
+	th = &TeamHealth{}
+	return th, doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		err := tx.QueryRow(`SELECT COUNT(*) FROM "team_user" WHERE "team" = $1`, t.Id).Scan(&th.Members)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		err = tx.QueryRow(`SELECT COUNT(*), COALESCE(SUM(h."total"), 0), COALESCE(SUM(h."weak"), 0), COALESCE(SUM(h."reused"), 0),
+			COALESCE(SUM(h."old"), 0), MIN(h."updated_at") FROM "health_report" h JOIN "team_user" tu ON tu."team" = h."team" AND tu."user" = h."user"
+			WHERE h."team" = $1 AND h."updated_at" > $2`, t.Id, time.Now().UTC().Add(-HEALTH_REPORT_MAX_AGE)).Scan(
+			&th.Reporting, &th.Total, &th.Weak, &th.Reused, &th.Old, &th.OldestReport)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if th.Reporting < HEALTH_MIN_REPORTS {
+			th.Total, th.Weak, th.Reused, th.Old, th.OldestReport = 0, 0, 0, 0, pq.NullTime{}
+		}
+		return nil
+	})
+}
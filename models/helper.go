@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"regexp"
 
@@ -45,10 +46,50 @@ func isNotExistsErr(err error) bool {
 	return util.CheckErr(err, sql.ErrNoRows)
 }
 
+// IsRetryableErr checks if the transaction failed because it conflicted with another one so it can be run again.
+// That's the case for serialization failures (40001) and deadlocks (40P01)
+func IsRetryableErr(err error) bool {
+	if ue, ok := err.(*util.Error); ok && ue.Inner() != nil {
+		err = ue.Inner()
+	}
+	if err == ErrConflict {
+		return true
+	}
+	pe, ok := err.(*pq.Error)
+	return ok && (pe.Code == "40001" || pe.Code == "40P01")
+}
+
+// IsTimeoutErr checks if the query was canceled because it took longer than the query or statement timeout
+func IsTimeoutErr(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case *util.Error:
+			err = e.Inner()
+		case sqlPanic:
+			err = e.err
+		case *pq.Error:
+			//query_canceled is what postgres returns when the statement_timeout expires
+			return e.Code == "57014"
+		default:
+			return err == ErrTimeout || err == context.DeadlineExceeded
+		}
+	}
+	return false
+}
+
+// sqlPanic keeps the error that made a statement panic so doTx can retry the transaction if it's retryable
+type sqlPanic struct {
+	err error
+}
+
+func (sp sqlPanic) Error() string {
+	return "Could not execute sql statement: " + sp.err.Error()
+}
+
 func isErrOrPanic(err error) bool {
 	if err != nil {
 		if err != sql.ErrTxDone || err != sql.ErrConnDone {
-			panic("Could not execute sql statement: " + err.Error())
+			panic(sqlPanic{err})
 		}
 		return true
 	}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Keys are forgotten after this so a client can't replay a request forever
+const IDEMPOTENCY_KEY_TTL = 24 * time.Hour
+
+// IdempotencyKey keeps the response of a request so retries with the same key get it back instead of running it again.
+// A status of 0 means the first request is still running
+type IdempotencyKey struct {
+	User        string    `scaneo:"pk" json:"user"`
+	Key         string    `scaneo:"pk" json:"key"`
+	RequestHash string    `json:"request_hash"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (ik *IdempotencyKey) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(ik.Key) == 0 || len(ik.Key) > 255 {
+		errs.SetFieldError("idempotency_key", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+func (ik *IdempotencyKey) Running() bool {
+	return ik.Status == 0
+}
+
+// ClaimIdempotencyKey stores the key for the request if it's new or expired and returns true. Otherwise it returns
+// what was stored for the key by the first request
+func ClaimIdempotencyKey(ctx context.Context, user, key, requestHash string) (ik *IdempotencyKey, claimed bool, err error) {
+	ik = &IdempotencyKey{User: user, Key: key, RequestHash: requestHash, Body: []byte{}, CreatedAt: time.Now().UTC()}
+	if err := ik.validate(); err != nil {
+		return nil, false, err
+	}
+	return ik, claimed, doTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`INSERT INTO "idempotency_key" `+insertIdempotencyKeyFields+` VALUES `+insertIdempotencyKeyBinds+`
+			ON CONFLICT ("user", "key") DO UPDATE SET "request_hash" = EXCLUDED."request_hash", "status" = EXCLUDED."status",
+			"content_type" = EXCLUDED."content_type", "body" = EXCLUDED."body", "created_at" = EXCLUDED."created_at"
+			WHERE "idempotency_key"."created_at" < $8`,
+			ik.User, ik.Key, ik.RequestHash, ik.Status, ik.ContentType, ik.Body, ik.CreatedAt, ik.CreatedAt.Add(-IDEMPOTENCY_KEY_TTL))
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return util.NewErrorFrom(err)
+		} else if n > 0 {
+			claimed = true
+			return nil
+		}
+		prev := &IdempotencyKey{User: user, Key: key}
+		if err := prev.dbFind(tx); isNotExistsErr(err) {
+			//Purged in the meantime
+			return util.NewErrorFrom(ErrConflict)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		ik = prev
+		return nil
+	})
+}
+
+// Complete stores the response of the request that claimed the key
+func (ik *IdempotencyKey) Complete(ctx context.Context, status int, contentType string, body []byte) error {
+	ik.Status, ik.ContentType, ik.Body = status, contentType, body
+	return doTx(ctx, func(tx *sql.Tx) error {
+		return treatUpdateErr(ik.dbUpdate(tx))
+	})
+}
+
+// Release forgets the key so the request can be retried with it after failing
+func (ik *IdempotencyKey) Release(ctx context.Context) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		_, err := ik.dbDelete(tx)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+// PurgeIdempotencyKeys removes the keys claimed before the given time. With dryRun nothing is removed.
+// The keys are chosen by the clients so they are only counted
+func PurgeIdempotencyKeys(ctx context.Context, before time.Time, dryRun bool) (purged AffectedRows, err error) {
+	return purged, doDryRunTx(ctx, dryRun, func(tx *sql.Tx) error {
+		purged, err = countAffected(tx, "idempotency_key", `WITH "purged" AS (
+			DELETE FROM "idempotency_key" WHERE "created_at" < $1 RETURNING 1
+		) SELECT COUNT(*) FROM "purged"`, before)
+		return err
+	})
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	key := util.GenerateRandomToken(20)
+	ik, claimed, err := ClaimIdempotencyKey(ctx, u.Id, key, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !claimed {
+		t.Fatal("A new key was not claimed")
+	}
+	prev, claimed, err := ClaimIdempotencyKey(ctx, u.Id, key, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed || !prev.Running() {
+		t.Fatalf("Expected the running request and got %#v", prev)
+	}
+	if err := ik.Complete(ctx, 201, "application/json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	prev, claimed, err = ClaimIdempotencyKey(ctx, u.Id, key, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed || prev.Status != 201 || string(prev.Body) != "{}" || prev.ContentType != "application/json" {
+		t.Fatalf("Unexpected stored response %#v", prev)
+	}
+	if err := ik.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed, err = ClaimIdempotencyKey(ctx, u.Id, key, "other"); err != nil || !claimed {
+		t.Fatalf("The released key could not be claimed again: %v", err)
+	}
+	if _, _, err := ClaimIdempotencyKey(ctx, u.Id, "", "hash"); !util.CheckErr(err, ErrInvalidAttributes) {
+		t.Fatalf("Expected %s and got %v", ErrInvalidAttributes, err)
+	}
+}
@@ -3,15 +3,28 @@ package models
 import (
 	"context"
 	"database/sql"
+	"sync/atomic"
 	"time"
 
 	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
 )
 
 type Invite struct {
 	Team      string    `scaneo:"pk" json:"-"`
 	Email     string    `scaneo:"pk" json:"email"`
 	CreatedAt time.Time `json:"created_at"`
+	//Message, Role and Vaults are what the inviter proposed. They are applied when the invite is accepted
+	Message   string         `json:"message,omitempty"`
+	Role      string         `json:"role"`
+	Vaults    pq.StringArray `json:"vaults,omitempty"`
+	InvitedBy string         `json:"invited_by,omitempty"`
+}
+
+// Expired tells if the invite is older than the invite lifetime. Expired invites don't add the user to the team
+func (i Invite) Expired() bool {
+	lifetime := time.Duration(atomic.LoadInt64(&inviteLifetime))
+	return lifetime > 0 && time.Since(i.CreatedAt) > lifetime
 }
 
 func FindInvitesForEmail(ctx context.Context, email string) (invs []*Invite, err error) {
@@ -32,6 +45,18 @@ func findInvitesForEmail(tx *sql.Tx, email string) ([]*Invite, error) {
 
 }
 
+// consumeInvitesForEmail removes the invites of the email and returns them. The delete locks the rows so concurrent
+// registrations can't both accept the same invite
+func consumeInvitesForEmail(tx *sql.Tx, email string) ([]*Invite, error) {
+	rows, err := tx.Query(`DELETE FROM "invite" WHERE "email" = $1 RETURNING `+selectInviteFields, email)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	invites, err := scanInvites(rows)
+	isErrOrPanic(err)
+	return invites, util.NewErrorFrom(err)
+}
+
 func (i Invite) validate() error {
 	errs := util.NewErrorFields().(*util.Error)
 	if !reValidEmail.MatchString(i.Email) {
@@ -40,6 +65,7 @@ func (i Invite) validate() error {
 	if len(i.Team) == 0 {
 		errs.SetFieldError("team", "invalid")
 	}
+	i.Assignment().validate(errs)
 	return errs.Camo()
 }
 
@@ -48,6 +74,13 @@ func (u *Invite) insert(tx *sql.Tx) error {
 		return err
 	}
 	u.CreatedAt = time.Now().UTC()
+	//An expired invite is replaced so the email can be invited again
+	if lifetime := time.Duration(atomic.LoadInt64(&inviteLifetime)); lifetime > 0 {
+		_, err := tx.Exec(`DELETE FROM "invite" WHERE "team" = $1 AND "email" = $2 AND "created_at" < $3`, u.Team, u.Email, u.CreatedAt.Add(-lifetime))
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+	}
 	_, err := u.dbInsert(tx)
 	if IsDuplicateErr(err) {
 		return util.NewErrorFrom(ErrAlreadyInvited)
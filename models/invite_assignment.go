@@ -0,0 +1,102 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const (
+	TEAM_ROLE_MEMBER  = "member"
+	TEAM_ROLE_ADMIN   = "admin"
+	TEAM_ROLE_AUDITOR = "auditor"
+)
+
+const (
+	INVITE_MESSAGE_MAX_LENGTH = 500
+	INVITE_VAULTS_MAX         = 50
+)
+
+// InviteAssignment is what the inviter proposes for the invitee: a short message, the role in the team and the vaults
+// to get. Admins get every vault and auditors none, so only members can have vaults
+type InviteAssignment struct {
+	Message string   `json:"message"`
+	Role    string   `json:"role"`
+	Vaults  []string `json:"vaults"`
+}
+
+func (i Invite) Assignment() InviteAssignment {
+	return InviteAssignment{i.Message, i.Role, i.Vaults}
+}
+
+func (ia *InviteAssignment) normalize() {
+	ia.Message = strings.TrimSpace(ia.Message)
+	if len(ia.Role) == 0 {
+		ia.Role = TEAM_ROLE_MEMBER
+	}
+}
+
+func (ia InviteAssignment) validate(errs *util.Error) {
+	if len(ia.Message) > INVITE_MESSAGE_MAX_LENGTH {
+		errs.SetFieldError("message", "too long")
+	}
+	switch ia.Role {
+	case TEAM_ROLE_MEMBER, TEAM_ROLE_ADMIN, TEAM_ROLE_AUDITOR:
+	default:
+		errs.SetFieldError("role", "invalid")
+	}
+	if len(ia.Vaults) > 0 && ia.Role != TEAM_ROLE_MEMBER {
+		errs.SetFieldError("vaults", "invalid")
+	}
+	if len(ia.Vaults) > INVITE_VAULTS_MAX || len(uniqueStrings(ia.Vaults)) != len(ia.Vaults) {
+		errs.SetFieldError("vaults", "invalid")
+	}
+}
+
+func (ia InviteAssignment) check() error {
+	errs := util.NewErrorFields().(*util.Error)
+	ia.validate(errs)
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+// checkVaults checks that the proposed vaults are in the team
+func (ia InviteAssignment) checkVaults(tx *sql.Tx, team string) error {
+	if len(ia.Vaults) == 0 {
+		return nil
+	}
+	var found int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM "vault" WHERE "team" = $1 AND "id" = ANY($2)`, team, pq.Array(ia.Vaults)).Scan(&found)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	if found != len(ia.Vaults) {
+		errs := util.NewErrorFields().(*util.Error)
+		errs.SetFieldError("vaults", "invalid")
+		return errs.SetErrorOrCamo(ErrInvalidAttributes)
+	}
+	return nil
+}
+
+// assign applies the assignment to a new member of the team. Auditors get the role right away but admins and vault
+// members need the vault keys wrapped for them, so those wait as a pending access until an admin does it
+func (t *Team) assign(tx *sql.Tx, uid, invitedBy string, ia InviteAssignment) error {
+	switch {
+	case ia.Role == TEAM_ROLE_AUDITOR:
+		tu, err := t.getUserAffiliation(tx, uid)
+		if err != nil {
+			return err
+		}
+		if tu == nil {
+			return util.NewErrorFrom(ErrNotInTeam)
+		}
+		tu.Auditor = true
+		return tu.update(tx)
+	case ia.Role == TEAM_ROLE_ADMIN || len(ia.Vaults) > 0:
+		pa := &PendingAccess{Team: t.Id, User: uid, Role: ia.Role, Vaults: ia.Vaults, InvitedBy: invitedBy, CreatedAt: time.Now().UTC()}
+		return pa.insert(tx)
+	}
+	return nil
+}
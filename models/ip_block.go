@@ -0,0 +1,158 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+// IpBlock denies access to all requests coming from an address or network until it expires
+type IpBlock struct {
+	Id        string      `scaneo:"pk" json:"id"`
+	Cidr      string      `json:"cidr"`
+	Reason    string      `json:"reason"`
+	Automatic bool        `json:"automatic"`
+	CreatedBy string      `json:"created_by"`
+	ExpiresAt pq.NullTime `json:"expires_at,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// ParseIpBlockCidr accepts a single address or a network and returns the network in canonical form
+func ParseIpBlockCidr(raw string) (*net.IPNet, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "/") {
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return nil, util.NewErrorf("Invalid address %s", raw)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(raw)
+	if err != nil {
+		return nil, util.NewErrorf("Invalid network %s", raw)
+	}
+	return ipnet, nil
+}
+
+func (ib *IpBlock) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if ipnet, err := ParseIpBlockCidr(ib.Cidr); err != nil {
+		errs.SetFieldError("ip_block_cidr", "invalid")
+	} else {
+		ib.Cidr = ipnet.String()
+	}
+	if ib.ExpiresAt.Valid && !ib.ExpiresAt.Time.After(time.Now()) {
+		errs.SetFieldError("ip_block_expires_at", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+func (ib *IpBlock) Expired(now time.Time) bool {
+	return ib.ExpiresAt.Valid && !ib.ExpiresAt.Time.After(now)
+}
+
+// AddIpBlock stores a new block. Only one block can exist for each network
+func AddIpBlock(ctx context.Context, ib *IpBlock) error {
+	if err := ib.validate(); err != nil {
+		return err
+	}
+	ib.Id = util.GenerateRandomToken(12)
+	ib.CreatedAt = time.Now().UTC()
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM "ip_block" WHERE "cidr" = $1 AND "expires_at" <= $2`, ib.Cidr, ib.CreatedAt); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		_, err := ib.dbInsert(tx)
+		switch {
+		case IsDuplicateErr(err):
+			return util.NewErrorFrom(ErrAlreadyExists)
+		case isErrOrPanic(err):
+			return util.NewErrorFrom(err)
+		}
+		return nil
+	})
+}
+
+// ExtendAutomaticIpBlock blocks the network until the given time. Automatic blocks that already
+// exist get their expiration extended but blocks created by an admin are never modified
+func ExtendAutomaticIpBlock(ctx context.Context, cidr, reason string, until time.Time) (ib *IpBlock, err error) {
+	ib = &IpBlock{Cidr: cidr, Reason: reason, Automatic: true, ExpiresAt: pq.NullTime{Time: until.UTC(), Valid: true}}
+	if err := ib.validate(); err != nil {
+		return nil, err
+	}
+	ib.Id = util.GenerateRandomToken(12)
+	ib.CreatedAt = time.Now().UTC()
+	return ib, doTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM "ip_block" WHERE "cidr" = $1 AND "expires_at" <= $2`, ib.Cidr, ib.CreatedAt); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		rows, err := tx.Query(`INSERT INTO "ip_block" (`+selectIpBlockFields+`) VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT ("cidr") DO UPDATE SET "expires_at" = GREATEST("ip_block"."expires_at", EXCLUDED."expires_at"), "reason" = EXCLUDED."reason"
+			WHERE "ip_block"."automatic"
+			RETURNING `+selectIpBlockFields, ib.Id, ib.Cidr, ib.Reason, ib.Automatic, ib.CreatedBy, ib.ExpiresAt, ib.CreatedAt)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		ibs, err := scanIpBlocks(rows)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if len(ibs) == 0 {
+			return util.NewErrorFrom(ErrAlreadyExists)
+		}
+		ib = ibs[0]
+		return nil
+	})
+}
+
+func FindIpBlock(ctx context.Context, id string) (*IpBlock, error) {
+	ib := &IpBlock{Id: id}
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		return ib.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return ib, nil
+}
+
+// GetActiveIpBlocks returns the blocks that have not expired
+func GetActiveIpBlocks(ctx context.Context) ([]*IpBlock, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := GetDB(ctx).QueryContext(ctx, `SELECT `+selectIpBlockFields+` FROM "ip_block" WHERE "expires_at" IS NULL OR "expires_at" > $1 ORDER BY "created_at"`, time.Now().UTC())
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	ibs, err := scanIpBlocks(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return ibs, nil
+}
+
+func (ib *IpBlock) Delete(ctx context.Context) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		return treatUpdateErr(ib.dbDelete(tx))
+	})
+}
+
+// PurgeExpiredIpBlocks removes the blocks that expired before the given time. With dryRun nothing is removed
+func PurgeExpiredIpBlocks(ctx context.Context, before time.Time, dryRun bool) (purged AffectedRows, err error) {
+	return purged, doDryRunTx(ctx, dryRun, func(tx *sql.Tx) error {
+		purged, err = queryAffected(tx, "ip_block", `DELETE FROM "ip_block" WHERE "expires_at" < $1 RETURNING "id"`, before)
+		return err
+	})
+}
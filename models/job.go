@@ -0,0 +1,99 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const (
+	JOB_STATUS_OK     = "ok"
+	JOB_STATUS_FAILED = "failed"
+)
+
+// Job keeps the outcome of the last run of a background job
+type Job struct {
+	Name           string      `scaneo:"pk" json:"name"`
+	Schedule       string      `json:"schedule"`
+	Running        bool        `json:"running"`
+	LastInstance   string      `json:"last_instance,omitempty"`
+	LastStatus     string      `json:"last_status,omitempty"`
+	LastMessage    string      `json:"last_message,omitempty"`
+	LastStartedAt  pq.NullTime `json:"last_started_at,omitempty"`
+	LastDurationMs int64       `json:"last_duration_ms"`
+	NextRunAt      pq.NullTime `json:"next_run_at,omitempty"`
+	Runs           int64       `json:"runs"`
+	Failures       int64       `json:"failures"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+}
+
+func nullTime(t time.Time) pq.NullTime {
+	return pq.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// RegisterJob stores the job if it's new and updates its schedule otherwise
+func RegisterJob(ctx context.Context, name, schedule string, next time.Time) error {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	j := &Job{Name: name, Schedule: schedule, NextRunAt: nullTime(next), UpdatedAt: time.Now().UTC()}
+	_, err := GetDB(ctx).ExecContext(ctx, `INSERT INTO "job" (`+selectJobFields+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT ("name") DO UPDATE SET "schedule" = EXCLUDED."schedule", "next_run_at" = EXCLUDED."next_run_at", "updated_at" = EXCLUDED."updated_at"`,
+		j.Name, j.Schedule, j.Running, j.LastInstance, j.LastStatus, j.LastMessage, j.LastStartedAt, j.LastDurationMs, j.NextRunAt, j.Runs, j.Failures, j.UpdatedAt)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+// StartJobRun flags the job as running in the given instance
+func StartJobRun(ctx context.Context, name, instance string, start time.Time) error {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	res, err := GetDB(ctx).ExecContext(ctx, `UPDATE "job" SET "running" = TRUE, "last_instance" = $2, "last_started_at" = $3, "updated_at" = $3 WHERE "name" = $1`, name, instance, start.UTC())
+	return treatUpdateErr(res, err)
+}
+
+// FinishJobRun records the outcome of the run and when the job will run again
+func FinishJobRun(ctx context.Context, name string, runErr error, message string, duration time.Duration, next time.Time) error {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	status, failed := JOB_STATUS_OK, 0
+	if runErr != nil {
+		status, failed, message = JOB_STATUS_FAILED, 1, runErr.Error()
+	}
+	res, err := GetDB(ctx).ExecContext(ctx, `UPDATE "job" SET "running" = FALSE, "last_status" = $2, "last_message" = $3, "last_duration_ms" = $4,
+		"next_run_at" = $5, "runs" = "runs" + 1, "failures" = "failures" + $6, "updated_at" = $7 WHERE "name" = $1`,
+		name, status, message, int64(duration/time.Millisecond), nullTime(next), failed, time.Now().UTC())
+	return treatUpdateErr(res, err)
+}
+
+func FindJob(ctx context.Context, name string) (*Job, error) {
+	j := &Job{Name: name}
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		return j.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return j, nil
+}
+
+func GetJobs(ctx context.Context) ([]*Job, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := GetDB(ctx).QueryContext(ctx, `SELECT `+selectJobFields+` FROM "job" ORDER BY "name"`)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	js, err := scanJobs(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return js, nil
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// KeyLogEntry records the public key a user had from its creation. The entries are the leaves of a merkle tree so
+// clients can check that the key they get for a member is in the same log everybody else sees
+type KeyLogEntry struct {
+	Seq       int64     `scaneo:"pk" json:"seq"`
+	User      string    `json:"user"`
+	PublicKey []byte    `json:"public_key"`
+	LeafHash  []byte    `json:"leaf_hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KeyLogLeaf is the data of the leaf of an entry. Clients compute the leaf hash from it to check the proofs
+func KeyLogLeaf(seq int64, user string, publicKey []byte, createdAt time.Time) []byte {
+	return []byte(fmt.Sprintf("keycatd.keylog\x00%d\x00%s\x00%s\x00%d", seq, user, hex.EncodeToString(publicKey), createdAt.Unix()))
+}
+
+// appendKeyLog adds the public key of the user at the end of the log in the transaction that changes it
+func appendKeyLog(tx *sql.Tx, user string, publicKey []byte) error {
+	kle := &KeyLogEntry{User: user, PublicKey: publicKey, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	err := tx.QueryRow(`UPDATE "key_log_head" SET "size" = "size" + 1 WHERE "id" = 1 RETURNING "size" - 1`).Scan(&kle.Seq)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	kle.LeafHash = util.MerkleLeafHash(KeyLogLeaf(kle.Seq, kle.User, kle.PublicKey, kle.CreatedAt))
+	_, err = kle.dbInsert(tx)
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
+
+// KeyLogTree has the leaf hashes of the log when it was loaded
+type KeyLogTree struct {
+	Leaves [][]byte
+}
+
+func (klt *KeyLogTree) Size() int64 {
+	return int64(len(klt.Leaves))
+}
+
+// Root is the root of the tree with the first size leaves
+func (klt *KeyLogTree) Root(size int64) []byte {
+	return util.MerkleRoot(klt.Leaves[:size])
+}
+
+func (klt *KeyLogTree) InclusionProof(seq int64) [][]byte {
+	return util.MerkleInclusionProof(int(seq), klt.Leaves)
+}
+
+func (klt *KeyLogTree) ConsistencyProof(from int64) [][]byte {
+	return util.MerkleConsistencyProof(int(from), klt.Leaves)
+}
+
+// LoadKeyLogTree loads the leaf hashes of the whole log
+func LoadKeyLogTree(ctx context.Context) (*KeyLogTree, error) {
+	klt := &KeyLogTree{Leaves: [][]byte{}}
+	return klt, doTx(ctx, func(tx *sql.Tx) error {
+		var size int64
+		if err := tx.QueryRow(`SELECT "size" FROM "key_log_head" WHERE "id" = 1`).Scan(&size); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		rows, err := tx.Query(`SELECT "leaf_hash" FROM "key_log" WHERE "seq" < $1 ORDER BY "seq"`, size)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var leaf []byte
+			if err := rows.Scan(&leaf); err != nil {
+				return util.NewErrorFrom(err)
+			}
+			klt.Leaves = append(klt.Leaves, leaf)
+		}
+		if err := rows.Err(); err != nil {
+			return util.NewErrorFrom(err)
+		}
+		if klt.Size() != size {
+			return util.NewErrorf("The key log has %d entries but its head says %d", klt.Size(), size)
+		}
+		return nil
+	})
+}
+
+// FindKeyLogEntries returns the entries of the user up to size, oldest first
+func FindKeyLogEntries(ctx context.Context, user string, size int64) (entries []*KeyLogEntry, err error) {
+	return entries, doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+selectKeyLogEntryFields+` FROM "key_log" WHERE "user" = $1 AND "seq" < $2 ORDER BY "seq"`, user, size)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		entries, err = scanKeyLogEntrys(rows)
+		return util.NewErrorFrom(err)
+	})
+}
+
+// BackfillKeyLog adds the users created before the key log existed. It returns how many were added
+func BackfillKeyLog(ctx context.Context) (added int, err error) {
+	return added, doTx(ctx, func(tx *sql.Tx) error {
+		added = 0
+		//Lock the head first so two instances starting at the same time don't add the users twice
+		if _, err := tx.Exec(`SELECT "size" FROM "key_log_head" WHERE "id" = 1 FOR UPDATE`); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		rows, err := tx.Query(`SELECT "id", "public_key" FROM "user" u WHERE NOT EXISTS (SELECT 1 FROM "key_log" kl WHERE kl."user" = u."id") ORDER BY "created_at", "id"`)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		type userKey struct {
+			id  string
+			pub []byte
+		}
+		users := []userKey{}
+		for rows.Next() {
+			uk := userKey{}
+			if err := rows.Scan(&uk.id, &uk.pub); err != nil {
+				rows.Close()
+				return util.NewErrorFrom(err)
+			}
+			users = append(users, uk)
+		}
+		rows.Close()
+		for _, uk := range users {
+			if err := appendKeyLog(tx, uk.id, uk.pub); err != nil {
+				return err
+			}
+			added++
+		}
+		return nil
+	})
+}
+
+// FindKeyLogMismatches returns the users whose public key is not the last one in the log. That means the key was
+// changed in the db without going through the server
+func FindKeyLogMismatches(ctx context.Context) (users []string, err error) {
+	return users, doTx(ctx, func(tx *sql.Tx) error {
+		users = []string{}
+		rows, err := tx.Query(`SELECT u."id" FROM "user" u LEFT JOIN (SELECT DISTINCT ON ("user") "user", "public_key" FROM "key_log" ORDER BY "user", "seq" DESC) kl ON kl."user" = u."id" WHERE kl."public_key" IS NULL OR kl."public_key" <> u."public_key" ORDER BY u."id"`)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return util.NewErrorFrom(err)
+			}
+			users = append(users, id)
+		}
+		return util.NewErrorFrom(rows.Err())
+	})
+}
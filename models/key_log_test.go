@@ -0,0 +1,55 @@
+package models
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestKeyLog(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	klt, err := LoadKeyLogTree(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := FindKeyLogEntries(ctx, u.Id, klt.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !bytes.Equal(entries[0].PublicKey, u.PublicKey) {
+		t.Fatalf("Unexpected key log entries %#v", entries)
+	}
+	kle := entries[0]
+	leaf := util.MerkleLeafHash(KeyLogLeaf(kle.Seq, kle.User, kle.PublicKey, kle.CreatedAt))
+	if !util.VerifyMerkleInclusion(int(kle.Seq), int(klt.Size()), leaf, klt.InclusionProof(kle.Seq), klt.Root(klt.Size())) {
+		t.Fatalf("The entry is not included in the tree")
+	}
+	_, _, fullpack := generateNewKeys()
+	if err := u.ChangePassword(ctx, "newpassword", fullpack); err != nil {
+		t.Fatal(err)
+	}
+	next, err := LoadKeyLogTree(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err = FindKeyLogEntries(ctx, u.Id, next.Size()); err != nil || len(entries) != 2 {
+		t.Fatalf("Expected a new entry after changing the key: %v %s", entries, err)
+	}
+	if !util.VerifyMerkleConsistency(int(klt.Size()), int(next.Size()), klt.Root(klt.Size()), next.Root(next.Size()), next.ConsistencyProof(klt.Size())) {
+		t.Errorf("The key log is not consistent")
+	}
+	users, err := FindKeyLogMismatches(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range users {
+		if uid == u.Id {
+			t.Errorf("The user is reported as changed outside of the server")
+		}
+	}
+	if n, err := BackfillKeyLog(ctx); err != nil || n != 0 {
+		t.Errorf("Expected nothing to backfill and got %d %s", n, err)
+	}
+}
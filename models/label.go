@@ -0,0 +1,277 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const (
+	LABEL_NAME_MAX_LENGTH = 50
+	LABELS_PER_TEAM_MAX   = 200
+	LABELS_PER_SECRET_MAX = 20
+)
+
+var reValidLabelColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Label is a name and a color the admins of a team define so the members can sort their secrets
+type Label struct {
+	Team      string    `scaneo:"pk" json:"-"`
+	Id        string    `scaneo:"pk" json:"id"`
+	Name      string    `json:"name"`
+	Color     string    `json:"color"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SecretLabel attaches a label to a secret. Only the ids are kept as the contents of the secrets are sealed
+type SecretLabel struct {
+	Team   string `scaneo:"pk" json:"-"`
+	Vault  string `scaneo:"pk" json:"vault"`
+	Secret string `scaneo:"pk" json:"secret"`
+	Label  string `scaneo:"pk" json:"label"`
+}
+
+func (l *Label) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	l.Name = strings.TrimSpace(l.Name)
+	if len(l.Name) == 0 {
+		errs.SetFieldError("name", "missing")
+	} else if len(l.Name) > LABEL_NAME_MAX_LENGTH {
+		errs.SetFieldError("name", "too long")
+	}
+	if !reValidLabelColor.MatchString(l.Color) {
+		errs.SetFieldError("color", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+func duplicateLabelName() error {
+	errs := util.NewErrorFields().(*util.Error)
+	errs.SetFieldError("name", "duplicate")
+	return errs.SetErrorOrCamo(ErrAlreadyExists)
+}
+
+func (l *Label) insert(tx *sql.Tx) error {
+	l.Id = util.GenerateRandomToken(8)
+	if err := l.validate(); err != nil {
+		return err
+	}
+	l.CreatedAt = time.Now().UTC()
+	l.UpdatedAt = l.CreatedAt
+	_, err := l.dbInsert(tx)
+	switch {
+	case IsDuplicateErr(err):
+		return duplicateLabelName()
+	case isErrOrPanic(err):
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+// CreateLabel adds a label to the team. Names are unique in the team regardless of the case
+func (t *Team) CreateLabel(ctx context.Context, admin *User, name, color string) (l *Label, err error) {
+	return l, doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM "label" WHERE "team" = $1`, t.Id).Scan(&count); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if count >= LABELS_PER_TEAM_MAX {
+			return util.NewErrorf("Teams can't have more than %d labels", LABELS_PER_TEAM_MAX)
+		}
+		l = &Label{Team: t.Id, Name: name, Color: color}
+		return l.insert(tx)
+	})
+}
+
+func (t *Team) GetLabels(ctx context.Context) ([]*Label, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := getReadDB(ctx).QueryContext(ctx, `SELECT `+selectLabelFields+` FROM "label" WHERE "team" = $1 ORDER BY LOWER("name")`, t.Id)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	labels, err := scanLabels(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return labels, nil
+}
+
+func (t *Team) GetLabel(ctx context.Context, lid string) (l *Label, err error) {
+	l = &Label{Team: t.Id, Id: lid}
+	err = doTx(ctx, func(tx *sql.Tx) error {
+		return l.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return l, nil
+}
+
+// UpdateLabel renames or recolors a label. The secrets keep it as they only point to its id
+func (t *Team) UpdateLabel(ctx context.Context, admin *User, l *Label) error {
+	l.Team = t.Id
+	if err := l.validate(); err != nil {
+		return err
+	}
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		prev := &Label{Team: t.Id, Id: l.Id}
+		if err := prev.dbFind(tx); isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		l.CreatedAt = prev.CreatedAt
+		l.UpdatedAt = time.Now().UTC()
+		_, err := l.dbUpdate(tx)
+		switch {
+		case IsDuplicateErr(err):
+			return duplicateLabelName()
+		case isErrOrPanic(err):
+			return util.NewErrorFrom(err)
+		}
+		return nil
+	})
+}
+
+// DeleteLabel removes the label and detaches it from all the secrets that had it
+func (t *Team) DeleteLabel(ctx context.Context, admin *User, lid string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM "secret_label" WHERE "team" = $1 AND "label" = $2`, t.Id, lid); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		return treatUpdateErr((&Label{Team: t.Id, Id: lid}).dbDelete(tx))
+	})
+}
+
+// GetSecretLabelsForUser returns the labels of the secrets in the vaults the user can read
+func (t *Team) GetSecretLabelsForUser(ctx context.Context, u *User) ([]*SecretLabel, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := getReadDB(ctx).QueryContext(ctx, `
+		SELECT "secret_label"."team", "secret_label"."vault", "secret_label"."secret", "secret_label"."label"
+		FROM "secret_label", "vault_user"
+		WHERE
+			"secret_label"."team" = $1 AND
+			"vault_user"."team" = "secret_label"."team" AND
+			"vault_user"."vault" = "secret_label"."vault" AND
+			"vault_user"."user" = $2 AND
+			`+activeVaultUser+`
+		ORDER BY "secret_label"."vault", "secret_label"."secret", "secret_label"."label"`, t.Id, u.Id)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	sls, err := scanSecretLabels(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return sls, nil
+}
+
+// GetSecretLabels returns the labels of the secrets of the vault
+func (v Vault) GetSecretLabels(ctx context.Context) ([]*SecretLabel, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := getReadDB(ctx).QueryContext(ctx, `SELECT `+selectSecretLabelFields+` FROM "secret_label" WHERE "team" = $1 AND "vault" = $2 ORDER BY "secret", "label"`, v.Team, v.Id)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	sls, err := scanSecretLabels(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return sls, nil
+}
+
+// SetSecretLabels replaces the labels of a secret. The labels have to belong to the team of the vault
+func (v Vault) SetSecretLabels(ctx context.Context, sid string, lids []string) ([]string, error) {
+	lids = uniqueStrings(lids)
+	if len(lids) > LABELS_PER_SECRET_MAX {
+		return nil, util.NewErrorf("Secrets can't have more than %d labels", LABELS_PER_SECRET_MAX)
+	}
+	return lids, doTx(ctx, func(tx *sql.Tx) error {
+		if _, err := v.getSecret(tx, sid); err != nil {
+			return err
+		}
+		var found int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM "label" WHERE "team" = $1 AND "id" = ANY($2)`, v.Team, pq.Array(lids)).Scan(&found); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if found != len(lids) {
+			errs := util.NewErrorFields().(*util.Error)
+			errs.SetFieldError("labels", "invalid")
+			return errs.SetErrorOrCamo(ErrInvalidAttributes)
+		}
+		if err := v.deleteSecretLabels(tx, sid); err != nil {
+			return err
+		}
+		return v.insertSecretLabels(tx, sid, lids)
+	})
+}
+
+func (v Vault) deleteSecretLabels(tx *sql.Tx, sid string) error {
+	_, err := tx.Exec(`DELETE FROM "secret_label" WHERE "team" = $1 AND "vault" = $2 AND "secret" = $3`, v.Team, v.Id, sid)
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
+
+func (v Vault) getSecretLabelIds(tx *sql.Tx, sid string) ([]string, error) {
+	rows, err := tx.Query(`SELECT "label" FROM "secret_label" WHERE "team" = $1 AND "vault" = $2 AND "secret" = $3`, v.Team, v.Id, sid)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	defer rows.Close()
+	var lids []string
+	for rows.Next() {
+		var lid string
+		if err := rows.Scan(&lid); isErrOrPanic(err) {
+			return nil, util.NewErrorFrom(err)
+		}
+		lids = append(lids, lid)
+	}
+	if err := rows.Err(); isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return lids, nil
+}
+
+func (v Vault) insertSecretLabels(tx *sql.Tx, sid string, lids []string) error {
+	for _, lid := range lids {
+		if _, err := (&SecretLabel{Team: v.Team, Vault: v.Id, Secret: sid, Label: lid}).dbInsert(tx); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+	}
+	return nil
+}
+
+func uniqueStrings(vals []string) []string {
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(vals))
+	for _, val := range vals {
+		if !seen[val] {
+			seen[val] = true
+			unique = append(unique, val)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
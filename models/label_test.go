@@ -0,0 +1,65 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestSecretLabels(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	if _, err := team.CreateLabel(ctx, owner, "prod", "red"); !util.CheckErr(err, ErrInvalidAttributes) {
+		t.Fatalf("Expected an invalid color and got %v", err)
+	}
+	prod, err := team.CreateLabel(ctx, owner, "prod", "#ff0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := team.CreateLabel(ctx, owner, " PROD ", "#00ff00"); !util.CheckErr(err, ErrAlreadyExists) {
+		t.Fatalf("Expected a duplicated name and got %v", err)
+	}
+	dev, err := team.CreateLabel(ctx, owner, "dev", "#00ff00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vms := []vaultMock{getFirstVault(owner, team), createVaultMock(owner, team)}
+	s := &Secret{Data: signAndPack(vms[0].priv, a32b)}
+	if err := vms[0].v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vms[0].v.SetSecretLabels(ctx, s.Id, []string{prod.Id, "nope"}); !util.CheckErr(err, ErrInvalidAttributes) {
+		t.Fatalf("Expected an unknown label and got %v", err)
+	}
+	lids, err := vms[0].v.SetSecretLabels(ctx, s.Id, []string{prod.Id, dev.Id, prod.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lids) != 2 {
+		t.Fatalf("Expected the labels to be deduplicated and got %v", lids)
+	}
+	s.Data = signAndPack(vms[1].priv, a32b)
+	if err := MoveSecretToVault(ctx, s, vms[0].v, vms[1].v); err != nil {
+		t.Fatal(err)
+	}
+	if err := team.DeleteLabel(ctx, owner, dev.Id); err != nil {
+		t.Fatal(err)
+	}
+	sls, err := team.GetSecretLabelsForUser(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sls) != 1 || sls[0].Vault != vms[1].v.Id || sls[0].Secret != s.Id || sls[0].Label != prod.Id {
+		t.Fatalf("Expected the labels to follow the secret and lose the deleted one: %#v", sls)
+	}
+	if err := vms[1].v.DeleteSecret(ctx, s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if sls, err = vms[1].v.GetSecretLabels(ctx); err != nil || len(sls) > 0 {
+		t.Fatalf("Expected no labels once the secret is deleted and got %v %v", sls, err)
+	}
+	member := getDummyUser()
+	if _, err := team.CreateLabel(ctx, member, "other", "#0000ff"); err == nil {
+		t.Fatal("Only admins should be able to create labels")
+	}
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const (
+	MACHINE_TOKEN_NAME_MAX_LENGTH = 50
+	MACHINE_TOKENS_PER_VAULT_MAX  = 20
+	MACHINE_TOKEN_LABELS_MAX      = 20
+)
+
+// MachineToken lets a machine read the secrets of a single vault with the vault key of the user that issued it. It's
+// stored with the hash of its secret as the id like the other tokens, so the id can be listed and used to revoke it.
+// Tokens are removed with the access of their user to the vault
+type MachineToken struct {
+	Id    string `scaneo:"pk" json:"id"`
+	Team  string `json:"team"`
+	Vault string `json:"vault"`
+	User  string `json:"user"`
+	Name  string `json:"name"`
+	//Labels limit the token to the secrets with any of them. Without labels it reads the whole vault
+	Labels    pq.StringArray `json:"labels"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// IssuedMachineToken is a machine token that has just been stored along with the secret to hand to the machine
+type IssuedMachineToken struct {
+	*MachineToken
+	Secret string
+}
+
+func (mt *MachineToken) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	mt.Name = strings.TrimSpace(mt.Name)
+	if len(mt.Name) == 0 {
+		errs.SetFieldError("name", "missing")
+	} else if len(mt.Name) > MACHINE_TOKEN_NAME_MAX_LENGTH {
+		errs.SetFieldError("name", "too long")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+// CreateMachineToken issues a token for the vault that reads with the key the user has for it. With labels the token
+// only reads the secrets that have any of them. The labels have to belong to the team of the vault
+func (v Vault) CreateMachineToken(ctx context.Context, u *User, name string, lids []string) (mt *IssuedMachineToken, err error) {
+	lids = uniqueStrings(lids)
+	if len(lids) > MACHINE_TOKEN_LABELS_MAX {
+		return nil, util.NewErrorf("Machine tokens can't have more than %d labels", MACHINE_TOKEN_LABELS_MAX)
+	}
+	secret := util.GenerateSecretToken()
+	mt = &IssuedMachineToken{&MachineToken{Id: hashTokenSecret(secret), Team: v.Team, Vault: v.Id, User: u.Id, Name: name, Labels: lids}, secret}
+	if err := mt.validate(); err != nil {
+		return nil, err
+	}
+	return mt, doTx(ctx, func(tx *sql.Tx) error {
+		var member bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM "vault_user" WHERE "team" = $1 AND "vault" = $2 AND "user" = $3 AND `+activeVaultUser+`)`, v.Team, v.Id, u.Id).Scan(&member)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if !member {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		var found int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM "label" WHERE "team" = $1 AND "id" = ANY($2)`, v.Team, pq.Array(lids)).Scan(&found); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if found != len(lids) {
+			errs := util.NewErrorFields().(*util.Error)
+			errs.SetFieldError("labels", "invalid")
+			return errs.SetErrorOrCamo(ErrInvalidAttributes)
+		}
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM "machine_token" WHERE "team" = $1 AND "vault" = $2`, v.Team, v.Id).Scan(&count); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if count >= MACHINE_TOKENS_PER_VAULT_MAX {
+			return util.NewErrorf("Vaults can't have more than %d machine tokens", MACHINE_TOKENS_PER_VAULT_MAX)
+		}
+		mt.CreatedAt = time.Now().UTC()
+		_, err = mt.dbInsert(tx)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+// FindMachineToken returns ErrDoesntExist for unknown and revoked tokens
+func FindMachineToken(ctx context.Context, secret string) (*MachineToken, error) {
+	mt := &MachineToken{Id: hashTokenSecret(secret)}
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		return mt.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return mt, nil
+}
+
+// GetMachineTokens returns the machine tokens of the vault by creation date
+func (v Vault) GetMachineTokens(ctx context.Context) ([]*MachineToken, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := getReadDB(ctx).QueryContext(ctx, `SELECT `+selectMachineTokenFields+` FROM "machine_token" WHERE "team" = $1 AND "vault" = $2 ORDER BY "created_at"`, v.Team, v.Id)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	mts, err := scanMachineTokens(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return mts, nil
+}
+
+// GetMachineToken returns ErrDoesntExist if the token isn't one of the vault
+func (v Vault) GetMachineToken(ctx context.Context, id string) (*MachineToken, error) {
+	mt := &MachineToken{Id: id}
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		return mt.dbFind(tx)
+	})
+	if isNotExistsErr(err) || (err == nil && (mt.Team != v.Team || mt.Vault != v.Id)) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return mt, nil
+}
+
+// DeleteMachineToken revokes the token. Machines using it get a 401 from the next request on
+func (v Vault) DeleteMachineToken(ctx context.Context, id string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM "machine_token" WHERE "id" = $1 AND "team" = $2 AND "vault" = $3`, id, v.Team, v.Id)
+		return treatUpdateErr(res, err)
+	})
+}
+
+// GetReadableSecrets returns the ids of the secrets of the vault the token reads, or nil if it reads all of them.
+// Deleted labels are detached from the secrets, so a token whose labels are all gone reads nothing
+func (mt *MachineToken) GetReadableSecrets(ctx context.Context) (map[string]bool, error) {
+	if len(mt.Labels) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := getReadDB(ctx).QueryContext(ctx, `SELECT DISTINCT "secret" FROM "secret_label" WHERE "team" = $1 AND "vault" = $2 AND "label" = ANY($3)`, mt.Team, mt.Vault, pq.Array([]string(mt.Labels)))
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	defer rows.Close()
+	sids := map[string]bool{}
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); isErrOrPanic(err) {
+			return nil, util.NewErrorFrom(err)
+		}
+		sids[sid] = true
+	}
+	if err := rows.Err(); isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return sids, nil
+}
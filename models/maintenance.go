@@ -0,0 +1,60 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// maintenanceRow is the id of the only row of the maintenance table
+const maintenanceRow = 1
+
+// Maintenance is the maintenance mode of the whole cluster. It's stored so it survives restarts and every instance
+// can load it. Without a row the server is not in maintenance
+type Maintenance struct {
+	Id         int       `scaneo:"pk" json:"-"`
+	Message    string    `json:"message"`
+	RetryAfter int       `json:"retry_after"`
+	Since      time.Time `json:"since"`
+	EnabledBy  string    `json:"enabled_by"`
+}
+
+// GetMaintenance returns ErrDoesntExist when the server is not in maintenance
+func GetMaintenance(ctx context.Context) (*Maintenance, error) {
+	m := &Maintenance{Id: maintenanceRow}
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		return m.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return m, nil
+}
+
+// SetMaintenance puts the server in maintenance or replaces the message of the current one
+func SetMaintenance(ctx context.Context, m *Maintenance) error {
+	m.Id = maintenanceRow
+	return doTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO "maintenance" (`+selectMaintenanceFields+`) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT ("id") DO UPDATE SET "message" = EXCLUDED."message", "retry_after" = EXCLUDED."retry_after", "since" = EXCLUDED."since", "enabled_by" = EXCLUDED."enabled_by"`,
+			m.Id, m.Message, m.RetryAfter, m.Since, m.EnabledBy)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		return nil
+	})
+}
+
+// ClearMaintenance takes the server out of maintenance. It's not an error if it wasn't in maintenance
+func ClearMaintenance(ctx context.Context) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM "maintenance" WHERE "id" = $1`, maintenanceRow)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
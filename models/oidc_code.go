@@ -0,0 +1,65 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Clients exchange the codes right after the redirect so they don't need to live long
+const OIDC_CODE_TTL = time.Minute
+
+// OIDCCode is the authorization code an OIDC client exchanges for the tokens of a user. It's stored in a token whose
+// id is the code
+type OIDCCode struct {
+	Code        string `json:"-"`
+	User        string `json:"-"`
+	Client      string `json:"client"`
+	RedirectURI string `json:"redirect_uri"`
+	Scope       string `json:"scope"`
+	Nonce       string `json:"nonce,omitempty"`
+	//CodeChallenge is the S256 PKCE challenge of the client if it sent one
+	CodeChallenge string    `json:"code_challenge,omitempty"`
+	CreatedAt     time.Time `json:"-"`
+}
+
+// NewOIDCCode stores the code with a new random secret
+func NewOIDCCode(ctx context.Context, oc *OIDCCode) error {
+	extra, err := json.Marshal(oc)
+	if err != nil {
+		return util.NewErrorFrom(err)
+	}
+	t := &IssuedToken{Token: &Token{Type: TOKEN_OIDC_CODE, User: oc.User, Extra: SealedString(extra)}}
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.insert(tx); err != nil {
+			return err
+		}
+		oc.Code, oc.CreatedAt = t.Secret, t.CreatedAt
+		return nil
+	})
+}
+
+// RedeemOIDCCode returns the code and marks it as used so it can only be exchanged once
+func RedeemOIDCCode(ctx context.Context, code string) (oc *OIDCCode, err error) {
+	return oc, doTx(ctx, func(tx *sql.Tx) error {
+		t := &Token{Id: hashTokenSecret(code)}
+		if err := t.consume(tx); err != nil {
+			return err
+		}
+		if err := t.find(tx); err != nil {
+			return util.NewErrorFrom(err)
+		}
+		if t.Type != TOKEN_OIDC_CODE || time.Since(t.CreatedAt) > OIDC_CODE_TTL {
+			return util.NewErrorFrom(ErrDoesntExist)
+		}
+		oc = &OIDCCode{}
+		if err := json.Unmarshal([]byte(t.Extra), oc); err != nil {
+			return util.NewErrorFrom(err)
+		}
+		oc.Code, oc.User, oc.CreatedAt = code, t.User, t.CreatedAt
+		return nil
+	})
+}
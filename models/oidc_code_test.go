@@ -0,0 +1,39 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestOIDCCode(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	oc := &OIDCCode{User: u.Id, Client: "grafana", RedirectURI: "https://grafana/login", Scope: "openid email", Nonce: "n"}
+	if err := NewOIDCCode(ctx, oc); err != nil {
+		t.Fatal(err)
+	}
+	if len(oc.Code) == 0 {
+		t.Fatal("The code has no id")
+	}
+	found, err := RedeemOIDCCode(ctx, oc.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.User != u.Id || found.Client != oc.Client || found.RedirectURI != oc.RedirectURI || found.Nonce != oc.Nonce {
+		t.Fatalf("Unexpected code %#v", found)
+	}
+	if _, err := RedeemOIDCCode(ctx, oc.Code); !util.CheckErr(err, ErrAlreadyUsed) {
+		t.Fatalf("Expected %s redeeming the code twice and got %s", ErrAlreadyUsed, err)
+	}
+	p, err := NewPairing(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RedeemOIDCCode(ctx, p.Code); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s redeeming another kind of token and got %s", ErrDoesntExist, err)
+	}
+	if _, err := FindPairing(ctx, p.Code); err != nil {
+		t.Fatalf("The pairing was removed: %s", err)
+	}
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+type orphanCheck struct {
+	kind  string
+	query string
+	//ids is what the removed rows return to list them in the report. Credentials are only counted
+	ids string
+}
+
+// orphanChecks go from parents to children so rows left behind by an earlier removal are picked up by the later checks
+// in dbs that don't cascade deletes
+var orphanChecks = []orphanCheck{
+	{"team_without_owner", `DELETE FROM "team" t WHERE NOT EXISTS (SELECT 1 FROM "user" u WHERE u."id" = t."owner")`, `t."id"`},
+	{"team_user_without_user", `DELETE FROM "team_user" tu WHERE NOT EXISTS (SELECT 1 FROM "user" u WHERE u."id" = tu."user")`, `tu."team" || '/' || tu."user"`},
+	{"team_user_without_team", `DELETE FROM "team_user" tu WHERE NOT EXISTS (SELECT 1 FROM "team" t WHERE t."id" = tu."team")`, `tu."team" || '/' || tu."user"`},
+	{"vault_without_team", `DELETE FROM "vault" v WHERE NOT EXISTS (SELECT 1 FROM "team" t WHERE t."id" = v."team")`, `v."team" || '/' || v."id"`},
+	{"vault_user_without_member", `DELETE FROM "vault_user" vu WHERE NOT EXISTS (SELECT 1 FROM "team_user" tu WHERE tu."team" = vu."team" AND tu."user" = vu."user")`, `vu."team" || '/' || vu."vault" || '/' || vu."user"`},
+	{"vault_user_without_vault", `DELETE FROM "vault_user" vu WHERE NOT EXISTS (SELECT 1 FROM "vault" v WHERE v."team" = vu."team" AND v."id" = vu."vault")`, `vu."team" || '/' || vu."vault" || '/' || vu."user"`},
+	//Nobody holds the key of these so their secrets can't be read anymore
+	{"vault_without_users", `DELETE FROM "vault" v WHERE NOT EXISTS (SELECT 1 FROM "vault_user" vu WHERE vu."team" = v."team" AND vu."vault" = v."id")`, `v."team" || '/' || v."id"`},
+	{"secret_without_vault", `DELETE FROM "secret" s WHERE NOT EXISTS (SELECT 1 FROM "vault" v WHERE v."team" = s."team" AND v."id" = s."vault")`, `s."team" || '/' || s."vault" || '/' || s."id" || '/' || s."version"::TEXT`},
+	{"invite_without_team", `DELETE FROM "invite" i WHERE NOT EXISTS (SELECT 1 FROM "team" t WHERE t."id" = i."team")`, `i."team" || '/' || i."email"`},
+	//Invites are only consumed on registration so users that confirm the email later leave them behind
+	{"invite_for_member", `DELETE FROM "invite" i WHERE EXISTS (SELECT 1 FROM "user" u, "team_user" tu WHERE u."email" = i."email" AND tu."user" = u."id" AND tu."team" = i."team")`, `i."team" || '/' || i."email"`},
+	{"token_without_user", `DELETE FROM "token" t WHERE NOT EXISTS (SELECT 1 FROM "user" u WHERE u."id" = t."user")`, ""},
+	{"session_without_user", `DELETE FROM "session" s WHERE NOT EXISTS (SELECT 1 FROM "user" u WHERE u."id" = s."user")`, ""},
+	{"webhook_without_team", `DELETE FROM "webhook" w WHERE NOT EXISTS (SELECT 1 FROM "team" t WHERE t."id" = w."team")`, `w."team" || '/' || w."id"`},
+	{"webhook_delivery_without_webhook", `DELETE FROM "webhook_delivery" d WHERE NOT EXISTS (SELECT 1 FROM "webhook" w WHERE w."team" = d."team" AND w."id" = d."webhook")`, `d."id"`},
+	{"team_matrix_without_team", `DELETE FROM "team_matrix" m WHERE NOT EXISTS (SELECT 1 FROM "team" t WHERE t."id" = m."team")`, `m."team"`},
+	//Team overrides can't reference the team because global flags have an empty team
+	{"feature_flag_without_team", `DELETE FROM "feature_flag" f WHERE f."team" != '' AND NOT EXISTS (SELECT 1 FROM "team" t WHERE t."id" = f."team")`, `f."team" || '/' || f."name"`},
+}
+
+// OrphanReport lists how many orphaned rows of each kind have been found
+type OrphanReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Total   int64          `json:"total"`
+	Orphans []AffectedRows `json:"orphans"`
+	RanAt   time.Time      `json:"ran_at"`
+}
+
+// CollectOrphans removes the rows that point to data that doesn't exist anymore or can't be reached.
+// Everything is removed in one transaction. With dryRun the transaction is rolled back so the report shows what would be removed
+func CollectOrphans(ctx context.Context, dryRun bool) (*OrphanReport, error) {
+	or := &OrphanReport{DryRun: dryRun, RanAt: time.Now().UTC()}
+	err := doDryRunTx(ctx, dryRun, func(tx *sql.Tx) error {
+		or.Orphans, or.Total = []AffectedRows{}, 0
+		for _, oc := range orphanChecks {
+			ar, err := oc.run(tx)
+			if err != nil {
+				return err
+			}
+			or.Orphans = append(or.Orphans, ar)
+			or.Total += ar.Count
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return or, nil
+}
+
+func (oc orphanCheck) run(tx *sql.Tx) (AffectedRows, error) {
+	if len(oc.ids) > 0 {
+		return queryAffected(tx, oc.kind, oc.query+` RETURNING `+oc.ids)
+	}
+	res, err := tx.Exec(oc.query)
+	if err != nil {
+		return AffectedRows{}, util.NewErrorf("Could not collect %s: %s", oc.kind, err)
+	}
+	count, err := res.RowsAffected()
+	return AffectedRows{Kind: oc.kind, Count: count}, util.NewErrorFrom(err)
+}
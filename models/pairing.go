@@ -0,0 +1,207 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Pairing codes are typed by hand so they skip the characters that look alike
+const pairingCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const PAIRING_CODE_LENGTH = 8
+
+// Pairings have to be finished before this since the code is short
+const PAIRING_TTL = 5 * time.Minute
+
+// Pairing lets a browser extension get a session of a user that is logged in the web app without the password.
+// It's stored in a token whose id is the hash of the code the web app shows. The code is short as it's typed by hand,
+// the lifetime of the pairing and the secret of the extension that claims it make up for it
+type Pairing struct {
+	Code      string `json:"-"`
+	User      string `json:"-"`
+	Name      string `json:"name,omitempty"`
+	PublicKey []byte `json:"public_key,omitempty"`
+	//Secret is given to the extension that claimed the code so only it can finish the pairing
+	Secret string `json:"secret,omitempty"`
+	//Keys are the keys of the user sealed by the web app for the public key of the extension
+	Keys      []byte    `json:"keys,omitempty"`
+	CreatedAt time.Time `json:"-"`
+}
+
+func (p *Pairing) Claimed() bool {
+	return len(p.Secret) > 0
+}
+
+func (p *Pairing) Approved() bool {
+	return len(p.Keys) > 0
+}
+
+func (p *Pairing) ExpiresAt() time.Time {
+	return p.CreatedAt.Add(PAIRING_TTL)
+}
+
+// NormalizePairingCode accepts the codes as people type them, in lower case or with spaces and dashes
+func NormalizePairingCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func generatePairingCode() string {
+	data := make([]byte, PAIRING_CODE_LENGTH)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	for i, b := range data {
+		data[i] = pairingCodeAlphabet[int(b)%len(pairingCodeAlphabet)]
+	}
+	return string(data)
+}
+
+func (p *Pairing) token() (*Token, error) {
+	extra, err := json.Marshal(p)
+	if err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	return &Token{Id: hashTokenSecret(p.Code), Type: TOKEN_PAIRING, User: p.User, Extra: SealedString(extra), CreatedAt: p.CreatedAt}, nil
+}
+
+func pairingFromToken(t *Token, code string) (*Pairing, error) {
+	if t.Type != TOKEN_PAIRING || time.Since(t.CreatedAt) > PAIRING_TTL {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	p := &Pairing{}
+	if err := json.Unmarshal([]byte(t.Extra), p); err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	p.Code, p.User, p.CreatedAt = code, t.User, t.CreatedAt
+	return p, nil
+}
+
+// NewPairing starts a pairing for the user with a new code
+func NewPairing(ctx context.Context, user string) (p *Pairing, err error) {
+	for {
+		p = &Pairing{Code: generatePairingCode(), User: user, CreatedAt: time.Now().UTC()}
+		err = doTx(ctx, func(tx *sql.Tx) error {
+			t, err := p.token()
+			if err != nil {
+				return err
+			}
+			if err := t.validate(); err != nil {
+				return err
+			}
+			t.UpdatedAt = t.CreatedAt
+			_, err = t.dbInsert(tx)
+			if IsDuplicateErr(err) {
+				return util.NewErrorFrom(ErrAlreadyExists)
+			}
+			isErrOrPanic(err)
+			return util.NewErrorFrom(err)
+		})
+		if !util.CheckErr(err, ErrAlreadyExists) {
+			return p, err
+		}
+	}
+}
+
+func FindPairing(ctx context.Context, code string) (p *Pairing, err error) {
+	return p, doTx(ctx, func(tx *sql.Tx) error {
+		p, err = findPairing(tx, code)
+		return err
+	})
+}
+
+func findPairing(tx *sql.Tx, code string) (*Pairing, error) {
+	code = NormalizePairingCode(code)
+	t := &Token{Id: hashTokenSecret(code)}
+	if err := t.find(tx); isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	} else if err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	return pairingFromToken(t, code)
+}
+
+// change runs ftor on the stored pairing with the token locked so concurrent claims and approvals don't overwrite each other
+func (p *Pairing) change(ctx context.Context, ftor func(*sql.Tx, *Pairing) error) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := (&Token{Id: hashTokenSecret(p.Code)}).lock(tx); err != nil {
+			return err
+		}
+		cur, err := findPairing(tx, p.Code)
+		if err != nil {
+			return err
+		}
+		if err := ftor(tx, cur); err != nil {
+			return err
+		}
+		t, err := cur.token()
+		if err != nil {
+			return err
+		}
+		if err := t.update(tx); err != nil {
+			return err
+		}
+		*p = *cur
+		return nil
+	})
+}
+
+// Claim binds the pairing to the extension that has the code. Returns the secret the extension needs to finish it
+func (p *Pairing) Claim(ctx context.Context, name string, publicKey []byte) (string, error) {
+	if len(name) == 0 || len(name) > 100 || len(publicKey) == 0 || len(publicKey) > 1024 {
+		return "", util.NewErrorFrom(ErrInvalidAttributes)
+	}
+	secret := util.GenerateSecretToken()
+	return secret, p.change(ctx, func(tx *sql.Tx, cur *Pairing) error {
+		if cur.Claimed() {
+			return util.NewErrorFrom(ErrAlreadyExists)
+		}
+		if revoked, err := isDeviceKeyRevoked(tx, publicKey); err != nil {
+			return err
+		} else if revoked {
+			return util.NewErrorFrom(ErrRevokedKey)
+		}
+		cur.Name, cur.PublicKey, cur.Secret = name, publicKey, secret
+		return nil
+	})
+}
+
+// Approve stores the keys of the user sealed for the extension that claimed the pairing
+func (p *Pairing) Approve(ctx context.Context, keys []byte) error {
+	if len(keys) == 0 {
+		return util.NewErrorFrom(ErrInvalidKeys)
+	}
+	return p.change(ctx, func(tx *sql.Tx, cur *Pairing) error {
+		if !cur.Claimed() {
+			return util.NewErrorf("The pairing has not been claimed by an extension yet")
+		}
+		if cur.Approved() {
+			return util.NewErrorFrom(ErrAlreadyExists)
+		}
+		//The key may have been revoked after the extension claimed the pairing
+		if revoked, err := isDeviceKeyRevoked(tx, cur.PublicKey); err != nil {
+			return err
+		} else if revoked {
+			return util.NewErrorFrom(ErrRevokedKey)
+		}
+		cur.Keys = keys
+		return nil
+	})
+}
+
+func (p *Pairing) CheckSecret(secret string) bool {
+	return p.Claimed() && subtle.ConstantTimeCompare([]byte(p.Secret), []byte(secret)) == 1
+}
+
+// Delete removes the pairing. It fails if it was already removed so a pairing can only be finished once
+func (p *Pairing) Delete(ctx context.Context) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		return treatUpdateErr((&Token{Id: hashTokenSecret(p.Code)}).dbDelete(tx))
+	})
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestPairing(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	p, err := NewPairing(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Code) != PAIRING_CODE_LENGTH || p.Code != NormalizePairingCode(p.Code) {
+		t.Fatalf("Invalid code %s", p.Code)
+	}
+	if err := p.Approve(ctx, []byte("keys")); err == nil {
+		t.Fatal("Approved a pairing that wasn't claimed")
+	}
+	found, err := FindPairing(ctx, " "+p.Code[:4]+"-"+p.Code[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := found.Claim(ctx, "extension", []byte("public key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Claim(ctx, "other", []byte("public key")); !util.CheckErr(err, ErrAlreadyExists) {
+		t.Fatalf("Expected %s and got %s", ErrAlreadyExists, err)
+	}
+	if err := p.Approve(ctx, []byte("keys")); err != nil {
+		t.Fatal(err)
+	}
+	if !p.CheckSecret(secret) || p.CheckSecret("nope") || string(p.Keys) != "keys" || p.Name != "extension" {
+		t.Fatalf("Unexpected pairing %#v", p)
+	}
+	if err := p.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Delete(ctx); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", ErrDoesntExist, err)
+	}
+}
+
+func TestPairingExpires(t *testing.T) {
+	tok := &Token{Id: "ABCDEFGH", Type: TOKEN_PAIRING, Extra: "{}", CreatedAt: time.Now().Add(-PAIRING_TTL - time.Second)}
+	if _, err := pairingFromToken(tok, "ABCDEFGH"); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", ErrDoesntExist, err)
+	}
+	tok.CreatedAt, tok.Type = time.Now(), TOKEN_VERIFICATION
+	if _, err := pairingFromToken(tok, "ABCDEFGH"); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", ErrDoesntExist, err)
+	}
+}
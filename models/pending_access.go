@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+// PendingAccess is the role or the vaults proposed in the invite of a member that wait for an admin to wrap the vault
+// keys for it
+type PendingAccess struct {
+	Team      string         `scaneo:"pk" json:"-"`
+	User      string         `scaneo:"pk" json:"user"`
+	Role      string         `json:"role"`
+	Vaults    pq.StringArray `json:"vaults"`
+	InvitedBy string         `json:"invited_by"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+func (pa *PendingAccess) insert(tx *sql.Tx) error {
+	_, err := pa.dbInsert(tx)
+	if IsDuplicateErr(err) {
+		return util.NewErrorFrom(ErrAlreadyExists)
+	}
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
+
+// GetPendingAccesses returns the accesses of the team waiting for an admin, oldest first
+func (t *Team) GetPendingAccesses(ctx context.Context) ([]*PendingAccess, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := getReadDB(ctx).QueryContext(ctx, `SELECT `+selectPendingAccessFields+` FROM "pending_access" WHERE "team" = $1 ORDER BY "created_at", "user"`, t.Id)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	pas, err := scanPendingAccesss(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return pas, nil
+}
+
+// lockPendingAccess returns the pending access of the member with its row locked
+func (t *Team) lockPendingAccess(tx *sql.Tx, admin *User, uid string) (*PendingAccess, error) {
+	if err := t.checkAdmin(tx, admin); err != nil {
+		return nil, err
+	}
+	pa := &PendingAccess{}
+	err := pa.dbScanRow(tx.QueryRow(`SELECT `+selectPendingAccessFields+` FROM "pending_access" WHERE "team" = $1 AND "user" = $2 FOR UPDATE`, t.Id, uid))
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	} else if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return pa, nil
+}
+
+// CompletePendingAccess gives the member the role or the vaults of its pending access with the vault keys wrapped by
+// the admin. Proposed admins need a key for every vault they are not in, like a promotion. Vaults removed since the
+// invite are skipped and the ones that require approval get a grant request instead. Returns the vaults the member
+// was added to and the ones with a grant request
+func (t *Team) CompletePendingAccess(ctx context.Context, admin *User, uid string, vkp VaultKeyPair) (added, requested []string, err error) {
+	return added, requested, doConflictTx(ctx, func(tx *sql.Tx) error {
+		pa, err := t.lockPendingAccess(tx, admin, uid)
+		if err != nil {
+			return err
+		}
+		missing, err := t.getVaultsMissingForUser(tx, &User{Id: uid})
+		if err != nil {
+			return err
+		}
+		if pa.Role != TEAM_ROLE_ADMIN {
+			proposed := map[string]bool{}
+			for _, vid := range pa.Vaults {
+				proposed[vid] = true
+			}
+			vaults := []*Vault{}
+			for _, v := range missing {
+				if proposed[v.Id] {
+					vaults = append(vaults, v)
+				}
+			}
+			missing = vaults
+		}
+		tu, err := t.getUserAffiliation(tx, uid)
+		if err != nil {
+			return err
+		}
+		if pa.Role == TEAM_ROLE_ADMIN && tu.Auditor {
+			return util.NewErrorf("Auditors can't be admins")
+		}
+		if added, requested, err = giveVaults(tx, admin.Id, uid, missing, vkp); err != nil {
+			return err
+		}
+		if pa.Role == TEAM_ROLE_ADMIN {
+			tu.Admin = true
+			if err := tu.update(tx); err != nil {
+				return err
+			}
+		}
+		return treatUpdateErr(pa.dbDelete(tx))
+	})
+}
+
+// DeletePendingAccess drops the pending access of the member. The member stays in the team
+func (t *Team) DeletePendingAccess(ctx context.Context, admin *User, uid string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		pa, err := t.lockPendingAccess(tx, admin, uid)
+		if err != nil {
+			return err
+		}
+		return treatUpdateErr(pa.dbDelete(tx))
+	})
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestInviteAssignment(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := getFirstVault(owner, team)
+	for _, ia := range []InviteAssignment{
+		{Role: "boss"},
+		{Role: TEAM_ROLE_AUDITOR, Vaults: []string{vm.v.Id}},
+		{Role: TEAM_ROLE_ADMIN, Vaults: []string{vm.v.Id}},
+		{Vaults: []string{vm.v.Id, vm.v.Id}},
+		{Vaults: []string{"nope"}},
+		{Message: strings.Repeat("a", INVITE_MESSAGE_MAX_LENGTH+1)},
+	} {
+		if _, err := team.AddOrInviteUserWithAssignment(ctx, owner, util.GenerateRandomToken(8)+"@nowhere.net", ia); !util.CheckErr(err, ErrInvalidAttributes) {
+			t.Errorf("Expected %#v to be rejected and got %v", ia, err)
+		}
+	}
+	uid := "u_" + util.GenerateRandomToken(10)
+	email := uid + "@nowhere.net"
+	i, err := team.AddOrInviteUserWithAssignment(ctx, owner, email, InviteAssignment{Message: " Welcome aboard ", Vaults: []string{vm.v.Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i == nil || i.Message != "Welcome aboard" || i.Role != TEAM_ROLE_MEMBER || i.InvitedBy != owner.Id {
+		t.Fatalf("Unexpected invite %#v", i)
+	}
+	_, priv, fullpack := generateNewKeys()
+	invitee, _, err := NewUser(ctx, uid, "uid fullname", email, uid, fullpack, getDummyVaultKeyPair(priv, uid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pas, err := team.GetPendingAccesses(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pas) != 1 || pas[0].User != invitee.Id || len(pas[0].Vaults) != 1 || pas[0].Vaults[0] != vm.v.Id || pas[0].InvitedBy != owner.Id {
+		t.Fatalf("Unexpected pending accesses %#v", pas)
+	}
+	keys := VaultKeyPair{Keys: map[string][]byte{vm.v.Id: sealVaultKey(vm.v, vm.priv)}}
+	if _, _, err := team.CompletePendingAccess(ctx, invitee, invitee.Id, keys); !util.CheckErr(err, ErrUnauthorized) {
+		t.Errorf("Expected a member that isn't an admin to be rejected and got %v", err)
+	}
+	if _, _, err := team.CompletePendingAccess(ctx, owner, invitee.Id, VaultKeyPair{}); err == nil {
+		t.Errorf("Completed a pending access without the vault keys")
+	}
+	added, requested, err := team.CompletePendingAccess(ctx, owner, invitee.Id, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0] != vm.v.Id || len(requested) != 0 {
+		t.Errorf("Expected the invitee to be added to %s and got %v %v", vm.v.Id, added, requested)
+	}
+	if _, err := team.GetVaultForUser(ctx, vm.v.Id, invitee); err != nil {
+		t.Errorf("The invitee has no access to the vault: %v", err)
+	}
+	if _, _, err := team.CompletePendingAccess(ctx, owner, invitee.Id, keys); !util.CheckErr(err, ErrDoesntExist) {
+		t.Errorf("Expected the pending access to be gone and got %v", err)
+	}
+}
+
+func TestInviteAssignmentForExistingUsers(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := getFirstVault(owner, team)
+	auditor, admin := getDummyUser(), getDummyUser()
+	if _, err := team.AddOrInviteUserWithAssignment(ctx, owner, auditor.Email, InviteAssignment{Role: TEAM_ROLE_AUDITOR}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := team.CheckAuditor(ctx, auditor); err != nil || !ok {
+		t.Errorf("Expected the auditor role to be applied right away (%v)", err)
+	}
+	if _, err := team.AddOrInviteUserWithAssignment(ctx, owner, admin.Email, InviteAssignment{Role: TEAM_ROLE_ADMIN}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := team.CheckAdmin(ctx, admin); err != nil || ok {
+		t.Fatalf("The admin role can't be applied before the vault keys are wrapped (%v)", err)
+	}
+	pas, err := team.GetPendingAccesses(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pas) != 1 || pas[0].User != admin.Id || pas[0].Role != TEAM_ROLE_ADMIN {
+		t.Fatalf("Unexpected pending accesses %#v", pas)
+	}
+	if _, _, err := team.CompletePendingAccess(ctx, owner, admin.Id, VaultKeyPair{Keys: map[string][]byte{vm.v.Id: sealVaultKey(vm.v, vm.priv)}}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := team.CheckAdmin(ctx, admin); err != nil || !ok {
+		t.Errorf("Expected the pending admin to be promoted (%v)", err)
+	}
+	if _, err := team.AddOrInviteUserWithAssignment(ctx, owner, getDummyUser().Email, InviteAssignment{Vaults: []string{vm.v.Id}}); err != nil {
+		t.Fatal(err)
+	}
+	pas, err = team.GetPendingAccesses(ctx)
+	if err != nil || len(pas) != 1 {
+		t.Fatalf("Expected one pending access and got %#v (%v)", pas, err)
+	}
+	if err := team.DeletePendingAccess(ctx, owner, pas[0].User); err != nil {
+		t.Fatal(err)
+	}
+	if err := team.DeletePendingAccess(ctx, owner, pas[0].User); !util.CheckErr(err, ErrDoesntExist) {
+		t.Errorf("Expected the pending access to be gone and got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"regexp"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+var rejectPlaintext int32
+
+// SetRejectPlaintext turns on the check that refuses secrets whose payload doesn't look encrypted
+func SetRejectPlaintext(reject bool) {
+	var val int32
+	if reject {
+		val = 1
+	}
+	atomic.StoreInt32(&rejectPlaintext, val)
+}
+
+// reSecretField matches the field names of the secrets clients store before encrypting them
+var reSecretField = regexp.MustCompile(`(?i)pass|secret|token|key|user|login|otp|note|url`)
+
+const (
+	//plaintextMinEntropySize is the smallest payload checked for entropy. Shorter ones can't tell text from random bytes
+	plaintextMinEntropySize = 64
+	//plaintextEntropyRatio is the fraction of the maximum entropy for its size a payload needs to look encrypted
+	plaintextEntropyRatio = 0.7
+)
+
+// shannonEntropy returns the bits per byte of data
+func shannonEntropy(data []byte) float64 {
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	entropy := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(len(data))
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// looksLikePlaintext guesses whether a client uploaded the payload of a secret without encrypting it. Encrypted payloads
+// are never JSON objects and their bytes are close to uniformly distributed
+func looksLikePlaintext(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' && utf8.Valid(trimmed) {
+		fields := map[string]json.RawMessage{}
+		if json.Unmarshal(trimmed, &fields) == nil {
+			for name := range fields {
+				if reSecretField.MatchString(name) {
+					return true
+				}
+			}
+		}
+	}
+	if len(data) < plaintextMinEntropySize {
+		return false
+	}
+	//A sample can't have more bits per byte than log2 of its size
+	max := math.Min(8, math.Log2(float64(len(data))))
+	return shannonEntropy(data) < max*plaintextEntropyRatio
+}
+
+// checkSecretData verifies the signature of the payload of a secret and, if enabled, that it doesn't look unencrypted
+func checkSecretData(pubPack, signedData []byte) error {
+	msg, err := verifyAndUnpack(pubPack, signedData)
+	if err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&rejectPlaintext) == 1 && looksLikePlaintext(msg) {
+		return util.NewErrorFrom(ErrPlaintext)
+	}
+	return nil
+}
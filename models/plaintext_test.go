@@ -0,0 +1,31 @@
+package models
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestLooksLikePlaintext(t *testing.T) {
+	random := make([]byte, 1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name      string
+		data      []byte
+		plaintext bool
+	}{
+		{"random", random, false},
+		{"short random", random[:64], false},
+		{"short text", []byte("hello"), false},
+		{"json", []byte(`{"username":"me","password":"hunter2"}`), true},
+		{"json without secret fields", []byte(`{"a":1}`), false},
+		{"text", []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 10)), true},
+	}
+	for _, c := range cases {
+		if got := looksLikePlaintext(c.data); got != c.plaintext {
+			t.Errorf("Expected %s to look like plaintext %t and got %t", c.name, c.plaintext, got)
+		}
+	}
+}
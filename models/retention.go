@@ -0,0 +1,52 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// RetentionRun is the last time the retention policy purged a class of data
+type RetentionRun struct {
+	Class       string    `scaneo:"pk" json:"class"`
+	Days        int       `json:"days"`
+	Cutoff      time.Time `json:"cutoff"`
+	Purged      int64     `json:"purged"`
+	TotalPurged int64     `json:"total_purged"`
+	RanAt       time.Time `json:"ran_at"`
+}
+
+// RecordRetentionRun stores the run as the last one of its class and adds what it purged to the total
+func RecordRetentionRun(ctx context.Context, rr *RetentionRun) error {
+	if len(rr.Class) == 0 {
+		return util.NewErrorFrom(ErrInvalidAttributes)
+	}
+	rr.RanAt = time.Now().UTC()
+	return doTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRow(`INSERT INTO "retention_run" (`+selectRetentionRunFields+`) VALUES ($1, $2, $3, $4, $4, $5)
+			ON CONFLICT ("class") DO UPDATE SET "days" = EXCLUDED."days", "cutoff" = EXCLUDED."cutoff", "purged" = EXCLUDED."purged",
+			"total_purged" = "retention_run"."total_purged" + EXCLUDED."purged", "ran_at" = EXCLUDED."ran_at" RETURNING "total_purged"`,
+			rr.Class, rr.Days, rr.Cutoff, rr.Purged, rr.RanAt).Scan(&rr.TotalPurged)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		return nil
+	})
+}
+
+// GetRetentionRuns returns the last run of every class that has been purged sorted by class
+func GetRetentionRuns(ctx context.Context) ([]*RetentionRun, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := getReadDB(ctx).QueryContext(ctx, `SELECT `+selectRetentionRunFields+` FROM "retention_run" ORDER BY "class"`)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	rrs, err := scanRetentionRuns(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return rrs, nil
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetentionPurges(t *testing.T) {
+	ctx := getCtx()
+	for _, action := range []string{"test.retention", "test.retention", "test.retention_kept"} {
+		if err := RecordAuditEntry(ctx, &AuditEntry{Actor: "retention", Action: action}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := time.Now().UTC().Add(time.Minute)
+	if ar, err := PurgeAuditEntriesWithActions(ctx, []string{"test.retention"}, before, true); err != nil || ar.Count != 2 {
+		t.Fatalf("Expected a dry run to count 2 entries and got %#v (%v)", ar, err)
+	}
+	if ar, err := PurgeAuditEntriesWithActions(ctx, []string{"test.retention"}, before, false); err != nil || ar.Count != 2 {
+		t.Fatalf("Expected 2 entries to be purged and got %#v (%v)", ar, err)
+	}
+	av, err := VerifyAuditChain(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !av.Valid() || av.Purged < 2 {
+		t.Errorf("The chain has to be verifiable after the purge %#v", av)
+	}
+	owner, team := getDummyOwnerWithTeam()
+	vm := getFirstVault(owner, team)
+	s := &Secret{Data: signAndPack(vm.priv, a32b)}
+	if err := vm.v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	first := s.Version
+	s.Data = signAndPack(vm.priv, a32b)
+	if err := vm.v.UpdateSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := doTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE "secret" SET "created_at" = $1 WHERE "team" = $2 AND "vault" = $3 AND "id" = $4`, time.Now().AddDate(0, 0, -2), team.Id, vm.v.Id, s.Id)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ar, err := PurgeSecretVersions(ctx, time.Now().AddDate(0, 0, -1), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.Count != 1 || ar.Ids[0] != fmt.Sprintf("%s/%s/%s/%d", team.Id, vm.v.Id, s.Id, first) {
+		t.Errorf("Expected only the replaced version to be purged and got %#v", ar)
+	}
+	if _, err := vm.v.GetSecretVersion(ctx, s.Id, s.Version); err != nil {
+		t.Errorf("The latest version has been purged: %s", err)
+	}
+	rr := &RetentionRun{Class: "test_retention", Days: 1, Cutoff: before, Purged: 2}
+	for i := 0; i < 2; i++ {
+		if err := RecordRetentionRun(ctx, rr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rrs, err := GetRetentionRuns(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, got := range rrs {
+		found = found || (got.Class == rr.Class && got.Purged == 2 && got.TotalPurged == 4)
+	}
+	if !found || rr.TotalPurged != 4 {
+		t.Errorf("Expected the runs to add up in %#v", rrs)
+	}
+}
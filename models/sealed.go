@@ -0,0 +1,313 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// KeyWrapper wraps and unwraps the data keys with a key that never leaves the kms
+type KeyWrapper interface {
+	Name() string
+	Wrap(ctx context.Context, plain []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// Values sealed with a data key start with the magic and the id of the key. Values without it were stored before
+// sealing was enabled and are read as they are
+var sealMagic = []byte("\x00kcs1")
+
+// Values sealed with sealRowMagic have their row as the associated data. The ones with sealMagic were sealed without it
+// and are still opened until they are sealed again
+var sealRowMagic = []byte("\x00kcs2")
+
+// Text columns keep the sealed value in base64 after this prefix
+const sealTextPrefix = "kcs1:"
+
+// keyRing has the unwrapped data keys. Keys sealed by other instances after this one started are loaded when found
+type keyRing struct {
+	lock    *sync.RWMutex
+	db      *sql.DB
+	kms     KeyWrapper
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+var sealing *keyRing
+
+// EnableSealing loads the data keys from the db and unwraps them with the kms. A data key is created if there are none.
+// Every sealed column is encrypted with the newest data key from then on
+func EnableSealing(ctx context.Context, kms KeyWrapper) error {
+	kr := &keyRing{lock: &sync.RWMutex{}, db: GetDB(ctx), kms: kms, aeads: map[string]cipher.AEAD{}}
+	dks, err := findDataKeys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, dk := range dks {
+		if err := kr.add(ctx, dk); err != nil {
+			return err
+		}
+		kr.current = dk.Id
+	}
+	sealing = kr
+	if len(dks) == 0 {
+		_, err = NewDataKey(ctx)
+	}
+	return err
+}
+
+func (kr *keyRing) add(ctx context.Context, dk *DataKey) error {
+	plain, err := kr.kms.Unwrap(ctx, dk.Wrapped)
+	if err != nil {
+		return util.NewErrorf("Could not unwrap data key %s with the %s kms: %s", dk.Id, kr.kms.Name(), err)
+	}
+	block, err := aes.NewCipher(plain)
+	if err != nil {
+		return util.NewErrorFrom(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return util.NewErrorFrom(err)
+	}
+	kr.lock.Lock()
+	kr.aeads[dk.Id] = aead
+	kr.lock.Unlock()
+	return nil
+}
+
+func (kr *keyRing) get(id string) (cipher.AEAD, error) {
+	kr.lock.RLock()
+	aead, ok := kr.aeads[id]
+	kr.lock.RUnlock()
+	if ok {
+		return aead, nil
+	}
+	ctx, cancel := context.WithTimeout(AddDBToContext(context.Background(), kr.db), 30*time.Second)
+	defer cancel()
+	dk := &DataKey{Id: id}
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		return dk.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorf("Unknown data key %s", id)
+	} else if err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	if err := kr.add(ctx, dk); err != nil {
+		return nil, err
+	}
+	return kr.get(id)
+}
+
+func (kr *keyRing) seal(plain, ad []byte) ([]byte, error) {
+	kr.lock.RLock()
+	id := kr.current
+	kr.lock.RUnlock()
+	aead, err := kr.get(id)
+	if err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, sealRowMagic...), byte(len(id)))
+	out = append(out, id...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plain, ad), nil
+}
+
+// sealedKeyId returns the id of the data key of a sealed value, whether it's bound to its row and false if the value
+// isn't sealed
+func sealedKeyId(data []byte) (string, []byte, bool, bool) {
+	bound := bytes.HasPrefix(data, sealRowMagic)
+	if !bound && !bytes.HasPrefix(data, sealMagic) || len(data) < len(sealMagic)+1 {
+		return "", nil, false, false
+	}
+	rest := data[len(sealMagic):]
+	l := int(rest[0])
+	if len(rest) < l+1 {
+		return "", nil, false, false
+	}
+	return string(rest[1 : l+1]), rest[l+1:], bound, true
+}
+
+func openSealed(data, ad []byte) ([]byte, error) {
+	id, rest, bound, ok := sealedKeyId(data)
+	if !ok {
+		return append([]byte{}, data...), nil
+	}
+	if sealing == nil {
+		return nil, util.NewErrorf("The value is sealed with data key %s but there is no kms configured", id)
+	}
+	aead, err := sealing.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, util.NewErrorf("Sealed value too short")
+	}
+	if !bound {
+		ad = nil
+	}
+	plain, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], ad)
+	if err != nil {
+		return nil, util.NewErrorf("Could not open the value sealed with data key %s: %s", id, err)
+	}
+	return plain, nil
+}
+
+// sealedRow is the associated data that binds a sealed value to its table, column and the primary key of its row, so a
+// sealed value copied into another row or column doesn't open
+func sealedRow(table, column string, pk ...string) []byte {
+	return []byte(strings.Join(append([]string{table, column}, pk...), "\x00"))
+}
+
+// sealedValue is a sealed column. Since the associated data needs the row, the row seals the value before writing it
+// and opens it after reading it. Scan and Value only move the sealed value to and from the db
+type sealedValue interface {
+	seal(ad []byte) (restore func(), err error)
+	open(ad []byte) error
+}
+
+// writeSealed runs write with the value sealed for its row and puts the plain value back afterwards
+func writeSealed(v sealedValue, ad []byte, write func() error) error {
+	restore, err := v.seal(ad)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	return write()
+}
+
+var errUnboundSeal = errors.New("Sealed values have to be sealed for their row before storing them")
+
+// Sealed is a binary column encrypted with the current data key when sealing is enabled
+type Sealed []byte
+
+func (s Sealed) Value() (driver.Value, error) {
+	if sealing == nil || s == nil {
+		return []byte(s), nil
+	}
+	if _, _, bound, ok := sealedKeyId(s); !ok || !bound {
+		return nil, util.NewErrorFrom(errUnboundSeal)
+	}
+	return []byte(s), nil
+}
+
+// Scan keeps the value as it's stored. The row opens it afterwards
+func (s *Sealed) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case []byte:
+		*s = append(Sealed{}, v...)
+		return nil
+	}
+	return util.NewErrorf("Can't scan %T into a sealed value", src)
+}
+
+func (s *Sealed) seal(ad []byte) (func(), error) {
+	plain := *s
+	if sealing == nil || plain == nil {
+		return func() {}, nil
+	}
+	data, err := sealing.seal(plain, ad)
+	if err != nil {
+		return nil, err
+	}
+	*s = data
+	return func() { *s = plain }, nil
+}
+
+func (s *Sealed) open(ad []byte) error {
+	if *s == nil {
+		return nil
+	}
+	plain, err := openSealed(*s, ad)
+	if err != nil {
+		return err
+	}
+	*s = plain
+	return nil
+}
+
+// SealedString is a text column encrypted with the current data key when sealing is enabled
+type SealedString string
+
+func (s SealedString) Value() (driver.Value, error) {
+	if sealing == nil || len(s) == 0 {
+		return string(s), nil
+	}
+	if data, ok := s.sealedData(); !ok {
+		return nil, util.NewErrorFrom(errUnboundSeal)
+	} else if _, _, bound, ok := sealedKeyId(data); !ok || !bound {
+		return nil, util.NewErrorFrom(errUnboundSeal)
+	}
+	return string(s), nil
+}
+
+// Scan keeps the value as it's stored. The row opens it afterwards
+func (s *SealedString) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s = ""
+	case []byte:
+		*s = SealedString(v)
+	case string:
+		*s = SealedString(v)
+	default:
+		return util.NewErrorf("Can't scan %T into a sealed value", src)
+	}
+	return nil
+}
+
+// sealedData returns the sealed value from the base64 text or false if the text isn't sealed
+func (s SealedString) sealedData() ([]byte, bool) {
+	if !strings.HasPrefix(string(s), sealTextPrefix) {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(string(s[len(sealTextPrefix):]))
+	return data, err == nil
+}
+
+func (s *SealedString) seal(ad []byte) (func(), error) {
+	plain := *s
+	if sealing == nil || len(plain) == 0 {
+		return func() {}, nil
+	}
+	data, err := sealing.seal([]byte(plain), ad)
+	if err != nil {
+		return nil, err
+	}
+	*s = SealedString(sealTextPrefix + base64.StdEncoding.EncodeToString(data))
+	return func() { *s = plain }, nil
+}
+
+func (s *SealedString) open(ad []byte) error {
+	if !strings.HasPrefix(string(*s), sealTextPrefix) {
+		return nil
+	}
+	data, ok := s.sealedData()
+	if !ok {
+		return util.NewErrorf("Invalid sealed text")
+	}
+	plain, err := openSealed(data, ad)
+	if err != nil {
+		return err
+	}
+	*s = SealedString(plain)
+	return nil
+}
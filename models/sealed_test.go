@@ -0,0 +1,108 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"testing"
+)
+
+// xorWrapper is a kms that is good enough for the tests
+type xorWrapper struct{}
+
+func (xorWrapper) Name() string { return "test" }
+
+func (xorWrapper) Wrap(ctx context.Context, plain []byte) ([]byte, error) {
+	out := make([]byte, len(plain))
+	for i, b := range plain {
+		out[i] = b ^ 0x5a
+	}
+	return out, nil
+}
+
+func (x xorWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return x.Wrap(ctx, wrapped)
+}
+
+func TestSealedColumns(t *testing.T) {
+	ctx := getCtx()
+	legacy, err := Sealed("plain").Value()
+	if err != nil || !bytes.Equal(legacy.([]byte), []byte("plain")) {
+		t.Fatalf("Values have to pass through without sealing: %v %s", legacy, err)
+	}
+	if err := EnableSealing(ctx, xorWrapper{}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		sealing = nil
+		doTx(ctx, func(tx *sql.Tx) error {
+			_, err := tx.Exec(`DELETE FROM "data_key"`)
+			return err
+		})
+	}()
+	u := getDummyUser()
+	defer u.Delete(ctx)
+	var raw []byte
+	err = doTx(ctx, func(tx *sql.Tx) error {
+		return tx.QueryRow(`SELECT "key" FROM "user" WHERE "id" = $1`, u.Id).Scan(&raw)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, bound, ok := sealedKeyId(raw); !ok || !bound {
+		t.Fatalf("The key of the user is not sealed for its row in the db")
+	}
+	//A sealed value copied into another row doesn't open
+	moved := Sealed(raw)
+	if err := moved.open(sealedRow("user", "key", "someone else")); err == nil {
+		t.Errorf("Expected an error opening a sealed value in another row")
+	}
+	found, err := FindUser(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(found.Key, u.Key) {
+		t.Errorf("The sealed key doesn't match after reading it")
+	}
+	//Values stored before sealing was enabled are still read
+	s := Sealed{}
+	if err := s.Scan([]byte("plain")); err != nil || string(s) != "plain" {
+		t.Errorf("Unsealed values have to be read as they are: %s %s", s, err)
+	}
+	if _, err := SealedString("extra").Value(); err == nil {
+		t.Errorf("Expected an error storing a value that isn't sealed for its row")
+	}
+	ad := sealedRow("token", "extra", "id")
+	ss := SealedString("extra")
+	if _, err := ss.seal(ad); err != nil {
+		t.Fatal(err)
+	}
+	v, err := ss.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Scan(v); err != nil {
+		t.Fatal(err)
+	}
+	if other := ss; other.open(sealedRow("token", "extra", "other")) == nil {
+		t.Errorf("Expected an error opening the sealed string in another row")
+	}
+	if err := ss.open(ad); err != nil || ss != "extra" {
+		t.Errorf("Mismatch in the sealed string: %s %s", ss, err)
+	}
+	//A new data key is used for new values and the previous ones are still opened
+	old := sealing.current
+	if _, err := NewDataKey(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if sealing.current == old {
+		t.Fatalf("The new data key is not the current one")
+	}
+	if found, err = FindUser(ctx, u.Id); err != nil || !bytes.Equal(found.Key, u.Key) {
+		t.Errorf("Could not read the value sealed with the previous data key: %s", err)
+	}
+	sealing = nil
+	if _, err := FindUser(ctx, u.Id); err == nil {
+		t.Errorf("Expected an error reading a sealed value without the kms")
+	}
+}
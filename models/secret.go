@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
 )
 
 type Secret struct {
@@ -16,6 +17,8 @@ type Secret struct {
 	Data         []byte    `json:"data"`
 	VaultVersion uint32    `json:"vault_version"`
 	CreatedAt    time.Time `json:"created_at"`
+	//ArchivedAt is set for the secrets of systems that are gone. They are kept but left out of the default listings
+	ArchivedAt pq.NullTime `json:"archived_at,omitempty"`
 }
 
 func (v *Secret) insert(tx *sql.Tx) error {
@@ -50,13 +53,64 @@ func (v *Secret) update(tx *sql.Tx) error {
 	return nil
 }
 
+// forEachSecret scans the rows one at a time and stops at the first error returned by fn
+func forEachSecret(rows *sql.Rows, fn func(*Secret) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		s := &Secret{}
+		err := rows.Scan(&s.Team, &s.Vault, &s.Id, &s.Version, &s.Data, &s.VaultVersion, &s.CreatedAt, &s.ArchivedAt)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
 func MoveSecretToVault(ctx context.Context, s *Secret, source, target *Vault) error {
 	return doTx(ctx, func(tx *sql.Tx) error {
+		prev, err := source.getSecret(tx, s.Id)
+		if err != nil {
+			return err
+		}
+		lids, err := source.getSecretLabelIds(tx, s.Id)
+		if err != nil {
+			return err
+		}
 		if err := source.deleteSecret(tx, s.Id); err != nil {
 			return err
 		}
 		s.Id = ""
-		return target.addSecret(tx, s)
+		s.ArchivedAt = prev.ArchivedAt
+		if err := target.addSecret(tx, s); err != nil {
+			return err
+		}
+		//Labels are per team so they are dropped when the secret goes to another team
+		if source.Team != target.Team {
+			return nil
+		}
+		return target.insertSecretLabels(tx, s.Id, lids)
+	})
+}
+
+// ArchiveSecret archives or restores a secret and returns its last version. Archiving an archived secret keeps the time
+// it was archived at
+func (v *Vault) ArchiveSecret(ctx context.Context, sid string, archived bool) (s *Secret, err error) {
+	return s, doTx(ctx, func(tx *sql.Tx) error {
+		if err := v.update(tx); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`UPDATE "secret" SET "archived_at" = CASE WHEN $1::BOOLEAN THEN COALESCE("archived_at", $2) END WHERE "team" = $3 AND "vault" = $4 AND "id" = $5`, archived, time.Now().UTC(), v.Team, v.Id, sid)
+		if err := treatUpdateErr(res, err); err != nil {
+			return err
+		}
+		s, err = v.getSecret(tx, sid)
+		return err
 	})
 }
 
@@ -82,3 +136,14 @@ func (v Secret) validate(fistInsert bool) error {
 	}
 	return errs.SetErrorOrCamo(ErrInvalidAttributes)
 }
+
+// PurgeSecretVersions removes the versions replaced by a newer one before the given time. The latest version of
+// every secret is always kept. With dryRun nothing is removed
+func PurgeSecretVersions(ctx context.Context, before time.Time, dryRun bool) (purged AffectedRows, err error) {
+	return purged, doDryRunTx(ctx, dryRun, func(tx *sql.Tx) error {
+		purged, err = queryAffected(tx, "secret_version", `DELETE FROM "secret" s WHERE EXISTS (
+				SELECT 1 FROM "secret" n WHERE n."team" = s."team" AND n."vault" = s."vault" AND n."id" = s."id" AND n."version" > s."version" AND n."created_at" < $1
+			) RETURNING s."team" || '/' || s."vault" || '/' || s."id" || '/' || s."version"::TEXT`, before)
+		return err
+	})
+}
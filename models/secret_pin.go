@@ -0,0 +1,105 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const VAULT_PINS_MAX = 10
+
+// SecretPin is a secret the clients list first in its vault. Pins are listed by their position
+type SecretPin struct {
+	Team     string    `scaneo:"pk" json:"-"`
+	Vault    string    `scaneo:"pk" json:"vault"`
+	Secret   string    `scaneo:"pk" json:"secret"`
+	Position int       `json:"position"`
+	PinnedBy string    `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// GetPins returns the pinned secrets of the vault in the order they have to be listed
+func (v Vault) GetPins(ctx context.Context) ([]*SecretPin, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := getReadDB(ctx).QueryContext(ctx, `SELECT `+selectSecretPinFields+` FROM "secret_pin" WHERE "team" = $1 AND "vault" = $2 ORDER BY "position"`, v.Team, v.Id)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	sps, err := scanSecretPins(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return sps, nil
+}
+
+// SetPins replaces the pinned secrets of the vault with sids in that order. Secrets that were already pinned keep who
+// pinned them and when
+func (v Vault) SetPins(ctx context.Context, admin string, sids []string) (sps []*SecretPin, err error) {
+	if len(sids) > VAULT_PINS_MAX {
+		return nil, util.NewErrorf("Vaults can't have more than %d pinned secrets", VAULT_PINS_MAX)
+	}
+	seen := map[string]bool{}
+	for _, sid := range sids {
+		if seen[sid] {
+			errs := util.NewErrorFields().(*util.Error)
+			errs.SetFieldError("secrets", "duplicate")
+			return nil, errs.SetErrorOrCamo(ErrInvalidAttributes)
+		}
+		seen[sid] = true
+	}
+	return sps, doTx(ctx, func(tx *sql.Tx) error {
+		//The vault row is locked so concurrent changes of the pins don't interleave
+		if _, err := tx.Exec(`SELECT 1 FROM "vault" WHERE "team" = $1 AND "id" = $2 FOR UPDATE`, v.Team, v.Id); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		var found int
+		err := tx.QueryRow(`SELECT COUNT(DISTINCT "id") FROM "secret" WHERE "team" = $1 AND "vault" = $2 AND "id" = ANY($3)`, v.Team, v.Id, pq.Array(sids)).Scan(&found)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if found != len(sids) {
+			errs := util.NewErrorFields().(*util.Error)
+			errs.SetFieldError("secrets", "invalid")
+			return errs.SetErrorOrCamo(ErrInvalidAttributes)
+		}
+		rows, err := tx.Query(`SELECT `+selectSecretPinFields+` FROM "secret_pin" WHERE "team" = $1 AND "vault" = $2`, v.Team, v.Id)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		prev, err := scanSecretPins(rows)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		pinned := map[string]*SecretPin{}
+		for _, sp := range prev {
+			pinned[sp.Secret] = sp
+		}
+		if _, err := tx.Exec(`DELETE FROM "secret_pin" WHERE "team" = $1 AND "vault" = $2`, v.Team, v.Id); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		now := time.Now().UTC()
+		sps = make([]*SecretPin, 0, len(sids))
+		for i, sid := range sids {
+			sp := &SecretPin{Team: v.Team, Vault: v.Id, Secret: sid, Position: i, PinnedBy: admin, PinnedAt: now}
+			if old, ok := pinned[sid]; ok {
+				sp.PinnedBy, sp.PinnedAt = old.PinnedBy, old.PinnedAt
+			}
+			if _, err := sp.dbInsert(tx); isErrOrPanic(err) {
+				return util.NewErrorFrom(err)
+			}
+			sps = append(sps, sp)
+		}
+		return nil
+	})
+}
+
+// deleteSecretPin unpins a secret that is deleted or moved. The rest of the pins keep their order
+func (v Vault) deleteSecretPin(tx *sql.Tx, sid string) error {
+	_, err := tx.Exec(`DELETE FROM "secret_pin" WHERE "team" = $1 AND "vault" = $2 AND "secret" = $3`, v.Team, v.Id, sid)
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
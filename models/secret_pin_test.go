@@ -0,0 +1,52 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestSecretPins(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := getFirstVault(owner, team)
+	sids := make([]string, 3)
+	for i := range sids {
+		s := &Secret{Data: signAndPack(vm.priv, a32b)}
+		if err := vm.v.AddSecret(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+		sids[i] = s.Id
+	}
+	if _, err := vm.v.SetPins(ctx, owner.Id, []string{sids[0], sids[0]}); !util.CheckFieldErr(err, "secrets", "duplicate") {
+		t.Fatalf("Expected a duplicated pin and got %v", err)
+	}
+	if _, err := vm.v.SetPins(ctx, owner.Id, []string{sids[0], "nope"}); !util.CheckFieldErr(err, "secrets", "invalid") {
+		t.Fatalf("Expected an unknown secret and got %v", err)
+	}
+	tooMany := make([]string, VAULT_PINS_MAX+1)
+	if _, err := vm.v.SetPins(ctx, owner.Id, tooMany); err == nil {
+		t.Fatalf("Expected to refuse more than %d pins", VAULT_PINS_MAX)
+	}
+	first, err := vm.v.SetPins(ctx, owner.Id, []string{sids[2], sids[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := getDummyUser()
+	if _, err := vm.v.SetPins(ctx, other.Id, []string{sids[0], sids[1], sids[2]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.DeleteSecret(ctx, sids[1]); err != nil {
+		t.Fatal(err)
+	}
+	sps, err := vm.v.GetPins(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sps) != 2 || sps[0].Secret != sids[0] || sps[1].Secret != sids[2] {
+		t.Fatalf("Expected the pins in order without the deleted secret and got %#v", sps)
+	}
+	if sps[0].PinnedBy != owner.Id || !sps[0].PinnedAt.Equal(first[1].PinnedAt) {
+		t.Errorf("Secrets that stay pinned have to keep who pinned them %#v", sps[0])
+	}
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"testing"
+
+	"github.com/keydotcat/keycatd/util"
 )
 
 func TestGetAllSecretsForOwnerAndUser(t *testing.T) {
@@ -70,6 +72,16 @@ func TestUpdateSecret(t *testing.T) {
 	if s.Version != 3 {
 		t.Errorf("Unexpected secret version 2 vs %d", s.Version)
 	}
+	old, err := vm.v.GetSecretVersion(ctx, s.Id, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Version != 2 {
+		t.Errorf("Expected version 2 and got %d", old.Version)
+	}
+	if _, err := vm.v.GetSecretVersion(ctx, s.Id, 4); !util.CheckErr(err, ErrDoesntExist) {
+		t.Errorf("Expected a missing version to not exist and got %v", err)
+	}
 }
 
 func TestCheckRetrieveLastVersionOfSecret(t *testing.T) {
@@ -228,3 +240,55 @@ func TestMoveSecretToTeamVault(t *testing.T) {
 		}
 	}
 }
+
+func TestArchiveSecret(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vms := []vaultMock{getFirstVault(owner, team), createVaultMock(owner, team)}
+	s := &Secret{Data: signAndPack(vms[0].priv, a32b)}
+	if err := vms[0].v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	as, err := vms[0].v.ArchiveSecret(ctx, s.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !as.ArchivedAt.Valid {
+		t.Fatal("The secret has not been archived")
+	}
+	again, err := vms[0].v.ArchiveSecret(ctx, s.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.ArchivedAt.Time.Equal(as.ArchivedAt.Time) {
+		t.Errorf("Archiving twice changed the archive time")
+	}
+	s.Data = signAndPack(vms[0].priv, a32b)
+	if err := vms[0].v.UpdateSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if !s.ArchivedAt.Valid {
+		t.Errorf("New versions of an archived secret should stay archived")
+	}
+	s.Data = signAndPack(vms[1].priv, a32b)
+	if err := MoveSecretToVault(ctx, s, vms[0].v, vms[1].v); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := vms[1].v.GetSecret(ctx, s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !moved.ArchivedAt.Valid {
+		t.Errorf("Moved secrets should stay archived")
+	}
+	restored, err := vms[1].v.ArchiveSecret(ctx, s.Id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ArchivedAt.Valid {
+		t.Errorf("The secret has not been restored")
+	}
+	if _, err := vms[1].v.ArchiveSecret(ctx, "nope", true); !util.CheckErr(err, ErrDoesntExist) {
+		t.Errorf("Expected a missing secret and got %v", err)
+	}
+}
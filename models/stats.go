@@ -0,0 +1,63 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+type UserStats struct {
+	Total     int `json:"total"`
+	Confirmed int `json:"confirmed"`
+	Disabled  int `json:"disabled"`
+}
+
+type SecretStats struct {
+	Total    int `json:"total"`
+	Versions int `json:"versions"`
+}
+
+type StorageStats struct {
+	DBBytes     int64 `json:"db_bytes"`
+	SecretBytes int64 `json:"secret_bytes"`
+}
+
+// InstanceStats summarizes how much the instance is used
+type InstanceStats struct {
+	Users   UserStats    `json:"users"`
+	Teams   int          `json:"teams"`
+	Vaults  int          `json:"vaults"`
+	Secrets SecretStats  `json:"secrets"`
+	Storage StorageStats `json:"storage"`
+}
+
+// GetInstanceStats counts the users, teams, vaults and secrets. Every count is taken from the same snapshot
+func GetInstanceStats(ctx context.Context) (is InstanceStats, err error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	tx, err := GetDB(ctx).BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return is, util.NewErrorFrom(err)
+	}
+	defer tx.Rollback()
+	queries := []struct {
+		query string
+		dest  []interface{}
+	}{
+		{`SELECT COUNT(*), COUNT("confirmed_at"), COUNT("locked_at") FROM "user"`, []interface{}{&is.Users.Total, &is.Users.Confirmed, &is.Users.Disabled}},
+		{`SELECT COUNT(*) FROM "team"`, []interface{}{&is.Teams}},
+		{`SELECT COUNT(*) FROM "vault"`, []interface{}{&is.Vaults}},
+		{`SELECT COUNT(DISTINCT ("team", "vault", "id")), COUNT(*), COALESCE(SUM(LENGTH("data")), 0) FROM "secret"`, []interface{}{&is.Secrets.Total, &is.Secrets.Versions, &is.Storage.SecretBytes}},
+	}
+	for _, q := range queries {
+		if err := tx.QueryRowContext(ctx, q.query).Scan(q.dest...); isErrOrPanic(err) {
+			return is, util.NewErrorFrom(err)
+		}
+	}
+	//Cockroach can't tell the size of a database so it's left as 0 there
+	if err := tx.QueryRowContext(ctx, `SELECT pg_database_size(current_database())`).Scan(&is.Storage.DBBytes); err != nil {
+		is.Storage.DBBytes = 0
+	}
+	return is, nil
+}
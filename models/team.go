@@ -36,7 +36,7 @@ func createTeam(tx *sql.Tx, owner *User, primary bool, name string, vaultKeys Va
 	if err := t.insert(tx); err != nil {
 		return nil, err
 	}
-	tu := &teamUser{t.Id, owner.Id, true, false}
+	tu := &teamUser{t.Id, owner.Id, true, false, false}
 	if err := tu.insert(tx); err != nil {
 		return nil, err
 	}
@@ -75,8 +75,17 @@ func (t *Team) getAdminUsers(tx *sql.Tx) ([]*User, error) {
 		return nil, util.NewErrorFrom(err)
 	}
 	users, err := scanUsers(rows)
-	isErrOrPanic(err)
-	return users, util.NewErrorFrom(err)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return users, openUsers(users)
+}
+
+func (t *Team) GetAdminUsers(ctx context.Context) (us []*User, err error) {
+	return us, doTx(ctx, func(tx *sql.Tx) error {
+		us, err = t.getAdminUsers(tx)
+		return err
+	})
 }
 
 func (t *Team) getUsers(tx *sql.Tx) ([]*User, error) {
@@ -85,8 +94,10 @@ func (t *Team) getUsers(tx *sql.Tx) ([]*User, error) {
 		return nil, util.NewErrorFrom(err)
 	}
 	users, err := scanUsers(rows)
-	isErrOrPanic(err)
-	return users, util.NewErrorFrom(err)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return users, openUsers(users)
 }
 
 func (t *Team) getUsersAfiliation(tx *sql.Tx) ([]*teamUser, error) {
@@ -162,7 +173,7 @@ func (t *Team) filterTeamUsers(tx *sql.Tx, uids ...string) ([]*teamUser, error)
 }
 
 func (t *Team) PromoteUser(ctx context.Context, promoter *User, promotee *User, signedVaultKeys VaultKeyPair) error {
-	return doTx(ctx, func(tx *sql.Tx) error {
+	return doConflictTx(ctx, func(tx *sql.Tx) error {
 		teamUsers, err := t.filterTeamUsers(tx, promoter.Id, promotee.Id)
 		if err != nil {
 			return err
@@ -173,44 +184,83 @@ func (t *Team) PromoteUser(ctx context.Context, promoter *User, promotee *User,
 		if teamUsers[1].Admin {
 			return nil
 		}
+		if teamUsers[1].Auditor {
+			return util.NewErrorf("Auditors can't be admins")
+		}
 		missingVaults, err := t.getVaultsMissingForUser(tx, promotee)
 		if err != nil {
 			return err
 		}
-		vaultIds := make([]string, len(missingVaults))
-		for i, v := range missingVaults {
-			vaultIds[i] = v.Id
-		}
-		if err := signedVaultKeys.checkKeyIdsMatch(vaultIds); err != nil {
+		if _, _, err := giveVaults(tx, promoter.Id, promotee.Id, missingVaults, signedVaultKeys); err != nil {
 			return err
 		}
-		for _, v := range missingVaults {
-			vaultKey := signedVaultKeys.Keys[v.Id]
-			_, err := verifyAndUnpack(v.PublicKey, vaultKey)
-			if err != nil {
-				return err
-			}
-			if err := v.addUser(tx, promotee.Id, vaultKey); err != nil {
-				return err
-			}
-		}
 		ta := teamUsers[1]
 		ta.Admin = true
 		return ta.update(tx)
 	})
 }
 
+// giveVaults shares the vaults with the user with the keys wrapped for it. There has to be a key for each vault and
+// the vaults that require approval get a grant request instead. Returns the vaults the user was added to and the ones
+// with a grant request
+func giveVaults(tx *sql.Tx, requester, uid string, vaults []*Vault, vkp VaultKeyPair) (added, requested []string, err error) {
+	vaultIds := make([]string, len(vaults))
+	for i, v := range vaults {
+		vaultIds[i] = v.Id
+	}
+	if err := vkp.checkKeyIdsMatch(vaultIds); err != nil {
+		return nil, nil, err
+	}
+	for _, v := range vaults {
+		vaultKey := vkp.Keys[v.Id]
+		if err := checkWrappedKey("vault_key", v.PublicKey, vaultKey); err != nil {
+			return nil, nil, err
+		}
+		//Promotions don't skip the approval of the vaults that need it
+		if v.ApprovalRequired {
+			if _, err := v.requestGrants(tx, requester, map[string][]byte{uid: vaultKey}, time.Time{}); err != nil {
+				return nil, nil, err
+			}
+			requested = append(requested, v.Id)
+			continue
+		}
+		if err := v.addUser(tx, uid, vaultKey); err != nil {
+			return nil, nil, err
+		}
+		added = append(added, v.Id)
+	}
+	return added, requested, nil
+}
+
 func (t *Team) AddOrInviteUserByEmail(ctx context.Context, admin *User, newcomerEmail string) (i *Invite, err error) {
+	return t.AddOrInviteUserWithAssignment(ctx, admin, newcomerEmail, InviteAssignment{})
+}
+
+// AddOrInviteUserWithAssignment adds the user with the email or invites it like AddOrInviteUserByEmail. The assignment
+// is applied once the user joins the team, so users that already have an account get it right away
+func (t *Team) AddOrInviteUserWithAssignment(ctx context.Context, admin *User, newcomerEmail string, ia InviteAssignment) (i *Invite, err error) {
+	ia.normalize()
+	if err := ia.check(); err != nil {
+		return nil, err
+	}
 	return i, doTx(ctx, func(tx *sql.Tx) error {
 		nu, err := findUserByEmail(tx, newcomerEmail)
 		switch {
 		case util.CheckErr(err, ErrDoesntExist):
-			i, err = t.generateInvite(tx, admin, newcomerEmail)
-			return err
+			if i, err = t.generateInvite(tx, admin, newcomerEmail, ia); err != nil {
+				return err
+			}
+			return ia.checkVaults(tx, t.Id)
 		case err != nil:
 			return err
 		default:
-			return t.addUser(tx, admin, nu)
+			if err := t.addUser(tx, admin, nu); err != nil {
+				return err
+			}
+			if err := ia.checkVaults(tx, t.Id); err != nil {
+				return err
+			}
+			return t.assign(tx, nu.Id, admin.Id, ia)
 		}
 	})
 }
@@ -228,14 +278,18 @@ func (t *Team) getUserAffiliation(tx *sql.Tx, username string) (*teamUser, error
 	}
 }
 
-func (t *Team) generateInvite(tx *sql.Tx, admin *User, email string) (*Invite, error) {
+func (t *Team) generateInvite(tx *sql.Tx, admin *User, email string, ia InviteAssignment) (*Invite, error) {
 	if !reValidEmail.MatchString(email) {
 		return nil, util.NewErrorFrom(ErrInvalidEmail)
 	}
 	if err := t.checkAdmin(tx, admin); err != nil {
 		return nil, err
 	}
-	i := &Invite{Team: t.Id, Email: email}
+	return t.insertInvite(tx, email, admin.Id, ia)
+}
+
+func (t *Team) insertInvite(tx *sql.Tx, email, invitedBy string, ia InviteAssignment) (*Invite, error) {
+	i := &Invite{Team: t.Id, Email: email, Message: ia.Message, Role: ia.Role, Vaults: ia.Vaults, InvitedBy: invitedBy}
 	return i, i.insert(tx)
 }
 
@@ -293,7 +347,7 @@ func (t *Team) addUserNoAdminCheck(tx *sql.Tx, newUser *User) error {
 	if tu != nil {
 		return util.NewErrorFrom(ErrAlreadyInTeam)
 	}
-	tu = &teamUser{t.Id, newUser.Id, false, false}
+	tu = &teamUser{t.Id, newUser.Id, false, false, false}
 	return tu.insert(tx)
 }
 
@@ -318,38 +372,42 @@ func (t *Team) DemoteUser(ctx context.Context, demoter *User, demotee *User) err
 	})
 }
 
-func (t *Team) GetSecretsForUser(ctx context.Context, u *User) (s []*Secret, err error) {
-	return s, doTx(ctx, func(tx *sql.Tx) error {
-		s, err = t.getSecretsForUser(tx, u)
-		return err
+func (t *Team) GetSecretsForUser(ctx context.Context, u *User) ([]*Secret, error) {
+	secrets := make([]*Secret, 0, 16)
+	return secrets, t.ForEachSecretForUser(ctx, u, func(s *Secret) error {
+		secrets = append(secrets, s)
+		return nil
 	})
 }
 
-func (t *Team) getSecretsForUser(tx *sql.Tx, u *User) (s []*Secret, err error) {
+// ForEachSecretForUser calls fn with the last version of every secret the user can read. The rows are scanned as fn
+// consumes them so the secrets of big teams don't need to be kept in memory
+func (t *Team) ForEachSecretForUser(ctx context.Context, u *User, fn func(*Secret) error) error {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
 	query := `
 	SELECT DISTINCT ON ("secret"."team", "secret"."vault", "secret"."id")
-		"secret"."team", "secret"."vault", "secret"."id", "secret"."version", "secret"."data", "secret"."vault_version", "secret"."created_at"  
+		"secret"."team", "secret"."vault", "secret"."id", "secret"."version", "secret"."data", "secret"."vault_version", "secret"."created_at", "secret"."archived_at"
 	FROM "secret", "vault_user" 
 	WHERE 
 		"secret"."team" = $1 AND 
 		"secret"."team" = "vault_user"."team" AND 
 		"secret"."vault" = "vault_user"."vault" AND 
-		"vault_user"."user" = $2
+		"vault_user"."user" = $2 AND
+		` + activeVaultUser + `
 	ORDER BY "secret"."team", "secret"."vault", "secret"."id", "secret"."version" DESC`
-	rows, err := tx.Query(query, t.Id, u.Id)
-	if isErrOrPanic(err) {
-		return nil, util.NewErrorFrom(err)
-	}
-	secrets, err := scanSecrets(rows)
+	rows, err := getReadDB(ctx).QueryContext(ctx, query, t.Id, u.Id)
 	if isErrOrPanic(err) {
-		return nil, util.NewErrorFrom(err)
+		return util.NewErrorFrom(err)
 	}
-	return secrets, nil
+	return forEachSecret(rows, fn)
 }
 
 func (t *Team) GetVaultForUser(ctx context.Context, vid string, u *User) (*Vault, error) {
-	db := GetDB(ctx)
-	r := db.QueryRow(`SELECT `+selectVaultFullFields+` FROM "vault", "vault_user" WHERE "vault"."team" = $1 AND "vault"."id" = $2 AND "vault_user"."team" = "vault"."team" AND "vault_user"."user" = $3 AND "vault_user"."vault" = "vault"."id"`, t.Id, vid, u.Id)
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	db := getReadDB(ctx)
+	r := db.QueryRowContext(ctx, `SELECT `+selectVaultFullFields+` FROM "vault", "vault_user" WHERE "vault"."team" = $1 AND "vault"."id" = $2 AND "vault_user"."team" = "vault"."team" AND "vault_user"."user" = $3 AND "vault_user"."vault" = "vault"."id" AND `+activeVaultUser, t.Id, vid, u.Id)
 	v := &Vault{}
 	err := v.dbScanRow(r)
 	if isNotExistsErr(err) {
@@ -362,14 +420,14 @@ func (t *Team) GetVaultForUser(ctx context.Context, vid string, u *User) (*Vault
 }
 
 func (t *Team) GetVaultsForUser(ctx context.Context, u *User) (vs []*Vault, err error) {
-	return vs, doTx(ctx, func(tx *sql.Tx) error {
+	return vs, doReadTx(ctx, func(tx *sql.Tx) error {
 		vs, err = t.getVaultsForUser(tx, u)
 		return err
 	})
 }
 
 func (t *Team) getVaultsForUser(tx *sql.Tx, u *User) ([]*Vault, error) {
-	rows, err := tx.Query(`SELECT `+selectVaultFullFields+` FROM "vault", "vault_user" WHERE  "vault"."team" = $1 AND "vault"."team" = "vault_user"."team" AND "vault"."id" = "vault_user"."vault" AND "vault_user"."user" = $2`, t.Id, u.Id)
+	rows, err := tx.Query(`SELECT `+selectVaultFullFields+` FROM "vault", "vault_user" WHERE  "vault"."team" = $1 AND "vault"."team" = "vault_user"."team" AND "vault"."id" = "vault_user"."vault" AND "vault_user"."user" = $2 AND `+activeVaultUser, t.Id, u.Id)
 	if isErrOrPanic(err) {
 		return nil, util.NewErrorFrom(err)
 	}
@@ -392,3 +450,42 @@ func (t *Team) getVaultsMissingForUser(tx *sql.Tx, u *User) ([]*Vault, error) {
 	}
 	return vaults, nil
 }
+
+func FindTeam(ctx context.Context, tid string) (t *Team, err error) {
+	t = &Team{Id: tid}
+	err = doTx(ctx, func(tx *sql.Tx) error {
+		return t.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return t, nil
+}
+
+func FindTeams(ctx context.Context, limit, offset int) ([]*Team, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := GetDB(ctx).QueryContext(ctx, `SELECT `+selectTeamFields+` FROM "team" ORDER BY "created_at" LIMIT $1 OFFSET $2`, limit, offset)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	teams, err := scanTeams(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return teams, nil
+}
+
+// InviteByEmail invites the email to the team without requiring a team admin. Used by the admin commands
+func (t *Team) InviteByEmail(ctx context.Context, email string) (i *Invite, err error) {
+	if !reValidEmail.MatchString(email) {
+		return nil, util.NewErrorFrom(ErrInvalidEmail)
+	}
+	return i, doTx(ctx, func(tx *sql.Tx) error {
+		i, err = t.insertInvite(tx, email, "", InviteAssignment{Role: TEAM_ROLE_MEMBER})
+		return err
+	})
+}
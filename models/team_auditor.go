@@ -0,0 +1,121 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+// VaultMetadata is what auditors see of a vault. It has no vault keys and no secrets
+type VaultMetadata struct {
+	*Vault
+	Users   []string `json:"users"`
+	Secrets int      `json:"secrets"`
+}
+
+// SetAuditor gives or takes the auditor role of a member. Admins can't be auditors and auditors can't have access to
+// any vault, not even a pending one, so they never get a vault key
+func (t *Team) SetAuditor(ctx context.Context, admin *User, uid string, auditor bool) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		//The row stays locked so no vault key can be given to the member while the role changes
+		tu := &teamUser{}
+		err := tu.dbScanRow(tx.QueryRow(`SELECT `+selectTeamUserFields+` FROM "team_user" WHERE "team" = $1 AND "user" = $2 FOR UPDATE`, t.Id, uid))
+		if isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrNotInTeam)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if auditor && tu.Admin {
+			return util.NewErrorf("Admins can't be auditors")
+		}
+		if auditor {
+			var keys int
+			err := tx.QueryRow(`SELECT (SELECT COUNT(*) FROM "vault_user" WHERE "team" = $1 AND "user" = $2) + (SELECT COUNT(*) FROM "vault_grant" WHERE "team" = $1 AND "user" = $2)`, t.Id, uid).Scan(&keys)
+			if isErrOrPanic(err) {
+				return util.NewErrorFrom(err)
+			}
+			if keys > 0 {
+				return util.NewErrorFrom(ErrAuditor)
+			}
+		}
+		tu.Auditor = auditor
+		return tu.update(tx)
+	})
+}
+
+// CheckAuditor tells if the user is an auditor of the team
+func (t *Team) CheckAuditor(ctx context.Context, u *User) (auditor bool, err error) {
+	return auditor, doReadTx(ctx, func(tx *sql.Tx) error {
+		tu, err := t.getUserAffiliation(tx, u.Id)
+		if err != nil {
+			return err
+		}
+		if tu == nil {
+			return util.NewErrorFrom(ErrNotInTeam)
+		}
+		auditor = tu.Auditor
+		return nil
+	})
+}
+
+// checkNoAuditors refuses to give vault keys to auditors. The rows of the members are locked for share so none of
+// them can become an auditor until the keys are stored
+func checkNoAuditors(tx *sql.Tx, team string, uids []string) error {
+	var auditors int
+	err := tx.QueryRow(`SELECT COUNT(*) FILTER (WHERE "auditor") FROM (SELECT "auditor" FROM "team_user" WHERE "team" = $1 AND "user" = ANY($2) FOR SHARE) "tu"`, team, pq.Array(uids)).Scan(&auditors)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	if auditors > 0 {
+		return util.NewErrorFrom(ErrAuditor)
+	}
+	return nil
+}
+
+// GetVaultsMetadata returns every vault of the team with its members and how many secrets it has
+func (t *Team) GetVaultsMetadata(ctx context.Context) (vms []*VaultMetadata, err error) {
+	return vms, doReadTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+selectVaultFields+` FROM "vault" WHERE "team" = $1 ORDER BY "id"`, t.Id)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		vaults, err := scanVaults(rows)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		vms = make([]*VaultMetadata, len(vaults))
+		byId := map[string]*VaultMetadata{}
+		for i, v := range vaults {
+			vms[i] = &VaultMetadata{Vault: v, Users: []string{}}
+			byId[v.Id] = vms[i]
+		}
+		if err := queryExport(tx, func(rows *sql.Rows) error {
+			var vid, uid string
+			if err := rows.Scan(&vid, &uid); err != nil {
+				return err
+			}
+			if vm, ok := byId[vid]; ok {
+				vm.Users = append(vm.Users, uid)
+			}
+			return nil
+		}, `SELECT "vault", "user" FROM "vault_user" WHERE "team" = $1 AND `+activeVaultUser+` ORDER BY "vault", "user"`, t.Id); err != nil {
+			return err
+		}
+		return queryExport(tx, func(rows *sql.Rows) error {
+			var vid string
+			var count int
+			if err := rows.Scan(&vid, &count); err != nil {
+				return err
+			}
+			if vm, ok := byId[vid]; ok {
+				vm.Secrets = count
+			}
+			return nil
+		}, `SELECT "vault", COUNT(DISTINCT "id") FROM "secret" WHERE "team" = $1 GROUP BY "vault"`, t.Id)
+	})
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestTeamAuditor(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := getFirstVault(owner, team)
+	member := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	if err := team.SetAuditor(ctx, member, member.Id, true); !util.CheckErr(err, ErrUnauthorized) {
+		t.Errorf("Expected a member that isn't an admin to be rejected and got %v", err)
+	}
+	if err := team.SetAuditor(ctx, owner, owner.Id, true); err == nil {
+		t.Errorf("An admin became an auditor")
+	}
+	if err := vm.v.AddUsers(ctx, map[string][]byte{member.Id: sealVaultKey(vm.v, vm.priv)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := team.SetAuditor(ctx, owner, member.Id, true); !util.CheckErr(err, ErrAuditor) {
+		t.Errorf("Expected a vault member to be rejected as auditor and got %v", err)
+	}
+	if err := vm.v.RemoveUser(ctx, member.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := team.SetAuditor(ctx, owner, member.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if auditor, err := team.CheckAuditor(ctx, member); err != nil || !auditor {
+		t.Fatalf("Expected the member to be an auditor (%v)", err)
+	}
+	if err := vm.v.AddUsers(ctx, map[string][]byte{member.Id: sealVaultKey(vm.v, vm.priv)}); !util.CheckErr(err, ErrAuditor) {
+		t.Errorf("Expected the vault key of an auditor to be rejected and got %v", err)
+	}
+	if err := team.PromoteUser(ctx, owner, member, VaultKeyPair{}); err == nil {
+		t.Errorf("An auditor was promoted to admin")
+	}
+	s := &Secret{Data: signAndPack(vm.priv, a32b)}
+	if err := vm.v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	vms, err := team.GetVaultsMetadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vms) != 1 || vms[0].Id != vm.v.Id || vms[0].Secrets != 1 || len(vms[0].Users) != 1 || vms[0].Users[0] != owner.Id {
+		t.Errorf("Unexpected vault metadata %#v", vms)
+	}
+	if err := team.SetAuditor(ctx, owner, member.Id, false); err != nil {
+		t.Fatal(err)
+	}
+	if auditor, err := team.CheckAuditor(ctx, member); err != nil || auditor {
+		t.Errorf("Expected the member not to be an auditor anymore (%v)", err)
+	}
+	if _, err := team.CheckAuditor(ctx, getDummyUser()); !util.CheckErr(err, ErrNotInTeam) {
+		t.Errorf("Expected ErrNotInTeam for a user outside of the team and got %v", err)
+	}
+}
+
+func TestForEachAuditEntryUnder(t *testing.T) {
+	ctx := getCtx()
+	_, team := getDummyOwnerWithTeam()
+	from := time.Now().UTC().Add(-time.Minute)
+	for _, object := range []string{"team:" + team.Id, "team:" + team.Id + "/vault:v", "team:" + team.Id + "x", "user:" + team.Id} {
+		if err := RecordAuditEntry(ctx, &AuditEntry{Actor: "auditor", Action: "test.team_audit", Object: object}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	objects := []string{}
+	if err := ForEachAuditEntryUnder(ctx, "team:"+team.Id, from, time.Now().UTC().Add(time.Minute), func(ae *AuditEntry) error {
+		objects = append(objects, ae.Object)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0] != "team:"+team.Id || objects[1] != "team:"+team.Id+"/vault:v" {
+		t.Errorf("Expected only the entries about the team and got %v", objects)
+	}
+}
@@ -40,6 +40,7 @@ func (t *Team) getTeamFull(tx *sql.Tx, u *User) (*TeamFull, error) {
 	if err != nil {
 		return nil, err
 	}
+	hideLastActive(tu, u)
 	invs, err := t.getInvites(tx)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// TeamMatrix holds the Matrix room where the team activity gets posted
+type TeamMatrix struct {
+	Team        string    `scaneo:"pk" json:"team"`
+	Homeserver  string    `json:"homeserver"`
+	AccessToken string    `json:"-"`
+	RoomId      string    `json:"room_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (tm TeamMatrix) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(tm.Team) == 0 {
+		errs.SetFieldError("matrix_team", "missing")
+	}
+	u, err := url.Parse(tm.Homeserver)
+	switch {
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0:
+		errs.SetFieldError("matrix_homeserver", "invalid")
+	case isInternalHost(u.Hostname()):
+		errs.SetFieldError("matrix_homeserver", "internal")
+	}
+	if len(tm.AccessToken) == 0 {
+		errs.SetFieldError("matrix_access_token", "missing")
+	}
+	if !strings.HasPrefix(tm.RoomId, "!") || !strings.Contains(tm.RoomId, ":") {
+		errs.SetFieldError("matrix_room_id", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+func (t *Team) SetMatrix(ctx context.Context, admin *User, tm *TeamMatrix) error {
+	tm.Team = t.Id
+	tm.Homeserver = strings.TrimRight(tm.Homeserver, "/")
+	if err := tm.validate(); err != nil {
+		return err
+	}
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		tm.CreatedAt = now
+		tm.UpdatedAt = now
+		if _, err := tm.dbDelete(tx); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		_, err := tm.dbInsert(tx)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		return nil
+	})
+}
+
+func (t *Team) GetMatrix(ctx context.Context) (*TeamMatrix, error) {
+	return FindTeamMatrix(ctx, t.Id)
+}
+
+func FindTeamMatrix(ctx context.Context, team string) (tm *TeamMatrix, err error) {
+	tm = &TeamMatrix{Team: team}
+	err = doTx(ctx, func(tx *sql.Tx) error {
+		return tm.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return tm, nil
+}
+
+func (t *Team) DeleteMatrix(ctx context.Context, admin *User) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		tm := &TeamMatrix{Team: t.Id}
+		return treatUpdateErr(tm.dbDelete(tx))
+	})
+}
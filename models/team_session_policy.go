@@ -0,0 +1,85 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// MAX_SESSION_HOURS is the longest cap a team can put on the sessions of its members
+const MAX_SESSION_HOURS = 24 * 365
+
+// TeamSessionPolicy is what a team requires of the sessions of its members. A MaxSessionHours of 0 doesn't cap them
+type TeamSessionPolicy struct {
+	Team            string    `scaneo:"pk" json:"team"`
+	RequireCSRF     bool      `json:"require_csrf"`
+	MaxSessionHours int       `json:"max_session_hours"`
+	UpdatedBy       string    `json:"updated_by"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+func (tsp TeamSessionPolicy) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if tsp.MaxSessionHours < 0 || tsp.MaxSessionHours > MAX_SESSION_HOURS {
+		errs.SetFieldError("max_session_hours", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+// SetSessionPolicy replaces the session policy of the team. Only admins can change it
+func (t *Team) SetSessionPolicy(ctx context.Context, admin *User, tsp *TeamSessionPolicy) error {
+	tsp.Team = t.Id
+	if err := tsp.validate(); err != nil {
+		return err
+	}
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		tsp.UpdatedBy = admin.Id
+		tsp.UpdatedAt = time.Now().UTC()
+		if _, err := tsp.dbDelete(tx); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		_, err := tsp.dbInsert(tx)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		return nil
+	})
+}
+
+// GetSessionPolicy returns the session policy of the team. Teams that never set one don't require anything
+func (t *Team) GetSessionPolicy(ctx context.Context) (*TeamSessionPolicy, error) {
+	tsp := &TeamSessionPolicy{Team: t.Id}
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		return tsp.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return &TeamSessionPolicy{Team: t.Id}, nil
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return tsp, nil
+}
+
+// SessionPolicy is the merge of the session policies of every team of a user. The strictest value of each setting wins
+type SessionPolicy struct {
+	RequireCSRF     bool `json:"require_csrf"`
+	MaxSessionHours int  `json:"max_session_hours"`
+}
+
+// GetSessionPolicy merges the session policies of the teams of the user
+func GetSessionPolicy(ctx context.Context, user string) (sp SessionPolicy, err error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	err = getReadDB(ctx).QueryRowContext(ctx, `SELECT COALESCE(BOOL_OR(p."require_csrf"), false), COALESCE(MIN(NULLIF(p."max_session_hours", 0)), 0)
+		FROM "team_session_policy" p JOIN "team_user" tu ON tu."team" = p."team" WHERE tu."user" = $1`, user).Scan(&sp.RequireCSRF, &sp.MaxSessionHours)
+	if isErrOrPanic(err) {
+		return sp, util.NewErrorFrom(err)
+	}
+	return sp, nil
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestMergedSessionPolicy(t *testing.T) {
+	ctx := getCtx()
+	member := getDummyUser()
+	if sp, err := GetSessionPolicy(ctx, member.Id); err != nil || sp.RequireCSRF || sp.MaxSessionHours != 0 {
+		t.Fatalf("A user without teams with policies requires nothing but got %#v (%v)", sp, err)
+	}
+	policies := []TeamSessionPolicy{{RequireCSRF: true}, {MaxSessionHours: 48}, {MaxSessionHours: 12}}
+	for _, p := range policies {
+		owner, team := getDummyOwnerWithTeam()
+		if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+			t.Fatal(err)
+		}
+		tsp := p
+		if err := team.SetSessionPolicy(ctx, member, &tsp); !util.CheckErr(err, ErrUnauthorized) {
+			t.Errorf("Expected a member that isn't an admin to be rejected and got %v", err)
+		}
+		if err := team.SetSessionPolicy(ctx, owner, &tsp); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := team.GetSessionPolicy(ctx); err != nil || got.MaxSessionHours != p.MaxSessionHours || got.UpdatedBy != owner.Id {
+			t.Fatalf("Unexpected policy %#v (%v)", got, err)
+		}
+	}
+	sp, err := GetSessionPolicy(ctx, member.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sp.RequireCSRF || sp.MaxSessionHours != 12 {
+		t.Errorf("Expected the strictest settings of every team and got %#v", sp)
+	}
+}
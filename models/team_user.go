@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 
 	"github.com/keydotcat/keycatd/util"
@@ -11,6 +12,8 @@ type teamUser struct {
 	User           string `scaneo:"pk" json:"user"`
 	Admin          bool   `json:"admin"`
 	AccessRequired bool   `json:"-"`
+	//Auditor members only read the audit log, the members and the vaults of the team. They never get a vault key
+	Auditor bool `json:"auditor"`
 }
 
 func (tu *teamUser) insert(tx *sql.Tx) error {
@@ -31,3 +34,12 @@ func (tu *teamUser) update(tx *sql.Tx) error {
 	}
 	return nil
 }
+
+// UsersShareTeam is true if both users are members of a team
+func UsersShareTeam(ctx context.Context, a, b string) (share bool, err error) {
+	return share, doTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM "team_user" a JOIN "team_user" b ON a."team" = b."team" WHERE a."user" = $1 AND b."user" = $2)`, a, b).Scan(&share)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
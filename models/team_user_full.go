@@ -5,15 +5,21 @@ import (
 	"database/sql"
 
 	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
 )
 
 type TeamUserFull struct {
-	Team           string `scaneo:"pk" json:"-"`
-	User           string `scaneo:"pk" json:"id"`
-	Admin          bool   `json:"admin"`
-	AccessRequired bool   `json:"-"`
-	FullName       string `json:"fullname"`
-	PublicKey      []byte `json:"public_key"`
+	Team           string      `scaneo:"pk" json:"-"`
+	User           string      `scaneo:"pk" json:"id"`
+	Admin          bool        `json:"admin"`
+	AccessRequired bool        `json:"-"`
+	Auditor        bool        `json:"auditor"`
+	FullName       string      `json:"fullname"`
+	PublicKey      []byte      `json:"public_key"`
+	Pronouns       string      `json:"pronouns"`
+	Title          string      `json:"title"`
+	AvatarAt       pq.NullTime `json:"avatar_at,omitempty"`
+	LastActiveAt   pq.NullTime `json:"last_active_at,omitempty"`
 }
 
 func scanTeamUserFull(rs *sql.Rows) ([]*TeamUserFull, error) {
@@ -26,8 +32,13 @@ func scanTeamUserFull(rs *sql.Rows) ([]*TeamUserFull, error) {
 			&s.User,
 			&s.Admin,
 			&s.AccessRequired,
+			&s.Auditor,
 			&s.FullName,
 			&s.PublicKey,
+			&s.Pronouns,
+			&s.Title,
+			&s.AvatarAt,
+			&s.LastActiveAt,
 		); err != nil {
 			return nil, err
 		}
@@ -39,6 +50,18 @@ func scanTeamUserFull(rs *sql.Rows) ([]*TeamUserFull, error) {
 	return structs, nil
 }
 
+// hideLastActive clears when the members were last active unless the user is an admin of the team
+func hideLastActive(tuf []*TeamUserFull, u *User) {
+	for _, tu := range tuf {
+		if tu.User == u.Id && tu.Admin {
+			return
+		}
+	}
+	for _, tu := range tuf {
+		tu.LastActiveAt = pq.NullTime{}
+	}
+}
+
 func (t *Team) GetUsersAfiliationFull(ctx context.Context) (tuf []*TeamUserFull, err error) {
 	return tuf, doTx(ctx, func(tx *sql.Tx) error {
 		tuf, err = t.getUsersAfiliationFull(tx)
@@ -47,7 +70,7 @@ func (t *Team) GetUsersAfiliationFull(ctx context.Context) (tuf []*TeamUserFull,
 }
 
 func (t *Team) getUsersAfiliationFull(tx *sql.Tx) ([]*TeamUserFull, error) {
-	rows, err := tx.Query(`SELECT `+selectTeamUserFullFields+`, "user"."full_name", "user"."public_key" FROM "team_user", "user" WHERE "team_user"."team" = $1 AND "team_user"."user" = "user"."id"`, t.Id)
+	rows, err := tx.Query(`SELECT `+selectTeamUserFullFields+`, "user"."full_name", "user"."public_key", "user"."pronouns", "user"."title", "user"."avatar_at", "user"."last_active_at" FROM "team_user", "user" WHERE "team_user"."team" = $1 AND "team_user"."user" = "user"."id"`, t.Id)
 	if isErrOrPanic(err) {
 		return nil, util.NewErrorFrom(err)
 	}
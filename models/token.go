@@ -2,30 +2,88 @@ package models
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"sync/atomic"
 	"time"
 
 	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
 )
 
-const TOKEN_VERIFICATION = 0
+const (
+	TOKEN_VERIFICATION = 0
+	TOKEN_PAIRING      = 1
+	TOKEN_OIDC_CODE    = 2
+)
 
+// Token is stored with the hash of its secret as the id. The secret is only known when the token is issued
 type Token struct {
-	Id        string    `scaneo:"pk" json:"id"`
-	Type      int       `json:"-"`
-	User      string    `json:"-"`
-	Extra     string    `json:"extra,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Id        string       `scaneo:"pk" json:"id"`
+	Type      int          `json:"-"`
+	User      string       `json:"-"`
+	Extra     SealedString `json:"extra,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	//ConsumedAt is when the token was used. Used tokens are kept until they are purged to tell apart a second use
+	ConsumedAt pq.NullTime `json:"-"`
+}
+
+// IssuedToken is a token that has just been stored along with the secret to hand to the user
+type IssuedToken struct {
+	*Token
+	Secret string
 }
 
-func FindToken(ctx context.Context, id string) (*Token, error) {
-	t := &Token{Id: id}
+// hashTokenSecret returns the id of the token for the secret. Tokens are looked up by the hash of the secret so the
+// comparison leaks nothing about the stored secrets and a copy of the db has no usable tokens
+func hashTokenSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+var verificationTokenLifetime, inviteLifetime int64
+
+// SetTokenLifetimes sets how long confirmation tokens are valid since they were last sent and invites since they were
+// created. A lifetime of 0 keeps them valid until they are purged
+func SetTokenLifetimes(verification, invite time.Duration) {
+	atomic.StoreInt64(&verificationTokenLifetime, int64(verification))
+	atomic.StoreInt64(&inviteLifetime, int64(invite))
+}
+
+// expired tells if the token is older than the lifetime of its type. Confirmation tokens are replaced every time they
+// are sent so their lifetime starts again
+func (t *Token) expired(now time.Time) bool {
+	switch t.Type {
+	case TOKEN_VERIFICATION:
+		lifetime := time.Duration(atomic.LoadInt64(&verificationTokenLifetime))
+		return lifetime > 0 && now.Sub(t.UpdatedAt) > lifetime
+	case TOKEN_PAIRING:
+		return now.Sub(t.CreatedAt) > PAIRING_TTL
+	case TOKEN_OIDC_CODE:
+		return now.Sub(t.CreatedAt) > OIDC_CODE_TTL
+	}
+	return false
+}
+
+// FindToken returns ErrDoesntExist for unknown tokens, ErrAlreadyUsed for consumed ones and ErrExpired for the ones
+// older than the lifetime of their type
+func FindToken(ctx context.Context, secret string) (*Token, error) {
+	t := &Token{Id: hashTokenSecret(secret)}
 	err := doTx(ctx, func(tx *sql.Tx) error {
-		return t.dbFind(tx)
+		return t.find(tx)
 	})
 	if isNotExistsErr(err) {
 		return nil, util.NewErrorFrom(ErrDoesntExist)
+	} else if err != nil {
+		return nil, err
+	}
+	if t.ConsumedAt.Valid {
+		return nil, util.NewErrorFrom(ErrAlreadyUsed)
+	}
+	if t.expired(time.Now()) {
+		return nil, util.NewErrorFrom(ErrExpired)
 	}
 	return t, nil
 }
@@ -39,7 +97,7 @@ func FindTokensForUser(ctx context.Context, user string) (tokens []*Token) {
 }
 
 func findTokensForUser(tx *sql.Tx, user string) []*Token {
-	rows, err := tx.Query("SELECT "+selectTokenFields+" FROM \"token\" WHERE \"user\"=$1", user)
+	rows, err := tx.Query("SELECT "+selectTokenFields+" FROM \"token\" WHERE \"user\"=$1 AND \"consumed_at\" IS NULL", user)
 	if err != nil {
 		panic(err)
 	}
@@ -47,31 +105,54 @@ func findTokensForUser(tx *sql.Tx, user string) []*Token {
 	if err != nil {
 		panic(err)
 	}
+	for _, t := range ts {
+		if err := t.Extra.open(t.extraRow()); err != nil {
+			panic(err)
+		}
+	}
 	return ts
 }
 
+// extraRow binds the sealed extra to the token
+func (t *Token) extraRow() []byte {
+	return sealedRow("token", "extra", t.Id)
+}
+
+// find reads the token and opens its extra
+func (t *Token) find(tx *sql.Tx) error {
+	if err := t.dbFind(tx); err != nil {
+		return err
+	}
+	return t.Extra.open(t.extraRow())
+}
+
 func (u *Token) validate() error {
 	errs := util.NewErrorFields().(*util.Error)
 	if !reValidUsername.MatchString(u.User) {
 		errs.SetFieldError("username", "invalid")
 	}
-	if len(u.Id) < 6 {
-		errs.SetFieldError("id", "too short")
+	if len(u.Id) != hex.EncodedLen(sha256.Size) {
+		errs.SetFieldError("id", "invalid")
 	}
-	if u.Type != TOKEN_VERIFICATION {
+	if u.Type != TOKEN_VERIFICATION && u.Type != TOKEN_PAIRING && u.Type != TOKEN_OIDC_CODE {
 		errs.SetFieldError("type", "invalid")
 	}
 	return errs.SetErrorOrCamo(ErrInvalidAttributes)
 }
 
 func (u *Token) insert(tx *sql.Tx) error {
-	u.Id = util.GenerateRandomToken(32)
 	if err := u.validate(); err != nil {
 		return err
 	}
 	u.CreatedAt = time.Now().UTC()
 	u.UpdatedAt = u.CreatedAt
-	_, err := u.dbInsert(tx)
+	var err error
+	if serr := writeSealed(&u.Extra, u.extraRow(), func() error {
+		_, err = u.dbInsert(tx)
+		return nil
+	}); serr != nil {
+		return serr
+	}
 	if IsDuplicateErr(err) {
 		return util.NewErrorFrom(ErrAlreadyExists)
 	}
@@ -79,13 +160,79 @@ func (u *Token) insert(tx *sql.Tx) error {
 	return util.NewErrorFrom(err)
 }
 
+// insert stores the token with a new secret
+func (it *IssuedToken) insert(tx *sql.Tx) error {
+	it.Secret = util.GenerateSecretToken()
+	it.Id = hashTokenSecret(it.Secret)
+	return it.Token.insert(tx)
+}
+
+func newVerificationToken(user string) *IssuedToken {
+	return &IssuedToken{Token: &Token{Type: TOKEN_VERIFICATION, User: user}}
+}
+
+// reissueVerificationToken replaces the pending confirmation token of the user with a new one, as the secret of the
+// previous one can't be sent again
+func reissueVerificationToken(tx *sql.Tx, user string) (*IssuedToken, error) {
+	_, err := tx.Exec(`DELETE FROM "token" WHERE "user" = $1 AND "type" = $2 AND "consumed_at" IS NULL`, user, TOKEN_VERIFICATION)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	t := newVerificationToken(user)
+	return t, t.insert(tx)
+}
+
 func (u *Token) update(tx *sql.Tx) error {
 	if err := u.validate(); err != nil {
 		return err
 	}
 	u.UpdatedAt = time.Now().UTC()
-	res, err := u.dbUpdate(tx)
-	return treatUpdateErr(res, err)
+	return writeSealed(&u.Extra, u.extraRow(), func() error {
+		return treatUpdateErr(u.dbUpdate(tx))
+	})
+}
+
+func (t *Token) lock(tx *sql.Tx) error {
+	var id string
+	err := tx.QueryRow(`SELECT "id" FROM "token" WHERE "id" = $1 FOR UPDATE`, t.Id).Scan(&id)
+	if isNotExistsErr(err) {
+		return util.NewErrorFrom(ErrDoesntExist)
+	}
+	return util.NewErrorFrom(err)
+}
+
+// consume marks the token as used with its row locked, so only the first of concurrent callers gets to use it and the
+// rest get ErrAlreadyUsed
+func (t *Token) consume(tx *sql.Tx) error {
+	var consumed pq.NullTime
+	err := tx.QueryRow(`SELECT "consumed_at" FROM "token" WHERE "id" = $1 FOR UPDATE`, t.Id).Scan(&consumed)
+	if isNotExistsErr(err) {
+		return util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	if consumed.Valid {
+		return util.NewErrorFrom(ErrAlreadyUsed)
+	}
+	now := time.Now().UTC()
+	res, err := tx.Exec(`UPDATE "token" SET "consumed_at" = $1, "updated_at" = $1 WHERE "id" = $2`, now, t.Id)
+	if err := treatUpdateErr(res, err); err != nil {
+		return err
+	}
+	t.ConsumedAt, t.UpdatedAt = nullTime(now), now
+	return nil
+}
+
+// PurgeExpiredTokens removes the tokens that haven't been sent or used since before. With dryRun nothing is removed.
+// Tokens locked by a running confirmation are skipped and left for the next run. They are only counted as the ids tell nothing to the admins
+func PurgeExpiredTokens(ctx context.Context, before time.Time, dryRun bool) (purged AffectedRows, err error) {
+	return purged, doDryRunTx(ctx, dryRun, func(tx *sql.Tx) error {
+		purged, err = countAffected(tx, "token", `WITH "purged" AS (
+			DELETE FROM "token" WHERE "id" IN (SELECT "id" FROM "token" WHERE "updated_at" < $1 FOR UPDATE SKIP LOCKED) RETURNING 1
+		) SELECT COUNT(*) FROM "purged"`, before)
+		return err
+	})
 }
 
 func (t *Token) ConfirmEmail(ctx context.Context) (u *User, err error) {
@@ -93,11 +240,12 @@ func (t *Token) ConfirmEmail(ctx context.Context) (u *User, err error) {
 		return nil, util.NewErrorFrom(ErrDoesntExist)
 	}
 	return u, doTx(ctx, func(tx *sql.Tx) error {
-		u, err = findUser(tx, t.User)
-		if err != nil {
+		//The token stays locked so the cleanup job and concurrent confirmations wait for this one
+		if err := t.consume(tx); err != nil {
 			return err
 		}
-		if err = treatUpdateErr(t.dbDelete(tx)); err != nil {
+		u, err = findUser(tx, t.User)
+		if err != nil {
 			return err
 		}
 		u.Email = u.UnconfirmedEmail
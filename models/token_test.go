@@ -1,7 +1,9 @@
 package models
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/keydotcat/keycatd/util"
 )
@@ -31,9 +33,15 @@ func TestConfirmEmail(t *testing.T) {
 	if !u2.ConfirmedAt.Valid {
 		t.Fatalf("User is not confirmed")
 	}
-	_, err = FindToken(ctx, tok.Id)
-	if !util.CheckErr(err, ErrDoesntExist) {
-		t.Fatalf("Unexpected error: %s vs %s", ErrDoesntExist, err)
+	if tok.Id == tok.Secret || len(tok.Secret) != 43 {
+		t.Fatalf("Expected a 256 bit secret stored hashed and got %s for %s", tok.Id, tok.Secret)
+	}
+	if _, err := FindToken(ctx, tok.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("The stored id works as a token: %s", err)
+	}
+	_, err = FindToken(ctx, tok.Secret)
+	if !util.CheckErr(err, ErrAlreadyUsed) {
+		t.Fatalf("Unexpected error: %s vs %s", ErrAlreadyUsed, err)
 	}
 	u3, err := FindUser(ctx, uid)
 	if err != nil {
@@ -44,3 +52,78 @@ func TestConfirmEmail(t *testing.T) {
 	}
 
 }
+
+func TestConfirmEmailOnlyOnce(t *testing.T) {
+	ctx := getCtx()
+	uid := "u_" + util.GenerateRandomToken(10)
+	_, priv, pack := generateNewKeys()
+	vkp := getDummyVaultKeyPair(priv, uid)
+	_, tok, err := NewUser(ctx, uid, "uid fullname", uid+"@nowhere.net", uid, pack, vkp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make([]error, 5)
+	wg := sync.WaitGroup{}
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = tok.ConfirmEmail(ctx)
+		}(i)
+	}
+	wg.Wait()
+	confirmed := 0
+	for _, err := range errs {
+		if err == nil {
+			confirmed++
+		} else if !util.CheckErr(err, ErrAlreadyUsed) {
+			t.Errorf("Expected %s and got %s", ErrAlreadyUsed, err)
+		}
+	}
+	if confirmed != 1 {
+		t.Fatalf("The token has been used %d times", confirmed)
+	}
+	if tokens := FindTokensForUser(ctx, uid); len(tokens) != 0 {
+		t.Fatalf("The used token is still listed for the user: %#v", tokens)
+	}
+}
+
+func TestTokenLifetimes(t *testing.T) {
+	defer SetTokenLifetimes(0, 0)
+	ctx := getCtx()
+	uid := "u_" + util.GenerateRandomToken(10)
+	_, priv, pack := generateNewKeys()
+	vkp := getDummyVaultKeyPair(priv, uid)
+	_, tok, err := NewUser(ctx, uid, "uid fullname", uid+"@nowhere.net", uid, pack, vkp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTokenLifetimes(time.Hour, 0)
+	if _, err := FindToken(ctx, tok.Secret); err != nil {
+		t.Fatal(err)
+	}
+	SetTokenLifetimes(time.Millisecond, 0)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := FindToken(ctx, tok.Secret); !util.CheckErr(err, ErrExpired) {
+		t.Fatalf("Expected %s and got %s", ErrExpired, err)
+	}
+	if _, err := FindToken(ctx, "nonexistent"); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", ErrDoesntExist, err)
+	}
+	SetTokenLifetimes(0, 0)
+	if _, err := FindToken(ctx, tok.Secret); err != nil {
+		t.Fatal(err)
+	}
+	//Pairing codes and oidc codes keep their own lifetimes
+	old := &Token{Type: TOKEN_PAIRING, CreatedAt: time.Now().Add(-PAIRING_TTL - time.Second)}
+	if !old.expired(time.Now()) {
+		t.Fatalf("Expected the pairing token to be expired")
+	}
+	SetTokenLifetimes(0, time.Hour)
+	if (Invite{CreatedAt: time.Now().Add(-2 * time.Hour)}).Expired() == false {
+		t.Fatalf("Expected the invite to be expired")
+	}
+	if (Invite{CreatedAt: time.Now()}).Expired() {
+		t.Fatalf("Expected the invite to be valid")
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
@@ -14,27 +15,40 @@ import (
 
 var (
 	HASH_PASSWD_COST = 14
-	reValidUsername  = regexp.MustCompile(`^[\w-]{3,}$`)
 	reValidEmail     = regexp.MustCompile(`^([\w-]+\.?)+@([\w-]+\.*)+\.\w+$`)
 )
 
 type User struct {
-	Id               string      `scaneo:"pk" json:"id"`
-	Email            string      `json:"email"`
-	UnconfirmedEmail string      `json:"-"`
-	HashPass         []byte      `json:"-"`
-	FullName         string      `json:"fullname"`
-	ConfirmedAt      pq.NullTime `json:"confirmed_at,omitempty"`
-	LockedAt         pq.NullTime `json:"locked_at,omitempty"`
-	SignInCount      int         `json:"sign_in_count"`
-	FailedAttempts   int         `json:"failed_attempts"`
-	PublicKey        []byte      `json:"public_key"`
-	Key              []byte      `json:"-"`
-	CreatedAt        time.Time   `json:"created_at"`
-	UpdatedAt        time.Time   `json:"updated_at"`
+	Id                string      `scaneo:"pk" json:"id"`
+	Email             string      `json:"email"`
+	UnconfirmedEmail  string      `json:"-"`
+	HashPass          []byte      `json:"-"`
+	FullName          string      `json:"fullname"`
+	ConfirmedAt       pq.NullTime `json:"confirmed_at,omitempty"`
+	LockedAt          pq.NullTime `json:"locked_at,omitempty"`
+	SignInCount       int         `json:"sign_in_count"`
+	FailedAttempts    int         `json:"failed_attempts"`
+	PublicKey         []byte      `json:"public_key"`
+	Key               Sealed      `json:"-"`
+	Admin             bool        `json:"admin"`
+	CreatedAt         time.Time   `json:"created_at"`
+	UpdatedAt         time.Time   `json:"updated_at"`
+	Pronouns          string      `json:"pronouns"`
+	Title             string      `json:"title"`
+	AvatarAt          pq.NullTime `json:"avatar_at,omitempty"`
+	LastActiveAt      pq.NullTime `json:"last_active_at,omitempty"`
+	Locale            string      `json:"locale"`
+	Timezone          string      `json:"timezone"`
+	MustResetPassword bool        `json:"must_reset_password"`
+	FailedAt          pq.NullTime `json:"failed_at,omitempty"`
 }
 
-func NewUser(ctx context.Context, id, fullname, email, password string, keyPack []byte, signedVaultKeys VaultKeyPair) (*User, *Token, error) {
+func NewUser(ctx context.Context, id, fullname, email, password string, keyPack []byte, signedVaultKeys VaultKeyPair) (*User, *IssuedToken, error) {
+	if !ValidNewUsername(id) {
+		errs := util.NewErrorFields().(*util.Error)
+		errs.SetFieldError("user_id", "invalid")
+		return nil, nil, errs.SetErrorOrCamo(ErrInvalidAttributes)
+	}
 	pub, priv, err := expandUserKeyPack(keyPack)
 	if err != nil {
 		return nil, nil, err
@@ -51,14 +65,17 @@ func NewUser(ctx context.Context, id, fullname, email, password string, keyPack
 	if err != nil {
 		return nil, nil, err
 	}
-	t := &Token{Type: TOKEN_VERIFICATION, User: u.Id}
+	t := newVerificationToken(u.Id)
 	if err := u.setPassword(password); err != nil {
 		return nil, nil, err
 	}
-	return u, t, doTx(ctx, func(tx *sql.Tx) error {
+	return u, t, doConflictTx(ctx, func(tx *sql.Tx) error {
 		if err := u.insert(tx); err != nil {
 			return err
 		}
+		if err := appendKeyLog(tx, u.Id, u.PublicKey); err != nil {
+			return err
+		}
 		if err := t.insert(tx); err != nil {
 			return err
 		}
@@ -66,11 +83,14 @@ func NewUser(ctx context.Context, id, fullname, email, password string, keyPack
 		if err != nil {
 			return err
 		}
-		invites, err := findInvitesForEmail(tx, u.Email)
+		invites, err := consumeInvitesForEmail(tx, u.Email)
 		if err != nil {
 			return err
 		}
 		for _, i := range invites {
+			if i.Expired() {
+				continue
+			}
 			team, err := i.getTeam(tx)
 			if err != nil {
 				return err
@@ -78,7 +98,7 @@ func NewUser(ctx context.Context, id, fullname, email, password string, keyPack
 			if err := team.addUserNoAdminCheck(tx, u); err != nil {
 				return err
 			}
-			if _, err := i.dbDelete(tx); err != nil {
+			if err := team.assign(tx, u.Id, i.InvitedBy, i.Assignment()); err != nil {
 				return err
 			}
 		}
@@ -94,13 +114,56 @@ func (u *User) ChangePassword(ctx context.Context, password string, keyPack []by
 	if err := u.setPassword(password); err != nil {
 		return err
 	}
+	changed := !bytes.Equal(u.PublicKey, pub)
 	u.PublicKey = pub
 	u.Key = priv
-	return doTx(ctx, func(tx *sql.Tx) error {
-		return u.update(tx)
+	u.MustResetPassword = false
+	return doConflictTx(ctx, func(tx *sql.Tx) error {
+		if changed {
+			if revoked, err := isDeviceKeyRevoked(tx, u.PublicKey); err != nil {
+				return err
+			} else if revoked {
+				return util.NewErrorFrom(ErrRevokedKey)
+			}
+		}
+		if err := u.update(tx); err != nil {
+			return err
+		}
+		if changed {
+			return appendKeyLog(tx, u.Id, u.PublicKey)
+		}
+		return nil
 	})
 }
 
+// ReplacePassword changes the password and the key pack like ChangePassword only if oldPassword is the current password
+func (u *User) ReplacePassword(ctx context.Context, oldPassword, password string, keyPack []byte) error {
+	errs := util.NewErrorFields().(*util.Error)
+	if err := u.CheckPassword(oldPassword); err != nil {
+		errs.SetFieldError("old_password", "invalid")
+	}
+	if len(password) == 0 {
+		errs.SetFieldError("password", "invalid")
+	}
+	if err := errs.SetErrorOrCamo(ErrInvalidAttributes); err != nil {
+		return err
+	}
+	return u.ChangePassword(ctx, password, keyPack)
+}
+
+// ResetPassword is ReplacePassword for the users that have been forced to reset it. The new password can't be the old one
+func (u *User) ResetPassword(ctx context.Context, oldPassword, password string, keyPack []byte) error {
+	if !u.MustResetPassword {
+		return util.NewErrorFrom(ErrUnauthorized)
+	}
+	if u.CheckPassword(password) == nil {
+		errs := util.NewErrorFields().(*util.Error)
+		errs.SetFieldError("password", "unchanged")
+		return errs.SetErrorOrCamo(ErrInvalidAttributes)
+	}
+	return u.ReplacePassword(ctx, oldPassword, password, keyPack)
+}
+
 func FindUser(ctx context.Context, id string) (u *User, err error) {
 	return u, doTx(ctx, func(tx *sql.Tx) error {
 		u, err = findUser(tx, id)
@@ -130,8 +193,25 @@ func findUserByField(tx *sql.Tx, fieldName, value string) (*User, error) {
 	if isNotExistsErr(err) {
 		return nil, util.NewErrorFrom(ErrDoesntExist)
 	}
-	isErrOrPanic(err)
-	return u, util.NewErrorFrom(err)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return u, u.Key.open(u.keyRow())
+}
+
+// keyRow binds the sealed private key to the user
+func (u *User) keyRow() []byte {
+	return sealedRow("user", "key", u.Id)
+}
+
+// openUsers opens the private keys of the users read from the db
+func openUsers(users []*User) error {
+	for _, u := range users {
+		if err := u.Key.open(u.keyRow()); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (u *User) CreateTeam(ctx context.Context, name string, signedVaultKeys VaultKeyPair) (t *Team, err error) {
@@ -139,7 +219,7 @@ func (u *User) CreateTeam(ctx context.Context, name string, signedVaultKeys Vaul
 	if err != nil {
 		return nil, err
 	}
-	return t, doTx(ctx, func(tx *sql.Tx) error {
+	return t, doConflictTx(ctx, func(tx *sql.Tx) error {
 		t, err = createTeam(tx, u, false, name, vaultKeys)
 		return err
 	})
@@ -151,7 +231,13 @@ func (u *User) insert(tx *sql.Tx) error {
 	}
 	u.CreatedAt = time.Now().UTC()
 	u.UpdatedAt = u.CreatedAt
-	_, err := u.dbInsert(tx)
+	var err error
+	if serr := writeSealed(&u.Key, u.keyRow(), func() error {
+		_, err = u.dbInsert(tx)
+		return nil
+	}); serr != nil {
+		return serr
+	}
 	if IsDuplicateErr(err) {
 		dup := getDuplicateFieldFromErr(err)
 		if dup == "" {
@@ -170,8 +256,9 @@ func (u *User) update(tx *sql.Tx) error {
 		return err
 	}
 	u.UpdatedAt = u.CreatedAt
-	res, err := u.dbUpdate(tx)
-	return treatUpdateErr(res, err)
+	return writeSealed(&u.Key, u.keyRow(), func() error {
+		return treatUpdateErr(u.dbUpdate(tx))
+	})
 }
 
 func (u *User) validate() error {
@@ -197,6 +284,18 @@ func (u *User) validate() error {
 	if len(u.Key) != privateKeyPackSize {
 		errs.SetFieldError("user_private_key", "invalid")
 	}
+	if len(u.Pronouns) > USER_PRONOUNS_MAX_LENGTH {
+		errs.SetFieldError("user_pronouns", "too long")
+	}
+	if len(u.Title) > USER_TITLE_MAX_LENGTH {
+		errs.SetFieldError("user_title", "too long")
+	}
+	if len(u.Locale) > 0 && !ValidLocale(u.Locale) {
+		errs.SetFieldError("user_locale", "invalid")
+	}
+	if len(u.Timezone) > 0 && !ValidTimezone(u.Timezone) {
+		errs.SetFieldError("user_timezone", "invalid")
+	}
 	return errs.SetErrorOrCamo(ErrInvalidAttributes)
 }
 
@@ -218,8 +317,10 @@ func (u *User) CheckPassword(pass string) error {
 }
 
 func (u *User) GetTeams(ctx context.Context) ([]*Team, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
 	db := GetDB(ctx)
-	rows, err := db.Query(`SELECT `+selectTeamFullFields+` FROM "team", "team_user" WHERE  "team_user"."team" = "team".id AND "team_user"."user" = $1`, u.Id)
+	rows, err := db.QueryContext(ctx, `SELECT `+selectTeamFullFields+` FROM "team", "team_user" WHERE  "team_user"."team" = "team".id AND "team_user"."user" = $1`, u.Id)
 	if isErrOrPanic(err) {
 		return nil, util.NewErrorFrom(err)
 	}
@@ -228,17 +329,16 @@ func (u *User) GetTeams(ctx context.Context) ([]*Team, error) {
 	return teams, util.NewErrorFrom(err)
 }
 
-func (u *User) GetVerificationToken(ctx context.Context) (t *Token, err error) {
-	t = &Token{}
-	err = doTx(ctx, func(tx *sql.Tx) error {
-		r := tx.QueryRow(`SELECT `+selectTokenFields+` FROM "token" WHERE "user" = $1 AND "type" = $2`, u.Id, TOKEN_VERIFICATION)
-		return t.dbScanRow(r)
-	})
-	fmt.Println("ERROR", err)
-	if isNotExistsErr(err) {
+// GetVerificationToken returns a new token to confirm the pending email. It replaces the previous one as only the hash of
+// its secret is stored, so only the link in the last mail works
+func (u *User) GetVerificationToken(ctx context.Context) (t *IssuedToken, err error) {
+	if len(u.UnconfirmedEmail) == 0 {
 		return nil, util.NewErrorFrom(ErrDoesntExist)
 	}
-	return t, nil
+	return t, doTx(ctx, func(tx *sql.Tx) error {
+		t, err = reissueVerificationToken(tx, u.Id)
+		return err
+	})
 }
 
 func (u *User) GetTeam(ctx context.Context, tid string) (t *Team, err error) {
@@ -259,19 +359,10 @@ func (u *User) getTeam(tx *sql.Tx, tid string) (*Team, error) {
 	return t, err
 }
 
-func (u *User) ChangeEmail(ctx context.Context, email string) (t *Token, err error) {
+func (u *User) ChangeEmail(ctx context.Context, email string) (t *IssuedToken, err error) {
 	return t, doTx(ctx, func(tx *sql.Tx) error {
-		tokens := findTokensForUser(tx, u.Id)
-		for _, token := range tokens {
-			if token.Type == TOKEN_VERIFICATION {
-				t = token
-			}
-		}
-		if t == nil {
-			t = &Token{Type: TOKEN_VERIFICATION, User: u.Id}
-			if err := t.insert(tx); err != nil {
-				return err
-			}
+		if t, err = reissueVerificationToken(tx, u.Id); err != nil {
+			return err
 		}
 		u.UnconfirmedEmail = email
 		return u.update(tx)
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// UserFilter restricts the users returned by FindUsers. Nil fields are ignored
+type UserFilter struct {
+	Query     string
+	Confirmed *bool
+	Disabled  *bool
+	Admin     *bool
+}
+
+// likeEscaper makes the wildcards of a search match themselves
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (uf UserFilter) where() (string, []interface{}) {
+	conds := []string{}
+	vals := []interface{}{}
+	if len(uf.Query) > 0 {
+		vals = append(vals, "%"+likeEscaper.Replace(uf.Query)+"%")
+		n := len(vals)
+		conds = append(conds, fmt.Sprintf(`("id" ILIKE $%d ESCAPE '\' OR "email" ILIKE $%d ESCAPE '\' OR "full_name" ILIKE $%d ESCAPE '\')`, n, n, n))
+	}
+	if uf.Confirmed != nil {
+		conds = append(conds, fmt.Sprintf(`"confirmed_at" IS %s NULL`, notIf(*uf.Confirmed)))
+	}
+	if uf.Disabled != nil {
+		conds = append(conds, fmt.Sprintf(`"locked_at" IS %s NULL`, notIf(*uf.Disabled)))
+	}
+	if uf.Admin != nil {
+		vals = append(vals, *uf.Admin)
+		conds = append(conds, fmt.Sprintf(`"admin" = $%d`, len(vals)))
+	}
+	if len(conds) == 0 {
+		return "", vals
+	}
+	return " WHERE " + strings.Join(conds, " AND "), vals
+}
+
+func notIf(b bool) string {
+	if b {
+		return "NOT"
+	}
+	return ""
+}
+
+// FindUsers returns a page of users matching the filter and the total number of matches
+func FindUsers(ctx context.Context, uf UserFilter, limit, offset int) (users []*User, total int, err error) {
+	return users, total, doTx(ctx, func(tx *sql.Tx) error {
+		where, vals := uf.where()
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM "user"`+where, vals...).Scan(&total); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		n := len(vals)
+		vals = append(vals, limit, offset)
+		rows, err := tx.Query(fmt.Sprintf(`SELECT %s FROM "user"%s ORDER BY "id" LIMIT $%d OFFSET $%d`, selectUserFields, where, n+1, n+2), vals...)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		users, err = scanUsers(rows)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		return openUsers(users)
+	})
+}
+
+func (u *User) IsDisabled() bool {
+	return u.LockedAt.Valid
+}
+
+func (u *User) SetDisabled(ctx context.Context, disabled bool) error {
+	u.LockedAt.Valid = disabled
+	if disabled {
+		u.LockedAt.Time = time.Now().UTC()
+	}
+	return doTx(ctx, func(tx *sql.Tx) error {
+		return u.update(tx)
+	})
+}
+
+// ForcePasswordReset keeps the user from logging in until it resets its password with ResetPassword
+func (u *User) ForcePasswordReset(ctx context.Context) error {
+	u.MustResetPassword = true
+	return doTx(ctx, func(tx *sql.Tx) error {
+		return u.update(tx)
+	})
+}
+
+func (u *User) SetAdmin(ctx context.Context, admin bool) error {
+	u.Admin = admin
+	return doTx(ctx, func(tx *sql.Tx) error {
+		return u.update(tx)
+	})
+}
+
+// ForceEmailVerification unconfirms the account and returns a token to confirm the current email again
+func (u *User) ForceEmailVerification(ctx context.Context) (t *IssuedToken, err error) {
+	return t, doTx(ctx, func(tx *sql.Tx) error {
+		if t, err = reissueVerificationToken(tx, u.Id); err != nil {
+			return err
+		}
+		if len(u.UnconfirmedEmail) == 0 {
+			u.UnconfirmedEmail = u.Email
+		}
+		u.ConfirmedAt.Valid = false
+		return u.update(tx)
+	})
+}
+
+// Delete removes the user. Teams owned by the user are removed as well, so it returns ErrOwnsSharedTeams while any of
+// them has other members
+func (u *User) Delete(ctx context.Context) error {
+	_, err := u.DeleteWithReport(ctx, false)
+	return err
+}
+
+const ownedTeams = `SELECT "id" FROM "team" WHERE "owner" = $1`
+
+// userDeleteChecks list what goes away with the user. Everything cascades from the user and the teams it owns
+var userDeleteChecks = []struct{ kind, query string }{
+	{"team", ownedTeams},
+	{"team_user", `SELECT "team" || '/' || "user" FROM "team_user" WHERE "user" = $1 OR "team" IN (` + ownedTeams + `)`},
+	{"vault", `SELECT "team" || '/' || "id" FROM "vault" WHERE "team" IN (` + ownedTeams + `)`},
+	{"vault_user", `SELECT "team" || '/' || "vault" || '/' || "user" FROM "vault_user" WHERE "user" = $1 OR "team" IN (` + ownedTeams + `)`},
+	{"secret", `SELECT DISTINCT "team" || '/' || "vault" || '/' || "id" FROM "secret" WHERE "team" IN (` + ownedTeams + `)`},
+	//Nobody else holds the key of these so the orphan collection removes them afterwards
+	{"vault_without_users", `SELECT v."team" || '/' || v."id" FROM "vault" v WHERE v."team" NOT IN (` + ownedTeams + `) AND
+		EXISTS (SELECT 1 FROM "vault_user" vu WHERE vu."team" = v."team" AND vu."vault" = v."id" AND vu."user" = $1) AND
+		NOT EXISTS (SELECT 1 FROM "vault_user" vu WHERE vu."team" = v."team" AND vu."vault" = v."id" AND vu."user" != $1)`},
+}
+
+// DeleteWithReport removes the user and reports what has been removed with it. With dryRun nothing is removed.
+// Sessions are not in the report as they are kept by the session manager. Users that own teams with other members
+// aren't removed so the teams of the rest aren't removed with them
+func (u *User) DeleteWithReport(ctx context.Context, dryRun bool) (cr *ChangeReport, err error) {
+	return cr, doDryRunTx(ctx, dryRun, func(tx *sql.Tx) error {
+		var shared bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM "team_user" WHERE "team" IN (`+ownedTeams+`) AND "user" != $1)`, u.Id).Scan(&shared)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if shared {
+			return util.NewErrorFrom(ErrOwnsSharedTeams)
+		}
+		cr = NewChangeReport(dryRun)
+		for _, uc := range userDeleteChecks {
+			ar, err := queryAffected(tx, uc.kind, uc.query, u.Id)
+			if err != nil {
+				return err
+			}
+			cr.Add(ar)
+		}
+		ar, err := countAffected(tx, "token", `SELECT COUNT(*) FROM "token" WHERE "user" = $1`, u.Id)
+		if err != nil {
+			return err
+		}
+		cr.Add(ar)
+		res, err := tx.Exec(`DELETE FROM "user" WHERE "id" = $1`, u.Id)
+		return treatUpdateErr(res, err)
+	})
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+// UserExportTeam is a team the user belongs to
+type UserExportTeam struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Owner bool   `json:"owner"`
+	Admin bool   `json:"admin"`
+}
+
+// UserExportVaultKey is the key of a vault sealed for the user
+type UserExportVaultKey struct {
+	Team      string      `json:"team"`
+	Vault     string      `json:"vault"`
+	Key       Sealed      `json:"key"`
+	ExpiresAt pq.NullTime `json:"expires_at,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// UserExportInvite is a pending invite to a team for the email of the user
+type UserExportInvite struct {
+	Team      string    `json:"team"`
+	Message   string    `json:"message,omitempty"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserExportPendingAccess is a role or vaults proposed to the user that wait for an admin of the team
+type UserExportPendingAccess struct {
+	Team string `json:"team"`
+	*PendingAccess
+}
+
+// UserExportHealthReport is the last password health report the user sent for a team
+type UserExportHealthReport struct {
+	Team string `json:"team"`
+	*HealthReport
+}
+
+// UserExport has what the db keeps about the user besides the audit entries. Confirmation tokens are left out as
+// they are credentials
+type UserExport struct {
+	Profile           *User                     `json:"profile"`
+	Keys              []byte                    `json:"keys"`
+	Preferences       json.RawMessage           `json:"preferences"`
+	Teams             []UserExportTeam          `json:"teams"`
+	VaultKeys         []UserExportVaultKey      `json:"vault_keys"`
+	Invites           []UserExportInvite        `json:"invites"`
+	PendingAccesses   []UserExportPendingAccess `json:"pending_accesses"`
+	HealthReports     []UserExportHealthReport  `json:"health_reports"`
+	DeviceRevocations []*DeviceRevocation       `json:"device_revocations"`
+}
+
+// Export collects the data of the user in one transaction so every part is consistent with the others
+func (u *User) Export(ctx context.Context) (ue *UserExport, err error) {
+	return ue, doTx(ctx, func(tx *sql.Tx) error {
+		ue = &UserExport{
+			Profile:         u,
+			Keys:            u.Key,
+			Teams:           []UserExportTeam{},
+			VaultKeys:       []UserExportVaultKey{},
+			Invites:         []UserExportInvite{},
+			PendingAccesses: []UserExportPendingAccess{},
+			HealthReports:   []UserExportHealthReport{},
+		}
+		up, _, err := findUserPreferences(tx, u.Id, false)
+		if err != nil {
+			return err
+		}
+		ue.Preferences = json.RawMessage(up.Data)
+		if err := queryExport(tx, func(rows *sql.Rows) error {
+			et := UserExportTeam{}
+			if err := rows.Scan(&et.Id, &et.Name, &et.Owner, &et.Admin); err != nil {
+				return err
+			}
+			ue.Teams = append(ue.Teams, et)
+			return nil
+		}, `SELECT "team"."id", "team"."name", "team"."owner" = $1, "team_user"."admin" FROM "team", "team_user"
+			WHERE "team"."id" = "team_user"."team" AND "team_user"."user" = $1 ORDER BY "team"."id"`, u.Id); err != nil {
+			return err
+		}
+		if err := queryExport(tx, func(rows *sql.Rows) error {
+			vk := UserExportVaultKey{}
+			if err := rows.Scan(&vk.Team, &vk.Vault, &vk.Key, &vk.ExpiresAt, &vk.CreatedAt, &vk.UpdatedAt); err != nil {
+				return err
+			}
+			if err := vk.Key.open(vaultKeyRow(vk.Team, vk.Vault, u.Id)); err != nil {
+				return err
+			}
+			ue.VaultKeys = append(ue.VaultKeys, vk)
+			return nil
+		}, `SELECT "team", "vault", "key", "expires_at", "created_at", "updated_at" FROM "vault_user" WHERE "user" = $1 AND `+activeVaultUser+` ORDER BY "team", "vault"`, u.Id); err != nil {
+			return err
+		}
+		if err := queryExport(tx, func(rows *sql.Rows) error {
+			ei := UserExportInvite{}
+			if err := rows.Scan(&ei.Team, &ei.Message, &ei.Role, &ei.CreatedAt); err != nil {
+				return err
+			}
+			ue.Invites = append(ue.Invites, ei)
+			return nil
+		}, `SELECT "team", "message", "role", "created_at" FROM "invite" WHERE "email" = $1 ORDER BY "team"`, u.Email); err != nil {
+			return err
+		}
+		rows, err := tx.Query(`SELECT `+selectPendingAccessFields+` FROM "pending_access" WHERE "user" = $1 ORDER BY "team"`, u.Id)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		pas, err := scanPendingAccesss(rows)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		for _, pa := range pas {
+			ue.PendingAccesses = append(ue.PendingAccesses, UserExportPendingAccess{pa.Team, pa})
+		}
+		rows, err = tx.Query(`SELECT `+selectHealthReportFields+` FROM "health_report" WHERE "user" = $1 ORDER BY "team"`, u.Id)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		hrs, err := scanHealthReports(rows)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		for _, hr := range hrs {
+			ue.HealthReports = append(ue.HealthReports, UserExportHealthReport{hr.Team, hr})
+		}
+		rows, err = tx.Query(`SELECT `+selectDeviceRevocationFields+` FROM "device_revocation" WHERE "user" = $1 ORDER BY "created_at"`, u.Id)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		ue.DeviceRevocations, err = scanDeviceRevocations(rows)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+func queryExport(tx *sql.Tx, scan func(*sql.Rows) error, query string, args ...interface{}) error {
+	rows, err := tx.Query(query, args...)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+	}
+	err = rows.Err()
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
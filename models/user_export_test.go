@@ -0,0 +1,86 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestUserExport(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	member := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	ue, err := member.Export(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, et := range ue.Teams {
+		found = found || (et.Id == team.Id && !et.Owner && !et.Admin)
+	}
+	if ue.Profile.Id != member.Id || len(ue.Keys) == 0 || !found || string(ue.Preferences) != "{}" {
+		t.Fatalf("Unexpected export %#v", ue)
+	}
+	for _, ae := range []*AuditEntry{
+		{Actor: owner.Id, Action: "test.export", Object: "team:" + team.Id + "/user:" + member.Id},
+		{Actor: owner.Id, Action: "test.export", Object: "team:" + team.Id + "/user:" + member.Id + "x"},
+		{Actor: member.Id, Action: "test.export", Object: "team:" + team.Id},
+	} {
+		if err := RecordAuditEntry(ctx, ae); err != nil {
+			t.Fatal(err)
+		}
+	}
+	about := 0
+	if err := ForEachAuditEntryAbout(ctx, member.Id, func(ae *AuditEntry) error {
+		if ae.Action == "test.export" {
+			about++
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if about != 2 {
+		t.Fatalf("Expected 2 entries about the member and got %d", about)
+	}
+}
+
+func TestUserExportSkipsExpiredVaultKeys(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(owner, team)
+	member := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.AddUsersUntil(ctx, map[string][]byte{member.Id: sealVaultKey(vm.v, vm.priv)}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	exported := func() bool {
+		ue, err := member.Export(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, vk := range ue.VaultKeys {
+			if vk.Vault == vm.v.Id {
+				return true
+			}
+		}
+		return false
+	}
+	if !exported() {
+		t.Fatal("The vault key of the member is not in the export")
+	}
+	//Expired access stays until the expiry job removes it but its key is not handed out anymore
+	if err := doTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE "vault_user" SET "expires_at" = $1 WHERE "team" = $2 AND "vault" = $3 AND "user" = $4`, time.Now().Add(-time.Minute), team.Id, vm.v.Id, member.Id)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if exported() {
+		t.Fatal("The expired vault key of the member is in the export")
+	}
+}
@@ -13,8 +13,10 @@ type UserFull struct {
 }
 
 func (u *User) GetUserFull(ctx context.Context) (*UserFull, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
 	cmd := fmt.Sprintf(`SELECT %s FROM "team", "team_user" WHERE "team"."id" = "team_user"."team" AND "team_user"."user" = $1`, selectTeamFullFields)
-	rows, err := GetDB(ctx).Query(cmd, u.Id)
+	rows, err := GetDB(ctx).QueryContext(ctx, cmd, u.Id)
 	if isErrOrPanic(err) {
 		return nil, util.NewErrorFrom(err)
 	}
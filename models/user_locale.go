@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+	//The server runs from scratch images without the zoneinfo of the system
+	_ "time/tzdata"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+var reValidLocale = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// ValidLocale checks if the locale looks like a language tag such as en or pt-BR
+func ValidLocale(locale string) bool {
+	return len(locale) <= 35 && reValidLocale.MatchString(locale)
+}
+
+// ValidTimezone checks if the timezone is in the IANA database such as Europe/Madrid. Local is not accepted
+func ValidTimezone(tz string) bool {
+	if len(tz) == 0 || tz == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(tz)
+	return err == nil
+}
+
+// CheckLocale fails with a field error for the locale or the timezone that isn't empty nor valid
+func CheckLocale(locale, timezone string) error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(locale) > 0 && !ValidLocale(locale) {
+		errs.SetFieldError("locale", "invalid")
+	}
+	if len(timezone) > 0 && !ValidTimezone(timezone) {
+		errs.SetFieldError("timezone", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+// SetLocale changes the locale and the timezone of the user that are not nil. An empty string unsets them
+func (u *User) SetLocale(ctx context.Context, locale, timezone *string) error {
+	nu := *u
+	if locale != nil {
+		nu.Locale = strings.TrimSpace(*locale)
+	}
+	if timezone != nil {
+		nu.Timezone = strings.TrimSpace(*timezone)
+	}
+	if err := CheckLocale(nu.Locale, nu.Timezone); err != nil {
+		return err
+	}
+	nu.UpdatedAt = time.Now().UTC()
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE "user" SET "locale" = $1, "timezone" = $2, "updated_at" = $3 WHERE "id" = $4`, nu.Locale, nu.Timezone, nu.UpdatedAt, nu.Id)
+		return treatUpdateErr(res, err)
+	})
+	if err != nil {
+		return err
+	}
+	*u = nu
+	return nil
+}
+
+// Location is the timezone of the user or UTC if it hasn't picked one
+func (u *User) Location() *time.Location {
+	if len(u.Timezone) > 0 {
+		if loc, err := time.LoadLocation(u.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
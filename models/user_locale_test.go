@@ -0,0 +1,41 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestSetLocale(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	if u.Location() != time.UTC {
+		t.Fatalf("Expected UTC for users without a timezone and got %s", u.Location())
+	}
+	bad, tz := "not a locale", "Mars/Olympus"
+	if err := u.SetLocale(ctx, &bad, nil); !util.CheckFieldErr(err, "locale", "invalid") {
+		t.Fatalf("Expected an invalid locale and got %s", err)
+	}
+	if err := u.SetLocale(ctx, nil, &tz); !util.CheckFieldErr(err, "timezone", "invalid") {
+		t.Fatalf("Expected an invalid timezone and got %s", err)
+	}
+	locale, tz := "pt-BR", "Europe/Lisbon"
+	if err := u.SetLocale(ctx, &locale, &tz); err != nil {
+		t.Fatal(err)
+	}
+	fu, err := FindUser(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fu.Locale != locale || fu.Timezone != tz || fu.Location().String() != tz {
+		t.Fatalf("Unexpected locale %s and timezone %s", fu.Locale, fu.Timezone)
+	}
+	empty := ""
+	if err := fu.SetLocale(ctx, nil, &empty); err != nil {
+		t.Fatal(err)
+	}
+	if fu.Locale != locale || fu.Location() != time.UTC {
+		t.Fatalf("Only the timezone should have been unset: %s %s", fu.Locale, fu.Timezone)
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// RecordLoginAttempt counts a login of the user at now in FailedAttempts before its password is checked, so
+// concurrent attempts can't all pass the login policy. The row is locked and loaded in the user and allow can refuse
+// the attempt without counting it. If the previous failure was before forgetBefore the count starts again. A
+// successful login clears the count afterwards
+func (u *User) RecordLoginAttempt(ctx context.Context, now, forgetBefore time.Time, allow func(*User) error) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT "failed_attempts", "failed_at" FROM "user" WHERE "id" = $1 FOR UPDATE`, u.Id).Scan(&u.FailedAttempts, &u.FailedAt)
+		if isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		}
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if err := allow(u); err != nil {
+			return err
+		}
+		err = tx.QueryRow(`UPDATE "user" SET "failed_attempts" = CASE WHEN "failed_at" IS NULL OR "failed_at" < $1 THEN 1 ELSE "failed_attempts" + 1 END,
+			"failed_at" = $2 WHERE "id" = $3 RETURNING "failed_attempts"`, forgetBefore, now, u.Id).Scan(&u.FailedAttempts)
+		isErrOrPanic(err)
+		if err == nil {
+			u.FailedAt = nullTime(now)
+		}
+		return util.NewErrorFrom(err)
+	})
+}
+
+// ClearFailedLogins forgets the failed logins of the user after it logs in or an admin clears them
+func (u *User) ClearFailedLogins(ctx context.Context) error {
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE "user" SET "failed_attempts" = 0, "failed_at" = NULL WHERE "id" = $1 AND "failed_attempts" > 0`, u.Id)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+	if err != nil {
+		return err
+	}
+	u.FailedAttempts = 0
+	u.FailedAt = nullTime(time.Time{})
+	return nil
+}
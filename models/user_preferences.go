@@ -0,0 +1,90 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const USER_PREFERENCES_MAX_SIZE = 16 * 1024
+
+// UserPreferences are the settings of the clients of a user, like the theme or the default team. The server doesn't
+// look into them besides checking they are a json object
+type UserPreferences struct {
+	User      string    `scaneo:"pk" json:"-"`
+	Data      []byte    `json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (up *UserPreferences) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(up.Data) > USER_PREFERENCES_MAX_SIZE {
+		errs.SetFieldError("preferences", "too large")
+	} else if !json.Valid(up.Data) || !bytes.HasPrefix(up.Data, []byte("{")) {
+		errs.SetFieldError("preferences", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+func findUserPreferences(tx *sql.Tx, user string, lock bool) (*UserPreferences, bool, error) {
+	up := &UserPreferences{User: user}
+	query := `SELECT ` + selectUserPreferencesFields + ` FROM "user_preferences" WHERE ` + findUserPreferencesCondition
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	err := up.dbScanRow(tx.QueryRow(query, user))
+	if isNotExistsErr(err) {
+		//Users that never stored anything get an empty object
+		return &UserPreferences{User: user, Data: []byte("{}")}, false, nil
+	}
+	if isErrOrPanic(err) {
+		return nil, false, util.NewErrorFrom(err)
+	}
+	return up, true, nil
+}
+
+// FindUserPreferences returns the preferences of the user. They are an empty object until something is stored
+func FindUserPreferences(ctx context.Context, user string) (up *UserPreferences, err error) {
+	return up, doTx(ctx, func(tx *sql.Tx) error {
+		up, _, err = findUserPreferences(tx, user, false)
+		return err
+	})
+}
+
+// UpdateUserPreferences replaces the preferences of the user. check gets the current ones with their row locked so a
+// concurrent update can't slip in between
+func UpdateUserPreferences(ctx context.Context, user string, data []byte, check func(*UserPreferences) error) (*UserPreferences, error) {
+	compact := &bytes.Buffer{}
+	if err := json.Compact(compact, data); err != nil {
+		errs := util.NewErrorFields().(*util.Error)
+		errs.SetFieldError("preferences", "invalid")
+		return nil, errs.SetErrorOrCamo(ErrInvalidAttributes)
+	}
+	up := &UserPreferences{User: user, Data: compact.Bytes(), UpdatedAt: time.Now().UTC()}
+	if err := up.validate(); err != nil {
+		return nil, err
+	}
+	return up, doTx(ctx, func(tx *sql.Tx) error {
+		current, found, err := findUserPreferences(tx, user, true)
+		if err != nil {
+			return err
+		}
+		if err := check(current); err != nil {
+			return err
+		}
+		if found {
+			return treatUpdateErr(up.dbUpdate(tx))
+		}
+		_, err = up.dbInsert(tx)
+		if IsDuplicateErr(err) {
+			//Somebody else stored the first preferences of the user at the same time
+			return util.NewErrorFrom(ErrConflict)
+		}
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	USER_FULLNAME_MAX_LENGTH = 100
+	USER_PRONOUNS_MAX_LENGTH = 40
+	USER_TITLE_MAX_LENGTH    = 100
+)
+
+// UpdateProfile changes the fields of the profile that are not nil. Those are shown to the other members of the teams
+func (u *User) UpdateProfile(ctx context.Context, fullname, pronouns, title *string) error {
+	nu := *u
+	if fullname != nil {
+		nu.FullName = strings.TrimSpace(*fullname)
+	}
+	if pronouns != nil {
+		nu.Pronouns = strings.TrimSpace(*pronouns)
+	}
+	if title != nil {
+		nu.Title = strings.TrimSpace(*title)
+	}
+	errs := util.NewErrorFields().(*util.Error)
+	if len(nu.FullName) == 0 {
+		errs.SetFieldError("fullname", "invalid")
+	} else if len(nu.FullName) > USER_FULLNAME_MAX_LENGTH {
+		errs.SetFieldError("fullname", "too long")
+	}
+	if len(nu.Pronouns) > USER_PRONOUNS_MAX_LENGTH {
+		errs.SetFieldError("pronouns", "too long")
+	}
+	if len(nu.Title) > USER_TITLE_MAX_LENGTH {
+		errs.SetFieldError("title", "too long")
+	}
+	if err := errs.SetErrorOrCamo(ErrInvalidAttributes); err != nil {
+		return err
+	}
+	nu.UpdatedAt = time.Now().UTC()
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE "user" SET "full_name" = $1, "pronouns" = $2, "title" = $3, "updated_at" = $4 WHERE "id" = $5`, nu.FullName, nu.Pronouns, nu.Title, nu.UpdatedAt, nu.Id)
+		return treatUpdateErr(res, err)
+	})
+	if err != nil {
+		return err
+	}
+	*u = nu
+	return nil
+}
+
+// SetAvatarAt records when the avatar of the user was uploaded. A zero time means the user has no avatar
+func (u *User) SetAvatarAt(ctx context.Context, at time.Time) error {
+	avatarAt := nullTime(at)
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE "user" SET "avatar_at" = $1 WHERE "id" = $2`, avatarAt, u.Id)
+		return treatUpdateErr(res, err)
+	})
+	if err != nil {
+		return err
+	}
+	u.AvatarAt = avatarAt
+	return nil
+}
+
+// SharesTeamWith tells if the user and the other one are in the same team. Only they can see each other's avatar
+func (u *User) SharesTeamWith(ctx context.Context, other string) (shared bool, err error) {
+	if u.Id == other {
+		return true, nil
+	}
+	return shared, doTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM "team_user" a JOIN "team_user" b ON a."team" = b."team" WHERE a."user" = $1 AND b."user" = $2)`, u.Id, other).Scan(&shared)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+// USER_ACTIVITY_RESOLUTION is how coarse the last activity of the users is
+const USER_ACTIVITY_RESOLUTION = time.Hour
+
+// TouchUserActivity records that the user was active at. The time is rounded down to USER_ACTIVITY_RESOLUTION so the
+// row is written at most once per period
+func TouchUserActivity(ctx context.Context, user string, at time.Time) error {
+	at = at.UTC().Truncate(USER_ACTIVITY_RESOLUTION)
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	_, err := GetDB(ctx).ExecContext(ctx, `UPDATE "user" SET "last_active_at" = $1 WHERE "id" = $2 AND ("last_active_at" IS NULL OR "last_active_at" < $1)`, at, user)
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
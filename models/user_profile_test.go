@@ -0,0 +1,81 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	empty, name, title := " ", "Another name", "Security"
+	if err := u.UpdateProfile(ctx, &empty, nil, nil); !util.CheckFieldErr(err, "fullname", "invalid") {
+		t.Fatalf("Expected an invalid fullname and got %s", err)
+	}
+	if err := u.UpdateProfile(ctx, &name, nil, &title); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.SetAvatarAt(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	fu, err := FindUser(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fu.FullName != name || fu.Title != title || fu.Pronouns != "" || !fu.AvatarAt.Valid {
+		t.Fatalf("Unexpected profile %#v", fu)
+	}
+	other := getDummyUser()
+	if shared, err := u.SharesTeamWith(ctx, other.Id); err != nil || shared {
+		t.Fatalf("Users without a team in common share one: %v %s", shared, err)
+	}
+	if shared, err := u.SharesTeamWith(ctx, u.Id); err != nil || !shared {
+		t.Fatalf("Users should see their own avatar: %v %s", shared, err)
+	}
+}
+
+func TestLastActiveOnlyForAdmins(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	invitee := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, invitee.Email); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	if err := TouchUserActivity(ctx, invitee.Id, now); err != nil {
+		t.Fatal(err)
+	}
+	//Older activity doesn't move it back
+	if err := TouchUserActivity(ctx, invitee.Id, now.Add(-2*USER_ACTIVITY_RESOLUTION)); err != nil {
+		t.Fatal(err)
+	}
+	fu, err := FindUser(ctx, invitee.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fu.LastActiveAt.Valid || !fu.LastActiveAt.Time.Equal(now.Truncate(USER_ACTIVITY_RESOLUTION)) {
+		t.Fatalf("Unexpected last activity %v for %s", fu.LastActiveAt, now)
+	}
+	lastActive := func(u *User) pq.NullTime {
+		tf, err := team.GetTeamFull(ctx, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tu := range tf.Users {
+			if tu.User == invitee.Id {
+				return tu.LastActiveAt
+			}
+		}
+		t.Fatalf("Invitee %s is not in the team", invitee.Id)
+		return pq.NullTime{}
+	}
+	if !lastActive(owner).Valid {
+		t.Fatal("Admins should see when the members were last active")
+	}
+	if lastActive(fu).Valid {
+		t.Fatal("Members that aren't admins shouldn't see when the others were last active")
+	}
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	USERNAME_MIN_LENGTH = 3
+	//Only enforced when registering so the existing users with longer ids keep working
+	USERNAME_MAX_LENGTH = 64
+	usernameCharacters  = `A-Za-z0-9_-`
+)
+
+var reValidUsername = regexp.MustCompile(fmt.Sprintf(`^[%s]{%d,}$`, usernameCharacters, USERNAME_MIN_LENGTH))
+
+// UsernameRules are the rules the ids of the new users have to follow. The pattern is valid in javascript too
+type UsernameRules struct {
+	MinLength int    `json:"min_length"`
+	MaxLength int    `json:"max_length"`
+	Pattern   string `json:"pattern"`
+}
+
+func GetUsernameRules() UsernameRules {
+	return UsernameRules{USERNAME_MIN_LENGTH, USERNAME_MAX_LENGTH, fmt.Sprintf(`^[%s]+$`, usernameCharacters)}
+}
+
+// ValidNewUsername checks if a new user can register with the id
+func ValidNewUsername(id string) bool {
+	return len(id) <= USERNAME_MAX_LENGTH && reValidUsername.MatchString(id)
+}
+
+func ValidEmail(email string) bool {
+	return reValidEmail.MatchString(email)
+}
+
+// UsernameAvailable checks if nobody has the id yet
+func UsernameAvailable(ctx context.Context, id string) (bool, error) {
+	_, err := FindUser(ctx, id)
+	return availableFromErr(err)
+}
+
+// EmailAvailable checks if no user has the email yet
+func EmailAvailable(ctx context.Context, email string) (bool, error) {
+	_, err := FindUserByEmail(ctx, email)
+	return availableFromErr(err)
+}
+
+func availableFromErr(err error) (bool, error) {
+	if util.CheckErr(err, ErrDoesntExist) {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidNewUsername(t *testing.T) {
+	rules := GetUsernameRules()
+	rePattern := regexp.MustCompile(rules.Pattern)
+	for id, valid := range map[string]bool{
+		"abc":                   true,
+		"a_b-c9":                true,
+		"ab":                    false,
+		"a b":                   false,
+		"ñandú":                 false,
+		strings.Repeat("a", 64): true,
+		strings.Repeat("a", 65): false,
+	} {
+		if ValidNewUsername(id) != valid {
+			t.Errorf("Expected %s to be valid %v", id, valid)
+		}
+		if inRules := rePattern.MatchString(id) && len(id) >= rules.MinLength && len(id) <= rules.MaxLength; inRules != valid {
+			t.Errorf("The rules disagree with the validation for %s", id)
+		}
+	}
+}
@@ -15,6 +15,10 @@ type Vault struct {
 	PublicKey []byte    `json:"public_key"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	//ApprovalRequired vaults only get new members once a second admin approves them
+	ApprovalRequired bool `json:"approval_required"`
+	//RotationRequired is set when a member loses the access because it expired
+	RotationRequired bool `json:"rotation_required"`
 }
 
 func createVault(tx *sql.Tx, id, team string, vkp VaultKeyPair) (*Vault, error) {
@@ -22,10 +26,9 @@ func createVault(tx *sql.Tx, id, team string, vkp VaultKeyPair) (*Vault, error)
 	if err := v.insert(tx); err != nil {
 		return nil, err
 	}
-	for u, k := range vkp.Keys {
-		if err := v.addUser(tx, u, k); err != nil {
-			return nil, err
-		}
+	//The vault is new so storing its keys doesn't bump the version
+	if err := insertVaultUsers(tx, v.Team, v.Id, vkp.Keys, time.Time{}); err != nil {
+		return nil, err
 	}
 	return v, nil
 }
@@ -86,41 +89,57 @@ func (v Vault) validate() error {
 }
 
 func (v Vault) AddUsers(ctx context.Context, userKeys map[string][]byte) error {
+	return v.AddUsersUntil(ctx, userKeys, time.Time{})
+}
+
+// AddUsersUntil shares the vault with the users until expiresAt. A zero expiresAt shares it until they are removed
+func (v Vault) AddUsersUntil(ctx context.Context, userKeys map[string][]byte, expiresAt time.Time) error {
 	for _, k := range userKeys {
-		if _, err := verifyAndUnpack(v.PublicKey, k); err != nil {
+		if err := checkWrappedKey("vault_key", v.PublicKey, k); err != nil {
 			return err
 		}
 	}
-	return doTx(ctx, func(tx *sql.Tx) error {
-		t := &Team{Id: v.Team}
-		users, err := t.getUsers(tx)
-		if err != nil {
+	return doConflictTx(ctx, func(tx *sql.Tx) error {
+		if err := v.checkTeamMembers(tx, userKeys); err != nil {
 			return err
 		}
-		for uk := range userKeys {
-			found := false
-			for _, user := range users {
-				if user.Id == uk {
-					found = true
-					break
-				}
-			}
-			if !found {
-				return util.NewErrorFrom(ErrNotInTeam)
-			}
+		if len(userKeys) == 0 {
+			return nil
 		}
-		for u, k := range userKeys {
-			if err := v.addUser(tx, u, k); err != nil {
-				if IsDuplicateErr(err) {
-					return util.NewErrorFrom(ErrAlreadyExists)
-				}
-				return err
-			}
+		//The policy is checked again in the transaction so a vault can't get members while it's being enabled
+		if required, err := v.approvalRequired(tx); err != nil {
+			return err
+		} else if required {
+			return util.NewErrorFrom(ErrApprovalRequired)
 		}
-		return nil
+		if err := v.update(tx); err != nil {
+			return err
+		}
+		return insertVaultUsers(tx, v.Team, v.Id, userKeys, expiresAt)
 	})
 }
 
+func (v Vault) checkTeamMembers(tx *sql.Tx, userKeys map[string][]byte) error {
+	t := &Team{Id: v.Team}
+	users, err := t.getUsers(tx)
+	if err != nil {
+		return err
+	}
+	for uk := range userKeys {
+		found := false
+		for _, user := range users {
+			if user.Id == uk {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return util.NewErrorFrom(ErrNotInTeam)
+		}
+	}
+	return nil
+}
+
 func (v Vault) GetUserIds(ctx context.Context) (uids []string, err error) {
 	return uids, doTx(ctx, func(tx *sql.Tx) error {
 		uids, err = v.getUserIds(tx)
@@ -149,6 +168,12 @@ func (v Vault) getUserIds(tx *sql.Tx) ([]string, error) {
 }
 
 func (v Vault) addUser(tx *sql.Tx, username string, key []byte) error {
+	if err := checkUserKeysNotRevoked(tx, []string{username}); err != nil {
+		return err
+	}
+	if err := checkNoAuditors(tx, v.Team, []string{username}); err != nil {
+		return err
+	}
 	if err := v.update(tx); err != nil {
 		return err
 	}
@@ -157,7 +182,15 @@ func (v Vault) addUser(tx *sql.Tx, username string, key []byte) error {
 }
 
 func (v Vault) RemoveUser(ctx context.Context, username string) error {
-	return doTx(ctx, func(tx *sql.Tx) error {
+	_, err := v.RemoveUserWithReport(ctx, username, false)
+	return err
+}
+
+// RemoveUserWithReport removes the user from the vault and reports what has been removed. With dryRun nothing is removed.
+// Team admins are never removed so their report is empty
+func (v Vault) RemoveUserWithReport(ctx context.Context, username string, dryRun bool) (cr *ChangeReport, err error) {
+	return cr, doDryRunTx(ctx, dryRun, func(tx *sql.Tx) error {
+		cr = NewChangeReport(dryRun)
 		t := &Team{Id: v.Team}
 		tu, err := t.getUserAffiliation(tx, username)
 		if err != nil {
@@ -166,7 +199,18 @@ func (v Vault) RemoveUser(ctx context.Context, username string) error {
 		if tu.Admin {
 			return util.NewErrorFrom(err)
 		}
-		return v.removeUser(tx, username)
+		if err := v.removeUser(tx, username); err != nil {
+			return err
+		}
+		cr.Add(AffectedRows{Kind: "vault_user", Count: 1, Ids: []string{v.Team + "/" + v.Id + "/" + username}})
+		//Nobody else holds the key of the vault so the orphan collection removes it afterwards
+		ar, err := queryAffected(tx, "vault_without_users", `SELECT v."team" || '/' || v."id" FROM "vault" v WHERE v."team" = $1 AND v."id" = $2 AND
+			NOT EXISTS (SELECT 1 FROM "vault_user" vu WHERE vu."team" = v."team" AND vu."vault" = v."id")`, v.Team, v.Id)
+		if err != nil {
+			return err
+		}
+		cr.Add(ar)
+		return nil
 	})
 }
 
@@ -192,7 +236,7 @@ func (v *Vault) AddSecret(ctx context.Context, s *Secret) error {
 func (v *Vault) addSecret(tx *sql.Tx, s *Secret) error {
 	s.Team = v.Team
 	s.Vault = v.Id
-	if _, err := verifyAndUnpack(v.PublicKey, s.Data); err != nil {
+	if err := checkSecretData(v.PublicKey, s.Data); err != nil {
 		return err
 	}
 	if err := v.update(tx); err != nil {
@@ -206,7 +250,7 @@ func (v *Vault) AddSecretList(ctx context.Context, sl []*Secret) error {
 	for _, s := range sl {
 		s.Team = v.Team
 		s.Vault = v.Id
-		if _, err := verifyAndUnpack(v.PublicKey, s.Data); err != nil {
+		if err := checkSecretData(v.PublicKey, s.Data); err != nil {
 			return err
 		}
 	}
@@ -233,8 +277,7 @@ func (v *Vault) AddSecretList(ctx context.Context, sl []*Secret) error {
 }
 
 func (v *Vault) UpdateSecret(ctx context.Context, s *Secret) error {
-	_, err := verifyAndUnpack(v.PublicKey, s.Data)
-	if err != nil {
+	if err := checkSecretData(v.PublicKey, s.Data); err != nil {
 		return err
 	}
 	return doTx(ctx, func(tx *sql.Tx) error {
@@ -249,6 +292,7 @@ func (v *Vault) UpdateSecret(ctx context.Context, s *Secret) error {
 		s.Vault = os.Vault
 		s.Version = os.Version + 1
 		s.VaultVersion = v.Version
+		s.ArchivedAt = os.ArchivedAt
 		return s.update(tx)
 	})
 }
@@ -264,30 +308,45 @@ func (v *Vault) deleteSecret(tx *sql.Tx, sid string) error {
 		return err
 	}
 	res, err := tx.Exec(`DELETE FROM "secret" WHERE "secret"."id" = $1`, sid)
-	return treatUpdateErr(res, err)
+	if err := treatUpdateErr(res, err); err != nil {
+		return err
+	}
+	if err := v.deleteSecretPin(tx, sid); err != nil {
+		return err
+	}
+	return v.deleteSecretLabels(tx, sid)
 }
 
 func (v Vault) GetSecrets(ctx context.Context) ([]*Secret, error) {
-	db := GetDB(ctx)
+	secrets := make([]*Secret, 0, 16)
+	return secrets, v.ForEachSecret(ctx, func(s *Secret) error {
+		secrets = append(secrets, s)
+		return nil
+	})
+}
+
+// ForEachSecret calls fn with the last version of every secret in the vault as the rows are scanned
+func (v Vault) ForEachSecret(ctx context.Context, fn func(*Secret) error) error {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	db := getReadDB(ctx)
 	query := `
 		SELECT DISTINCT ON ("secret"."team", "secret"."vault", "secret"."id") ` + selectSecretFullFields + ` 
 		FROM "secret" WHERE "secret"."team" = $1 AND "secret"."vault" = $2 
 		ORDER BY "secret"."team", "secret"."vault", "secret"."id", "secret"."version" DESC`
-	rows, err := db.Query(query, v.Team, v.Id)
+	rows, err := db.QueryContext(ctx, query, v.Team, v.Id)
 	if isErrOrPanic(err) {
-		return nil, util.NewErrorFrom(err)
-	}
-	secrets, err := scanSecrets(rows)
-	if isErrOrPanic(err) {
-		return nil, util.NewErrorFrom(err)
+		return util.NewErrorFrom(err)
 	}
-	return secrets, nil
+	return forEachSecret(rows, fn)
 }
 
 func (v Vault) GetSecretsAllVersions(ctx context.Context) ([]*Secret, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
 	db := GetDB(ctx)
 	query := `SELECT` + selectSecretFullFields + ` FROM "secret" WHERE "secret"."team" = $1 AND "secret"."vault" = $2`
-	rows, err := db.Query(query, v.Team, v.Id)
+	rows, err := db.QueryContext(ctx, query, v.Team, v.Id)
 	if isErrOrPanic(err) {
 		return nil, util.NewErrorFrom(err)
 	}
@@ -305,6 +364,24 @@ func (v Vault) GetSecret(ctx context.Context, sid string) (s *Secret, err error)
 	})
 }
 
+// GetSecretVersion returns a version of a secret. Every version is kept until the secret is deleted or a newer one
+// has been around for longer than the retention of the versions
+func (v Vault) GetSecretVersion(ctx context.Context, sid string, version uint32) (*Secret, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	db := getReadDB(ctx)
+	s := &Secret{Id: sid}
+	r := db.QueryRowContext(ctx, `SELECT `+selectSecretFields+` FROM "secret" WHERE "secret"."team" = $1 AND "secret"."vault" = $2 AND "secret"."id" = $3 AND "secret"."version" = $4`, v.Team, v.Id, sid, version)
+	err := s.dbScanRow(r)
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return s, nil
+}
+
 func (v Vault) getSecret(tx *sql.Tx, sid string) (*Secret, error) {
 	s := &Secret{Id: sid}
 	r := tx.QueryRow(`SELECT `+selectSecretFields+` FROM "secret" WHERE "secret"."team" = $1 AND "secret"."vault" = $2 AND "secret"."id" = $3 ORDER BY "secret"."version" DESC LIMIT 1`, v.Team, v.Id, sid)
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// VaultAccess is a member of a vault with an access that expires
+type VaultAccess struct {
+	Team      string    `json:"team"`
+	Vault     string    `json:"vault"`
+	User      string    `json:"user"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func scanVaultAccesses(rows *sql.Rows) ([]*VaultAccess, error) {
+	defer rows.Close()
+	vas := []*VaultAccess{}
+	for rows.Next() {
+		va := &VaultAccess{}
+		if err := rows.Scan(&va.Team, &va.Vault, &va.User, &va.ExpiresAt); err != nil {
+			return nil, util.NewErrorFrom(err)
+		}
+		vas = append(vas, va)
+	}
+	if err := rows.Err(); isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return vas, nil
+}
+
+// ExpireVaultUsers removes the members whose access expired before now and flags their vaults for rotation since they
+// could have kept the vault key
+func ExpireVaultUsers(ctx context.Context, now time.Time) (vas []*VaultAccess, err error) {
+	return vas, doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`DELETE FROM "vault_user" WHERE "expires_at" <= $1 RETURNING "team", "vault", "user", "expires_at"`, now)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if vas, err = scanVaultAccesses(rows); err != nil {
+			return err
+		}
+		for _, va := range vas {
+			_, err := tx.Exec(`UPDATE "vault" SET "rotation_required" = true WHERE "team" = $1 AND "id" = $2`, va.Team, va.Vault)
+			if isErrOrPanic(err) {
+				return util.NewErrorFrom(err)
+			}
+		}
+		return nil
+	})
+}
+
+// ClaimExpiringVaultUsers returns the members whose access expires before the time and that haven't been notified yet.
+// They are marked as notified so each one is only returned once
+func ClaimExpiringVaultUsers(ctx context.Context, before time.Time) (vas []*VaultAccess, err error) {
+	return vas, doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`UPDATE "vault_user" SET "expiry_notified" = true WHERE "expires_at" <= $1 AND NOT "expiry_notified" RETURNING "team", "vault", "user", "expires_at"`, before)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		vas, err = scanVaultAccesses(rows)
+		return err
+	})
+}
+
+// GetExpiringUsers returns the members of the vault whose access expires
+func (v Vault) GetExpiringUsers(ctx context.Context) (vas []*VaultAccess, err error) {
+	return vas, doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT "team", "vault", "user", "expires_at" FROM "vault_user" WHERE "team" = $1 AND "vault" = $2 AND "expires_at" IS NOT NULL ORDER BY "expires_at"`, v.Team, v.Id)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		vas, err = scanVaultAccesses(rows)
+		return err
+	})
+}
+
+// MarkRotated clears the rotation flag once the secrets have been moved to a vault with a new key
+func (v *Vault) MarkRotated(ctx context.Context) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		v.UpdatedAt = time.Now().UTC()
+		res, err := tx.Exec(`UPDATE "vault" SET "rotation_required" = false, "updated_at" = $1 WHERE "team" = $2 AND "id" = $3`, v.UpdatedAt, v.Team, v.Id)
+		if err := treatUpdateErr(res, err); err != nil {
+			return err
+		}
+		v.RotationRequired = false
+		return nil
+	})
+}
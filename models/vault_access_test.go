@@ -0,0 +1,80 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func findVaultAccess(vas []*VaultAccess, vault, user string) *VaultAccess {
+	for _, va := range vas {
+		if va.Vault == vault && va.User == user {
+			return va
+		}
+	}
+	return nil
+}
+
+func TestVaultAccessExpiry(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(owner, team)
+	invitee := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, invitee.Email); err != nil {
+		t.Fatal(err)
+	}
+	uk := map[string][]byte{invitee.Id: sealVaultKey(vm.v, vm.priv)}
+	if err := vm.v.AddUsersUntil(ctx, uk, time.Now().Add(-time.Hour)); err == nil {
+		t.Fatal("Added a member whose access already expired")
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	if err := vm.v.AddUsersUntil(ctx, uk, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	vas, err := vm.v.GetExpiringUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vas) != 1 || vas[0].User != invitee.Id {
+		t.Fatalf("Unexpected expiring members %#v", vas)
+	}
+	if vas, err = ClaimExpiringVaultUsers(ctx, expiresAt.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if findVaultAccess(vas, vm.v.Id, invitee.Id) == nil {
+		t.Fatal("The expiring member has not been claimed")
+	}
+	if vas, err = ClaimExpiringVaultUsers(ctx, expiresAt.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if findVaultAccess(vas, vm.v.Id, invitee.Id) != nil {
+		t.Fatal("The expiring member has been claimed twice")
+	}
+	if _, err := team.GetVaultForUser(ctx, vm.v.Id, invitee); err != nil {
+		t.Fatal(err)
+	}
+	if vas, err = ExpireVaultUsers(ctx, expiresAt.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if findVaultAccess(vas, vm.v.Id, invitee.Id) == nil {
+		t.Fatal("The member has not been expired")
+	}
+	if _, err := team.GetVaultForUser(ctx, vm.v.Id, invitee); err == nil {
+		t.Fatal("The expired member can still get the vault")
+	}
+	v, err := team.GetVaultForUser(ctx, vm.v.Id, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.RotationRequired {
+		t.Fatal("The vault has not been flagged for rotation")
+	}
+	if err := v.MarkRotated(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = team.GetVaultForUser(ctx, vm.v.Id, owner); err != nil {
+		t.Fatal(err)
+	}
+	if v.RotationRequired {
+		t.Fatal("The rotation flag has not been cleared")
+	}
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+// VaultGrant is the key of a new member of a vault that requires approval. Members fetch the keys from vault_user so
+// the key can't be used until another admin approves it and it's moved there
+type VaultGrant struct {
+	Team        string      `scaneo:"pk" json:"-"`
+	Vault       string      `scaneo:"pk" json:"vault"`
+	User        string      `scaneo:"pk" json:"user"`
+	Key         Sealed      `json:"-"`
+	RequestedBy string      `json:"requested_by"`
+	CreatedAt   time.Time   `json:"created_at"`
+	ExpiresAt   pq.NullTime `json:"expires_at,omitempty"`
+}
+
+func (v Vault) approvalRequired(tx *sql.Tx) (bool, error) {
+	required := false
+	err := tx.QueryRow(`SELECT "approval_required" FROM "vault" WHERE "team" = $1 AND "id" = $2 FOR UPDATE`, v.Team, v.Id).Scan(&required)
+	if isNotExistsErr(err) {
+		return false, util.NewErrorFrom(ErrDoesntExist)
+	}
+	isErrOrPanic(err)
+	return required, util.NewErrorFrom(err)
+}
+
+// SetApprovalRequired turns the two admin approval of the new members of the vault on or off
+func (v *Vault) SetApprovalRequired(ctx context.Context, required bool) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		v.UpdatedAt = time.Now().UTC()
+		res, err := tx.Exec(`UPDATE "vault" SET "approval_required" = $1, "updated_at" = $2 WHERE "team" = $3 AND "id" = $4`, required, v.UpdatedAt, v.Team, v.Id)
+		if err := treatUpdateErr(res, err); err != nil {
+			return err
+		}
+		v.ApprovalRequired = required
+		return nil
+	})
+}
+
+// RequestGrants keeps the keys of the new members until another admin approves them. Once approved they have access
+// until expiresAt if it isn't zero
+func (v Vault) RequestGrants(ctx context.Context, requester string, userKeys map[string][]byte, expiresAt time.Time) (vgs []*VaultGrant, err error) {
+	for _, k := range userKeys {
+		if err := checkWrappedKey("vault_key", v.PublicKey, k); err != nil {
+			return nil, err
+		}
+	}
+	return vgs, doConflictTx(ctx, func(tx *sql.Tx) error {
+		vgs, err = v.requestGrants(tx, requester, userKeys, expiresAt)
+		return err
+	})
+}
+
+func (v Vault) requestGrants(tx *sql.Tx, requester string, userKeys map[string][]byte, expiresAt time.Time) ([]*VaultGrant, error) {
+	if err := v.checkTeamMembers(tx, userKeys); err != nil {
+		return nil, err
+	}
+	members, err := v.getUserIds(tx)
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]string, 0, len(userKeys))
+	for uid := range userKeys {
+		for _, member := range members {
+			if member == uid {
+				return nil, util.NewErrorf("User %s is already in vault", uid)
+			}
+		}
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	if err := checkUserKeysNotRevoked(tx, uids); err != nil {
+		return nil, err
+	}
+	if err := checkNoAuditors(tx, v.Team, uids); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return nil, util.NewErrorFrom(ErrInvalidAttributes)
+	}
+	vgs := make([]*VaultGrant, len(uids))
+	for i, uid := range uids {
+		vgs[i] = &VaultGrant{Team: v.Team, Vault: v.Id, User: uid, Key: userKeys[uid], RequestedBy: requester, CreatedAt: now, ExpiresAt: nullTime(expiresAt)}
+		if len(vgs[i].Key) != privateKeyPackSize {
+			return nil, util.NewErrorFrom(ErrInvalidKeys)
+		}
+		var err error
+		if serr := writeSealed(&vgs[i].Key, vgs[i].keyRow(), func() error {
+			_, err = vgs[i].dbInsert(tx)
+			return nil
+		}); serr != nil {
+			return nil, serr
+		}
+		if IsDuplicateErr(err) {
+			return nil, util.NewErrorFrom(ErrAlreadyExists)
+		}
+		if isErrOrPanic(err) {
+			return nil, util.NewErrorFrom(err)
+		}
+	}
+	return vgs, nil
+}
+
+// GetGrants returns the members waiting for approval
+func (v Vault) GetGrants(ctx context.Context) (vgs []*VaultGrant, err error) {
+	return vgs, doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+selectVaultGrantFields+` FROM "vault_grant" WHERE "team" = $1 AND "vault" = $2 ORDER BY "created_at"`, v.Team, v.Id)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		vgs, err = scanVaultGrants(rows)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		for _, vg := range vgs {
+			if err := vg.Key.open(vg.keyRow()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// keyRow binds the sealed key of the grant to it
+func (vg *VaultGrant) keyRow() []byte {
+	return sealedRow("vault_grant", "key", vg.Team, vg.Vault, vg.User)
+}
+
+// ApproveGrant gives the key of the grant to the member. The admin that requested it can't approve it
+func (v *Vault) ApproveGrant(ctx context.Context, approver, user string) (vg *VaultGrant, err error) {
+	return vg, doConflictTx(ctx, func(tx *sql.Tx) error {
+		vg = &VaultGrant{Team: v.Team, Vault: v.Id, User: user}
+		if err := vg.dbFind(tx); isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if err := vg.Key.open(vg.keyRow()); err != nil {
+			return err
+		}
+		if vg.RequestedBy == approver {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		//Grants that expired while they were pending can only be rejected
+		if vg.ExpiresAt.Valid && !vg.ExpiresAt.Time.After(time.Now()) {
+			return util.NewErrorf("The grant expired at %s", vg.ExpiresAt.Time.Format(time.RFC3339))
+		}
+		//Removing it first makes sure a grant is only approved once
+		if err := treatUpdateErr(vg.dbDelete(tx)); err != nil {
+			return err
+		}
+		if err := v.update(tx); err != nil {
+			return err
+		}
+		return insertVaultUsers(tx, v.Team, v.Id, map[string][]byte{vg.User: vg.Key}, vg.ExpiresAt.Time)
+	})
+}
+
+// RejectGrant drops the key of the grant without giving it to the member
+func (v Vault) RejectGrant(ctx context.Context, user string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		return treatUpdateErr((&VaultGrant{Team: v.Team, Vault: v.Id, User: user}).dbDelete(tx))
+	})
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestVaultGrantApproval(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(owner, team)
+	if err := vm.v.SetApprovalRequired(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	invitee := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, invitee.Email); err != nil {
+		t.Fatal(err)
+	}
+	uk := map[string][]byte{invitee.Id: sealVaultKey(vm.v, vm.priv)}
+	if err := vm.v.AddUsers(ctx, uk); !util.CheckErr(err, ErrApprovalRequired) {
+		t.Fatalf("Expected %s and got %s", ErrApprovalRequired, err)
+	}
+	vgs, err := vm.v.RequestGrants(ctx, owner.Id, uk, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vgs) != 1 || vgs[0].User != invitee.Id || vgs[0].RequestedBy != owner.Id {
+		t.Fatalf("Unexpected grants %#v", vgs)
+	}
+	if _, err := vm.v.RequestGrants(ctx, owner.Id, uk, time.Time{}); !util.CheckErr(err, ErrAlreadyExists) {
+		t.Fatalf("Expected %s and got %s", ErrAlreadyExists, err)
+	}
+	if _, err := vm.v.ApproveGrant(ctx, owner.Id, invitee.Id); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("Expected %s and got %s", ErrUnauthorized, err)
+	}
+	if _, err := team.GetVaultForUser(ctx, vm.v.Id, invitee); err == nil {
+		t.Fatal("The pending member can get the vault")
+	}
+	if _, err := vm.v.ApproveGrant(ctx, "second admin", invitee.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := team.GetVaultForUser(ctx, vm.v.Id, invitee); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.v.ApproveGrant(ctx, "second admin", invitee.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", ErrDoesntExist, err)
+	}
+	if vgs, err = vm.v.GetGrants(ctx); err != nil || len(vgs) != 0 {
+		t.Fatalf("Expected no pending grants: %v %#v", err, vgs)
+	}
+}
+
+func TestVaultGrantReject(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(owner, team)
+	if err := vm.v.SetApprovalRequired(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	invitee := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, invitee.Email); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.v.RequestGrants(ctx, owner.Id, map[string][]byte{invitee.Id: sealVaultKey(vm.v, vm.priv)}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.RejectGrant(ctx, invitee.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.v.ApproveGrant(ctx, "second admin", invitee.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", ErrDoesntExist, err)
+	}
+}
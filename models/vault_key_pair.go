@@ -51,20 +51,46 @@ func (vkp VaultKeyPair) checkKeyIdsMatch(ppl []string) error {
 	return nil
 }
 
+// keyError tells the client which of the keys it sent is malformed
+func keyError(field, reason string, inner error) error {
+	errs := util.NewErrorFields().(*util.Error)
+	errs.SetFieldError(field, reason)
+	return errs.SetErrorOrCamo(inner)
+}
+
+// checkPublicKeyPack makes sure the pack is a signing key followed by the box key signed with it
+func checkPublicKeyPack(field string, pubPack []byte) error {
+	if len(pubPack) != publicKeyPackSize {
+		return keyError(field, "invalid length", ErrInvalidPublicKey)
+	}
+	if _, err := verifyAndUnpack(pubPack, pubPack[ed25519.PublicKeySize:]); err != nil {
+		return keyError(field, "invalid signature", ErrInvalidSignature)
+	}
+	return nil
+}
+
+// checkWrappedKey makes sure a sealed private key pack has the right size and is signed by the owner of signerPack
+func checkWrappedKey(field string, signerPack, key []byte) error {
+	if len(key) != privateKeyPackSize {
+		return keyError(field, "invalid length", ErrInvalidKeys)
+	}
+	if _, err := verifyAndUnpack(signerPack, key); err != nil {
+		return keyError(field, "invalid signature", ErrInvalidSignature)
+	}
+	return nil
+}
+
 func expandUserKeyPack(pack []byte) ([]byte, []byte, error) {
-	if len(pack) < publicKeyPackSize+privateKeyPackSize {
-		return nil, nil, util.NewErrorFrom(ErrInvalidKeys)
+	if len(pack) != publicKeyPackSize+privateKeyPackSize {
+		return nil, nil, keyError("key_pack", "invalid length", ErrInvalidKeys)
 	}
 	pubPack := pack[:publicKeyPackSize]
 	privPack := pack[publicKeyPackSize:]
-	spub := pubPack[:ed25519.PublicKeySize]
-	_, err := verifyAndUnpack(spub, pubPack[ed25519.PublicKeySize:])
-	if err != nil {
-		return nil, nil, util.NewErrorFrom(ErrInvalidSignature)
+	if err := checkPublicKeyPack("key_pack", pubPack); err != nil {
+		return nil, nil, err
 	}
-	_, err = verifyAndUnpack(spub, privPack)
-	if err != nil {
-		return nil, nil, util.NewErrorFrom(ErrInvalidSignature)
+	if err := checkWrappedKey("key_pack", pubPack, privPack); err != nil {
+		return nil, nil, err
 	}
 	return pubPack, privPack, nil
 }
@@ -89,16 +115,18 @@ func verifyAndUnpack(pubPack []byte, signedData []byte) ([]byte, error) {
 func (vkp VaultKeyPair) verifyAndUnpack(pubPack []byte) (VaultKeyPair, error) {
 	unpacked := VaultKeyPair{Keys: map[string][]byte{}}
 	if data, err := verifyAndUnpack(pubPack, vkp.PublicKey); err != nil {
-		return unpacked, err
+		return unpacked, keyError("vault_public_key", "invalid signature", ErrInvalidSignature)
 	} else {
 		unpacked.PublicKey = data
 	}
+	if err := checkPublicKeyPack("vault_public_key", unpacked.PublicKey); err != nil {
+		return unpacked, err
+	}
 	for k, v := range vkp.Keys {
-		if _, err := verifyAndUnpack(unpacked.PublicKey, v); err != nil {
+		if err := checkWrappedKey("vault_key", unpacked.PublicKey, v); err != nil {
 			return unpacked, err
-		} else {
-			unpacked.Keys[k] = v
 		}
+		unpacked.Keys[k] = v
 	}
 	return unpacked, nil
 }
@@ -146,3 +146,42 @@ func TestSigningAndVerificationOfVaultKeyPairs(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestMalformedKeyPacksAreRejected(t *testing.T) {
+	pub, priv, fullPack := generateNewKeys()
+	if _, _, err := expandUserKeyPack(append(fullPack, 0)); !util.CheckFieldErr(err, "key_pack", "invalid length") {
+		t.Fatalf("Expected a length error and got %s", err)
+	}
+	broken := append([]byte{}, fullPack...)
+	broken[ed25519.PublicKeySize+1] ^= 1
+	if _, _, err := expandUserKeyPack(broken); !util.CheckFieldErr(err, "key_pack", "invalid signature") || !util.CheckErr(err, ErrInvalidSignature) {
+		t.Fatalf("Expected a signature error and got %s", err)
+	}
+	broken = append([]byte{}, fullPack...)
+	broken[len(broken)-1] ^= 1
+	if _, _, err := expandUserKeyPack(broken); !util.CheckFieldErr(err, "key_pack", "invalid signature") {
+		t.Fatalf("Expected a signature error and got %s", err)
+	}
+	vkp := getDummyVaultKeyPair(priv, "random1")
+	vkp.Keys["random1"] = vkp.Keys["random1"][1:]
+	if _, err := vkp.verifyAndUnpack(pub); !util.CheckFieldErr(err, "vault_key", "invalid length") || !util.CheckErr(err, ErrInvalidKeys) {
+		t.Fatalf("Expected a length error and got %s", err)
+	}
+	vkp = getDummyVaultKeyPair(priv, "random1")
+	vkp.Keys["random1"][ed25519.SignatureSize] ^= 1
+	if _, err := vkp.verifyAndUnpack(pub); !util.CheckFieldErr(err, "vault_key", "invalid signature") {
+		t.Fatalf("Expected a signature error and got %s", err)
+	}
+	//A vault public key signed by the user but not by itself
+	vkp = getDummyVaultKeyPair(priv, "random1")
+	inner := append([]byte{}, vkp.PublicKey[ed25519.SignatureSize:]...)
+	inner[len(inner)-1] ^= 1
+	vkp.PublicKey = signAndPack(priv, inner)
+	if _, err := vkp.verifyAndUnpack(pub); !util.CheckFieldErr(err, "vault_public_key", "invalid signature") {
+		t.Fatalf("Expected a signature error and got %s", err)
+	}
+	vkp.PublicKey = signAndPack(priv, inner[1:])
+	if _, err := vkp.verifyAndUnpack(pub); !util.CheckFieldErr(err, "vault_public_key", "invalid length") {
+		t.Fatalf("Expected a length error and got %s", err)
+	}
+}
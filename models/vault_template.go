@@ -0,0 +1,124 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	VAULT_TEMPLATE_NAME_MAX_LENGTH = 50
+	VAULT_TEMPLATE_MAX_SIZE        = 16 * 1024
+	VAULT_TEMPLATES_MAX            = 50
+)
+
+// VaultTemplate is a layout of the fields of the new secrets of a vault. The data is only read by the clients
+type VaultTemplate struct {
+	Team      string    `scaneo:"pk" json:"-"`
+	Vault     string    `scaneo:"pk" json:"vault"`
+	Id        string    `scaneo:"pk" json:"id"`
+	Name      string    `json:"name"`
+	Data      []byte    `json:"data"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (vt *VaultTemplate) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	vt.Name = strings.TrimSpace(vt.Name)
+	if len(vt.Name) == 0 {
+		errs.SetFieldError("name", "missing")
+	} else if len(vt.Name) > VAULT_TEMPLATE_NAME_MAX_LENGTH {
+		errs.SetFieldError("name", "too long")
+	}
+	if len(vt.Data) == 0 {
+		errs.SetFieldError("data", "missing")
+	} else if len(vt.Data) > VAULT_TEMPLATE_MAX_SIZE {
+		errs.SetFieldError("data", "too large")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+func duplicateTemplateName() error {
+	errs := util.NewErrorFields().(*util.Error)
+	errs.SetFieldError("name", "duplicate")
+	return errs.SetErrorOrCamo(ErrAlreadyExists)
+}
+
+// AddTemplate stores a new template in the vault. Names are unique in the vault regardless of the case
+func (v Vault) AddTemplate(ctx context.Context, creator string, vt *VaultTemplate) error {
+	vt.Team, vt.Vault, vt.Id, vt.CreatedBy = v.Team, v.Id, util.GenerateRandomToken(8), creator
+	if err := vt.validate(); err != nil {
+		return err
+	}
+	vt.CreatedAt = time.Now().UTC()
+	vt.UpdatedAt = vt.CreatedAt
+	return doTx(ctx, func(tx *sql.Tx) error {
+		var count int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM "vault_template" WHERE "team" = $1 AND "vault" = $2`, v.Team, v.Id).Scan(&count)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if count >= VAULT_TEMPLATES_MAX {
+			return util.NewErrorf("Vaults can't have more than %d templates", VAULT_TEMPLATES_MAX)
+		}
+		_, err = vt.dbInsert(tx)
+		switch {
+		case IsDuplicateErr(err):
+			return duplicateTemplateName()
+		case isErrOrPanic(err):
+			return util.NewErrorFrom(err)
+		}
+		return nil
+	})
+}
+
+// GetTemplates returns the templates of the vault sorted by name
+func (v Vault) GetTemplates(ctx context.Context) ([]*VaultTemplate, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := getReadDB(ctx).QueryContext(ctx, `SELECT `+selectVaultTemplateFields+` FROM "vault_template" WHERE "team" = $1 AND "vault" = $2 ORDER BY LOWER("name")`, v.Team, v.Id)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	vts, err := scanVaultTemplates(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return vts, nil
+}
+
+// UpdateTemplate replaces the name and the data of a template
+func (v Vault) UpdateTemplate(ctx context.Context, vt *VaultTemplate) error {
+	vt.Team, vt.Vault = v.Team, v.Id
+	if err := vt.validate(); err != nil {
+		return err
+	}
+	return doTx(ctx, func(tx *sql.Tx) error {
+		prev := &VaultTemplate{Team: v.Team, Vault: v.Id, Id: vt.Id}
+		if err := prev.dbFind(tx); isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		vt.CreatedBy, vt.CreatedAt, vt.UpdatedAt = prev.CreatedBy, prev.CreatedAt, time.Now().UTC()
+		_, err := vt.dbUpdate(tx)
+		switch {
+		case IsDuplicateErr(err):
+			return duplicateTemplateName()
+		case isErrOrPanic(err):
+			return util.NewErrorFrom(err)
+		}
+		return nil
+	})
+}
+
+func (v Vault) DeleteTemplate(ctx context.Context, tid string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		return treatUpdateErr((&VaultTemplate{Team: v.Team, Vault: v.Id, Id: tid}).dbDelete(tx))
+	})
+}
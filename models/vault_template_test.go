@@ -0,0 +1,45 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestVaultTemplates(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := getFirstVault(owner, team)
+	big := &VaultTemplate{Name: "big", Data: []byte(strings.Repeat("a", VAULT_TEMPLATE_MAX_SIZE+1))}
+	if err := vm.v.AddTemplate(ctx, owner.Id, big); !util.CheckFieldErr(err, "data", "too large") {
+		t.Fatalf("Expected the data to be too large and got %v", err)
+	}
+	vt := &VaultTemplate{Name: " Card ", Data: []byte("opaque")}
+	if err := vm.v.AddTemplate(ctx, owner.Id, vt); err != nil {
+		t.Fatal(err)
+	}
+	if vt.Name != "Card" {
+		t.Errorf("Expected the name to be trimmed and got '%s'", vt.Name)
+	}
+	if err := vm.v.AddTemplate(ctx, owner.Id, &VaultTemplate{Name: "CARD", Data: []byte("x")}); !util.CheckFieldErr(err, "name", "duplicate") {
+		t.Fatalf("Expected a duplicated name and got %v", err)
+	}
+	other := createVaultMock(owner, team)
+	if err := other.v.AddTemplate(ctx, owner.Id, &VaultTemplate{Name: "Card", Data: []byte("x")}); err != nil {
+		t.Fatalf("Names should only be unique in their vault: %v", err)
+	}
+	if err := other.v.DeleteTemplate(ctx, vt.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Templates should only be deleted from their vault: %v", err)
+	}
+	if err := vm.v.DeleteTemplate(ctx, vt.Id); err != nil {
+		t.Fatal(err)
+	}
+	vts, err := vm.v.GetTemplates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vts) != 0 {
+		t.Fatalf("Expected no templates and got %d", len(vts))
+	}
+}
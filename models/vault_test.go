@@ -98,3 +98,40 @@ func TestAddSecretList(t *testing.T) {
 		t.Fatalf("Mismatch in the vault (%d) and secret vault (%d) version", vm.v.Version, sl[1].VaultVersion)
 	}
 }
+
+func TestAddUsersInOneBatch(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(owner, team)
+	if vm.v.Version != 1 {
+		t.Fatalf("Expected a new vault to have version 1 and got %d", vm.v.Version)
+	}
+	uk := map[string][]byte{}
+	for i := 0; i < 3; i++ {
+		invitee := getDummyUser()
+		if _, err := team.AddOrInviteUserByEmail(ctx, owner, invitee.Email); err != nil {
+			t.Fatal(err)
+		}
+		uk[invitee.Id] = sealVaultKey(vm.v, vm.priv)
+	}
+	if err := vm.v.AddUsers(ctx, uk); err != nil {
+		t.Fatal(err)
+	}
+	uids, err := vm.v.GetUserIds(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uids) != 4 {
+		t.Fatalf("Expected 4 users in the vault and got %d", len(uids))
+	}
+	v, err := team.GetVaultForUser(ctx, vm.v.Id, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != 2 {
+		t.Errorf("Expected adding the users to bump the version once and got %d", v.Version)
+	}
+	if err := vm.v.AddUsers(ctx, uk); !util.CheckErr(err, ErrAlreadyExists) {
+		t.Errorf("Expected adding the users again to fail with %s and got %v", ErrAlreadyExists, err)
+	}
+}
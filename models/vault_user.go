@@ -2,18 +2,33 @@ package models
 
 import (
 	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
 )
 
 type vaultUser struct {
 	Team      string `scaneo:"pk"`
 	Vault     string `scaneo:"pk"`
 	User      string `scaneo:"pk"`
-	Key       []byte
+	Key       Sealed
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	//ExpiresAt is when the member stops getting the key of the vault
+	ExpiresAt      pq.NullTime
+	ExpiryNotified bool
+}
+
+// activeVaultUser filters out the members whose access has expired but haven't been removed by the expiry job yet
+const activeVaultUser = `("vault_user"."expires_at" IS NULL OR "vault_user"."expires_at" > NOW())`
+
+// vaultKeyRow binds the sealed key of a member of a vault to the member
+func vaultKeyRow(team, vault, user string) []byte {
+	return sealedRow("vault_user", "key", team, vault, user)
 }
 
 func (tu *vaultUser) insert(tx *sql.Tx) error {
@@ -23,7 +38,13 @@ func (tu *vaultUser) insert(tx *sql.Tx) error {
 	now := time.Now().UTC()
 	tu.CreatedAt = now
 	tu.UpdatedAt = now
-	_, err := tu.dbInsert(tx)
+	var err error
+	if serr := writeSealed(&tu.Key, vaultKeyRow(tu.Team, tu.Vault, tu.User), func() error {
+		_, err = tu.dbInsert(tx)
+		return nil
+	}); serr != nil {
+		return serr
+	}
 	if IsDuplicateErr(err) {
 		return util.NewErrorf("User %s is already in vault", tu.User)
 	}
@@ -33,6 +54,59 @@ func (tu *vaultUser) insert(tx *sql.Tx) error {
 	return nil
 }
 
+// vaultUserBatch is how many keys go in each insert. Postgres allows up to 65535 binds in a statement
+const vaultUserBatch = 1000
+
+// insertVaultUsers stores the keys of a vault with one multi row insert per batch instead of one insert per user.
+// A zero expiresAt gives access until the member is removed
+func insertVaultUsers(tx *sql.Tx, team, vault string, keys map[string][]byte, expiresAt time.Time) error {
+	uids := make([]string, 0, len(keys))
+	for uid := range keys {
+		uids = append(uids, uid)
+	}
+	//Always insert in the same order so concurrent inserts lock the rows in the same order
+	sort.Strings(uids)
+	if err := checkUserKeysNotRevoked(tx, uids); err != nil {
+		return err
+	}
+	if err := checkNoAuditors(tx, team, uids); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	vus := make([]*vaultUser, len(uids))
+	for i, uid := range uids {
+		vus[i] = &vaultUser{Team: team, Vault: vault, User: uid, Key: keys[uid], CreatedAt: now, UpdatedAt: now, ExpiresAt: nullTime(expiresAt)}
+		if err := vus[i].validate(); err != nil {
+			return err
+		}
+		//The rows are only used for the insert so the plain keys don't have to be put back
+		if _, err := vus[i].Key.seal(vaultKeyRow(team, vault, uid)); err != nil {
+			return err
+		}
+	}
+	for start := 0; start < len(vus); start += vaultUserBatch {
+		end := start + vaultUserBatch
+		if end > len(vus) {
+			end = len(vus)
+		}
+		binds := make([]string, 0, end-start)
+		values := make([]interface{}, 0, (end-start)*8)
+		for i, vu := range vus[start:end] {
+			p := i * 8
+			binds = append(binds, fmt.Sprintf("($%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d)", p+1, p+2, p+3, p+4, p+5, p+6, p+7, p+8))
+			values = append(values, vu.Team, vu.Vault, vu.User, vu.Key, vu.CreatedAt, vu.UpdatedAt, vu.ExpiresAt, vu.ExpiryNotified)
+		}
+		_, err := tx.Exec(`INSERT INTO "vault_user" `+insertVaultUserFields+` VALUES `+strings.Join(binds, ","), values...)
+		if IsDuplicateErr(err) {
+			return util.NewErrorFrom(ErrAlreadyExists)
+		}
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+	}
+	return nil
+}
+
 func (v vaultUser) validate() error {
 	errs := util.NewErrorFields().(*util.Error)
 	if len(v.Team) == 0 {
@@ -47,5 +121,8 @@ func (v vaultUser) validate() error {
 	if len(v.Key) != privateKeyPackSize {
 		errs.SetFieldError("vaultuser_key", "invalid")
 	}
+	if v.ExpiresAt.Valid && !v.ExpiresAt.Time.After(time.Now()) {
+		errs.SetFieldError("vaultuser_expires_at", "invalid")
+	}
 	return errs.SetErrorOrCamo(ErrInvalidAttributes)
 }
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+type Webhook struct {
+	Team      string    `scaneo:"pk" json:"team"`
+	Id        string    `scaneo:"pk" json:"id"`
+	Url       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (w *Webhook) insert(tx *sql.Tx) error {
+	w.Id = util.GenerateRandomToken(12)
+	w.Secret = util.GenerateRandomToken(32)
+	if err := w.validate(); err != nil {
+		return err
+	}
+	w.CreatedAt = time.Now().UTC()
+	w.UpdatedAt = w.CreatedAt
+	_, err := w.dbInsert(tx)
+	switch {
+	case IsDuplicateErr(err):
+		return util.NewErrorFrom(ErrAlreadyExists)
+	case isErrOrPanic(err):
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+func (w Webhook) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(w.Team) == 0 {
+		errs.SetFieldError("webhook_team", "missing")
+	}
+	u, err := url.Parse(w.Url)
+	switch {
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0:
+		errs.SetFieldError("webhook_url", "invalid")
+	case isInternalHost(u.Hostname()):
+		errs.SetFieldError("webhook_url", "internal")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+var internalNetworks = func() []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range []string{"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// IsInternalIP tells if the address is in the network of the server, like loopback, link local or private addresses.
+// Webhooks and homeservers can't point there so team admins can't use them to reach internal services
+func IsInternalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range internalNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isInternalHost only catches the literal addresses and localhost. Names are checked when the delivery connects
+func isInternalHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && IsInternalIP(ip)
+}
+
+func (t *Team) CreateWebhook(ctx context.Context, admin *User, hookUrl string) (w *Webhook, err error) {
+	return w, doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		w = &Webhook{Team: t.Id, Url: hookUrl}
+		return w.insert(tx)
+	})
+}
+
+func (t *Team) GetWebhooks(ctx context.Context) ([]*Webhook, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := GetDB(ctx).QueryContext(ctx, `SELECT `+selectWebhookFields+` FROM "webhook" WHERE "team" = $1 ORDER BY "created_at"`, t.Id)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	hooks, err := scanWebhooks(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return hooks, nil
+}
+
+func (t *Team) GetWebhook(ctx context.Context, wid string) (*Webhook, error) {
+	return FindWebhook(ctx, t.Id, wid)
+}
+
+func FindWebhook(ctx context.Context, team, wid string) (w *Webhook, err error) {
+	w = &Webhook{Team: team, Id: wid}
+	err = doTx(ctx, func(tx *sql.Tx) error {
+		return w.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return w, nil
+}
+
+func (t *Team) DeleteWebhook(ctx context.Context, admin *User, wid string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		w := &Webhook{Team: t.Id, Id: wid}
+		return treatUpdateErr(w.dbDelete(tx))
+	})
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	WEBHOOK_DELIVERY_PENDING   = 0
+	WEBHOOK_DELIVERY_DELIVERED = 1
+	WEBHOOK_DELIVERY_DEAD      = 2
+)
+
+type WebhookDelivery struct {
+	Id          string    `scaneo:"pk" json:"id"`
+	Team        string    `json:"team"`
+	Webhook     string    `json:"webhook"`
+	Payload     []byte    `json:"-"`
+	Status      int       `json:"status"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (d *WebhookDelivery) insert(tx *sql.Tx) error {
+	d.Id = util.GenerateRandomToken(16)
+	d.Status = WEBHOOK_DELIVERY_PENDING
+	d.CreatedAt = time.Now().UTC()
+	d.UpdatedAt = d.CreatedAt
+	d.NextAttempt = d.CreatedAt
+	_, err := d.dbInsert(tx)
+	switch {
+	case IsDuplicateErr(err):
+		return util.NewErrorFrom(ErrAlreadyExists)
+	case isErrOrPanic(err):
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+func (d *WebhookDelivery) update(tx *sql.Tx) error {
+	d.UpdatedAt = time.Now().UTC()
+	res, err := d.dbUpdate(tx)
+	return treatUpdateErr(res, err)
+}
+
+// EnqueueWebhookDeliveries queues the payload for every webhook registered in the team
+func EnqueueWebhookDeliveries(ctx context.Context, team string, payload []byte) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+selectWebhookFields+` FROM "webhook" WHERE "team" = $1`, team)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		hooks, err := scanWebhooks(rows)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		for _, w := range hooks {
+			d := &WebhookDelivery{Team: w.Team, Webhook: w.Id, Payload: payload}
+			if err := d.insert(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindDueWebhookDeliveries returns the pending deliveries whose next attempt is due
+func FindDueWebhookDeliveries(ctx context.Context, limit int) ([]*WebhookDelivery, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := GetDB(ctx).QueryContext(ctx, `SELECT `+selectWebhookDeliveryFields+` FROM "webhook_delivery" WHERE "status" = $1 AND "next_attempt" <= $2 ORDER BY "next_attempt" LIMIT $3`, WEBHOOK_DELIVERY_PENDING, time.Now().UTC(), limit)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	ds, err := scanWebhookDeliverys(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return ds, nil
+}
+
+func (d *WebhookDelivery) MarkDelivered(ctx context.Context) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		d.Status = WEBHOOK_DELIVERY_DELIVERED
+		d.Attempts += 1
+		d.LastError = ""
+		return d.update(tx)
+	})
+}
+
+// MarkFailed records a failed attempt. If next is zero the delivery is parked as dead
+func (d *WebhookDelivery) MarkFailed(ctx context.Context, reason string, next time.Time) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		d.Attempts += 1
+		d.LastError = reason
+		if next.IsZero() {
+			d.Status = WEBHOOK_DELIVERY_DEAD
+		} else {
+			d.NextAttempt = next.UTC()
+		}
+		return d.update(tx)
+	})
+}
+
+func (w *Webhook) GetDeliveries(ctx context.Context, status int) ([]*WebhookDelivery, error) {
+	ctx, cancel := queryCtx(ctx)
+	defer cancel()
+	rows, err := GetDB(ctx).QueryContext(ctx, `SELECT `+selectWebhookDeliveryFields+` FROM "webhook_delivery" WHERE "team" = $1 AND "webhook" = $2 AND "status" = $3 ORDER BY "created_at" DESC`, w.Team, w.Id, status)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	ds, err := scanWebhookDeliverys(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return ds, nil
+}
+
+// Redeliver puts a delivery back in the queue to be sent as soon as possible
+func (w *Webhook) Redeliver(ctx context.Context, did string) (d *WebhookDelivery, err error) {
+	return d, doTx(ctx, func(tx *sql.Tx) error {
+		d = &WebhookDelivery{Id: did}
+		err := d.dbFind(tx)
+		if isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		}
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if d.Team != w.Team || d.Webhook != w.Id {
+			return util.NewErrorFrom(ErrDoesntExist)
+		}
+		d.Status = WEBHOOK_DELIVERY_PENDING
+		d.Attempts = 0
+		d.NextAttempt = time.Now().UTC()
+		return d.update(tx)
+	})
+}
+
+// PurgeWebhookDeliveries removes the delivered and dead deliveries last attempted before the given time. Pending
+// deliveries are kept until they are sent or given up. With dryRun nothing is removed
+func PurgeWebhookDeliveries(ctx context.Context, before time.Time, dryRun bool) (purged AffectedRows, err error) {
+	return purged, doDryRunTx(ctx, dryRun, func(tx *sql.Tx) error {
+		purged, err = queryAffected(tx, "webhook_delivery", `DELETE FROM "webhook_delivery" WHERE "status" <> $1 AND "updated_at" < $2 RETURNING "id"`, WEBHOOK_DELIVERY_PENDING, before)
+		return err
+	})
+}
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	LOG_LEVEL_INFO  = "info"
+	LOG_LEVEL_ERROR = "error"
+)
+
+var errorTag = []byte("[ERROR]")
+
+// levelWriter drops every log line that is not tagged as [ERROR] when only errors are wanted
+type levelWriter struct {
+	onlyErrors int32
+	out        io.Writer
+}
+
+func (lw *levelWriter) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&lw.onlyErrors) == 1 && !bytes.Contains(p, errorTag) {
+		return len(p), nil
+	}
+	return lw.out.Write(p)
+}
+
+var logWriter = &levelWriter{out: os.Stderr}
+
+func init() {
+	log.SetOutput(logWriter)
+}
+
+func ValidLogLevel(level string) bool {
+	return level == LOG_LEVEL_INFO || level == LOG_LEVEL_ERROR
+}
+
+// SetLogLevel changes which lines the standard logger writes. It is safe to call while logging
+func SetLogLevel(level string) error {
+	if !ValidLogLevel(level) {
+		return NewErrorf("Invalid log level %s", level)
+	}
+	var onlyErrors int32
+	if level == LOG_LEVEL_ERROR {
+		onlyErrors = 1
+	}
+	atomic.StoreInt32(&logWriter.onlyErrors, onlyErrors)
+	return nil
+}
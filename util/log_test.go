@@ -0,0 +1,35 @@
+package util
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogLevel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	prev := logWriter.out
+	logWriter.out = buf
+	defer func() {
+		logWriter.out = prev
+		SetLogLevel(LOG_LEVEL_INFO)
+	}()
+	if err := SetLogLevel("verbose"); err == nil {
+		t.Fatal("Expected an error for an invalid level")
+	}
+	if err := SetLogLevel(LOG_LEVEL_ERROR); err != nil {
+		t.Fatal(err)
+	}
+	log.Printf("Something happened")
+	log.Printf("[ERROR] Something failed")
+	if out := buf.String(); strings.Contains(out, "happened") || !strings.Contains(out, "failed") {
+		t.Fatalf("Unexpected log output with the error level: %s", out)
+	}
+	buf.Reset()
+	SetLogLevel(LOG_LEVEL_INFO)
+	log.Printf("Something happened")
+	if !strings.Contains(buf.String(), "happened") {
+		t.Fatalf("Expected info lines with the info level")
+	}
+}
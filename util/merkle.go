@@ -0,0 +1,145 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// Merkle trees as in RFC 6962 (certificate transparency) so logs can be checked with the usual tools
+
+// MerkleLeafHash hashes the data of a leaf with the leaf prefix so it can't be confused with an inner node
+func MerkleLeafHash(data []byte) []byte {
+	h := sha256.Sum256(append([]byte{0}, data...))
+	return h[:]
+}
+
+func merkleNodeHash(left, right []byte) []byte {
+	buf := make([]byte, 0, 1+len(left)+len(right))
+	buf = append(append(append(buf, 1), left...), right...)
+	h := sha256.Sum256(buf)
+	return h[:]
+}
+
+// merkleSplit is the largest power of two smaller than n
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// MerkleRoot is the root of the tree with the leaf hashes
+func MerkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+	k := merkleSplit(len(leaves))
+	return merkleNodeHash(MerkleRoot(leaves[:k]), MerkleRoot(leaves[k:]))
+}
+
+// MerkleInclusionProof is the audit path of the leaf at index
+func MerkleInclusionProof(index int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return [][]byte{}
+	}
+	k := merkleSplit(len(leaves))
+	if index < k {
+		return append(MerkleInclusionProof(index, leaves[:k]), MerkleRoot(leaves[k:]))
+	}
+	return append(MerkleInclusionProof(index-k, leaves[k:]), MerkleRoot(leaves[:k]))
+}
+
+// MerkleConsistencyProof proves that the tree with the first m leaves is a prefix of the tree with all of them
+func MerkleConsistencyProof(m int, leaves [][]byte) [][]byte {
+	if m <= 0 || m >= len(leaves) {
+		return [][]byte{}
+	}
+	return merkleSubproof(m, leaves, true)
+}
+
+func merkleSubproof(m int, leaves [][]byte, complete bool) [][]byte {
+	n := len(leaves)
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{MerkleRoot(leaves)}
+	}
+	k := merkleSplit(n)
+	if m <= k {
+		return append(merkleSubproof(m, leaves[:k], complete), MerkleRoot(leaves[k:]))
+	}
+	return append(merkleSubproof(m-k, leaves[k:], false), MerkleRoot(leaves[:k]))
+}
+
+// VerifyMerkleInclusion checks that the leaf hash is at index of the tree of size with the root
+func VerifyMerkleInclusion(index, size int, leaf []byte, proof [][]byte, root []byte) bool {
+	if index < 0 || index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+// VerifyMerkleConsistency checks that the tree of size first with the first root is a prefix of the one of size second
+func VerifyMerkleConsistency(first, second int, firstRoot, secondRoot []byte, proof [][]byte) bool {
+	switch {
+	case first < 0 || first > second:
+		return false
+	case first == second:
+		return len(proof) == 0 && bytes.Equal(firstRoot, secondRoot)
+	case first == 0:
+		return len(proof) == 0
+	case len(proof) == 0:
+		return false
+	}
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = merkleNodeHash(c, fr)
+			sr = merkleNodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = merkleNodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, firstRoot) && bytes.Equal(sr, secondRoot)
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func getMerkleLeaves(n int) [][]byte {
+	leaves := [][]byte{}
+	for i := 0; i < n; i++ {
+		leaves = append(leaves, MerkleLeafHash([]byte(fmt.Sprintf("leaf %d", i))))
+	}
+	return leaves
+}
+
+func TestMerkleRootVectors(t *testing.T) {
+	//Empty tree and single empty leaf from RFC 6962
+	if hex.EncodeToString(MerkleRoot(nil)) != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("Invalid root of the empty tree")
+	}
+	if hex.EncodeToString(MerkleLeafHash(nil)) != "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d" {
+		t.Errorf("Invalid hash of the empty leaf")
+	}
+}
+
+func TestMerkleInclusion(t *testing.T) {
+	for size := 1; size <= 17; size++ {
+		leaves := getMerkleLeaves(size)
+		root := MerkleRoot(leaves)
+		for i := 0; i < size; i++ {
+			proof := MerkleInclusionProof(i, leaves)
+			if !VerifyMerkleInclusion(i, size, leaves[i], proof, root) {
+				t.Fatalf("Inclusion of %d in %d doesn't verify", i, size)
+			}
+			if size > 1 && VerifyMerkleInclusion((i+1)%size, size, leaves[i], proof, root) {
+				t.Fatalf("Inclusion of %d in %d verifies at another index", i, size)
+			}
+		}
+	}
+	leaves := getMerkleLeaves(5)
+	if VerifyMerkleInclusion(2, 5, MerkleLeafHash([]byte("other")), MerkleInclusionProof(2, leaves), MerkleRoot(leaves)) {
+		t.Errorf("Another leaf verifies")
+	}
+}
+
+func TestMerkleConsistency(t *testing.T) {
+	for second := 1; second <= 17; second++ {
+		leaves := getMerkleLeaves(second)
+		root := MerkleRoot(leaves)
+		for first := 1; first <= second; first++ {
+			firstRoot := MerkleRoot(leaves[:first])
+			proof := MerkleConsistencyProof(first, leaves)
+			if !VerifyMerkleConsistency(first, second, firstRoot, root, proof) {
+				t.Fatalf("Consistency of %d with %d doesn't verify", first, second)
+			}
+		}
+	}
+	leaves := getMerkleLeaves(7)
+	rewritten := append([][]byte{}, leaves...)
+	rewritten[1] = MerkleLeafHash([]byte("swapped"))
+	if VerifyMerkleConsistency(3, 7, MerkleRoot(leaves[:3]), MerkleRoot(rewritten), MerkleConsistencyProof(3, rewritten)) {
+		t.Errorf("A rewritten log is consistent")
+	}
+	if !bytes.Equal(MerkleRoot(leaves[:1]), leaves[0]) {
+		t.Errorf("The root of one leaf has to be the leaf")
+	}
+}
@@ -15,6 +15,11 @@ func GenerateRandomToken(length int) string {
 	return base64.RawURLEncoding.EncodeToString(data)[:length]
 }
 
+// GenerateSecretToken returns 256 random bits in url safe base64. Used for the tokens that are handed to the users
+func GenerateSecretToken() string {
+	return base64.RawURLEncoding.EncodeToString(GenerateRandomByteArray(32))
+}
+
 func GenerateRandomByteArray(length int) []byte {
 	data := make([]byte, length)
 	_, err := rand.Read(data)
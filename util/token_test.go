@@ -2,6 +2,7 @@ package util
 
 import (
 	"bytes"
+	"encoding/base64"
 	"testing"
 )
 
@@ -15,6 +16,16 @@ func TestGenerateRandomToken(t *testing.T) {
 	}
 }
 
+func TestGenerateSecretToken(t *testing.T) {
+	tok := GenerateSecretToken()
+	if data, err := base64.RawURLEncoding.DecodeString(tok); err != nil || len(data) != 32 {
+		t.Errorf("Expected 256 bits in url safe base64 and got %s", tok)
+	}
+	if GenerateSecretToken() == tok {
+		t.Error("Tokens are the same!!")
+	}
+}
+
 func TestWriteStringtoWriter(t *testing.T) {
 	source := GenerateRandomToken(128)
 	b := bytes.NewBuffer(nil)
@@ -3,12 +3,24 @@ package util
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/keydotcat/keycatd/static"
 )
 
+// MIN_CLIENT_VERSION is the oldest client release that can talk to this server
+const MIN_CLIENT_VERSION = "0.1.0"
+
+// MIN_CLIENT_VERSIONS are the oldest releases of each type of client that can talk to this server. Clients send
+// their type and version in the X-Keycat-Client header
+var MIN_CLIENT_VERSIONS = map[string]string{
+	"web":       MIN_CLIENT_VERSION,
+	"extension": MIN_CLIENT_VERSION,
+	"cli":       MIN_CLIENT_VERSION,
+}
+
 type VersionTime time.Time
 
 const isotime = "2006-01-02 15:04:05 -0700"
@@ -62,6 +74,20 @@ func GetServerVersion() string {
 	return strings.TrimSpace(string(data))
 }
 
+// GetBuildDate returns when the static assets were generated or the date of the commit if it's not known
+func GetBuildDate() time.Time {
+	data, err := static.Asset("version/build_date")
+	if err == nil {
+		if t, err := time.Parse(isotime, strings.TrimSpace(string(data))); err == nil {
+			return t.UTC()
+		}
+	}
+	if currentVersion.Date == nil {
+		return time.Time{}
+	}
+	return time.Time(*currentVersion.Date).UTC()
+}
+
 func GetWebVersion() string {
 	data, err := static.Asset("version/current.web")
 	if err != nil {
@@ -77,3 +103,49 @@ func GetVersionInfo(cid string) (*VersionEntry, error) {
 	}
 	return v, nil
 }
+
+// CompareVersions compares two dotted versions like 1.2.10 number by number. A leading v and anything after a - or +
+// are ignored. Missing numbers count as 0
+func CompareVersions(a, b string) (int, error) {
+	na, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	nb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(na) || i < len(nb); i++ {
+		var va, vb int
+		if i < len(na) {
+			va = na[i]
+		}
+		if i < len(nb) {
+			vb = nb[i]
+		}
+		switch {
+		case va < vb:
+			return -1, nil
+		case va > vb:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func parseVersion(v string) ([]int, error) {
+	clean := strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(clean, "-+"); i >= 0 {
+		clean = clean[:i]
+	}
+	parts := strings.Split(clean, ".")
+	nums := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, NewErrorf("Invalid version %s", v)
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
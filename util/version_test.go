@@ -0,0 +1,28 @@
+package util
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	checks := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.10", "1.2.9", 1},
+		{"v1.2", "1.2.0", 0},
+		{"0.9.9", "1.0", -1},
+		{"2.0.0-beta.1", "2.0.0", 0},
+		{"1.3.0+build5", "1.10.0", -1},
+	}
+	for _, c := range checks {
+		cmp, err := CompareVersions(c.a, c.b)
+		if err != nil || cmp != c.cmp {
+			t.Errorf("%s vs %s: expected %d and got %d %v", c.a, c.b, c.cmp, cmp, err)
+		}
+	}
+	for _, bad := range []string{"", "1..2", "1.x", "-1"} {
+		if _, err := CompareVersions(bad, "1.0"); err == nil {
+			t.Errorf("Expected %q to be an invalid version", bad)
+		}
+	}
+}